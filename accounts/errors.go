@@ -65,3 +65,7 @@ func NewAuthNeededError(needed string) error {
 func (err *AuthNeededError) Error() string {
 	return fmt.Sprintf("authentication needed: %s", err.Needed)
 }
+
+// ErrSignTimeout is returned if a remote signer did not answer a signing request
+// in time. Sealers skip the slot instead of waiting for it.
+var ErrSignTimeout = errors.New("signing request timed out")
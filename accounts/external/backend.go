@@ -16,254 +16,199 @@
 
 package external
 
-//import (
-//	"fmt"
-//	"math/big"
-//	"sync"
-//
-//	"github.com/amazechain/amc/accounts"
-//	"github.com/amazechain/amc/common"
-//	types2 "github.com/amazechain/amc/common/types"
-//	"github.com/amazechain/amc/internal/avm/common/hexutil"
-//	"github.com/amazechain/amc/internal/avm/types"
-//	"github.com/amazechain/amc/log"
-//	event "github.com/amazechain/amc/modules/event/v2"
-//	"github.com/amazechain/amc/rpc"
-//	"github.com/amazechain/amc/signer/core/apitypes"
-//)
-//
-//type ExternalBackend struct {
-//	signers []accounts.Wallet
-//}
-//
-//func (eb *ExternalBackend) Wallets() []accounts.Wallet {
-//	return eb.signers
-//}
-//
-//func NewExternalBackend(endpoint string) (*ExternalBackend, error) {
-//	signer, err := NewExternalSigner(endpoint)
-//	if err != nil {
-//		return nil, err
-//	}
-//	return &ExternalBackend{
-//		signers: []accounts.Wallet{signer},
-//	}, nil
-//}
-//
-//func (eb *ExternalBackend) Subscribe(sink chan<- accounts.WalletEvent) event.Subscription {
-//	return event.NewSubscription(func(quit <-chan struct{}) error {
-//		<-quit
-//		return nil
-//	})
-//}
-//
-//// ExternalSigner provides an API to interact with an external signer (clef)
-//// It proxies request to the external signer while forwarding relevant
-//// request headers
-//type ExternalSigner struct {
-//	client   *rpc.Client
-//	endpoint string
-//	status   string
-//	cacheMu  sync.RWMutex
-//	cache    []accounts.Account
-//}
-//
-//func NewExternalSigner(endpoint string) (*ExternalSigner, error) {
-//	client, err := rpc.Dial(endpoint)
-//	if err != nil {
-//		return nil, err
-//	}
-//	extsigner := &ExternalSigner{
-//		client:   client,
-//		endpoint: endpoint,
-//	}
-//	// Check if reachable
-//	version, err := extsigner.pingVersion()
-//	if err != nil {
-//		return nil, err
-//	}
-//	extsigner.status = fmt.Sprintf("ok [version=%v]", version)
-//	return extsigner, nil
-//}
-//
-//func (api *ExternalSigner) URL() accounts.URL {
-//	return accounts.URL{
-//		Scheme: "extapi",
-//		Path:   api.endpoint,
-//	}
-//}
-//
-//func (api *ExternalSigner) Status() (string, error) {
-//	return api.status, nil
-//}
-//
-//func (api *ExternalSigner) Open(passphrase string) error {
-//	return fmt.Errorf("operation not supported on external signers")
-//}
-//
-//func (api *ExternalSigner) Close() error {
-//	return fmt.Errorf("operation not supported on external signers")
-//}
-//
-//func (api *ExternalSigner) Accounts() []accounts.Account {
-//	var accnts []accounts.Account
-//	res, err := api.listAccounts()
-//	if err != nil {
-//		log.Error("account listing failed", "error", err)
-//		return accnts
-//	}
-//	for _, addr := range res {
-//		accnts = append(accnts, accounts.Account{
-//			URL: accounts.URL{
-//				Scheme: "extapi",
-//				Path:   api.endpoint,
-//			},
-//			Address: addr,
-//		})
-//	}
-//	api.cacheMu.Lock()
-//	api.cache = accnts
-//	api.cacheMu.Unlock()
-//	return accnts
-//}
-//
-//func (api *ExternalSigner) Contains(account accounts.Account) bool {
-//	api.cacheMu.RLock()
-//	defer api.cacheMu.RUnlock()
-//	if api.cache == nil {
-//		// If we haven't already fetched the accounts, it's time to do so now
-//		api.cacheMu.RUnlock()
-//		api.Accounts()
-//		api.cacheMu.RLock()
-//	}
-//	for _, a := range api.cache {
-//		if a.Address == account.Address && (account.URL == (accounts.URL{}) || account.URL == api.URL()) {
-//			return true
-//		}
-//	}
-//	return false
-//}
-//
-//func (api *ExternalSigner) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
-//	return accounts.Account{}, fmt.Errorf("operation not supported on external signers")
-//}
-//
-//func (api *ExternalSigner) SelfDerive(bases []accounts.DerivationPath, chain common.ChainStateReader) {
-//	log.Error("operation SelfDerive not supported on external signers")
-//}
-//
-//// SignData signs keccak256(data). The mimetype parameter describes the type of data being signed
-//func (api *ExternalSigner) SignData(account accounts.Account, mimeType string, data []byte) ([]byte, error) {
-//	var res hexutil.Bytes
-//	var signAddress = common.NewMixedcaseAddress(account.Address)
-//	if err := api.client.Call(&res, "account_signData",
-//		mimeType,
-//		&signAddress, // Need to use the pointer here, because of how MarshalJSON is defined
-//		hexutil.Encode(data)); err != nil {
-//		return nil, err
-//	}
-//	// If V is on 27/28-form, convert to 0/1 for Clique
-//	if mimeType == accounts.MimetypeClique && (res[64] == 27 || res[64] == 28) {
-//		res[64] -= 27 // Transform V from 27/28 to 0/1 for Clique use
-//	}
-//	return res, nil
-//}
-//
-//func (api *ExternalSigner) SignText(account accounts.Account, text []byte) ([]byte, error) {
-//	var signature hexutil.Bytes
-//	var signAddress = common.NewMixedcaseAddress(account.Address)
-//	if err := api.client.Call(&signature, "account_signData",
-//		accounts.MimetypeTextPlain,
-//		&signAddress, // Need to use the pointer here, because of how MarshalJSON is defined
-//		hexutil.Encode(text)); err != nil {
-//		return nil, err
-//	}
-//	if signature[64] == 27 || signature[64] == 28 {
-//		// If clef is used as a backend, it may already have transformed
-//		// the signature to ethereum-type signature.
-//		signature[64] -= 27 // Transform V from Ethereum-legacy to 0/1
-//	}
-//	return signature, nil
-//}
-//
-//// signTransactionResult represents the signinig result returned by clef.
-//type signTransactionResult struct {
-//	Raw hexutil.Bytes      `json:"raw"`
-//	Tx  *types.Transaction `json:"tx"`
-//}
-//
-//// SignTx sends the transaction to the external signer.
-//// If chainID is nil, or tx.ChainID is zero, the chain ID will be assigned
-//// by the external signer. For non-legacy transactions, the chain ID of the
-//// transaction overrides the chainID parameter.
-//func (api *ExternalSigner) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
-//	data := hexutil.Bytes(tx.Data())
-//	var to *common.MixedcaseAddress
-//	if tx.To() != nil {
-//		t := common.NewMixedcaseAddress(*tx.To())
-//		to = &t
-//	}
-//	args := &apitypes.SendTxArgs{
-//		Data:  &data,
-//		Nonce: hexutil.Uint64(tx.Nonce()),
-//		Value: hexutil.Big(*tx.Value()),
-//		Gas:   hexutil.Uint64(tx.Gas()),
-//		To:    to,
-//		From:  common.NewMixedcaseAddress(account.Address),
-//	}
-//	switch tx.Type() {
-//	case types.LegacyTxType, types.AccessListTxType:
-//		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
-//	case types.DynamicFeeTxType:
-//		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
-//		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
-//	default:
-//		return nil, fmt.Errorf("unsupported tx type %d", tx.Type())
-//	}
-//	// We should request the default chain id that we're operating with
-//	// (the chain we're executing on)
-//	if chainID != nil && chainID.Sign() != 0 {
-//		args.ChainID = (*hexutil.Big)(chainID)
-//	}
-//	if tx.Type() != types.LegacyTxType {
-//		// However, if the user asked for a particular chain id, then we should
-//		// use that instead.
-//		if tx.ChainId().Sign() != 0 {
-//			args.ChainID = (*hexutil.Big)(tx.ChainId())
-//		}
-//		accessList := tx.AccessList()
-//		args.AccessList = &accessList
-//	}
-//	var res signTransactionResult
-//	if err := api.client.Call(&res, "account_signTransaction", args); err != nil {
-//		return nil, err
-//	}
-//	return res.Tx, nil
-//}
-//
-//func (api *ExternalSigner) SignTextWithPassphrase(account accounts.Account, passphrase string, text []byte) ([]byte, error) {
-//	return []byte{}, fmt.Errorf("password-operations not supported on external signers")
-//}
-//
-//func (api *ExternalSigner) SignTxWithPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
-//	return nil, fmt.Errorf("password-operations not supported on external signers")
-//}
-//func (api *ExternalSigner) SignDataWithPassphrase(account accounts.Account, passphrase, mimeType string, data []byte) ([]byte, error) {
-//	return nil, fmt.Errorf("password-operations not supported on external signers")
-//}
-//
-//func (api *ExternalSigner) listAccounts() ([]types2.Address, error) {
-//	var res []types2.Address
-//	if err := api.client.Call(&res, "account_list"); err != nil {
-//		return nil, err
-//	}
-//	return res, nil
-//}
-//
-//func (api *ExternalSigner) pingVersion() (string, error) {
-//	var v string
-//	if err := api.client.Call(&v, "account_version"); err != nil {
-//		return "", err
-//	}
-//	return v, nil
-//}
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/accounts"
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+)
+
+// DefaultSignTimeout is how long a signing request may take if Config has no timeout.
+const DefaultSignTimeout = 2 * time.Second
+
+// Config tells how to reach an external signer speaking the account_* JSON-RPC
+// API of clef.
+type Config struct {
+	Endpoint string
+
+	// CertFile and KeyFile are the client certificate presented to the signer,
+	// CAFile the certificate authority the signer certificate must chain to.
+	// All are optional, without them the system roots verify the signer.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// Timeout bounds every request, a signing request running longer fails
+	// with accounts.ErrSignTimeout.
+	Timeout time.Duration
+}
+
+// tlsConfig builds the mutual TLS configuration of cfg, nil if it has none.
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" && cfg.CAFile == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+// ExternalSigner signs with keys held by a remote signer (clef), so the keys
+// never live on the block producing host. Its SignData is the SignerFn of the
+// sealers.
+type ExternalSigner struct {
+	client   *jsonrpc.Client
+	endpoint string
+	timeout  time.Duration
+
+	cacheMu sync.RWMutex
+	cache   []accounts.Account
+}
+
+// NewExternalSigner connects to the signer of cfg and checks it is reachable.
+func NewExternalSigner(cfg Config) (*ExternalSigner, error) {
+	tlsConf, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultSignTimeout
+	}
+	client, err := jsonrpc.DialHTTPWithClient(cfg.Endpoint, &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConf},
+	})
+	if err != nil {
+		return nil, err
+	}
+	signer := &ExternalSigner{client: client, endpoint: cfg.Endpoint, timeout: timeout}
+	version, err := signer.pingVersion()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	log.Info("Connected to external signer", "endpoint", cfg.Endpoint, "version", version)
+	return signer, nil
+}
+
+func (api *ExternalSigner) URL() accounts.URL {
+	return accounts.URL{
+		Scheme: "extapi",
+		Path:   api.endpoint,
+	}
+}
+
+func (api *ExternalSigner) Close() {
+	api.client.Close()
+}
+
+// Accounts fetches the accounts of the signer and caches them for Contains.
+func (api *ExternalSigner) Accounts() ([]accounts.Account, error) {
+	res, err := api.listAccounts()
+	if err != nil {
+		return nil, err
+	}
+	accnts := make([]accounts.Account, 0, len(res))
+	for _, addr := range res {
+		accnts = append(accnts, accounts.Account{Address: addr, URL: api.URL()})
+	}
+	api.cacheMu.Lock()
+	api.cache = accnts
+	api.cacheMu.Unlock()
+	return accnts, nil
+}
+
+// Contains reports whether the signer holds the account, from the cached
+// account list. The list is fetched again if the account is not in it.
+func (api *ExternalSigner) Contains(account accounts.Account) bool {
+	if api.cached(account) {
+		return true
+	}
+	if _, err := api.Accounts(); err != nil {
+		log.Error("External signer account listing failed", "endpoint", api.endpoint, "err", err)
+		return false
+	}
+	return api.cached(account)
+}
+
+func (api *ExternalSigner) cached(account accounts.Account) bool {
+	api.cacheMu.RLock()
+	defer api.cacheMu.RUnlock()
+	for _, a := range api.cache {
+		if a.Address == account.Address && (account.URL == (accounts.URL{}) || account.URL == api.URL()) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignData signs keccak256(data). The mimetype parameter describes the type of data being signed
+func (api *ExternalSigner) SignData(account accounts.Account, mimeType string, data []byte) ([]byte, error) {
+	var res hexutil.Bytes
+	if err := api.call(&res, "account_signData", mimeType, account.Address, hexutil.Bytes(data)); err != nil {
+		return nil, err
+	}
+	if len(res) != 65 {
+		return nil, fmt.Errorf("external signer returned %d signature bytes", len(res))
+	}
+	// If V is on 27/28-form, convert to 0/1 for Clique
+	if mimeType == accounts.MimetypeClique && (res[64] == 27 || res[64] == 28) {
+		res[64] -= 27 // Transform V from 27/28 to 0/1 for Clique use
+	}
+	return res, nil
+}
+
+func (api *ExternalSigner) listAccounts() ([]types.Address, error) {
+	var res []types.Address
+	if err := api.call(&res, "account_list"); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (api *ExternalSigner) pingVersion() (string, error) {
+	var v string
+	if err := api.call(&v, "account_version"); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// call runs one request within the timeout, running out of it is accounts.ErrSignTimeout.
+func (api *ExternalSigner) call(result interface{}, method string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), api.timeout)
+	defer cancel()
+	err := api.client.CallContext(ctx, result, method, args...)
+	if err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return fmt.Errorf("%w: %s %s after %v", accounts.ErrSignTimeout, api.endpoint, method, api.timeout)
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package external
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/accounts"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/conf"
+	"github.com/amazechain/amc/internal/consensus/apos"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+// mockSigner serves the account_* API of clef for one account. It signs with
+// signKey, which differs from the key of the account to play a faulty signer.
+type mockSigner struct {
+	account types.Address
+	signKey *ecdsa.PrivateKey
+	delay   time.Duration
+}
+
+func (m *mockSigner) Version() string { return "6.0.0" }
+
+func (m *mockSigner) List() []types.Address { return []types.Address{m.account} }
+
+func (m *mockSigner) SignData(mimeType string, addr types.Address, data hexutil.Bytes) (hexutil.Bytes, error) {
+	time.Sleep(m.delay)
+	if addr != m.account {
+		return nil, errors.New("unknown account")
+	}
+	sig, err := crypto.Sign(crypto.Keccak256(data), m.signKey)
+	if err != nil {
+		return nil, err
+	}
+	sig[64] += 27 // clef answers in 27/28-form
+	return sig, nil
+}
+
+func newMockSigner(t *testing.T, mock *mockSigner, timeout time.Duration) *ExternalSigner {
+	server := jsonrpc.NewServer()
+	if err := server.RegisterName("account", mock); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	signer, err := NewExternalSigner(Config{Endpoint: httpServer.URL, Timeout: timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(signer.Close)
+	return signer
+}
+
+// sealHeader seals a header the way the sealers do and returns the author
+// header verification recovers from it.
+func sealHeader(t *testing.T, signer *ExternalSigner, account types.Address) (types.Address, error) {
+	header := &block.Header{
+		Number:     uint256.NewInt(1),
+		Difficulty: uint256.NewInt(2),
+		Time:       uint64(time.Now().Unix()),
+		Extra:      make([]byte, 32+crypto.SignatureLength),
+	}
+	sig, err := signer.SignData(accounts.Account{Address: account}, accounts.MimetypeClique, apos.APosProto(header))
+	if err != nil {
+		return types.Address{}, err
+	}
+	copy(header.Extra[len(header.Extra)-crypto.SignatureLength:], sig)
+
+	engine := apos.New(&conf.ConsensusConfig{APos: &conf.APosConfig{}}, nil, params.TestChainConfig)
+	author, err := engine.Author(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return author, nil
+}
+
+func TestExternalSignerSeal(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	signer := newMockSigner(t, &mockSigner{account: account, signKey: key}, time.Second)
+
+	if !signer.Contains(accounts.Account{Address: account}) {
+		t.Fatalf("signer does not list %s", account)
+	}
+	if signer.Contains(accounts.Account{Address: types.Address{1}}) {
+		t.Fatalf("signer lists an unknown account")
+	}
+	author, err := sealHeader(t, signer, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if author != account {
+		t.Fatalf("author: have %s, want %s", author, account)
+	}
+}
+
+func TestExternalSignerTimeout(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	mock := &mockSigner{account: account, signKey: key}
+	signer := newMockSigner(t, mock, 100*time.Millisecond)
+
+	mock.delay = time.Second
+	if _, err := sealHeader(t, signer, account); !errors.Is(err, accounts.ErrSignTimeout) {
+		t.Fatalf("error: have %v, want %v", err, accounts.ErrSignTimeout)
+	}
+}
+
+func TestExternalSignerMismatch(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	signer := newMockSigner(t, &mockSigner{account: account, signKey: other}, time.Second)
+
+	author, err := sealHeader(t, signer, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if author == account {
+		t.Fatalf("header signed by the wrong key is attributed to %s", account)
+	}
+	if author != crypto.PubkeyToAddress(other.PublicKey) {
+		t.Fatalf("author: have %s, want %s", author, crypto.PubkeyToAddress(other.PublicKey))
+	}
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/amazechain/amc/log"
 	"net/http"
@@ -35,6 +36,7 @@ import (
 	"github.com/amazechain/amc/cmd/utils"
 
 	"github.com/amazechain/amc/conf"
+	"github.com/amazechain/amc/internal/kv/migrations"
 	"github.com/amazechain/amc/internal/node"
 	"github.com/urfave/cli/v2"
 )
@@ -86,8 +88,15 @@ func appRun(ctx *cli.Context) error {
 	}
 
 	n, err := node.NewNode(c, &DefaultConfig)
+	if errors.Is(err, migrations.ErrNotApproved) {
+		// the db is untouched, the operator reviews the plan printed above and starts again
+		log.Warn("Database migrations not approved, exiting", "err", err, "approve", "--"+DBMigrationsApproveFlag.Name)
+		cancel()
+		return nil
+	}
 	if err != nil {
 		log.Error("Failed start Node", "err", err)
+		cancel()
 		return err
 	}
 
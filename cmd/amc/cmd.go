@@ -120,6 +120,23 @@ var rpcFlags = []cli.Flag{
 		Value:       "20013",
 		Destination: &DefaultConfig.NodeCfg.WSPort,
 	},
+	&cli.IntFlag{
+		Name:        "rpc.logslimit",
+		Usage:       "Maximum number of logs eth_getLogs returns for a block range (0 = unlimited)",
+		Value:       DefaultConfig.NodeCfg.RPCLogsLimit,
+		Destination: &DefaultConfig.NodeCfg.RPCLogsLimit,
+	},
+	&cli.IntFlag{
+		Name:        "rpc.slowqueries",
+		Usage:       "Number of slowest RPC calls kept for admin_slowQueries (0 = disabled)",
+		Value:       DefaultConfig.NodeCfg.RPCSlowQueries,
+		Destination: &DefaultConfig.NodeCfg.RPCSlowQueries,
+	},
+	&cli.BoolFlag{
+		Name:        "rpc.slowqueries.params",
+		Usage:       "Keep the sanitized parameters of slow RPC calls",
+		Destination: &DefaultConfig.NodeCfg.RPCSlowQueryParams,
+	},
 }
 
 var consensusFlag = []cli.Flag{
@@ -233,6 +250,61 @@ var (
 		Value:       "./amc/",
 		Destination: &DefaultConfig.NodeCfg.DataDir,
 	}
+	DataDirTakeoverFlag = &cli.BoolFlag{
+		Name:        "data.dir.takeover",
+		Usage:       "Ask a running amc holding the data dir to shut down gracefully and take it over",
+		Value:       false,
+		Destination: &DefaultConfig.NodeCfg.DataDirTakeover,
+	}
+
+	EventJournalFlag = &cli.BoolFlag{
+		Name:        "db.eventjournal",
+		Usage:       "Append chain events to a durable journal served by amc_pollEvents",
+		Value:       false,
+		Destination: &DefaultConfig.DatabaseCfg.EventJournal,
+	}
+	EventJournalMaxAgeFlag = &cli.DurationFlag{
+		Name:        "db.eventjournal.maxage",
+		Usage:       "Prune unacknowledged journal events older than this (0 keeps them until acknowledged)",
+		Value:       DefaultConfig.DatabaseCfg.EventJournalMaxAge,
+		Destination: &DefaultConfig.DatabaseCfg.EventJournalMaxAge,
+	}
+	FeeAccountingFlag = &cli.BoolFlag{
+		Name:        "db.feeaccounting",
+		Usage:       "Index gas used, fees paid and tips received per address, served by amc_getFeeAccounting",
+		Value:       false,
+		Destination: &DefaultConfig.DatabaseCfg.FeeAccounting,
+	}
+	AccessListsFlag = &cli.BoolFlag{
+		Name:        "db.accesslists",
+		Usage:       "Record the accounts and storage slots each block touched, served by amc_getBlockAccessList",
+		Value:       false,
+		Destination: &DefaultConfig.DatabaseCfg.AccessLists,
+	}
+	AccessListRetentionFlag = &cli.Uint64Flag{
+		Name:        "db.accesslists.retention",
+		Usage:       "Remove block access lists this many blocks below the head (0 keeps them)",
+		Value:       DefaultConfig.DatabaseCfg.AccessListRetention,
+		Destination: &DefaultConfig.DatabaseCfg.AccessListRetention,
+	}
+	ForkRetentionFlag = &cli.Uint64Flag{
+		Name:        "db.forkretention",
+		Usage:       "Remove side chain blocks this many blocks below the head (0 keeps them)",
+		Value:       DefaultConfig.DatabaseCfg.ForkRetention,
+		Destination: &DefaultConfig.DatabaseCfg.ForkRetention,
+	}
+	DBMigrationsApproveFlag = &cli.BoolFlag{
+		Name:        "db.migrations.approve",
+		Usage:       "Run pending destructive database migrations at startup without asking",
+		Value:       false,
+		Destination: &DefaultConfig.DatabaseCfg.MigrationsApprove,
+	}
+	DiskGuardMarginFlag = &cli.Uint64Flag{
+		Name:        "db.diskguard.margin",
+		Usage:       "Pause sync while the datadir volume is predicted to drop below this many MiB free (0 disables)",
+		Value:       DefaultConfig.DatabaseCfg.DiskGuardMargin,
+		Destination: &DefaultConfig.DatabaseCfg.DiskGuardMargin,
+	}
 )
 
 var (
@@ -332,6 +404,15 @@ var (
 var (
 	settingFlag = []cli.Flag{
 		DataDirFlag,
+		DataDirTakeoverFlag,
+		EventJournalFlag,
+		EventJournalMaxAgeFlag,
+		FeeAccountingFlag,
+		AccessListsFlag,
+		AccessListRetentionFlag,
+		ForkRetentionFlag,
+		DBMigrationsApproveFlag,
+		DiskGuardMarginFlag,
 	}
 	accountFlag = []cli.Flag{
 		PasswordFileFlag,
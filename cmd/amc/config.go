@@ -22,8 +22,10 @@ import (
 	"fmt"
 	"github.com/amazechain/amc/params"
 	"math/big"
+	"time"
 
 	"github.com/amazechain/amc/conf"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
 )
 
 //go:embed allocs
@@ -31,12 +33,14 @@ var allocs embed.FS
 
 var DefaultConfig = conf.Config{
 	NodeCfg: conf.NodeConfig{
-		NodePrivate: "",
-		HTTP:        true,
-		HTTPHost:    "127.0.0.1",
-		HTTPPort:    "8545",
-		IPCPath:     "amc.ipc",
-		Miner:       false,
+		NodePrivate:    "",
+		HTTP:           true,
+		HTTPHost:       "127.0.0.1",
+		HTTPPort:       "8545",
+		IPCPath:        "amc.ipc",
+		Miner:          false,
+		RPCLogsLimit:   10000,
+		RPCSlowQueries: jsonrpc.DefaultSlowQueries,
 	},
 	NetworkCfg: conf.NetWorkConfig{
 		Bootstrapped: true,
@@ -65,6 +69,11 @@ var DefaultConfig = conf.Config{
 		IsMem:      false,
 		MaxDB:      100,
 		MaxReaders: 1000,
+
+		EventJournalMaxAge:  7 * 24 * time.Hour,
+		AccessListRetention: 90000,
+		ForkRetention:       0,
+		DiskGuardMargin:     2048,
 	},
 	MetricsCfg: conf.MetricsConfig{
 		InfluxDBEndpoint:     "",
@@ -0,0 +1,785 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/diagnostics"
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/integrity"
+	"github.com/amazechain/amc/internal/kv/kvstats"
+	"github.com/amazechain/amc/internal/kv/prune"
+	"github.com/amazechain/amc/internal/node"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/c2h5oh/datasize"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	PruneOlderFlag = &cli.Uint64Flag{
+		Name:  "prune.older",
+		Usage: "project pruning of data older than this many blocks behind the head",
+		Value: 90000,
+	}
+	JSONOutputFlag = &cli.BoolFlag{
+		Name:  "json",
+		Usage: "print the report as JSON",
+	}
+	StatsDupSortFlag = &cli.BoolFlag{
+		Name:  "stats.dupsort",
+		Usage: "also estimate DupSort savings of candidate key prefix lengths from a sample of table keys",
+	}
+	StatsSampleRateFlag = &cli.Float64Flag{
+		Name:  "stats.rate",
+		Usage: "share of block numbers sampled by the prefix analyzer, 1 reads whole tables",
+		Value: 0.01,
+	}
+	StatsTableFlag = &cli.StringFlag{
+		Name:  "stats.table",
+		Usage: "estimate DupSort savings of this block-keyed table instead of the built-in examples, requires --stats.prefixes",
+	}
+	StatsPrefixesFlag = &cli.IntSliceFlag{
+		Name:  "stats.prefixes",
+		Usage: "two candidate DupSort prefix lengths compared for --stats.table",
+	}
+	StatsBlockSizesFlag = &cli.BoolFlag{
+		Name:  "stats.blocksizes",
+		Usage: "also print stored block sizes per 1k blocks and the segment ranges they would be retired in",
+	}
+	StatsSegmentSizeFlag = &cli.Uint64Flag{
+		Name:  "stats.segment",
+		Usage: "compressed segment size in MB the ranges of --stats.blocksizes aim for",
+		Value: 256,
+	}
+	DefragTableFlag = &cli.StringSliceFlag{
+		Name:  "defrag.table",
+		Usage: "history index tables to defragment",
+		Value: cli.NewStringSlice(modules.AccountsHistory, modules.StorageHistory),
+	}
+	BackfillFromFlag = &cli.Uint64Flag{
+		Name:  "backfill.from",
+		Usage: "first block to index",
+		Value: 1,
+	}
+	BackfillToFlag = &cli.Uint64Flag{
+		Name:  "backfill.to",
+		Usage: "last block to index, 0 indexes up to the head",
+	}
+	RepairFromFlag = &cli.Uint64Flag{
+		Name:  "repair.from",
+		Usage: "first block to repair",
+	}
+	RepairDryRunFlag = &cli.BoolFlag{
+		Name:  "repair.dryrun",
+		Usage: "report what would be repaired without writing it",
+	}
+	CheckFromFlag = &cli.Uint64Flag{
+		Name:  "check.from",
+		Usage: "first block to check",
+	}
+	CheckToFlag = &cli.Uint64Flag{
+		Name:  "check.to",
+		Usage: "last block to check, 0 checks up to the last canonical block",
+	}
+	CheckFixFlag = &cli.BoolFlag{
+		Name:  "check.fix",
+		Usage: "write missing transaction lookup entries and recover missing senders",
+	}
+	RebuildFromFlag = &cli.Uint64Flag{
+		Name:  "rebuild.from",
+		Usage: "first block to rebuild",
+	}
+	RebuildToFlag = &cli.Uint64Flag{
+		Name:  "rebuild.to",
+		Usage: "last block to rebuild, 0 rebuilds up to the head",
+	}
+	DropDryRunFlag = &cli.BoolFlag{
+		Name:  "drop.dryrun",
+		Usage: "report the space dropping would free without dropping",
+	}
+	VerifySampleFlag = &cli.IntFlag{
+		Name:  "verify.sample",
+		Usage: "records decoded spread over each table, 0 decodes whole tables",
+		Value: 10000,
+	}
+
+	dbCommand = &cli.Command{
+		Name:        "db",
+		Usage:       "AmazeChain database tools",
+		ArgsUsage:   "",
+		Description: ``,
+		Subcommands: []*cli.Command{
+			{
+				Name:      "prune-projection",
+				Usage:     "Report disk space freed and RPC methods affected by a hypothetical prune horizon",
+				ArgsUsage: "",
+				Action:    pruneProjection,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					PruneOlderFlag,
+					JSONOutputFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "stats",
+				Usage:     "Print entries and size of every table, largest first",
+				ArgsUsage: "",
+				Action:    dbStats,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					StatsDupSortFlag,
+					StatsSampleRateFlag,
+					StatsTableFlag,
+					StatsPrefixesFlag,
+					StatsBlockSizesFlag,
+					StatsSegmentSizeFlag,
+					JSONOutputFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "defrag-history",
+				Usage:     "Merge small history index shards left by pruning and unwinds, of a stopped node",
+				ArgsUsage: "",
+				Action:    defragHistory,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					DefragTableFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "diagnostics",
+				Usage:     "Collect node state of a stopped node for bug reports, as JSON",
+				ArgsUsage: "",
+				Action:    collectDiagnostics,
+				Flags: []cli.Flag{
+					DataDirFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "fee-accounting-backfill",
+				Usage:     "Index fees per address of blocks imported before fee accounting was enabled, of a stopped node",
+				ArgsUsage: "",
+				Action:    backfillFeeAccounting,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					BackfillFromFlag,
+					BackfillToFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "access-list-backfill",
+				Usage:     "Record access lists of blocks imported before access lists were enabled, of a stopped node, lacking the accounts and slots only read",
+				ArgsUsage: "",
+				Action:    backfillAccessLists,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					BackfillFromFlag,
+					BackfillToFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "repair-canonical",
+				Usage:     "Rebuild the canonical chain markers of a stopped node from headers and their total difficulty",
+				ArgsUsage: "",
+				Action:    repairCanonical,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					RepairFromFlag,
+					RepairDryRunFlag,
+					JSONOutputFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "repair-cumulative-indexes",
+				Usage:     "Check the cumulative gas and transaction indexes of a stopped node and rewrite missing or wrong totals",
+				ArgsUsage: "",
+				Action:    repairCumulativeIndexes,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					RepairFromFlag,
+					RepairDryRunFlag,
+					JSONOutputFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "check-blocks",
+				Usage:     "Check the references between the block tables of a stopped node",
+				ArgsUsage: "",
+				Action:    checkBlocks,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					CheckFromFlag,
+					CheckToFlag,
+					CheckFixFlag,
+					JSONOutputFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "rebuild-txlookup",
+				Usage:     "Regenerate the transaction lookup entries of canonical blocks of a stopped node",
+				ArgsUsage: "",
+				Action:    rebuildTxLookup,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					RebuildFromFlag,
+					RebuildToFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:        "access-matrix",
+				Usage:       "Print the tables each sync stage and RPC method declares to read and write, as JSON",
+				ArgsUsage:   "",
+				Action:      accessMatrix,
+				Description: ``,
+			},
+			{
+				Name:      "drop-deprecated",
+				Usage:     "Drop the deprecated tables of older database layouts, one write transaction per table",
+				ArgsUsage: "",
+				Action:    dropDeprecated,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					DropDryRunFlag,
+				},
+				Description: ``,
+			},
+			{
+				Name:      "verify-codecs",
+				Usage:     "Decode a sample of the records of every table with a known format, of a stopped node",
+				ArgsUsage: "",
+				Action:    verifyCodecs,
+				Flags: []cli.Flag{
+					DataDirFlag,
+					VerifySampleFlag,
+				},
+				Description: ``,
+			},
+		},
+	}
+)
+
+func pruneProjection(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	roTX, err := db.BeginRo(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer roTX.Rollback()
+
+	projection, err := prune.Project(roTX, prune.Settings{Older: ctx.Uint64(PruneOlderFlag.Name)})
+	if err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		out, err := json.MarshalIndent(projection, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	fmt.Print(projection)
+	return nil
+}
+
+// statsReport - output of the stats subcommand
+type statsReport struct {
+	Tables   map[string]amckv.TableStat `json:"tables"`
+	Prefixes []*kvstats.Comparison      `json:"prefixes,omitempty"`
+
+	BlockSizes []rawdb.BlockSizeWindow `json:"blockSizes,omitempty"`
+	Segments   []rawdb.RetireRange     `json:"segments,omitempty"`
+}
+
+func dbStats(ctx *cli.Context) error {
+	var examples []kvstats.Example
+	if ctx.Bool(StatsDupSortFlag.Name) {
+		examples = kvstats.Examples
+	}
+	if table := ctx.String(StatsTableFlag.Name); table != "" {
+		prefixes := ctx.IntSlice(StatsPrefixesFlag.Name)
+		if len(prefixes) != 2 {
+			return fmt.Errorf("--%s needs two prefix lengths, have %d", StatsPrefixesFlag.Name, len(prefixes))
+		}
+		examples = []kvstats.Example{{Table: table, Prefixes: [2]int{prefixes[0], prefixes[1]}}}
+	}
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	var report statsReport
+	if report.Tables, err = node.TableStats(ctx.Context, db); err != nil {
+		return err
+	}
+
+	roTX, err := db.BeginRo(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer roTX.Rollback()
+	for _, e := range examples {
+		c, err := kvstats.ComparePrefixes(roTX, e.Table, e.Prefixes[0], e.Prefixes[1], ctx.Float64(StatsSampleRateFlag.Name))
+		if err != nil {
+			// built-in examples may name tables this database does not have
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.Table, err)
+			continue
+		}
+		report.Prefixes = append(report.Prefixes, c)
+	}
+	if ctx.Bool(StatsBlockSizesFlag.Name) {
+		if report.BlockSizes, err = rawdb.ReadBlockSizeWindows(roTX); err != nil {
+			return err
+		}
+		if n := len(report.BlockSizes); n > 0 {
+			last := report.BlockSizes[n-1].First + rawdb.BlockSizeWindowLen - 1
+			target := ctx.Uint64(StatsSegmentSizeFlag.Name) * uint64(datasize.MB)
+			if report.Segments, err = rawdb.ScheduleRetirement(roTX, report.BlockSizes[0].First, last, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Bool(JSONOutputFlag.Name) {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	fmt.Printf("%-30s %14s %12s %12s\n", "table", "entries", "overflow", "size")
+	for _, name := range amckv.SortTableStats(report.Tables) {
+		st := report.Tables[name]
+		fmt.Printf("%-30s %14d %12d %12s\n", name, st.Entries, st.OverflowPages, datasize.ByteSize(st.Size).HR())
+	}
+	for _, c := range report.Prefixes {
+		fmt.Println()
+		fmt.Print(c)
+	}
+	if len(report.BlockSizes) > 0 {
+		fmt.Println()
+		fmt.Printf("%-12s %8s %12s %12s %12s %12s %8s\n", "blocks", "count", "header", "body", "txs", "receipts", "ratio")
+		for _, w := range report.BlockSizes {
+			ratio := "-"
+			if r, ok := w.Ratio(); ok {
+				ratio = fmt.Sprintf("%.2f", r)
+			}
+			fmt.Printf("%-12d %8d %12s %12s %12s %12s %8s\n", w.First, w.Blocks, datasize.ByteSize(w.Header).HR(), datasize.ByteSize(w.Body).HR(),
+				datasize.ByteSize(w.Txs).HR(), datasize.ByteSize(w.Receipts).HR(), ratio)
+		}
+		fmt.Println()
+		for _, r := range report.Segments {
+			fmt.Printf("segment %d-%d: ~%s\n", r.From, r.To, datasize.ByteSize(r.Size).HR())
+		}
+	}
+	return nil
+}
+
+func defragHistory(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	for _, table := range ctx.StringSlice(DefragTableFlag.Name) {
+		var stats bitmapdb.DefragStats
+		if err := db.Update(ctx.Context, func(tx kv.RwTx) error {
+			percent := -1
+			stats, err = bitmapdb.DefragHistoryTable(tx, table, func(share float64) {
+				if p := int(share * 100); p != percent {
+					percent = p
+					fmt.Fprintf(os.Stderr, "\r%s: %3d%%", table, p)
+				}
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("defrag %s: %w", table, err)
+		}
+		fmt.Fprintln(os.Stderr)
+		fmt.Printf("%s: %d keys, %d rewritten, shards %d -> %d\n", table, stats.Keys, stats.Rewritten, stats.ShardsBefore, stats.ShardsAfter)
+	}
+	return nil
+}
+
+func backfillFeeAccounting(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	from, to := ctx.Uint64(BackfillFromFlag.Name), ctx.Uint64(BackfillToFlag.Name)
+	var indexed int
+	if err := db.Update(ctx.Context, func(tx kv.RwTx) error {
+		if head := rawdb.ReadCurrentBlockNumber(tx); head == nil {
+			return fmt.Errorf("no head block")
+		} else if to == 0 || to > *head {
+			to = *head
+		}
+		indexed, err = rawdb.BackfillFeeAccounting(tx, from, to, os.TempDir(), ctx.Done())
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("indexed fees of %d blocks in %d..%d\n", indexed, from, to)
+	return nil
+}
+
+func backfillAccessLists(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	from, to := ctx.Uint64(BackfillFromFlag.Name), ctx.Uint64(BackfillToFlag.Name)
+	var recorded int
+	if err := db.Update(ctx.Context, func(tx kv.RwTx) error {
+		if head := rawdb.ReadCurrentBlockNumber(tx); head == nil {
+			return fmt.Errorf("no head block")
+		} else if to == 0 || to > *head {
+			to = *head
+		}
+		recorded, err = rawdb.BackfillBlockAccessLists(tx, from, to, os.TempDir(), ctx.Done())
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("recorded access lists of %d blocks in %d..%d\n", recorded, from, to)
+	return nil
+}
+
+func rebuildTxLookup(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	from, to := ctx.Uint64(RebuildFromFlag.Name), ctx.Uint64(RebuildToFlag.Name)
+	if err := db.Update(ctx.Context, func(tx kv.RwTx) error {
+		if head := rawdb.ReadCurrentBlockNumber(tx); head == nil {
+			return fmt.Errorf("no head block")
+		} else if to == 0 || to > *head {
+			to = *head
+		}
+		last := from
+		return rawdb.RebuildTxLookup(tx, from, to, os.TempDir(), func(number uint64) {
+			if number-last >= 10000 || number == to {
+				last = number
+				fmt.Fprintf(os.Stderr, "\rblock %d of %d", number, to)
+			}
+		})
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Printf("rebuilt transaction lookup of blocks %d..%d\n", from, to)
+	return nil
+}
+
+func repairCanonical(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	tx, err := db.BeginRw(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := integrity.RepairCanonical(tx, ctx.Uint64(RepairFromFlag.Name))
+	if err != nil {
+		return err
+	}
+	dryRun := ctx.Bool(RepairDryRunFlag.Name)
+	if !dryRun {
+		// gaps are not repaired, the check must keep reporting them
+		if len(res.Missing) == 0 {
+			if err := integrity.Unfreeze(tx, integrity.Canonical.Name); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Bool(JSONOutputFlag.Name) {
+		out, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	verb := "repaired"
+	if dryRun {
+		verb = "would repair"
+	}
+	fmt.Printf("blocks %d..%d: %s %d canonical, %d truncated, %d header numbers\n", res.From, res.To, verb, res.Rewritten, res.Truncated, res.Numbers)
+	for _, n := range res.Missing {
+		fmt.Printf("block %d: no header with total difficulty, not repaired\n", n)
+	}
+	return nil
+}
+
+func repairCumulativeIndexes(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	tx, err := db.BeginRw(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	dryRun := ctx.Bool(RepairDryRunFlag.Name)
+	res, err := rawdb.RepairCumulativeIndexes(tx, ctx.Uint64(RepairFromFlag.Name), math.MaxUint64, dryRun)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Bool(JSONOutputFlag.Name) {
+		out, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	verb := "repaired"
+	if dryRun {
+		verb = "would repair"
+	}
+	fmt.Printf("blocks %d..%d: %d missing, %d non-monotonic, %d mismatched, %s %d\n", res.From, res.To, len(res.Missing), len(res.NonMonotonic), len(res.Mismatched), verb, len(res.Missing)+len(res.NonMonotonic)+len(res.Mismatched))
+	return nil
+}
+
+func checkBlocks(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	tx, err := db.BeginRw(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fix *integrity.BlockFix
+	if ctx.Bool(CheckFixFlag.Name) {
+		genesis, err := rawdb.ReadCanonicalHash(tx, 0)
+		if err != nil {
+			return err
+		}
+		config, err := rawdb.ReadChainConfig(tx, genesis)
+		if err != nil {
+			return err
+		}
+		recovery, err := rawdb.NewSenderRecovery(config, runtime.NumCPU(), 128, 0)
+		if err != nil {
+			return err
+		}
+		fix = &integrity.BlockFix{Tx: tx, Recovery: recovery}
+	}
+	to := ctx.Uint64(CheckToFlag.Name)
+	if to == 0 {
+		to = math.MaxUint64
+	}
+	report, err := integrity.VerifyBlocks(ctx.Context, tx, ctx.Uint64(CheckFromFlag.Name), to, fix)
+	if err != nil {
+		return err
+	}
+	if fix != nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Bool(JSONOutputFlag.Name) {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("blocks %d..%d: %d checked\n", report.From, report.To, report.Checked)
+		for _, typ := range report.Types() {
+			fmt.Printf("%s: %d blocks %v", typ, len(report.Violations[typ]), report.Violations[typ])
+			if fixed := report.Fixed[typ]; len(fixed) > 0 {
+				fmt.Printf(", fixed %d", len(fixed))
+			}
+			fmt.Println()
+		}
+		if len(report.Affected) > 0 {
+			fmt.Printf("affected RPC: %s\n", strings.Join(report.Affected, ", "))
+		}
+	}
+	if report.Unfixed() {
+		return fmt.Errorf("block tables are inconsistent")
+	}
+	return nil
+}
+
+func accessMatrix(ctx *cli.Context) error {
+	out, err := json.MarshalIndent(amckv.AccessMatrix(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func verifyCodecs(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	roTX, err := db.BeginRo(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer roTX.Rollback()
+
+	errs := amckv.VerifyCodecs(roTX, ctx.Int(VerifySampleFlag.Name))
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d tables hold undecodable records", len(errs), len(amckv.CodecTables()))
+	}
+	fmt.Printf("%d tables decoded\n", len(amckv.CodecTables()))
+	return nil
+}
+
+func dropDeprecated(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	dryRun := ctx.Bool(DropDryRunFlag.Name)
+	freed, err := amckv.DropDeprecatedTables(ctx.Context, func(c context.Context) (amckv.DropTx, error) {
+		return db.BeginRw(c)
+	}, dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "freed"
+	if dryRun {
+		verb = "would free"
+	}
+	fmt.Printf("%s %s of %d deprecated tables\n", verb, datasize.ByteSize(freed).HR(), len(amckv.DeprecatedTables()))
+	return nil
+}
+
+func collectDiagnostics(ctx *cli.Context) error {
+
+	stack, err := node.NewNode(ctx.Context, &DefaultConfig)
+	if err != nil {
+		return err
+	}
+	db := stack.Database()
+	defer stack.Close()
+
+	roTX, err := db.BeginRo(ctx.Context)
+	if err != nil {
+		return err
+	}
+	defer roTX.Rollback()
+
+	bundle := diagnostics.Collect(diagnostics.Sources(roTX,
+		diagnostics.Source{Name: "chainConfig", Collect: func() (interface{}, error) {
+			genesis, err := rawdb.ReadCanonicalHash(roTX, 0)
+			if err != nil {
+				return nil, err
+			}
+			return rawdb.ReadChainConfig(roTX, genesis)
+		}},
+	))
+	out, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
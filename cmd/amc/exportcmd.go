@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"github.com/amazechain/amc/common/account"
 	common "github.com/amazechain/amc/common/types"
 	"github.com/amazechain/amc/internal/node"
+	"github.com/amazechain/amc/modules/rawdb"
 	"github.com/amazechain/amc/params"
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/kv"
@@ -104,29 +104,20 @@ func exportBalance(ctx *cli.Context) error {
 		return err
 	}
 	defer roTX.Rollback()
-	//kv.ReadAhead(ctx.Context, roTX.(kv.RoDB), atomic.NewBool(false), name, nil, 1<<32-1) // MaxUint32
-	//
-	srcC, err := roTX.Cursor("Account")
+	accounts, err := rawdb.IterateAccounts(roTX, nil)
 	if err != nil {
 		return err
 	}
+	defer accounts.Close()
 
-	for k, v, err := srcC.First(); k != nil; k, v, err = srcC.Next() {
-		if err != nil {
-			return err
-		}
-		var acc account.StateAccount
-		if err = acc.DecodeForStorage(v); err != nil {
-			return err
-		}
-
+	for accounts.Next() {
 		fmt.Printf("%x, %.2f\n",
-			k,
-			new(big.Float).Quo(new(big.Float).SetInt(acc.Balance.ToBig()), new(big.Float).SetInt(big.NewInt(params.AMT))),
+			accounts.Address(),
+			new(big.Float).Quo(new(big.Float).SetInt(accounts.Account().Balance.ToBig()), new(big.Float).SetInt(big.NewInt(params.AMT))),
 		)
 	}
 
-	return nil
+	return accounts.Err()
 }
 
 func exportDBState(ctx *cli.Context) error {
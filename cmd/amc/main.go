@@ -39,7 +39,7 @@ func main() {
 	flags = append(flags, accountFlag...)
 	flags = append(flags, metricsFlags...)
 
-	rootCmd = append(rootCmd, walletCommand, accountCommand, exportCommand)
+	rootCmd = append(rootCmd, walletCommand, accountCommand, exportCommand, dbCommand)
 	commands := rootCmd
 
 	app := &cli.App{
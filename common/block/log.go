@@ -17,8 +17,11 @@
 package block
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/amazechain/amc/api/protocol/types_pb"
+	"github.com/amazechain/amc/common/hexutil"
 	"github.com/amazechain/amc/common/types"
 
 	"github.com/amazechain/amc/utils"
@@ -53,6 +56,71 @@ type Log struct {
 	Removed bool `json:"removed"`
 }
 
+// logJSON is the JSON-RPC encoding of a Log: data and numbers are hex, as in
+// Ethereum's eth_getLogs.
+type logJSON struct {
+	Address     *types.Address `json:"address"`
+	Topics      []types.Hash   `json:"topics"`
+	Data        *hexutil.Bytes `json:"data"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	TxHash      *types.Hash    `json:"transactionHash"`
+	TxIndex     hexutil.Uint   `json:"transactionIndex"`
+	BlockHash   types.Hash     `json:"blockHash"`
+	Index       hexutil.Uint   `json:"logIndex"`
+	Removed     bool           `json:"removed"`
+}
+
+// MarshalJSON marshals as JSON.
+func (l Log) MarshalJSON() ([]byte, error) {
+	enc := logJSON{
+		Address:   &l.Address,
+		Topics:    l.Topics,
+		Data:      (*hexutil.Bytes)(&l.Data),
+		TxHash:    &l.TxHash,
+		TxIndex:   hexutil.Uint(l.TxIndex),
+		BlockHash: l.BlockHash,
+		Index:     hexutil.Uint(l.Index),
+		Removed:   l.Removed,
+	}
+	if enc.Topics == nil {
+		enc.Topics = []types.Hash{}
+	}
+	if l.BlockNumber != nil {
+		enc.BlockNumber = hexutil.Uint64(l.BlockNumber.Uint64())
+	}
+	return json.Marshal(&enc)
+}
+
+// UnmarshalJSON unmarshals from JSON.
+func (l *Log) UnmarshalJSON(input []byte) error {
+	var dec logJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.Address == nil {
+		return errors.New("missing required field 'address' for Log")
+	}
+	if dec.Topics == nil {
+		return errors.New("missing required field 'topics' for Log")
+	}
+	if dec.Data == nil {
+		return errors.New("missing required field 'data' for Log")
+	}
+	if dec.TxHash == nil {
+		return errors.New("missing required field 'transactionHash' for Log")
+	}
+	l.Address = *dec.Address
+	l.Topics = dec.Topics
+	l.Data = *dec.Data
+	l.BlockNumber = uint256.NewInt(uint64(dec.BlockNumber))
+	l.TxHash = *dec.TxHash
+	l.TxIndex = uint(dec.TxIndex)
+	l.BlockHash = dec.BlockHash
+	l.Index = uint(dec.Index)
+	l.Removed = dec.Removed
+	return nil
+}
+
 func (l *Log) ToProtoMessage() proto.Message {
 	return &types_pb.Log{
 		Address:     utils.ConvertAddressToH160(l.Address),
@@ -109,6 +177,7 @@ func (l *Logs) Unmarshal(data []byte) error {
 
 	body := make([]*Log, len(pb.Logs))
 	for i, p := range pb.Logs {
+		body[i] = new(Log)
 		if err := body[i].FromProtoMessage(p); nil != err {
 			return err
 		}
@@ -19,6 +19,7 @@ package common
 import (
 	"github.com/amazechain/amc/common/block"
 	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/modules/rawdb"
 	"github.com/amazechain/amc/modules/state"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
@@ -57,3 +58,18 @@ type ChainHighestBlock struct {
 type MinedEntireEvent struct {
 	Entire state.EntireCode
 }
+
+// StorageWatchHitsEvent is posted when watched storage slots change or such changes are unwound
+type StorageWatchHitsEvent struct{ Hits []*rawdb.WatchHit }
+
+// DropReasonExpired is the DroppedTxsEvent reason of transactions past their validity window
+const DropReasonExpired = "expired"
+
+// DroppedTxsEvent is posted when the tx pool evicts transactions
+type DroppedTxsEvent struct {
+	Txs    []*transaction.Transaction
+	Reason string
+}
+
+// MaintenanceEvent is posted when the node enters or leaves maintenance mode
+type MaintenanceEvent struct{ Enabled bool }
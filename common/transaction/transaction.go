@@ -84,8 +84,9 @@ func (s Transactions) EncodeIndex(i int, w *bytes.Buffer) {
 }
 
 type Transaction struct {
-	inner TxData    // Consensus contents of a transaction
-	time  time.Time // Time first seen locally (spam avoidance)
+	inner    TxData    // Consensus contents of a transaction
+	validity *Validity // Envelope extension, nil if absent
+	time     time.Time // Time first seen locally (spam avoidance)
 
 	// caches
 	hash atomic.Value
@@ -193,7 +194,9 @@ func FromProtoMessage(message proto.Message) (*Transaction, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewTx(inner), nil
+	tx := NewTx(inner)
+	tx.validity = validityFromProto(message.(*types_pb.Transaction))
+	return tx, nil
 }
 
 func (tx *Transaction) ToProtoMessage() proto.Message {
@@ -245,6 +248,7 @@ func (tx *Transaction) ToProtoMessage() proto.Message {
 	if nil != s {
 		pbTx.S = utils.ConvertUint256IntToH256(s)
 	}
+	tx.validity.toProto(&pbTx)
 
 	return &pbTx
 }
@@ -317,6 +321,7 @@ func (tx Transaction) Marshal() ([]byte, error) {
 	if nil != s {
 		pbTx.S = utils.ConvertUint256IntToH256(s)
 	}
+	tx.validity.toProto(&pbTx)
 	return proto.Marshal(&pbTx)
 }
 
@@ -345,9 +350,26 @@ func (tx *Transaction) Unmarshal(data []byte) error {
 	}
 
 	tx.setDecoded(inner, 0)
+	tx.validity = validityFromProto(&pbTx)
 	return err
 }
 
+// Validity returns the validity window extension, nil if the transaction has none.
+func (tx *Transaction) Validity() *Validity {
+	return tx.validity
+}
+
+// WithValidity returns a copy of the transaction carrying the given validity window.
+// The copy must be signed again, the window is part of the signed hash.
+func (tx *Transaction) WithValidity(v *Validity) *Transaction {
+	cpy := &Transaction{inner: tx.inner.copy(), time: tx.time}
+	if !v.empty() {
+		w := *v
+		cpy.validity = &w
+	}
+	return cpy
+}
+
 func (tx *Transaction) Type() uint8 {
 	return tx.inner.txType()
 }
@@ -434,7 +456,7 @@ func (tx *Transaction) Hash() types.Hash {
 	if hash := tx.hash.Load(); hash != nil {
 		return hash.(types.Hash)
 	}
-	h := tx.inner.hash()
+	h := withValidity(tx.inner.hash(), tx.validity)
 	tx.hash.Store(h)
 	return h
 }
@@ -531,7 +553,7 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	r1, _ := uint256.FromBig(r)
 	s1, _ := uint256.FromBig(s)
 	cpy.setSignatureValues(chainID, v1, r1, s1)
-	return &Transaction{inner: cpy, time: tx.time}, nil
+	return &Transaction{inner: cpy, validity: tx.validity, time: tx.time}, nil
 }
 
 //type Transaction struct {
@@ -198,7 +198,7 @@ func (s londonSigner) Hash(tx *Transaction) types.Hash {
 	if tx.Type() != DynamicFeeTxType {
 		return s.eip2930Signer.Hash(tx)
 	}
-	return utils.PrefixedRlpHash(
+	return withValidity(utils.PrefixedRlpHash(
 		tx.Type(),
 		[]interface{}{
 			s.chainId,
@@ -210,7 +210,7 @@ func (s londonSigner) Hash(tx *Transaction) types.Hash {
 			tx.Value(),
 			tx.Data(),
 			tx.AccessList(),
-		})
+		}), tx.validity)
 }
 
 type eip2930Signer struct{ EIP155Signer }
@@ -279,7 +279,7 @@ func (s eip2930Signer) SignatureValues(tx *Transaction, sig []byte) (R, S, V *bi
 func (s eip2930Signer) Hash(tx *Transaction) types.Hash {
 	switch tx.Type() {
 	case LegacyTxType:
-		return utils.RlpHash([]interface{}{
+		return withValidity(utils.RlpHash([]interface{}{
 			tx.Nonce(),
 			tx.GasPrice(),
 			tx.Gas(),
@@ -287,9 +287,9 @@ func (s eip2930Signer) Hash(tx *Transaction) types.Hash {
 			tx.Value(),
 			tx.Data(),
 			s.chainId, uint(0), uint(0),
-		})
+		}), tx.validity)
 	case AccessListTxType:
-		return utils.PrefixedRlpHash(
+		return withValidity(utils.PrefixedRlpHash(
 			tx.Type(),
 			[]interface{}{
 				s.chainId,
@@ -300,7 +300,7 @@ func (s eip2930Signer) Hash(tx *Transaction) types.Hash {
 				tx.Value(),
 				tx.Data(),
 				tx.AccessList(),
-			})
+			}), tx.validity)
 	default:
 		// This _should_ not happen, but in case someone sends in a bad
 		// json struct via RPC, it's probably more prudent to return an
@@ -373,7 +373,7 @@ func (s EIP155Signer) SignatureValues(tx *Transaction, sig []byte) (R, S, V *big
 // Hash returns the hash to be signed by the sender.
 // It does not uniquely identify the transaction.
 func (s EIP155Signer) Hash(tx *Transaction) types.Hash {
-	return utils.RlpHash([]interface{}{
+	return withValidity(utils.RlpHash([]interface{}{
 		tx.Nonce(),
 		tx.GasPrice(),
 		tx.Gas(),
@@ -381,7 +381,7 @@ func (s EIP155Signer) Hash(tx *Transaction) types.Hash {
 		tx.Value(),
 		tx.Data(),
 		s.chainId, uint(0), uint(0),
-	})
+	}), tx.validity)
 }
 
 // HomesteadTransaction implements TransactionInterface using the
@@ -443,14 +443,14 @@ func (fs FrontierSigner) SignatureValues(tx *Transaction, sig []byte) (r, s, v *
 // Hash returns the hash to be signed by the sender.
 // It does not uniquely identify the transaction.
 func (fs FrontierSigner) Hash(tx *Transaction) types.Hash {
-	return utils.RlpHash([]interface{}{
+	return withValidity(utils.RlpHash([]interface{}{
 		tx.Nonce(),
 		tx.GasPrice(),
 		tx.Gas(),
 		tx.To(),
 		tx.Value(),
 		tx.Data(),
-	})
+	}), tx.validity)
 }
 
 func decodeSignature(sig []byte) (r, s, v *big.Int) {
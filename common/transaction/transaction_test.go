@@ -22,6 +22,7 @@ import (
 	"github.com/amazechain/amc/common/types"
 	"github.com/holiman/uint256"
 	"github.com/libp2p/go-libp2p-core/crypto"
+	"math/big"
 	"testing"
 )
 
@@ -86,3 +87,60 @@ func TestNewDynamicTx(t *testing.T) {
 	//addr := types.PublicToAddress(pub)
 
 }
+
+func TestValidityExtension(t *testing.T) {
+	var addr types.Address
+	addr[0] = 1
+	tx := NewTransaction(1, addr, &addr, uint256.NewInt(10000), 21000, uint256.NewInt(10000000), []byte("hello"))
+	if tx.Validity() != nil {
+		t.Fatal("plain transaction has a validity window")
+	}
+	if tx.WithValidity(&Validity{}).Validity() != nil {
+		t.Fatal("empty validity window is kept")
+	}
+
+	vtx := tx.WithValidity(&Validity{ValidUntilBlock: 100, ValidUntilTime: 1700000000})
+	if vtx.Hash() == tx.Hash() {
+		t.Fatal("validity window is not covered by the hash")
+	}
+	signer := NewEIP155Signer(big.NewInt(1))
+	if signer.Hash(vtx) == signer.Hash(tx) {
+		t.Fatal("validity window is not covered by the signing hash")
+	}
+
+	b, err := vtx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := decoded.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if v := decoded.Validity(); v == nil || *v != *vtx.Validity() {
+		t.Fatalf("validity after unmarshal: have %+v, want %+v", v, vtx.Validity())
+	}
+	if decoded.Hash() != vtx.Hash() {
+		t.Fatalf("hash after unmarshal: have %x, want %x", decoded.Hash(), vtx.Hash())
+	}
+	fromProto, err := FromProtoMessage(vtx.ToProtoMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromProto.Hash() != vtx.Hash() {
+		t.Fatalf("hash after proto round trip: have %x, want %x", fromProto.Hash(), vtx.Hash())
+	}
+
+	for _, tc := range []struct {
+		number, time uint64
+		expired      bool
+	}{
+		{99, 1699999999, false},
+		{100, 1700000000, false},
+		{101, 1700000000, true},
+		{100, 1700000001, true},
+	} {
+		if have := vtx.Validity().Expired(tc.number, tc.time); have != tc.expired {
+			t.Fatalf("expired at block %d time %d: have %v, want %v", tc.number, tc.time, have, tc.expired)
+		}
+	}
+}
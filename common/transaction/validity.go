@@ -0,0 +1,67 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package transaction
+
+import (
+	"github.com/amazechain/amc/api/protocol/types_pb"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/utils"
+)
+
+// Validity is the envelope extension limiting which blocks may include a transaction.
+// A zero bound doesn't limit. The extension is covered by the signature and the tx hash.
+type Validity struct {
+	ValidUntilBlock uint64 // last block number which may include the transaction
+	ValidUntilTime  uint64 // last block timestamp which may include the transaction
+}
+
+// Expired reports whether a block with the given number and timestamp is past the window.
+func (v *Validity) Expired(number, time uint64) bool {
+	if v == nil {
+		return false
+	}
+	return (v.ValidUntilBlock != 0 && number > v.ValidUntilBlock) ||
+		(v.ValidUntilTime != 0 && time > v.ValidUntilTime)
+}
+
+func (v *Validity) empty() bool {
+	return v == nil || (v.ValidUntilBlock == 0 && v.ValidUntilTime == 0)
+}
+
+func validityFromProto(pbTx *types_pb.Transaction) *Validity {
+	v := &Validity{ValidUntilBlock: pbTx.ValidUntilBlock, ValidUntilTime: pbTx.ValidUntilTime}
+	if v.empty() {
+		return nil
+	}
+	return v
+}
+
+func (v *Validity) toProto(pbTx *types_pb.Transaction) {
+	if v == nil {
+		return
+	}
+	pbTx.ValidUntilBlock = v.ValidUntilBlock
+	pbTx.ValidUntilTime = v.ValidUntilTime
+}
+
+// withValidity mixes the extension into h. Transactions without it keep their hashes.
+func withValidity(h types.Hash, v *Validity) types.Hash {
+	if v == nil {
+		return h
+	}
+	return utils.RlpHash([]interface{}{h, v.ValidUntilBlock, v.ValidUntilTime})
+}
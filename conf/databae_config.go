@@ -16,6 +16,8 @@
 
 package conf
 
+import "time"
+
 type DatabaseConfig struct {
 	DBType     string   `json:"db_type" yaml:"db_type"`
 	DBPath     string   `json:"path" yaml:"path"`
@@ -25,4 +27,24 @@ type DatabaseConfig struct {
 	IsMem      bool     `json:"memory" yaml:"memory"`
 	MaxDB      uint64   `json:"max_db" yaml:"max_db"`
 	MaxReaders uint64   `json:"max_readers" yaml:"max_readers"`
+
+	// EventJournal appends chain events to a durable journal for external consumers.
+	EventJournal       bool          `json:"event_journal" yaml:"event_journal"`
+	EventJournalMaxAge time.Duration `json:"event_journal_max_age" yaml:"event_journal_max_age"`
+
+	// FeeAccounting indexes gas used, fees paid and tips received per address.
+	FeeAccounting bool `json:"fee_accounting" yaml:"fee_accounting"`
+
+	// AccessLists records the accounts and storage slots each block touched, kept AccessListRetention blocks, 0 keeps them.
+	AccessLists         bool   `json:"access_lists" yaml:"access_lists"`
+	AccessListRetention uint64 `json:"access_list_retention" yaml:"access_list_retention"`
+
+	// ForkRetention removes side chain blocks this many blocks below the head, 0 keeps them.
+	ForkRetention uint64 `json:"fork_retention" yaml:"fork_retention"`
+
+	// MigrationsApprove runs pending destructive migrations at startup without asking.
+	MigrationsApprove bool `json:"migrations_approve" yaml:"migrations_approve"`
+
+	// DiskGuardMargin pauses sync while the datadir volume would drop below this many MiB free, 0 disables.
+	DiskGuardMargin uint64 `json:"disk_guard_margin" yaml:"disk_guard_margin"`
 }
@@ -37,6 +37,16 @@ type NodeConfig struct {
 	DataDir     string `json:"data_dir" yaml:"data_dir"`
 	Miner       bool   `json:"miner" yaml:"miner"`
 
+	// RPCLogsLimit is the maximum number of logs eth_getLogs returns for a block range, 0 means unlimited.
+	RPCLogsLimit int `json:"rpc_logs_limit" yaml:"rpc_logs_limit"`
+	// RPCSlowQueries is the number of slowest RPC calls served by admin_slowQueries, 0 disables the log.
+	RPCSlowQueries int `json:"rpc_slow_queries" yaml:"rpc_slow_queries"`
+	// RPCSlowQueryParams keeps the sanitized parameters of slow RPC calls, which may identify users.
+	RPCSlowQueryParams bool `json:"rpc_slow_query_params" yaml:"rpc_slow_query_params"`
+
+	// DataDirTakeover asks a running process holding DataDir to shut down instead of failing to start.
+	DataDirTakeover bool `json:"data_dir_takeover" yaml:"data_dir_takeover"`
+
 	// KeyStoreDir is the file system folder that contains private keys. The directory can
 	// be specified as a relative path, in which case it is resolved relative to the
 	// current directory.
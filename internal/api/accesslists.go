@@ -0,0 +1,70 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+)
+
+// AccessListAPI serves the block access lists, see the db.accesslists flag.
+type AccessListAPI struct {
+	api *API
+}
+
+// NewAccessListAPI creates a new instance of AccessListAPI.
+func NewAccessListAPI(api *API) *AccessListAPI {
+	return &AccessListAPI{api: api}
+}
+
+// BlockAccessListResult is the access list of a block.
+type BlockAccessListResult struct {
+	BlockNumber hexutil.Uint64         `json:"blockNumber"`
+	BlockHash   types.Hash             `json:"blockHash"`
+	AccessList  transaction.AccessList `json:"accessList"`
+}
+
+// GetBlockAccessList returns the accounts and storage slots the block
+// touched, for executors to prefetch state before replaying it. Lists of
+// backfilled blocks only hold the accounts and slots the block changed or
+// called. Returns null for blocks without a recorded list.
+func (s *AccessListAPI) GetBlockAccessList(ctx context.Context, blockNrOrHash jsonrpc.BlockNumberOrHash) (*BlockAccessListResult, error) {
+	b, err := BlockByNumberOrHash(ctx, blockNrOrHash, s.api)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	list, err := rawdb.ReadBlockAccessList(tx, b.Number64().Uint64(), b.Hash())
+	if err != nil || list == nil {
+		return nil, err
+	}
+	return &BlockAccessListResult{
+		BlockNumber: hexutil.Uint64(b.Number64().Uint64()),
+		BlockHash:   b.Hash(),
+		AccessList:  list,
+	}, nil
+}
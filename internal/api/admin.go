@@ -0,0 +1,70 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amazechain/amc/internal/diskguard"
+	"github.com/amazechain/amc/internal/maintenance"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+)
+
+// AdminAPI offers node administration methods.
+type AdminAPI struct {
+	api *API
+}
+
+// NewAdminAPI creates a new instance of AdminAPI.
+func NewAdminAPI(api *API) *AdminAPI {
+	return &AdminAPI{api: api}
+}
+
+// Maintenance enters or leaves maintenance mode. While frozen the node
+// serves reads but halts sync, block production and transaction admission,
+// across restarts too. Entering waits for the chain write in progress, at
+// most maintenance.EnterTimeout. It returns whether the node is frozen.
+func (s *AdminAPI) Maintenance(ctx context.Context, enable bool) (bool, error) {
+	m := maintenance.Of(s.api.BlockChain())
+	if m == nil {
+		return false, errors.New("maintenance mode is not supported by the blockchain")
+	}
+	var err error
+	if enable {
+		err = m.Enter(ctx)
+	} else {
+		err = m.Exit(ctx)
+	}
+	return m.Enabled(), err
+}
+
+// DiskSpace reports the free space of the watched volumes, the predicted
+// growth and whether sync is paused to keep the disk from filling up.
+func (s *AdminAPI) DiskSpace() (diskguard.Status, error) {
+	g := diskguard.Of(s.api.BlockChain())
+	if g == nil {
+		return diskguard.Status{}, errors.New("disk guard is not enabled")
+	}
+	return g.Status(), nil
+}
+
+// SlowQueries returns the slowest RPC calls served, slowest first, with the kv
+// reads each made. Parameters are only included with rpc.slowqueries.params.
+func (s *AdminAPI) SlowQueries() []jsonrpc.SlowQuery {
+	return jsonrpc.DefaultSlowQueryLog().Queries()
+}
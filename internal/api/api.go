@@ -49,6 +49,7 @@ import (
 	mvm_common "github.com/amazechain/amc/internal/avm/common"
 	mvm_types "github.com/amazechain/amc/internal/avm/types"
 	"github.com/amazechain/amc/internal/consensus"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/log"
 	"github.com/amazechain/amc/modules/rawdb"
 	"github.com/amazechain/amc/modules/rpc/jsonrpc"
@@ -78,6 +79,8 @@ type API struct {
 	chainConfig    *params.ChainConfig
 
 	gpo *Oracle
+
+	logsLimit int
 }
 
 // NewAPI creates a new protocol API.
@@ -100,8 +103,14 @@ func (api *API) SetGpo(gpo *Oracle) {
 	api.gpo = gpo
 }
 
+// SetLogsLimit sets the maximum number of logs a range query of eth_getLogs returns.
+func (api *API) SetLogsLimit(limit int) {
+	api.logsLimit = limit
+}
+
 func (api *API) Apis() []jsonrpc.API {
 	nonceLock := new(AddrLocker)
+	filterAPI := filters.NewFilterAPI(api, 5*time.Minute, api.logsLimit)
 	return []jsonrpc.API{
 		{
 			Namespace: "eth",
@@ -126,9 +135,33 @@ func (api *API) Apis() []jsonrpc.API {
 		{
 			Namespace: "txpool",
 			Service:   NewTxsPoolAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewPruneAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewDiagnosticsAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewEventJournalAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewStorageWatchAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewFeeAccountingAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewAccessListAPI(api),
+		}, {
+			Namespace: "amc",
+			Service:   NewBalancesAPI(api),
+		}, {
+			Namespace: "admin",
+			Service:   NewAdminAPI(api),
 		}, {
 			Namespace: "eth",
-			Service:   filters.NewFilterAPI(api, 5*time.Minute),
+			Service:   filterAPI,
 		},
 	}
 }
@@ -185,7 +218,8 @@ func (n *API) State(tx kv.Tx, blockNrOrHash jsonrpc.BlockNumberOrHash) evmtypes.
 	}
 
 	stateReader := state.NewPlainState(tx, *blockNr+1)
-	return state.New(stateReader)
+	stateReader.SetHistoryCache(state.DefaultHistoryCache)
+	return state.New(state.NewCodeCachingReader(stateReader, state.DefaultCodeCache.Consumer("rpc")))
 }
 
 func (n *API) GetChainConfig() *params.ChainConfig {
@@ -414,7 +448,8 @@ func (s *BlockChainAPI) GetStorageAt(ctx context.Context, address types.Address,
 	var va uint256.Int
 	k := types.HexToHash(key)
 	state.GetState(address, &k, &va)
-	return va.Bytes(), nil
+	word := va.Bytes32()
+	return word[:], nil
 }
 
 // GetUncleCountByBlockHash returns number of uncles in the block for the given block hash
@@ -557,11 +592,9 @@ func (diff *BlockOverrides) Apply(blockCtx *evmtypes.BlockContext) {
 	}
 }
 
-func DoCall(ctx context.Context, api *API, args TransactionArgs, blockNrOrHash jsonrpc.BlockNumberOrHash, overrides *StateOverride, timeout time.Duration, globalGasCap uint64) (*internal.ExecutionResult, error) {
-	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
-
-	// header := api.BlockChain().CurrentBlock().Header()
-	//state := api.BlockChain().StateAt(header.Hash()).(*statedb.StateDB)
+// callHeader resolves the header a call is executed on top of; pending and
+// latest both resolve to the current head.
+func callHeader(api *API, blockNrOrHash jsonrpc.BlockNumberOrHash) (block.IHeader, error) {
 	var header block.IHeader
 	var err error
 	if blockNr, ok := blockNrOrHash.Number(); ok {
@@ -577,6 +610,21 @@ func DoCall(ctx context.Context, api *API, args TransactionArgs, blockNrOrHash j
 	if err != nil {
 		return nil, err
 	}
+	if header == nil {
+		return nil, errors.New("header not found")
+	}
+	return header, nil
+}
+
+func DoCall(ctx context.Context, api *API, args TransactionArgs, blockNrOrHash jsonrpc.BlockNumberOrHash, overrides *StateOverride, timeout time.Duration, globalGasCap uint64) (*internal.ExecutionResult, error) {
+	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
+
+	// header := api.BlockChain().CurrentBlock().Header()
+	//state := api.BlockChain().StateAt(header.Hash()).(*statedb.StateDB)
+	header, err := callHeader(api, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
 	//state := api.State(blockNrOrHash).(*statedb.StateDB)
 	tx, err := api.db.BeginRo(ctx)
 	if nil != err {
@@ -784,30 +832,25 @@ func BlockByNumberOrHash(ctx context.Context, blockNrOrHash jsonrpc.BlockNumberO
 //	return nil, nil, errors.New("invalid arguments; neither block nor hash specified")
 //}
 
-func DoEstimateGas(ctx context.Context, n *API, args TransactionArgs, blockNrOrHash jsonrpc.BlockNumberOrHash, gasCap uint64) (hexutil.Uint64, error) {
+func DoEstimateGas(ctx context.Context, n *API, args TransactionArgs, blockNrOrHash jsonrpc.BlockNumberOrHash, overrides *StateOverride, gasCap uint64) (hexutil.Uint64, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var (
-		lo  = params.TxGas - 1
-		hi  uint64
-		cap uint64
+		lo = params.TxGas - 1
+		hi uint64
 	)
 	// Use zero address if sender unspecified.
 	if args.From == nil {
 		args.From = new(mvm_common.Address)
 	}
+	header, err := callHeader(n, blockNrOrHash)
+	if err != nil {
+		return 0, err
+	}
 	// Determine the highest gas limit can be used during the estimation.
 	if args.Gas != nil && uint64(*args.Gas) >= params.TxGas {
 		hi = uint64(*args.Gas)
 	} else {
-		// Retrieve the block to act as the gas ceiling
-		iblock, err := BlockByNumberOrHash(ctx, blockNrOrHash, n)
-		if err != nil {
-			return 0, err
-		}
-		if iblock == nil {
-			return 0, errors.New("block not found")
-		}
-		hi = iblock.GasLimit()
+		hi = header.(*block.Header).GasLimit
 	}
 
 	var feeCap *big.Int
@@ -820,18 +863,25 @@ func DoEstimateGas(ctx context.Context, n *API, args TransactionArgs, blockNrOrH
 	} else {
 		feeCap = common.Big0
 	}
+
+	// Every execution below runs against the same state, reverted to this
+	// pre-state after each attempt instead of being rebuilt.
+	tx, err := n.db.BeginRo(ctx)
+	if nil != err {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ibs := n.State(tx, blockNrOrHash)
+	if ibs == nil {
+		return 0, errors.New("cannot load stateDB")
+	}
+	if err := overrides.Apply(ibs.(*state.IntraBlockState)); err != nil {
+		return 0, err
+	}
+
 	// Recap the highest gas limit with account's available balance.
 	if feeCap.BitLen() != 0 {
-		tx, err := n.db.BeginRo(ctx)
-		if nil != err {
-			return 0, err
-		}
-		defer tx.Rollback()
-		statedb := n.State(tx, blockNrOrHash)
-		if statedb == nil {
-			return 0, errors.New("cannot load stateDB")
-		}
-		balance := statedb.GetBalance(*mvm_types.ToAmcAddress(args.From)) // from
+		balance := ibs.GetBalance(*mvm_types.ToAmcAddress(args.From)) // from
 
 		// can't be nil
 		available := new(big.Int).Set(balance.ToBig())
@@ -859,66 +909,64 @@ func DoEstimateGas(ctx context.Context, n *API, args TransactionArgs, blockNrOrH
 		log.Warn("Caller gas above allowance, capping", "requested", hi, "cap", gasCap)
 		hi = gasCap
 	}
-	cap = hi
 
-	// Create a helper to check if a gas allowance results in an executable transaction
-	executable := func(gas uint64) (bool, *internal.ExecutionResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	run := func(gas uint64) (*internal.ExecutionResult, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		args.Gas = (*hexutil.Uint64)(&gas)
-		result, err := DoCall(ctx, n, args, blockNrOrHash, nil, 0, gasCap)
+		msg, err := args.ToMessage(gasCap, header.BaseFee64().ToBig())
 		if err != nil {
-			if errors.Is(err, internal.ErrIntrinsicGas) {
-				return true, nil, nil // Special case, raise gas limit
-			}
-			return true, nil, err // Bail out
+			return nil, err
 		}
-		return result.Failed(), result, nil
-	}
-	// Execute the binary search and hone in on an executable gas limit
-	for lo+1 < hi {
-		mid := (hi + lo) / 2
-		failed, _, err := executable(mid)
+		snap := ibs.Snapshot()
+		defer ibs.RevertToSnapshot(snap)
 
-		// If the error is not nil(consensus error), it means the provided message
-		// call or transaction will never be accepted no matter how much gas it is
-		// assigened. Return the error directly, don't struggle any more.
+		evm, vmError, err := n.GetEvm(ctx, msg, ibs, header, &vm2.Config{NoBaseFee: true})
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		if failed {
-			lo = mid
-		} else {
-			hi = mid
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				evm.Cancel()
+			case <-done:
+			}
+		}()
+		gp := new(common.GasPool).AddGas(math.MaxUint64)
+		result, err := internal.ApplyMessage(evm, msg, gp, true, false)
+		if err := vmError(); err != nil {
+			return nil, err
 		}
-	}
-	// Reject the transaction as invalid if it still fails at the highest allowance
-	if hi == cap {
-		failed, result, err := executable(hi)
-		if err != nil {
-			return 0, err
+		if evm.Cancelled() {
+			return nil, errors.New("execution aborted")
 		}
-		if failed {
-			if result != nil && !errors.Is(result.Err, vm2.ErrOutOfGas) {
-				if len(result.Revert()) > 0 {
-					return 0, newRevertError(result)
-				}
-				return 0, result.Err
-			}
-			// Otherwise, the specified gas cap is too low
-			return 0, fmt.Errorf("gas required exceeds allowance (%d)", cap)
+		if err != nil {
+			return nil, fmt.Errorf("err: %w (supplied gas %d)", err, msg.Gas())
 		}
+		return result, nil
+	}
+	gas, iterations, err := searchGasLimit(lo, hi, run)
+	estimateGasIterations.Update(int64(iterations))
+	if err != nil {
+		return 0, err
 	}
-	return hexutil.Uint64(hi), nil
-	//return hexutil.Uint64(baseFee), nil
+	return hexutil.Uint64(gas), nil
 }
 
 // EstimateGas returns an estimate of the amount of gas needed to execute the
 // given transaction against the current pending block.
-func (s *BlockChainAPI) EstimateGas(ctx context.Context, args TransactionArgs, blockNrOrHash *jsonrpc.BlockNumberOrHash) (hexutil.Uint64, error) {
+func (s *BlockChainAPI) EstimateGas(ctx context.Context, args TransactionArgs, blockNrOrHash *jsonrpc.BlockNumberOrHash, overrides *StateOverride) (hexutil.Uint64, error) {
 	bNrOrHash := jsonrpc.BlockNumberOrHashWithNumber(jsonrpc.PendingBlockNumber)
 	if blockNrOrHash != nil {
 		bNrOrHash = *blockNrOrHash
 	}
-	return DoEstimateGas(ctx, s.api, args, bNrOrHash, rpcGasCap)
+	return DoEstimateGas(ctx, s.api, args, bNrOrHash, overrides, rpcGasCap)
 }
 
 // GetBlockByNumber returns the requested canonical block.
@@ -956,12 +1004,11 @@ func (s *BlockChainAPI) GetBlockByNumber(ctx context.Context, number jsonrpc.Blo
 
 // GetBlockByHash get block by hash
 func (s *BlockChainAPI) GetBlockByHash(ctx context.Context, hash mvm_common.Hash, fullTx bool) (map[string]interface{}, error) {
-	block, err := s.api.BlockChain().GetBlockByHash(mvm_types.ToAmcHash(hash))
-
-	if block != nil {
+	// unknown blocks are null, not an error
+	if block, _ := s.api.BlockChain().GetBlockByHash(mvm_types.ToAmcHash(hash)); block != nil {
 		return RPCMarshalBlock(block, s.api.BlockChain(), true, fullTx)
 	}
-	return nil, err
+	return nil, nil
 }
 
 func (s *BlockChainAPI) MinedBlock(ctx context.Context, address types.Address) (*jsonrpc.Subscription, error) {
@@ -1218,6 +1265,9 @@ func (s *TransactionAPI) GetTransactionByBlockHashAndIndex(ctx context.Context,
 
 // SubmitTransaction ?
 func SubmitTransaction(ctx context.Context, api *API, tx *transaction.Transaction) (mvm_common.Hash, error) {
+	if maintenance.Of(api.BlockChain()).Enabled() {
+		return mvm_common.Hash{}, maintenance.ErrMaintenance
+	}
 
 	if err := checkTxFee(*tx.GasPrice(), tx.Gas(), baseFee); err != nil {
 		return mvm_common.Hash{}, err
@@ -0,0 +1,129 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/amazechain/amc/modules/state"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// maxBalancesAddresses is the most addresses one GetBalances call reads.
+const maxBalancesAddresses = 10000
+
+// BalancesAPI serves balances of many addresses read from one state snapshot.
+type BalancesAPI struct {
+	api *API
+}
+
+// NewBalancesAPI creates a new instance of BalancesAPI.
+func NewBalancesAPI(api *API) *BalancesAPI {
+	return &BalancesAPI{api: api}
+}
+
+// BalancesResult is the balances of the requested addresses after block
+// BlockHash, in request order.
+type BalancesResult struct {
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   types.Hash     `json:"blockHash"`
+	Balances    []*hexutil.Big `json:"balances"`
+}
+
+// GetBalances returns the balances of addresses after one block. The block
+// tag is resolved once, in the same read transaction the balances are read
+// from, so every balance belongs to the returned block even while the head
+// moves. Latest and pending both resolve to the head block, there is no
+// pending state.
+func (s *BalancesAPI) GetBalances(ctx context.Context, addresses []types.Address, blockNrOrHash jsonrpc.BlockNumberOrHash) (*BalancesResult, error) {
+	if len(addresses) > maxBalancesAddresses {
+		return nil, fmt.Errorf("too many addresses %d, max %d", len(addresses), maxBalancesAddresses)
+	}
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	number, hash, err := snapshotBlock(tx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	// state after block number is the state as of the start of the next one
+	reader := state.NewPlainState(tx, number+1)
+	reader.SetHistoryCache(state.DefaultHistoryCache)
+	balances, err := reader.ReadBalances(addresses)
+	if err != nil {
+		return nil, err
+	}
+	res := &BalancesResult{BlockNumber: hexutil.Uint64(number), BlockHash: hash, Balances: make([]*hexutil.Big, len(balances))}
+	for i, b := range balances {
+		res.Balances[i] = (*hexutil.Big)(b.ToBig())
+	}
+	return res, nil
+}
+
+// snapshotBlock resolves blockNrOrHash to a block number and hash within tx.
+// The head is read from tx rather than the blockchain, which may already
+// have moved past the state tx sees.
+func snapshotBlock(tx kv.Tx, blockNrOrHash jsonrpc.BlockNumberOrHash) (uint64, types.Hash, error) {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		number := rawdb.ReadHeaderNumber(tx, hash)
+		if number == nil {
+			return 0, types.Hash{}, fmt.Errorf("block %x not found", hash)
+		}
+		if blockNrOrHash.RequireCanonical {
+			canonical, err := rawdb.ReadCanonicalHash(tx, *number)
+			if err != nil {
+				return 0, types.Hash{}, err
+			}
+			if canonical != hash {
+				return 0, types.Hash{}, fmt.Errorf("block %x is not canonical", hash)
+			}
+		}
+		return *number, hash, nil
+	}
+
+	blockNr, ok := blockNrOrHash.Number()
+	if !ok {
+		blockNr = jsonrpc.LatestBlockNumber
+	}
+	switch blockNr {
+	case jsonrpc.LatestBlockNumber, jsonrpc.PendingBlockNumber:
+		hash := rawdb.ReadHeadBlockHash(tx)
+		number := rawdb.ReadHeaderNumber(tx, hash)
+		if number == nil {
+			return 0, types.Hash{}, fmt.Errorf("head block %x not found", hash)
+		}
+		return *number, hash, nil
+	case jsonrpc.SafeBlockNumber, jsonrpc.FinalizedBlockNumber:
+		return 0, types.Hash{}, fmt.Errorf("block tag %d is not supported", blockNr)
+	}
+	hash, err := rawdb.ReadCanonicalHash(tx, uint64(blockNr))
+	if err != nil {
+		return 0, types.Hash{}, err
+	}
+	if hash == (types.Hash{}) {
+		return 0, types.Hash{}, fmt.Errorf("block %d not found", blockNr)
+	}
+	return uint64(blockNr), hash, nil
+}
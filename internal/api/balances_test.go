@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/amazechain/amc/modules/state"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+const balancesTestAddresses = 5000
+
+func openBalancesDB(t testing.TB) kv.RwDB {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(t.TempDir()).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func balancesTestHash(n uint64) types.Hash {
+	return types.Hash{byte(n), byte(n >> 8), 0x99}
+}
+
+// writeBalancesBlock sets every balance to n+1 in block n, then moves the
+// head to n in a separate tx, like the blockchain does.
+func writeBalancesBlock(t testing.TB, db kv.RwDB, addrs []types.Address, n uint64) {
+	err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		w := state.NewPlainStateWriter(tx, tx, n)
+		for _, addr := range addrs {
+			original := new(account.StateAccount)
+			if n > 0 {
+				original.Initialised = true
+				original.Balance.SetUint64(n)
+			}
+			acc := &account.StateAccount{Initialised: true}
+			acc.Balance.SetUint64(n + 1)
+			if err := w.UpdateAccountData(addr, original, acc); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteChangeSets(); err != nil {
+			return err
+		}
+		return w.WriteHistory()
+	})
+	if err != nil {
+		t.Fatalf("write state %d: %v", n, err)
+	}
+	err = db.Update(context.Background(), func(tx kv.RwTx) error {
+		hash := balancesTestHash(n)
+		if err := rawdb.WriteHeaderNumber(tx, hash, n); err != nil {
+			return err
+		}
+		if err := rawdb.WriteCanonicalHash(tx, hash, n); err != nil {
+			return err
+		}
+		rawdb.WriteHeadBlockHash(tx, hash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("write head %d: %v", n, err)
+	}
+}
+
+func balancesTestAddrs() []types.Address {
+	addrs := make([]types.Address, balancesTestAddresses)
+	for i := range addrs {
+		// reverse order, so the reader has to sort
+		j := balancesTestAddresses - i
+		addrs[i] = types.Address{byte(j >> 8), byte(j), 0x42}
+	}
+	return addrs
+}
+
+func checkBalances(t *testing.T, res *BalancesResult, number uint64) {
+	t.Helper()
+	if uint64(res.BlockNumber) != number || res.BlockHash != balancesTestHash(number) {
+		t.Fatalf("block = %d %x, want %d %x", res.BlockNumber, res.BlockHash, number, balancesTestHash(number))
+	}
+	if len(res.Balances) != balancesTestAddresses {
+		t.Fatalf("balances = %d, want %d", len(res.Balances), balancesTestAddresses)
+	}
+	for i, b := range res.Balances {
+		if b.ToInt().Uint64() != number+1 {
+			t.Fatalf("block %d: balance %d = %d, want %d", number, i, b.ToInt().Uint64(), number+1)
+		}
+	}
+}
+
+func TestGetBalances(t *testing.T) {
+	db := openBalancesDB(t)
+	addrs := balancesTestAddrs()
+	writeBalancesBlock(t, db, addrs, 0)
+	api := NewBalancesAPI(&API{db: db})
+	ctx := context.Background()
+
+	const blocks = 8
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := uint64(1); n <= blocks; n++ {
+			writeBalancesBlock(t, db, addrs, n)
+		}
+	}()
+	latest := jsonrpc.BlockNumberOrHashWithNumber(jsonrpc.LatestBlockNumber)
+	for last := uint64(0); last < blocks; {
+		res, err := api.GetBalances(ctx, addrs, latest)
+		if err != nil {
+			t.Fatalf("latest: %v", err)
+		}
+		if uint64(res.BlockNumber) < last {
+			t.Fatalf("head went back from %d to %d", last, res.BlockNumber)
+		}
+		last = uint64(res.BlockNumber)
+		checkBalances(t, res, last)
+	}
+	wg.Wait()
+
+	res, err := api.GetBalances(ctx, addrs, jsonrpc.BlockNumberOrHashWithNumber(3))
+	if err != nil {
+		t.Fatalf("number: %v", err)
+	}
+	checkBalances(t, res, 3)
+	res, err = api.GetBalances(ctx, addrs, jsonrpc.BlockNumberOrHashWithHash(balancesTestHash(5), true))
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	checkBalances(t, res, 5)
+
+	missing := []types.Address{{0xde, 0xad}}
+	res, err = api.GetBalances(ctx, missing, latest)
+	if err != nil || res.Balances[0].ToInt().Sign() != 0 {
+		t.Fatalf("missing account = %v, %v", res, err)
+	}
+	if _, err := api.GetBalances(ctx, addrs, jsonrpc.BlockNumberOrHashWithNumber(blocks+1)); err == nil {
+		t.Fatalf("future block: no error")
+	}
+	if _, err := api.GetBalances(ctx, make([]types.Address, maxBalancesAddresses+1), latest); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Fatalf("over limit = %v", err)
+	}
+}
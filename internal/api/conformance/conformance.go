@@ -0,0 +1,287 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package conformance checks the JSON-RPC responses of a node against a table of
+// expected responses, so that field formats web3 libraries depend on do not regress.
+//
+// Expected responses are JSON documents compared structurally with the actual ones:
+//
+//   - strings like "<quantity>" are matchers for volatile values, see matchers. Alternatives
+//     are separated by "|", e.g. "<address|null>";
+//   - objects must have exactly the expected fields, a field ending with "?" may be absent
+//     but must match if present. null and an absent field are different;
+//   - an array ["<each>", x] matches arrays of any length whose elements all match x,
+//     other arrays must match element by element;
+//   - everything else must be equal.
+//
+// Params and expected responses may refer to values of the Fixture as {{name}}.
+//
+// Every method of a namespace needs at least one case, adding a method means adding
+// its cases, see TestEthCoverage.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+)
+
+// ErrNeedsFixture is returned for cases referring to values the fixture does not have.
+var ErrNeedsFixture = errors.New("fixture value missing")
+
+var matchers = map[string]func(v interface{}) bool{
+	"quantity": hexString(`^0x(0|[1-9a-f][0-9a-f]*)$`),
+	"hash":     hexString(`^0x[0-9a-f]{64}$`),
+	"address":  hexString(`^0x[0-9a-fA-F]{40}$`),
+	"data":     hexString(`^0x([0-9a-f]{2})*$`),
+	"bloom":    hexString(`^0x[0-9a-f]{512}$`),
+	"nonce":    hexString(`^0x[0-9a-f]{16}$`),
+	"id":       hexString(`^0x[0-9a-f]+$`),
+	"number":   func(v interface{}) bool { _, ok := v.(float64); return ok },
+	"bool":     func(v interface{}) bool { _, ok := v.(bool); return ok },
+	"string":   func(v interface{}) bool { _, ok := v.(string); return ok },
+	"null":     func(v interface{}) bool { return v == nil },
+	"any":      func(v interface{}) bool { return true },
+}
+
+func hexString(expr string) func(v interface{}) bool {
+	re := regexp.MustCompile(expr)
+	return func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && re.MatchString(s)
+	}
+}
+
+// Caller is the part of *jsonrpc.Client the runner uses.
+type Caller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// ErrorSpec is an expected error response. Code 0 matches any code and an empty
+// Message any message, otherwise the message must contain it.
+type ErrorSpec struct {
+	Code    int
+	Message string
+}
+
+// Case is a request and its expected response.
+type Case struct {
+	Name   string     // unique name, shown on failures
+	Method string     // e.g. eth_getBalance
+	Params string     // JSON array of the params, "" for none
+	Result string     // expected result, unused if Error is set
+	Error  *ErrorSpec // expected error
+	Save   string     // stores a string result in the fixture under this name for later cases
+	// Pending describes a known deviation from the expected response. The case is
+	// still run and its diff reported, but it does not fail.
+	Pending string
+}
+
+// Fixture holds the values of the chain the cases refer to, see Discover.
+type Fixture map[string]string
+
+var placeholder = regexp.MustCompile(`{{(\w+)}}`)
+
+// Expand replaces the {{name}} references of s by fixture values.
+func (fx Fixture) Expand(s string) (string, error) {
+	var missing []string
+	res := placeholder.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-2]
+		v, ok := fx[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: %s", ErrNeedsFixture, strings.Join(missing, ", "))
+	}
+	return res, nil
+}
+
+// Check runs the case and returns the differences from the expected response, nil
+// if the response conforms. Errors are failures to run the case.
+func Check(ctx context.Context, c Caller, fx Fixture, tc Case) ([]string, error) {
+	params, err := fx.Expand(tc.Params)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	if params != "" {
+		var raw []json.RawMessage
+		if err := json.Unmarshal([]byte(params), &raw); err != nil {
+			return nil, fmt.Errorf("bad params of %s: %v", tc.Name, err)
+		}
+		for _, p := range raw {
+			args = append(args, p)
+		}
+	}
+
+	var res json.RawMessage
+	callErr := c.CallContext(ctx, &res, tc.Method, args...)
+	if tc.Error != nil {
+		return checkError(callErr, res, *tc.Error), nil
+	}
+	if callErr != nil {
+		var rpcErr jsonrpc.Error
+		if !errors.As(callErr, &rpcErr) {
+			return nil, callErr
+		}
+		return []string{fmt.Sprintf("result: have error %d %q, want %s", rpcErr.ErrorCode(), callErr.Error(), tc.Result)}, nil
+	}
+
+	expected, err := fx.Expand(tc.Result)
+	if err != nil {
+		return nil, err
+	}
+	var want, have interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		return nil, fmt.Errorf("bad result of %s: %v", tc.Name, err)
+	}
+	if len(res) == 0 {
+		res = json.RawMessage("null")
+	}
+	if err := json.Unmarshal(res, &have); err != nil {
+		return []string{fmt.Sprintf("result: invalid JSON %s", res)}, nil
+	}
+	diffs := Compare("result", have, want)
+	if len(diffs) == 0 && tc.Save != "" {
+		s, ok := have.(string)
+		if !ok {
+			return []string{fmt.Sprintf("result: have %s, want a string to save as %s", res, tc.Save)}, nil
+		}
+		fx[tc.Save] = s
+	}
+	return diffs, nil
+}
+
+func checkError(err error, res json.RawMessage, want ErrorSpec) []string {
+	if err == nil {
+		return []string{fmt.Sprintf("error: have result %s, want error %d", res, want.Code)}
+	}
+	var rpcErr jsonrpc.Error
+	if !errors.As(err, &rpcErr) {
+		return []string{fmt.Sprintf("error: have %v, want a JSON-RPC error", err)}
+	}
+	var diffs []string
+	if want.Code != 0 && rpcErr.ErrorCode() != want.Code {
+		diffs = append(diffs, fmt.Sprintf("error.code: have %d, want %d", rpcErr.ErrorCode(), want.Code))
+	}
+	if !strings.Contains(err.Error(), want.Message) {
+		diffs = append(diffs, fmt.Sprintf("error.message: have %q, want it to contain %q", err.Error(), want.Message))
+	}
+	return diffs
+}
+
+// Compare returns the differences of the decoded JSON value have from the expected
+// value want, one line per mismatching path.
+func Compare(path string, have, want interface{}) []string {
+	switch w := want.(type) {
+	case string:
+		if len(w) > 2 && w[0] == '<' && w[len(w)-1] == '>' {
+			for _, name := range strings.Split(w[1:len(w)-1], "|") {
+				match, ok := matchers[name]
+				if !ok {
+					return []string{fmt.Sprintf("%s: unknown matcher %s", path, name)}
+				}
+				if match(have) {
+					return nil
+				}
+			}
+			return []string{fmt.Sprintf("%s: have %s, want %s", path, show(have), w)}
+		}
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: have %s, want an object", path, show(have))}
+		}
+		return compareObject(path, h, w)
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: have %s, want an array", path, show(have))}
+		}
+		if len(w) == 2 && w[0] == "<each>" {
+			var diffs []string
+			for i := range h {
+				diffs = append(diffs, Compare(fmt.Sprintf("%s[%d]", path, i), h[i], w[1])...)
+			}
+			return diffs
+		}
+		if len(h) != len(w) {
+			return []string{fmt.Sprintf("%s: have %d elements, want %d", path, len(h), len(w))}
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, Compare(fmt.Sprintf("%s[%d]", path, i), h[i], w[i])...)
+		}
+		return diffs
+	}
+	if !reflect.DeepEqual(have, want) {
+		return []string{fmt.Sprintf("%s: have %s, want %s", path, show(have), show(want))}
+	}
+	return nil
+}
+
+func compareObject(path string, have, want map[string]interface{}) []string {
+	var diffs []string
+	expected := make(map[string]bool, len(want))
+	for key, w := range want {
+		name := strings.TrimSuffix(key, "?")
+		expected[name] = true
+		h, ok := have[name]
+		if !ok {
+			if name == key {
+				diffs = append(diffs, fmt.Sprintf("%s.%s: missing, want %s", path, name, show(w)))
+			}
+			continue
+		}
+		diffs = append(diffs, Compare(path+"."+name, h, w)...)
+	}
+	for name, h := range have {
+		if !expected[name] {
+			diffs = append(diffs, fmt.Sprintf("%s.%s: unexpected field %s", path, name, show(h)))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// show prints a value briefly, diffs of large objects only print their type
+func show(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	}
+	if s, ok := v.(string); ok {
+		if len(s) > 70 {
+			return fmt.Sprintf("%q (%d chars)", s[:66]+"...", len(s))
+		}
+		return strconv.Quote(s)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
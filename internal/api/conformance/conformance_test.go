@@ -0,0 +1,242 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/api"
+	"github.com/amazechain/amc/internal/api/filters"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/amazechain/amc/params"
+	"github.com/amazechain/amc/tests/devnode"
+	"github.com/holiman/uint256"
+)
+
+// TestEthConformance runs the eth cases against an in-process dev node, see chainNode, or
+// against the node at AMC_CONFORMANCE_URL if it is set. AMC_CONFORMANCE_FIXTURE may hold a
+// JSON object of extra fixture values, such as prunedBlock and prunedTx.
+func TestEthConformance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var client *jsonrpc.Client
+	if url := os.Getenv("AMC_CONFORMANCE_URL"); url != "" {
+		var err error
+		if client, err = jsonrpc.DialContext(ctx, url); err != nil {
+			t.Fatalf("dial %s: %v", url, err)
+		}
+	} else {
+		client = chainNode(t).Client()
+	}
+	defer client.Close()
+
+	fx, err := Discover(ctx, client)
+	if err != nil {
+		t.Fatalf("discover fixture: %v", err)
+	}
+	if extra := os.Getenv("AMC_CONFORMANCE_FIXTURE"); extra != "" {
+		if err := json.Unmarshal([]byte(extra), &fx); err != nil {
+			t.Fatalf("AMC_CONFORMANCE_FIXTURE: %v", err)
+		}
+	}
+	t.Logf("fixture %v", fx)
+
+	for _, tc := range EthCases() {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			diffs, err := Check(ctx, client, fx, tc)
+			if errors.Is(err, ErrNeedsFixture) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diffs) == 0 {
+				return
+			}
+			if tc.Pending != "" {
+				t.Skipf("pending: %s\n%s", tc.Pending, strings.Join(diffs, "\n"))
+			}
+			t.Fatalf("%s %s\n%s", tc.Method, tc.Params, strings.Join(diffs, "\n"))
+		})
+	}
+}
+
+// logCode is the runtime code of the contract chainNode deploys, it emits LOG1 with topic
+// 0x2a and the word 0x2a as data.
+var logCode = []byte{0x60, 0x2a, 0x60, 0x00, 0x52, 0x60, 0x2a, 0x60, 0x20, 0x60, 0x00, 0xa1, 0x00}
+
+// chainNode boots a dev node with two blocks: the first deploys the logCode contract, the
+// second calls it and carries a legacy and an access list transfer, so that Discover picks
+// a block with logs and every transaction type.
+func chainNode(t *testing.T) *devnode.Node {
+	n := devnode.New(t)
+	chainID := uint256.NewInt(devnode.Config.ChainID.Uint64())
+	feeCap, tip := uint256.NewInt(100*params.GWei), uint256.NewInt(params.GWei)
+	to := types.Address{0xbb}
+	// CODECOPY(0, 12, len) RETURN(0, len), followed by the runtime code
+	initCode := append([]byte{0x60, byte(len(logCode)), 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, byte(len(logCode)), 0x60, 0x00, 0xf3}, logCode...)
+	contract := crypto.CreateAddress(devnode.Address, 0)
+
+	blocks := [][]transaction.TxData{
+		{
+			&transaction.DynamicFeeTx{ChainID: chainID, Nonce: 0, GasTipCap: tip, GasFeeCap: feeCap, Gas: 100_000, Data: initCode},
+		},
+		{
+			&transaction.DynamicFeeTx{ChainID: chainID, Nonce: 1, GasTipCap: tip, GasFeeCap: feeCap, Gas: 50_000, To: &contract},
+			&transaction.LegacyTx{Nonce: 2, GasPrice: feeCap, Gas: 21_000, To: &to, Value: uint256.NewInt(1)},
+			&transaction.AccessListTx{ChainID: chainID, Nonce: 3, GasPrice: feeCap, Gas: 30_000, To: &to, Value: uint256.NewInt(1),
+				AccessList: transaction.AccessList{{Address: contract, StorageKeys: []types.Hash{{}}}}},
+		},
+	}
+	for i, inners := range blocks {
+		txs := make([]*transaction.Transaction, len(inners))
+		for j, inner := range inners {
+			var err error
+			if txs[j], err = devnode.Sign(inner); err != nil {
+				t.Fatalf("block %d tx %d: %v", i+1, j, err)
+			}
+		}
+		if _, err := n.Mine(txs...); err != nil {
+			t.Fatalf("mine block %d: %v", i+1, err)
+		}
+	}
+	return n
+}
+
+// ethServices are the services of the eth namespace, see api.Apis
+var ethServices = []interface{}{
+	&api.BlockChainAPI{},
+	&api.AmcAPI{},
+	&api.TransactionAPI{},
+	&filters.FilterAPI{},
+}
+
+// TestEthCoverage fails for eth methods without a case, every new method has to come with its cases.
+func TestEthCoverage(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, tc := range EthCases() {
+		covered[tc.Method] = true
+	}
+	subscription := reflect.TypeOf(&jsonrpc.Subscription{})
+	methods := make(map[string]bool)
+	for _, service := range ethServices {
+		typ := reflect.TypeOf(service)
+		for i := 0; i < typ.NumMethod(); i++ {
+			m := typ.Method(i)
+			if m.Type.NumOut() > 0 && m.Type.Out(0) == subscription {
+				continue // eth_subscribe
+			}
+			name := []rune(m.Name)
+			name[0] = unicode.ToLower(name[0])
+			methods["eth_"+string(name)] = true
+		}
+	}
+	for method := range methods {
+		if !covered[method] {
+			t.Errorf("%s has no conformance case", method)
+		}
+	}
+	for method := range covered {
+		if !methods[method] && method != "eth_noSuchMethod" {
+			t.Errorf("case for unknown method %s", method)
+		}
+	}
+}
+
+type fakeCaller struct {
+	result string
+	err    error
+}
+
+func (c *fakeCaller) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	return json.Unmarshal([]byte(c.result), result)
+}
+
+type rpcError struct{ code int }
+
+func (e rpcError) Error() string  { return "filter not found" }
+func (e rpcError) ErrorCode() int { return e.code }
+
+func TestCheck(t *testing.T) {
+	fx := Fixture{"blockHash": "0x" + strings.Repeat("ab", 32)}
+	tc := Case{
+		Name:   "block",
+		Method: "eth_getBlockByHash",
+		Params: `["{{blockHash}}", false]`,
+		Result: `{"hash": "{{blockHash}}", "number": "<quantity>", "miner": "<address|null>", "baseFeePerGas?": "<quantity>", "uncles": ["<each>", "<hash>"]}`,
+	}
+	cases := []struct {
+		result string
+		diffs  []string
+	}{
+		{`{"hash": "0xabababababababababababababababababababababababababababababababab", "number": "0x10", "miner": null, "uncles": []}`, nil},
+		{`{"hash": "0xabababababababababababababababababababababababababababababababab", "number": "0x010", "miner": null, "baseFeePerGas": 7, "uncles": ["0x1"]}`, []string{
+			`result.baseFeePerGas: have 7, want <quantity>`,
+			`result.number: have "0x010", want <quantity>`,
+			`result.uncles[0]: have "0x1", want <hash>`,
+		}},
+		{`{"hash": "0xabababababababababababababababababababababababababababababababab", "number": "0x0", "uncles": [], "size": "0x1"}`, []string{
+			`result.miner: missing, want "<address|null>"`,
+			`result.size: unexpected field "0x1"`,
+		}},
+		{`null`, []string{`result: have null, want an object`}},
+	}
+	for i, c := range cases {
+		diffs, err := Check(context.Background(), &fakeCaller{result: c.result}, fx, tc)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(diffs, c.diffs) {
+			t.Fatalf("case %d: have diffs %q, want %q", i, diffs, c.diffs)
+		}
+	}
+
+	if _, err := Check(context.Background(), &fakeCaller{}, Fixture{}, tc); !errors.Is(err, ErrNeedsFixture) {
+		t.Fatalf("have %v, want %v", err, ErrNeedsFixture)
+	}
+
+	errCase := Case{Name: "err", Method: "eth_getFilterChanges", Params: `["0x1"]`, Error: &ErrorSpec{Code: -32000, Message: "not found"}}
+	if diffs, _ := Check(context.Background(), &fakeCaller{err: rpcError{-32000}}, fx, errCase); diffs != nil {
+		t.Fatalf("matching error: %q", diffs)
+	}
+	if diffs, _ := Check(context.Background(), &fakeCaller{err: rpcError{-32602}}, fx, errCase); len(diffs) != 1 {
+		t.Fatalf("wrong code: %q", diffs)
+	}
+	if diffs, _ := Check(context.Background(), &fakeCaller{result: `[]`}, fx, errCase); len(diffs) != 1 {
+		t.Fatalf("result instead of error: %q", diffs)
+	}
+
+	save := Case{Name: "filter", Method: "eth_newBlockFilter", Result: `"<id>"`, Save: "filter"}
+	if diffs, err := Check(context.Background(), &fakeCaller{result: `"0x5f"`}, fx, save); diffs != nil || err != nil || fx["filter"] != "0x5f" {
+		t.Fatalf("save: diffs %q, err %v, fixture %v", diffs, err, fx)
+	}
+}
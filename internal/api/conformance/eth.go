@@ -0,0 +1,217 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import "fmt"
+
+const (
+	unknownHash  = `"0xabababababababababababababababababababababababababababababababab"`
+	zeroWord     = `"0x0000000000000000000000000000000000000000000000000000000000000000"`
+	invalidParam = -32602
+)
+
+// blockResult is a block of eth_getBlockByNumber and eth_getBlockByHash with the given
+// number, hash and transactions. verifier and rewards are amc extensions.
+func blockResult(number, hash, txs string) string {
+	return fmt.Sprintf(`{
+		"number": %s,
+		"hash": %s,
+		"parentHash": "<hash>",
+		"nonce": "<nonce>",
+		"mixHash": "<hash>",
+		"sha3Uncles": "<hash>",
+		"miner": "<address>",
+		"difficulty": "<quantity>",
+		"extraData": "<data>",
+		"size": "<quantity>",
+		"gasLimit": "<quantity>",
+		"gasUsed": "<quantity>",
+		"timestamp": "<quantity>",
+		"transactionsRoot": "<hash>",
+		"receiptsRoot": "<hash>",
+		"logsBloom": "<bloom>",
+		"stateRoot": "<hash>",
+		"signature": "<data>",
+		"baseFeePerGas?": "<quantity>",
+		"transactions": %s,
+		"verifier": "<any>",
+		"rewards": "<any>",
+		"totalDifficulty": "<quantity>",
+		"uncles": []
+	}`, number, hash, txs)
+}
+
+// txResult is a mined transaction with the given hash
+func txResult(hash string) string {
+	return fmt.Sprintf(`{
+		"blockHash": "<hash>",
+		"blockNumber": "<quantity>",
+		"from": "<address>",
+		"gas": "<quantity>",
+		"gasPrice": "<quantity>",
+		"maxFeePerGas?": "<quantity>",
+		"maxPriorityFeePerGas?": "<quantity>",
+		"hash": %s,
+		"input": "<data>",
+		"nonce": "<quantity>",
+		"to": "<address|null>",
+		"transactionIndex": "<quantity>",
+		"value": "<quantity>",
+		"type": "<quantity>",
+		"accessList?": "<any>",
+		"chainId?": "<quantity>",
+		"v": "<quantity>",
+		"r": "<quantity>",
+		"s": "<quantity>"
+	}`, hash)
+}
+
+const logResult = `{
+	"address": "<address>",
+	"topics": ["<each>", "<hash>"],
+	"data": "<data>",
+	"blockNumber": "<quantity>",
+	"transactionHash": "<hash>",
+	"transactionIndex": "<quantity>",
+	"blockHash": "<hash>",
+	"logIndex": "<quantity>",
+	"removed": "<bool>"
+}`
+
+var receiptResult = `{
+	"blockHash": "{{blockHash}}",
+	"blockNumber": "{{block}}",
+	"transactionHash": "{{txHash}}",
+	"transactionIndex": "0x0",
+	"from": "{{from}}",
+	"to": "<address|null>",
+	"gasUsed": "<quantity>",
+	"cumulativeGasUsed": "<quantity>",
+	"contractAddress": "<address|null>",
+	"logsBloom": "<bloom>",
+	"type": "<quantity>",
+	"effectiveGasPrice": "<quantity>",
+	"root?": "<data>",
+	"status?": "<quantity>",
+	"logs": ["<each>", ` + logResult + `]
+}`
+
+// EthCases are the cases of the eth namespace, in the order they run. Filter cases
+// depend on the filters installed by the cases before them.
+//
+// Pruned data cases need the fixture values prunedBlock and prunedTx, a block below
+// the state history and a transaction whose lookup was pruned.
+func EthCases() []Case {
+	return []Case{
+		// chain
+		{Name: "chainId", Method: "eth_chainId", Result: `"<quantity>"`},
+		{Name: "blockNumber", Method: "eth_blockNumber", Result: `"<quantity>"`},
+
+		// state
+		{Name: "getBalance/latest", Method: "eth_getBalance", Params: `["{{from}}", "latest"]`, Result: `"<quantity>"`},
+		{Name: "getBalance/blockHash", Method: "eth_getBalance", Params: `["{{from}}", {"blockHash": "{{blockHash}}"}]`, Result: `"<quantity>"`},
+		{Name: "getBalance/invalidAddress", Method: "eth_getBalance", Params: `["0x12", "latest"]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getBalance/missingBlock", Method: "eth_getBalance", Params: `["{{from}}"]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getBalance/pruned", Method: "eth_getBalance", Params: `["{{from}}", "{{prunedBlock}}"]`, Error: &ErrorSpec{}},
+		{Name: "getCode/account", Method: "eth_getCode", Params: `["{{from}}", "latest"]`, Result: `"0x"`},
+		{Name: "getCode/invalidBlock", Method: "eth_getCode", Params: `["{{from}}", "head"]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getStorageAt/empty", Method: "eth_getStorageAt", Params: `["{{from}}", "0x0", "latest"]`, Result: zeroWord},
+		{Name: "getTransactionCount/latest", Method: "eth_getTransactionCount", Params: `["{{from}}", "latest"]`, Result: `"<quantity>"`},
+		{Name: "getTransactionCount/pending", Method: "eth_getTransactionCount", Params: `["{{from}}", "pending"]`, Result: `"<quantity>"`},
+
+		// blocks
+		{Name: "getBlockByNumber/genesis", Method: "eth_getBlockByNumber", Params: `["0x0", false]`,
+			Result: blockResult(`"0x0"`, `"{{genesisHash}}"`, `[]`)},
+		{Name: "getBlockByNumber/latest", Method: "eth_getBlockByNumber", Params: `["latest", false]`,
+			Result: blockResult(`"<quantity>"`, `"<hash>"`, `["<each>", "<hash>"]`)},
+		{Name: "getBlockByNumber/hashes", Method: "eth_getBlockByNumber", Params: `["{{block}}", false]`,
+			Result: blockResult(`"{{block}}"`, `"{{blockHash}}"`, `["<each>", "<hash>"]`)},
+		{Name: "getBlockByNumber/fullTx", Method: "eth_getBlockByNumber", Params: `["{{block}}", true]`,
+			Result: blockResult(`"{{block}}"`, `"{{blockHash}}"`, `["<each>", `+txResult(`"<hash>"`)+`]`)},
+		{Name: "getBlockByNumber/future", Method: "eth_getBlockByNumber", Params: `["0xffffffffff", false]`, Result: `null`},
+		{Name: "getBlockByNumber/invalidNumber", Method: "eth_getBlockByNumber", Params: `["0x01", false]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getBlockByNumber/missingFullTx", Method: "eth_getBlockByNumber", Params: `["latest"]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getBlockByHash/hashes", Method: "eth_getBlockByHash", Params: `["{{blockHash}}", false]`,
+			Result: blockResult(`"{{block}}"`, `"{{blockHash}}"`, `["<each>", "<hash>"]`)},
+		{Name: "getBlockByHash/fullTx", Method: "eth_getBlockByHash", Params: `["{{blockHash}}", true]`,
+			Result: blockResult(`"{{block}}"`, `"{{blockHash}}"`, `["<each>", `+txResult(`"<hash>"`)+`]`)},
+		{Name: "getBlockByHash/unknown", Method: "eth_getBlockByHash", Params: `[` + unknownHash + `, false]`, Result: `null`},
+		{Name: "getBlockByHash/invalidHash", Method: "eth_getBlockByHash", Params: `["0x1234", false]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "getUncleCountByBlockHash", Method: "eth_getUncleCountByBlockHash", Params: `["{{blockHash}}"]`, Result: `"0x0"`},
+		{Name: "getUncleCountByBlockHash/unknown", Method: "eth_getUncleCountByBlockHash", Params: `[` + unknownHash + `]`, Result: `null`},
+		{Name: "getUncleByBlockHashAndIndex", Method: "eth_getUncleByBlockHashAndIndex", Params: `["{{blockHash}}", "0x0"]`, Result: `null`},
+		{Name: "getBlockTransactionCountByHash", Method: "eth_getBlockTransactionCountByHash", Params: `["{{blockHash}}"]`, Result: `"{{txCount}}"`},
+		{Name: "getBlockTransactionCountByHash/unknown", Method: "eth_getBlockTransactionCountByHash", Params: `[` + unknownHash + `]`, Result: `null`},
+
+		// transactions
+		{Name: "getTransactionByHash", Method: "eth_getTransactionByHash", Params: `["{{txHash}}"]`, Result: txResult(`"{{txHash}}"`)},
+		{Name: "getTransactionByHash/unknown", Method: "eth_getTransactionByHash", Params: `[` + unknownHash + `]`, Result: `null`},
+		{Name: "getTransactionByHash/pruned", Method: "eth_getTransactionByHash", Params: `["{{prunedTx}}"]`, Result: `null`},
+		{Name: "getTransactionByBlockHashAndIndex", Method: "eth_getTransactionByBlockHashAndIndex", Params: `["{{blockHash}}", "0x0"]`,
+			Result: txResult(`"{{txHash}}"`)},
+		{Name: "getTransactionByBlockHashAndIndex/outOfRange", Method: "eth_getTransactionByBlockHashAndIndex", Params: `["{{blockHash}}", "0xffff"]`, Result: `null`},
+		{Name: "getTransactionReceipt", Method: "eth_getTransactionReceipt", Params: `["{{txHash}}"]`, Result: receiptResult},
+		{Name: "getTransactionReceipt/unknown", Method: "eth_getTransactionReceipt", Params: `[` + unknownHash + `]`, Result: `null`},
+		{Name: "getTransactionReceipt/pruned", Method: "eth_getTransactionReceipt", Params: `["{{prunedTx}}"]`, Result: `null`},
+		{Name: "sendRawTransaction/invalid", Method: "eth_sendRawTransaction", Params: `["0x00"]`, Error: &ErrorSpec{}},
+		{Name: "sendRawTransaction/notHex", Method: "eth_sendRawTransaction", Params: `["tx"]`, Error: &ErrorSpec{Code: invalidParam}},
+		{Name: "sendTransaction/unknownAccount", Method: "eth_sendTransaction",
+			Params: `[{"from": "0x0000000000000000000000000000000000000001", "to": "{{from}}"}]`, Error: &ErrorSpec{}},
+
+		// execution
+		{Name: "call/account", Method: "eth_call", Params: `[{"to": "{{from}}", "data": "0x"}, "latest"]`, Result: `"0x"`},
+		{Name: "call/unknownBlock", Method: "eth_call", Params: `[{"to": "{{from}}"}, {"blockHash": ` + unknownHash + `}]`, Error: &ErrorSpec{}},
+		{Name: "estimateGas/transfer", Method: "eth_estimateGas", Params: `[{"from": "{{from}}", "to": "{{from}}", "value": "0x0"}]`, Result: `"0x5208"`},
+		{Name: "estimateGas/invalidArgs", Method: "eth_estimateGas", Params: `[{"to": "{{from}}", "gas": 21000}]`, Error: &ErrorSpec{Code: invalidParam}},
+
+		// fees
+		{Name: "gasPrice", Method: "eth_gasPrice", Result: `"<quantity>"`},
+		{Name: "maxPriorityFeePerGas", Method: "eth_maxPriorityFeePerGas", Result: `"<quantity>"`},
+		{Name: "feeHistory", Method: "eth_feeHistory", Params: `["0x2", "latest", [25, 75]]`, Result: `{
+			"oldestBlock": "<quantity>",
+			"reward?": ["<each>", ["<each>", "<quantity>"]],
+			"baseFeePerGas?": ["<each>", "<quantity>"],
+			"gasUsedRatio": ["<each>", "<number>"]
+		}`},
+		{Name: "feeHistory/invalidPercentiles", Method: "eth_feeHistory", Params: `["0x2", "latest", [75, 25]]`, Error: &ErrorSpec{}},
+
+		// consensus
+		{Name: "submitSign/invalid", Method: "eth_submitSign", Params: `["0x"]`, Error: &ErrorSpec{Code: invalidParam}},
+
+		// logs and filters
+		{Name: "getLogs/block", Method: "eth_getLogs", Params: `[{"fromBlock": "{{block}}", "toBlock": "{{block}}"}]`,
+			Result: `["<each>", ` + logResult + `]`},
+		{Name: "getLogs/blockHash", Method: "eth_getLogs", Params: `[{"blockHash": "{{blockHash}}"}]`,
+			Result: `["<each>", ` + logResult + `]`},
+		{Name: "getLogs/unknownBlockHash", Method: "eth_getLogs", Params: `[{"blockHash": ` + unknownHash + `}]`, Error: &ErrorSpec{}},
+		{Name: "newBlockFilter", Method: "eth_newBlockFilter", Result: `"<id>"`, Save: "blockFilter"},
+		{Name: "getFilterChanges/blocks", Method: "eth_getFilterChanges", Params: `["{{blockFilter}}"]`, Result: `["<each>", "<hash>"]`},
+		{Name: "uninstallFilter", Method: "eth_uninstallFilter", Params: `["{{blockFilter}}"]`, Result: `true`},
+		{Name: "uninstallFilter/again", Method: "eth_uninstallFilter", Params: `["{{blockFilter}}"]`, Result: `false`},
+		{Name: "getFilterChanges/uninstalled", Method: "eth_getFilterChanges", Params: `["{{blockFilter}}"]`, Error: &ErrorSpec{Message: "filter not found"}},
+		{Name: "newPendingTransactionFilter", Method: "eth_newPendingTransactionFilter", Result: `"<id>"`, Save: "pendingFilter"},
+		{Name: "getFilterChanges/pending", Method: "eth_getFilterChanges", Params: `["{{pendingFilter}}"]`, Result: `["<each>", "<hash>"]`},
+		{Name: "newFilter", Method: "eth_newFilter", Params: `[{"fromBlock": "{{block}}", "toBlock": "{{block}}"}]`, Result: `"<id>"`, Save: "logFilter"},
+		{Name: "getFilterLogs", Method: "eth_getFilterLogs", Params: `["{{logFilter}}"]`,
+			Result: `["<each>", ` + logResult + `]`},
+		{Name: "getFilterLogs/unknown", Method: "eth_getFilterLogs", Params: `["0x1"]`, Error: &ErrorSpec{Message: "filter not found"}},
+		{Name: "newFilter/invalidAddress", Method: "eth_newFilter", Params: `[{"address": "0x12"}]`, Error: &ErrorSpec{Code: invalidParam}},
+
+		// protocol
+		{Name: "unknownMethod", Method: "eth_noSuchMethod", Error: &ErrorSpec{Code: -32601}},
+	}
+}
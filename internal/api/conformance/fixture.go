@@ -0,0 +1,82 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amazechain/amc/common/hexutil"
+)
+
+// discoverDepth is how many blocks below the head Discover looks for transactions.
+const discoverDepth = 1024
+
+// Discover collects the fixture values from the node: the head and genesis, and the
+// most recent block with transactions within discoverDepth blocks of the head, its
+// first transaction and sender. Without such a block the cases needing them are skipped.
+//
+//	head, genesisHash             - head number and genesis hash
+//	block, blockHash, parentHash  - number and hashes of the block with transactions
+//	txCount                       - number of its transactions
+//	txHash, from                  - hash and sender of its first transaction
+func Discover(ctx context.Context, c Caller) (Fixture, error) {
+	fx := make(Fixture)
+	var head hexutil.Uint64
+	if err := c.CallContext(ctx, &head, "eth_blockNumber"); err != nil {
+		return nil, err
+	}
+	fx["head"] = head.String()
+
+	type rpcBlock struct {
+		Hash         string   `json:"hash"`
+		ParentHash   string   `json:"parentHash"`
+		Transactions []string `json:"transactions"`
+	}
+	var genesis *rpcBlock
+	if err := c.CallContext(ctx, &genesis, "eth_getBlockByNumber", "0x0", false); err != nil {
+		return nil, err
+	}
+	if genesis == nil {
+		return nil, fmt.Errorf("node has no genesis block")
+	}
+	fx["genesisHash"] = genesis.Hash
+
+	for n := uint64(head); n > 0 && uint64(head)-n < discoverDepth; n-- {
+		var b *rpcBlock
+		if err := c.CallContext(ctx, &b, "eth_getBlockByNumber", hexutil.EncodeUint64(n), false); err != nil {
+			return nil, err
+		}
+		if b == nil || len(b.Transactions) == 0 {
+			continue
+		}
+		var tx struct {
+			From string `json:"from"`
+		}
+		if err := c.CallContext(ctx, &tx, "eth_getTransactionByHash", b.Transactions[0]); err != nil {
+			return nil, err
+		}
+		fx["block"] = hexutil.EncodeUint64(n)
+		fx["blockHash"] = b.Hash
+		fx["parentHash"] = b.ParentHash
+		fx["txCount"] = hexutil.EncodeUint64(uint64(len(b.Transactions)))
+		fx["txHash"] = b.Transactions[0]
+		fx["from"] = tx.From
+		break
+	}
+	return fx, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+
+	"github.com/amazechain/amc/internal/diagnostics"
+)
+
+// DiagnosticsAPI offers node state bundles for bug reports.
+type DiagnosticsAPI struct {
+	api *API
+}
+
+// NewDiagnosticsAPI creates a new instance of DiagnosticsAPI.
+func NewDiagnosticsAPI(api *API) *DiagnosticsAPI {
+	return &DiagnosticsAPI{api: api}
+}
+
+// CollectDiagnostics returns schema version, chain config, stages progress, head pointers, prune horizons,
+// database sanity and peer count of the node. Keys and user data are not included.
+func (s *DiagnosticsAPI) CollectDiagnostics(ctx context.Context) (*diagnostics.Bundle, error) {
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return diagnostics.Collect(diagnostics.Sources(tx,
+		diagnostics.Source{Name: "chainConfig", Collect: func() (interface{}, error) { return s.api.GetChainConfig(), nil }},
+		diagnostics.Source{Name: "peers", Collect: func() (interface{}, error) { return s.api.P2pServer().PeerCount(), nil }},
+	)), nil
+}
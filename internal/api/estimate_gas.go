@@ -0,0 +1,109 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/amazechain/amc/internal"
+	vm2 "github.com/amazechain/amc/internal/vm"
+	"github.com/amazechain/amc/params"
+	"github.com/rcrowley/go-metrics"
+)
+
+// estimateGasIterations records how many executions each eth_estimateGas
+// call needed before the search converged.
+var estimateGasIterations = metrics.NewRegisteredHistogram("rpc/estimategas/iterations", nil, metrics.NewExpDecaySample(1028, 0.015))
+
+// estimateRunner executes the call with the given gas limit against a fresh
+// copy of the pre-state.
+type estimateRunner func(gas uint64) (*internal.ExecutionResult, error)
+
+// searchGasLimit returns the smallest gas limit in (lo, hi] the call succeeds
+// with, together with the number of executions it took to find it.
+//
+// The call is first executed at hi: a revert is reported with its reason and
+// running out of gas means the allowance is too low. The gas used by that
+// run bounds the search from below, and a guess of used plus refunded gas
+// plus the call stipend, scaled by 64/63 for the gas withheld from nested
+// calls, usually settles the search in one more execution. Anything else is
+// a plain binary search where a revert counts as too little gas, since the
+// call is known to succeed at hi.
+func searchGasLimit(lo, hi uint64, run estimateRunner) (uint64, int, error) {
+	iterations := 1
+	result, err := run(hi)
+	if err != nil {
+		if errors.Is(err, internal.ErrIntrinsicGas) {
+			return 0, iterations, fmt.Errorf("gas required exceeds allowance (%d)", hi)
+		}
+		return 0, iterations, err
+	}
+	if result.Failed() {
+		if !errors.Is(result.Err, vm2.ErrOutOfGas) {
+			if len(result.Revert()) > 0 {
+				return 0, iterations, newRevertError(result)
+			}
+			return 0, iterations, result.Err
+		}
+		return 0, iterations, fmt.Errorf("gas required exceeds allowance (%d)", hi)
+	}
+	// Any limit below the gas actually consumed is bound to fail.
+	if result.UsedGas > 0 && result.UsedGas-1 > lo {
+		lo = result.UsedGas - 1
+	}
+	// executable reports whether the call succeeds with the given limit.
+	executable := func(gas uint64) (bool, error) {
+		iterations++
+		result, err := run(gas)
+		if err != nil {
+			if errors.Is(err, internal.ErrIntrinsicGas) {
+				return false, nil // Special case, raise gas limit
+			}
+			return false, err // Bail out
+		}
+		return !result.Failed(), nil
+	}
+	optimistic := (result.UsedGas + result.RefundedGas + params.CallStipend) * 64 / 63
+	if optimistic > lo && optimistic < hi {
+		ok, err := executable(optimistic)
+		if err != nil {
+			return 0, iterations, err
+		}
+		if ok {
+			hi = optimistic
+		} else {
+			lo = optimistic
+		}
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := executable(mid)
+		// If the error is not nil(consensus error), it means the provided message
+		// call or transaction will never be accepted no matter how much gas it is
+		// assigened. Return the error directly, don't struggle any more.
+		if err != nil {
+			return 0, iterations, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, iterations, nil
+}
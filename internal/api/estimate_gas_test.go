@@ -0,0 +1,124 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/internal"
+	vm2 "github.com/amazechain/amc/internal/vm"
+	"github.com/amazechain/amc/params"
+)
+
+// revertReason abi-encodes reason as a call to Error(string).
+func revertReason(reason string) []byte {
+	data := []byte{0x08, 0xc3, 0x79, 0xa0}
+	word := make([]byte, 32)
+	word[31] = 32
+	data = append(data, word...)
+	word = make([]byte, 32)
+	binary.BigEndian.PutUint64(word[24:], uint64(len(reason)))
+	data = append(data, word...)
+	padded := make([]byte, (len(reason)+31)/32*32)
+	copy(padded, reason)
+	return append(data, padded...)
+}
+
+func TestSearchGasLimitThreshold(t *testing.T) {
+	// The call only checks gasleft() against a threshold, far above what it
+	// actually consumes, and reverts below it.
+	const threshold, used = 100000, 30000
+	run := func(gas uint64) (*internal.ExecutionResult, error) {
+		if gas < params.TxGas {
+			return nil, internal.ErrIntrinsicGas
+		}
+		if gas < threshold {
+			return &internal.ExecutionResult{UsedGas: used, Err: vm2.ErrExecutionReverted}, nil
+		}
+		return &internal.ExecutionResult{UsedGas: used}, nil
+	}
+	gas, iterations, err := searchGasLimit(params.TxGas-1, 30000000, run)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if gas != threshold {
+		t.Fatalf("estimate mismatch: have %d, want %d", gas, threshold)
+	}
+	if iterations > 32 {
+		t.Fatalf("search did not stay bounded: %d iterations", iterations)
+	}
+}
+
+func TestSearchGasLimitRefundGuess(t *testing.T) {
+	// A call that needs its refund back as execution gas is settled by the
+	// 63/64 guess without a full binary search.
+	const used, refunded = 40000, 10000
+	run := func(gas uint64) (*internal.ExecutionResult, error) {
+		if gas < used+refunded {
+			return &internal.ExecutionResult{UsedGas: gas, Err: vm2.ErrOutOfGas}, nil
+		}
+		return &internal.ExecutionResult{UsedGas: used, RefundedGas: refunded}, nil
+	}
+	_, iterations, err := searchGasLimit(params.TxGas-1, 30000000, run)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	var plain int
+	for lo, hi := uint64(params.TxGas-1), uint64(30000000); lo+1 < hi; plain++ {
+		mid := lo + (hi-lo)/2
+		if mid < used+refunded {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if iterations >= plain {
+		t.Fatalf("guess did not shorten the search: %d iterations, plain search %d", iterations, plain)
+	}
+}
+
+func TestSearchGasLimitRevert(t *testing.T) {
+	reason := revertReason("not allowed")
+	run := func(gas uint64) (*internal.ExecutionResult, error) {
+		return &internal.ExecutionResult{UsedGas: 25000, Err: vm2.ErrExecutionReverted, ReturnData: reason}, nil
+	}
+	_, iterations, err := searchGasLimit(params.TxGas-1, 30000000, run)
+	if iterations != 1 {
+		t.Fatalf("revert should stop the search: %d iterations", iterations)
+	}
+	var rerr *revertError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected revert error, have %v", err)
+	}
+	if !strings.Contains(rerr.Error(), "not allowed") {
+		t.Fatalf("revert reason missing: %v", rerr)
+	}
+	if rerr.ErrorData() != hexutil.Encode(reason) {
+		t.Fatalf("revert data mismatch: have %v", rerr.ErrorData())
+	}
+
+	oog := func(gas uint64) (*internal.ExecutionResult, error) {
+		return &internal.ExecutionResult{UsedGas: gas, Err: vm2.ErrOutOfGas}, nil
+	}
+	if _, _, err := searchGasLimit(params.TxGas-1, 30000000, oog); err == nil || !strings.Contains(err.Error(), "exceeds allowance") {
+		t.Fatalf("expected allowance error, have %v", err)
+	}
+}
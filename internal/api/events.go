@@ -0,0 +1,98 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// maxPollEvents caps the number of journal events returned by one poll.
+const maxPollEvents = 1000
+
+var errEventsPruned = errors.New("requested events were pruned from the journal")
+
+// EventJournalAPI serves the durable event journal to external consumers.
+type EventJournalAPI struct {
+	api *API
+}
+
+// NewEventJournalAPI creates a new instance of EventJournalAPI.
+func NewEventJournalAPI(api *API) *EventJournalAPI {
+	return &EventJournalAPI{api: api}
+}
+
+// EventPage is a batch of journal events.
+type EventPage struct {
+	Events []*rawdb.JournalEvent `json:"events"`
+	Head   uint64                `json:"head"` // sequence of the newest appended event
+}
+
+// PollEvents returns up to limit events with a sequence greater than afterSeq.
+// Consumers persist the last processed sequence and resume from it; an error
+// is returned if events they have not seen were already pruned.
+func (s *EventJournalAPI) PollEvents(ctx context.Context, afterSeq uint64, limit int) (*EventPage, error) {
+	if limit <= 0 || limit > maxPollEvents {
+		limit = maxPollEvents
+	}
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	head, err := rawdb.ReadJournalHead(tx)
+	if err != nil {
+		return nil, err
+	}
+	events, err := rawdb.ReadJournalEvents(tx, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	if afterSeq < head {
+		if len(events) == 0 || events[0].Seq != afterSeq+1 {
+			return nil, fmt.Errorf("%w: after %d, oldest available %d", errEventsPruned, afterSeq, oldestEvent(tx, head))
+		}
+	}
+	return &EventPage{Events: events, Head: head}, nil
+}
+
+// AckEvents marks every event up to seq as processed, making it prunable.
+func (s *EventJournalAPI) AckEvents(ctx context.Context, seq uint64) error {
+	return s.api.db.Update(ctx, func(tx kv.RwTx) error {
+		return rawdb.WriteJournalAck(tx, seq)
+	})
+}
+
+func oldestEvent(tx kv.Tx, head uint64) uint64 {
+	c, err := tx.Cursor(modules.EventJournal)
+	if err != nil {
+		return head + 1
+	}
+	defer c.Close()
+	k, _, err := c.First()
+	if err != nil || k == nil {
+		return head + 1
+	}
+	return binary.BigEndian.Uint64(k)
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// FeeAccountingAPI serves the per-address fee accounting index.
+type FeeAccountingAPI struct {
+	api *API
+}
+
+// NewFeeAccountingAPI creates a new instance of FeeAccountingAPI.
+func NewFeeAccountingAPI(api *API) *FeeAccountingAPI {
+	return &FeeAccountingAPI{api: api}
+}
+
+// FeeAccountResult is the fee totals of an address over a block range.
+type FeeAccountResult struct {
+	FromBlock    hexutil.Uint64 `json:"fromBlock"`
+	ToBlock      hexutil.Uint64 `json:"toBlock"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	FeesPaid     *hexutil.Big   `json:"feesPaid"`
+	TipsReceived *hexutil.Big   `json:"tipsReceived"`
+}
+
+// GetFeeAccounting returns the gas used and fees paid by address, and the
+// tips it received as fee recipient, over blocks fromBlock..toBlock
+// inclusive. toBlock is capped at the current head. Blocks indexed neither
+// live nor by a backfill count as zero.
+func (s *FeeAccountingAPI) GetFeeAccounting(ctx context.Context, address types.Address, fromBlock, toBlock hexutil.Uint64) (*FeeAccountResult, error) {
+	if head := hexutil.Uint64(s.api.BlockChain().CurrentBlock().Number64().Uint64()); toBlock > head {
+		toBlock = head
+	}
+	if fromBlock > toBlock {
+		return nil, fmt.Errorf("invalid block range %d..%d", fromBlock, toBlock)
+	}
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	a, err := rawdb.ReadFeeAccounting(tx, address, uint64(fromBlock), uint64(toBlock))
+	if err != nil {
+		return nil, err
+	}
+	return &FeeAccountResult{
+		FromBlock:    fromBlock,
+		ToBlock:      toBlock,
+		GasUsed:      hexutil.Uint64(a.GasUsed),
+		FeesPaid:     (*hexutil.Big)(a.FeesPaid),
+		TipsReceived: (*hexutil.Big)(a.TipsReceived),
+	}, nil
+}
@@ -30,15 +30,28 @@ type FilterAPI struct {
 	filtersMu sync.Mutex
 	filters   map[jsonrpc.ID]*filter
 	timeout   time.Duration
+	logsLimit int // maximum number of logs of a range query, 0 means unlimited
+
+	streamsMu       sync.Mutex
+	streams         map[jsonrpc.ID]*replayStream // replayable logs subscriptions by stream id
+	replayLimit     int
+	replayRetention time.Duration
 }
 
-// NewFilterAPI returns a new FilterAPI instance.
-func NewFilterAPI(api Api, timeout time.Duration) *FilterAPI {
+// NewFilterAPI returns a new FilterAPI instance. logsLimit is the maximum number of logs
+// eth_getLogs and eth_getFilterLogs return for a block range, larger results fail with a
+// LimitExceededError. 0 disables the limit.
+func NewFilterAPI(api Api, timeout time.Duration, logsLimit int) *FilterAPI {
 	filterAPI := &FilterAPI{
-		api:     api,
-		events:  NewEventSystem(api),
-		filters: make(map[jsonrpc.ID]*filter),
-		timeout: timeout,
+		api:       api,
+		events:    NewEventSystem(api),
+		filters:   make(map[jsonrpc.ID]*filter),
+		timeout:   timeout,
+		logsLimit: logsLimit,
+
+		streams:         make(map[jsonrpc.ID]*replayStream),
+		replayLimit:     replayBufferSize,
+		replayRetention: replayRetention,
 	}
 	go filterAPI.timeoutLoop(timeout)
 
@@ -209,11 +222,16 @@ func (filterApi *FilterAPI) NewHeads(ctx context.Context) (*jsonrpc.Subscription
 }
 
 // Logs creates a subscription that fires for all new log that match the given filter criteria.
-func (filterApi *FilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*jsonrpc.Subscription, error) {
+// With opts requesting replay the notifications are sequenced and can be resumed after a
+// reconnect, see ReplayOptions.
+func (filterApi *FilterAPI) Logs(ctx context.Context, crit FilterCriteria, opts *ReplayOptions) (*jsonrpc.Subscription, error) {
 	notifier, supported := jsonrpc.NotifierFromContext(ctx)
 	if !supported {
 		return &jsonrpc.Subscription{}, jsonrpc.ErrNotificationsUnsupported
 	}
+	if opts != nil && (opts.Replay || opts.ResumeFrom != nil) {
+		return filterApi.replayableLogs(notifier, crit, opts.ResumeFrom)
+	}
 
 	var (
 		rpcSub      = notifier.CreateSubscription()
@@ -307,6 +325,7 @@ func (filterApi *FilterAPI) GetLogs(ctx context.Context, crit FilterCriteria) ([
 		}
 		// Construct the range filter
 		filter = NewRangeFilter(filterApi.api, begin, end, crit.Addresses, crit.Topics)
+		filter.limit = filterApi.logsLimit
 	}
 	// Run the filter and return all the logs
 	logs, err := filter.Logs(ctx)
@@ -358,6 +377,7 @@ func (filterApi *FilterAPI) GetFilterLogs(ctx context.Context, id jsonrpc.ID) ([
 		}
 		// Construct the range filter
 		filter = NewRangeFilter(filterApi.api, begin, end, f.crit.Addresses, f.crit.Topics)
+		filter.limit = filterApi.logsLimit
 	}
 	// Run the filter and return all the logs
 	logs, err := filter.Logs(ctx)
@@ -12,7 +12,6 @@ import (
 	vm2 "github.com/amazechain/amc/internal/vm"
 	"github.com/amazechain/amc/internal/vm/evmtypes"
 	"github.com/amazechain/amc/modules/rpc/jsonrpc"
-	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"math/big"
@@ -39,6 +38,7 @@ type Filter struct {
 
 	block      types.Hash // Block hash if filtering a single block
 	begin, end int64      // Range interval if filtering multiple blocks
+	limit      int        // Maximum number of logs of a range query, 0 means unlimited
 
 	//matcher *bloombits.Matcher
 }
@@ -93,6 +93,7 @@ func newFilter(api Api, addresses []types.Address, topics [][]types.Hash) *Filte
 		addresses: addresses,
 		topics:    topics,
 		db:        api.Database(),
+		limit:     defaultLogsLimit,
 	}
 }
 
@@ -130,28 +131,21 @@ func (f *Filter) Logs(ctx context.Context) ([]*block.Log, error) {
 	if f.begin == jsonrpc.LatestBlockNumber.Int64() {
 		f.begin = int64(head)
 	}
-	if f.end == jsonrpc.LatestBlockNumber.Int64() || f.end == jsonrpc.PendingBlockNumber.Int64() {
+	if f.end == jsonrpc.LatestBlockNumber.Int64() || f.end == jsonrpc.PendingBlockNumber.Int64() || end > head {
 		end = head
 	}
-	// Gather all indexed logs, and finish with non indexed ones
-	var (
-		logs           []*block.Log
-		err            error
-		size, sections = uint64(4096), uint64(10)
-		//todo
-	)
-	if indexed := sections * size; indexed > uint64(f.begin) {
-		if indexed > end {
-			logs, err = f.indexedLogs(ctx, end)
-		} else {
-			logs, err = f.indexedLogs(ctx, indexed-1)
-		}
-		if err != nil {
-			return logs, err
-		}
+	tx, err := f.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
 	}
-	rest, err := f.unindexedLogs(ctx, end)
-	logs = append(logs, rest...)
+	defer tx.Rollback()
+
+	q := &logQuery{addresses: f.addresses, topics: f.topics, limit: f.limit}
+	logs, err := q.run(ctx, tx, uint64(f.begin), end)
+	if err != nil {
+		return nil, err
+	}
+	f.begin = int64(end) + 1
 	if pending {
 		pendingLogs, err := f.pendingLogs()
 		if err != nil {
@@ -159,72 +153,6 @@ func (f *Filter) Logs(ctx context.Context) ([]*block.Log, error) {
 		}
 		logs = append(logs, pendingLogs...)
 	}
-	return logs, err
-}
-
-// indexedLogs returns the logs matching the filter criteria based on the bloom
-// bits indexed available locally or via the network.
-func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*block.Log, error) {
-	// Create a matcher session and request servicing from the backend
-	matches := make(chan uint64, 64)
-
-	//session, err := f.matcher.Start(ctx, uint64(f.begin), end, matches)
-	//if err != nil {
-	//	return nil, err
-	//}
-	//defer session.Close()
-
-	//f.api.ServiceFilter(ctx, session)
-
-	// Iterate over the matches until exhausted or context closed
-	var logs []*block.Log
-
-	for {
-		select {
-		case number, ok := <-matches:
-			// Abort if all matches have been fulfilled
-			if !ok {
-				//err := session.Error()
-				//if err == nil {
-				//	f.begin = int64(end) + 1
-				//}
-				return logs, nil
-			}
-			f.begin = int64(number) + 1
-
-			// Retrieve the suggested block and pull any truly matching logs
-			header := f.api.BlockChain().GetHeaderByNumber(uint256.NewInt(number))
-			if header == nil {
-				return logs, nil
-			}
-			found, err := f.checkMatches(ctx, header)
-			if err != nil {
-				return logs, err
-			}
-			logs = append(logs, found...)
-
-		case <-ctx.Done():
-			return logs, ctx.Err()
-		}
-	}
-}
-
-// unindexedLogs returns the logs matching the filter criteria based on raw block
-// iteration and bloom matching.
-func (f *Filter) unindexedLogs(ctx context.Context, end uint64) ([]*block.Log, error) {
-	var logs []*block.Log
-
-	for ; f.begin <= int64(end); f.begin++ {
-		header := f.api.BlockChain().GetHeaderByNumber(uint256.NewInt(uint64(f.begin)))
-		if header == nil {
-			return logs, nil
-		}
-		found, err := f.blockLogs(ctx, header)
-		if err != nil {
-			return logs, err
-		}
-		logs = append(logs, found...)
-	}
 	return logs, nil
 }
 
@@ -0,0 +1,219 @@
+package filters
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv/walk"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// defaultLogsLimit is the maximum number of logs a single query returns unless configured otherwise.
+const defaultLogsLimit = 10000
+
+// LimitExceededError is returned by a logs query which matches more logs than allowed.
+// The client has to narrow the block range or the criteria.
+type LimitExceededError struct {
+	Limit int
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("query exceeds limit of %d results", e.Limit)
+}
+
+// ErrorCode returns the JSON-RPC error code of an exceeded limit.
+func (e *LimitExceededError) ErrorCode() int { return -32005 }
+
+// logQuery matches the logs of a canonical block range against addresses and topics.
+//
+// Candidate blocks are the intersection of the LogAddressIndex bitmaps of the addresses
+// with the LogTopicIndex bitmaps of every topic position, their logs are read from the
+// Log table and matched exactly, topic positions included. Blocks below the earliest
+// indexed block are scanned through their receipts.
+type logQuery struct {
+	addresses []types.Address
+	topics    [][]types.Hash
+	limit     int // 0 means unlimited
+
+	logs []*block.Log
+}
+
+// run returns the matching logs of the blocks begin..end in ascending order.
+func (q *logQuery) run(ctx context.Context, tx kv.Tx, begin, end uint64) ([]*block.Log, error) {
+	if begin > end {
+		return nil, nil
+	}
+	indexFrom, indexed, err := rawdb.ReadLogIndexFrom(tx)
+	if err != nil {
+		return nil, err
+	}
+	// blocks from indexBegin on are looked up in the indices
+	indexBegin := end + 1
+	if indexed && indexFrom <= end {
+		indexBegin = indexFrom
+		if indexBegin < begin {
+			indexBegin = begin
+		}
+	}
+	for number := begin; number < indexBegin; number++ {
+		if err := ctx.Err(); err != nil {
+			return q.logs, err
+		}
+		if err := q.scanBlock(tx, number); err != nil {
+			return q.logs, err
+		}
+	}
+	if indexBegin > end {
+		return q.logs, nil
+	}
+	candidates, err := q.candidates(tx, indexBegin, end)
+	if err != nil {
+		return q.logs, err
+	}
+	for it := candidates.Iterator(); it.HasNext(); {
+		if err := ctx.Err(); err != nil {
+			return q.logs, err
+		}
+		if err := q.indexedBlock(ctx, tx, uint64(it.Next())); err != nil {
+			return q.logs, err
+		}
+	}
+	return q.logs, nil
+}
+
+// candidates returns the blocks in from..to the indices report a log of the addresses and
+// of every non-wildcard topic position for, regardless of the position of the topics.
+func (q *logQuery) candidates(tx kv.Tx, from, to uint64) (*roaring.Bitmap, error) {
+	if from > math.MaxUint32 {
+		return roaring.New(), nil
+	}
+	if to > math.MaxUint32 {
+		to = math.MaxUint32
+	}
+	var bm *roaring.Bitmap
+	if len(q.addresses) > 0 {
+		bm = roaring.New()
+		for _, addr := range q.addresses {
+			m, err := bitmapdb.ReadIndex(tx, modules.LogAddressIndex, addr.Bytes(), uint32(from), uint32(to))
+			if err != nil {
+				return nil, err
+			}
+			bm.Or(m)
+		}
+	}
+	for _, sub := range q.topics {
+		if len(sub) == 0 {
+			continue
+		}
+		matched := roaring.New()
+		for _, topic := range sub {
+			m, err := bitmapdb.ReadIndex(tx, modules.LogTopicIndex, topic.Bytes(), uint32(from), uint32(to))
+			if err != nil {
+				return nil, err
+			}
+			matched.Or(m)
+		}
+		if bm == nil {
+			bm = matched
+		} else {
+			bm.And(matched)
+		}
+	}
+	if bm == nil {
+		bm = roaring.New()
+		bm.AddRange(from, to+1)
+	}
+	return bm, nil
+}
+
+// indexedBlock matches the logs of a block in the Log table. The transaction hash stored
+// with the logs is taken if TxLookup places it in this block, otherwise it is resolved
+// from the receipts.
+func (q *logQuery) indexedBlock(ctx context.Context, tx kv.Tx, number uint64) error {
+	hash, err := rawdb.ReadCanonicalHash(tx, number)
+	if err != nil || hash == (types.Hash{}) {
+		return err
+	}
+	c, err := tx.Cursor(modules.Log)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	var (
+		logIndex uint
+		receipts block.Receipts
+	)
+	return walk.Cursor(ctx, c, number, number+1, func(_ uint64, k, v []byte) error {
+		if len(k) != 12 {
+			return nil
+		}
+		txIndex := binary.BigEndian.Uint32(k[8:])
+		var logs block.Logs
+		if err := logs.Unmarshal(v); err != nil {
+			return fmt.Errorf("logs of block %d transaction %d: %w", number, txIndex, err)
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		txHash := logs[0].TxHash
+		lookup, err := rawdb.ReadTxLookupEntry(tx, txHash)
+		if err != nil {
+			return err
+		}
+		if lookup == nil || *lookup != number {
+			if receipts == nil {
+				receipts = rawdb.ReadRawReceipts(tx, number)
+			}
+			if int(txIndex) < len(receipts) {
+				txHash = receipts[txIndex].TxHash
+			}
+		}
+		logIndex, err = q.deliver(logs, number, hash, txHash, uint(txIndex), logIndex)
+		return err
+	})
+}
+
+// scanBlock matches the logs of the receipts of a block, for blocks the indices don't cover.
+func (q *logQuery) scanBlock(tx kv.Tx, number uint64) error {
+	hash, err := rawdb.ReadCanonicalHash(tx, number)
+	if err != nil || hash == (types.Hash{}) {
+		return err
+	}
+	var logIndex uint
+	for i, r := range rawdb.ReadRawReceipts(tx, number) {
+		if logIndex, err = q.deliver(r.Logs, number, hash, r.TxHash, uint(i), logIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver fills in the position of the logs of a transaction and collects the matching ones.
+// logIndex is the index of the first log in the block, the index after the last one is returned.
+func (q *logQuery) deliver(logs []*block.Log, number uint64, hash, txHash types.Hash, txIndex, logIndex uint) (uint, error) {
+	for _, l := range logs {
+		l.BlockNumber = uint256.NewInt(number)
+		l.BlockHash = hash
+		l.TxHash = txHash
+		l.TxIndex = txIndex
+		l.Index = logIndex
+		l.Removed = false
+		logIndex++
+	}
+	for _, l := range filterLogs(logs, nil, nil, q.addresses, q.topics) {
+		if q.limit > 0 && len(q.logs) >= q.limit {
+			return logIndex, &LimitExceededError{Limit: q.limit}
+		}
+		q.logs = append(q.logs, l)
+	}
+	return logIndex, nil
+}
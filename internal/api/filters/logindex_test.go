@@ -0,0 +1,269 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+var (
+	logAddrA  = types.Address{0xaa}
+	logAddrB  = types.Address{0xbb}
+	logTopic1 = types.Hash{0x01}
+	logTopic2 = types.Hash{0x02}
+	logTopic3 = types.Hash{0x03}
+)
+
+func openLogsDB(tb testing.TB) kv.RwDB {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(tb.TempDir()).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		tb.Fatalf("open db: %v", err)
+	}
+	tb.Cleanup(db.Close)
+	return db
+}
+
+func logsBlockHash(n uint64) types.Hash {
+	return types.Hash{byte(n), byte(n >> 8), byte(n >> 16), 0x77}
+}
+
+func logsTxHash(n uint64, i int) types.Hash {
+	return types.Hash{byte(n), byte(n >> 8), byte(n >> 16), byte(i), 0x55}
+}
+
+// logsReceipts - the first transaction of even blocks logs A [T1 T2], the second one of
+// every block logs B [T2 T1] and A [T3 T2]. The logs of odd blocks carry no tx hash.
+func logsReceipts(n uint64) block.Receipts {
+	receipts := block.Receipts{
+		{Status: 1, TxHash: logsTxHash(n, 0)},
+		{Status: 1, TxHash: logsTxHash(n, 1)},
+	}
+	if n%2 == 0 {
+		receipts[0].Logs = []*block.Log{{Address: logAddrA, Topics: []types.Hash{logTopic1, logTopic2}, TxHash: logsTxHash(n, 0)}}
+	}
+	receipts[1].Logs = []*block.Log{
+		{Address: logAddrB, Topics: []types.Hash{logTopic2, logTopic1}},
+		{Address: logAddrA, Topics: []types.Hash{logTopic3, logTopic2}},
+	}
+	if n%2 == 0 {
+		for _, l := range receipts[1].Logs {
+			l.TxHash = logsTxHash(n, 1)
+		}
+	}
+	for _, r := range receipts {
+		for _, l := range r.Logs {
+			l.BlockNumber, l.BlockHash = uint256.NewInt(n), logsBlockHash(n)
+		}
+	}
+	return receipts
+}
+
+// writeLogsChain writes blocks 0..count-1, the log indices cover the blocks from indexFrom on.
+func writeLogsChain(t *testing.T, db kv.RwDB, count, indexFrom uint64) {
+	err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for n := uint64(0); n < count; n++ {
+			if err := rawdb.WriteCanonicalHash(tx, logsBlockHash(n), n); err != nil {
+				return err
+			}
+			receipts := logsReceipts(n)
+			for _, r := range receipts {
+				if err := rawdb.WriteTxLookupEntry(tx, r.TxHash, n); err != nil {
+					return err
+				}
+			}
+			if err := rawdb.WriteReceipts(tx, n, receipts); err != nil {
+				return err
+			}
+			if n >= indexFrom {
+				if err := rawdb.WriteLogIndex(tx, n, receipts); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("write chain: %v", err)
+	}
+}
+
+func runLogQuery(t *testing.T, db kv.RwDB, q *logQuery, begin, end uint64) ([]*block.Log, error) {
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	return q.run(context.Background(), tx, begin, end)
+}
+
+func TestLogQuery(t *testing.T) {
+	db := openLogsDB(t)
+	writeLogsChain(t, db, 20, 8)
+
+	tests := []struct {
+		name      string
+		addresses []types.Address
+		topics    [][]types.Hash
+		// per block the tx index and log index of the matches
+		want func(n uint64) [][2]uint
+	}{
+		{"address", []types.Address{logAddrA}, nil, func(n uint64) [][2]uint {
+			if n%2 == 0 {
+				return [][2]uint{{0, 0}, {1, 2}}
+			}
+			return [][2]uint{{1, 1}}
+		}},
+		{"topic position", nil, [][]types.Hash{{logTopic2}}, func(n uint64) [][2]uint {
+			if n%2 == 0 {
+				return [][2]uint{{1, 1}}
+			}
+			return [][2]uint{{1, 0}}
+		}},
+		{"address and topics", []types.Address{logAddrA}, [][]types.Hash{{logTopic1, logTopic3}, {logTopic2}}, func(n uint64) [][2]uint {
+			if n%2 == 0 {
+				return [][2]uint{{0, 0}, {1, 2}}
+			}
+			return [][2]uint{{1, 1}}
+		}},
+		{"no match", []types.Address{logAddrB}, [][]types.Hash{{logTopic3}}, func(uint64) [][2]uint { return nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 3..7 are scanned from the receipts, 8..15 looked up in the indices
+			logs, err := runLogQuery(t, db, &logQuery{addresses: tt.addresses, topics: tt.topics}, 3, 15)
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			var i int
+			for n := uint64(3); n <= 15; n++ {
+				for _, w := range tt.want(n) {
+					if i >= len(logs) {
+						t.Fatalf("missing logs of block %d, have %d logs", n, len(logs))
+					}
+					l := logs[i]
+					i++
+					if l.BlockNumber.Uint64() != n || l.BlockHash != logsBlockHash(n) {
+						t.Fatalf("log %d: block %d %x, want %d %x", i, l.BlockNumber.Uint64(), l.BlockHash, n, logsBlockHash(n))
+					}
+					if l.TxIndex != w[0] || l.Index != w[1] {
+						t.Fatalf("block %d: tx %d log %d, want tx %d log %d", n, l.TxIndex, l.Index, w[0], w[1])
+					}
+					if l.TxHash != logsTxHash(n, int(w[0])) {
+						t.Fatalf("block %d: tx hash %x, want %x", n, l.TxHash, logsTxHash(n, int(w[0])))
+					}
+				}
+			}
+			if i != len(logs) {
+				t.Fatalf("have %d logs, want %d", len(logs), i)
+			}
+		})
+	}
+}
+
+func TestLogQueryLimit(t *testing.T) {
+	db := openLogsDB(t)
+	writeLogsChain(t, db, 10, 4)
+
+	q := &logQuery{addresses: []types.Address{logAddrA}, limit: 5}
+	_, err := runLogQuery(t, db, q, 0, 9)
+	var limitErr *LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Limit != 5 {
+		t.Fatalf("expected limit error, have %v", err)
+	}
+	// 3 logs in 0..1 and 3 in 6..7, exactly at the limit is fine
+	q = &logQuery{addresses: []types.Address{logAddrA}, limit: 3}
+	if logs, err := runLogQuery(t, db, q, 0, 1); err != nil || len(logs) != 3 {
+		t.Fatalf("scanned range: %d logs, err %v", len(logs), err)
+	}
+	q = &logQuery{addresses: []types.Address{logAddrA}, limit: 3}
+	if logs, err := runLogQuery(t, db, q, 6, 7); err != nil || len(logs) != 3 {
+		t.Fatalf("indexed range: %d logs, err %v", len(logs), err)
+	}
+}
+
+// BenchmarkLogQuery queries 1M blocks for a token address which logs a Transfer in every
+// 10th block, and for the transfers to one holder, in every 1000th block.
+func BenchmarkLogQuery(b *testing.B) {
+	const blocks = 1_000_000
+	var (
+		token    = types.Address{0xe2, 0x0c}
+		transfer = types.Hash{0xdd, 0xf2, 0x52, 0xad}
+		holder   = types.Hash{0x12}
+		other    = types.Hash{0x34}
+	)
+	db := openLogsDB(b)
+	err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		tokenBlocks, holderBlocks := roaring.New(), roaring.New()
+		for n := uint64(0); n < blocks; n += 10 {
+			to := other
+			if n%1000 == 0 {
+				to = holder
+				holderBlocks.Add(uint32(n))
+			}
+			tokenBlocks.Add(uint32(n))
+			if err := rawdb.WriteCanonicalHash(tx, logsBlockHash(n), n); err != nil {
+				return err
+			}
+			logs := block.Logs{{Address: token, Topics: []types.Hash{transfer, {0x99}, to}, Data: make([]byte, 32), TxHash: logsTxHash(n, 0)}}
+			v, err := logs.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(modules.Log, modules.LogKey(n, 0), v); err != nil {
+				return err
+			}
+			if err := rawdb.WriteTxLookupEntry(tx, logsTxHash(n, 0), n); err != nil {
+				return err
+			}
+		}
+		limit := int(bitmapdb.ChunkLimit)
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogAddressIndex, token.Bytes(), tokenBlocks, limit); err != nil {
+			return err
+		}
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogTopicIndex, transfer.Bytes(), tokenBlocks, limit); err != nil {
+			return err
+		}
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogTopicIndex, holder.Bytes(), holderBlocks, limit); err != nil {
+			return err
+		}
+		otherBlocks := roaring.AndNot(tokenBlocks, holderBlocks)
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogTopicIndex, other.Bytes(), otherBlocks, limit); err != nil {
+			return err
+		}
+		return rawdb.WriteLogIndexFrom(tx, 0)
+	})
+	if err != nil {
+		b.Fatalf("write chain: %v", err)
+	}
+
+	bench := func(b *testing.B, topics [][]types.Hash, want int) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tx, err := db.BeginRo(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			q := &logQuery{addresses: []types.Address{token}, topics: topics}
+			logs, err := q.run(context.Background(), tx, 0, blocks-1)
+			tx.Rollback()
+			if err != nil || len(logs) != want {
+				b.Fatalf("%d logs, want %d, err %v", len(logs), want, err)
+			}
+		}
+	}
+	b.Run("address", func(b *testing.B) { bench(b, nil, blocks/10) })
+	b.Run("address+topic", func(b *testing.B) { bench(b, [][]types.Hash{{transfer}, {}, {holder}}, blocks/1000) })
+}
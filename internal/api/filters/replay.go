@@ -0,0 +1,202 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+)
+
+const (
+	// replayBufferSize is the number of notifications a replayable logs subscription keeps for resuming.
+	replayBufferSize = 4096
+	// replayRetention is how long a replayable logs subscription outlives its connection.
+	replayRetention = 5 * time.Minute
+)
+
+// ErrResyncRequired is returned on resuming a logs subscription which can't continue without a gap:
+// the notifications after the resume point are no longer buffered or the subscription expired.
+// The client has to fetch the missed range with eth_getLogs and subscribe anew.
+var ErrResyncRequired = errors.New("gap, resync required")
+
+// ReplayOptions is the optional last argument of eth_subscribe("logs", criteria, options).
+// With Replay or ResumeFrom set every notification is a SequencedLog, and the subscription
+// survives its connection for a while, buffering notifications for a resuming client.
+type ReplayOptions struct {
+	Replay     bool         `json:"replay"`
+	ResumeFrom *ResumePoint `json:"resumeFrom"`
+}
+
+// ResumePoint is the stream and sequence number of the last notification a client received.
+// The criteria of the resumed stream apply, the ones of the resubscription are ignored.
+type ResumePoint struct {
+	ID  jsonrpc.ID     `json:"id"`
+	Seq hexutil.Uint64 `json:"seq"`
+}
+
+// SequencedLog is a notification of a replayable logs subscription. Seq starts at 1 and grows
+// by one per notification of the stream across reconnects, removed logs of a reorg included.
+// Stream is the id of the first subscription of the stream, resubscriptions get new ids.
+type SequencedLog struct {
+	Stream jsonrpc.ID     `json:"stream"`
+	Seq    hexutil.Uint64 `json:"seq"`
+	Log    *block.Log     `json:"log"`
+}
+
+// replayStream is the state of a replayable logs subscription, kept across connections.
+type replayStream struct {
+	id      jsonrpc.ID
+	logsSub *Subscription
+	limit   int
+
+	mu       sync.Mutex
+	buf      []SequencedLog // last notifications, ascending Seq
+	next     uint64         // Seq of the next notification
+	notifier *jsonrpc.Notifier
+	rpcSub   *jsonrpc.Subscription // nil while no client is attached
+	expiry   *time.Timer
+	closed   bool
+}
+
+// push sequences a log, buffers it and sends it to the attached client, if any.
+func (s *replayStream) push(log *block.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := SequencedLog{Stream: s.id, Seq: hexutil.Uint64(s.next), Log: log}
+	s.next++
+	if len(s.buf) == s.limit {
+		s.buf = s.buf[1:]
+	}
+	s.buf = append(s.buf, n)
+	if s.rpcSub != nil {
+		// a failed write means the connection is gone, the watcher detaches the client
+		s.notifier.Notify(s.rpcSub.ID, &n)
+	}
+}
+
+// attach replays the buffered notifications after seq to a client and sends it the following ones.
+func (s *replayStream) attach(notifier *jsonrpc.Notifier, rpcSub *jsonrpc.Subscription, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("%w: subscription %s expired", ErrResyncRequired, s.id)
+	}
+	if s.rpcSub != nil {
+		return fmt.Errorf("subscription %s is attached to another connection", s.id)
+	}
+	if seq >= s.next {
+		return fmt.Errorf("resume point %d is ahead of subscription %s, last sent is %d", seq, s.id, s.next-1)
+	}
+	first := s.next - uint64(len(s.buf))
+	if seq+1 < first {
+		return fmt.Errorf("%w: subscription %s keeps notifications from %d, resume point is %d", ErrResyncRequired, s.id, first, seq)
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	// the notifier holds notifications until the subscription id is sent, replay goes first
+	for i := range s.buf[seq+1-first:] {
+		notifier.Notify(rpcSub.ID, &s.buf[int(seq+1-first)+i])
+	}
+	s.notifier, s.rpcSub = notifier, rpcSub
+	return nil
+}
+
+// detach stops sending to the client of rpcSub, expire is called unless a client attaches in time.
+func (s *replayStream) detach(rpcSub *jsonrpc.Subscription, retention time.Duration, expire func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rpcSub != rpcSub {
+		return
+	}
+	s.notifier, s.rpcSub = nil, nil
+	s.expiry = time.AfterFunc(retention, expire)
+}
+
+func (s *replayStream) run(matchedLogs chan []*block.Log) {
+	for {
+		select {
+		case logs := <-matchedLogs:
+			for _, log := range logs {
+				s.push(log)
+			}
+		case <-s.logsSub.Err():
+			return
+		}
+	}
+}
+
+// replayableLogs subscribes to logs in replay mode, or resumes the stream of from.
+func (filterApi *FilterAPI) replayableLogs(notifier *jsonrpc.Notifier, crit FilterCriteria, from *ResumePoint) (*jsonrpc.Subscription, error) {
+	if from != nil {
+		filterApi.streamsMu.Lock()
+		s := filterApi.streams[from.ID]
+		filterApi.streamsMu.Unlock()
+		if s == nil {
+			return nil, fmt.Errorf("%w: unknown or expired subscription %s", ErrResyncRequired, from.ID)
+		}
+		rpcSub := notifier.CreateSubscription()
+		if err := s.attach(notifier, rpcSub, uint64(from.Seq)); err != nil {
+			return nil, err
+		}
+		go filterApi.watchStream(s, notifier, rpcSub)
+		return rpcSub, nil
+	}
+
+	var (
+		rpcSub      = notifier.CreateSubscription()
+		matchedLogs = make(chan []*block.Log)
+	)
+	logsSub, err := filterApi.events.SubscribeLogs(crit, matchedLogs)
+	if err != nil {
+		return nil, err
+	}
+	s := &replayStream{id: rpcSub.ID, logsSub: logsSub, limit: filterApi.replayLimit, next: 1}
+	s.attach(notifier, rpcSub, 0)
+	filterApi.streamsMu.Lock()
+	filterApi.streams[s.id] = s
+	filterApi.streamsMu.Unlock()
+
+	go s.run(matchedLogs)
+	go filterApi.watchStream(s, notifier, rpcSub)
+	return rpcSub, nil
+}
+
+// watchStream ends the stream when the client unsubscribes and detaches it when the connection drops.
+func (filterApi *FilterAPI) watchStream(s *replayStream, notifier *jsonrpc.Notifier, rpcSub *jsonrpc.Subscription) {
+	select {
+	case err := <-rpcSub.Err():
+		// unsubscribe closes the channel, a dropped connection sends its error first
+		if err == nil {
+			filterApi.dropStream(s, false)
+			return
+		}
+	case <-notifier.Closed():
+	}
+	s.detach(rpcSub, filterApi.replayRetention, func() { filterApi.dropStream(s, true) })
+}
+
+// dropStream uninstalls the stream, if detached only unless no client attached since.
+func (filterApi *FilterAPI) dropStream(s *replayStream, detached bool) {
+	s.mu.Lock()
+	if s.closed || detached && s.rpcSub != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.notifier, s.rpcSub = nil, nil
+	s.buf = nil
+	s.mu.Unlock()
+
+	filterApi.streamsMu.Lock()
+	delete(filterApi.streams, s.id)
+	filterApi.streamsMu.Unlock()
+	s.logsSub.Unsubscribe()
+}
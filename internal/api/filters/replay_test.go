@@ -0,0 +1,188 @@
+package filters
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	event "github.com/amazechain/amc/modules/event/v2"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/holiman/uint256"
+)
+
+func replayLog(number uint64, index uint, removed bool) *block.Log {
+	return &block.Log{
+		BlockNumber: uint256.NewInt(number),
+		BlockHash:   types.Hash{byte(number)},
+		TxHash:      types.Hash{byte(number), byte(index)},
+		Index:       index,
+		Removed:     removed,
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch chan SequencedLog) SequencedLog {
+	t.Helper()
+	select {
+	case n := <-ch:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notification")
+		return SequencedLog{}
+	}
+}
+
+func newReplayServer(t *testing.T) (*FilterAPI, *jsonrpc.Server) {
+	api := NewFilterAPI(nil, time.Minute, defaultLogsLimit)
+	server := jsonrpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return api, server
+}
+
+func subscribeLogs(client *jsonrpc.Client, ch chan SequencedLog, opts *ReplayOptions) (*jsonrpc.ClientSubscription, error) {
+	return client.Subscribe(context.Background(), "eth", ch, "logs", map[string]interface{}{}, opts)
+}
+
+// streamState returns the next sequence number and whether a client is attached.
+func streamState(api *FilterAPI, id jsonrpc.ID) (uint64, bool, bool) {
+	api.streamsMu.Lock()
+	s := api.streams[id]
+	api.streamsMu.Unlock()
+	if s == nil {
+		return 0, false, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next, s.rpcSub != nil, true
+}
+
+func TestReplayAcrossReorg(t *testing.T) {
+	api, server := newReplayServer(t)
+	client := jsonrpc.DialInProc(server)
+	ch := make(chan SequencedLog)
+	if _, err := subscribeLogs(client, ch, &ReplayOptions{Replay: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// block 2 is replaced by 2' in a reorg, the connection drops between removal and new logs
+	want := []*block.Log{
+		replayLog(1, 0, false),
+		replayLog(2, 0, false), replayLog(2, 1, false),
+		replayLog(2, 0, true), replayLog(2, 1, true),
+		replayLog(0x22, 0, false), replayLog(3, 0, false),
+	}
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: want[:3]})
+	var got []SequencedLog
+	for i := 0; i < 3; i++ {
+		got = append(got, receive(t, ch))
+	}
+	stream := got[0].Stream
+
+	event.GlobalEvent.Send(&common.RemovedLogsEvent{Logs: want[3:5]})
+	got = append(got, receive(t, ch)) // second removed log is lost with the connection
+	client.Close()
+	waitFor(t, "detach", func() bool { _, attached, ok := streamState(api, stream); return ok && !attached })
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: want[5:]})
+	waitFor(t, "buffering", func() bool { next, _, _ := streamState(api, stream); return next == uint64(len(want))+1 })
+
+	client = jsonrpc.DialInProc(server)
+	defer client.Close()
+	ch = make(chan SequencedLog)
+	resume := &ReplayOptions{ResumeFrom: &ResumePoint{ID: stream, Seq: got[len(got)-1].Seq}}
+	if _, err := subscribeLogs(client, ch, resume); err != nil {
+		t.Fatal(err)
+	}
+	for len(got) < len(want) {
+		got = append(got, receive(t, ch))
+	}
+
+	for i, n := range got {
+		w := want[i]
+		if n.Stream != stream || uint64(n.Seq) != uint64(i)+1 {
+			t.Fatalf("notification %d: stream %s seq %d, want %s %d", i, n.Stream, n.Seq, stream, i+1)
+		}
+		if n.Log.BlockNumber.Uint64() != w.BlockNumber.Uint64() || n.Log.Index != w.Index || n.Log.Removed != w.Removed {
+			t.Fatalf("notification %d: block %d log %d removed %t, want %d %d %t", i,
+				n.Log.BlockNumber.Uint64(), n.Log.Index, n.Log.Removed, w.BlockNumber.Uint64(), w.Index, w.Removed)
+		}
+	}
+
+	// live notifications follow the replay
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: []*block.Log{replayLog(4, 0, false)}})
+	if n := receive(t, ch); uint64(n.Seq) != uint64(len(want))+1 || n.Log.BlockNumber.Uint64() != 4 {
+		t.Fatalf("live notification: seq %d block %d", n.Seq, n.Log.BlockNumber.Uint64())
+	}
+}
+
+func TestReplayGap(t *testing.T) {
+	api, server := newReplayServer(t)
+	api.replayLimit = 2
+	client := jsonrpc.DialInProc(server)
+	ch := make(chan SequencedLog)
+	if _, err := subscribeLogs(client, ch, &ReplayOptions{Replay: true}); err != nil {
+		t.Fatal(err)
+	}
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: []*block.Log{replayLog(1, 0, false)}})
+	stream := receive(t, ch).Stream
+	client.Close()
+	waitFor(t, "detach", func() bool { _, attached, ok := streamState(api, stream); return ok && !attached })
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: []*block.Log{replayLog(2, 0, false), replayLog(3, 0, false), replayLog(4, 0, false)}})
+	waitFor(t, "buffering", func() bool { next, _, _ := streamState(api, stream); return next == 5 })
+
+	client = jsonrpc.DialInProc(server)
+	defer client.Close()
+	for _, c := range []struct {
+		from   ResumePoint
+		resync bool
+	}{
+		{ResumePoint{ID: stream, Seq: 1}, true},  // 2 is evicted
+		{ResumePoint{ID: stream, Seq: 9}, false}, // ahead of the stream
+		{ResumePoint{ID: "0x1", Seq: 1}, true},   // unknown stream
+	} {
+		from := c.from
+		_, err := subscribeLogs(client, make(chan SequencedLog), &ReplayOptions{ResumeFrom: &from})
+		if err == nil || strings.Contains(err.Error(), ErrResyncRequired.Error()) != c.resync {
+			t.Fatalf("resume from %s %d: %v", from.ID, from.Seq, err)
+		}
+	}
+
+	ch = make(chan SequencedLog)
+	sub, err := subscribeLogs(client, ch, &ReplayOptions{ResumeFrom: &ResumePoint{ID: stream, Seq: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint64(3); seq <= 4; seq++ {
+		if n := receive(t, ch); uint64(n.Seq) != seq {
+			t.Fatalf("replayed seq %d, want %d", n.Seq, seq)
+		}
+	}
+
+	// unsubscribing ends the stream, a detached stream expires
+	sub.Unsubscribe()
+	waitFor(t, "unsubscribe", func() bool { _, _, ok := streamState(api, stream); return !ok })
+	api.replayRetention = 10 * time.Millisecond
+	if _, err := subscribeLogs(client, ch, &ReplayOptions{Replay: true}); err != nil {
+		t.Fatal(err)
+	}
+	event.GlobalEvent.Send(&common.NewLogsEvent{Logs: []*block.Log{replayLog(5, 0, false)}})
+	stream = receive(t, ch).Stream
+	client.Close()
+	waitFor(t, "expiry", func() bool { _, _, ok := streamState(api, stream); return !ok })
+}
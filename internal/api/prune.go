@@ -0,0 +1,44 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+
+	"github.com/amazechain/amc/internal/kv/prune"
+)
+
+// PruneAPI offers data retention reports to node operators.
+type PruneAPI struct {
+	api *API
+}
+
+// NewPruneAPI creates a new instance of PruneAPI.
+func NewPruneAPI(api *API) *PruneAPI {
+	return &PruneAPI{api: api}
+}
+
+// PruneProjection estimates how much disk pruning with the given settings would free,
+// and which RPC methods would stop serving which blocks.
+func (s *PruneAPI) PruneProjection(ctx context.Context, settings prune.Settings) (*prune.Projection, error) {
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	return prune.Project(tx, settings)
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/common/types"
+	event "github.com/amazechain/amc/modules/event/v2"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// maxWatchHits caps the number of watch hits returned by one call.
+const maxWatchHits = 1000
+
+// storageWatcher is implemented by blockchains matching blocks against the watchlist.
+type storageWatcher interface {
+	ReloadStorageWatches() error
+}
+
+// StorageWatchAPI manages storage slot watchlists.
+type StorageWatchAPI struct {
+	api *API
+}
+
+// NewStorageWatchAPI creates a new instance of StorageWatchAPI.
+func NewStorageWatchAPI(api *API) *StorageWatchAPI {
+	return &StorageWatchAPI{api: api}
+}
+
+// WatchHitPage is a batch of watch hits.
+type WatchHitPage struct {
+	Hits []*rawdb.WatchHit `json:"hits"`
+	Head uint64            `json:"head"` // sequence of the newest appended hit
+}
+
+// WatchStorage adds slots of address to the persisted watchlist. Every later
+// canonical block changing one of them produces a watch hit.
+func (s *StorageWatchAPI) WatchStorage(ctx context.Context, address types.Address, slots []types.Hash) error {
+	if len(slots) == 0 {
+		return errors.New("no slots to watch")
+	}
+	return s.update(ctx, func(tx kv.RwTx) error {
+		return rawdb.WriteStorageWatch(tx, address, slots)
+	})
+}
+
+// UnwatchStorage removes slots of address from the watchlist, all of them if slots is empty.
+func (s *StorageWatchAPI) UnwatchStorage(ctx context.Context, address types.Address, slots []types.Hash) error {
+	return s.update(ctx, func(tx kv.RwTx) error {
+		return rawdb.DeleteStorageWatch(tx, address, slots)
+	})
+}
+
+func (s *StorageWatchAPI) update(ctx context.Context, f func(tx kv.RwTx) error) error {
+	if err := s.api.db.Update(ctx, f); err != nil {
+		return err
+	}
+	w, ok := s.api.BlockChain().(storageWatcher)
+	if !ok {
+		return errors.New("storage watches are not supported by the blockchain")
+	}
+	return w.ReloadStorageWatches()
+}
+
+// GetWatchHits returns up to limit hits and retractions with a sequence greater than afterSeq.
+func (s *StorageWatchAPI) GetWatchHits(ctx context.Context, afterSeq uint64, limit int) (*WatchHitPage, error) {
+	if limit <= 0 || limit > maxWatchHits {
+		limit = maxWatchHits
+	}
+	tx, err := s.api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	head, err := rawdb.ReadStorageWatchHead(tx)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := rawdb.ReadStorageWatchHits(tx, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &WatchHitPage{Hits: hits, Head: head}, nil
+}
+
+// StorageWatchHits sends watch hits and retractions as they are recorded.
+func (s *StorageWatchAPI) StorageWatchHits(ctx context.Context) (*jsonrpc.Subscription, error) {
+	notifier, supported := jsonrpc.NotifierFromContext(ctx)
+	if !supported {
+		return &jsonrpc.Subscription{}, jsonrpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		hitsCh := make(chan common.StorageWatchHitsEvent)
+		hitsSub := event.GlobalEvent.Subscribe(hitsCh)
+		defer hitsSub.Unsubscribe()
+		for {
+			select {
+			case ev := <-hitsCh:
+				for _, hit := range ev.Hits {
+					notifier.Notify(rpcSub.ID, hit)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
@@ -75,6 +75,8 @@ type RPCTransaction struct {
 	V                *hexutil.Big          `json:"v"`
 	R                *hexutil.Big          `json:"r"`
 	S                *hexutil.Big          `json:"s"`
+	ValidUntilBlock  *hexutil.Uint64       `json:"validUntilBlock,omitempty"`
+	ValidUntilTime   *hexutil.Uint64       `json:"validUntilTime,omitempty"`
 }
 
 // from retrieves the transaction sender address.
@@ -132,7 +134,7 @@ func (args *TransactionArgs) setDefaults(ctx context.Context, api *API) error {
 		}
 		pendingBlockNr := jsonrpc.BlockNumberOrHashWithNumber(jsonrpc.PendingBlockNumber)
 		//todo gasCap
-		estimated, err := DoEstimateGas(ctx, api, callArgs, pendingBlockNr, 50000000)
+		estimated, err := DoEstimateGas(ctx, api, callArgs, pendingBlockNr, nil, 50000000)
 		if err != nil {
 			return err
 		}
@@ -340,6 +342,16 @@ func newRPCTransaction(tx *transaction.Transaction, blockHash types.Hash, blockN
 		R:        (*hexutil.Big)(r.ToBig()),
 		S:        (*hexutil.Big)(s.ToBig()),
 	}
+	if v := tx.Validity(); v != nil {
+		if v.ValidUntilBlock != 0 {
+			until := hexutil.Uint64(v.ValidUntilBlock)
+			result.ValidUntilBlock = &until
+		}
+		if v.ValidUntilTime != 0 {
+			until := hexutil.Uint64(v.ValidUntilTime)
+			result.ValidUntilTime = &until
+		}
+	}
 	if blockHash != (types.Hash{}) {
 		hash := mvm_types.FromAmcHash(blockHash)
 		result.BlockHash = &hash
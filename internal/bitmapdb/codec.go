@@ -0,0 +1,238 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+/*
+Shard values written by EncodeShard and EncodeShard64 start with a codec byte, the bitmap follows in that codec:
+  - CodecRoaring - portable roaring serialization
+  - CodecDelta - uvarint of the first value, then uvarint gaps to every next one: sparse shards
+  - CodecRuns - per run of consecutive values uvarint gap from the end of the previous run and uvarint
+    length-1 of the run: dense shards
+
+The encoder keeps the smallest. Shards written before codecs have no codec byte and are roaring: 32-bit
+roaring starts with cookie byte 0x3a or 0x3b, 64-bit roaring with the little-endian amount of 2^32 buckets,
+which is 0 or 1 for block numbers. Neither takes a codec byte value, so no migration is needed.
+*/
+const (
+	CodecRoaring byte = 0xf0 + iota
+	CodecDelta
+	CodecRuns
+)
+
+// ShardCodec - codec of a stored shard, CodecRoaring for shards written before codecs
+func ShardCodec(v []byte) byte {
+	if len(v) > 0 && v[0] >= CodecRoaring && v[0] <= CodecRuns {
+		return v[0]
+	}
+	return CodecRoaring
+}
+
+// compact - delta and runs encodings of ascending values drained by next; an encoding growing beyond
+// limit bytes is abandoned and returned nil
+func compact(next func(buf []uint64) int, limit int) (delta, runs []byte) {
+	delta, runs = []byte{CodecDelta}, []byte{CodecRuns}
+	var (
+		buf         = make([]uint64, 256)
+		prev        uint64
+		start, end  uint64 // current run
+		afterRun    uint64 // end of the previous run + 1
+		first, done = true, false
+	)
+	flush := func() {
+		runs = binary.AppendUvarint(runs, start-afterRun)
+		runs = binary.AppendUvarint(runs, end-start)
+		afterRun = end + 1
+		if len(runs) > limit {
+			runs = nil
+		}
+	}
+	for n := next(buf); n > 0 && !done; n = next(buf) {
+		for _, v := range buf[:n] {
+			if delta != nil {
+				delta = binary.AppendUvarint(delta, v-prev)
+				if len(delta) > limit {
+					delta = nil
+				}
+			}
+			switch {
+			case first:
+				start, end = v, v
+			case v == end+1:
+				end = v
+			case runs != nil:
+				flush()
+				start, end = v, v
+			}
+			prev, first = v, false
+		}
+		done = delta == nil && runs == nil
+	}
+	if !first && runs != nil {
+		flush()
+	}
+	return delta, runs
+}
+
+// smallest - shortest of the candidates, earlier ones win ties
+func smallest(candidates ...[]byte) []byte {
+	var res []byte
+	for _, c := range candidates {
+		if c != nil && (res == nil || len(c) < len(res)) {
+			res = c
+		}
+	}
+	return res
+}
+
+// EncodeShard64 - shard value of bm in the smallest codec
+func EncodeShard64(bm *roaring64.Bitmap) ([]byte, error) {
+	size := int(bm.GetSerializedSizeInBytes()) + 1
+	it := bm.ManyIterator()
+	delta, runs := compact(it.NextMany, size)
+	if v := smallest(delta, runs); v != nil && len(v) <= size {
+		return v, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	buf.WriteByte(CodecRoaring)
+	if _, err := bm.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeShard - shard value of bm in the smallest codec
+func EncodeShard(bm *roaring.Bitmap) ([]byte, error) {
+	size := int(bm.GetSerializedSizeInBytes()) + 1
+	it := bm.ManyIterator()
+	var buf32 []uint32
+	delta, runs := compact(func(buf []uint64) int {
+		if len(buf32) < len(buf) {
+			buf32 = make([]uint32, len(buf))
+		}
+		n := it.NextMany(buf32[:len(buf)])
+		for i, v := range buf32[:n] {
+			buf[i] = uint64(v)
+		}
+		return n
+	}, size)
+	if v := smallest(delta, runs); v != nil && len(v) <= size {
+		return v, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	buf.WriteByte(CodecRoaring)
+	if _, err := bm.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCompact - walks a delta or runs encoded shard: values of a delta shard are returned in ascending order,
+// runs of a runs shard go to addRange as [start, end)
+func decodeCompact(codec byte, v []byte, addRange func(start, end uint64)) ([]uint64, error) {
+	var values []uint64
+	if codec == CodecDelta {
+		values = make([]uint64, 0, len(v))
+	}
+	var prev uint64
+	for len(v) > 0 {
+		a, n := binary.Uvarint(v)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed shard of codec %x", codec)
+		}
+		v = v[n:]
+		if codec == CodecDelta {
+			prev += a
+			values = append(values, prev)
+			continue
+		}
+		l, n := binary.Uvarint(v)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed shard of codec %x: run without length", codec)
+		}
+		v = v[n:]
+		start := prev + a
+		prev = start + l + 1
+		addRange(start, prev)
+	}
+	return values, nil
+}
+
+// DecodeShard64 - replaces content of bm by the stored shard v of any codec
+func DecodeShard64(bm *roaring64.Bitmap, v []byte) error {
+	bm.Clear()
+	switch codec := ShardCodec(v); {
+	case len(v) > 0 && v[0] == CodecRoaring:
+		_, err := bm.ReadFrom(bytes.NewReader(v[1:]))
+		return err
+	case codec == CodecRoaring:
+		_, err := bm.ReadFrom(bytes.NewReader(v))
+		return err
+	default:
+		values, err := decodeCompact(codec, v[1:], bm.AddRange)
+		if err != nil {
+			return err
+		}
+		bm.AddMany(values)
+		return nil
+	}
+}
+
+// DecodeShard - replaces content of bm by the stored shard v of any codec
+func DecodeShard(bm *roaring.Bitmap, v []byte) error {
+	bm.Clear()
+	switch codec := ShardCodec(v); {
+	case len(v) > 0 && v[0] == CodecRoaring:
+		_, err := bm.ReadFrom(bytes.NewReader(v[1:]))
+		return err
+	case codec == CodecRoaring:
+		_, err := bm.ReadFrom(bytes.NewReader(v))
+		return err
+	default:
+		var rangeErr error
+		values, err := decodeCompact(codec, v[1:], func(start, end uint64) {
+			if end > MaxUint32+1 {
+				rangeErr = fmt.Errorf("run up to %d of 32-bit shard", end)
+				return
+			}
+			bm.AddRange(start, end)
+		})
+		if err != nil {
+			return err
+		}
+		if rangeErr != nil {
+			return rangeErr
+		}
+		if len(values) > 0 && values[len(values)-1] > MaxUint32 {
+			return fmt.Errorf("value %d of 32-bit shard", values[len(values)-1])
+		}
+		values32 := make([]uint32, len(values))
+		for i, x := range values {
+			values32[i] = uint32(x)
+		}
+		bm.AddMany(values32)
+		return nil
+	}
+}
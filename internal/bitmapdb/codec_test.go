@@ -0,0 +1,239 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// densityProfiles - shards as history indices have them: an address touched now and then, a contract called
+// in long streaks of blocks, a hot contract called in every other block, and an empty shard left by unwinding
+func densityProfiles() []struct {
+	name  string
+	bm    *roaring64.Bitmap
+	codec byte
+} {
+	rnd := rand.New(rand.NewSource(1))
+	sparse, runs, dense := roaring64.New(), roaring64.New(), roaring64.New()
+	for b, i := uint64(5_000_000), 0; i < 2000; i++ {
+		b += 1 + uint64(rnd.Intn(20_000))
+		sparse.Add(b)
+	}
+	for b, i := uint64(5_000_000), 0; i < 100; i++ {
+		b += 1 + uint64(rnd.Intn(10_000))
+		l := 1 + uint64(rnd.Intn(500))
+		runs.AddRange(b, b+l)
+		b += l
+	}
+	for b := uint64(5_000_000); b < 5_000_000+1<<17; b++ {
+		if rnd.Intn(2) == 0 {
+			dense.Add(b)
+		}
+	}
+	runs.RunOptimize()
+	return []struct {
+		name  string
+		bm    *roaring64.Bitmap
+		codec byte
+	}{
+		{"sparse", sparse, CodecDelta},
+		{"runs", runs, CodecRuns},
+		{"dense", dense, CodecRoaring},
+		{"empty", roaring64.New(), CodecDelta},
+	}
+}
+
+func TestShardCodecRoundTrip(t *testing.T) {
+	for _, p := range densityProfiles() {
+		v, err := EncodeShard64(p.bm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := ShardCodec(v); c != p.codec {
+			t.Fatalf("%s: codec %x, want %x", p.name, c, p.codec)
+		}
+		if size := int(p.bm.GetSerializedSizeInBytes()) + 1; len(v) > size {
+			t.Fatalf("%s: %d bytes, roaring takes %d", p.name, len(v), size)
+		}
+		got := roaring64.BitmapOf(1, 2, 3) // decoding replaces content
+		if err := DecodeShard64(got, v); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(p.bm) {
+			t.Fatalf("%s: decoded %d values, encoded %d", p.name, got.GetCardinality(), p.bm.GetCardinality())
+		}
+
+		// 32-bit shards of the same block numbers
+		bm32 := roaring.New()
+		it := p.bm.Iterator()
+		for it.HasNext() {
+			bm32.Add(uint32(it.Next()))
+		}
+		if v, err = EncodeShard(bm32); err != nil {
+			t.Fatal(err)
+		}
+		if c := ShardCodec(v); c != p.codec {
+			t.Fatalf("%s 32-bit: codec %x, want %x", p.name, c, p.codec)
+		}
+		got32 := roaring.New()
+		if err := DecodeShard(got32, v); err != nil {
+			t.Fatal(err)
+		}
+		if !got32.Equals(bm32) {
+			t.Fatalf("%s 32-bit: decoded %d values, encoded %d", p.name, got32.GetCardinality(), bm32.GetCardinality())
+		}
+	}
+
+	for _, v := range [][]byte{{CodecDelta, 0x80}, {CodecRuns, 0x05}} {
+		if err := DecodeShard64(roaring64.New(), v); err == nil {
+			t.Fatalf("malformed shard %x decoded", v)
+		}
+	}
+}
+
+// TestMixedShardFormats - shards written before codecs are read as they are, next to re-encoded shards of the same key
+func TestMixedShardFormats(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	key := append([]byte{1}, make([]byte, 19)...)
+
+	legacy := func(bm io.WriterTo) []byte {
+		var buf bytes.Buffer
+		if _, err := bm.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	put := func(table string, k, v []byte) {
+		if err := tx.Put(table, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 64-bit history index: legacy, delta and legacy shards
+	want := roaring64.New()
+	old := roaring64.BitmapOf(10, 20, 30)
+	old.AddRange(100, 200)
+	put(kv.AccountsHistory, binary.BigEndian.AppendUint64(append([]byte{}, key...), 199), legacy(old))
+	mid := roaring64.BitmapOf(1000, 5000, 90_000)
+	v, err := EncodeShard64(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(kv.AccountsHistory, binary.BigEndian.AppendUint64(append([]byte{}, key...), 90_000), v)
+	last := roaring64.BitmapOf(100_000, 100_001)
+	put(kv.AccountsHistory, binary.BigEndian.AppendUint64(append([]byte{}, key...), ^uint64(0)), legacy(last))
+	want.Or(old)
+	want.Or(mid)
+	want.Or(last)
+
+	got, err := Get64(tx, kv.AccountsHistory, key, 0, MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(want) {
+		t.Fatalf("Get64 %v, want %v", got.ToArray(), want.ToArray())
+	}
+	if n, ok := seekHistory(t, tx, kv.AccountsHistory, key, 2000); !ok || n != 5000 {
+		t.Fatalf("Seek(2000) found %d %t", n, ok)
+	}
+
+	// truncation rewrites what it touches in the new format and keeps reading the rest
+	if err := TruncateRange64(tx, kv.AccountsHistory, key, 5000); err != nil {
+		t.Fatal(err)
+	}
+	want.RemoveRange(5000, MaxUint64)
+	if got, err = Get64(tx, kv.AccountsHistory, key, 0, MaxUint64); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(want) {
+		t.Fatalf("after truncation Get64 %v, want %v", got.ToArray(), want.ToArray())
+	}
+
+	// 32-bit log index: legacy shard followed by a runs shard
+	want32 := roaring.BitmapOf(7, 9, 11)
+	put(kv.LogAddressIndex, binary.BigEndian.AppendUint32(append([]byte{}, key...), 11), legacy(want32))
+	runs := roaring.New()
+	runs.AddRange(1000, 3000)
+	if v, err = EncodeShard(runs); err != nil {
+		t.Fatal(err)
+	}
+	if ShardCodec(v) != CodecRuns {
+		t.Fatalf("codec %x", ShardCodec(v))
+	}
+	put(kv.LogAddressIndex, binary.BigEndian.AppendUint32(append([]byte{}, key...), MaxUint32), v)
+	want32.Or(runs)
+	got32, err := Get(tx, kv.LogAddressIndex, key, 0, MaxUint32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got32.Equals(want32) {
+		t.Fatalf("Get %d values, want %d", got32.GetCardinality(), want32.GetCardinality())
+	}
+}
+
+func BenchmarkEncodeShard64(b *testing.B) {
+	for _, p := range densityProfiles() {
+		b.Run(p.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				v, err := EncodeShard64(p.bm)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(v)
+			}
+			b.ReportMetric(float64(size), "bytes")
+			b.ReportMetric(float64(p.bm.GetSerializedSizeInBytes()), "roaring-bytes")
+		})
+	}
+}
+
+func BenchmarkDecodeShard64(b *testing.B) {
+	for _, p := range densityProfiles() {
+		v, err := EncodeShard64(p.bm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := p.bm.WriteTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+		for _, c := range []struct {
+			name string
+			v    []byte
+		}{{p.name, v}, {p.name + "-roaring", buf.Bytes()}} {
+			b.Run(c.name, func(b *testing.B) {
+				bm := roaring64.New()
+				b.SetBytes(int64(len(c.v)))
+				for i := 0; i < b.N; i++ {
+					if err := DecodeShard64(bm, c.v); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -19,6 +19,7 @@ package bitmapdb
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"github.com/amazechain/amc/internal/kv"
 	"github.com/amazechain/amc/utils"
 	"sort"
@@ -90,6 +91,32 @@ func WalkChunkWithKeys(k []byte, m *roaring.Bitmap, sizeLimit uint64, f func(chu
 	})
 }
 
+// InvertedShardKey - prefix + 2 bytes inverted shard number: newer (bigger) shards sort first
+func InvertedShardKey(prefix []byte, shard uint16) []byte {
+	k := make([]byte, len(prefix)+2)
+	copy(k, prefix)
+	binary.BigEndian.PutUint16(k[len(prefix):], ^shard)
+	return k
+}
+
+// VerifyInvertedShardOrdering - keys are shard keys in logical order, from the oldest shard to the
+// newest one for each prefix. Checks that in on-disk (byte) order every prefix goes from the newest
+// shard to the oldest one, as the inverted shard number intends.
+func VerifyInvertedShardOrdering(keys [][]byte, prefixLen int) error {
+	last := make(map[string][]byte)
+	for _, k := range keys {
+		if len(k) != prefixLen+2 {
+			return fmt.Errorf("shard key %x: length %d, expected %d", k, len(k), prefixLen+2)
+		}
+		prefix := string(k[:prefixLen])
+		if older, ok := last[prefix]; ok && bytes.Compare(k, older) >= 0 {
+			return fmt.Errorf("prefix %x: newer shard %x is not stored before older shard %x", k[:prefixLen], k[prefixLen:], older[prefixLen:])
+		}
+		last[prefix] = k
+	}
+	return nil
+}
+
 // TruncateRange - gets existing bitmap in db and call RemoveRange operator on it.
 // starts from hot shard, stops when shard not overlap with [from-to)
 // !Important: [from, to)
@@ -123,13 +150,12 @@ func TruncateRange(db kv.RwTx, bucket string, key []byte, to uint32) error {
 		return err
 	}
 
-	buf := bytes.NewBuffer(nil)
 	return WalkChunkWithKeys(key, bm, ChunkLimit, func(chunkKey []byte, chunk *roaring.Bitmap) error {
-		buf.Reset()
-		if _, err := chunk.WriteTo(buf); err != nil {
+		v, err := EncodeShard(chunk)
+		if err != nil {
 			return err
 		}
-		return db.Put(bucket, chunkKey, utils.Copy(buf.Bytes()))
+		return db.Put(bucket, chunkKey, v)
 	})
 }
 
@@ -154,7 +180,7 @@ func Get(db kv.Tx, bucket string, key []byte, from, to uint32) (*roaring.Bitmap,
 			break
 		}
 		bm := roaring.New()
-		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+		if err := DecodeShard(bm, v); err != nil {
 			return nil, err
 		}
 		chunks = append(chunks, bm)
@@ -278,16 +304,61 @@ func TruncateRange64(db kv.RwTx, bucket string, key []byte, to uint64) error {
 		return err
 	}
 
-	buf := bytes.NewBuffer(nil)
 	return WalkChunkWithKeys64(key, bm, ChunkLimit, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
-		buf.Reset()
-		if _, err := chunk.WriteTo(buf); err != nil {
+		v, err := EncodeShard64(chunk)
+		if err != nil {
 			return err
 		}
-		return db.Put(bucket, chunkKey, utils.Copy(buf.Bytes()))
+		return db.Put(bucket, chunkKey, v)
 	})
 }
 
+// TruncateBelow64 - removes numbers below `from` from all shards of key, deletes shards left empty.
+// Shard keys stay valid: a shard is keyed by its maximum, which is kept unless the shard is emptied.
+func TruncateBelow64(db kv.RwTx, bucket string, key []byte, from uint64) error {
+	type shard struct {
+		k  []byte
+		bm *roaring64.Bitmap
+	}
+	var shards []shard
+	stop := false // shards are ordered by maximum: once a shard has nothing below from, later ones don't either
+	if err := db.ForPrefix(bucket, key, func(k, v []byte) error {
+		if stop || len(k) != len(key)+8 {
+			return nil
+		}
+		bm := roaring64.New()
+		if err := DecodeShard64(bm, v); err != nil {
+			return err
+		}
+		if !bm.IsEmpty() && bm.Minimum() >= from {
+			stop = true
+			return nil
+		}
+		shards = append(shards, shard{utils.Copy(k), bm})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, s := range shards {
+		s.bm.RemoveRange(0, from)
+		if s.bm.IsEmpty() {
+			if err := db.Delete(bucket, s.k); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := EncodeShard64(s.bm)
+		if err != nil {
+			return err
+		}
+		if err := db.Put(bucket, s.k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Get - reading as much chunks as needed to satisfy [from, to] condition
 // join all chunks to 1 bitmap by Or operator
 func Get64(db kv.Tx, bucket string, key []byte, from, to uint64) (*roaring64.Bitmap, error) {
@@ -310,8 +381,7 @@ func Get64(db kv.Tx, bucket string, key []byte, from, to uint64) (*roaring64.Bit
 			break
 		}
 		bm := roaring64.New()
-		_, err := bm.ReadFrom(bytes.NewReader(v))
-		if err != nil {
+		if err := DecodeShard64(bm, v); err != nil {
 			return nil, err
 		}
 		chunks = append(chunks, bm)
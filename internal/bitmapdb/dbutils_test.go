@@ -0,0 +1,59 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestVerifyInvertedShardOrdering(t *testing.T) {
+	topic, addr := []byte{0x01, 0x02}, []byte{0x01, 0x03}
+	// logical order: shards of each prefix from the oldest to the newest
+	good := [][]byte{
+		InvertedShardKey(topic, 0),
+		InvertedShardKey(addr, 1),
+		InvertedShardKey(topic, 2),
+		InvertedShardKey(addr, 7),
+		InvertedShardKey(topic, 0xffff),
+	}
+	if err := VerifyInvertedShardOrdering(good, 2); err != nil {
+		t.Fatalf("correct keys rejected: %v", err)
+	}
+	if err := VerifyInvertedShardOrdering(nil, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// shard number stored without inversion: oldest shard sorts first
+	plain := func(prefix []byte, shard uint16) []byte {
+		k := append(append([]byte{}, prefix...), 0, 0)
+		binary.BigEndian.PutUint16(k[len(prefix):], shard)
+		return k
+	}
+	bad := [][]byte{plain(topic, 1), plain(topic, 2)}
+	if err := VerifyInvertedShardOrdering(bad, 2); err == nil || !strings.Contains(err.Error(), "0102") {
+		t.Fatalf("non-inverted keys accepted: %v", err)
+	}
+	dup := [][]byte{InvertedShardKey(topic, 3), InvertedShardKey(topic, 3)}
+	if err := VerifyInvertedShardOrdering(dup, 2); err == nil {
+		t.Fatal("duplicate shard accepted")
+	}
+	if err := VerifyInvertedShardOrdering([][]byte{topic}, 2); err == nil {
+		t.Fatal("key without shard number accepted")
+	}
+}
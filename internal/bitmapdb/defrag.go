@@ -0,0 +1,168 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/utils"
+)
+
+// ShardTx - what defrag needs of a tx, RwTx of internal/kv and of erigon-lib both satisfy it
+type ShardTx interface {
+	ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error
+	Put(table string, k, v []byte) error
+	Delete(table string, k []byte) error
+}
+
+// DefragStats - result of defragmentation, shards are counted over all visited keys
+type DefragStats struct {
+	Keys         int
+	Rewritten    int
+	ShardsBefore int
+	ShardsAfter  int
+}
+
+var errGroupEnd = errors.New("end of shard group")
+
+// DefragHistoryIndex - rewrites shards of every key of a 64-bit history index (AccountsHistory, StorageHistory,
+// CallFromIndex, ...) which starts with prefix: bitmaps of the key are joined and cut again into chunks of
+// ChunkLimit, keyed as by WalkChunkWithKeys64 (maximum of the chunk, ^uint64(0) for the last one).
+// Keys which shards are already laid out that way are not touched, shards written before EncodeShard64
+// are re-encoded.
+func DefragHistoryIndex(tx ShardTx, table string, prefix []byte) (DefragStats, error) {
+	return defrag(tx, table, prefix, nil)
+}
+
+// DefragHistoryTable - DefragHistoryIndex of the whole table. progress gets share of the table done,
+// estimated by position of the last key in key space: keys of history indices start with an address.
+func DefragHistoryTable(tx ShardTx, table string, progress func(share float64)) (DefragStats, error) {
+	return defrag(tx, table, nil, progress)
+}
+
+type shardKV struct {
+	k, v []byte
+}
+
+func defrag(tx ShardTx, table string, prefix []byte, progress func(share float64)) (DefragStats, error) {
+	var stats DefragStats
+	for seek := prefix; ; {
+		// shards of one key are adjacent: read them, stop at the first key of the next group
+		var group []shardKV
+		var key []byte
+		next := []byte(nil)
+		if err := tx.ForEach(table, seek, func(k, v []byte) error {
+			if !bytes.HasPrefix(k, prefix) || len(k) < 8 {
+				return errGroupEnd
+			}
+			if key == nil {
+				key = utils.Copy(k[:len(k)-8])
+			} else if !bytes.Equal(k[:len(k)-8], key) {
+				next = utils.Copy(k)
+				return errGroupEnd
+			}
+			group = append(group, shardKV{utils.Copy(k), utils.Copy(v)})
+			return nil
+		}); err != nil && !errors.Is(err, errGroupEnd) {
+			return stats, err
+		}
+		if key == nil {
+			break
+		}
+		after, rewritten, err := defragKey(tx, table, key, group)
+		if err != nil {
+			return stats, err
+		}
+		stats.Keys++
+		stats.ShardsBefore += len(group)
+		stats.ShardsAfter += after
+		if rewritten {
+			stats.Rewritten++
+		}
+		if progress != nil {
+			progress(keyShare(key))
+		}
+		if next == nil {
+			break
+		}
+		seek = next
+	}
+	if progress != nil {
+		progress(1)
+	}
+	return stats, nil
+}
+
+func defragKey(tx ShardTx, table string, key []byte, group []shardKV) (int, bool, error) {
+	bm := roaring64.New()
+	for _, s := range group {
+		shard := roaring64.New()
+		if err := DecodeShard64(shard, s.v); err != nil {
+			return 0, false, err
+		}
+		bm.Or(shard)
+	}
+
+	var chunks []shardKV
+	if err := WalkChunkWithKeys64(key, bm, ChunkLimit, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
+		v, err := EncodeShard64(chunk)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, shardKV{chunkKey, v})
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+	if sameShards(group, chunks) {
+		return len(group), false, nil
+	}
+
+	for _, s := range group {
+		if err := tx.Delete(table, s.k); err != nil {
+			return 0, false, err
+		}
+	}
+	for _, s := range chunks {
+		if err := tx.Put(table, s.k, s.v); err != nil {
+			return 0, false, err
+		}
+	}
+	return len(chunks), true, nil
+}
+
+func sameShards(a, b []shardKV) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].k, b[i].k) || !bytes.Equal(a[i].v, b[i].v) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyShare - position of key in key space, in [0, 1)
+func keyShare(key []byte) float64 {
+	var b [8]byte
+	copy(b[:], key)
+	return float64(binary.BigEndian.Uint64(b[:])) / (1 << 64)
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// seekHistory - first block >= x where key changed, the way history readers find it: Seek(key+bigEndian(x))
+func seekHistory(t *testing.T, tx kv.Tx, table string, key []byte, x uint64) (uint64, bool) {
+	t.Helper()
+	c, err := tx.Cursor(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	k, v, err := c.Seek(binary.BigEndian.AppendUint64(append([]byte{}, key...), x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k == nil || len(k) != len(key)+8 || !bytes.HasPrefix(k, key) {
+		return 0, false
+	}
+	bm := roaring64.New()
+	if err := DecodeShard64(bm, v); err != nil {
+		t.Fatal(err)
+	}
+	it := bm.Iterator()
+	it.AdvanceIfNeeded(x)
+	if !it.HasNext() {
+		return 0, false
+	}
+	return it.Next(), true
+}
+
+func writeShard(t *testing.T, tx kv.RwTx, table string, key []byte, shard uint64, blocks []uint64) {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := roaring64.BitmapOf(blocks...).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put(table, binary.BigEndian.AppendUint64(append([]byte{}, key...), shard), buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefragHistoryIndex(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	rnd := rand.New(rand.NewSource(1))
+	addr := func(i byte) []byte { return append([]byte{i}, make([]byte, 19)...) }
+
+	// StorageHistory keys: address + location. Location 1 of address 1 is fragmented into tiny shards
+	// (as left by pruning), location 2 has more blocks than fit one shard, address 2 is untouched by prefix defrag.
+	var keys [][]byte
+	var tiny []uint64
+	for b := uint64(100); b < 2000; b += 1 + uint64(rnd.Intn(50)) {
+		tiny = append(tiny, b)
+	}
+	loc1 := append(addr(1), bytes.Repeat([]byte{1}, 32)...)
+	for i, b := range tiny {
+		shard := b
+		if i == len(tiny)-1 {
+			shard = ^uint64(0)
+		}
+		writeShard(t, tx, kv.StorageHistory, loc1, shard, []uint64{b})
+	}
+	keys = append(keys, loc1)
+
+	var many []uint64
+	for b := uint64(0); b < 1_000_000; b += 1 + uint64(rnd.Intn(200)) {
+		many = append(many, b)
+	}
+	loc2 := append(addr(1), bytes.Repeat([]byte{2}, 32)...)
+	writeShard(t, tx, kv.StorageHistory, loc2, many[len(many)/2-1], many[:len(many)/2])
+	writeShard(t, tx, kv.StorageHistory, loc2, ^uint64(0), many[len(many)/2:])
+	keys = append(keys, loc2)
+
+	other := append(addr(2), bytes.Repeat([]byte{1}, 32)...)
+	writeShard(t, tx, kv.StorageHistory, other, 10, []uint64{10})
+	writeShard(t, tx, kv.StorageHistory, other, ^uint64(0), []uint64{20})
+	keys = append(keys, other)
+
+	probes := []uint64{0, 1, 99, 100, 101, 500, 1999, 2000, 499_999, 500_000, 500_001, 999_999, 1_000_000}
+	for i := 0; i < 200; i++ {
+		probes = append(probes, uint64(rnd.Intn(1_000_100)))
+	}
+	type answer struct {
+		block uint64
+		ok    bool
+	}
+	before := make(map[string][]answer)
+	for _, key := range keys {
+		for _, x := range probes {
+			n, ok := seekHistory(t, tx, kv.StorageHistory, key, x)
+			before[string(key)] = append(before[string(key)], answer{n, ok})
+		}
+	}
+
+	stats, err := DefragHistoryIndex(tx, kv.StorageHistory, addr(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 2 || stats.Rewritten != 2 || stats.ShardsBefore != len(tiny)+2 || stats.ShardsAfter >= stats.ShardsBefore {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	for _, key := range keys {
+		for i, x := range probes {
+			n, ok := seekHistory(t, tx, kv.StorageHistory, key, x)
+			if want := before[string(key)][i]; n != want.block || ok != want.ok {
+				t.Fatalf("key %x: Seek(%d) found %d %t, before defrag %d %t", key[:21], x, n, ok, want.block, want.ok)
+			}
+		}
+	}
+	// shard layout of a defragmented key: every shard but the last keyed by its maximum, ^0 for the last
+	for _, key := range [][]byte{loc1, loc2} {
+		var shards [][]byte
+		if err := tx.ForPrefix(kv.StorageHistory, key, func(k, v []byte) error {
+			if len(v) > int(ChunkLimit) {
+				t.Fatalf("shard %x of %d bytes", k, len(v))
+			}
+			shards = append(shards, append([]byte{}, k...))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if last := shards[len(shards)-1]; binary.BigEndian.Uint64(last[len(key):]) != ^uint64(0) {
+			t.Fatalf("last shard key %x", last)
+		}
+	}
+
+	// whole table: second pass has nothing left to rewrite but address 2
+	var shares []float64
+	stats, err = DefragHistoryTable(tx, kv.StorageHistory, func(share float64) { shares = append(shares, share) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 3 || stats.Rewritten != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if len(shares) != 4 || shares[len(shares)-1] != 1 {
+		t.Fatalf("progress %v", shares)
+	}
+	for i := 1; i < len(shares); i++ {
+		if shares[i] < shares[i-1] {
+			t.Fatalf("progress goes back: %v", shares)
+		}
+	}
+	if n, ok := seekHistory(t, tx, kv.StorageHistory, other, 11); !ok || n != 20 {
+		t.Fatalf("address 2: Seek(11) found %d %t", n, ok)
+	}
+}
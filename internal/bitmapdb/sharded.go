@@ -0,0 +1,185 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/utils"
+)
+
+// IndexReader - what SeekInIndex needs of a tx, Tx of internal/kv and of erigon-lib both satisfy it
+type IndexReader interface {
+	ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error
+}
+
+// UpsertShardedIndex - adds delta to the bitmap of key in an index keyed by InvertedShardKey
+// (LogTopicIndex, LogAddressIndex): the newest shard of key is merged with delta and cut again into
+// shards of at most shardLimit serialized bytes, numbered on from the newest one. A value which alone
+// exceeds shardLimit gets a shard of its own. Older shards are not touched.
+func UpsertShardedIndex(tx ShardTx, table string, key []byte, delta *roaring.Bitmap, shardLimit int) error {
+	if delta.IsEmpty() {
+		return nil
+	}
+	if shardLimit <= 0 {
+		return fmt.Errorf("upsert %s %x: shard limit %d", table, key, shardLimit)
+	}
+	var (
+		shard  uint16
+		newest []byte
+	)
+	bm := roaring.New()
+	if err := tx.ForEach(table, key, func(k, v []byte) error {
+		if !isShardOf(k, key) {
+			return errGroupEnd
+		}
+		shard = ^binary.BigEndian.Uint16(k[len(key):])
+		newest = append([]byte{}, k...)
+		if err := DecodeShard(bm, v); err != nil {
+			return fmt.Errorf("shard %d: %w", shard, err)
+		}
+		return errGroupEnd
+	}); err != nil && !errors.Is(err, errGroupEnd) {
+		return fmt.Errorf("upsert %s %x: %w", table, key, err)
+	}
+	if newest != nil {
+		if err := tx.Delete(table, newest); err != nil {
+			return err
+		}
+	}
+
+	bm.Or(delta)
+	for first := true; !bm.IsEmpty(); first = false {
+		chunk := CutLeft(bm, uint64(shardLimit))
+		if chunk.IsEmpty() {
+			// a single value bigger than the limit
+			min := bm.Minimum()
+			chunk = roaring.BitmapOf(min)
+			bm.Remove(min)
+		}
+		if !first {
+			if shard == math.MaxUint16 {
+				return fmt.Errorf("upsert %s %x: out of shard numbers", table, key)
+			}
+			shard++
+		}
+		v, err := EncodeShard(chunk)
+		if err != nil {
+			return err
+		}
+		if err := tx.Put(table, InvertedShardKey(key, shard), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TruncateShardedIndex - removes the values >= from of every key of an index written by UpsertShardedIndex,
+// shards left empty are deleted. Shards are merged only into the newest one, so the whole table is visited.
+func TruncateShardedIndex(tx ShardTx, table string, from uint32) error {
+	var changed []shardKV // v is nil for a shard to delete
+	bm := roaring.New()
+	if err := tx.ForEach(table, nil, func(k, v []byte) error {
+		bm.Clear()
+		if err := DecodeShard(bm, v); err != nil {
+			return fmt.Errorf("shard %x: %w", k, err)
+		}
+		if bm.IsEmpty() || bm.Maximum() < from {
+			return nil
+		}
+		bm.RemoveRange(uint64(from), MaxUint32+1)
+		c := shardKV{k: utils.Copy(k)}
+		if !bm.IsEmpty() {
+			enc, err := EncodeShard(bm)
+			if err != nil {
+				return err
+			}
+			c.v = enc
+		}
+		changed = append(changed, c)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("truncate %s: %w", table, err)
+	}
+	for _, c := range changed {
+		var err error
+		if c.v == nil {
+			err = tx.Delete(table, c.k)
+		} else {
+			err = tx.Put(table, c.k, c.v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeekInIndex - the smallest value >= n in the shards of key, written by UpsertShardedIndex
+func SeekInIndex(tx IndexReader, table string, key []byte, n uint32) (found uint32, ok bool, err error) {
+	bm := roaring.New()
+	// shards are merged only into the newest one, so older shards may hold bigger values: look at all
+	if err := tx.ForEach(table, key, func(k, v []byte) error {
+		if !isShardOf(k, key) {
+			return errGroupEnd
+		}
+		bm.Clear()
+		if err := DecodeShard(bm, v); err != nil {
+			return err
+		}
+		if x, has := SeekInBitmap(bm, n); has && (!ok || x < found) {
+			found, ok = x, true
+		}
+		return nil
+	}); err != nil && !errors.Is(err, errGroupEnd) {
+		return 0, false, fmt.Errorf("seek %s %x: %w", table, key, err)
+	}
+	return found, ok, nil
+}
+
+// ReadIndex - the values in [from, to] of all shards of key, written by UpsertShardedIndex
+func ReadIndex(tx IndexReader, table string, key []byte, from, to uint32) (*roaring.Bitmap, error) {
+	res := roaring.New()
+	bm := roaring.New()
+	if err := tx.ForEach(table, key, func(k, v []byte) error {
+		if !isShardOf(k, key) {
+			return errGroupEnd
+		}
+		bm.Clear()
+		if err := DecodeShard(bm, v); err != nil {
+			return err
+		}
+		res.Or(bm)
+		return nil
+	}); err != nil && !errors.Is(err, errGroupEnd) {
+		return nil, fmt.Errorf("read %s %x: %w", table, key, err)
+	}
+	if from > 0 {
+		res.RemoveRange(0, uint64(from))
+	}
+	res.RemoveRange(uint64(to)+1, MaxUint32+1)
+	return res, nil
+}
+
+func isShardOf(k, key []byte) bool {
+	return len(k) == len(key)+2 && bytes.HasPrefix(k, key)
+}
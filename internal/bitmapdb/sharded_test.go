@@ -0,0 +1,145 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package bitmapdb
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// indexShards - shard keys of key in logical order and their bitmaps
+func indexShards(t *testing.T, tx kv.Tx, table string, key []byte) ([][]byte, []*roaring.Bitmap) {
+	t.Helper()
+	var keys [][]byte
+	var shards []*roaring.Bitmap
+	if err := tx.ForEach(table, key, func(k, v []byte) error {
+		if !isShardOf(k, key) {
+			return errGroupEnd
+		}
+		bm := roaring.New()
+		if err := DecodeShard(bm, v); err != nil {
+			return err
+		}
+		// on disk the newest shard comes first
+		keys = append([][]byte{append([]byte{}, k...)}, keys...)
+		shards = append([]*roaring.Bitmap{bm}, shards...)
+		return nil
+	}); err != nil && err != errGroupEnd {
+		t.Fatal(err)
+	}
+	return keys, shards
+}
+
+func TestUpsertShardedIndex(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	topic, addr := []byte{0x01, 0x02}, []byte{0x01, 0x03}
+	rnd := rand.New(rand.NewSource(1))
+	for _, limit := range []int{64, 256, int(ChunkLimit)} {
+		t.Run("", func(t *testing.T) {
+			if err := tx.ClearBucket(kv.LogTopicIndex); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]*roaring.Bitmap{string(topic): roaring.New(), string(addr): roaring.New()}
+			var block uint32
+			for i := 0; i < 300; i++ {
+				key := topic
+				if rnd.Intn(3) == 0 {
+					key = addr
+				}
+				delta := roaring.New()
+				switch rnd.Intn(10) {
+				case 0: // a reorg: blocks below the newest shard again
+					delta.Add(uint32(rnd.Intn(int(block) + 1)))
+				case 1: // catching up, a delta larger than a shard
+					delta.AddRange(uint64(block), uint64(block)+uint64(rnd.Intn(5000)))
+				default:
+					for j := rnd.Intn(8); j >= 0; j-- {
+						delta.Add(block + uint32(rnd.Intn(50)))
+					}
+				}
+				block += uint32(rnd.Intn(100)) + 1
+				if err := UpsertShardedIndex(tx, kv.LogTopicIndex, key, delta, limit); err != nil {
+					t.Fatal(err)
+				}
+				want[string(key)].Or(delta)
+			}
+
+			for key, bm := range want {
+				keys, shards := indexShards(t, tx, kv.LogTopicIndex, []byte(key))
+				if err := VerifyInvertedShardOrdering(keys, len(key)); err != nil {
+					t.Fatal(err)
+				}
+				for i, shard := range shards {
+					if shard.IsEmpty() {
+						t.Fatalf("key %x: shard %d is empty", key, i)
+					}
+					if sz := shard.GetSerializedSizeInBytes(); sz > uint64(limit) && shard.GetCardinality() > 1 {
+						t.Fatalf("key %x: shard %d of %d bytes over limit %d", key, i, sz, limit)
+					}
+				}
+				if got := roaring.FastOr(shards...); !got.Equals(bm) {
+					t.Fatalf("key %x: union of shards has %d values, inserted %d", key, got.GetCardinality(), bm.GetCardinality())
+				}
+				for i := 0; i < 50; i++ {
+					n := uint32(rnd.Intn(int(block) + 10))
+					wantX, wantOk := SeekInBitmap(bm, n)
+					x, ok, err := SeekInIndex(tx, kv.LogTopicIndex, []byte(key), n)
+					if err != nil || x != wantX || ok != wantOk {
+						t.Fatalf("key %x: seek %d = %d %t %v, want %d %t", key, n, x, ok, err, wantX, wantOk)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestUpsertShardedIndexOversized - a delta far over the limit is split, down to single values which
+// alone exceed it
+func TestUpsertShardedIndexOversized(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	key := []byte{0xaa}
+	delta := roaring.New()
+	for i := uint32(0); i < 20; i++ {
+		delta.Add(i * 100_000)
+	}
+	if err := UpsertShardedIndex(tx, kv.LogAddressIndex, key, delta, 1); err != nil {
+		t.Fatal(err)
+	}
+	keys, shards := indexShards(t, tx, kv.LogAddressIndex, key)
+	if len(shards) != 20 {
+		t.Fatalf("%d shards, want one per value", len(shards))
+	}
+	if err := VerifyInvertedShardOrdering(keys, len(key)); err != nil {
+		t.Fatal(err)
+	}
+	if got := roaring.FastOr(shards...); !got.Equals(delta) {
+		t.Fatalf("union of shards %v, want %v", got, delta)
+	}
+	if x, ok, err := SeekInIndex(tx, kv.LogAddressIndex, key, 1); err != nil || !ok || x != 100_000 {
+		t.Fatalf("seek = %d %t %v", x, ok, err)
+	}
+	if _, ok, err := SeekInIndex(tx, kv.LogAddressIndex, key, 1_900_001); err != nil || ok {
+		t.Fatalf("seek past the end = %t %v", ok, err)
+	}
+	if err := UpsertShardedIndex(tx, kv.LogAddressIndex, key, delta, 0); err == nil {
+		t.Fatal("zero shard limit accepted")
+	}
+}
@@ -80,6 +80,9 @@ func (v *BlockValidator) ValidateBody(b block.IBlock) error {
 	if hash := DeriveSha(transaction.Transactions(b.Transactions())); hash != b.TxHash() {
 		return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, b.TxHash())
 	}
+	if err := ValidateTxValidity(v.config, b.Header().(*block.Header), b.Transactions()); err != nil {
+		return err
+	}
 
 	if !v.bc.HasBlockAndState(b.ParentHash(), b.Number64().Uint64()-1) {
 		if !v.bc.HasBlock(b.ParentHash(), b.Number64().Uint64()-1) {
@@ -87,6 +90,11 @@ func (v *BlockValidator) ValidateBody(b block.IBlock) error {
 		}
 		return ErrPrunedAncestor
 	}
+	// the plain state is the state of the head, a block on any other canonical
+	// block is executed once the chain is unwound to it, see insertSideChain
+	if b.ParentHash() != v.bc.CurrentBlock().Hash() {
+		return ErrPrunedAncestor
+	}
 	return nil
 }
 
@@ -37,8 +37,12 @@ import (
 	block2 "github.com/amazechain/amc/common/block"
 	"github.com/amazechain/amc/common/message"
 	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/blockimport"
 	"github.com/amazechain/amc/internal/consensus"
+	"github.com/amazechain/amc/internal/diskguard"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules"
 	event "github.com/amazechain/amc/modules/event/v2"
 	"github.com/amazechain/amc/modules/rawdb"
 	lru "github.com/hashicorp/golang-lru"
@@ -71,6 +75,7 @@ const (
 	maxFutureBlocks     = 256
 	tdCacheLimit        = 1024
 	maxTimeFutureBlocks = 5 * 60 // 5 min
+	staleForkChunk      = 1024   // headers looked at by a stale fork pass
 )
 
 type BlockChain struct {
@@ -110,6 +115,16 @@ type BlockChain struct {
 
 	forker    *ForkChoice
 	validator Validator
+
+	eventJournal  *rawdb.EventJournalRetention // nil disables the durable event journal
+	storageWatch  atomic.Value                 // rawdb.StorageWatchIndex
+	feeAccounting bool                         // index fees per address
+	accessLists   bool                         // record the accounts and slots each block touched
+	accessListTTL uint64                       // blocks below the head access lists are kept for, 0 keeps them forever
+	maintenance   *maintenance.Mode            // nil never freezes
+	diskGuard     *diskguard.Guard             // nil never pauses
+	importer      *blockimport.Coordinator     // single-flight imports of gossiped, sealed and designated blocks
+	forkRetention uint64                       // depth below the head kept for side chains, 0 keeps them forever
 }
 
 type insertStats struct {
@@ -166,6 +181,10 @@ func NewBlockChain(ctx context.Context, genesisBlock block2.IBlock, engine conse
 	//bc.process = avm.NewVMProcessor(ctx, bc, engine)
 	bc.process = NewStateProcessor(config, bc, engine)
 	bc.validator = NewBlockValidator(config, bc, engine)
+	bc.importer = blockimport.New(bc.importBlock)
+	if err := bc.ReloadStorageWatches(); nil != err {
+		log.Warn("failed to load storage watchlist", "err", err)
+	}
 
 	return bc, nil
 }
@@ -216,6 +235,7 @@ func (bc *BlockChain) Start() error {
 	go bc.runLoop()
 	go bc.newBlockLoop()
 	go bc.updateFutureBlocksLoop()
+	go bc.importer.Run(bc.ctx)
 
 	return nil
 }
@@ -423,7 +443,7 @@ func (bc *BlockChain) newBlockLoop() {
 						inserted = false
 						bc.addFutureBlock(&block)
 					} else {
-						if _, err := bc.InsertChain([]block2.IBlock{&block}); err != nil {
+						if err := bc.ImportBlock(bc.ctx, blockimport.SourceGossip, &block); err != nil {
 							inserted = false
 							log.Errorf("failed to inster new block in blockchain, err:%v", err)
 						} else {
@@ -607,7 +627,12 @@ func (bc *BlockChain) GetHeaderByHash(h types.Hash) (block2.IHeader, error) {
 		return nil, err
 	}
 	defer tx.Rollback()
-	return rawdb.ReadHeaderByHash(tx, h)
+	header, err := rawdb.ReadHeaderByHash(tx, h)
+	if header == nil {
+		// a nil *block.Header would make a non-nil IHeader
+		return nil, err
+	}
+	return header, nil
 }
 
 // GetCanonicalHash returns the canonical hash for a given block number
@@ -626,6 +651,7 @@ func (bc *BlockChain) GetBlockByHash(h types.Hash) (block2.IBlock, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 	block, err := rawdb.ReadBlockByHash(tx, h)
 
 	if err != nil {
@@ -732,11 +758,29 @@ func (bc *BlockChain) GetBlock(hash types.Hash, number uint64) block2.IBlock {
 }
 
 func (bc *BlockChain) SealedBlock(b block2.IBlock) {
+	// the copy echoed by gossip joins this import
+	go func() {
+		if err := bc.ImportBlock(bc.ctx, blockimport.SourceLocal, b); err != nil {
+			log.Warn("failed to import sealed block", "hash", b.Hash(), "number", b.Number64().Uint64(), "err", err)
+		}
+	}()
 	pbBlock := b.ToProtoMessage()
 
 	_ = bc.pubsub.Publish(message.GossipBlockMessage, pbBlock)
 }
 
+// ImportBlock imports b through the import coordinator, see package blockimport: a block delivered by
+// several sources at once is executed once and every caller gets the result of that execution.
+func (bc *BlockChain) ImportBlock(ctx context.Context, source blockimport.Source, b block2.IBlock) error {
+	return bc.importer.Import(ctx, source, b)
+}
+
+// importBlock executes the imports of the coordinator
+func (bc *BlockChain) importBlock(b block2.IBlock) error {
+	_, err := bc.InsertChain([]block2.IBlock{b})
+	return err
+}
+
 // StopInsert stop insert
 func (bc *BlockChain) StopInsert() {
 	atomic.StoreInt32(&bc.procInterrupt, 1)
@@ -829,6 +873,14 @@ func (bc *BlockChain) InsertChain(chain []block2.IBlock) (int, error) {
 				prev.Hash().Bytes()[:4], i, block.Number64().String(), block.Hash().Bytes()[:4], block.ParentHash().Bytes()[:4])
 		}
 	}
+	if err := bc.diskGuard.Admit(); nil != err {
+		return 0, err
+	}
+	release, err := bc.maintenance.BeginWrite()
+	if nil != err {
+		return 0, err
+	}
+	defer release()
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 	return bc.insertChain(chain)
@@ -967,7 +1019,10 @@ func (bc *BlockChain) insertChain(chain []block2.IBlock) (int, error) {
 		//	return err
 		//}
 
-		stateReader := state.NewPlainStateReader(tx)
+		var stateReader state.StateReader = state.NewCodeCachingReader(state.NewPlainStateReader(tx), state.DefaultCodeCache.Consumer("execution"))
+		if bc.accessLists {
+			stateReader = state.NewAccessRecorder(stateReader)
+		}
 		ibs := state.New(stateReader)
 		stateWriter := state.NewPlainStateWriter(tx, tx, block.Number64().Uint64())
 
@@ -1022,6 +1077,9 @@ func (bc *BlockChain) insertChain(chain []block2.IBlock) (int, error) {
 				//atomic.StoreUint32(&followupInterrupt, 1)
 				return err
 			}
+			if recorder, ok := reader.(*state.AccessRecorder); ok {
+				return rawdb.WriteBlockAccessList(tx, block.Number64().Uint64(), block.Hash(), recorder.AccessList())
+			}
 
 			return nil
 		}); nil != err {
@@ -1137,21 +1195,8 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 				externTd = *pt
 				continue
 			}
-			if canonical != nil && canonical.StateRoot() == block.StateRoot() {
-				// This is most likely a shadow-state attack. When a fork is imported into the
-				// database, and it eventually reaches a block height which is not pruned, we
-				// just found that the state already exist! This means that the sidechain block
-				// refers to a state which already exists in our canon chain.
-				//
-				// If left unchecked, we would now proceed importing the blocks, without actually
-				// having verified the state of the previous blocks.
-				log.Warn("Sidechain ghost-state attack detected", "number", block.Number64(), "sideroot", block.StateRoot(), "canonroot", canonical.StateRoot())
-
-				// If someone legitimately side-mines blocks, they would still be imported as usual. However,
-				// we cannot risk writing unverified blocks to disk when they obviously target the pruning
-				// mechanism.
-				return it.index, errors.New("sidechain ghost-state attack")
-			}
+			// There is no ghost-state check against the canonical root: no state is kept per
+			// block, a side chain is always executed on the plain state unwound to its fork point.
 		}
 		if externTd.Cmp(uint256.NewInt(0)) == 0 {
 			externTd = *bc.GetTd(block.ParentHash(), uint256.NewInt(0).Sub(block.Number64(), uint256.NewInt(1)))
@@ -1160,7 +1205,7 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 
 		if !bc.HasBlock(block.Hash(), block.Number64().Uint64()) {
 			start := time.Now()
-			if err := bc.WriteBlockWithoutState(block); err != nil {
+			if err := bc.WriteBlockWithoutState(block, &externTd); err != nil {
 				return it.index, err
 			}
 			log.Debug("Injected sidechain block", "number", block.Number64(), "hash", block.Hash(),
@@ -1186,7 +1231,7 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 		numbers []uint64
 	)
 	parent := it.previous()
-	for parent != nil && !bc.HasState(parent.StateRoot()) {
+	for parent != nil && !bc.HasState(parent.Hash()) {
 		hashes = append(hashes, parent.Hash())
 		numbers = append(numbers, parent.Number64().Uint64())
 
@@ -1195,7 +1240,12 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 	if parent == nil {
 		return it.index, errors.New("missing parent")
 	}
-	// Import all the pruned blocks to make the state available
+	// The plain state only exists for the head, unwind it to the fork point and
+	// import all the side chain blocks on top of it
+	oldChain, err := bc.unwindHead(parent)
+	if err != nil {
+		return it.index, err
+	}
 	var (
 		blocks []block2.IBlock
 	)
@@ -1211,6 +1261,7 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 		if len(blocks) >= 2048 {
 			log.Info("Importing heavy sidechain segment", "blocks", len(blocks), "start", blocks[0].Number64(), "end", block.Number64())
 			if _, err := bc.insertChain(blocks); err != nil {
+				bc.restoreHead(parent, oldChain)
 				return 0, err
 			}
 			blocks = blocks[:0]
@@ -1223,11 +1274,94 @@ func (bc *BlockChain) insertSideChain(block block2.IBlock, it *insertIterator) (
 	}
 	if len(blocks) > 0 {
 		log.Info("Importing sidechain segment", "start", blocks[0].Number64(), "end", blocks[len(blocks)-1].Number64())
-		return bc.insertChain(blocks)
+		n, err := bc.insertChain(blocks)
+		if err != nil {
+			bc.restoreHead(parent, oldChain)
+		}
+		return n, err
 	}
 	return 0, nil
 }
 
+// unwindHead makes fork, a canonical block, the head again. The plain state, its
+// changesets and history, the receipts, call traces and the indexes of the blocks
+// above fork are unwound, and their transactions are demoted to NonCanonicalTxs.
+// The unwound blocks are returned from the old head down.
+func (bc *BlockChain) unwindHead(fork block2.IHeader) (block2.Blocks, error) {
+	var (
+		oldChain  block2.Blocks
+		forkBlock block2.IBlock
+		forkNum   = fork.Number64().Uint64()
+		head      = bc.CurrentBlock()
+		headNum   = head.Number64().Uint64()
+	)
+	if err := bc.ChainDB.Update(bc.ctx, func(tx kv.RwTx) error {
+		for b := head; b.Hash() != fork.Hash(); {
+			oldChain = append(oldChain, b)
+			if b = rawdb.ReadBlock(tx, b.ParentHash(), b.Number64().Uint64()-1); b == nil {
+				return fmt.Errorf("invalid old chain")
+			}
+			forkBlock = b
+		}
+		if len(oldChain) == 0 {
+			return nil
+		}
+		log.Info("Unwinding chain to fork point", "number", forkNum, "hash", fork.Hash(), "drop", len(oldChain), "dropfrom", oldChain[0].Hash())
+		if err := bc.unwindIndexes(tx, forkBlock, oldChain); nil != err {
+			return err
+		}
+		if err := state.UnwindPlainState(tx, headNum, forkNum); nil != err {
+			return err
+		}
+		if err := rawdb.TruncateReceipts(tx, forkNum+1); nil != err {
+			return err
+		}
+		if err := rawdb.UnwindCallTraceIndexes(tx, forkNum); nil != err {
+			return err
+		}
+		for _, b := range oldChain {
+			body, err := rawdb.ReadBodyForStorageByKey(tx, modules.BlockBodyKey(b.Number64().Uint64(), b.Hash()))
+			if nil != err {
+				return err
+			}
+			base, err := rawdb.IncrementSequence(tx, modules.NonCanonicalTxs, uint64(body.TxAmount))
+			if nil != err {
+				return err
+			}
+			if err := rawdb.DemoteCanonicalTxs(tx, b.Hash().Bytes(), base); nil != err {
+				return err
+			}
+		}
+		if err := rawdb.TruncateCanonicalHash(tx, forkNum+1, false); nil != err {
+			return err
+		}
+		rawdb.WriteHeadBlockHash(tx, fork.Hash())
+		return nil
+	}); nil != err {
+		return nil, err
+	}
+	if forkBlock != nil {
+		bc.currentBlock = forkBlock
+	}
+	return oldChain, nil
+}
+
+// restoreHead puts oldChain, unwound by unwindHead, back on top of fork after the
+// side chain failed to import.
+func (bc *BlockChain) restoreHead(fork block2.IHeader, oldChain block2.Blocks) {
+	if _, err := bc.unwindHead(fork); nil != err {
+		log.Error("Failed to unwind the side chain", "number", fork.Number64(), "hash", fork.Hash(), "err", err)
+		return
+	}
+	blocks := make([]block2.IBlock, 0, len(oldChain))
+	for i := len(oldChain) - 1; i >= 0; i-- {
+		blocks = append(blocks, oldChain[i])
+	}
+	if _, err := bc.insertChain(blocks); nil != err {
+		log.Error("Failed to restore the old chain", "number", fork.Number64(), "hash", fork.Hash(), "err", err)
+	}
+}
+
 // recoverAncestors
 func (bc *BlockChain) recoverAncestors(block block2.IBlock) (types.Hash, error) {
 	var (
@@ -1258,15 +1392,15 @@ func (bc *BlockChain) recoverAncestors(block block2.IBlock) (types.Hash, error)
 	return block.Hash(), nil
 }
 
-// WriteBlockWithoutState without state
-func (bc *BlockChain) WriteBlockWithoutState(block block2.IBlock) (err error) {
+// WriteBlockWithoutState writes a side chain block and its total difficulty, without state
+func (bc *BlockChain) WriteBlockWithoutState(block block2.IBlock, td *uint256.Int) (err error) {
 	if bc.insertStopped() {
 		return errInsertionInterrupted
 	}
-	//if err := bc.state.WriteTD(block.Hash(), td); err != nil {
-	//	return err
-	//}
 	return bc.ChainDB.Update(bc.ctx, func(tx kv.RwTx) error {
+		if err := rawdb.WriteTd(tx, block.Hash(), block.Number64().Uint64(), td); err != nil {
+			return err
+		}
 		if err := rawdb.WriteBlock(tx, block.(*block2.Block)); err != nil {
 			return err
 		}
@@ -1359,6 +1493,30 @@ func (bc *BlockChain) writeHeadBlock(tx kv.RwTx, block block2.IBlock) error {
 	if err = rawdb.WriteCanonicalHash(tx, block.Hash(), block.Number64().Uint64()); nil != err {
 		return err
 	}
+	if err = rawdb.AppendCumulativeIndexes(tx, block.Number64().Uint64(), block.GasUsed(), uint64(len(block.Transactions()))); nil != err {
+		return err
+	}
+	if err = rawdb.RecordBlockSize(tx, block.Hash(), block.Number64().Uint64()); nil != err {
+		return err
+	}
+	if err = bc.journalHeadBlock(tx, block); nil != err {
+		return err
+	}
+	if err = bc.matchStorageWatches(tx, block); nil != err {
+		return err
+	}
+	if err = bc.indexFees(tx, block); nil != err {
+		return err
+	}
+	if err = bc.indexLogs(tx, block); nil != err {
+		return err
+	}
+	if err = bc.pruneAccessLists(tx, block); nil != err {
+		return err
+	}
+	if err = bc.pruneStaleForks(tx, block); nil != err {
+		return err
+	}
 	bc.currentBlock = block
 	if notExternalTx {
 		if err = tx.Commit(); nil != err {
@@ -1368,6 +1526,237 @@ func (bc *BlockChain) writeHeadBlock(tx kv.RwTx, block block2.IBlock) error {
 	return nil
 }
 
+// SetEventJournal enables the durable event journal. Head changes and
+// reorgs are appended in the same transaction that applies them, and
+// entries are pruned according to retention.
+func (bc *BlockChain) SetEventJournal(retention rawdb.EventJournalRetention) {
+	bc.eventJournal = &retention
+}
+
+// SetMaintenance sets the maintenance mode gating chain writes, see package maintenance.
+func (bc *BlockChain) SetMaintenance(m *maintenance.Mode) {
+	bc.maintenance = m
+}
+
+// Maintenance returns the maintenance mode of the chain, nil if it has none.
+func (bc *BlockChain) Maintenance() *maintenance.Mode {
+	return bc.maintenance
+}
+
+// SetDiskGuard sets the guard pausing block imports on low disk space, see package diskguard.
+func (bc *BlockChain) SetDiskGuard(g *diskguard.Guard) {
+	bc.diskGuard = g
+}
+
+// DiskGuard returns the disk guard of the chain, nil if it has none.
+func (bc *BlockChain) DiskGuard() *diskguard.Guard {
+	return bc.diskGuard
+}
+
+// SetFeeAccounting enables the per-address fee accounting index. Blocks
+// before it was enabled are indexed with the db fee-accounting-backfill command.
+func (bc *BlockChain) SetFeeAccounting(enabled bool) {
+	bc.feeAccounting = enabled
+}
+
+// SetAccessLists enables recording the accounts and storage slots each
+// executed block touched, kept for retention blocks below the head, 0 keeps
+// them forever. Blocks imported before, and blocks sealed by the local miner,
+// are recorded with the db access-list-backfill command.
+func (bc *BlockChain) SetAccessLists(enabled bool, retention uint64) {
+	bc.accessLists = enabled
+	bc.accessListTTL = retention
+}
+
+// SetForkRetention enables the removal of side chain blocks more than
+// retention blocks below the head, 0 keeps them forever.
+func (bc *BlockChain) SetForkRetention(retention uint64) {
+	bc.forkRetention = retention
+}
+
+// pruneStaleForks removes the side chain blocks which fell retention blocks
+// below the new head, a bounded chunk per head block. The engines have no
+// finality, so the head stands in for the finalized block. Blocks waiting
+// in futureBlocks are kept.
+func (bc *BlockChain) pruneStaleForks(tx kv.RwTx, block block2.IBlock) error {
+	number := block.Number64().Uint64()
+	if bc.forkRetention == 0 || number <= bc.forkRetention {
+		return nil
+	}
+	below := number - bc.forkRetention
+	next, err := rawdb.ReadStaleForkNext(tx)
+	if nil != err {
+		return err
+	}
+	if next >= below {
+		return nil
+	}
+	stats, err := rawdb.PruneStaleForks(tx, next, below, staleForkChunk, func(hash types.Hash) bool {
+		return bc.futureBlocks.Contains(hash)
+	})
+	if nil != err {
+		return err
+	}
+	if stats.Blocks > 0 {
+		log.Debug("Pruned stale forks", "from", next, "to", stats.Next, "blocks", stats.Blocks, "rows", stats.Rows, "retainedTxs", stats.RetainedTxs)
+	}
+	// committed together with the chunk, a rolled back chunk is looked at again
+	return rawdb.WriteStaleForkNext(tx, stats.Next)
+}
+
+// indexFees adds the fees of a new canonical head to the fee accounting index.
+func (bc *BlockChain) indexFees(tx kv.RwTx, block block2.IBlock) error {
+	if !bc.feeAccounting || len(block.Transactions()) == 0 {
+		return nil
+	}
+	return rawdb.AppendFeeAccounting(tx, block, rawdb.ReadRawReceipts(tx, block.Number64().Uint64()))
+}
+
+// indexLogs adds a new canonical head to the log address and topic indices.
+// Entries of unwound blocks are kept, eth_getLogs matches the logs of the
+// canonical block exactly.
+func (bc *BlockChain) indexLogs(tx kv.RwTx, block block2.IBlock) error {
+	if len(block.Transactions()) == 0 {
+		return nil
+	}
+	return rawdb.WriteLogIndex(tx, block.Number64().Uint64(), rawdb.ReadRawReceipts(tx, block.Number64().Uint64()))
+}
+
+// unwindFees removes the fees of unwound blocks from the fee accounting index.
+func (bc *BlockChain) unwindFees(tx kv.RwTx, oldChain block2.Blocks) error {
+	if !bc.feeAccounting {
+		return nil
+	}
+	for _, b := range oldChain {
+		if err := rawdb.UnwindFeeAccounting(tx, b.Number64().Uint64(), b.Hash()); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneAccessLists removes the access lists which fell retention blocks below the new head.
+func (bc *BlockChain) pruneAccessLists(tx kv.RwTx, block block2.IBlock) error {
+	number := block.Number64().Uint64()
+	if !bc.accessLists || bc.accessListTTL == 0 || number <= bc.accessListTTL {
+		return nil
+	}
+	return rawdb.PruneBlockAccessLists(tx, number-bc.accessListTTL)
+}
+
+// unwindAccessLists removes the access lists of unwound blocks.
+func (bc *BlockChain) unwindAccessLists(tx kv.RwTx, oldChain block2.Blocks) error {
+	if !bc.accessLists {
+		return nil
+	}
+	for _, b := range oldChain {
+		if err := rawdb.DeleteBlockAccessList(tx, b.Number64().Uint64(), b.Hash()); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalHeadBlock appends the events of a new canonical head.
+func (bc *BlockChain) journalHeadBlock(tx kv.RwTx, block block2.IBlock) error {
+	if bc.eventJournal == nil {
+		return nil
+	}
+	now := time.Now()
+	number := block.Number64().Uint64()
+	if err := rawdb.AppendJournalEvent(tx, &rawdb.JournalEvent{
+		Kind:   rawdb.JournalNewBlock,
+		Time:   uint64(now.Unix()),
+		Number: number,
+		Hash:   block.Hash(),
+	}); nil != err {
+		return err
+	}
+
+	var logs uint64
+	if len(block.Transactions()) > 0 {
+		for _, r := range rawdb.ReadRawReceipts(tx, number) {
+			logs += uint64(len(r.Logs))
+		}
+	}
+	if logs > 0 {
+		if err := rawdb.AppendJournalEvent(tx, &rawdb.JournalEvent{
+			Kind:     rawdb.JournalLogs,
+			Time:     uint64(now.Unix()),
+			Number:   number,
+			Hash:     block.Hash(),
+			LogCount: logs,
+		}); nil != err {
+			return err
+		}
+	}
+	_, err := rawdb.PruneEventJournal(tx, *bc.eventJournal, now)
+	return err
+}
+
+// journalReorg appends a reorg event referencing every unwound block.
+func (bc *BlockChain) journalReorg(tx kv.RwTx, ancestor block2.IBlock, oldChain block2.Blocks) error {
+	if bc.eventJournal == nil {
+		return nil
+	}
+	unwound := make([]types.Hash, len(oldChain))
+	for i, b := range oldChain {
+		unwound[i] = b.Hash()
+	}
+	return rawdb.AppendJournalEvent(tx, &rawdb.JournalEvent{
+		Kind:    rawdb.JournalReorg,
+		Time:    uint64(time.Now().Unix()),
+		Number:  ancestor.Number64().Uint64(),
+		Hash:    ancestor.Hash(),
+		Unwound: unwound,
+	})
+}
+
+// ReloadStorageWatches recompiles the storage watchlist, it must be called
+// after the watchlist is changed in the database.
+func (bc *BlockChain) ReloadStorageWatches() error {
+	return bc.ChainDB.View(bc.ctx, func(tx kv.Tx) error {
+		idx, err := rawdb.ReadStorageWatchIndex(tx)
+		if nil != err {
+			return err
+		}
+		bc.storageWatch.Store(idx)
+		return nil
+	})
+}
+
+// matchStorageWatches records and publishes the watched slots changed by a new canonical head.
+func (bc *BlockChain) matchStorageWatches(tx kv.RwTx, block block2.IBlock) error {
+	idx, _ := bc.storageWatch.Load().(rawdb.StorageWatchIndex)
+	if len(idx) == 0 {
+		return nil
+	}
+	hits, err := rawdb.AppendStorageWatchHits(tx, idx, block.Number64().Uint64(), block.Hash())
+	if nil != err {
+		return err
+	}
+	if len(hits) > 0 {
+		event.GlobalEvent.Send(&common.StorageWatchHitsEvent{Hits: hits})
+	}
+	return nil
+}
+
+// retractStorageWatches records and publishes retractions of the hits of unwound blocks.
+func (bc *BlockChain) retractStorageWatches(tx kv.RwTx, ancestor block2.IBlock, oldChain block2.Blocks) error {
+	unwound := make([]types.Hash, len(oldChain))
+	for i, b := range oldChain {
+		unwound[i] = b.Hash()
+	}
+	hits, err := rawdb.RetractStorageWatchHits(tx, ancestor.Number64().Uint64(), unwound)
+	if nil != err {
+		return err
+	}
+	if len(hits) > 0 {
+		event.GlobalEvent.Send(&common.StorageWatchHitsEvent{Hits: hits})
+	}
+	return nil
+}
+
 // reportBlock logs a bad block error.
 func (bc *BlockChain) reportBlock(block block2.IBlock, receipts []*block2.Receipt, err error) {
 
@@ -1406,6 +1795,11 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err != nil {
 		return nil
 	}
+	release, err := bc.maintenance.BeginWrite()
+	if nil != err {
+		return err
+	}
+	defer release()
 	return bc.ChainDB.Update(bc.ctx, func(tx kv.RwTx) error {
 		return rawdb.WriteHeadHeaderHash(tx, newHeadBlock.Hash())
 	})
@@ -1559,6 +1953,11 @@ func (bc *BlockChain) reorg(tx kv.RwTx, oldBlock, newBlock block2.IBlock) error
 		// rewind the canonical chain to a lower point.
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number64(), "oldhash", oldBlock.Hash(), "oldblocks", len(oldChain), "newnum", newBlock.Number64(), "newhash", newBlock.Hash(), "newblocks", len(newChain))
 	}
+	if len(oldChain) > 0 {
+		if err := bc.unwindIndexes(tx, commonBlock, oldChain); nil != err {
+			return err
+		}
+	}
 	// Insert the new chain(except the head block(reverse order)),
 	// taking care of the proper incremental order.
 	for i := len(newChain) - 1; i >= 1; i-- {
@@ -1602,6 +2001,31 @@ func (bc *BlockChain) reorg(tx kv.RwTx, oldBlock, newBlock block2.IBlock) error
 
 	return nil
 }
+
+// unwindIndexes removes oldChain, the blocks above commonBlock from the old head
+// down, from the journal, the storage watches and the per block indexes.
+func (bc *BlockChain) unwindIndexes(tx kv.RwTx, commonBlock block2.IBlock, oldChain block2.Blocks) error {
+	if err := bc.journalReorg(tx, commonBlock, oldChain); nil != err {
+		return err
+	}
+	if err := bc.retractStorageWatches(tx, commonBlock, oldChain); nil != err {
+		return err
+	}
+	if err := bc.unwindFees(tx, oldChain); nil != err {
+		return err
+	}
+	if err := bc.unwindAccessLists(tx, oldChain); nil != err {
+		return err
+	}
+	if err := rawdb.TruncateCumulativeIndexes(tx, commonBlock.Number64().Uint64()+1); nil != err {
+		return err
+	}
+	if err := rawdb.TruncateBlockSizes(tx, commonBlock.Number64().Uint64()+1); nil != err {
+		return err
+	}
+	state.DefaultHistoryCache.Unwind(commonBlock.Number64().Uint64())
+	return nil
+}
 func (bc *BlockChain) Quit() <-chan struct{} {
 	return bc.ctx.Done()
 }
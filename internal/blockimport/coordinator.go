@@ -0,0 +1,268 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package blockimport admits blocks arriving from several sources into the
+// chain. The same block is often delivered by gossip, the local miner and the
+// sync at once; the coordinator executes it once and hands the result to every
+// caller, and runs queued imports in the order of their source priority.
+package blockimport
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/rcrowley/go-metrics"
+)
+
+// ErrClosed is returned for imports submitted to or queued in a stopped coordinator.
+var ErrClosed = errors.New("block import coordinator stopped")
+
+var (
+	submittedCounter = metrics.NewRegisteredCounter("chain/import/submitted", nil)
+	executedCounter  = metrics.NewRegisteredCounter("chain/import/executed", nil)
+	duplicateCounter = metrics.NewRegisteredCounter("chain/import/duplicates", nil)
+	knownCounter     = metrics.NewRegisteredCounter("chain/import/known", nil)
+	queueGauge       = metrics.NewRegisteredGauge("chain/import/queue", nil)
+)
+
+// importedCacheSize is the number of recently imported hashes answered without execution.
+const importedCacheSize = 1024
+
+// Source of a block. Queued imports run in the order of their source, lower first.
+type Source uint8
+
+const (
+	SourceForkchoice Source = iota // designated by forkchoice, e.g. Engine API newPayload
+	SourceLocal                    // sealed by the local miner
+	SourceGossip                   // announced by peers
+	SourceSync                     // fetched by the bodies stage
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceForkchoice:
+		return "forkchoice"
+	case SourceLocal:
+		return "local"
+	case SourceGossip:
+		return "gossip"
+	case SourceSync:
+		return "sync"
+	}
+	return "unknown"
+}
+
+// ImportFunc executes the import of one block into the chain.
+type ImportFunc func(b block.IBlock) error
+
+// Stats of a Coordinator. The metrics of the package count all coordinators.
+type Stats struct {
+	Submitted  uint64 // calls of Import
+	Executed   uint64 // calls of the ImportFunc
+	Duplicates uint64 // imports which joined one queued or running for the same hash
+	Known      uint64 // imports answered from the recently imported hashes
+}
+
+// Coordinator deduplicates and orders block imports. Imports are executed one
+// at a time by Run.
+type Coordinator struct {
+	importFn ImportFunc
+
+	mu       sync.Mutex
+	calls    map[types.Hash]*call // queued and running imports
+	queue    callQueue
+	imported *lru.Cache // hashes of recent successful imports
+	seq      uint64
+	stats    Stats
+	closed   bool
+	wake     chan struct{}
+}
+
+// call is one import shared by all the callers of its hash.
+type call struct {
+	block  block.IBlock
+	hash   types.Hash
+	source Source
+	seq    uint64 // submission order among imports of one source
+	index  int    // in the queue, -1 once running
+	done   chan struct{}
+	err    error
+}
+
+// New returns a coordinator executing imports with importFn, started by Run.
+func New(importFn ImportFunc) *Coordinator {
+	imported, _ := lru.New(importedCacheSize)
+	return &Coordinator{
+		importFn: importFn,
+		calls:    make(map[types.Hash]*call),
+		imported: imported,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Import submits b and waits for its import. A block already queued or running
+// is not imported again: the caller gets the result of that import, and a
+// higher priority source moves it ahead in the queue. A block imported
+// recently returns nil at once. Failed imports are not remembered, submitting
+// the block again retries it.
+func (c *Coordinator) Import(ctx context.Context, source Source, b block.IBlock) error {
+	hash := b.Hash()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.stats.Submitted++
+	submittedCounter.Inc(1)
+	if c.imported.Contains(hash) {
+		c.stats.Known++
+		knownCounter.Inc(1)
+		c.mu.Unlock()
+		return nil
+	}
+	cl, ok := c.calls[hash]
+	if ok {
+		c.stats.Duplicates++
+		duplicateCounter.Inc(1)
+		if cl.index >= 0 && source < cl.source {
+			cl.source = source
+			heap.Fix(&c.queue, cl.index)
+		}
+	} else {
+		c.seq++
+		cl = &call{block: b, hash: hash, source: source, seq: c.seq, done: make(chan struct{})}
+		c.calls[hash] = cl
+		heap.Push(&c.queue, cl)
+		queueGauge.Update(int64(c.queue.Len()))
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run executes queued imports until ctx is done, then fails the queued ones
+// with ErrClosed.
+func (c *Coordinator) Run(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			c.close()
+			return
+		}
+		if cl := c.next(); cl != nil {
+			err := c.importFn(cl.block)
+			c.finish(cl, err)
+			continue
+		}
+		select {
+		case <-c.wake:
+		case <-ctx.Done():
+			c.close()
+			return
+		}
+	}
+}
+
+// Stats returns the counters of the coordinator.
+func (c *Coordinator) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// next pops the import to run, nil if the queue is empty.
+func (c *Coordinator) next() *call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queue.Len() == 0 {
+		return nil
+	}
+	cl := heap.Pop(&c.queue).(*call)
+	queueGauge.Update(int64(c.queue.Len()))
+	return cl
+}
+
+func (c *Coordinator) finish(cl *call, err error) {
+	c.mu.Lock()
+	c.stats.Executed++
+	executedCounter.Inc(1)
+	delete(c.calls, cl.hash)
+	if err == nil {
+		c.imported.Add(cl.hash, struct{}{})
+	}
+	cl.err = err
+	c.mu.Unlock()
+	close(cl.done)
+}
+
+func (c *Coordinator) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.queue.Len() > 0 {
+		cl := heap.Pop(&c.queue).(*call)
+		delete(c.calls, cl.hash)
+		cl.err = ErrClosed
+		close(cl.done)
+	}
+	queueGauge.Update(0)
+}
+
+// callQueue is a heap of queued imports by source, then submission order.
+type callQueue []*call
+
+func (q callQueue) Len() int { return len(q) }
+
+func (q callQueue) Less(i, j int) bool {
+	if q[i].source != q[j].source {
+		return q[i].source < q[j].source
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q callQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *callQueue) Push(x interface{}) {
+	cl := x.(*call)
+	cl.index = len(*q)
+	*q = append(*q, cl)
+}
+
+func (q *callQueue) Pop() interface{} {
+	old := *q
+	cl := old[len(old)-1]
+	old[len(old)-1] = nil
+	cl.index = -1
+	*q = old[:len(old)-1]
+	return cl
+}
@@ -0,0 +1,215 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package blockimport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/holiman/uint256"
+)
+
+func testBlock(n uint64) block.IBlock {
+	return block.NewBlock(&block.Header{Number: uint256.NewInt(n), Difficulty: uint256.NewInt(1), BaseFee: uint256.NewInt(0), Time: n}, nil)
+}
+
+// waitQueued waits until n imports are queued behind the running one.
+func waitQueued(t *testing.T, c *Coordinator, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		l := c.queue.Len()
+		c.mu.Unlock()
+		if l == n {
+			return
+		}
+	}
+	t.Fatalf("%d imports not queued", n)
+}
+
+func TestImportSameBlockFromAllSources(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		var executions int32
+		c := New(func(b block.IBlock) error {
+			atomic.AddInt32(&executions, 1)
+			time.Sleep(time.Millisecond)
+			return nil
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		go c.Run(ctx)
+
+		b := testBlock(1)
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i, source := range []Source{SourceForkchoice, SourceLocal, SourceGossip} {
+			wg.Add(1)
+			go func(i int, source Source) {
+				defer wg.Done()
+				errs[i] = c.Import(ctx, source, b)
+			}(i, source)
+		}
+		wg.Wait()
+		cancel()
+
+		if n := atomic.LoadInt32(&executions); n != 1 {
+			t.Fatalf("round %d: block executed %d times", round, n)
+		}
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("round %d: caller %d: %v", round, i, err)
+			}
+		}
+		if s := c.Stats(); s.Submitted != 3 || s.Executed != 1 || s.Duplicates+s.Known != 2 {
+			t.Fatalf("round %d: stats %+v", round, s)
+		}
+	}
+}
+
+func TestImportSharesFailure(t *testing.T) {
+	errBad := errors.New("bad block")
+	gate, started := make(chan struct{}), make(chan struct{}, 2)
+	var executions int32
+	c := New(func(b block.IBlock) error {
+		started <- struct{}{}
+		<-gate
+		atomic.AddInt32(&executions, 1)
+		return errBad
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	b := testBlock(1)
+	errs := make(chan error, 2)
+	go func() { errs <- c.Import(ctx, SourceGossip, b) }()
+	<-started
+	go func() { errs <- c.Import(ctx, SourceSync, b) }()
+	for c.Stats().Duplicates != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(gate)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, errBad) {
+			t.Fatalf("caller got %v", err)
+		}
+	}
+
+	// failures are retried
+	if err := c.Import(ctx, SourceGossip, b); !errors.Is(err, errBad) || atomic.LoadInt32(&executions) != 2 {
+		t.Fatalf("retry: %v, %d executions", err, executions)
+	}
+}
+
+func TestImportPriority(t *testing.T) {
+	gate, started := make(chan struct{}), make(chan struct{}, 5)
+	var (
+		mu    sync.Mutex
+		order []types.Hash
+	)
+	c := New(func(b block.IBlock) error {
+		started <- struct{}{}
+		<-gate
+		mu.Lock()
+		order = append(order, b.Hash())
+		mu.Unlock()
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	var wg sync.WaitGroup
+	submit := func(source Source, b block.IBlock) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Import(ctx, source, b); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	running, sync1, gossip, local, forkchoice := testBlock(1), testBlock(2), testBlock(3), testBlock(4), testBlock(5)
+	submit(SourceGossip, running)
+	<-started
+	submit(SourceSync, sync1)
+	waitQueued(t, c, 1)
+	submit(SourceGossip, gossip)
+	waitQueued(t, c, 2)
+	submit(SourceLocal, local)
+	waitQueued(t, c, 3)
+	submit(SourceGossip, forkchoice)
+	waitQueued(t, c, 4)
+	// designated by forkchoice while queued behind gossip
+	submit(SourceForkchoice, forkchoice)
+	for c.Stats().Duplicates != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(gate)
+	wg.Wait()
+	want := []types.Hash{running.Hash(), forkchoice.Hash(), local.Hash(), gossip.Hash(), sync1.Hash()}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("import %d is %x, want %x", i, order[i], want[i])
+		}
+	}
+}
+
+func TestImportStopped(t *testing.T) {
+	gate, started := make(chan struct{}), make(chan struct{}, 2)
+	c := New(func(b block.IBlock) error {
+		started <- struct{}{}
+		<-gate
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	errs := make(chan error, 2)
+	go func() { errs <- c.Import(context.Background(), SourceGossip, testBlock(1)) }()
+	<-started
+	go func() { errs <- c.Import(context.Background(), SourceGossip, testBlock(2)) }()
+	waitQueued(t, c, 1)
+
+	cancel()
+	close(gate)
+	<-stopped
+	var closed int
+	for i := 0; i < 2; i++ {
+		if err := <-errs; errors.Is(err, ErrClosed) {
+			closed++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("%d queued imports failed on stop, want 1", closed)
+	}
+	if err := c.Import(context.Background(), SourceGossip, testBlock(3)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("import after stop: %v", err)
+	}
+}
@@ -161,15 +161,23 @@ func ecrecover(iHeader block.IHeader, sigcache *lru.ARCCache) (types.Address, er
 	}
 	signature := header.Extra[len(header.Extra)-extraSeal:]
 
-	//Recover the public key and the Ethereum(AMC) address
-	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), signature)
+	signer, err := recoverSealer(SealHash(header), signature)
+	if err != nil {
+		return types.Address{}, err
+	}
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// recoverSealer recovers the public key and the Ethereum(AMC) address of the
+// account whose signature of sealHash is seal.
+func recoverSealer(sealHash types.Hash, seal []byte) (types.Address, error) {
+	pubkey, err := crypto.Ecrecover(sealHash.Bytes(), seal)
 	if err != nil {
 		return types.Address{}, err
 	}
 	var signer types.Address
 	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
-
-	sigcache.Add(hash, signer)
 	return signer, nil
 }
 
@@ -583,7 +591,7 @@ func (c *Apoa) Prepare(chain consensus.ChainHeaderReader, header block.IHeader)
 	rawHeader.MixDigest = types.Hash{}
 
 	// Ensure the timestamp has the correct delay
-	parent := chain.GetHeader(rawHeader.ParentHash, rawHeader.Number.Sub(rawHeader.Number, uint256.NewInt(1)))
+	parent := chain.GetHeader(rawHeader.ParentHash, uint256.NewInt(0).Sub(rawHeader.Number, uint256.NewInt(1)))
 	if parent == nil {
 		return errors.New("unknown ancestor")
 	}
@@ -684,9 +692,17 @@ func (c *Apoa) Seal(chain consensus.ChainHeaderReader, b block.IBlock, results c
 	}
 	// Sign all the things!
 	sighash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeClique, ApoaProto(header))
+	if errors.Is(err, accounts.ErrSignTimeout) {
+		// a remote signer is slow or down, leave the slot to the other signers instead of stalling the miner
+		log.Warn("Signer timed out, skipping slot", "number", number, "signer", signer, "err", err)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if sealer, err := recoverSealer(SealHash(header), sighash); err != nil || sealer != signer {
+		return fmt.Errorf("%w: have %s, want %s", consensus.ErrSealSignerMismatch, sealer, signer)
+	}
 
 	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
 	// Wait until sealing is terminated or delay timeout.
@@ -42,4 +42,9 @@ var (
 	ErrInvalidNumber = errors.New("invalid block number")
 	// ErrNotEnoughSign bls Sign
 	ErrNotEnoughSign = errors.New("not enough sign")
+
+	// ErrSealSignerMismatch is returned if the seal signature of a block recovers
+	// to another address than the sealing account, as a misconfigured remote
+	// signer may return. Peers would reject such a block.
+	ErrSealSignerMismatch = errors.New("seal signed by another key than the signer")
 )
@@ -0,0 +1,77 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+func londonConfig() *params.ChainConfig {
+	return &params.ChainConfig{ChainID: big.NewInt(1), LondonBlock: big.NewInt(0)}
+}
+
+func TestVerifyEip1559HeaderGasLimitBounds(t *testing.T) {
+	config := londonConfig()
+	parent := &block.Header{Number: uint256.NewInt(10), GasLimit: 30_000_000, GasUsed: 15_000_000, BaseFee: uint256.NewInt(params.InitialBaseFee)}
+	baseFee, _ := uint256.FromBig(CalcBaseFee(config, parent))
+	bound := parent.GasLimit / params.GasLimitBoundDivisor
+
+	for _, c := range []struct {
+		gasLimit uint64
+		ok       bool
+	}{
+		{parent.GasLimit + bound - 1, true},
+		{parent.GasLimit - bound + 1, true},
+		{parent.GasLimit + bound, false},
+		{parent.GasLimit * 2, false},
+	} {
+		header := &block.Header{Number: uint256.NewInt(11), GasLimit: c.gasLimit, BaseFee: baseFee}
+		err := VerifyEip1559Header(config, parent, header)
+		if (err == nil) != c.ok {
+			t.Fatalf("gas limit %d: have err %v, want ok %t", c.gasLimit, err, c.ok)
+		}
+	}
+}
+
+func TestCalcBaseFeeSchedule(t *testing.T) {
+	config := londonConfig()
+	config.ElasticityMultiplierSchedule = map[string]uint64{"0": 2, "100": 4}
+	config.BaseFeeChangeDenominatorSchedule = map[string]uint64{"100": 50}
+
+	parent := &block.Header{GasLimit: 40_000_000, GasUsed: 10_000_000, BaseFee: uint256.NewInt(params.InitialBaseFee)}
+
+	// before the change gas target is 20M: parent is under target and the baseFee goes down by 1/8 * 1/2
+	parent.Number = uint256.NewInt(50)
+	if have, want := CalcBaseFee(config, parent), big.NewInt(params.InitialBaseFee-params.InitialBaseFee/16); have.Cmp(want) != 0 {
+		t.Fatalf("before schedule: have %s, want %s", have, want)
+	}
+	// block 100 has gas target 10M: parent is exactly on target and the baseFee holds
+	parent.Number = uint256.NewInt(99)
+	if have := CalcBaseFee(config, parent); have.Cmp(big.NewInt(params.InitialBaseFee)) != 0 {
+		t.Fatalf("at schedule: have %s, want %d", have, params.InitialBaseFee)
+	}
+	// above target by 10M over a 10M target: up by 1/50
+	parent.GasUsed = 20_000_000
+	if have, want := CalcBaseFee(config, parent), big.NewInt(params.InitialBaseFee+params.InitialBaseFee/50); have.Cmp(want) != 0 {
+		t.Fatalf("after schedule: have %s, want %s", have, want)
+	}
+}
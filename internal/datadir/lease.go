@@ -0,0 +1,293 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package datadir coordinates processes competing for one data directory.
+//
+// The holder of a datadir keeps a lease file with its PID, start time and a local control endpoint.
+// Another process finding the lease pings the endpoint: a live holder is either reported, or asked to shut down
+// when taking over, a lease nobody answers for is stale and reclaimed.
+package datadir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/log"
+)
+
+const (
+	// LeaseFile - lease of the running holder, removed on clean shutdown
+	LeaseFile = "amc.lease"
+	// CleanShutdownFile - lease of the last holder which shut down cleanly
+	CleanShutdownFile = "amc.clean"
+
+	// DefaultTakeoverTimeout - how long Acquire waits for the holder to shut down on takeover
+	DefaultTakeoverTimeout = time.Minute
+
+	pingTimeout  = 2 * time.Second
+	pollInterval = 100 * time.Millisecond
+	// maxAttempts - lease creations lost to other contenders before Acquire gives up
+	maxAttempts = 5
+)
+
+// Lease - content of LeaseFile and CleanShutdownFile
+type Lease struct {
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Endpoint string    `json:"endpoint"`
+}
+
+func (l Lease) same(o Lease) bool {
+	return l.PID == o.PID && l.Started.Equal(o.Started)
+}
+
+// InUseError - datadir is held by a live process
+type InUseError struct {
+	Dir   string
+	Lease Lease
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("datadir %s in use by PID %d since %s", e.Dir, e.Lease.PID, e.Lease.Started.Format(time.RFC3339))
+}
+
+// Options - how Acquire treats a live holder
+type Options struct {
+	// Takeover - ask a live holder to shut down and wait for its clean shutdown, instead of failing with InUseError
+	Takeover bool
+	// Timeout - wait for the holder on takeover, DefaultTakeoverTimeout if zero
+	Timeout time.Duration
+	// OnShutdown - called once when another process takes the datadir over, it must start a graceful shutdown
+	// ending with Holder.Release
+	OnShutdown func()
+}
+
+// Holder - lease held by this process
+type Holder struct {
+	dir   string
+	lease Lease
+
+	srv  *http.Server
+	once sync.Once
+}
+
+// Acquire - takes the lease of dir, reclaiming a stale one or taking over a live holder if opts allow it
+func Acquire(dir string, opts Options) (*Holder, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTakeoverTimeout
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	h := &Holder{
+		dir:   dir,
+		lease: Lease{PID: os.Getpid(), Started: time.Now().UTC().Round(0), Endpoint: ln.Addr().String()},
+	}
+	h.srv = &http.Server{Handler: h.handler(opts.OnShutdown), ReadHeaderTimeout: pingTimeout}
+	go h.srv.Serve(ln)
+
+	if err := h.acquire(opts); err != nil {
+		h.srv.Close()
+		return nil, err
+	}
+	// running holder, the datadir is no longer cleanly shut down
+	if err := os.Remove(filepath.Join(dir, CleanShutdownFile)); err != nil && !os.IsNotExist(err) {
+		h.Release()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Holder) acquire(opts Options) error {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		created, err := h.create()
+		if err != nil || created {
+			return err
+		}
+
+		holder, err := readLease(filepath.Join(h.dir, LeaseFile))
+		if os.IsNotExist(err) {
+			continue // released meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		if !ping(holder) {
+			// another reclaimer may race us here, the MDBX lock still keeps the database itself safe
+			log.Warn("Reclaiming stale datadir lease", "dir", h.dir, "pid", holder.PID, "started", holder.Started)
+			if err := os.Remove(filepath.Join(h.dir, LeaseFile)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if !opts.Takeover {
+			return &InUseError{Dir: h.dir, Lease: holder}
+		}
+		log.Info("Taking over datadir", "dir", h.dir, "pid", holder.PID)
+		if err := h.takeover(holder, opts.Timeout); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("datadir %s: lease contested by other processes", h.dir)
+}
+
+// create - atomically creates LeaseFile, false if it already exists
+func (h *Holder) create() (bool, error) {
+	data, err := json.Marshal(h.lease)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(h.dir, LeaseFile+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	// link, unlike rename, fails if the target exists, so readers never see a partially written lease
+	if err := os.Link(tmp.Name(), filepath.Join(h.dir, LeaseFile)); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// takeover - asks holder to shut down and waits for its clean shutdown marker
+func (h *Holder) takeover(holder Lease, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+holder.Endpoint+"/shutdown", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("datadir %s: shutdown request to PID %d: %w", h.dir, holder.PID, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("datadir %s: PID %d refused shutdown: %s", h.dir, holder.PID, resp.Status)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		clean, err := readLease(filepath.Join(h.dir, CleanShutdownFile))
+		if err == nil && clean.same(holder) {
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		time.Sleep(pollInterval)
+	}
+	return fmt.Errorf("datadir %s: PID %d did not shut down within %s", h.dir, holder.PID, timeout)
+}
+
+func (h *Holder) handler(onShutdown func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(h.lease)
+	})
+	mux.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if onShutdown == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		h.once.Do(func() {
+			log.Info("Datadir takeover requested, shutting down", "dir", h.dir)
+			go onShutdown()
+		})
+	})
+	return mux
+}
+
+// Lease - lease held by this process
+func (h *Holder) Lease() Lease { return h.lease }
+
+// Release - stops the control endpoint, writes the clean shutdown marker and removes the lease.
+// Call it after the database is closed.
+func (h *Holder) Release() error {
+	h.srv.Close()
+	data, err := json.Marshal(h.lease)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(h.dir, CleanShutdownFile), data, 0644); err != nil {
+		return err
+	}
+	// a lease of another process means ours was reclaimed as stale, leave it alone
+	if current, err := readLease(filepath.Join(h.dir, LeaseFile)); err == nil && !current.same(h.lease) {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(h.dir, LeaseFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readLease(path string) (Lease, error) {
+	var l Lease
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return l, err
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// ping - whether the holder of l answers on its endpoint, a process reusing the port answers with other lease
+func ping(l Lease) bool {
+	if l.Endpoint == "" {
+		return false
+	}
+	client := http.Client{Timeout: pingTimeout}
+	resp, err := client.Get("http://" + l.Endpoint + "/ping")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var answer Lease
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return false
+	}
+	return answer.same(l)
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package datadir
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireContested(t *testing.T) {
+	dir := t.TempDir()
+	h, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+
+	_, err = Acquire(dir, Options{})
+	var inUse *InUseError
+	if !errors.As(err, &inUse) {
+		t.Fatalf("have %v, want InUseError", err)
+	}
+	if !inUse.Lease.same(h.Lease()) || !strings.Contains(err.Error(), "in use by PID") {
+		t.Fatalf("unexpected holder: %v", err)
+	}
+
+	// takeover of a holder which cannot shut down is refused, the holder keeps the datadir
+	if _, err := Acquire(dir, Options{Takeover: true, Timeout: time.Second}); err == nil {
+		t.Fatal("takeover without shutdown hook succeeded")
+	}
+	if current, err := readLease(filepath.Join(dir, LeaseFile)); err != nil || !current.same(h.Lease()) {
+		t.Fatalf("lease changed: %+v %v", current, err)
+	}
+}
+
+func TestAcquireTakeover(t *testing.T) {
+	dir := t.TempDir()
+	requested := make(chan struct{})
+	var first *Holder
+	first, err := Acquire(dir, Options{OnShutdown: func() {
+		close(requested)
+		// graceful shutdown takes a while before the database is closed
+		time.Sleep(200 * time.Millisecond)
+		first.Release()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Acquire(dir, Options{Takeover: true, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+	select {
+	case <-requested:
+	default:
+		t.Fatal("holder was not asked to shut down")
+	}
+	current, err := readLease(filepath.Join(dir, LeaseFile))
+	if err != nil || !current.same(second.Lease()) || current.Endpoint == first.Lease().Endpoint {
+		t.Fatalf("lease not taken over: %+v %v", current, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CleanShutdownFile)); !os.IsNotExist(err) {
+		t.Fatalf("clean shutdown marker left while running: %v", err)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	dir := t.TempDir()
+	// lease of a killed process: nothing listens on its endpoint anymore
+	dead, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.srv.Close()
+	stale := dead.Lease()
+
+	h, err := Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Lease().same(stale) {
+		t.Fatal("stale lease reused")
+	}
+	if err := h.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, LeaseFile)); !os.IsNotExist(err) {
+		t.Fatalf("lease left after release: %v", err)
+	}
+	clean, err := readLease(filepath.Join(dir, CleanShutdownFile))
+	if err != nil || !clean.same(h.Lease()) {
+		t.Fatalf("clean shutdown marker: %+v %v", clean, err)
+	}
+
+	// stale holder releasing late must not remove the lease of the new holder
+	h, err = Acquire(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+	if err := dead.Release(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, LeaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var current Lease
+	if err := json.Unmarshal(data, &current); err != nil || !current.same(h.Lease()) {
+		t.Fatalf("lease of new holder lost: %s", data)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package diagnostics - bundle of node state for bug reports. Keys and raw user data (accounts, state,
+// transactions) are never collected.
+package diagnostics
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/integrity"
+	"github.com/amazechain/amc/params"
+)
+
+// Source - producer of one section of the bundle
+type Source struct {
+	Name    string
+	Collect func() (interface{}, error)
+}
+
+// Section - data of one source, or the reason it is missing
+type Section struct {
+	Name  string      `json:"name"`
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Bundle - collected sections, marshals to the JSON attached to bug reports
+type Bundle struct {
+	Collected time.Time `json:"collected"`
+	Sections  []Section `json:"sections"`
+}
+
+// Section - returns section by name, nil if bundle has no such section
+func (b *Bundle) Section(name string) *Section {
+	for i := range b.Sections {
+		if b.Sections[i].Name == name {
+			return &b.Sections[i]
+		}
+	}
+	return nil
+}
+
+// Collect - runs every source in order. A failing or panicking source only marks its own section,
+// so a degraded node still produces a bundle.
+func Collect(sources []Source) *Bundle {
+	b := &Bundle{Collected: time.Now().UTC()}
+	for _, s := range sources {
+		b.Sections = append(b.Sections, collect(s))
+	}
+	return b
+}
+
+func collect(s Source) (section Section) {
+	section.Name = s.Name
+	defer func() {
+		if r := recover(); r != nil {
+			section.Data = nil
+			section.Error = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	data, err := s.Collect()
+	if err != nil {
+		section.Error = err.Error()
+		return section
+	}
+	section.Data = data
+	return section
+}
+
+// Sources - all sections which can be read from the database, followed by build info and extra
+// sources of the caller (chain config, peers, ...)
+func Sources(tx kv.Getter, extra ...Source) []Source {
+	sources := []Source{
+		{Name: "version", Collect: version},
+		{Name: "schemaVersion", Collect: func() (interface{}, error) { return schemaVersion(tx) }},
+		{Name: "stages", Collect: func() (interface{}, error) { return stages(tx) }},
+		{Name: "heads", Collect: func() (interface{}, error) { return heads(tx) }},
+		{Name: "prune", Collect: func() (interface{}, error) { return prune(tx) }},
+		{Name: "sanity", Collect: func() (interface{}, error) { return sanity(tx) }},
+	}
+	return append(sources, extra...)
+}
+
+func version() (interface{}, error) {
+	return map[string]string{
+		"version": params.VersionWithCommit(params.GitCommit, ""),
+		"go":      runtime.Version(),
+		"os":      runtime.GOOS,
+		"arch":    runtime.GOARCH,
+	}, nil
+}
+
+func schemaVersion(tx kv.Getter) (interface{}, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, kv.DBSchemaVersionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != 12 {
+		return hex.EncodeToString(v), nil
+	}
+	return fmt.Sprintf("%d.%d.%d", binary.BigEndian.Uint32(v), binary.BigEndian.Uint32(v[4:]), binary.BigEndian.Uint32(v[8:])), nil
+}
+
+func stages(tx kv.Getter) (interface{}, error) {
+	res := make(map[string]uint64)
+	err := tx.ForEach(kv.SyncStageProgress, nil, func(k, v []byte) error {
+		if len(v) == 8 {
+			res[string(k)] = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return res, err
+}
+
+type head struct {
+	Hash   string  `json:"hash"`
+	Number *uint64 `json:"number,omitempty"`
+}
+
+func readHead(tx kv.Getter, hash []byte) (*head, error) {
+	if len(hash) == 0 {
+		return nil, nil
+	}
+	h := &head{Hash: hex.EncodeToString(hash)}
+	number, err := tx.GetOne(kv.HeaderNumber, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(number) == 8 {
+		n := binary.BigEndian.Uint64(number)
+		h.Number = &n
+	}
+	return h, nil
+}
+
+// heads - head pointers and the last forkchoice (safe, finalized) hashes
+func heads(tx kv.Getter) (interface{}, error) {
+	res := make(map[string]*head)
+	for _, pointer := range []string{kv.HeadBlockKey, kv.HeadHeaderKey} {
+		hash, err := tx.GetOne(pointer, []byte(pointer))
+		if err != nil {
+			return nil, err
+		}
+		if res[pointer], err = readHead(tx, hash); err != nil {
+			return nil, err
+		}
+	}
+	err := tx.ForEach(kv.LastForkchoice, nil, func(k, v []byte) error {
+		h, err := readHead(tx, v)
+		res[string(k)] = h
+		return err
+	})
+	return res, err
+}
+
+type pruneMode struct {
+	Type     string  `json:"type"`
+	Distance uint64  `json:"distance"`
+	Horizon  *uint64 `json:"horizon,omitempty"`
+}
+
+// prune - configured prune modes and the first block each of them keeps at the current head
+func prune(tx kv.Getter) (interface{}, error) {
+	var headNumber *uint64
+	hash, err := tx.GetOne(kv.HeadBlockKey, []byte(kv.HeadBlockKey))
+	if err != nil {
+		return nil, err
+	}
+	if h, err := readHead(tx, hash); err != nil {
+		return nil, err
+	} else if h != nil {
+		headNumber = h.Number
+	}
+
+	res := make(map[string]pruneMode)
+	for name, keys := range map[string][2][]byte{
+		"history":    {kv.PruneHistory, kv.PruneHistoryType},
+		"receipts":   {kv.PruneReceipts, kv.PruneReceiptsType},
+		"txIndex":    {kv.PruneTxIndex, kv.PruneTxIndexType},
+		"callTraces": {kv.PruneCallTraces, kv.PruneCallTracesType},
+		"senders":    {kv.PruneSenders, kv.PruneSendersType},
+	} {
+		v, err := tx.GetOne(kv.DatabaseInfo, keys[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != 8 {
+			continue
+		}
+		typ, err := tx.GetOne(kv.DatabaseInfo, keys[1])
+		if err != nil {
+			return nil, err
+		}
+		mode := pruneMode{Type: string(kv.PruneTypeOlder), Distance: binary.BigEndian.Uint64(v)}
+		if len(typ) > 0 {
+			mode.Type = string(typ)
+		}
+		switch {
+		case mode.Type == string(kv.PruneTypeBefore):
+			horizon := mode.Distance
+			mode.Horizon = &horizon
+		case headNumber != nil && *headNumber > mode.Distance:
+			horizon := *headNumber - mode.Distance
+			mode.Horizon = &horizon
+		}
+		res[name] = mode
+	}
+	return res, nil
+}
+
+type sanityReport struct {
+	Heads     []string           `json:"heads"`
+	Integrity []integrity.Status `json:"integrity"`
+}
+
+// sanity - head pointer consistency and incremental integrity check positions
+func sanity(tx kv.Getter) (interface{}, error) {
+	res := sanityReport{Heads: []string{}}
+	for _, err := range kv.VerifyHeadConsistency(tx) {
+		res.Heads = append(res.Heads, err.Error())
+	}
+	status, err := integrity.ReadStatus(tx, []integrity.Check{integrity.Canonical})
+	if err != nil {
+		return nil, err
+	}
+	res.Integrity = status
+	return res, nil
+}
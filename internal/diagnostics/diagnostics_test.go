@@ -0,0 +1,151 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package diagnostics
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// missingTable - fails every read of table, as a node with a dropped or corrupted table would
+type missingTable struct {
+	kv.Getter
+	table string
+}
+
+func (g missingTable) err(table string) error {
+	if table == g.table {
+		return fmt.Errorf("table %s not found", table)
+	}
+	return nil
+}
+
+func (g missingTable) GetOne(table string, key []byte) ([]byte, error) {
+	if err := g.err(table); err != nil {
+		return nil, err
+	}
+	return g.Getter.GetOne(table, key)
+}
+
+func (g missingTable) ForEach(table string, from []byte, walker func(k, v []byte) error) error {
+	if err := g.err(table); err != nil {
+		return err
+	}
+	return g.Getter.ForEach(table, from, walker)
+}
+
+func writeFixture(t *testing.T, tx kv.RwTx) {
+	t.Helper()
+	put := func(table string, k, v []byte) {
+		if err := tx.Put(table, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u64 := func(n uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, n)
+		return b
+	}
+	for n := uint64(0); n <= 10; n++ {
+		hash := make([]byte, 32)
+		binary.BigEndian.PutUint64(hash, n+1)
+		put(kv.HeaderNumber, hash, u64(n))
+		put(kv.HeaderCanonical, u64(n), hash)
+		put(kv.Headers, append(u64(n), hash...), []byte{0xc0})
+		if n == 10 {
+			put(kv.HeadBlockKey, []byte(kv.HeadBlockKey), hash)
+			put(kv.HeadHeaderKey, []byte(kv.HeadHeaderKey), hash)
+		}
+		if n == 8 {
+			put(kv.LastForkchoice, []byte("finalizedBlockHash"), hash)
+		}
+	}
+	put(kv.DatabaseInfo, kv.DBSchemaVersionKey, make([]byte, 12))
+	put(kv.DatabaseInfo, kv.PruneHistory, u64(4))
+	put(kv.DatabaseInfo, kv.PruneHistoryType, kv.PruneTypeOlder)
+	put(kv.SyncStageProgress, []byte("Execution"), u64(10))
+}
+
+func sections(t *testing.T, b *Bundle) map[string]Section {
+	t.Helper()
+	blob, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Bundle
+	if err := json.Unmarshal(blob, &parsed); err != nil {
+		t.Fatalf("bundle does not parse: %v", err)
+	}
+	res := make(map[string]Section)
+	for _, s := range parsed.Sections {
+		res[s.Name] = s
+	}
+	return res
+}
+
+func TestCollectHealthy(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeFixture(t, tx)
+
+	peers := Source{Name: "peers", Collect: func() (interface{}, error) { return 3, nil }}
+	got := sections(t, Collect(Sources(tx, peers)))
+	for _, name := range []string{"version", "schemaVersion", "stages", "heads", "prune", "sanity", "peers"} {
+		s, ok := got[name]
+		if !ok {
+			t.Fatalf("section %s missing", name)
+		}
+		if s.Error != "" || s.Data == nil {
+			t.Fatalf("section %s: data %v, error %q", name, s.Data, s.Error)
+		}
+	}
+
+	prune := got["prune"].Data.(map[string]interface{})["history"].(map[string]interface{})
+	if prune["horizon"] != float64(6) {
+		t.Fatalf("history horizon %v, want 6", prune["horizon"])
+	}
+	if heads := got["sanity"].Data.(map[string]interface{})["heads"].([]interface{}); len(heads) != 0 {
+		t.Fatalf("healthy fixture has head errors: %v", heads)
+	}
+}
+
+func TestCollectDegraded(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeFixture(t, tx)
+
+	panicking := Source{Name: "peers", Collect: func() (interface{}, error) { panic("p2p not started") }}
+	b := Collect(Sources(missingTable{tx, kv.SyncStageProgress}, panicking))
+	got := sections(t, b)
+	if got["stages"].Error == "" {
+		t.Fatal("missing table not reported")
+	}
+	if got["peers"].Error == "" {
+		t.Fatal("panic not reported")
+	}
+	for _, name := range []string{"version", "schemaVersion", "heads", "prune", "sanity"} {
+		if got[name].Error != "" {
+			t.Fatalf("section %s affected by other failures: %s", name, got[name].Error)
+		}
+	}
+	if b.Section("stages") == nil || b.Section("nope") != nil {
+		t.Fatal("Section lookup")
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package diskguard pauses sync before the volumes of the datadir run out of
+// space. A full disk in the middle of a write transaction fails MDBX and can
+// leave the datadir in need of repair; the guard extrapolates the recent write
+// rate and stops chain writes at a batch boundary while the predicted writes
+// still fit, then resumes once space is freed.
+package diskguard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/log"
+	"github.com/rcrowley/go-metrics"
+)
+
+// ErrLowDiskSpace is returned by every chain write refused while the guard is paused.
+var ErrLowDiskSpace = errors.New("low disk space, sync paused")
+
+var (
+	pausedGauge    = metrics.NewRegisteredGauge("disk/guard/paused", nil)
+	freeGauge      = metrics.NewRegisteredGauge("disk/guard/free", nil)
+	predictedGauge = metrics.NewRegisteredGauge("disk/guard/predicted", nil)
+)
+
+// Usage is the space of a volume in bytes.
+type Usage struct {
+	Free  uint64 // available to the node
+	Total uint64
+}
+
+// StatFunc returns the space of the volume holding dir, see StatVolume.
+type StatFunc func(dir string) (Usage, error)
+
+// Progress returns the current head and the highest block known to the sync.
+type Progress func() (head, highest uint64)
+
+// Config of a Guard.
+type Config struct {
+	Dirs     []string      // directories to watch: chain data, tx pool, snapshots
+	Margin   uint64        // free bytes always left on every volume
+	Horizon  time.Duration // how far ahead the write rate is extrapolated
+	Window   time.Duration // how far back the write rate is measured
+	Interval time.Duration // sampling interval of Run
+}
+
+// DefaultConfig keeps 2GiB free and looks 10 minutes ahead.
+var DefaultConfig = Config{
+	Margin:   2 << 30,
+	Horizon:  10 * time.Minute,
+	Window:   5 * time.Minute,
+	Interval: 10 * time.Second,
+}
+
+// VolumeStatus is the last sample of a watched directory.
+type VolumeStatus struct {
+	Dir       string `json:"dir"`
+	Free      uint64 `json:"free"`
+	Total     uint64 `json:"total"`
+	Rate      uint64 `json:"rate"`      // bytes written per second over the window
+	PerBlock  uint64 `json:"perBlock"`  // bytes written per synced block over the window
+	Predicted uint64 `json:"predicted"` // bytes expected to be written within the horizon
+	Error     string `json:"error,omitempty"`
+}
+
+// Status of a Guard, served by admin_diskSpace.
+type Status struct {
+	Paused    bool           `json:"paused"`
+	Since     time.Time      `json:"since"`     // of the current pause
+	Margin    uint64         `json:"margin"`    // free bytes always left
+	Remaining uint64         `json:"remaining"` // blocks left to sync
+	Volumes   []VolumeStatus `json:"volumes"`
+}
+
+type sample struct {
+	at   time.Time
+	free uint64
+	head uint64
+}
+
+// Guard samples the watched volumes and gates chain writes. A nil Guard
+// never pauses.
+type Guard struct {
+	cfg      Config
+	stat     StatFunc
+	progress Progress
+
+	mu       sync.Mutex
+	samples  map[string][]sample
+	status   Status
+	resumeAt map[string]uint64 // free space a volume needs before resuming, set on pausing
+	resumed  chan struct{}     // closed on resuming, nil while running
+}
+
+// New returns a guard of the directories in cfg, sampled by Run. progress may be nil
+// if the sync distance is unknown.
+func New(cfg Config, stat StatFunc, progress Progress) *Guard {
+	return &Guard{
+		cfg:      cfg,
+		stat:     stat,
+		progress: progress,
+		samples:  make(map[string][]sample),
+		resumeAt: make(map[string]uint64),
+		status:   Status{Margin: cfg.Margin},
+	}
+}
+
+// Of returns the disk guard of chain, nil if it has none.
+func Of(chain interface{}) *Guard {
+	if c, ok := chain.(interface{ DiskGuard() *Guard }); ok {
+		return c.DiskGuard()
+	}
+	return nil
+}
+
+// Run samples the volumes every interval until ctx is done.
+func (g *Guard) Run(ctx context.Context) {
+	tick := time.NewTicker(g.cfg.Interval)
+	defer tick.Stop()
+	for {
+		g.Check(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Check samples the volumes at now, pauses if the free space of a volume
+// does not cover the margin and the writes predicted within the horizon, and
+// resumes once every volume it paused for has that space again, at least
+// twice the margin.
+//
+// The prediction is the write rate over the window times the horizon, capped
+// by the bytes written per block times the blocks left to sync: a sync about
+// to catch up doesn't pause for writes it won't make.
+func (g *Guard) Check(now time.Time) Status {
+	var head, highest uint64
+	if g.progress != nil {
+		head, highest = g.progress()
+	}
+	var remaining uint64
+	if highest > head {
+		remaining = highest - head
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status.Remaining = remaining
+	g.status.Volumes = g.status.Volumes[:0]
+	var (
+		short                 []VolumeStatus
+		minFree, maxPredicted uint64
+		sampled               bool
+	)
+	for _, dir := range g.cfg.Dirs {
+		vs := g.sample(dir, now, head, remaining)
+		g.status.Volumes = append(g.status.Volumes, vs)
+		if vs.Error != "" {
+			continue
+		}
+		if !sampled || vs.Free < minFree {
+			minFree = vs.Free
+		}
+		if vs.Predicted > maxPredicted {
+			maxPredicted = vs.Predicted
+		}
+		sampled = true
+		if vs.Free < g.cfg.Margin+vs.Predicted {
+			short = append(short, vs)
+		}
+	}
+	freeGauge.Update(int64(minFree))
+	predictedGauge.Update(int64(maxPredicted))
+
+	for _, vs := range short {
+		if _, ok := g.resumeAt[vs.Dir]; ok {
+			continue
+		}
+		need := g.cfg.Margin + vs.Predicted
+		if need < 2*g.cfg.Margin {
+			need = 2 * g.cfg.Margin
+		}
+		g.resumeAt[vs.Dir] = need
+	}
+	switch {
+	case !g.status.Paused && len(short) > 0:
+		g.status.Paused, g.status.Since = true, now
+		g.resumed = make(chan struct{})
+		pausedGauge.Update(1)
+		for _, vs := range short {
+			log.Warn("Low disk space, pausing sync", "dir", vs.Dir, "free", vs.Free, "predicted", vs.Predicted, "margin", g.cfg.Margin)
+		}
+	case g.status.Paused && len(short) == 0 && g.recovered():
+		g.status.Paused, g.status.Since = false, time.Time{}
+		g.resumeAt = make(map[string]uint64)
+		close(g.resumed)
+		g.resumed = nil
+		pausedGauge.Update(0)
+		log.Info("Disk space freed, resuming sync", "free", minFree)
+	}
+	return g.copyStatus()
+}
+
+// sample records the free space of dir and predicts the writes of the horizon.
+func (g *Guard) sample(dir string, now time.Time, head, remaining uint64) VolumeStatus {
+	vs := VolumeStatus{Dir: dir}
+	u, err := g.stat(dir)
+	if err != nil {
+		vs.Error = err.Error()
+		return vs
+	}
+	vs.Free, vs.Total = u.Free, u.Total
+
+	s := append(g.samples[dir], sample{at: now, free: u.Free, head: head})
+	// keep one sample at or before the start of the window
+	for len(s) > 2 && !s[1].at.After(now.Add(-g.cfg.Window)) {
+		s = s[1:]
+	}
+	g.samples[dir] = s
+
+	oldest := s[0]
+	if dt := now.Sub(oldest.at); dt > 0 && oldest.free > u.Free {
+		written := oldest.free - u.Free
+		vs.Rate = uint64(float64(written) / dt.Seconds())
+		if head > oldest.head {
+			vs.PerBlock = written / (head - oldest.head)
+		}
+	}
+	vs.Predicted = uint64(float64(vs.Rate) * g.cfg.Horizon.Seconds())
+	if vs.PerBlock > 0 && remaining < vs.Predicted/vs.PerBlock {
+		vs.Predicted = vs.PerBlock * remaining
+	}
+	return vs
+}
+
+// recovered reports whether every volume paused for has its resume space.
+func (g *Guard) recovered() bool {
+	for _, vs := range g.status.Volumes {
+		need, ok := g.resumeAt[vs.Dir]
+		if ok && (vs.Error != "" || vs.Free < need) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Guard) copyStatus() Status {
+	s := g.status
+	s.Volumes = append([]VolumeStatus(nil), g.status.Volumes...)
+	return s
+}
+
+// Status returns the last sample.
+func (g *Guard) Status() Status {
+	if g == nil {
+		return Status{}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.copyStatus()
+}
+
+// Paused reports whether chain writes are paused.
+func (g *Guard) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status.Paused
+}
+
+// Admit fails with ErrLowDiskSpace while paused, writers call it before
+// every batch.
+func (g *Guard) Admit() error {
+	if g.Paused() {
+		return ErrLowDiskSpace
+	}
+	return nil
+}
+
+// Wait blocks while paused, until resumed or ctx is done.
+func (g *Guard) Wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
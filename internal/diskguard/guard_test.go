@@ -0,0 +1,170 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package diskguard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const mib = 1 << 20
+
+// fakeVolume is a volume a simulated sync writes to.
+type fakeVolume struct {
+	mu      sync.Mutex
+	free    uint64
+	head    uint64
+	highest uint64
+}
+
+func (v *fakeVolume) stat(string) (Usage, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return Usage{Free: v.free, Total: 100 << 30}, nil
+}
+
+func (v *fakeVolume) progress() (uint64, uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.head, v.highest
+}
+
+// insert syncs blocks, each taking perBlock bytes.
+func (v *fakeVolume) insert(blocks, perBlock uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.head += blocks
+	v.free -= blocks * perBlock
+}
+
+func testConfig() Config {
+	return Config{
+		Dirs:     []string{"chaindata", "txpool"},
+		Margin:   100 * mib,
+		Horizon:  time.Minute,
+		Window:   30 * time.Second,
+		Interval: 10 * time.Second,
+	}
+}
+
+func TestGuardPausesBeforeMargin(t *testing.T) {
+	vol := &fakeVolume{free: 2000 * mib, highest: 1_000_000}
+	g := New(testConfig(), vol.stat, vol.progress)
+
+	// a batch of 100 blocks of 1MiB every 10 seconds: 10MiB/s, 600MiB a minute
+	now := time.Unix(0, 0)
+	g.Check(now)
+	var batches int
+	for ; batches < 100; batches++ {
+		if err := g.Admit(); err != nil {
+			if !errors.Is(err, ErrLowDiskSpace) {
+				t.Fatalf("admit: %v", err)
+			}
+			break
+		}
+		vol.insert(100, mib)
+		now = now.Add(10 * time.Second)
+		g.Check(now)
+	}
+	st := g.Status()
+	if !st.Paused {
+		t.Fatalf("sync not paused after %d batches, free %d", batches, vol.free)
+	}
+	// the pause comes a horizon early, well before the margin is reached
+	if vol.free < testConfig().Margin+500*mib {
+		t.Fatalf("paused late, free %dMiB", vol.free/mib)
+	}
+	if st.Volumes[0].Rate != 10*mib || st.Volumes[0].PerBlock != mib {
+		t.Fatalf("rate %d per block %d", st.Volumes[0].Rate, st.Volumes[0].PerBlock)
+	}
+
+	// paused writers wait for the resume
+	waited := make(chan error, 1)
+	go func() { waited <- g.Wait(context.Background()) }()
+
+	// no writes while paused, freeing a little is not enough
+	now = now.Add(10 * time.Second)
+	vol.mu.Lock()
+	vol.free += 50 * mib
+	vol.mu.Unlock()
+	if g.Check(now).Paused != true {
+		t.Fatal("resumed without the space needed")
+	}
+	select {
+	case err := <-waited:
+		t.Fatalf("wait returned while paused: %v", err)
+	default:
+	}
+
+	vol.mu.Lock()
+	vol.free += 10 << 30
+	vol.mu.Unlock()
+	now = now.Add(10 * time.Second)
+	if g.Check(now).Paused {
+		t.Fatal("not resumed after space was freed")
+	}
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer not released on resume")
+	}
+	if err := g.Admit(); err != nil {
+		t.Fatalf("admit after resume: %v", err)
+	}
+}
+
+func TestGuardSyncDistance(t *testing.T) {
+	// the same write rate, but only 200 blocks are left to sync
+	vol := &fakeVolume{free: 400 * mib, head: 800, highest: 1000}
+	g := New(testConfig(), vol.stat, vol.progress)
+	// without the sync distance the rate alone predicts 600MiB
+	blind := New(testConfig(), vol.stat, nil)
+
+	now := time.Unix(0, 0)
+	g.Check(now)
+	blind.Check(now)
+	for i := 0; i < 2; i++ {
+		vol.insert(100, mib)
+		now = now.Add(10 * time.Second)
+		if st := g.Check(now); st.Paused {
+			t.Fatalf("paused at head %d with %dMiB free, %d blocks left", vol.head, vol.free/mib, st.Remaining)
+		}
+		blind.Check(now)
+	}
+	if !blind.Paused() {
+		t.Fatal("rate prediction did not pause")
+	}
+}
+
+func TestGuardStatError(t *testing.T) {
+	g := New(testConfig(), func(string) (Usage, error) { return Usage{}, errors.New("no such volume") }, nil)
+	st := g.Check(time.Unix(0, 0))
+	if st.Paused || len(st.Volumes) != 2 || st.Volumes[0].Error == "" {
+		t.Fatalf("status %+v", st)
+	}
+
+	var nilGuard *Guard
+	if nilGuard.Paused() || nilGuard.Admit() != nil || nilGuard.Wait(context.Background()) != nil {
+		t.Fatal("nil guard paused")
+	}
+}
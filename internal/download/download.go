@@ -30,6 +30,8 @@ import (
 	"github.com/amazechain/amc/api/protocol/sync_proto"
 	"github.com/amazechain/amc/api/protocol/types_pb"
 	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/internal/diskguard"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/log"
 	event "github.com/amazechain/amc/modules/event/v2"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -286,6 +288,11 @@ func (d *Downloader) findAncestor() (uint256.Int, error) {
 	return *d.bc.CurrentBlock().Number64(), nil
 }
 
+// Highest returns the highest block number announced by peers.
+func (d *Downloader) Highest() uint64 {
+	return d.highestNumber.Uint64()
+}
+
 func (d *Downloader) findHead() (uint256.Int, error) {
 	//if d.highestNumber.IsEmpty {
 	//	return d.highestNumber, ErrSyncBlock
@@ -351,6 +358,11 @@ func (d *Downloader) synchronise() {
 			}
 			return
 		case <-tick.C:
+			// frozen: wait, sync resumes from the committed head on exit
+			if maintenance.Of(d.bc).Enabled() || diskguard.Of(d.bc).Paused() {
+				tick.Reset(syncTimeTick)
+				continue
+			}
 			difference := new(uint256.Int).Sub(&d.highestNumber, d.bc.CurrentBlock().Number64())
 			log.Tracef("highest: %d, current: %d", d.highestNumber.Uint64(), d.bc.CurrentBlock().Number64().Uint64())
 			if difference.Uint64() > 1 {
@@ -22,7 +22,9 @@ import (
 	"math/rand"
 	"time"
 
+	"github.com/amazechain/amc/api/protocol/types_pb"
 	block2 "github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/internal/diskguard"
 	"github.com/amazechain/amc/log"
 )
 
@@ -155,12 +157,17 @@ func (d *Downloader) processChain() error {
 		case <-d.ctx.Done():
 			return ErrCanceled
 		case <-tick.C:
+			// low on disk: keep the downloaded bodies until the guard resumes
+			if diskguard.Of(d.bc).Paused() {
+				continue
+			}
 
 			d.bodyTaskPoolLock.Lock()
 			wantBlockNumber := new(uint256.Int).AddUint64(d.bc.CurrentBlock().Number64(), 1)
 			log.Tracef("want block %d have blocks count is %d", wantBlockNumber.Uint64(), len(d.bodyResultStore))
 
 			blocks := make([]block2.IBlock, 0)
+			msgs := make([]*types_pb.Block, 0)
 			for i := 0; i < maxResultsProcess; i++ {
 				if blockMsg, ok := d.bodyResultStore[*wantBlockNumber]; ok {
 					var block block2.Block
@@ -171,6 +178,7 @@ func (d *Downloader) processChain() error {
 					}
 					delete(d.bodyResultStore, *wantBlockNumber)
 					blocks = append(blocks, &block)
+					msgs = append(msgs, blockMsg)
 				}
 				wantBlockNumber.AddUint64(wantBlockNumber, 1)
 			}
@@ -186,7 +194,14 @@ func (d *Downloader) processChain() error {
 				"lastnum", last.Number64().Uint64(), "lasthash", last.Hash(),
 			)
 
-			if index, err := d.bc.InsertChain(blocks); err != nil {
+			if index, err := d.bc.InsertChain(blocks); err == diskguard.ErrLowDiskSpace {
+				// paused before anything was written, retry the same blocks later
+				for _, msg := range msgs {
+					d.bodyResultStore[*utils.ConvertH256ToUint256Int(msg.Header.Number)] = msg
+				}
+				d.bodyTaskPoolLock.Unlock()
+				continue
+			} else if err != nil {
 				//inserted = false
 				if index < len(blocks) {
 					log.Errorf("downloader failed to inster new block in blockchain, err:%v", err)
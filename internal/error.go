@@ -82,4 +82,8 @@ var (
 
 	// ErrSenderNoEOA is returned if the sender of a transaction is a contract.
 	ErrSenderNoEOA = errors.New("sender not an eoa")
+
+	// ErrTxExpired is returned if a transaction is included after its validity
+	// window has passed.
+	ErrTxExpired = errors.New("transaction validity window expired")
 )
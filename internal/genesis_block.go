@@ -69,6 +69,12 @@ func (g *GenesisBlock) Write(tx kv.RwTx) (*block2.Block, *state.IntraBlockState,
 	if err := rawdb.WriteCanonicalHash(tx, block.Hash(), block.Number64().Uint64()); err != nil {
 		return nil, nil, err
 	}
+	if err := rawdb.AppendCumulativeIndexes(tx, block.Number64().Uint64(), block.GasUsed(), uint64(len(block.Transactions()))); err != nil {
+		return nil, nil, err
+	}
+	if err := rawdb.RecordBlockSize(tx, block.Hash(), block.Number64().Uint64()); err != nil {
+		return nil, nil, err
+	}
 
 	rawdb.WriteHeadBlockHash(tx, block.Hash())
 	if err := rawdb.WriteHeadHeaderHash(tx, block.Hash()); err != nil {
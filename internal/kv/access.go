@@ -0,0 +1,204 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Owner prefixes of access declarations: a stage writing derived data, or an RPC method or namespace
+const (
+	StageOwner = "stage/"
+	RPCOwner   = "rpc/"
+)
+
+// Access - tables one owner reads and writes. Writing a table implies reading it.
+type Access struct {
+	Reads  []string `json:"reads,omitempty"`
+	Writes []string `json:"writes,omitempty"`
+}
+
+var (
+	headerTables = []string{Headers, HeaderNumber, HeaderCanonical, HeaderTD, HeadBlockKey, HeadHeaderKey}
+	bodyTables   = []string{BlockBody, EthTx, Sequence, Senders}
+	stateTables  = []string{
+		PlainState, PlainContractCode, Code, IncarnationMap, ConfigTable,
+		AccountChangeSet, StorageChangeSet, AccountsHistory, StorageHistory,
+	}
+)
+
+func tableList(groups ...[]string) []string {
+	var res []string
+	for _, g := range groups {
+		res = append(res, g...)
+	}
+	return res
+}
+
+// declaredAccess - owner -> tables it touches. A new code path reading a table declares it here or
+// through DeclareAccess, so pruning and unwinding of the table know what they break.
+// debug and trace are declared per namespace, their methods share the tables.
+var declaredAccess = map[string]Access{
+	StageOwner + "Execution": {
+		Reads:  tableList(headerTables, bodyTables),
+		Writes: []string{PlainState, PlainContractCode, Code, AccountChangeSet, StorageChangeSet, Receipts, Log, CallTraceSet},
+	},
+	StageOwner + "Senders": {
+		Reads:  []string{HeaderCanonical, BlockBody, EthTx, ConfigTable},
+		Writes: []string{Senders},
+	},
+	StageOwner + "TxLookup": {
+		Reads:  []string{HeaderCanonical, BlockBody, EthTx},
+		Writes: []string{TxLookup},
+	},
+
+	RPCOwner + "eth_blockNumber":           {Reads: headerTables},
+	RPCOwner + "eth_getBlockByHash":        {Reads: tableList(headerTables, bodyTables)},
+	RPCOwner + "eth_getBlockByNumber":      {Reads: tableList(headerTables, bodyTables)},
+	RPCOwner + "eth_getTransactionByHash":  {Reads: tableList(headerTables, bodyTables, []string{TxLookup})},
+	RPCOwner + "eth_getTransactionReceipt": {Reads: tableList(headerTables, bodyTables, []string{TxLookup, Receipts, Log, ConfigTable})},
+	RPCOwner + "eth_getLogs":               {Reads: tableList(headerTables, []string{Receipts, Log, LogTopicIndex, LogAddressIndex})},
+	RPCOwner + "eth_getBalance":            {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "eth_getCode":               {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "eth_getStorageAt":          {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "eth_getTransactionCount":   {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "eth_call":                  {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "eth_estimateGas":           {Reads: tableList(headerTables, stateTables)},
+	RPCOwner + "debug":                     {Reads: []string{CallTraceSet, TrieOfAccounts, TrieOfStorage, HashedAccounts, HashedStorage}},
+	RPCOwner + "trace":                     {Reads: []string{CallTraceSet, CallFromIndex, CallToIndex}},
+}
+
+// DeclareAccess - registers the tables owner reads and writes, meant to be called from init of the
+// package implementing a stage or an RPC method. Owner starts with StageOwner or RPCOwner.
+func DeclareAccess(owner string, reads, writes []string) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if !strings.HasPrefix(owner, StageOwner) && !strings.HasPrefix(owner, RPCOwner) {
+		return fmt.Errorf("owner %q is neither a stage nor an RPC method", owner)
+	}
+	if _, ok := declaredAccess[owner]; ok {
+		return fmt.Errorf("access of %s is already declared", owner)
+	}
+	for _, table := range append(append([]string(nil), reads...), writes...) {
+		if _, ok := lookup(table); !ok {
+			return fmt.Errorf("%s declares unknown table %s", owner, table)
+		}
+	}
+	declaredAccess[owner] = Access{
+		Reads:  append([]string(nil), reads...),
+		Writes: append([]string(nil), writes...),
+	}
+	return nil
+}
+
+// DeclaredAccess - sorted tables declared by owner
+func DeclaredAccess(owner string) (Access, bool) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	a, ok := declaredAccess[owner]
+	if !ok {
+		return Access{}, false
+	}
+	res := Access{Reads: append([]string(nil), a.Reads...), Writes: append([]string(nil), a.Writes...)}
+	sort.Strings(res.Reads)
+	sort.Strings(res.Writes)
+	return res, true
+}
+
+// TableUse - owners reading and writing one table, a row of AccessMatrix
+type TableUse struct {
+	Table   string   `json:"table"`
+	Readers []string `json:"readers,omitempty"`
+	Writers []string `json:"writers,omitempty"`
+}
+
+// AccessMatrix - per table, sorted by name, the owners declaring it. Writers are not repeated as readers.
+func AccessMatrix() []TableUse {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	rows := make(map[string]*TableUse)
+	row := func(table string) *TableUse {
+		r, ok := rows[table]
+		if !ok {
+			r = &TableUse{Table: table}
+			rows[table] = r
+		}
+		return r
+	}
+	for owner, a := range declaredAccess {
+		writes := make(map[string]struct{}, len(a.Writes))
+		for _, table := range a.Writes {
+			if _, ok := writes[table]; !ok {
+				writes[table] = struct{}{}
+				row(table).Writers = append(row(table).Writers, owner)
+			}
+		}
+		reads := make(map[string]struct{}, len(a.Reads))
+		for _, table := range a.Reads {
+			_, written := writes[table]
+			if _, ok := reads[table]; !ok && !written {
+				reads[table] = struct{}{}
+				row(table).Readers = append(row(table).Readers, owner)
+			}
+		}
+	}
+	res := make([]TableUse, 0, len(rows))
+	for _, r := range rows {
+		sort.Strings(r.Readers)
+		sort.Strings(r.Writers)
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Table < res[j].Table })
+	return res
+}
+
+// Dependents - sorted owners starting with prefix which read or write any of tables, with the
+// prefix cut off. Dependents(RPCOwner, Receipts) are the RPC methods broken by pruning receipts.
+func Dependents(prefix string, tables ...string) []string {
+	want := make(map[string]struct{}, len(tables))
+	for _, table := range tables {
+		want[table] = struct{}{}
+	}
+	var res []string
+	for _, use := range AccessMatrix() {
+		if _, ok := want[use.Table]; !ok {
+			continue
+		}
+		for _, owner := range append(use.Readers, use.Writers...) {
+			if strings.HasPrefix(owner, prefix) {
+				res = append(res, strings.TrimPrefix(owner, prefix))
+			}
+		}
+	}
+	sort.Strings(res)
+	return dedupSorted(res)
+}
+
+func dedupSorted(s []string) []string {
+	res := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
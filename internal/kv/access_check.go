@@ -0,0 +1,245 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUndeclaredAccess - table accessed by an owner which did not declare it
+var ErrUndeclaredAccess = errors.New("undeclared table access")
+
+// WithAccessCheck - tx failing every access of owner to a table it did not declare with
+// ErrUndeclaredAccess. Checks run only in builds with the kvdebug tag, others get tx back as is.
+func WithAccessCheck(owner string, tx Tx) Tx {
+	if !accessChecks {
+		return tx
+	}
+	return &checkedTx{Tx: tx, access: newAccessChecker(owner)}
+}
+
+// WithRwAccessCheck - WithAccessCheck of a write transaction, writes need a declared write
+func WithRwAccessCheck(owner string, tx RwTx) RwTx {
+	if !accessChecks {
+		return tx
+	}
+	return newCheckedRwTx(owner, tx)
+}
+
+// accessChecker - declared tables of one owner, read at wrap time
+type accessChecker struct {
+	owner  string
+	reads  map[string]struct{}
+	writes map[string]struct{}
+}
+
+func newAccessChecker(owner string) *accessChecker {
+	c := &accessChecker{owner: owner, reads: map[string]struct{}{}, writes: map[string]struct{}{}}
+	a, _ := DeclaredAccess(owner)
+	for _, table := range a.Reads {
+		c.reads[table] = struct{}{}
+	}
+	for _, table := range a.Writes {
+		c.reads[table] = struct{}{}
+		c.writes[table] = struct{}{}
+	}
+	return c
+}
+
+func (c *accessChecker) read(table string) error {
+	if _, ok := c.reads[table]; !ok {
+		return fmt.Errorf("%w: %s reads %s", ErrUndeclaredAccess, c.owner, table)
+	}
+	return nil
+}
+
+func (c *accessChecker) write(table string) error {
+	if _, ok := c.writes[table]; !ok {
+		return fmt.Errorf("%w: %s writes %s", ErrUndeclaredAccess, c.owner, table)
+	}
+	return nil
+}
+
+// checkedTx - Tx checking the tables of every access. Sequences are kept in the Sequence table,
+// whichever table they count.
+type checkedTx struct {
+	Tx
+	access *accessChecker
+}
+
+func (tx *checkedTx) Has(table string, key []byte) (bool, error) {
+	if err := tx.access.read(table); err != nil {
+		return false, err
+	}
+	return tx.Tx.Has(table, key)
+}
+
+func (tx *checkedTx) GetOne(table string, key []byte) ([]byte, error) {
+	if err := tx.access.read(table); err != nil {
+		return nil, err
+	}
+	return tx.Tx.GetOne(table, key)
+}
+
+func (tx *checkedTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.access.read(table); err != nil {
+		return err
+	}
+	return tx.Tx.ForEach(table, fromPrefix, walker)
+}
+
+func (tx *checkedTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	if err := tx.access.read(table); err != nil {
+		return err
+	}
+	return tx.Tx.ForPrefix(table, prefix, walker)
+}
+
+func (tx *checkedTx) ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	if err := tx.access.read(table); err != nil {
+		return err
+	}
+	return tx.Tx.ForAmount(table, prefix, amount, walker)
+}
+
+func (tx *checkedTx) ReadSequence(table string) (uint64, error) {
+	if err := tx.access.read(Sequence); err != nil {
+		return 0, err
+	}
+	return tx.Tx.ReadSequence(table)
+}
+
+func (tx *checkedTx) BucketSize(table string) (uint64, error) {
+	if err := tx.access.read(table); err != nil {
+		return 0, err
+	}
+	return tx.Tx.BucketSize(table)
+}
+
+func (tx *checkedTx) Cursor(table string) (Cursor, error) {
+	if err := tx.access.read(table); err != nil {
+		return nil, err
+	}
+	return tx.Tx.Cursor(table)
+}
+
+func (tx *checkedTx) CursorDupSort(table string) (CursorDupSort, error) {
+	if err := tx.access.read(table); err != nil {
+		return nil, err
+	}
+	return tx.Tx.CursorDupSort(table)
+}
+
+// checkedRwTx - RwTx checking the tables of every access, reads go through checkedTx
+type checkedRwTx struct {
+	RwTx
+	ro *checkedTx
+}
+
+func newCheckedRwTx(owner string, tx RwTx) *checkedRwTx {
+	return &checkedRwTx{RwTx: tx, ro: &checkedTx{Tx: tx, access: newAccessChecker(owner)}}
+}
+
+func (tx *checkedRwTx) Has(table string, key []byte) (bool, error) { return tx.ro.Has(table, key) }
+
+func (tx *checkedRwTx) GetOne(table string, key []byte) ([]byte, error) {
+	return tx.ro.GetOne(table, key)
+}
+
+func (tx *checkedRwTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return tx.ro.ForEach(table, fromPrefix, walker)
+}
+
+func (tx *checkedRwTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	return tx.ro.ForPrefix(table, prefix, walker)
+}
+
+func (tx *checkedRwTx) ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	return tx.ro.ForAmount(table, prefix, amount, walker)
+}
+
+func (tx *checkedRwTx) ReadSequence(table string) (uint64, error) { return tx.ro.ReadSequence(table) }
+func (tx *checkedRwTx) BucketSize(table string) (uint64, error)   { return tx.ro.BucketSize(table) }
+func (tx *checkedRwTx) Cursor(table string) (Cursor, error)       { return tx.ro.Cursor(table) }
+
+func (tx *checkedRwTx) CursorDupSort(table string) (CursorDupSort, error) {
+	return tx.ro.CursorDupSort(table)
+}
+
+func (tx *checkedRwTx) Put(table string, k, v []byte) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Put(table, k, v)
+}
+
+func (tx *checkedRwTx) Delete(table string, k []byte) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Delete(table, k)
+}
+
+func (tx *checkedRwTx) Append(table string, k, v []byte) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.Append(table, k, v)
+}
+
+func (tx *checkedRwTx) AppendDup(table string, k, v []byte) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.AppendDup(table, k, v)
+}
+
+func (tx *checkedRwTx) IncrementSequence(table string, amount uint64) (uint64, error) {
+	if err := tx.ro.access.write(Sequence); err != nil {
+		return 0, err
+	}
+	return tx.RwTx.IncrementSequence(table, amount)
+}
+
+func (tx *checkedRwTx) RwCursor(table string) (RwCursor, error) {
+	if err := tx.ro.access.write(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RwCursor(table)
+}
+
+func (tx *checkedRwTx) RwCursorDupSort(table string) (RwCursorDupSort, error) {
+	if err := tx.ro.access.write(table); err != nil {
+		return nil, err
+	}
+	return tx.RwTx.RwCursorDupSort(table)
+}
+
+func (tx *checkedRwTx) ClearBucket(table string) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.ClearBucket(table)
+}
+
+func (tx *checkedRwTx) DropBucket(table string) error {
+	if err := tx.ro.access.write(table); err != nil {
+		return err
+	}
+	return tx.RwTx.DropBucket(table)
+}
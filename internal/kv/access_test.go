@@ -0,0 +1,146 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDeclaredAccess(t *testing.T) {
+	for owner, a := range declaredAccess {
+		for _, table := range append(append([]string(nil), a.Reads...), a.Writes...) {
+			if _, ok := ChaindataTablesCfg[table]; !ok {
+				t.Fatalf("%s declares %s, not a chaindata table", owner, table)
+			}
+		}
+	}
+
+	// every table written by execution is declared
+	execution, ok := DeclaredAccess(StageOwner + "Execution")
+	if !ok {
+		t.Fatal("execution stage not declared")
+	}
+	ws := ExecutionWriteSet(1, ExecutionChanges{
+		Accounts: [][]byte{make([]byte, 20)},
+		Storage:  []StorageChange{{Addr: make([]byte, 20), Loc: make([]byte, 32)}},
+		Code:     []CodeChange{{Addr: make([]byte, 20), CodeHash: make([]byte, 32)}},
+		Txs:      1,
+		LogTxs:   []uint32{0},
+		Traced:   [][]byte{make([]byte, 20)},
+	})
+	for table := range ws {
+		if i := sort.SearchStrings(execution.Writes, table); i == len(execution.Writes) || execution.Writes[i] != table {
+			t.Fatalf("execution writes undeclared %s", table)
+		}
+	}
+
+	if have := Dependents(RPCOwner, Receipts); !reflect.DeepEqual(have, []string{"eth_getLogs", "eth_getTransactionReceipt"}) {
+		t.Fatalf("methods reading receipts %v", have)
+	}
+	if have := Dependents(StageOwner, TxLookup); !reflect.DeepEqual(have, []string{"TxLookup"}) {
+		t.Fatalf("stages touching TxLookup %v", have)
+	}
+	for _, use := range AccessMatrix() {
+		if use.Table != Senders {
+			continue
+		}
+		if !reflect.DeepEqual(use.Writers, []string{StageOwner + "Senders"}) {
+			t.Fatalf("Senders writers %v", use.Writers)
+		}
+		for _, r := range use.Readers {
+			if r == StageOwner+"Senders" {
+				t.Fatal("writer listed as reader")
+			}
+		}
+	}
+}
+
+func TestDeclareAccess(t *testing.T) {
+	const owner = RPCOwner + "test_method"
+	defer func() {
+		registryLock.Lock()
+		delete(declaredAccess, owner)
+		registryLock.Unlock()
+	}()
+	if err := DeclareAccess("test_method", []string{Headers}, nil); err == nil {
+		t.Fatal("owner without prefix accepted")
+	}
+	if err := DeclareAccess(owner, []string{"NoSuchTable"}, nil); err == nil {
+		t.Fatal("unknown table accepted")
+	}
+	if err := DeclareAccess(owner, []string{Headers, Receipts}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeclareAccess(owner, []string{Headers}, nil); err == nil {
+		t.Fatal("second declaration accepted")
+	}
+	if have := Dependents(RPCOwner, Receipts); !reflect.DeepEqual(have, []string{"eth_getLogs", "eth_getTransactionReceipt", "test_method"}) {
+		t.Fatalf("methods reading receipts %v", have)
+	}
+	if RPCNamespaceTables("test") == nil {
+		t.Fatal("namespace of declared method has no tables")
+	}
+}
+
+// TestUndeclaredAccess - every table access of an owner outside its declaration fails
+func TestUndeclaredAccess(t *testing.T) {
+	inner := newMockTx()
+	tx := newCheckedRwTx(StageOwner+"TxLookup", inner)
+	key := make([]byte, HashLen)
+
+	// declared
+	if err := tx.Put(TxLookup, key, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.GetOne(EthTx, key); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := tx.RwCursor(TxLookup); err != nil || c == nil {
+		t.Fatalf("cursor of declared table: %v", err)
+	}
+
+	for name, access := range map[string]func() error{
+		"read":      func() error { _, err := tx.GetOne(Receipts, key); return err },
+		"cursor":    func() error { _, err := tx.Cursor(PlainState); return err },
+		"walk":      func() error { return tx.ForEach(Log, nil, func(k, v []byte) error { return nil }) },
+		"write":     func() error { return tx.Put(EthTx, key, []byte{1}) },
+		"rw cursor": func() error { _, err := tx.RwCursor(BlockBody); return err },
+		"sequence":  func() error { _, err := tx.IncrementSequence(EthTx, 1); return err },
+		"clear":     func() error { return tx.ClearBucket(HeaderCanonical) },
+	} {
+		if err := access(); !errors.Is(err, ErrUndeclaredAccess) {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if v, _ := inner.GetOne(EthTx, key); v != nil {
+		t.Fatal("undeclared write reached the transaction")
+	}
+
+	if _, err := newCheckedRwTx(RPCOwner+"no_such_method", inner).Has(TxLookup, key); !errors.Is(err, ErrUndeclaredAccess) {
+		t.Fatalf("access of undeclared owner: %v", err)
+	}
+	if accessChecks {
+		if _, err := WithAccessCheck(RPCOwner+"eth_getLogs", inner).GetOne(TxLookup, key); !errors.Is(err, ErrUndeclaredAccess) {
+			t.Fatalf("kvdebug build: %v", err)
+		}
+	} else if WithRwAccessCheck(RPCOwner+"eth_getLogs", inner) != RwTx(inner) {
+		t.Fatal("transaction wrapped without kvdebug")
+	}
+}
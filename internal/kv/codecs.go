@@ -0,0 +1,155 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Codec - decoders of the keys and values of a table, a nil decoder accepts anything
+type Codec struct {
+	Key   func(k []byte) error
+	Value func(v []byte) error
+}
+
+func fixedLen(what string, n int) func([]byte) error {
+	return func(b []byte) error {
+		if len(b) != n {
+			return fmt.Errorf("%s %x: unexpected length %d, want %d", what, b, len(b), n)
+		}
+		return nil
+	}
+}
+
+func headerKey(k []byte) error {
+	_, _, err := ParseHeaderKey(k)
+	return err
+}
+
+// codecs - key layouts shared by every database using these tables; modules owning the
+// value formats refine them with RegisterCodec
+var codecs = map[string]Codec{
+	Headers:         {Key: headerKey},
+	HeaderTD:        {Key: headerKey},
+	BlockBody:       {Key: headerKey},
+	HeaderCanonical: {Key: fixedLen("block number", BlockNumLen), Value: fixedLen("header hash", HashLen)},
+	HeaderNumber:    {Key: fixedLen("header hash", HashLen), Value: fixedLen("block number", BlockNumLen)},
+}
+
+// RegisterCodec - sets the codec of table, meant to be called from init of the module owning
+// the format. A previous codec is replaced.
+func RegisterCodec(table string, c Codec) error {
+	if table == "" {
+		return fmt.Errorf("empty table name")
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	codecs[table] = c
+	return nil
+}
+
+// CodecTables - sorted tables with a registered codec
+func CodecTables() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	res := make([]string, 0, len(codecs))
+	for table := range codecs {
+		res = append(res, table)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func lookupCodec(table string) Codec {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return codecs[table]
+}
+
+func (c Codec) decode(k, v []byte) error {
+	if c.Key != nil {
+		if err := c.Key(k); err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+	}
+	if c.Value != nil {
+		if err := c.Value(v); err != nil {
+			return fmt.Errorf("value of %x: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// VerifyCodecs - decodes sampleN records (sampleN <= 0 - all records) of every table with a registered
+// codec, one error per table holding undecodable data. Samples are every k-th record counted back from
+// the last one, so they spread over the whole table and always include the newest record. Catches
+// format drift of databases written by other versions.
+func VerifyCodecs(tx Getter, sampleN int) []error {
+	var errs []error
+	for _, table := range CodecTables() {
+		codec := lookupCodec(table)
+		first, stride, err := sampling(tx, table, sampleN)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", table, err))
+			continue
+		}
+		var i, sampled, bad int
+		var firstErr error
+		if err := tx.ForEach(table, nil, func(k, v []byte) error {
+			i++
+			if n := i - 1; n < first || (n-first)%stride != 0 {
+				return nil
+			}
+			sampled++
+			if err := codec.decode(k, v); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				bad++
+			}
+			return nil
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", table, err))
+			continue
+		}
+		if bad > 0 {
+			errs = append(errs, fmt.Errorf("%s: %d of %d sampled records undecodable, first %w", table, bad, sampled, firstErr))
+		}
+	}
+	return errs
+}
+
+// sampling - index of the first sampled record of table and distance between samples, so that sampleN
+// samples end at the last record
+func sampling(tx Getter, table string, sampleN int) (first, stride int, err error) {
+	if sampleN <= 0 {
+		return 0, 1, nil
+	}
+	var count int
+	if err := tx.ForEach(table, nil, func(k, v []byte) error {
+		count++
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	if count <= sampleN {
+		return 0, 1, nil
+	}
+	stride = count / sampleN
+	return count - 1 - (sampleN-1)*stride, stride, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodecs(t *testing.T) {
+	const testTable = "CodecTest"
+	errOdd := errors.New("odd value")
+	if err := RegisterCodec(testTable, Codec{Value: func(v []byte) error {
+		if len(v)%2 != 0 {
+			return errOdd
+		}
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		registryLock.Lock()
+		delete(codecs, testTable)
+		registryLock.Unlock()
+	}()
+
+	tx := newMockTx()
+	hash := bytes.Repeat([]byte{0xab}, HashLen)
+	for n := uint64(0); n < 10; n++ {
+		tx.Put(HeaderCanonical, EncodeBlockNum(n), hash)
+		tx.Put(HeaderNumber, append(EncodeBlockNum(n), hash[BlockNumLen:]...), EncodeBlockNum(n))
+		tx.Put(Headers, HeaderKey(n, hash), []byte{0xc0})
+		tx.Put(testTable, EncodeBlockNum(n), []byte{1, 2})
+	}
+	if errs := VerifyCodecs(tx, 5); len(errs) != 0 {
+		t.Fatalf("valid records: %v", errs)
+	}
+
+	// truncated hash in block 3, block number key sorting last in HeaderNumber, odd value between samples in CodecTest
+	tx.Put(HeaderCanonical, EncodeBlockNum(3), hash[:20])
+	tx.Put(HeaderNumber, []byte{1}, EncodeBlockNum(1))
+	tx.Put(testTable, EncodeBlockNum(8), []byte{1})
+	errs := VerifyCodecs(tx, 5)
+	if len(errs) != 2 {
+		t.Fatalf("have %d errors, want 2: %v", len(errs), errs)
+	}
+	if msg := errs[0].Error(); !strings.HasPrefix(msg, HeaderCanonical+": 1 of 5 sampled") || !strings.Contains(msg, "header hash") {
+		t.Fatalf("canonical: %v", errs[0])
+	}
+	if msg := errs[1].Error(); !strings.HasPrefix(msg, HeaderNumber+": 1 of 5 sampled") {
+		t.Fatalf("header number: %v", errs[1])
+	}
+
+	// the whole table
+	errs = VerifyCodecs(tx, 0)
+	if len(errs) != 3 || !errors.Is(errs[1], errOdd) || !strings.HasPrefix(errs[1].Error(), testTable+": 1 of 10 sampled") {
+		t.Fatalf("all records: %v", errs)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrUnknownTables - binary has tables the db layout doesn't, see UnknownTablesError
+	ErrUnknownTables = errors.New("tables missing in db")
+	// ErrDBILayoutMismatch - tables got other DBIs at open than the ones recorded in the db
+	ErrDBILayoutMismatch = errors.New("dbi layout mismatch")
+)
+
+// UnknownTablesError - tables of this binary the db doesn't have. A writable db creates them when opened with
+// auto-create (additive upgrade), a read-only one can't.
+type UnknownTablesError struct {
+	Tables []string
+}
+
+func (e *UnknownTablesError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnknownTables, strings.Join(e.Tables, ", "))
+}
+
+func (e *UnknownTablesError) Is(target error) bool { return target == ErrUnknownTables }
+
+// DBILayout - DBI of every table of the db, stored in DatabaseInfo under DBILayoutKey.
+// Tables are opened in DBI order, so a table keeps its DBI as long as no table before it is dropped.
+type DBILayout map[string]DBI
+
+// ReadDBILayout - recorded layout, nil for db which has none
+func ReadDBILayout(tx Getter) (DBILayout, error) {
+	v, err := tx.GetOne(DatabaseInfo, DBILayoutKey)
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	var l DBILayout
+	if err := json.Unmarshal(v, &l); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDBILayoutMismatch, err)
+	}
+	return l, nil
+}
+
+func WriteDBILayout(tx Putter, l DBILayout) error {
+	v, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return tx.Put(DatabaseInfo, DBILayoutKey, v)
+}
+
+// Tables - tables of the layout in DBI order
+func (l DBILayout) Tables() []string {
+	res := make([]string, 0, len(l))
+	for name := range l {
+		res = append(res, name)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if l[res[i]] != l[res[j]] {
+			return l[res[i]] < l[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// Diff - "table have->want" for every table whose DBI differs in other, tables missing in either are skipped
+func (l DBILayout) Diff(other DBILayout) []string {
+	var res []string
+	for _, name := range l.Tables() {
+		if dbi, ok := other[name]; ok && dbi != l[name] {
+			res = append(res, fmt.Sprintf("%s %d->%d", name, l[name], dbi))
+		}
+	}
+	return res
+}
+
+func (l DBILayout) Equal(other DBILayout) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for name, dbi := range l {
+		if got, ok := other[name]; !ok || got != dbi {
+			return false
+		}
+	}
+	return true
+}
+
+// DBIOrder - order to open tables in: tables of layout in DBI order, then sorted tables which layout doesn't
+// have (added). DatabaseInfo goes first into a db without layout, the layout is read from it.
+func DBIOrder(layout DBILayout, tables []string) (order, added []string) {
+	order = layout.Tables()
+	for _, name := range tables {
+		if _, ok := layout[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	if len(layout) == 0 {
+		for i, name := range added {
+			if name == DatabaseInfo {
+				copy(added[1:i+1], added[:i])
+				added[0] = name
+				break
+			}
+		}
+	}
+	return append(order, added...), added
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DropTx - what DropDeprecatedTables needs of a write tx, RwTx of internal/kv and of erigon-lib both satisfy it
+type DropTx interface {
+	Putter
+	ExistsBucket(table string) (bool, error)
+	BucketSize(table string) (uint64, error)
+	DropBucket(table string) error
+	Commit() error
+	Rollback()
+}
+
+// DroppedTable - record DropDeprecatedTables keeps in DatabaseInfo for every table it dropped
+type DroppedTable struct {
+	Table string    `json:"table"`
+	Time  time.Time `json:"time"`
+	Size  uint64    `json:"size"` // bytes the table took
+}
+
+// DeprecatedTables - sorted tables of ChaindataTablesCfg flagged IsDeprecated
+func DeprecatedTables() []string {
+	var res []string
+	for name, cfg := range ChaindataTablesCfg {
+		if cfg.IsDeprecated {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// DropDeprecatedTables - drops the DBI of every table of DeprecatedTables existing in the database, freeing its pages,
+// and records the drop in DatabaseInfo. Every table is dropped in its own write tx begun by begin, so a live node
+// waits for one table at a time. With dryRun nothing is written and freedBytes is what a drop would free.
+func DropDeprecatedTables(ctx context.Context, begin func(context.Context) (DropTx, error), dryRun bool) (freedBytes int64, err error) {
+	for _, name := range DeprecatedTables() {
+		size, err := dropDeprecatedTable(ctx, begin, name, dryRun)
+		if err != nil {
+			return freedBytes, fmt.Errorf("drop %s: %w", name, err)
+		}
+		freedBytes += int64(size)
+	}
+	return freedBytes, nil
+}
+
+func dropDeprecatedTable(ctx context.Context, begin func(context.Context) (DropTx, error), name string, dryRun bool) (uint64, error) {
+	tx, err := begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	exists, err := tx.ExistsBucket(name)
+	if err != nil || !exists {
+		return 0, err
+	}
+	size, err := tx.BucketSize(name)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return size, nil
+	}
+	if err := tx.DropBucket(name); err != nil {
+		return 0, err
+	}
+	v, err := json.Marshal(DroppedTable{Table: name, Time: time.Now().UTC(), Size: size})
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Put(DatabaseInfo, append(append([]byte{}, DroppedTableKey...), name...), v); err != nil {
+		return 0, err
+	}
+	return size, tx.Commit()
+}
+
+// ReadDroppedTables - records of the tables DropDeprecatedTables dropped, by table name
+func ReadDroppedTables(tx Getter) ([]DroppedTable, error) {
+	var res []DroppedTable
+	err := tx.ForPrefix(DatabaseInfo, DroppedTableKey, func(k, v []byte) error {
+		var d DroppedTable
+		if err := json.Unmarshal(v, &d); err != nil {
+			return fmt.Errorf("dropped table record %s: %w", k, err)
+		}
+		res = append(res, d)
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// dupSortConvertingCursor - logical view of table with AutoDupSortKeysConversion over cursor working
+// with db format: keys of DupFromLen are stored as DupToLen key + (rest of key + value) DupSort value.
+// Keys shorter than DupToLen are stored as is. Returned keys and values are never aliased to the
+// keys and values passed in.
+type dupSortConvertingCursor struct {
+	c        RwCursorDupSort
+	from, to int
+	table    string
+}
+
+// NewDupSortConvertingCursor - wraps cursor c of table configured by cfg, c must return keys and values
+// in db format. Returns c itself if the table doesn't use AutoDupSortKeysConversion.
+func NewDupSortConvertingCursor(c RwCursorDupSort, table string, cfg TableCfgItem) RwCursor {
+	if !cfg.AutoDupSortKeysConversion {
+		return c
+	}
+	return &dupSortConvertingCursor{c: c, from: cfg.DupFromLen, to: cfg.DupToLen, table: table}
+}
+
+// join - db format to logical
+func (c *dupSortConvertingCursor) join(k, v []byte, err error) ([]byte, []byte, error) {
+	if err != nil || k == nil || len(k) != c.to {
+		return k, v, err
+	}
+	keyPart := c.from - c.to
+	if len(v) < keyPart {
+		return nil, nil, fmt.Errorf("table %s: value %x of key %x is shorter than key part %d", c.table, v, k, keyPart)
+	}
+	k2 := make([]byte, 0, c.from)
+	k2 = append(append(k2, k...), v[:keyPart]...)
+	return k2, v[keyPart:], nil
+}
+
+// split - logical to db format, the value is a new slice
+func (c *dupSortConvertingCursor) split(k, v []byte) ([]byte, []byte) {
+	v2 := make([]byte, 0, c.from-c.to+len(v))
+	v2 = append(append(v2, k[c.to:]...), v...)
+	return k[:c.to], v2
+}
+
+func (c *dupSortConvertingCursor) checkKey(op string, k []byte) error {
+	if len(k) != c.from && len(k) >= c.to {
+		return fmt.Errorf("%s dupsort table %s: can have keys of len==%d and len<%d. key: %x,%d", op, c.table, c.from, c.to, k, len(k))
+	}
+	return nil
+}
+
+func (c *dupSortConvertingCursor) First() ([]byte, []byte, error) {
+	return c.join(c.c.First())
+}
+
+func (c *dupSortConvertingCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	if len(seek) <= c.to {
+		// shorter seek keys are prefixes of db keys, plain range seek is enough
+		return c.join(c.c.Seek(seek))
+	}
+	seek1, seek2 := seek[:c.to], seek[c.to:]
+	k, v, err := c.c.Seek(seek1)
+	if err != nil || k == nil || !bytes.Equal(k, seek1) {
+		return c.join(k, v, err)
+	}
+	v, err = c.c.SeekBothRange(seek1, seek2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v == nil {
+		// every value of seek1 is smaller, move to the next key
+		if _, _, err = c.c.Seek(seek1); err != nil {
+			return nil, nil, err
+		}
+		return c.join(c.c.NextNoDup())
+	}
+	return c.join(seek1, v, nil)
+}
+
+func (c *dupSortConvertingCursor) SeekExact(key []byte) ([]byte, []byte, error) {
+	if len(key) != c.from {
+		return c.join(c.c.SeekExact(key))
+	}
+	v, err := c.c.SeekBothRange(key[:c.to], key[c.to:])
+	if err != nil || v == nil || !bytes.HasPrefix(v, key[c.to:]) {
+		return nil, nil, err
+	}
+	return c.join(key[:c.to], v, nil)
+}
+
+func (c *dupSortConvertingCursor) Next() ([]byte, []byte, error)    { return c.join(c.c.Next()) }
+func (c *dupSortConvertingCursor) Prev() ([]byte, []byte, error)    { return c.join(c.c.Prev()) }
+func (c *dupSortConvertingCursor) Last() ([]byte, []byte, error)    { return c.join(c.c.Last()) }
+func (c *dupSortConvertingCursor) Current() ([]byte, []byte, error) { return c.join(c.c.Current()) }
+func (c *dupSortConvertingCursor) Count() (uint64, error)           { return c.c.Count() }
+func (c *dupSortConvertingCursor) Close()                           { c.c.Close() }
+func (c *dupSortConvertingCursor) DeleteCurrent() error             { return c.c.DeleteCurrent() }
+
+func (c *dupSortConvertingCursor) Put(k, v []byte) error {
+	if len(k) == 0 {
+		return fmt.Errorf("empty keys are not supported. table: %s", c.table)
+	}
+	if err := c.checkKey("put", k); err != nil {
+		return err
+	}
+	if len(k) != c.from {
+		// short keys have single value
+		if err := c.c.Delete(k); err != nil {
+			return err
+		}
+		return c.c.Put(k, v)
+	}
+	if err := c.Delete(k); err != nil {
+		return err
+	}
+	k, v = c.split(k, v)
+	return c.c.Put(k, v)
+}
+
+func (c *dupSortConvertingCursor) Append(k, v []byte) error {
+	if len(k) == 0 {
+		return fmt.Errorf("empty keys are not supported. table: %s", c.table)
+	}
+	if err := c.checkKey("append", k); err != nil {
+		return err
+	}
+	if len(k) == c.from {
+		k, v = c.split(k, v)
+	}
+	return c.c.AppendDup(k, v)
+}
+
+func (c *dupSortConvertingCursor) Delete(k []byte) error {
+	if err := c.checkKey("delete", k); err != nil {
+		return err
+	}
+	if len(k) != c.from {
+		return c.c.Delete(k)
+	}
+	// match only the key part of values: the rest is user data which can look like anything
+	v, err := c.c.SeekBothRange(k[:c.to], k[c.to:])
+	if err != nil || v == nil || !bytes.HasPrefix(v, k[c.to:]) {
+		return err
+	}
+	return c.c.DeleteCurrent()
+}
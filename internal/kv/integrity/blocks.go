@@ -0,0 +1,276 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/prune"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// Violation types of VerifyBlocks, keys of BlockReport.Violations
+const (
+	NoHeader   = "no-header"   // HeaderCanonical entry without Headers record
+	NoBody     = "no-body"     // canonical header without BlockBody
+	TxGap      = "tx-gap"      // transactions of the body range missing from EthTx or undecodable
+	NoTxLookup = "no-txlookup" // canonical transaction missing from TxLookup or pointing at another block
+	BadSenders = "bad-senders" // Senders missing or not one per transaction, inside the senders retention window
+	NoReceipts = "no-receipts" // Receipts missing inside the receipt retention window
+)
+
+// violationTables - tables with missing or broken records of each violation type
+var violationTables = map[string][]string{
+	NoHeader:   {kv.Headers},
+	NoBody:     {kv.BlockBody},
+	TxGap:      {kv.EthTx},
+	NoTxLookup: {kv.TxLookup},
+	BadSenders: {kv.Senders},
+	NoReceipts: {kv.Receipts},
+}
+
+// BlockReader - what VerifyBlocks reads, read transactions of internal/kv and of erigon-lib both satisfy it
+type BlockReader interface {
+	kv.Getter
+	prune.Reader
+}
+
+// BlockFix - writer of the cheap repairs: TxLookup entries and senders recovered from signatures
+type BlockFix struct {
+	Tx       RepairTx
+	Recovery *rawdb.SenderRecovery
+}
+
+// BlockReport - result of VerifyBlocks, block numbers grouped by violation type
+type BlockReport struct {
+	From       uint64              `json:"from"`
+	To         uint64              `json:"to"`      // last canonical block verified
+	Checked    uint64              `json:"checked"` // canonical blocks verified
+	Violations map[string][]uint64 `json:"violations"`
+	Fixed      map[string][]uint64 `json:"fixed,omitempty"`
+	// Affected - RPC methods and namespaces reading a table with unfixed violations, see kv.AccessMatrix
+	Affected []string `json:"affected,omitempty"`
+}
+
+// Types - sorted violation types found
+func (r *BlockReport) Types() []string {
+	res := make([]string, 0, len(r.Violations))
+	for typ := range r.Violations {
+		res = append(res, typ)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Unfixed - whether some violation was not fixed
+func (r *BlockReport) Unfixed() bool {
+	for typ, blocks := range r.Violations {
+		if len(r.Fixed[typ]) != len(blocks) {
+			return true
+		}
+	}
+	return false
+}
+
+var errBlocksDone = errors.New("blocks verified")
+
+// VerifyBlocks - streams canonical blocks [from, to] checking the references between the block tables:
+// header, body, contiguous transactions, TxLookup, Senders and Receipts. Blocks pruned of senders or
+// receipts by the stored prune mode are not required to have them. With fix, missing TxLookup entries
+// are written and bad senders are recovered from the transaction signatures.
+func VerifyBlocks(ctx context.Context, tx BlockReader, from, to uint64, fix *BlockFix) (*BlockReport, error) {
+	mode, err := prune.ReadPruneMode(tx)
+	if err != nil {
+		return nil, err
+	}
+	head := to
+	if n := rawdb.ReadCurrentBlockNumber(tx); n != nil {
+		head = *n
+	}
+	sendersFrom, _ := prune.PruneThreshold(mode.Senders, head)
+	receiptsFrom, _ := prune.PruneThreshold(mode.Receipts, head)
+
+	r := &BlockReport{From: from, Violations: map[string][]uint64{}}
+	if fix != nil {
+		r.Fixed = map[string][]uint64{}
+	}
+	violation := func(typ string, n uint64) {
+		r.Violations[typ] = append(r.Violations[typ], n)
+	}
+	fixed := func(typ string, n uint64) {
+		r.Fixed[typ] = append(r.Fixed[typ], n)
+	}
+
+	if err := tx.ForEach(kv.HeaderCanonical, kv.EncodeBlockNum(from), func(k, v []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := kv.DecodeBlockNum(k)
+		if err != nil {
+			return err
+		}
+		if n > to {
+			return errBlocksDone
+		}
+		r.To = n
+		r.Checked++
+		if len(v) != kv.HashLen {
+			return fmt.Errorf("canonical hash %x of block %d", v, n)
+		}
+		hash := types.BytesToHash(v)
+		key := kv.HeaderKey(n, v)
+
+		if ok, err := tx.Has(kv.Headers, key); err != nil {
+			return err
+		} else if !ok {
+			violation(NoHeader, n)
+			return nil
+		}
+		body, err := tx.GetOne(kv.BlockBody, key)
+		if err != nil {
+			return err
+		}
+		if len(body) != 8+4 {
+			violation(NoBody, n)
+			return nil
+		}
+
+		// the first and the last id of the range are reserved for system transactions
+		baseTxId, amount := binary.BigEndian.Uint64(body), binary.BigEndian.Uint32(body[8:])
+		var count uint32
+		if amount > 2 {
+			count = amount - 2
+		}
+		txs, complete, err := readTxs(tx, baseTxId+1, count)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			violation(TxGap, n)
+		}
+
+		missing := 0
+		for _, txn := range txs {
+			entry, err := rawdb.ReadTxLookupEntry(tx, txn.Hash())
+			if err != nil {
+				return err
+			}
+			if entry != nil && *entry == n {
+				continue
+			}
+			if missing++; fix != nil {
+				if err := rawdb.WriteTxLookupEntry(fix.Tx, txn.Hash(), n); err != nil {
+					return err
+				}
+			}
+		}
+		if missing > 0 {
+			violation(NoTxLookup, n)
+			if fix != nil && complete {
+				fixed(NoTxLookup, n)
+			}
+		}
+
+		if n >= sendersFrom {
+			raw, err := tx.GetOne(kv.Senders, key)
+			if err != nil {
+				return err
+			}
+			senders, err := rawdb.DecodeSenders(raw)
+			if (raw == nil && count > 0) || err != nil || len(senders) != int(count) {
+				violation(BadSenders, n)
+				// senders of lost transactions are lost as well
+				if fix != nil && complete {
+					// recovery prefers stored senders, malformed ones must go first
+					if raw != nil {
+						if err := fix.Tx.Delete(kv.Senders, key); err != nil {
+							return err
+						}
+					}
+					if senders, err = fix.Recovery.Senders(ctx, fix.Tx, hash, n, txs); err != nil {
+						return err
+					}
+					if err := rawdb.WriteSenders(fix.Tx, hash, n, senders); err != nil {
+						return err
+					}
+					fixed(BadSenders, n)
+				}
+			}
+		}
+
+		if n >= receiptsFrom {
+			if ok, err := tx.Has(kv.Receipts, kv.EncodeBlockNum(n)); err != nil {
+				return err
+			} else if !ok {
+				violation(NoReceipts, n)
+			}
+		}
+		return nil
+	}); err != nil && !errors.Is(err, errBlocksDone) {
+		return nil, err
+	}
+
+	var broken []string
+	for typ, blocks := range r.Violations {
+		if len(r.Fixed[typ]) != len(blocks) {
+			broken = append(broken, violationTables[typ]...)
+		}
+	}
+	if len(broken) > 0 {
+		r.Affected = kv.Dependents(kv.RPCOwner, broken...)
+	}
+	return r, nil
+}
+
+// readTxs - decodable transactions of ids [first, first+count), complete=false if some is missing or undecodable
+func readTxs(tx kv.Getter, first uint64, count uint32) (txs []*transaction.Transaction, complete bool, err error) {
+	if count == 0 {
+		return nil, true, nil
+	}
+	next := first
+	complete = true
+	if err := tx.ForAmount(kv.EthTx, kv.EncodeBlockNum(first), count, func(k, v []byte) error {
+		id, err := kv.DecodeBlockNum(k)
+		if err != nil {
+			return err
+		}
+		if id >= first+uint64(count) {
+			return errBlocksDone
+		}
+		if id != next {
+			complete = false
+		}
+		next = id + 1
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(v); err != nil {
+			complete = false
+			return nil
+		}
+		txs = append(txs, txn)
+		return nil
+	}); err != nil && !errors.Is(err, errBlocksDone) {
+		return nil, false, err
+	}
+	return txs, complete && next == first+uint64(count), nil
+}
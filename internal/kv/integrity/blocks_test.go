@@ -0,0 +1,215 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+const txsPerBlock = 2
+
+type testBlock struct {
+	hash    types.Hash
+	txs     []*transaction.Transaction
+	senders []types.Address
+}
+
+// putBlocks - canonical blocks 0..n-1 with every block table written, block 0 without transactions
+func putBlocks(t *testing.T, tx kv.RwTx, n uint64) []testBlock {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	signer := transaction.NewLondonSigner(params.AllEthashProtocolChanges.ChainID)
+	chainID, _ := uint256.FromBig(params.AllEthashProtocolChanges.ChainID)
+
+	blocks := make([]testBlock, n)
+	for num := uint64(0); num < n; num++ {
+		b := &blocks[num]
+		b.hash = types.BytesToHash(bytes.Repeat([]byte{byte(num + 1)}, kv.HashLen))
+		k := kv.HeaderKey(num, b.hash[:])
+		count := txsPerBlock
+		if num == 0 {
+			count = 0
+		}
+		baseTxId := num * 10
+		for i := 0; i < count; i++ {
+			to := types.Address{0xee}
+			txn, err := transaction.SignNewTx(key, signer, &transaction.DynamicFeeTx{
+				ChainID:   chainID,
+				Nonce:     num*10 + uint64(i),
+				GasTipCap: uint256.NewInt(1),
+				GasFeeCap: uint256.NewInt(10),
+				Gas:       21000,
+				From:      &from,
+				To:        &to,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := txn.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Put(kv.EthTx, kv.EncodeBlockNum(baseTxId+1+uint64(i)), data); err != nil {
+				t.Fatal(err)
+			}
+			if err := rawdb.WriteTxLookupEntry(tx, txn.Hash(), num); err != nil {
+				t.Fatal(err)
+			}
+			b.txs, b.senders = append(b.txs, txn), append(b.senders, from)
+		}
+		body := append(kv.EncodeBlockNum(baseTxId), 0, 0, 0, byte(count+2))
+		for _, p := range []struct {
+			table string
+			k, v  []byte
+		}{
+			{kv.HeaderCanonical, kv.EncodeBlockNum(num), b.hash[:]},
+			{kv.Headers, k, []byte{0xc0}},
+			{kv.BlockBody, k, body},
+			{kv.Receipts, kv.EncodeBlockNum(num), []byte{0}},
+		} {
+			if err := tx.Put(p.table, p.k, p.v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rawdb.WriteSenders(tx, b.hash, num, b.senders); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return blocks
+}
+
+func TestVerifyBlocks(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	ctx := context.Background()
+	blocks := putBlocks(t, tx, 10)
+
+	r, err := VerifyBlocks(ctx, tx, 0, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.To != 9 || r.Checked != 10 || len(r.Violations) != 0 {
+		t.Fatalf("sound chain: %+v", r)
+	}
+
+	key := func(n uint64) []byte { return kv.HeaderKey(n, blocks[n].hash[:]) }
+	for _, err := range []error{
+		tx.Delete(kv.Headers, key(2)),
+		tx.Delete(kv.BlockBody, key(3)),
+		tx.Delete(kv.EthTx, kv.EncodeBlockNum(4*10+1)),
+		rawdb.DeleteTxLookupEntry(tx, blocks[5].txs[1].Hash()),
+		rawdb.WriteSenders(tx, blocks[6].hash, 6, blocks[6].senders[:1]),
+		tx.Delete(kv.Receipts, kv.EncodeBlockNum(7)),
+		rawdb.WriteTxLookupEntry(tx, blocks[8].txs[0].Hash(), 1),
+		tx.Put(kv.Senders, key(9), []byte{5, 0}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string][]uint64{
+		NoHeader:   {2},
+		NoBody:     {3},
+		TxGap:      {4},
+		NoTxLookup: {5, 8},
+		BadSenders: {6, 9},
+		NoReceipts: {7},
+	}
+	if r, err = VerifyBlocks(ctx, tx, 0, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Violations, want) || r.Fixed != nil {
+		t.Fatalf("violations %v, want %v", r.Violations, want)
+	}
+	if want := kv.Dependents(kv.RPCOwner, kv.Headers, kv.BlockBody, kv.EthTx, kv.TxLookup, kv.Senders, kv.Receipts); !reflect.DeepEqual(r.Affected, want) {
+		t.Fatalf("affected %v, want %v", r.Affected, want)
+	}
+	if r, err = VerifyBlocks(ctx, tx, 3, 6, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.To != 6 || r.Checked != 4 || len(r.Types()) != 4 {
+		t.Fatalf("range 3-6: %+v", r)
+	}
+
+	// pruned receipts below 8 and senders outside the last 2 blocks are not required
+	for _, p := range [][2][]byte{
+		{kv.PruneReceipts, kv.EncodeBlockNum(8)},
+		{kv.PruneReceiptsType, kv.PruneTypeBefore},
+		{kv.PruneSenders, kv.EncodeBlockNum(2)},
+	} {
+		if err := tx.Put(kv.DatabaseInfo, p[0], p[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, err = VerifyBlocks(ctx, tx, 0, 9, nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.Violations[NoReceipts] != nil || !reflect.DeepEqual(r.Violations[BadSenders], []uint64{9}) {
+		t.Fatalf("pruned: %v", r.Violations)
+	}
+	for _, k := range [][]byte{kv.PruneReceipts, kv.PruneReceiptsType, kv.PruneSenders} {
+		if err := tx.Delete(kv.DatabaseInfo, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recovery, err := rawdb.NewSenderRecovery(params.AllEthashProtocolChanges, 2, 16, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = VerifyBlocks(ctx, tx, 0, 100, &BlockFix{Tx: tx, Recovery: recovery}); err != nil {
+		t.Fatal(err)
+	}
+	wantFixed := map[string][]uint64{NoTxLookup: {5, 8}, BadSenders: {6, 9}}
+	if !reflect.DeepEqual(r.Violations, want) || !reflect.DeepEqual(r.Fixed, wantFixed) || !r.Unfixed() {
+		t.Fatalf("fix: violations %v, fixed %v", r.Violations, r.Fixed)
+	}
+	for _, n := range []uint64{6, 9} {
+		if senders, err := rawdb.ReadSenders(tx, blocks[n].hash, n); err != nil || !reflect.DeepEqual(senders, blocks[n].senders) {
+			t.Fatalf("block %d: recovered senders %v, %v", n, senders, err)
+		}
+	}
+
+	// expensive violations stay
+	if r, err = VerifyBlocks(ctx, tx, 0, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, NoTxLookup)
+	delete(want, BadSenders)
+	if !reflect.DeepEqual(r.Violations, want) {
+		t.Fatalf("after fix: %v, want %v", r.Violations, want)
+	}
+	if want := kv.Dependents(kv.RPCOwner, kv.Headers, kv.BlockBody, kv.EthTx, kv.Receipts); !reflect.DeepEqual(r.Affected, want) {
+		t.Fatalf("after fix: affected %v, want %v", r.Affected, want)
+	}
+}
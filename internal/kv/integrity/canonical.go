@@ -0,0 +1,90 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// Canonical - every HeaderCanonical entry in range has record in Headers which decodes
+var Canonical = Check{
+	Name:   "canonical",
+	Repair: "amc db repair-canonical",
+	Verify: verifyCanonical,
+}
+
+func verifyCanonical(tx Reader, from, to uint64) error {
+	c, err := tx.Cursor(kv.Headers)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	end := to + 1
+	if end == 0 { // to == MaxUint64
+		end = to
+	}
+	it := rawdb.NewHeaderIterator(c, from, end)
+	defer it.Close()
+
+	// headers come ordered by block number, next - first block which canonical header is not met yet
+	next, done := from, false
+	var canonical []byte
+	for !done && it.Next() {
+		n := it.BlockNum()
+		if n < next {
+			continue
+		}
+		if n > next {
+			break
+		}
+		if canonical == nil {
+			if canonical, err = canonicalHash(tx, n); err != nil {
+				return err
+			}
+		}
+		if hash := it.Hash(); bytes.Equal(hash[:], canonical) {
+			canonical, done = nil, n == to
+			next++
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if done || next > to {
+		return nil
+	}
+	hash, err := canonicalHash(tx, next)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("canonical header %x of block %d not found", hash, next)
+}
+
+func canonicalHash(tx kv.Getter, n uint64) ([]byte, error) {
+	hash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != kv.HashLen {
+		return nil, fmt.Errorf("no canonical hash for block %d", n)
+	}
+	return hash, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// Checks behind the Invariants besides Canonical
+var (
+	// SchemaVersion - stored schema version is readable by this binary without migrations
+	SchemaVersion = Check{
+		Name:   "schema-version",
+		Repair: "amc --db.migrations.approve",
+		Whole:  true,
+		Verify: verifySchemaVersion,
+	}
+	// HeadPointers - head block and head header point at existing canonical headers
+	HeadPointers = Check{
+		Name:   "head-pointers",
+		Repair: "amc db repair-canonical",
+		Whole:  true,
+		Verify: verifyHeadPointers,
+	}
+	// CumulativeIndexes - totals of every indexed block grow by the gas and transactions of the block
+	CumulativeIndexes = Check{
+		Name:   "cumulative-indexes",
+		Repair: "amc db repair-cumulative-indexes",
+		Verify: verifyCumulativeIndexes,
+	}
+	// Blocks - canonical blocks have header, body, transactions, senders and receipts, see VerifyBlocks
+	Blocks = Check{
+		Name:   "blocks",
+		Repair: "amc db check-blocks --check.fix",
+		Verify: verifyBlocks(false),
+	}
+	// TxLookup - transactions of canonical blocks have lookup entries pointing at their block
+	TxLookup = Check{
+		Name:   "tx-lookup",
+		Repair: "amc db rebuild-txlookup",
+		Verify: verifyBlocks(true),
+	}
+	// Codecs - every record of the tables with a registered codec decodes, see kv.VerifyCodecs
+	Codecs = Check{
+		Name:   "codecs",
+		Repair: "amc db verify-codecs",
+		Whole:  true,
+		Verify: verifyCodecs,
+	}
+)
+
+func verifySchemaVersion(tx Reader, _, _ uint64) error {
+	current, expected, compatible, err := kv.CheckSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if !compatible {
+		return fmt.Errorf("%w: db has %s, binary writes %s", kv.ErrSchemaIncompatible, current, expected)
+	}
+	return nil
+}
+
+func verifyHeadPointers(tx Reader, _, _ uint64) error {
+	return firstOf(kv.VerifyHeadConsistency(tx))
+}
+
+func verifyCodecs(tx Reader, _, _ uint64) error {
+	return firstOf(kv.VerifyCodecs(tx, 0))
+}
+
+// firstOf - first of errs with the number of the others, nil if errs is empty
+func firstOf(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %d more)", errs[0], len(errs)-1)
+}
+
+// verifyBlocks - VerifyBlocks reporting only NoTxLookup violations if lookup, all others otherwise
+func verifyBlocks(lookup bool) func(tx Reader, from, to uint64) error {
+	return func(tx Reader, from, to uint64) error {
+		r, err := VerifyBlocks(context.Background(), tx, from, to, nil)
+		if err != nil {
+			return err
+		}
+		for _, typ := range r.Types() {
+			if (typ == NoTxLookup) == lookup {
+				return fmt.Errorf("%s in blocks %v", typ, r.Violations[typ])
+			}
+		}
+		return nil
+	}
+}
+
+// verifyCumulativeIndexes - blocks without totals are skipped: AppendCumulativeIndexes leaves them out
+// when the parent has none, so only the totals of blocks following an indexed parent are compared
+func verifyCumulativeIndexes(tx Reader, from, to uint64) error {
+	var prevGas, prevTxs uint64
+	prevOk := false
+	if from > 0 {
+		var err error
+		if prevGas, prevTxs, prevOk, err = rawdb.ReadCumulativeIndexes(tx, from-1); err != nil {
+			return err
+		}
+	}
+	for n := from; n <= to; n++ {
+		hash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+		if err != nil {
+			return err
+		}
+		if len(hash) != kv.HashLen {
+			return nil
+		}
+		gas, txs, ok, err := rawdb.ReadCumulativeIndexes(tx, n)
+		if err != nil {
+			return err
+		}
+		if ok && prevOk {
+			header := rawdb.ReadHeader(tx, types.BytesToHash(hash), n)
+			if header == nil {
+				return fmt.Errorf("header of block %d %x is missing", n, hash)
+			}
+			body, err := tx.GetOne(kv.BlockBody, kv.HeaderKey(n, hash))
+			if err != nil {
+				return err
+			}
+			if len(body) != 8+4 {
+				return fmt.Errorf("body of block %d %x is missing", n, hash)
+			}
+			// the first and the last id of the range are reserved for system transactions
+			var count uint64
+			if amount := binary.BigEndian.Uint32(body[8:]); amount > 2 {
+				count = uint64(amount - 2)
+			}
+			if gas < prevGas || gas-prevGas != header.GasUsed || txs < prevTxs || txs-prevTxs != count {
+				return fmt.Errorf("block %d totals gas %d txs %d, parent gas %d txs %d, block gas %d txs %d",
+					n, gas, txs, prevGas, prevTxs, header.GasUsed, count)
+			}
+		}
+		prevGas, prevTxs, prevOk = gas, txs, ok
+		if n == to { // to == MaxUint64
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+func TestWholeChecks(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeChain(t, tx, 0, 5)
+
+	// blocks without a schema version are a legacy db needing migrations
+	if err := SchemaVersion.Verify(tx, 0, 5); err == nil {
+		t.Fatal("legacy db passed schema-version")
+	}
+	if err := tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, kv.CurrentSchemaVersion.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := SchemaVersion.Verify(tx, 0, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []Check{SchemaVersion, HeadPointers}
+	if err := tx.Put(kv.HeadBlockKey, []byte(kv.HeadBlockKey), make([]byte, kv.HashLen)); err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 2; run++ {
+		violations, err := RunStartup(tx, checks, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(violations) != 1 || violations[0].Check != HeadPointers.Name {
+			t.Fatalf("run %d: violations %v", run, violations)
+		}
+	}
+	if frozen, _ := Frozen(tx, HeadPointers.Name); frozen != "" {
+		t.Fatalf("whole check frozen: %s", frozen)
+	}
+	if _, ok, _ := TipWatermark(tx, HeadPointers.Name); ok {
+		t.Fatal("whole check keeps a watermark")
+	}
+	if done, violations, err := AdvanceHistory(tx, checks, 10); err != nil || !done || len(violations) != 0 {
+		t.Fatalf("history of whole checks: %t %v %v", done, violations, err)
+	}
+}
+
+func TestCumulativeIndexesCheck(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeChain(t, tx, 0, 5)
+	for n := uint64(0); n <= 5; n++ {
+		hash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 1 transaction between the 2 reserved ids
+		if err := tx.Put(kv.BlockBody, kv.HeaderKey(n, hash), append(kv.EncodeBlockNum(n*10), 0, 0, 0, 3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// block 0 has no totals, so the totals of block 1 are not compared
+	for n := uint64(1); n <= 5; n++ {
+		if err := rawdb.WriteCumulativeIndexes(tx, n, 0, 7+n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CumulativeIndexes.Verify(tx, 0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := rawdb.WriteCumulativeIndexes(tx, 3, 0, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := CumulativeIndexes.Verify(tx, 4, 5); err == nil {
+		t.Fatal("wrong totals of block 4 parent passed")
+	}
+	if err := CumulativeIndexes.Verify(tx, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlocksAndTxLookupChecks(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	blocks := putBlocks(t, tx, 4)
+	for _, c := range []Check{Blocks, TxLookup} {
+		if err := c.Verify(tx, 0, 3); err != nil {
+			t.Fatalf("%s: %v", c.Name, err)
+		}
+	}
+	if err := rawdb.DeleteTxLookupEntry(tx, blocks[2].txs[0].Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Blocks.Verify(tx, 0, 3); err != nil {
+		t.Fatalf("blocks failed on a missing lookup entry: %v", err)
+	}
+	if err := TxLookup.Verify(tx, 0, 3); err == nil {
+		t.Fatal("missing lookup entry passed tx-lookup")
+	}
+	if err := tx.Delete(kv.Receipts, kv.EncodeBlockNum(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Blocks.Verify(tx, 0, 3); err == nil {
+		t.Fatal("missing receipts passed blocks")
+	}
+}
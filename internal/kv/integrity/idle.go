@@ -0,0 +1,89 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"context"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/log"
+)
+
+// Update - runs f in a read-write transaction committed when f succeeds, as RwDB.Update does
+type Update func(ctx context.Context, f func(tx Tx) error) error
+
+// UpdateOf - Update of an internal/kv database
+func UpdateOf(db kv.RwDB) Update {
+	return func(ctx context.Context, f func(tx Tx) error) error {
+		return db.Update(ctx, func(tx kv.RwTx) error { return f(tx) })
+	}
+}
+
+// IdleVerifier - background task moving history watermarks forward by small steps,
+// only when `idle` reports that node has nothing better to do
+type IdleVerifier struct {
+	update Update
+	checks []Check
+	step   uint64
+	every  time.Duration
+	idle   func() bool
+}
+
+func NewIdleVerifier(update Update, checks []Check, step uint64, every time.Duration, idle func() bool) *IdleVerifier {
+	return &IdleVerifier{update: update, checks: checks, step: step, every: every, idle: idle}
+}
+
+// Step - verifies 1 step of history if node is idle. Returns true when all history is verified.
+func (v *IdleVerifier) Step(ctx context.Context) (bool, error) {
+	if !v.idle() {
+		return false, nil
+	}
+	var done bool
+	if err := v.update(ctx, func(tx Tx) error {
+		var violations []*Violation
+		var err error
+		done, violations, err = AdvanceHistory(tx, v.checks, v.step)
+		for _, violation := range violations {
+			log.Warn("[integrity] history verification failed", "err", violation)
+		}
+		return err
+	}); err != nil {
+		return false, err
+	}
+	return done, nil
+}
+
+// Run - calls Step periodically until all history is verified or ctx is done
+func (v *IdleVerifier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(v.every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		done, err := v.Step(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
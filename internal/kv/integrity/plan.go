@@ -0,0 +1,103 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// Invariant - integrity check a startup plan can pick, with the cost of running it over whole tables
+// Critical - node can't start safely without it, planned whatever the budget
+// Fixed - cost independent of the data, PerEntry - cost of every entry of Tables
+type Invariant struct {
+	Check    Check
+	Tables   []string
+	Critical bool
+	Fixed    time.Duration
+	PerEntry time.Duration
+}
+
+// Cost - estimated run time of the check for tables having counts entries, missing tables count as empty
+func (i Invariant) Cost(counts map[string]uint64) time.Duration {
+	cost := i.Fixed
+	for _, t := range i.Tables {
+		cost += time.Duration(counts[t]) * i.PerEntry
+	}
+	return cost
+}
+
+// Invariants - registry of checks IntegrityPlan chooses from, costs are rough estimates for SSD
+var Invariants = []Invariant{
+	{Check: SchemaVersion, Tables: []string{kv.DatabaseInfo}, Critical: true, Fixed: time.Millisecond},
+	{Check: HeadPointers, Tables: []string{kv.HeadBlockKey, kv.HeadHeaderKey}, Critical: true, Fixed: time.Millisecond},
+	{Check: Canonical, Tables: []string{kv.HeaderCanonical, kv.Headers}, PerEntry: 2 * time.Microsecond},
+	{Check: CumulativeIndexes, Tables: []string{kv.CumulativeGasIndex, kv.CumulativeTransactionIndex}, PerEntry: time.Microsecond},
+	{Check: Blocks, Tables: []string{kv.BlockBody, kv.EthTx, kv.Senders}, PerEntry: 10 * time.Microsecond},
+	{Check: TxLookup, Tables: []string{kv.TxLookup, kv.EthTx}, PerEntry: 15 * time.Microsecond},
+	{Check: Codecs, Tables: kv.CodecTables(), PerEntry: 20 * time.Microsecond},
+}
+
+// InvariantOf - invariant of the check named name, ok=false if no Invariants entry runs it
+func InvariantOf(name string) (Invariant, bool) {
+	for _, i := range Invariants {
+		if i.Check.Name == name {
+			return i, true
+		}
+	}
+	return Invariant{}, false
+}
+
+// IntegrityPlan - names of the Invariants to run within budget, counts are entries per table (see kv.Stat).
+// Critical checks are always planned and run first, their cost is taken from the budget even if it exceeds it.
+// Other checks are added from the cheapest while they fit, so slow storage still boots with a partial check.
+func IntegrityPlan(budget time.Duration, counts map[string]uint64) []string {
+	planned := PlanChecks(budget, counts)
+	plan := make([]string, len(planned))
+	for i, c := range planned {
+		plan[i] = c.Name
+	}
+	return plan
+}
+
+// PlanChecks - checks of the Invariants IntegrityPlan picks, in its order, ready for RunStartup
+func PlanChecks(budget time.Duration, counts map[string]uint64) []Check {
+	var plan []Check
+	optional := make([]Invariant, 0, len(Invariants))
+	for _, i := range Invariants {
+		if i.Critical {
+			plan = append(plan, i.Check)
+			budget -= i.Cost(counts)
+		} else {
+			optional = append(optional, i)
+		}
+	}
+	sort.SliceStable(optional, func(a, b int) bool {
+		return optional[a].Cost(counts) < optional[b].Cost(counts)
+	})
+	for _, i := range optional {
+		cost := i.Cost(counts)
+		if cost > budget {
+			break
+		}
+		plan = append(plan, i.Check)
+		budget -= cost
+	}
+	return plan
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// mainnetCounts - entry counts of a node some millions of blocks in
+var mainnetCounts = map[string]uint64{
+	kv.DatabaseInfo:               10,
+	kv.HeadBlockKey:               1,
+	kv.HeadHeaderKey:              1,
+	kv.HeaderCanonical:            5_000_000,
+	kv.Headers:                    5_100_000,
+	kv.HeaderTD:                   5_100_000,
+	kv.CumulativeGasIndex:         5_000_000,
+	kv.CumulativeTransactionIndex: 5_000_000,
+	kv.BlockBody:                  5_100_000,
+	kv.EthTx:                      200_000_000,
+	kv.Senders:                    5_000_000,
+	kv.TxLookup:                   200_000_000,
+	kv.Receipts:                   5_000_000,
+}
+
+func TestIntegrityPlan(t *testing.T) {
+	critical := []string{"schema-version", "head-pointers"}
+	for _, tt := range []struct {
+		name   string
+		budget time.Duration
+		counts map[string]uint64
+		want   []string
+	}{
+		{"no budget", 0, mainnetCounts, critical},
+		{"tight budget", 31 * time.Second, mainnetCounts, append(critical, "cumulative-indexes", "canonical")},
+		{"empty db", time.Second, map[string]uint64{}, append(critical, "canonical", "cumulative-indexes", "blocks", "tx-lookup", "codecs")},
+		{"unlimited", 24 * time.Hour, mainnetCounts, append(critical, "cumulative-indexes", "canonical", "codecs", "blocks", "tx-lookup")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := IntegrityPlan(tt.budget, tt.counts)
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Fatalf("plan: have %v, want %v", plan, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrityPlanBudget(t *testing.T) {
+	costs := make(map[string]time.Duration)
+	for _, i := range Invariants {
+		costs[i.Check.Name] = i.Cost(mainnetCounts)
+	}
+	for _, budget := range []time.Duration{time.Millisecond, time.Second, time.Minute, time.Hour} {
+		plan := IntegrityPlan(budget, mainnetCounts)
+		var total, critical time.Duration
+		for i, name := range plan {
+			total += costs[name]
+			if i < 2 {
+				critical += costs[name]
+			}
+		}
+		// only critical checks may take the plan over budget
+		if total > budget && total != critical {
+			t.Fatalf("budget %v: plan %v takes %v", budget, plan, total)
+		}
+	}
+}
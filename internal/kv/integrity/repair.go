@@ -0,0 +1,165 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// RepairTx - what RepairCanonical needs of a tx, RwTx of internal/kv and of erigon-lib both satisfy it
+type RepairTx interface {
+	kv.Getter
+	kv.Putter
+	kv.Deleter
+}
+
+// CanonicalRepair - result of RepairCanonical
+type CanonicalRepair struct {
+	From      uint64   `json:"from"`
+	To        uint64   `json:"to"`        // highest block with a header
+	Rewritten uint64   `json:"rewritten"` // HeaderCanonical entries written
+	Truncated uint64   `json:"truncated"` // HeaderCanonical entries above To deleted
+	Numbers   uint64   `json:"numbers"`   // HeaderNumber entries written or deleted
+	Missing   []uint64 `json:"missing"`   // blocks without header with total difficulty, their HeaderCanonical entries are left as they are
+}
+
+// RepairCanonical - rebuilds HeaderCanonical of blocks from `from` to the highest header: at every height the header
+// with the highest HeaderTD becomes canonical (on equal TD the current canonical one stays). HeaderNumber gets an
+// entry for every header and loses entries of those hashes which have no header.
+// Heights without any header having TD are not fixed, they are reported in Missing.
+func RepairCanonical(tx RepairTx, from uint64) (*CanonicalRepair, error) {
+	res := &CanonicalRepair{From: from, Missing: []uint64{}}
+	var (
+		height = from
+		best   []byte
+		bestTD *big.Int
+		seen   bool
+	)
+	// Headers come ordered by block number, every height is settled when the walk leaves it
+	settle := func() error {
+		if best == nil {
+			res.Missing = append(res.Missing, height)
+			return nil
+		}
+		return res.setCanonical(tx, height, best)
+	}
+	if err := tx.ForEach(kv.Headers, kv.EncodeBlockNum(from), func(k, v []byte) error {
+		if len(k) != kv.BlockNumLen+kv.HashLen {
+			return fmt.Errorf("invalid %s key %x", kv.Headers, k)
+		}
+		n, hash := binary.BigEndian.Uint64(k), k[kv.BlockNumLen:]
+		for ; height < n; height++ {
+			if err := settle(); err != nil {
+				return err
+			}
+			best, bestTD = nil, nil
+		}
+		seen = true
+		if err := res.setNumber(tx, hash, n); err != nil {
+			return err
+		}
+
+		tdBytes, err := tx.GetOne(kv.HeaderTD, k)
+		if err != nil || len(tdBytes) == 0 {
+			return err
+		}
+		td := new(big.Int).SetBytes(tdBytes)
+		if bestTD == nil || td.Cmp(bestTD) > 0 {
+			best, bestTD = append([]byte{}, hash...), td
+		} else if td.Cmp(bestTD) == 0 {
+			canonical, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(canonical, hash) {
+				best = append([]byte{}, hash...)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if !seen {
+		return nil, fmt.Errorf("no headers from block %d", from)
+	}
+	if err := settle(); err != nil {
+		return nil, err
+	}
+	res.To = height
+
+	// HeaderCanonical must not reach past the highest header
+	var above [][]byte
+	if err := tx.ForEach(kv.HeaderCanonical, kv.EncodeBlockNum(res.To+1), func(k, _ []byte) error {
+		above = append(above, append([]byte{}, k...))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, k := range above {
+		if err := tx.Delete(kv.HeaderCanonical, k); err != nil {
+			return nil, err
+		}
+		res.Truncated++
+	}
+
+	// HeaderNumber entries of orphaned hashes, collected first: the walk must not delete from its own table
+	var orphans [][]byte
+	if err := tx.ForEach(kv.HeaderNumber, nil, func(hash, num []byte) error {
+		if len(num) != kv.BlockNumLen || binary.BigEndian.Uint64(num) < from {
+			return nil
+		}
+		ok, err := tx.Has(kv.Headers, append(append(make([]byte, 0, kv.BlockNumLen+kv.HashLen), num...), hash...))
+		if err == nil && !ok {
+			orphans = append(orphans, append([]byte{}, hash...))
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	for _, hash := range orphans {
+		if err := tx.Delete(kv.HeaderNumber, hash); err != nil {
+			return nil, err
+		}
+		res.Numbers++
+	}
+	return res, nil
+}
+
+func (r *CanonicalRepair) setCanonical(tx RepairTx, n uint64, hash []byte) error {
+	k := kv.EncodeBlockNum(n)
+	canonical, err := tx.GetOne(kv.HeaderCanonical, k)
+	if err != nil || bytes.Equal(canonical, hash) {
+		return err
+	}
+	r.Rewritten++
+	return tx.Put(kv.HeaderCanonical, k, hash)
+}
+
+func (r *CanonicalRepair) setNumber(tx RepairTx, hash []byte, n uint64) error {
+	num := kv.EncodeBlockNum(n)
+	v, err := tx.GetOne(kv.HeaderNumber, hash)
+	if err != nil || bytes.Equal(v, num) {
+		return err
+	}
+	r.Numbers++
+	return tx.Put(kv.HeaderNumber, hash, num)
+}
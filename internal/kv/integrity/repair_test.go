@@ -0,0 +1,118 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+func putHeader(t *testing.T, tx kv.RwTx, n uint64, fork byte, td int64) []byte {
+	t.Helper()
+	hash := bytes.Repeat([]byte{fork}, kv.HashLen)
+	copy(hash, kv.EncodeBlockNum(n))
+	k := append(kv.EncodeBlockNum(n), hash...)
+	if err := tx.Put(kv.Headers, k, []byte{0xc0}); err != nil {
+		t.Fatal(err)
+	}
+	if td > 0 {
+		if err := tx.Put(kv.HeaderTD, k, big.NewInt(td).Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hash
+}
+
+func TestRepairCanonical(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	// fork 0xaa is canonical up to 10, fork 0xbb branches off at 6 and is heavier from 8.
+	// Block 12 has no header, block 13 has no TD.
+	want := map[uint64][]byte{}
+	for n := uint64(0); n <= 15; n++ {
+		switch n {
+		case 12:
+			continue
+		case 13:
+			putHeader(t, tx, n, 0xbb, 0)
+			continue
+		}
+		want[n] = putHeader(t, tx, n, 0xaa, int64(n+1)*2)
+		if n >= 6 {
+			if hash := putHeader(t, tx, n, 0xbb, int64(n+1)*2+int64(n)-7); n >= 8 {
+				want[n] = hash
+			}
+		}
+	}
+	for n := uint64(0); n <= 20; n++ {
+		// crash mid-reorg: canonical entries above 10 point at headers never written
+		hash := bytes.Repeat([]byte{0xaa}, kv.HashLen)
+		copy(hash, kv.EncodeBlockNum(n))
+		if n > 10 {
+			hash[kv.HashLen-1] = 0xee
+			if err := tx.Put(kv.HeaderNumber, hash, kv.EncodeBlockNum(n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(n), hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := RepairCanonical(tx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8, 9, 10, 11, 14, 15 rewritten, 16..20 truncated, 21 headers from block 2 numbered and 11..20 orphans dropped
+	if res.To != 15 || res.Rewritten != 6 || res.Truncated != 5 || res.Numbers != 21+10 || !reflect.DeepEqual(res.Missing, []uint64{12, 13}) {
+		t.Fatalf("unexpected repair %+v", res)
+	}
+	for n := uint64(0); n <= 20; n++ {
+		have, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case n == 12 || n == 13:
+			if have[kv.HashLen-1] != 0xee {
+				t.Fatalf("block %d: missing header fixed to %x", n, have)
+			}
+		case !bytes.Equal(have, want[n]):
+			t.Fatalf("block %d: canonical %x, want %x", n, have, want[n])
+		}
+	}
+	if err := tx.ForEach(kv.HeaderNumber, nil, func(hash, num []byte) error {
+		if ok, err := tx.Has(kv.Headers, append(append([]byte{}, num...), hash...)); err != nil || !ok {
+			t.Fatalf("HeaderNumber of orphaned hash %x kept", hash)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// repaired chain needs no more repair, only headers 0 and 1 below the first run get numbered
+	if res, err = RepairCanonical(tx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if res.Rewritten != 0 || res.Truncated != 0 || res.Numbers != 2 || len(res.Missing) != 2 {
+		t.Fatalf("unexpected second repair %+v", res)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// Reader - what checks read, Tx of internal/kv satisfies it
+type Reader interface {
+	BlockReader
+	Cursor(table string) (kv.Cursor, error)
+}
+
+// Tx - what checks read and keep their watermarks in. RwTx of internal/kv satisfies it, RwTx of
+// erigon-lib only through a wrapper of Cursor: its cursors satisfy kv.Cursor, the method signature doesn't
+type Tx interface {
+	Reader
+	kv.Putter
+	kv.Deleter
+}
+
+// Check - verifies invariants of data written for blocks in range [from, to]
+// Repair - name of the tool which fixes violations found by this check, shown to the operator
+// Whole - Verify ignores the range and checks the whole database, at every startup and without watermarks
+type Check struct {
+	Name   string
+	Repair string
+	Whole  bool
+	Verify func(tx Reader, from, to uint64) error
+}
+
+// Violation - invariant broken by some block in range [From, To]
+type Violation struct {
+	Check  string
+	From   uint64
+	To     uint64
+	Repair string
+	Err    error
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("integrity check %s failed in blocks %d-%d: %v, try: %s", v.Check, v.From, v.To, v.Err, v.Repair)
+}
+
+func (v *Violation) Unwrap() error { return v.Err }
+
+/*
+Every check keeps 2 watermarks in DatabaseInfo:
+  - tip watermark - last block verified at startup. Startup verifies only (tip, head]. The first
+    startup seeds it at head without verifying, blocks below are left to the background pass.
+  - history watermark - all blocks in [0, history] verified by background pass. It moves slowly
+    towards tip watermark during idle periods and never overtakes it.
+
+The canonical hash of the tip watermark block is kept next to it. When a reorg replaced that block,
+both watermarks are moved back to the common ancestor of the old and the new branch, so the blocks
+of the new branch are verified again.
+
+Violation freezes both watermarks of the check: they are not advanced until the marker is cleared,
+so every next startup verifies the damaged range again and reports it.
+*/
+var (
+	tipWatermarkPrefix     = []byte("integrityTip.")
+	tipHashPrefix          = []byte("integrityTipHash.")
+	historyWatermarkPrefix = []byte("integrityHistory.")
+	frozenPrefix           = []byte("integrityFrozen.")
+)
+
+func infoKey(prefix []byte, check string) []byte {
+	return append(append([]byte{}, prefix...), check...)
+}
+
+func readUint64(tx kv.Getter, key []byte) (uint64, bool, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, key)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(v), true, nil
+}
+
+func writeUint64(tx kv.Putter, key []byte, n uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, n)
+	return tx.Put(kv.DatabaseInfo, key, v)
+}
+
+// TipWatermark - last block verified by startup check, ok=false if check never passed
+func TipWatermark(tx kv.Getter, check string) (block uint64, ok bool, err error) {
+	return readUint64(tx, infoKey(tipWatermarkPrefix, check))
+}
+
+// HistoryWatermark - all blocks up to returned one verified by background pass, ok=false if pass never advanced
+func HistoryWatermark(tx kv.Getter, check string) (block uint64, ok bool, err error) {
+	return readUint64(tx, infoKey(historyWatermarkPrefix, check))
+}
+
+// Frozen - returns description of violation which froze watermarks of check, empty if not frozen
+func Frozen(tx kv.Getter, check string) (string, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, infoKey(frozenPrefix, check))
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// Unfreeze - must be called after repair, next run re-verifies range above watermarks
+func Unfreeze(tx kv.Deleter, check string) error {
+	return tx.Delete(kv.DatabaseInfo, infoKey(frozenPrefix, check))
+}
+
+func freeze(tx Tx, v *Violation) error {
+	log.Error("[integrity] violation found, watermark frozen", "check", v.Check, "from", v.From, "to", v.To, "err", v.Err, "repair", v.Repair)
+	return tx.Put(kv.DatabaseInfo, infoKey(frozenPrefix, v.Check), []byte(v.Error()))
+}
+
+// verify - runs check on [from, to] and moves watermark to `to` on success
+func verify(tx Tx, c Check, advance func(to uint64) error, from, to uint64) (*Violation, error) {
+	if err := c.Verify(tx, from, to); err != nil {
+		v := &Violation{Check: c.Name, From: from, To: to, Repair: c.Repair, Err: err}
+		if err := freeze(tx, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, advance(to)
+}
+
+// writeTip - moves tip watermark of check to n, together with the canonical hash of n
+func writeTip(tx Tx, check string, n uint64) error {
+	hash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(kv.DatabaseInfo, infoKey(tipHashPrefix, check), hash); err != nil {
+		return err
+	}
+	return writeUint64(tx, infoKey(tipWatermarkPrefix, check), n)
+}
+
+// commonAncestor - highest block of the branch ending at (n, hash) which is canonical, walked
+// back through parent hashes. ok=false if a header of the old branch is gone.
+func commonAncestor(tx Reader, n uint64, hash []byte) (uint64, bool, error) {
+	for {
+		canonical, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(n))
+		if err != nil {
+			return 0, false, err
+		}
+		if bytes.Equal(canonical, hash) {
+			return n, true, nil
+		}
+		header := rawdb.ReadHeader(tx, types.BytesToHash(hash), n)
+		if header == nil || n == 0 {
+			return 0, false, nil
+		}
+		n, hash = n-1, header.ParentHash.Bytes()
+	}
+}
+
+// rewind - tip watermark of check, moved back together with history watermark to the common ancestor
+// if a reorg replaced the tip watermark block. Without the ancestor both watermarks are dropped.
+func rewind(tx Tx, check string) (uint64, bool, error) {
+	tip, ok, err := TipWatermark(tx, check)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	hash, err := tx.GetOne(kv.DatabaseInfo, infoKey(tipHashPrefix, check))
+	if err != nil || len(hash) != kv.HashLen {
+		return tip, true, err
+	}
+	ancestor, found, err := commonAncestor(tx, tip, hash)
+	if err != nil || (found && ancestor == tip) {
+		return tip, true, err
+	}
+	history, historyOk, err := HistoryWatermark(tx, check)
+	if err != nil {
+		return 0, false, err
+	}
+	if !found {
+		log.Warn("[integrity] tip watermark block replaced by a reorg, ancestor unknown", "check", check, "tip", tip)
+		if err := tx.Delete(kv.DatabaseInfo, infoKey(historyWatermarkPrefix, check)); err != nil {
+			return 0, false, err
+		}
+		return 0, false, tx.Delete(kv.DatabaseInfo, infoKey(tipWatermarkPrefix, check))
+	}
+	log.Info("[integrity] tip watermark block replaced by a reorg", "check", check, "tip", tip, "ancestor", ancestor)
+	if historyOk && history > ancestor {
+		if err := writeUint64(tx, infoKey(historyWatermarkPrefix, check), ancestor); err != nil {
+			return 0, false, err
+		}
+	}
+	return ancestor, true, writeTip(tx, check, ancestor)
+}
+
+// RunStartup - verifies only blocks written after tip watermark of each check, up to head.
+// The first run only seeds tip watermark at head. Frozen checks re-verify their range but don't advance.
+// Whole checks run every time and are not frozen.
+func RunStartup(tx Tx, checks []Check, head uint64) ([]*Violation, error) {
+	var violations []*Violation
+	for _, c := range checks {
+		if c.Whole {
+			if err := c.Verify(tx, 0, head); err != nil {
+				v := &Violation{Check: c.Name, From: 0, To: head, Repair: c.Repair, Err: err}
+				log.Error("[integrity] violation found", "check", v.Check, "err", v.Err, "repair", v.Repair)
+				violations = append(violations, v)
+			}
+			continue
+		}
+		watermark, ok, err := rewind(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		frozen, err := Frozen(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if frozen == "" {
+				if err := writeTip(tx, c.Name, head); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if watermark >= head {
+			continue
+		}
+		from := watermark + 1
+		if frozen != "" {
+			if err := c.Verify(tx, from, head); err != nil {
+				violations = append(violations, &Violation{Check: c.Name, From: from, To: head, Repair: c.Repair, Err: err})
+			}
+			continue
+		}
+		advance := func(to uint64) error { return writeTip(tx, c.Name, to) }
+		v, err := verify(tx, c, advance, from, head)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			violations = append(violations, v)
+		}
+	}
+	return violations, nil
+}
+
+// AdvanceHistory - verifies next `step` blocks above history watermark of each check.
+// History watermark never overtakes tip watermark. Returns true when all checks reached tip.
+// Whole checks have no history and are skipped.
+func AdvanceHistory(tx Tx, checks []Check, step uint64) (done bool, violations []*Violation, err error) {
+	if step == 0 {
+		return false, nil, errors.New("integrity: history step must be positive")
+	}
+	done = true
+	for _, c := range checks {
+		if c.Whole {
+			continue
+		}
+		frozen, err := Frozen(tx, c.Name)
+		if err != nil {
+			return false, nil, err
+		}
+		if frozen != "" {
+			continue
+		}
+		tip, ok, err := rewind(tx, c.Name)
+		if err != nil {
+			return false, nil, err
+		}
+		if !ok {
+			continue
+		}
+		key := infoKey(historyWatermarkPrefix, c.Name)
+		watermark, ok, err := readUint64(tx, key)
+		if err != nil {
+			return false, nil, err
+		}
+		from := uint64(0)
+		if ok {
+			if watermark >= tip {
+				continue
+			}
+			from = watermark + 1
+		}
+		to := from + step - 1
+		if to >= tip {
+			to = tip
+		} else {
+			done = false
+		}
+		advance := func(to uint64) error { return writeUint64(tx, key, to) }
+		v, err := verify(tx, c, advance, from, to)
+		if err != nil {
+			return false, nil, err
+		}
+		if v != nil {
+			violations = append(violations, v)
+		}
+	}
+	return done, violations, nil
+}
+
+// Status - state of 1 check, for health reporting
+type Status struct {
+	Check            string
+	TipWatermark     uint64
+	HistoryWatermark uint64
+	Frozen           string
+	Repair           string
+}
+
+func ReadStatus(tx kv.Getter, checks []Check) ([]Status, error) {
+	res := make([]Status, 0, len(checks))
+	for _, c := range checks {
+		tip, _, err := TipWatermark(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		history, _, err := HistoryWatermark(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		frozen, err := Frozen(tx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Status{Check: c.Name, TipWatermark: tip, HistoryWatermark: history, Frozen: frozen, Repair: c.Repair})
+	}
+	return res, nil
+}
@@ -0,0 +1,281 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package integrity
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"github.com/holiman/uint256"
+)
+
+// encodedHeader - Headers record of block n, the canonical check decodes it
+func encodedHeader(t *testing.T, n uint64) []byte {
+	t.Helper()
+	header := &block.Header{Number: uint256.NewInt(n), Difficulty: uint256.NewInt(2), BaseFee: uint256.NewInt(1), Time: n}
+	data, err := header.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeChain(t *testing.T, tx kv.RwTx, from, to uint64) {
+	t.Helper()
+	for n := from; n <= to; n++ {
+		hash := make([]byte, 32)
+		binary.BigEndian.PutUint64(hash, n+1)
+		num := make([]byte, 8)
+		binary.BigEndian.PutUint64(num, n)
+		if err := tx.Put(kv.HeaderCanonical, num, hash); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(kv.Headers, append(num, hash...), encodedHeader(t, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeBranch - canonical blocks [from, to] linked by parent hashes to parent, fork tells branches apart
+func writeBranch(t *testing.T, tx kv.RwTx, from, to uint64, parent types.Hash, fork byte) types.Hash {
+	t.Helper()
+	for n := from; n <= to; n++ {
+		var hash types.Hash
+		binary.BigEndian.PutUint64(hash[:], n+1)
+		hash[31] = fork
+		header := &block.Header{ParentHash: parent, Number: uint256.NewInt(n), Difficulty: uint256.NewInt(2), BaseFee: uint256.NewInt(1), Time: n}
+		data, err := header.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(n), hash[:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(kv.Headers, append(kv.EncodeBlockNum(n), hash[:]...), data); err != nil {
+			t.Fatal(err)
+		}
+		parent = hash
+	}
+	return parent
+}
+
+func TestRunStartupSeedsAtHead(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	checks := []Check{Canonical}
+
+	// damage below head is left to the history pass
+	writeChain(t, tx, 0, 20)
+	if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(5), make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := RunStartup(tx, checks, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if w, ok, _ := TipWatermark(tx, Canonical.Name); !ok || w != 20 {
+		t.Fatalf("tip watermark: have %d %t, want 20", w, ok)
+	}
+	if _, ok, _ := HistoryWatermark(tx, Canonical.Name); ok {
+		t.Fatal("history must not be verified at startup")
+	}
+
+	_, violations, err = AdvanceHistory(tx, checks, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].From != 0 || violations[0].To != 20 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+}
+
+func TestRunStartupReorg(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	checks := []Check{Canonical}
+
+	ancestor := writeBranch(t, tx, 0, 10, types.Hash{}, 0)
+	writeBranch(t, tx, 11, 20, ancestor, 0)
+	if _, err := RunStartup(tx, checks, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AdvanceHistory(tx, checks, 100); err != nil {
+		t.Fatal(err)
+	}
+	if w, _, _ := HistoryWatermark(tx, Canonical.Name); w != 20 {
+		t.Fatalf("history watermark: have %d, want 20", w)
+	}
+
+	// a shorter branch from block 10 replaces the tip watermark block, its block 13 is damaged
+	writeBranch(t, tx, 11, 15, ancestor, 1)
+	for n := uint64(16); n <= 20; n++ {
+		if err := tx.Delete(kv.HeaderCanonical, kv.EncodeBlockNum(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(13), make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	violations, err := RunStartup(tx, checks, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].From != 11 || violations[0].To != 15 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if w, _, _ := TipWatermark(tx, Canonical.Name); w != 10 {
+		t.Fatalf("tip watermark: have %d, want 10", w)
+	}
+	if w, _, _ := HistoryWatermark(tx, Canonical.Name); w != 10 {
+		t.Fatalf("history watermark: have %d, want 10", w)
+	}
+}
+
+func TestAdvanceHistoryZeroStep(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeChain(t, tx, 0, 20)
+	if _, err := RunStartup(tx, []Check{Canonical}, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := AdvanceHistory(tx, []Check{Canonical}, 0); err == nil {
+		t.Fatal("zero step must be rejected")
+	}
+}
+
+func TestRunStartupIncremental(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	checks := []Check{Canonical}
+
+	writeChain(t, tx, 0, 20)
+	violations, err := RunStartup(tx, checks, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+	if w, _, _ := TipWatermark(tx, Canonical.Name); w != 20 {
+		t.Fatalf("tip watermark: have %d, want 20", w)
+	}
+
+	// corruption below watermark is not visible to fast-path, above it - must be caught
+	writeChain(t, tx, 21, 30)
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, 25)
+	if err := tx.Put(kv.HeaderCanonical, num, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	violations, err = RunStartup(tx, checks, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(violations))
+	}
+	if violations[0].From != 21 || violations[0].To != 30 || violations[0].Repair == "" {
+		t.Fatalf("unexpected violation: %v", violations[0])
+	}
+	var v *Violation
+	if !errors.As(violations[0], &v) {
+		t.Fatal("violation must be an error")
+	}
+	if w, _, _ := TipWatermark(tx, Canonical.Name); w != 20 {
+		t.Fatalf("watermark must be frozen at 20, have %d", w)
+	}
+	if frozen, _ := Frozen(tx, Canonical.Name); frozen == "" {
+		t.Fatal("check must be frozen")
+	}
+
+	// still reported on next startup
+	violations, err = RunStartup(tx, checks, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 {
+		t.Fatalf("frozen check must report again, got %d violations", len(violations))
+	}
+
+	// repair and unfreeze
+	writeChain(t, tx, 25, 25)
+	if err := Unfreeze(tx, Canonical.Name); err != nil {
+		t.Fatal(err)
+	}
+	violations, err = RunStartup(tx, checks, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations after repair: %v", violations)
+	}
+	if w, _, _ := TipWatermark(tx, Canonical.Name); w != 30 {
+		t.Fatalf("tip watermark: have %d, want 30", w)
+	}
+}
+
+func TestIdleVerifierAdvancesHistory(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	ctx := context.Background()
+	checks := []Check{Canonical}
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		writeChain(t, tx, 0, 99)
+		_, err := RunStartup(tx, checks, 99)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	idle := false
+	verifier := NewIdleVerifier(UpdateOf(db), checks, 10, 0, func() bool { return idle })
+	done, err := verifier.Step(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done {
+		t.Fatal("busy node must not advance history")
+	}
+
+	idle = true
+	steps := 0
+	for !done {
+		if done, err = verifier.Step(ctx); err != nil {
+			t.Fatal(err)
+		}
+		steps++
+	}
+	if steps != 10 {
+		t.Fatalf("expected 10 steps of 10 blocks, got %d", steps)
+	}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		statuses, err := ReadStatus(tx, checks)
+		if err != nil {
+			return err
+		}
+		if statuses[0].HistoryWatermark != 99 || statuses[0].Frozen != "" {
+			t.Fatalf("unexpected status: %+v", statuses[0])
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
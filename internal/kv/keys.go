@@ -0,0 +1,283 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Key components length
+const (
+	BlockNumLen    = 8
+	HashLen        = 32
+	AddrLen        = 20
+	IncarnationLen = 8
+)
+
+// EncodeBlockNum - big-endian block number, the prefix of block-keyed tables
+func EncodeBlockNum(num uint64) []byte {
+	k := make([]byte, BlockNumLen)
+	binary.BigEndian.PutUint64(k, num)
+	return k
+}
+
+// DecodeBlockNum - block number from the first BlockNumLen bytes of k
+func DecodeBlockNum(k []byte) (uint64, error) {
+	if len(k) < BlockNumLen {
+		return 0, fmt.Errorf("key %x is shorter than block number", k)
+	}
+	return binary.BigEndian.Uint64(k), nil
+}
+
+// BlockKeyed - whether keys of table start with the block number, per its schema
+func BlockKeyed(table string) bool {
+	return strings.HasPrefix(tableSchemas[table].key, "block_num_u64")
+}
+
+// BlockKeyedTables - sorted list of ChaindataTables which keys start with the block number, range scans
+// over blocks rely on their ordering
+func BlockKeyedTables() []string {
+	var res []string
+	for _, name := range ChaindataTables {
+		if BlockKeyed(name) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// VerifyBlockOrdering - checks that a cursor over table yields keys in ascending block number order,
+// as range scans expect: a table created with ReverseKey or with keys not starting with the big-endian
+// block number fails. Keys of one block may come in any order.
+func VerifyBlockOrdering(tx Tx, table string) error {
+	if cfg, ok := Lookup(table); ok && cfg.Flags&ReverseKey != 0 {
+		return fmt.Errorf("%s: created with ReverseKey, keys are not in block order", table)
+	}
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var prev uint64
+	var prevKey []byte
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		num, err := DecodeBlockNum(k)
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		if prevKey != nil && num < prev {
+			return fmt.Errorf("%s: key %x of block %d follows key %x of block %d", table, k, num, prevKey, prev)
+		}
+		prev, prevKey = num, append(prevKey[:0], k...)
+	}
+	return nil
+}
+
+// HeaderKey - block_num_u64 + hash, key of Headers, HeaderTD and BlockBody
+func HeaderKey(num uint64, hash []byte) []byte {
+	k := make([]byte, BlockNumLen+HashLen)
+	binary.BigEndian.PutUint64(k, num)
+	copy(k[BlockNumLen:], hash)
+	return k
+}
+
+func ParseHeaderKey(k []byte) (num uint64, hash []byte, err error) {
+	if len(k) != BlockNumLen+HashLen {
+		return 0, nil, fmt.Errorf("header key %x: unexpected length %d", k, len(k))
+	}
+	return binary.BigEndian.Uint64(k), k[BlockNumLen:], nil
+}
+
+// StorageKey - address + incarnation_u64 + storage_key, key of PlainState storage
+func StorageKey(addr []byte, inc uint64, loc []byte) []byte {
+	return storageKey(AddrLen, addr, inc, loc)
+}
+
+func ParseStorageKey(k []byte) (addr []byte, inc uint64, loc []byte, err error) {
+	return parseStorageKey(AddrLen, k)
+}
+
+// PlainStateStoragePrefix - address + incarnation_u64, the DupToLen key PlainState keeps storage of one
+// account incarnation under, a DupSort cursor iterates its slots as the dup values of this key
+func PlainStateStoragePrefix(address []byte, incarnation uint64) []byte {
+	return storageKey(AddrLen, address, incarnation, nil)[:AddrLen+IncarnationLen]
+}
+
+// HashedStorageKey - address_hash + incarnation_u64 + storage_key_hash, key of HashedStorage
+func HashedStorageKey(addrHash []byte, inc uint64, locHash []byte) []byte {
+	return storageKey(HashLen, addrHash, inc, locHash)
+}
+
+func ParseHashedStorageKey(k []byte) (addrHash []byte, inc uint64, locHash []byte, err error) {
+	return parseStorageKey(HashLen, k)
+}
+
+func storageKey(prefixLen int, prefix []byte, inc uint64, loc []byte) []byte {
+	k := make([]byte, prefixLen+IncarnationLen+HashLen)
+	copy(k, prefix)
+	binary.BigEndian.PutUint64(k[prefixLen:], inc)
+	copy(k[prefixLen+IncarnationLen:], loc)
+	return k
+}
+
+func parseStorageKey(prefixLen int, k []byte) ([]byte, uint64, []byte, error) {
+	if len(k) != prefixLen+IncarnationLen+HashLen {
+		return nil, 0, nil, fmt.Errorf("storage key %x: unexpected length %d", k, len(k))
+	}
+	return k[:prefixLen], binary.BigEndian.Uint64(k[prefixLen:]), k[prefixLen+IncarnationLen:], nil
+}
+
+// StorageChangeSetKey - block_num_u64 + address + incarnation_u64, key of StorageChangeSet
+func StorageChangeSetKey(blockNum uint64, addr []byte, inc uint64) []byte {
+	k := make([]byte, BlockNumLen+AddrLen+IncarnationLen)
+	binary.BigEndian.PutUint64(k, blockNum)
+	copy(k[BlockNumLen:], addr)
+	binary.BigEndian.PutUint64(k[BlockNumLen+AddrLen:], inc)
+	return k
+}
+
+// ParseChangeSetKey - splits key of AccountChangeSet (rest is empty) or StorageChangeSet
+// (rest is address + incarnation_u64)
+func ParseChangeSetKey(k []byte) (blockNum uint64, rest []byte, err error) {
+	switch len(k) {
+	case BlockNumLen, BlockNumLen + AddrLen + IncarnationLen:
+		return binary.BigEndian.Uint64(k), k[BlockNumLen:], nil
+	default:
+		return 0, nil, fmt.Errorf("changeset key %x: unexpected length %d", k, len(k))
+	}
+}
+
+// DupSortSplit - db representation of logical k/v: with AutoDupSortKeysConversion keys of DupFromLen
+// are cut to DupToLen and the rest moves to the value. See TableCfgItem.
+func DupSortSplit(cfg TableCfgItem, k, v []byte) ([]byte, []byte) {
+	if !cfg.AutoDupSortKeysConversion || len(k) != cfg.DupFromLen {
+		return k, v
+	}
+	nv := make([]byte, 0, cfg.DupFromLen-cfg.DupToLen+len(v))
+	nv = append(append(nv, k[cfg.DupToLen:]...), v...)
+	return k[:cfg.DupToLen], nv
+}
+
+// DupSortJoin - reverse of DupSortSplit
+func DupSortJoin(cfg TableCfgItem, k, v []byte) ([]byte, []byte, error) {
+	if !cfg.AutoDupSortKeysConversion || len(k) != cfg.DupToLen {
+		return k, v, nil
+	}
+	keyPart := cfg.DupFromLen - cfg.DupToLen
+	if len(v) < keyPart {
+		return nil, nil, fmt.Errorf("value %x is shorter than key part %d", v, keyPart)
+	}
+	nk := make([]byte, 0, cfg.DupFromLen)
+	nk = append(append(nk, k...), v[:keyPart]...)
+	return nk, v[keyPart:], nil
+}
+
+// SortedDupValues - copy of the dup values of one key of table, as stored (see DupSortSplit), in the order
+// the table keeps them: by DupCmp of its config, byte-wise without one. Ties keep their order, so dumps
+// of the same data come out the same.
+func SortedDupValues(table string, values [][]byte) [][]byte {
+	cfg := ChaindataTablesCfg[table]
+	keyPart := 0
+	if cfg.AutoDupSortKeysConversion {
+		keyPart = cfg.DupFromLen - cfg.DupToLen
+	}
+	split := func(v []byte) ([]byte, []byte) {
+		if len(v) < keyPart {
+			return v, nil
+		}
+		return v[:keyPart], v[keyPart:]
+	}
+	cmp := cfg.DupCmp
+	if cmp == nil {
+		cmp = func(k1, k2, v1, v2 []byte) int {
+			if c := bytes.Compare(k1, k2); c != 0 {
+				return c
+			}
+			return bytes.Compare(v1, v2)
+		}
+	}
+
+	sorted := make([][]byte, len(values))
+	copy(sorted, values)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		k1, v1 := split(sorted[i])
+		k2, v2 := split(sorted[j])
+		return cmp(k1, k2, v1, v2) < 0
+	})
+	return sorted
+}
+
+func ReadHeaderRLP(tx Getter, num uint64, hash []byte) ([]byte, error) {
+	return tx.GetOne(Headers, HeaderKey(num, hash))
+}
+
+func WriteHeaderRLP(tx Putter, num uint64, hash []byte, data []byte) error {
+	return tx.Put(Headers, HeaderKey(num, hash), data)
+}
+
+func ReadTDRLP(tx Getter, num uint64, hash []byte) ([]byte, error) {
+	return tx.GetOne(HeaderTD, HeaderKey(num, hash))
+}
+
+func WriteTDRLP(tx Putter, num uint64, hash []byte, data []byte) error {
+	return tx.Put(HeaderTD, HeaderKey(num, hash), data)
+}
+
+func ReadBodyForStorage(tx Getter, num uint64, hash []byte) ([]byte, error) {
+	return tx.GetOne(BlockBody, HeaderKey(num, hash))
+}
+
+func WriteBodyForStorage(tx Putter, num uint64, hash []byte, data []byte) error {
+	return tx.Put(BlockBody, HeaderKey(num, hash), data)
+}
+
+func ReadHashedStorage(tx Getter, addrHash []byte, inc uint64, locHash []byte) ([]byte, error) {
+	return tx.GetOne(HashedStorage, HashedStorageKey(addrHash, inc, locHash))
+}
+
+func WriteHashedStorage(tx Putter, addrHash []byte, inc uint64, locHash []byte, value []byte) error {
+	return tx.Put(HashedStorage, HashedStorageKey(addrHash, inc, locHash), value)
+}
+
+// WriteStorageChange - records value of storage slot before blockNum
+func WriteStorageChange(tx Putter, blockNum uint64, addr []byte, inc uint64, loc []byte, prev []byte) error {
+	v := make([]byte, 0, HashLen+len(prev))
+	v = append(append(v, loc...), prev...)
+	return tx.Put(StorageChangeSet, StorageChangeSetKey(blockNum, addr, inc), v)
+}
+
+// ForStorageChanges - walks storage changes of blockNum in key order
+func ForStorageChanges(tx Getter, blockNum uint64, walker func(addr []byte, inc uint64, loc, prev []byte) error) error {
+	return tx.ForPrefix(StorageChangeSet, EncodeBlockNum(blockNum), func(k, v []byte) error {
+		_, rest, err := ParseChangeSetKey(k)
+		if err != nil {
+			return err
+		}
+		if len(rest) == 0 || len(v) < HashLen {
+			return fmt.Errorf("invalid storage change %x: %x", k, v)
+		}
+		return walker(rest[:AddrLen], binary.BigEndian.Uint64(rest[AddrLen:]), v[:HashLen], v[HashLen:])
+	})
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func fixed(b []byte, n int) []byte {
+	res := make([]byte, n)
+	copy(res, b)
+	return res
+}
+
+func FuzzHeaderKey(f *testing.F) {
+	f.Add(uint64(0), []byte{})
+	f.Add(uint64(1<<63), bytes.Repeat([]byte{0xff}, HashLen))
+	f.Fuzz(func(t *testing.T, num uint64, hash []byte) {
+		hash = fixed(hash, HashLen)
+		k := HeaderKey(num, hash)
+		n, h, err := ParseHeaderKey(k)
+		if err != nil || n != num || !bytes.Equal(h, hash) {
+			t.Fatalf("round trip of %d %x: %d %x %v", num, hash, n, h, err)
+		}
+		if bn, err := DecodeBlockNum(k); err != nil || bn != num || !bytes.Equal(EncodeBlockNum(num), k[:BlockNumLen]) {
+			t.Fatalf("block number prefix of %x: %d %v", k, bn, err)
+		}
+		if _, _, err := ParseHeaderKey(k[1:]); err == nil {
+			t.Fatal("short header key accepted")
+		}
+	})
+}
+
+func FuzzStorageKeys(f *testing.F) {
+	f.Add([]byte{1}, uint64(1), []byte{2}, []byte{3})
+	f.Add(bytes.Repeat([]byte{0xff}, HashLen), uint64(0), bytes.Repeat([]byte{0xee}, HashLen), []byte{})
+	f.Fuzz(func(t *testing.T, prefix []byte, inc uint64, loc []byte, value []byte) {
+		addr, addrHash, loc := fixed(prefix, AddrLen), fixed(prefix, HashLen), fixed(loc, HashLen)
+		for _, c := range []struct {
+			table  string
+			prefix []byte
+			key    []byte
+			parse  func([]byte) ([]byte, uint64, []byte, error)
+		}{
+			{PlainState, addr, StorageKey(addr, inc, loc), ParseStorageKey},
+			{HashedStorage, addrHash, HashedStorageKey(addrHash, inc, loc), ParseHashedStorageKey},
+		} {
+			p, i, l, err := c.parse(c.key)
+			if err != nil || i != inc || !bytes.Equal(p, c.prefix) || !bytes.Equal(l, loc) {
+				t.Fatalf("%s round trip of %x: %x %d %x %v", c.table, c.key, p, i, l, err)
+			}
+
+			// auto-conversion 60/28 for PlainState, 72/40 for HashedStorage
+			cfg, _ := Lookup(c.table)
+			if len(c.key) != cfg.DupFromLen {
+				t.Fatalf("%s key length %d, DupFromLen %d", c.table, len(c.key), cfg.DupFromLen)
+			}
+			dk, dv := DupSortSplit(cfg, c.key, value)
+			if len(dk) != cfg.DupToLen {
+				t.Fatalf("%s split key length %d, want %d", c.table, len(dk), cfg.DupToLen)
+			}
+			k, v, err := DupSortJoin(cfg, dk, dv)
+			if err != nil || !bytes.Equal(k, c.key) || !bytes.Equal(v, value) {
+				t.Fatalf("%s dupsort round trip of %x/%x: %x/%x %v", c.table, c.key, value, k, v, err)
+			}
+		}
+	})
+}
+
+func TestPlainStateStoragePrefix(t *testing.T) {
+	cfg, _ := Lookup(PlainState)
+	addr := fixed([]byte{0xaa, 0xbb}, AddrLen)
+	prefix := PlainStateStoragePrefix(addr, 3)
+	if len(prefix) != cfg.DupToLen {
+		t.Fatalf("prefix length %d, PlainState DupToLen %d", len(prefix), cfg.DupToLen)
+	}
+	for _, loc := range [][]byte{fixed(nil, HashLen), bytes.Repeat([]byte{0xff}, HashLen)} {
+		dk, _ := DupSortSplit(cfg, StorageKey(addr, 3, loc), []byte{1})
+		if !bytes.Equal(dk, prefix) {
+			t.Fatalf("short key of slot %x: %x, prefix %x", loc, dk, prefix)
+		}
+	}
+	if dk, _ := DupSortSplit(cfg, StorageKey(addr, 4, fixed(nil, HashLen)), []byte{1}); bytes.Equal(dk, prefix) {
+		t.Fatal("prefix matches slots of another incarnation")
+	}
+}
+
+func FuzzChangeSetKey(f *testing.F) {
+	f.Add(uint64(7), []byte{1}, uint64(2))
+	f.Fuzz(func(t *testing.T, num uint64, addr []byte, inc uint64) {
+		addr = fixed(addr, AddrLen)
+		n, rest, err := ParseChangeSetKey(StorageChangeSetKey(num, addr, inc))
+		if err != nil || n != num || !bytes.Equal(rest, StorageKey(addr, inc, nil)[:AddrLen+IncarnationLen]) {
+			t.Fatalf("storage changeset round trip of %d %x %d: %d %x %v", num, addr, inc, n, rest, err)
+		}
+		if n, rest, err = ParseChangeSetKey(EncodeBlockNum(num)); err != nil || n != num || len(rest) != 0 {
+			t.Fatalf("account changeset round trip of %d: %d %x %v", num, n, rest, err)
+		}
+		if _, _, err = ParseChangeSetKey(addr); err == nil {
+			t.Fatal("changeset key of wrong length accepted")
+		}
+	})
+}
+
+func TestTypedAccessors(t *testing.T) {
+	tx := newMockTx()
+	hash := bytes.Repeat([]byte{0xab}, HashLen)
+	for _, c := range []struct {
+		write func(Putter, uint64, []byte, []byte) error
+		read  func(Getter, uint64, []byte) ([]byte, error)
+		table string
+	}{
+		{WriteHeaderRLP, ReadHeaderRLP, Headers},
+		{WriteTDRLP, ReadTDRLP, HeaderTD},
+		{WriteBodyForStorage, ReadBodyForStorage, BlockBody},
+	} {
+		if err := c.write(tx, 5, hash, []byte(c.table)); err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.read(tx, 5, hash)
+		if err != nil || string(v) != c.table {
+			t.Fatalf("%s: read %q %v", c.table, v, err)
+		}
+		if raw, _ := tx.GetOne(c.table, append(EncodeBlockNum(5), hash...)); string(raw) != c.table {
+			t.Fatalf("%s: unexpected key layout", c.table)
+		}
+	}
+
+	if err := WriteHashedStorage(tx, hash, 1, hash, []byte{9}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ReadHashedStorage(tx, hash, 1, hash); err != nil || !bytes.Equal(v, []byte{9}) {
+		t.Fatalf("hashed storage: %x %v", v, err)
+	}
+
+	addr := bytes.Repeat([]byte{0x01}, AddrLen)
+	loc1, loc2 := fixed([]byte{1}, HashLen), fixed([]byte{2}, HashLen)
+	if err := WriteStorageChange(tx, 7, addr, 1, loc2, []byte{0x22}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStorageChange(tx, 7, addr, 1, loc1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStorageChange(tx, 8, addr, 1, loc1, []byte{0x11}); err != nil {
+		t.Fatal(err)
+	}
+	var locs [][]byte
+	if err := ForStorageChanges(tx, 7, func(a []byte, inc uint64, loc, prev []byte) error {
+		if !bytes.Equal(a, addr) || inc != 1 {
+			t.Fatalf("unexpected change of %x/%d", a, inc)
+		}
+		locs = append(locs, loc)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 2 || !bytes.Equal(locs[0], loc1) || !bytes.Equal(locs[1], loc2) {
+		t.Fatalf("unexpected changes of block 7: %x", locs)
+	}
+}
+
+func TestVerifyBlockOrdering(t *testing.T) {
+	keyed := BlockKeyedTables()
+	for _, c := range []struct {
+		table string
+		keyed bool
+	}{{Headers, true}, {Receipts, true}, {AccountChangeSet, true}, {HeaderNumber, false}, {PlainState, false}, {EthTx, false}} {
+		if BlockKeyed(c.table) != c.keyed {
+			t.Fatalf("%s: block keyed %t", c.table, !c.keyed)
+		}
+		found := false
+		for _, name := range keyed {
+			found = found || name == c.table
+		}
+		if found != c.keyed {
+			t.Fatalf("%s: listed by BlockKeyedTables %t", c.table, found)
+		}
+	}
+
+	tx := newMockTx()
+	if err := VerifyBlockOrdering(tx, Headers); err != nil {
+		t.Fatalf("empty table: %v", err)
+	}
+	var keys [][]byte
+	for num := uint64(0); num < 3; num++ {
+		for _, b := range []byte{0xbb, 0xaa} {
+			k := HeaderKey(num, bytes.Repeat([]byte{b}, HashLen))
+			keys = append(keys, k)
+			if err := tx.Put(Headers, k, []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := VerifyBlockOrdering(tx, Headers); err != nil {
+		t.Fatalf("ordered table: %v", err)
+	}
+
+	// cursor of the mock yields pairs as stored: keys of a table created with ReverseKey
+	yield := func(keys ...[]byte) {
+		tx.table(Headers).pairs = nil
+		for _, k := range keys {
+			tx.table(Headers).pairs = append(tx.table(Headers).pairs, mockPair{k: k, v: []byte{1}})
+		}
+	}
+	yield(keys[1], keys[0], keys[3], keys[2]) // keys of one block in any order
+	if err := VerifyBlockOrdering(tx, Headers); err != nil {
+		t.Fatalf("blocks in order: %v", err)
+	}
+	yield(keys[0], keys[2], keys[1], keys[4])
+	if err := VerifyBlockOrdering(tx, Headers); err == nil || !strings.Contains(err.Error(), "of block 0 follows") {
+		t.Fatalf("block 0 after block 1 accepted: %v", err)
+	}
+	yield(keys[0], []byte{1, 2})
+	if err := VerifyBlockOrdering(tx, Headers); err == nil {
+		t.Fatal("key shorter than block number accepted")
+	}
+
+	cfg := ChaindataTablesCfg[Receipts]
+	defer func() { ChaindataTablesCfg[Receipts] = cfg }()
+	reversed := cfg
+	reversed.Flags |= ReverseKey
+	ChaindataTablesCfg[Receipts] = reversed
+	if err := VerifyBlockOrdering(tx, Receipts); err == nil {
+		t.Fatal("table with ReverseKey accepted")
+	}
+}
+
+func TestSortedDupValues(t *testing.T) {
+	// HashedStorage: the storage key hash moved into the value comes first
+	slot := func(key, value byte) []byte { return append(fixed([]byte{key}, 32), value) }
+	values := [][]byte{slot(3, 1), slot(1, 9), slot(2, 0), slot(1, 2), {0x01}}
+	want := [][]byte{{0x01}, slot(1, 2), slot(1, 9), slot(2, 0), slot(3, 1)}
+	in := append([][]byte{}, values...)
+	got := SortedDupValues(HashedStorage, values)
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("HashedStorage value %d: %x, want %x", i, got[i], want[i])
+		}
+		if !bytes.Equal(values[i], in[i]) {
+			t.Fatalf("input changed at %d", i)
+		}
+	}
+	// same data in any order sorts the same
+	again := SortedDupValues(HashedStorage, [][]byte{slot(2, 0), slot(3, 1), {0x01}, slot(1, 9), slot(1, 2)})
+	for i := range want {
+		if !bytes.Equal(again[i], want[i]) {
+			t.Fatalf("second sort differs at %d: %x", i, again[i])
+		}
+	}
+
+	// comparator on the first byte only, descending: ties keep their order
+	const table = "TestDupCmp"
+	ChaindataTablesCfg[table] = TableCfgItem{Flags: DupSort, DupCmp: func(_, _, v1, v2 []byte) int {
+		return int(v2[0]) - int(v1[0])
+	}}
+	defer delete(ChaindataTablesCfg, table)
+	got = SortedDupValues(table, [][]byte{{1, 'a'}, {3, 'b'}, {1, 'c'}, {2, 'd'}, {3, 'e'}})
+	if s := string([]byte{got[0][1], got[1][1], got[2][1], got[3][1], got[4][1]}); s != "bedac" {
+		t.Fatalf("comparator order %q, want %q", s, "bedac")
+	}
+	if got := SortedDupValues(table, nil); len(got) != 0 {
+		t.Fatalf("no values sorted to %x", got)
+	}
+}
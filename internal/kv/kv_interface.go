@@ -26,6 +26,7 @@ const ReadersLimit = 32000 // MDBX_READERS_LIMIT=32767
 var (
 	ErrAttemptToDeleteNonDeprecatedBucket = errors.New("only buckets from dbutils.ChaindataDeprecatedTables can be deleted")
 	ErrUnknownBucket                      = errors.New("unknown bucket. add it to dbutils.ChaindataTables")
+	ErrReadOnlyTable                      = errors.New("table is opened read-only")
 )
 
 type DBVerbosityLvl int8
@@ -115,6 +116,8 @@ type RoDB interface {
 	//	transaction and its cursors may not issue any other operations than
 	//	Commit and Rollback while it has active child transactions.
 	BeginRo(ctx context.Context) (Tx, error)
+	// BeginSnapshot - read view for long scans, which renews its read transaction instead of holding one. See Snapshot.
+	BeginSnapshot(ctx context.Context) (Snapshot, error)
 	AllBuckets() TableCfg
 	PageSize() uint64
 }
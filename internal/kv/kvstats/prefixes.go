@@ -0,0 +1,421 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package kvstats estimates storage properties of kv tables from a sample of their keys.
+package kvstats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// ClusterLen - length of the big-endian number every analyzed key starts with (block number).
+// Clusters, all entries sharing these leading bytes, are the sampling unit.
+const ClusterLen = kv.BlockNumLen
+
+const (
+	// minClusters - sampled clusters below which the sample rate is raised, so confidence bounds stay meaningful.
+	// At least two clusters are needed for a variance, a table of one cluster is always read whole.
+	minClusters = 32
+	// maxClusters - sampled clusters above which the key space is considered too sparse for cluster sampling
+	maxClusters = 1 << 20
+	// nodeHeader - size of MDBX node header, paid per plain entry and per dup entry
+	nodeHeader = 8
+	// z95 - normal quantile of a two-sided 95% confidence interval
+	z95 = 1.959964
+	// sampleSeed - fixed seed, so repeated reports on the same data select the same clusters
+	sampleSeed = 1
+)
+
+var errStopWalk = errors.New("stop walk")
+
+// Reader - the subset of kv.Tx used by the analyzer. Both internal/kv and erigon-lib transactions satisfy it.
+type Reader interface {
+	ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error
+}
+
+// Estimate - estimated table-wide total with its 95% confidence interval
+type Estimate struct {
+	Value float64 `json:"value"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+func (e Estimate) String() string {
+	if e.Low == e.High {
+		return fmt.Sprintf("%.0f", e.Value)
+	}
+	return fmt.Sprintf("%.0f [%.0f, %.0f]", e.Value, e.Low, e.High)
+}
+
+// DupListBucket - estimated number of prefixes whose dup list length is in [Min, Max]
+type DupListBucket struct {
+	Min      uint64  `json:"min"`
+	Max      uint64  `json:"max"`
+	Prefixes float64 `json:"prefixes"`
+}
+
+// PrefixStats - estimated layout of a table when entries are grouped by their first PrefixLen bytes.
+// Entries are read as the concatenation k+v, so a prefix may reach into the value of a DupSort table.
+type PrefixStats struct {
+	Table      string  `json:"table"`
+	PrefixLen  int     `json:"prefixLen"`
+	SampleRate float64 `json:"sampleRate"`
+	Clusters   uint64  `json:"clusters"`
+	Sampled    int     `json:"sampled"`
+
+	Records  Estimate `json:"records"`
+	Prefixes Estimate `json:"prefixes"`
+	// MaxDupList - longest dup list seen in the sample, a lower bound of the table-wide maximum
+	MaxDupList uint64          `json:"maxDupList"`
+	DupLists   []DupListBucket `json:"dupLists"`
+
+	// PlainSize and DupSortSize - bytes of keys, values and node headers in either layout, page overhead excluded
+	PlainSize   Estimate `json:"plainSize"`
+	DupSortSize Estimate `json:"dupSortSize"`
+	Savings     Estimate `json:"savings"`
+}
+
+// MeanDupList - average dup list length, the ratio of estimated records to estimated prefixes
+func (s *PrefixStats) MeanDupList() float64 {
+	if s.Prefixes.Value == 0 {
+		return 0
+	}
+	return s.Records.Value / s.Prefixes.Value
+}
+
+// SavingsRatio - estimated share of the plain layout saved by DupSort storage
+func (s *PrefixStats) SavingsRatio() float64 {
+	if s.PlainSize.Value == 0 {
+		return 0
+	}
+	return s.Savings.Value / s.PlainSize.Value
+}
+
+func (s *PrefixStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "prefix %d bytes: %d of %d clusters sampled\n", s.PrefixLen, s.Sampled, s.Clusters)
+	fmt.Fprintf(&b, "  records          %s\n", s.Records)
+	fmt.Fprintf(&b, "  prefixes         %s\n", s.Prefixes)
+	fmt.Fprintf(&b, "  dup list         mean %.2f, max seen %d\n", s.MeanDupList(), s.MaxDupList)
+	for _, d := range s.DupLists {
+		fmt.Fprintf(&b, "    %6d-%-6d   %.0f\n", d.Min, d.Max, d.Prefixes)
+	}
+	fmt.Fprintf(&b, "  plain size       %s\n", s.PlainSize)
+	fmt.Fprintf(&b, "  dupsort size     %s\n", s.DupSortSize)
+	fmt.Fprintf(&b, "  savings          %s (%.1f%%)\n", s.Savings, s.SavingsRatio()*100)
+	return b.String()
+}
+
+// Comparison - the same sample of a table analyzed for two candidate prefix lengths
+type Comparison struct {
+	Table string       `json:"table"`
+	A     *PrefixStats `json:"a"`
+	B     *PrefixStats `json:"b"`
+}
+
+// Better - candidate with higher estimated savings
+func (c *Comparison) Better() *PrefixStats {
+	if c.B.Savings.Value > c.A.Savings.Value {
+		return c.B
+	}
+	return c.A
+}
+
+func (c *Comparison) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", c.Table)
+	b.WriteString(c.A.String())
+	b.WriteString(c.B.String())
+	better, worse := c.Better(), c.A
+	if better == c.A {
+		worse = c.B
+	}
+	fmt.Fprintf(&b, "prefix %d saves %.0f bytes more than prefix %d", better.PrefixLen, better.Savings.Value-worse.Savings.Value, worse.PrefixLen)
+	// savings of both candidates come from the same clusters, so overlapping intervals do not make the difference insignificant
+	if better.Savings.Low <= worse.Savings.High {
+		b.WriteString(" (confidence intervals overlap)")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Example - table and candidate prefix lengths analyzed by the stats command
+type Example struct {
+	Table    string
+	Prefixes [2]int
+}
+
+// Examples - built-in comparisons: block number alone against the full DupSort key of each table
+var Examples = []Example{
+	// block_num_u64 + address + incarnation_u64 -> slot + value
+	{Table: kv.StorageChangeSet, Prefixes: [2]int{kv.BlockNumLen, kv.BlockNumLen + kv.AddrLen + kv.IncarnationLen}},
+	// block_num_u64 -> address + flags
+	{Table: kv.CallTraceSet, Prefixes: [2]int{kv.BlockNumLen, kv.BlockNumLen + kv.AddrLen}},
+}
+
+// AnalyzePrefixes - estimates distinct prefixes of length prefixLen, dup list lengths and DupSort savings of table.
+// Roughly sampleRate of the block numbers between the first and last key are read, every entry of a sampled block is
+// counted, and per-block totals are extrapolated. sampleRate 1 reads the whole table and returns exact counts.
+func AnalyzePrefixes(tx Reader, table string, prefixLen int, sampleRate float64) (*PrefixStats, error) {
+	stats, err := analyze(tx, table, []int{prefixLen}, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return stats[0], nil
+}
+
+// ComparePrefixes - AnalyzePrefixes for two prefix lengths over one shared sample
+func ComparePrefixes(tx Reader, table string, a, b int, sampleRate float64) (*Comparison, error) {
+	stats, err := analyze(tx, table, []int{a, b}, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return &Comparison{Table: table, A: stats[0], B: stats[1]}, nil
+}
+
+// clusterTotals - per-cluster observations of one prefix length
+type clusterTotals struct {
+	records, prefixes, plain, dupsort, savings []float64
+	dupLists                                   []float64
+	maxDupList                                 uint64
+}
+
+func analyze(tx Reader, table string, prefixLens []int, sampleRate float64) ([]*PrefixStats, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("sample rate %v out of (0, 1]", sampleRate)
+	}
+	for _, l := range prefixLens {
+		if l < ClusterLen {
+			return nil, fmt.Errorf("prefix length %d shorter than block number", l)
+		}
+	}
+
+	first, last, ok, err := clusterRange(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	clusters := uint64(0)
+	if ok {
+		if first == 0 && last == math.MaxUint64 {
+			return nil, fmt.Errorf("%s: key space too sparse for cluster sampling", table)
+		}
+		clusters = last - first + 1
+	}
+	n := uint64(math.Ceil(sampleRate * float64(clusters)))
+	if n < minClusters {
+		n = minClusters
+	}
+	if n > clusters {
+		n = clusters
+	}
+	if n > maxClusters {
+		return nil, fmt.Errorf("%s: %d clusters to sample, key space too sparse or sample rate too high", table, n)
+	}
+
+	totals := make([]*clusterTotals, len(prefixLens))
+	for i := range totals {
+		totals[i] = &clusterTotals{}
+	}
+	rnd := rand.New(rand.NewSource(sampleSeed))
+	for _, id := range sampleClusters(rnd, clusters, n) {
+		if err := readCluster(tx, table, first+id, prefixLens, totals); err != nil {
+			return nil, err
+		}
+	}
+
+	stats := make([]*PrefixStats, len(prefixLens))
+	for i, l := range prefixLens {
+		t := totals[i]
+		s := &PrefixStats{
+			Table:       table,
+			PrefixLen:   l,
+			SampleRate:  sampleRate,
+			Clusters:    clusters,
+			Sampled:     int(n),
+			Records:     estimateTotal(t.records, clusters),
+			Prefixes:    estimateTotal(t.prefixes, clusters),
+			MaxDupList:  t.maxDupList,
+			PlainSize:   estimateTotal(t.plain, clusters),
+			DupSortSize: estimateTotal(t.dupsort, clusters),
+			Savings:     estimateTotal(t.savings, clusters),
+		}
+		scale := 0.0
+		if n > 0 {
+			scale = float64(clusters) / float64(n)
+		}
+		for b, count := range t.dupLists {
+			if count == 0 {
+				continue
+			}
+			lo, hi := uint64(1), uint64(1)
+			if b > 0 {
+				lo, hi = 1<<(b-1)+1, 1<<b
+			}
+			s.DupLists = append(s.DupLists, DupListBucket{Min: lo, Max: hi, Prefixes: count * scale})
+		}
+		stats[i] = s
+	}
+	return stats, nil
+}
+
+// clusterRange - block numbers of the first and last key, ok is false for an empty table
+func clusterRange(tx Reader, table string) (first, last uint64, ok bool, err error) {
+	seek := func(from uint64) (uint64, bool, error) {
+		var (
+			found bool
+			id    uint64
+		)
+		err := tx.ForAmount(table, kv.EncodeBlockNum(from), 1, func(k, _ []byte) error {
+			if len(k) < ClusterLen {
+				return fmt.Errorf("%s: key %x shorter than block number", table, k)
+			}
+			found, id = true, binary.BigEndian.Uint64(k)
+			return nil
+		})
+		return id, found, err
+	}
+
+	if first, ok, err = seek(0); err != nil || !ok {
+		return 0, 0, false, err
+	}
+	// largest block number from which a seek still finds a key
+	lo, hi := first, uint64(math.MaxUint64)
+	for lo < hi {
+		mid := lo + (hi-lo)/2 + 1
+		_, found, err := seek(mid)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if found {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return first, lo, true, nil
+}
+
+// sampleClusters - n distinct offsets out of [0, clusters) in ascending order, Floyd's algorithm
+func sampleClusters(rnd *rand.Rand, clusters, n uint64) []uint64 {
+	picked := make(map[uint64]struct{}, n)
+	ids := make([]uint64, 0, n)
+	for j := clusters - n; j < clusters; j++ {
+		var id uint64
+		if j < math.MaxInt64 {
+			id = uint64(rnd.Int63n(int64(j + 1)))
+		} else {
+			id = rnd.Uint64() % (j + 1)
+		}
+		if _, ok := picked[id]; ok {
+			id = j
+		}
+		picked[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func readCluster(tx Reader, table string, id uint64, prefixLens []int, totals []*clusterTotals) error {
+	start := kv.EncodeBlockNum(id)
+	groups := make([]map[string]uint64, len(prefixLens))
+	for i := range groups {
+		groups[i] = make(map[string]uint64)
+	}
+	var records, plain float64
+	err := tx.ForAmount(table, start, math.MaxUint32, func(k, v []byte) error {
+		if !bytes.HasPrefix(k, start) {
+			return errStopWalk
+		}
+		entry := make([]byte, 0, len(k)+len(v))
+		entry = append(append(entry, k...), v...)
+		records++
+		plain += float64(len(entry) + nodeHeader)
+		for i, l := range prefixLens {
+			if l > len(entry) {
+				l = len(entry)
+			}
+			groups[i][string(entry[:l])]++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return err
+	}
+
+	for i, l := range prefixLens {
+		t := totals[i]
+		var savings float64
+		for prefix, size := range groups[i] {
+			// MDBX keeps a single value inline, longer dup lists store the prefix once and pay a header per dup
+			if size > 1 && len(prefix) == l {
+				savings += float64(size-1)*float64(l) - nodeHeader
+			}
+			b := bits.Len64(size - 1)
+			for len(t.dupLists) <= b {
+				t.dupLists = append(t.dupLists, 0)
+			}
+			t.dupLists[b]++
+			if size > t.maxDupList {
+				t.maxDupList = size
+			}
+		}
+		t.records = append(t.records, records)
+		t.prefixes = append(t.prefixes, float64(len(groups[i])))
+		t.plain = append(t.plain, plain)
+		t.dupsort = append(t.dupsort, plain-savings)
+		t.savings = append(t.savings, savings)
+	}
+	return nil
+}
+
+// estimateTotal - expansion estimator of a table-wide total from per-cluster totals of a simple random sample
+// without replacement, with the normal-approximation confidence interval including finite population correction
+func estimateTotal(sample []float64, clusters uint64) Estimate {
+	n := float64(len(sample))
+	if n == 0 {
+		return Estimate{}
+	}
+	var sum float64
+	for _, y := range sample {
+		sum += y
+	}
+	N := float64(clusters)
+	if n >= N {
+		return Estimate{Value: sum, Low: sum, High: sum}
+	}
+	mean := sum / n
+	total := N * mean
+	var ss float64
+	for _, y := range sample {
+		ss += (y - mean) * (y - mean)
+	}
+	variance := N * N * (1 - n/N) * (ss / (n - 1)) / n
+	margin := z95 * math.Sqrt(variance)
+	// the sampled clusters are part of the table, a total below their sum is impossible
+	low := math.Max(total-margin, sum)
+	return Estimate{Value: total, Low: low, High: total + margin}
+}
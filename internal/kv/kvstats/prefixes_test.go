@@ -0,0 +1,219 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kvstats
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// exhaustive - exact counts of a fixture for one prefix length
+type exhaustive struct {
+	records, prefixes, savings float64
+	dupLists                   map[uint64]float64
+}
+
+func count(entries [][]byte, prefixLen int) exhaustive {
+	groups := make(map[string]uint64)
+	for _, e := range entries {
+		groups[string(e[:prefixLen])]++
+	}
+	ex := exhaustive{records: float64(len(entries)), prefixes: float64(len(groups)), dupLists: make(map[uint64]float64)}
+	for _, size := range groups {
+		if size > 1 {
+			ex.savings += float64(size-1)*float64(prefixLen) - nodeHeader
+		}
+		max := uint64(1)
+		for max < size {
+			max <<= 1
+		}
+		ex.dupLists[max]++
+	}
+	return ex
+}
+
+func writeStorageChangeSets(t *testing.T, tx kv.RwTx, blocks uint64) [][]byte {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(2))
+	addrs := make([][]byte, 50)
+	for i := range addrs {
+		addrs[i] = make([]byte, kv.AddrLen)
+		rnd.Read(addrs[i])
+	}
+	var entries [][]byte
+	for n := uint64(1000); n < 1000+blocks; n++ {
+		// empty blocks leave gaps between clusters
+		if n%7 == 0 {
+			continue
+		}
+		picked := make(map[int]bool)
+		for a := 0; a < rnd.Intn(7); a++ {
+			i := rnd.Intn(len(addrs))
+			if picked[i] {
+				continue
+			}
+			picked[i] = true
+			k := kv.StorageChangeSetKey(n, addrs[i], 1)
+			// skewed dup list lengths: most contracts change few slots, some change many
+			for s := 0; s < 1+rnd.Intn(1+rnd.Intn(24)); s++ {
+				v := make([]byte, kv.HashLen+rnd.Intn(33))
+				rnd.Read(v[kv.HashLen:])
+				v[0], v[1] = byte(s>>8), byte(s)
+				if err := tx.Put(kv.StorageChangeSet, k, v); err != nil {
+					t.Fatal(err)
+				}
+				entries = append(entries, append(append([]byte{}, k...), v...))
+			}
+		}
+	}
+	return entries
+}
+
+func checkExact(t *testing.T, s *PrefixStats, ex exhaustive) {
+	t.Helper()
+	for name, e := range map[string]struct {
+		have Estimate
+		want float64
+	}{
+		"records":  {s.Records, ex.records},
+		"prefixes": {s.Prefixes, ex.prefixes},
+		"savings":  {s.Savings, ex.savings},
+	} {
+		if e.have.Value != e.want || e.have.Low != e.want || e.have.High != e.want {
+			t.Fatalf("prefix %d %s: have %v, want exactly %v", s.PrefixLen, name, e.have, e.want)
+		}
+	}
+	if len(s.DupLists) == 0 {
+		t.Fatalf("prefix %d: no dup list distribution", s.PrefixLen)
+	}
+	for _, b := range s.DupLists {
+		if b.Prefixes != ex.dupLists[b.Max] {
+			t.Fatalf("prefix %d: %d prefixes with dup list %d-%d, want %v", s.PrefixLen, uint64(b.Prefixes), b.Min, b.Max, ex.dupLists[b.Max])
+		}
+	}
+	if s.DupSortSize.Value != s.PlainSize.Value-s.Savings.Value {
+		t.Fatalf("prefix %d: dupsort size %v, plain %v, savings %v", s.PrefixLen, s.DupSortSize, s.PlainSize, s.Savings)
+	}
+}
+
+func checkCovered(t *testing.T, s *PrefixStats, ex exhaustive) {
+	t.Helper()
+	for name, e := range map[string]struct {
+		have Estimate
+		want float64
+	}{
+		"records":  {s.Records, ex.records},
+		"prefixes": {s.Prefixes, ex.prefixes},
+		"savings":  {s.Savings, ex.savings},
+	} {
+		if e.want < e.have.Low || e.want > e.have.High {
+			t.Fatalf("prefix %d %s: %v outside confidence interval %v", s.PrefixLen, name, e.want, e.have)
+		}
+		if e.have.Low == e.have.High {
+			t.Fatalf("prefix %d %s: sampled estimate without interval", s.PrefixLen, name)
+		}
+		if rel := (e.have.Value - e.want) / e.want; rel > 0.15 || rel < -0.15 {
+			t.Fatalf("prefix %d %s: estimate %v off by %.1f%% of %v", s.PrefixLen, name, e.have.Value, rel*100, e.want)
+		}
+	}
+}
+
+func TestAnalyzePrefixesStorageChangeSet(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	entries := writeStorageChangeSets(t, tx, 3000)
+	short, long := Examples[0].Prefixes[0], Examples[0].Prefixes[1]
+
+	exact, err := ComparePrefixes(tx, kv.StorageChangeSet, short, long, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExact(t, exact.A, count(entries, short))
+	checkExact(t, exact.B, count(entries, long))
+	if exact.A.Clusters != 3000 || exact.A.Sampled != 3000 {
+		t.Fatalf("have %d clusters, %d sampled, want 3000", exact.A.Clusters, exact.A.Sampled)
+	}
+
+	sampled, err := ComparePrefixes(tx, kv.StorageChangeSet, short, long, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampled.A.Sampled != 300 {
+		t.Fatalf("sampled %d clusters, want 300", sampled.A.Sampled)
+	}
+	checkCovered(t, sampled.A, count(entries, short))
+	checkCovered(t, sampled.B, count(entries, long))
+	if exact.Better().PrefixLen != sampled.Better().PrefixLen {
+		t.Fatalf("sample prefers prefix %d, exhaustive count prefers %d", sampled.Better().PrefixLen, exact.Better().PrefixLen)
+	}
+	if !strings.Contains(sampled.String(), kv.StorageChangeSet) {
+		t.Fatalf("report does not name the table:\n%s", sampled)
+	}
+}
+
+func TestAnalyzePrefixesCallTraceSet(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	rnd := rand.New(rand.NewSource(3))
+	var entries [][]byte
+	for n := uint64(0); n < 500; n++ {
+		for i := 0; i < rnd.Intn(40); i++ {
+			v := make([]byte, kv.AddrLen+1)
+			rnd.Read(v)
+			if err := tx.Put(kv.CallTraceSet, kv.EncodeBlockNum(n), v); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, append(kv.EncodeBlockNum(n), v...))
+		}
+	}
+	short, long := Examples[1].Prefixes[0], Examples[1].Prefixes[1]
+	c, err := ComparePrefixes(tx, kv.CallTraceSet, short, long, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExact(t, c.A, count(entries, short))
+	checkExact(t, c.B, count(entries, long))
+	// an address is touched once per block, only the block number is shared
+	if c.Better().PrefixLen != short || c.B.Savings.Value != 0 {
+		t.Fatalf("unexpected comparison:\n%s", c)
+	}
+
+	s, err := AnalyzePrefixes(tx, kv.CallTraceSet, short, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCovered(t, s, count(entries, short))
+}
+
+func TestAnalyzePrefixesInvalid(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	if _, err := AnalyzePrefixes(tx, kv.StorageChangeSet, 4, 1); err == nil {
+		t.Fatal("prefix shorter than block number accepted")
+	}
+	if _, err := AnalyzePrefixes(tx, kv.StorageChangeSet, 8, 0); err == nil {
+		t.Fatal("zero sample rate accepted")
+	}
+	s, err := AnalyzePrefixes(tx, kv.StorageChangeSet, 8, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Clusters != 0 || s.Records.Value != 0 {
+		t.Fatalf("empty table: %+v", s)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/c2h5oh/datasize"
+)
+
+func TestDBILayout(t *testing.T) {
+	dir := t.TempDir()
+	opts := NewMDBX().Path(dir).MapSize(64 * datasize.MB)
+	// sorts before all tables, a sorted open order would shift every DBI
+	const added = "AAddedTable"
+	withAdded := func(defaultBuckets kv.TableCfg) kv.TableCfg {
+		res := kv.TableCfg{added: {}}
+		for name, cfg := range defaultBuckets {
+			res[name] = cfg
+		}
+		return res
+	}
+	readLayout := func(db kv.RwDB) (l kv.DBILayout) {
+		if err := db.View(context.Background(), func(tx kv.Tx) (err error) {
+			l, err = kv.ReadDBILayout(tx)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	db, err := opts.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := readLayout(db)
+	if len(layout) != len(kv.ChaindataTables) || layout.Tables()[0] != kv.DatabaseInfo {
+		t.Fatalf("layout of %d tables starts with %s", len(layout), layout.Tables()[0])
+	}
+	for name, dbi := range db.(*MdbxKV).AllDBI() {
+		if want, ok := layout[name]; ok && dbi != want {
+			t.Fatalf("%s has DBI %d, layout %d", name, dbi, want)
+		}
+	}
+	db.Close()
+
+	// upgrade adding a table is refused unless opted in
+	_, err = opts.WithTablessCfg(withAdded).Open()
+	var unknown *kv.UnknownTablesError
+	if !errors.As(err, &unknown) || !errors.Is(err, kv.ErrUnknownTables) || len(unknown.Tables) != 1 || unknown.Tables[0] != added {
+		t.Fatalf("open with added table: %v", err)
+	}
+	if _, err = opts.WithTablessCfg(withAdded).Readonly().AutoCreateTables().Open(); !errors.Is(err, kv.ErrUnknownTables) {
+		t.Fatalf("read-only open with added table: %v", err)
+	}
+
+	db, err = opts.WithTablessCfg(withAdded).AutoCreateTables().Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := readLayout(db)
+	db.Close()
+	for name, dbi := range layout {
+		if upgraded[name] != dbi {
+			t.Fatalf("%s moved from DBI %d to %d", name, dbi, upgraded[name])
+		}
+		if upgraded[added] <= dbi {
+			t.Fatalf("added table got DBI %d before %s with %d", upgraded[added], name, dbi)
+		}
+	}
+
+	// binary without the table keeps its DBI, so does a read-only open
+	for _, o := range []MdbxOpts{opts, opts.Readonly()} {
+		db, err = o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := readLayout(db); !l.Equal(upgraded) {
+			t.Fatalf("layout changed on downgrade: %v", upgraded.Diff(l))
+		}
+		db.Close()
+	}
+}
+
+func TestReadOnlyTables(t *testing.T) {
+	db, err := NewMDBX().InMem().MapSize(64 * datasize.MB).ReadOnlyTables(kv.ReadOnlyWhenFrozen()).Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		if err := tx.Put(kv.HeaderCanonical, []byte{1}, []byte{1}); err != nil {
+			t.Fatalf("writable table: %v", err)
+		}
+		if err := tx.Put(kv.Headers, []byte{1}, []byte{1}); !errors.Is(err, kv.ErrReadOnlyTable) {
+			t.Fatalf("put: %v", err)
+		}
+		c, err := tx.RwCursorDupSort(kv.EthTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.Append([]byte{1}, []byte{1}); !errors.Is(err, kv.ErrReadOnlyTable) {
+			t.Fatalf("append: %v", err)
+		}
+		if k, _, err := c.First(); err != nil || k != nil {
+			t.Fatalf("read: %x %v", k, err)
+		}
+		if err := tx.ClearBucket(kv.BlockBody); !errors.Is(err, kv.ErrReadOnlyTable) {
+			t.Fatalf("clear: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewMDBX().InMem().ReadOnlyTables([]string{"NoSuchTable"}).Open(); !errors.Is(err, kv.ErrUnknownBucket) {
+		t.Fatalf("unknown read-only table: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+func TestDropDeprecatedTables(t *testing.T) {
+	db := NewMDBX().InMem().MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+	begin := func(ctx context.Context) (kv.DropTx, error) { return db.BeginRw(ctx) }
+
+	// deprecated tables are not created on open, the fixture is a db written before Clique was deprecated
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		if err := tx.(kv.BucketMigrator).CreateBucket(kv.Clique); err != nil {
+			return err
+		}
+		for i := uint64(0); i < 100; i++ {
+			if err := tx.Put(kv.Clique, kv.EncodeBlockNum(i), make([]byte, 1000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(table string) bool {
+		var ok bool
+		if err := db.View(ctx, func(tx kv.Tx) (err error) {
+			ok, err = tx.(kv.BucketMigrator).ExistsBucket(table)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	dropped := func() []kv.DroppedTable {
+		var res []kv.DroppedTable
+		if err := db.View(ctx, func(tx kv.Tx) (err error) {
+			res, err = kv.ReadDroppedTables(tx)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	wouldFree, err := kv.DropDeprecatedTables(ctx, begin, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wouldFree < 100*1000 {
+		t.Fatalf("dry run would free %d bytes", wouldFree)
+	}
+	if !exists(kv.Clique) || len(dropped()) != 0 {
+		t.Fatal("dry run dropped the table")
+	}
+
+	freed, err := kv.DropDeprecatedTables(ctx, begin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != wouldFree {
+		t.Fatalf("freed %d bytes, dry run said %d", freed, wouldFree)
+	}
+	if exists(kv.Clique) {
+		t.Fatal("table was not dropped")
+	}
+	records := dropped()
+	if len(records) != 1 || records[0].Table != kv.Clique || int64(records[0].Size) != freed || records[0].Time.IsZero() {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	// nothing left to drop
+	if freed, err = kv.DropDeprecatedTables(ctx, begin, false); err != nil || freed != 0 {
+		t.Fatalf("second run freed %d bytes, err %v", freed, err)
+	}
+	if len(dropped()) != 1 {
+		t.Fatal("second run recorded a drop")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+type dupSortOp struct {
+	name  string
+	op    string // put, del, seek, seekExact, next
+	k, v  []byte
+	wantK []byte
+	wantV []byte
+}
+
+func fill(b byte, n int) []byte { return bytes.Repeat([]byte{b}, n) }
+
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func runDupSortOps(t *testing.T, table string, ops []dupSortOp) {
+	db := NewMDBX().InMem().MustOpen()
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	c, err := tx.RwCursor(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, o := range ops {
+		var k, v []byte
+		switch o.op {
+		case "put":
+			if err := c.Put(o.k, o.v); err != nil {
+				t.Fatalf("%s: %v", o.name, err)
+			}
+			continue
+		case "del":
+			if err := c.Delete(o.k); err != nil {
+				t.Fatalf("%s: %v", o.name, err)
+			}
+			continue
+		case "seek":
+			k, v, err = c.Seek(o.k)
+		case "seekExact":
+			k, v, err = c.SeekExact(o.k)
+		case "next":
+			k, v, err = c.Next()
+		default:
+			t.Fatalf("%s: unknown op %q", o.name, o.op)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", o.name, err)
+		}
+		if !bytes.Equal(k, o.wantK) || !bytes.Equal(v, o.wantV) {
+			t.Fatalf("%s: have %x=%x, want %x=%x", o.name, k, v, o.wantK, o.wantV)
+		}
+	}
+}
+
+func TestDupSortConvertingCursorPlainState(t *testing.T) {
+	acc := fill(0x01, 20)
+	inc := fill(0x00, 8)
+	slotA, slotB, slotC := fill(0x0a, 32), fill(0x0b, 32), fill(0x0c, 32)
+	keyA, keyB, keyC := cat(acc, inc, slotA), cat(acc, inc, slotB), cat(acc, inc, slotC)
+	other := fill(0x02, 20)
+	// A value whose first bytes look like a storage location must not be
+	// confused with the key part of its neighbours.
+	tricky := cat(slotA, []byte{0xff})
+
+	runDupSortOps(t, kv.PlainState, []dupSortOp{
+		{name: "put account", op: "put", k: acc, v: []byte{0x01}},
+		{name: "put slot C", op: "put", k: keyC, v: []byte{0x03}},
+		{name: "put slot A", op: "put", k: keyA, v: []byte{0x01}},
+		{name: "put slot B", op: "put", k: keyB, v: tricky},
+		{name: "put other", op: "put", k: other, v: []byte{0x02}},
+
+		{name: "seek account", op: "seek", k: acc, wantK: acc, wantV: []byte{0x01}},
+		{name: "next slot A", op: "next", wantK: keyA, wantV: []byte{0x01}},
+		{name: "next slot B", op: "next", wantK: keyB, wantV: tricky},
+		{name: "next slot C", op: "next", wantK: keyC, wantV: []byte{0x03}},
+		{name: "next other", op: "next", wantK: other, wantV: []byte{0x02}},
+
+		{name: "seek shorter than DupToLen", op: "seek", k: acc[:4], wantK: acc, wantV: []byte{0x01}},
+		{name: "seek storage prefix", op: "seek", k: cat(acc, inc), wantK: keyA, wantV: []byte{0x01}},
+		{name: "seek between slots", op: "seek", k: cat(acc, inc, fill(0x0a, 31), []byte{0x0b}), wantK: keyB, wantV: tricky},
+		{name: "seek past last slot", op: "seek", k: cat(acc, inc, fill(0x0d, 32)), wantK: other, wantV: []byte{0x02}},
+		{name: "seekExact slot B", op: "seekExact", k: keyB, wantK: keyB, wantV: tricky},
+		{name: "seekExact missing slot", op: "seekExact", k: cat(acc, inc, fill(0x0d, 32))},
+
+		{name: "overwrite slot A", op: "put", k: keyA, v: []byte{0x11, 0x12}},
+		{name: "overwritten slot A", op: "seekExact", k: keyA, wantK: keyA, wantV: []byte{0x11, 0x12}},
+		{name: "neighbour intact", op: "next", wantK: keyB, wantV: tricky},
+
+		{name: "delete slot B", op: "del", k: keyB},
+		{name: "deleted slot B", op: "seekExact", k: keyB},
+		{name: "slot C after delete", op: "seek", k: keyB, wantK: keyC, wantV: []byte{0x03}},
+		{name: "delete account", op: "del", k: acc},
+		{name: "slot A after account delete", op: "seek", k: acc, wantK: keyA, wantV: []byte{0x11, 0x12}},
+	})
+}
+
+func TestDupSortConvertingCursorHashedStorage(t *testing.T) {
+	prefix := cat(fill(0x05, 32), fill(0x00, 8))
+	keyA, keyB := cat(prefix, fill(0x01, 32)), cat(prefix, fill(0x02, 32))
+
+	runDupSortOps(t, kv.HashedStorage, []dupSortOp{
+		{name: "put B", op: "put", k: keyB, v: []byte{0x02}},
+		{name: "put A", op: "put", k: keyA, v: []byte{0x01}},
+		{name: "seek prefix", op: "seek", k: prefix, wantK: keyA, wantV: []byte{0x01}},
+		{name: "next B", op: "next", wantK: keyB, wantV: []byte{0x02}},
+		{name: "seekExact A", op: "seekExact", k: keyA, wantK: keyA, wantV: []byte{0x01}},
+		{name: "delete A", op: "del", k: keyA},
+		{name: "seek after delete", op: "seek", k: keyA, wantK: keyB, wantV: []byte{0x02}},
+	})
+}
@@ -46,6 +46,18 @@ func WithChaindataTables(defaultBuckets kv.TableCfg) kv.TableCfg {
 	return defaultBuckets
 }
 
+// registeredTables - configs of the active and deprecated chaindata tables, resolved through the registry
+func registeredTables() kv.TableCfg {
+	names := append(kv.Tables(kv.TableGroupChaindata), kv.DeprecatedTables()...)
+	res := make(kv.TableCfg, len(names))
+	for _, name := range names {
+		if cfg, ok := kv.Lookup(name); ok {
+			res[name] = cfg
+		}
+	}
+	return res
+}
+
 type MdbxOpts struct {
 	bucketsCfg    TableCfgFunc
 	path          string
@@ -59,6 +71,13 @@ type MdbxOpts struct {
 	augumentLimit uint64
 	pageSize      uint64
 	roTxsLimiter  *semaphore.Weighted
+	snapshotRenew time.Duration
+	checkSchema   bool
+	schemaUpgrade kv.SchemaUpgrade
+	// autoCreateTables - create tables the recorded DBI layout lacks, see AutoCreateTables
+	autoCreateTables bool
+	// readOnlyTables - tables marked kv.TableCfgItem.ReadOnly on open, see ReadOnlyTables
+	readOnlyTables []string
 }
 
 func testKVPath() string {
@@ -97,6 +116,12 @@ func (opts MdbxOpts) AugumentLimit(v uint64) MdbxOpts {
 	return opts
 }
 
+// SnapshotRenewInterval - how long a kv.Snapshot keeps one read transaction, kv.DefaultSnapshotRenewInterval if zero
+func (opts MdbxOpts) SnapshotRenewInterval(d time.Duration) MdbxOpts {
+	opts.snapshotRenew = d
+	return opts
+}
+
 func (opts MdbxOpts) PageSize(v uint64) MdbxOpts {
 	opts.pageSize = v
 	return opts
@@ -162,6 +187,28 @@ func (opts MdbxOpts) WithTablessCfg(f TableCfgFunc) MdbxOpts {
 	return opts
 }
 
+// CheckSchema - makes Open enforce kv.CurrentSchemaVersion, running upgrade for db which needs migrations.
+// Readonly db is only checked for errors: downgrade or incompatible major.
+func (opts MdbxOpts) CheckSchema(upgrade kv.SchemaUpgrade) MdbxOpts {
+	opts.checkSchema = true
+	opts.schemaUpgrade = upgrade
+	return opts
+}
+
+// AutoCreateTables - lets Open create tables of this binary which the DBI layout of the db lacks (additive upgrade).
+// They get DBIs after the recorded tables. Without it Open fails with kv.UnknownTablesError.
+func (opts MdbxOpts) AutoCreateTables() MdbxOpts {
+	opts.autoCreateTables = true
+	return opts
+}
+
+// ReadOnlyTables - marks the tables read-only in a writable db: writes to them fail with kv.ErrReadOnlyTable.
+// E.g. kv.ReadOnlyWhenFrozen() when replaying from frozen snapshots.
+func (opts MdbxOpts) ReadOnlyTables(tables []string) MdbxOpts {
+	opts.readOnlyTables = tables
+	return opts
+}
+
 func (opts MdbxOpts) Open() (kv.RwDB, error) {
 	var err error
 	if opts.inMem {
@@ -189,7 +236,12 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 		if opts.inMem {
 			opts.mapSize = 64 * datasize.MB
 		} else {
-			opts.mapSize = 3 * datasize.TB
+			opts.mapSize = DefaultMapSize
+		}
+	}
+	if !opts.inMem {
+		if err = CheckMapSize(opts.path, opts.mapSize); err != nil {
+			return nil, err
 		}
 	}
 	if opts.flags&mdbx.Accede == 0 {
@@ -292,39 +344,23 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 		roTxsLimiter: opts.roTxsLimiter,
 	}
 
-	customBuckets := opts.bucketsCfg(kv.ChaindataTablesCfg)
+	customBuckets := opts.bucketsCfg(registeredTables())
 	for name, cfg := range customBuckets { // copy map to avoid changing global variable
 		db.buckets[name] = cfg
 	}
+	for _, name := range opts.readOnlyTables {
+		cfg, ok := db.buckets[name]
+		if !ok {
+			env.Close()
+			return nil, fmt.Errorf("read-only table: %s, %w", name, kv.ErrUnknownBucket)
+		}
+		cfg.ReadOnly = true
+		db.buckets[name] = cfg
+	}
 
 	buckets := bucketSlice(db.buckets)
 	if err := db.openDBIs(buckets); err != nil {
-		return nil, err
-	}
-
-	// Configure buckets and open deprecated buckets
-	if err := env.View(func(tx *mdbx.Txn) error {
-		for _, name := range buckets {
-			// Open deprecated buckets if they exist, don't create
-			if !db.buckets[name].IsDeprecated {
-				continue
-			}
-			cnfCopy := db.buckets[name]
-			dbi, createErr := tx.OpenDBI(name, mdbx.DBAccede, nil, nil)
-			if createErr != nil {
-				if mdbx.IsNotFound(createErr) {
-					cnfCopy.DBI = NonExistingDBI
-					db.buckets[name] = cnfCopy
-					continue // if deprecated bucket couldn't be open - then it's deleted and it's fine
-				} else {
-					return fmt.Errorf("bucket: %s, %w", name, createErr)
-				}
-			}
-			cnfCopy.DBI = kv.DBI(dbi)
-			db.buckets[name] = cnfCopy
-		}
-		return nil
-	}); err != nil {
+		env.Close()
 		return nil, err
 	}
 
@@ -336,6 +372,22 @@ func (opts MdbxOpts) Open() (kv.RwDB, error) {
 		}
 
 	}
+
+	if opts.checkSchema {
+		var err error
+		if opts.flags&mdbx.Readonly != 0 {
+			err = db.View(context.Background(), func(tx kv.Tx) error {
+				_, _, _, err := kv.CheckSchemaVersion(tx)
+				return err
+			})
+		} else {
+			err = kv.EnforceSchemaVersion(context.Background(), db, opts.schemaUpgrade)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -359,40 +411,112 @@ type MdbxKV struct {
 
 func (db *MdbxKV) PageSize() uint64 { return db.opts.pageSize }
 
-// openDBIs - first trying to open existing DBI's in RO transaction
-// otherwise re-try by RW transaction
-// it allow open DB from another process - even if main process holding long RW transaction
+// openDBIs - opens the tables in the order of the DBI layout recorded in the db, so every table gets its recorded
+// DBI, and records the layout of a db which has none. Tables the layout lacks are created after the recorded ones
+// if the db was opened with AutoCreateTables, otherwise Open fails with kv.UnknownTablesError.
+// Read-only db is opened in RO transaction: it allow open DB from another process - even if main process holding
+// long RW transaction.
 func (db *MdbxKV) openDBIs(buckets []string) error {
-	if db.opts.flags&mdbx.Readonly != 0 {
-		if err := db.View(context.Background(), func(tx kv.Tx) error {
-			for _, name := range buckets {
-				if db.buckets[name].IsDeprecated {
-					continue
-				}
-				if err := tx.(kv.BucketMigrator).CreateBucket(name); err != nil {
-					return err
-				}
+	readonly := db.opts.flags&mdbx.Readonly != 0
+	var tx kv.Tx
+	var err error
+	if readonly {
+		tx, err = db.BeginRo(context.Background())
+	} else {
+		tx, err = db.BeginRw(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := db.assignDBIs(tx.(*MdbxTx), buckets, readonly); err != nil {
+		return err
+	}
+	return tx.Commit() // when open db as read-only, commit of this RO transaction is required
+}
+
+func (db *MdbxKV) assignDBIs(tx *MdbxTx, buckets []string, readonly bool) error {
+	if err := tx.CreateBucket(kv.DatabaseInfo); err != nil {
+		return err
+	}
+	layout, err := kv.ReadDBILayout(tx)
+	if err != nil {
+		return err
+	}
+	order, added := kv.DBIOrder(layout, buckets)
+	if layout != nil {
+		var unknown []string
+		for _, name := range added {
+			if !db.buckets[name].IsDeprecated {
+				unknown = append(unknown, name)
 			}
-			return tx.Commit() // when open db as read-only, commit of this RO transaction is required
-		}); err != nil {
-			return err
 		}
-	} else {
-		if err := db.Update(context.Background(), func(tx kv.RwTx) error {
-			for _, name := range buckets {
-				if db.buckets[name].IsDeprecated {
-					continue
-				}
-				if err := tx.(kv.BucketMigrator).CreateBucket(name); err != nil {
-					return err
-				}
+		if len(unknown) > 0 {
+			if readonly || !db.opts.autoCreateTables {
+				return &kv.UnknownTablesError{Tables: unknown}
 			}
-			return nil
-		}); err != nil {
-			return err
+			log.Info("[db] creating tables added by this binary", "tables", unknown)
 		}
 	}
-	return nil
+
+	assigned := kv.DBILayout{kv.DatabaseInfo: db.buckets[kv.DatabaseInfo].DBI}
+	var missing []string
+	var dropped bool
+	for _, name := range order {
+		if name == kv.DatabaseInfo {
+			continue
+		}
+		cfg, known := db.buckets[name]
+		if known && !cfg.IsDeprecated && !readonly {
+			if err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+			assigned[name] = db.buckets[name].DBI
+			continue
+		}
+		// deprecated tables and tables of a newer binary are opened only if they exist, read-only db can't
+		// create any
+		dbi, err := tx.tx.OpenDBI(name, mdbx.DBAccede, nil, nil)
+		if err != nil {
+			if !mdbx.IsNotFound(err) {
+				return fmt.Errorf("bucket: %s, %w", name, err)
+			}
+			if _, ok := layout[name]; ok {
+				dropped = true // DBIs after it shift
+			}
+			switch {
+			case known && !cfg.IsDeprecated:
+				missing = append(missing, name)
+			case known:
+				cfg.DBI = NonExistingDBI
+				db.buckets[name] = cfg
+			}
+			continue
+		}
+		assigned[name] = kv.DBI(dbi)
+		switch {
+		case known && !cfg.IsDeprecated:
+			if err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		case known:
+			cfg.DBI = kv.DBI(dbi)
+			db.buckets[name] = cfg
+		default:
+			log.Warn("[db] table unknown to this binary, keeping its DBI", "table", name)
+		}
+	}
+	if len(missing) > 0 {
+		return &kv.UnknownTablesError{Tables: missing}
+	}
+
+	if diff := layout.Diff(assigned); len(diff) > 0 && !dropped {
+		return fmt.Errorf("%w: %s", kv.ErrDBILayoutMismatch, strings.Join(diff, ", "))
+	}
+	if readonly || layout.Equal(assigned) {
+		return nil
+	}
+	return kv.WriteDBILayout(tx, assigned)
 }
 
 // Close closes db
@@ -446,6 +570,10 @@ func (db *MdbxKV) BeginRo(ctx context.Context) (txn kv.Tx, err error) {
 	}, nil
 }
 
+func (db *MdbxKV) BeginSnapshot(ctx context.Context) (kv.Snapshot, error) {
+	return kv.NewSnapshot(ctx, db, db.opts.snapshotRenew)
+}
+
 func (db *MdbxKV) BeginRw(_ context.Context) (txn kv.RwTx, err error) {
 	if db.closed.Load() {
 		return nil, fmt.Errorf("db closed")
@@ -706,6 +834,9 @@ func (tx *MdbxTx) CreateBucket(name string) error {
 }
 
 func (tx *MdbxTx) dropEvenIfBucketIsNotDeprecated(name string) error {
+	if tx.db.buckets[name].ReadOnly {
+		return fmt.Errorf("table: %s, %w", name, kv.ErrReadOnlyTable)
+	}
 	dbi := tx.db.buckets[name].DBI
 	// if bucket was not open on db start, then it's may be deprecated
 	// try to open it now without `Create` flag, and if fail then nothing to drop
@@ -730,6 +861,9 @@ func (tx *MdbxTx) dropEvenIfBucketIsNotDeprecated(name string) error {
 }
 
 func (tx *MdbxTx) ClearBucket(bucket string) error {
+	if tx.db.buckets[bucket].ReadOnly {
+		return fmt.Errorf("table: %s, %w", bucket, kv.ErrReadOnlyTable)
+	}
 	dbi := tx.db.buckets[bucket].DBI
 	if dbi == NonExistingDBI {
 		return nil
@@ -980,6 +1114,20 @@ func (tx *MdbxTx) BucketSize(name string) (uint64, error) {
 	return (st.LeafPages + st.BranchPages + st.OverflowPages) * tx.db.opts.pageSize, nil
 }
 
+func (tx *MdbxTx) TableStat(name string) (kv.TableStat, error) {
+	st, err := tx.BucketStat(name)
+	if err != nil {
+		return kv.TableStat{}, err
+	}
+	return kv.TableStat{
+		Entries:       st.Entries,
+		BranchPages:   st.BranchPages,
+		LeafPages:     st.LeafPages,
+		OverflowPages: st.OverflowPages,
+		Size:          (st.LeafPages + st.BranchPages + st.OverflowPages) * tx.db.opts.pageSize,
+	}, nil
+}
+
 func (tx *MdbxTx) BucketStat(name string) (*mdbx.Stat, error) {
 	if name == "freelist" || name == "gc" || name == "free_list" {
 		return tx.tx.StatDBI(mdbx.DBI(0))
@@ -1027,7 +1175,11 @@ func (tx *MdbxTx) Reset() (err error) {
 func (tx *MdbxTx) RwCursor(bucket string) (kv.RwCursor, error) {
 	b := tx.db.buckets[bucket]
 	if b.AutoDupSortKeysConversion {
-		return tx.stdCursor(bucket)
+		raw, err := tx.rawCursor(bucket)
+		if err != nil {
+			return nil, err
+		}
+		return kv.NewDupSortConvertingCursor(&MdbxDupSortCursor{MdbxCursor: raw}, bucket, b), nil
 	}
 
 	if b.Flags&kv.DupSort != 0 {
@@ -1042,8 +1194,22 @@ func (tx *MdbxTx) Cursor(bucket string) (kv.Cursor, error) {
 }
 
 func (tx *MdbxTx) stdCursor(bucket string) (kv.RwCursor, error) {
+	return tx.openCursor(bucket, tx.db.buckets[bucket])
+}
+
+// rawCursor - cursor returning keys and values in db format, without AutoDupSortKeysConversion
+func (tx *MdbxTx) rawCursor(bucket string) (*MdbxCursor, error) {
 	b := tx.db.buckets[bucket]
-	c := &MdbxCursor{bucketName: bucket, tx: tx, bucketCfg: b, dbi: mdbx.DBI(tx.db.buckets[bucket].DBI), id: tx.cursorID}
+	b.AutoDupSortKeysConversion = false
+	c, err := tx.openCursor(bucket, b)
+	if err != nil {
+		return nil, err
+	}
+	return c.(*MdbxCursor), nil
+}
+
+func (tx *MdbxTx) openCursor(bucket string, b kv.TableCfgItem) (kv.RwCursor, error) {
+	c := &MdbxCursor{bucketName: bucket, tx: tx, bucketCfg: b, dbi: mdbx.DBI(b.DBI), id: tx.cursorID}
 	tx.cursorID++
 
 	var err error
@@ -1083,14 +1249,29 @@ func (c *MdbxCursor) prev() ([]byte, []byte, error)        { return c.c.Get(nil,
 func (c *MdbxCursor) prevDup() ([]byte, []byte, error)     { return c.c.Get(nil, nil, mdbx.PrevDup) }
 func (c *MdbxCursor) prevNoDup() ([]byte, []byte, error)   { return c.c.Get(nil, nil, mdbx.PrevNoDup) }
 func (c *MdbxCursor) last() ([]byte, []byte, error)        { return c.c.Get(nil, nil, mdbx.Last) }
-func (c *MdbxCursor) delCurrent() error                    { return c.c.Del(mdbx.Current) }
-func (c *MdbxCursor) delAllDupData() error                 { return c.c.Del(mdbx.AllDups) }
-func (c *MdbxCursor) put(k, v []byte) error                { return c.c.Put(k, v, 0) }
-func (c *MdbxCursor) putCurrent(k, v []byte) error         { return c.c.Put(k, v, mdbx.Current) }
-func (c *MdbxCursor) putNoOverwrite(k, v []byte) error     { return c.c.Put(k, v, mdbx.NoOverwrite) }
-func (c *MdbxCursor) putNoDupData(k, v []byte) error       { return c.c.Put(k, v, mdbx.NoDupData) }
-func (c *MdbxCursor) append(k, v []byte) error             { return c.c.Put(k, v, mdbx.Append) }
-func (c *MdbxCursor) appendDup(k, v []byte) error          { return c.c.Put(k, v, mdbx.AppendDup) }
+func (c *MdbxCursor) delCurrent() error                    { return c.del(mdbx.Current) }
+func (c *MdbxCursor) delAllDupData() error                 { return c.del(mdbx.AllDups) }
+func (c *MdbxCursor) put(k, v []byte) error                { return c.write(k, v, 0) }
+func (c *MdbxCursor) putCurrent(k, v []byte) error         { return c.write(k, v, mdbx.Current) }
+func (c *MdbxCursor) putNoOverwrite(k, v []byte) error     { return c.write(k, v, mdbx.NoOverwrite) }
+func (c *MdbxCursor) putNoDupData(k, v []byte) error       { return c.write(k, v, mdbx.NoDupData) }
+func (c *MdbxCursor) append(k, v []byte) error             { return c.write(k, v, mdbx.Append) }
+func (c *MdbxCursor) appendDup(k, v []byte) error          { return c.write(k, v, mdbx.AppendDup) }
+
+// write - Put of the native cursor, failing for tables the db was opened with read-only
+func (c *MdbxCursor) write(k, v []byte, flags uint) error {
+	if c.bucketCfg.ReadOnly {
+		return fmt.Errorf("table: %s, %w", c.bucketName, kv.ErrReadOnlyTable)
+	}
+	return c.c.Put(k, v, flags)
+}
+
+func (c *MdbxCursor) del(flags uint) error {
+	if c.bucketCfg.ReadOnly {
+		return fmt.Errorf("table: %s, %w", c.bucketName, kv.ErrReadOnlyTable)
+	}
+	return c.c.Del(flags)
+}
 func (c *MdbxCursor) getBoth(k, v []byte) ([]byte, error) {
 	_, v, err := c.c.Get(k, v, mdbx.GetBoth)
 	return v, err
@@ -1590,7 +1771,7 @@ func (c *MdbxDupSortCursor) LastDup() ([]byte, error) {
 }
 
 func (c *MdbxDupSortCursor) Append(k []byte, v []byte) error {
-	if err := c.c.Put(k, v, mdbx.Append|mdbx.AppendDup); err != nil {
+	if err := c.write(k, v, mdbx.Append|mdbx.AppendDup); err != nil {
 		return fmt.Errorf("in Append: bucket=%s, %w", c.bucketName, err)
 	}
 	return nil
@@ -0,0 +1,81 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// DataFile - name of mdbx data file in db directory
+const DataFile = "mdbx.dat"
+
+// maxMapSize32 - map size limit of 32-bit processes: the map must fit 2GB of address space together with
+// heap, stacks and libraries
+const maxMapSize32 = 1 * datasize.GB
+
+// ErrMapSize - db can't be mapped into the address space of this process
+var ErrMapSize = errors.New("db exceeds mappable size")
+
+// DefaultMapSize - map size of on-disk db when MdbxOpts.MapSize is not set
+var DefaultMapSize = PlatformMapSize(3 * datasize.TB)
+
+// PlatformMapSize - want, limited to what this process can map
+func PlatformMapSize(want datasize.ByteSize) datasize.ByteSize {
+	return platformMapSize(want, strconv.IntSize)
+}
+
+func platformMapSize(want datasize.ByteSize, intSize int) datasize.ByteSize {
+	if intSize == 32 && want > maxMapSize32 {
+		return maxMapSize32
+	}
+	return want
+}
+
+// CheckMapSize - whether db at path can be opened with mapSize: mapSize must be addressable
+// by this process and the data file must not have outgrown it
+func CheckMapSize(path string, mapSize datasize.ByteSize) error {
+	return checkMapSize(path, mapSize, math.MaxInt)
+}
+
+func checkMapSize(path string, mapSize datasize.ByteSize, maxInt uint64) error {
+	if uint64(mapSize) > maxInt {
+		return fmt.Errorf("%w: map size %s is over %s address space limit of this build", ErrMapSize, mapSize.HR(), datasize.ByteSize(maxInt).HR())
+	}
+	fi, err := os.Stat(filepath.Join(path, DataFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if uint64(fi.Size()) > uint64(mapSize) {
+		hint := "raise map size"
+		if maxInt <= math.MaxInt32 {
+			hint = "open it with a 64-bit build"
+		}
+		return fmt.Errorf("%w: %s of size %s is larger than map size %s, %s",
+			ErrMapSize, filepath.Join(path, DataFile), datasize.ByteSize(fi.Size()).HR(), mapSize.HR(), hint)
+	}
+	return nil
+}
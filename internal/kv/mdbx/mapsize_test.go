@@ -0,0 +1,67 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+)
+
+func TestPlatformMapSize(t *testing.T) {
+	if have := platformMapSize(8*datasize.TB, 64); have != 8*datasize.TB {
+		t.Fatalf("64-bit: have %s", have.HR())
+	}
+	if have := platformMapSize(8*datasize.TB, 32); have != maxMapSize32 {
+		t.Fatalf("32-bit: have %s", have.HR())
+	}
+	if have := platformMapSize(64*datasize.MB, 32); have != 64*datasize.MB {
+		t.Fatalf("32-bit small map: have %s", have.HR())
+	}
+}
+
+func TestCheckMapSize(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkMapSize(dir, 8*datasize.TB, math.MaxInt32); !errors.Is(err, ErrMapSize) {
+		t.Fatalf("8TB map on 32-bit: %v", err)
+	}
+	if err := checkMapSize(dir, maxMapSize32, math.MaxInt32); err != nil {
+		t.Fatalf("fresh db: %v", err)
+	}
+
+	// sparse data file which outgrew 32-bit limit
+	f, err := os.Create(filepath.Join(dir, DataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(int64(2 * maxMapSize32)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	err = checkMapSize(dir, maxMapSize32, math.MaxInt32)
+	if !errors.Is(err, ErrMapSize) || !strings.Contains(err.Error(), "64-bit build") {
+		t.Fatalf("outgrown db on 32-bit: %v", err)
+	}
+	if err := checkMapSize(dir, 3*datasize.TB, math.MaxInt64); err != nil {
+		t.Fatalf("same db on 64-bit: %v", err)
+	}
+}
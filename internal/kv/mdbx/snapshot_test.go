@@ -0,0 +1,295 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/c2h5oh/datasize"
+)
+
+// snapshotTestMapSize - room for 1M PlainState entries and the pages concurrent commits copy meanwhile
+const snapshotTestMapSize = 1 * datasize.GB
+
+func plainKey(i uint64, suffix byte) []byte {
+	k := make([]byte, kv.AddrLen)
+	binary.BigEndian.PutUint64(k, i)
+	k[kv.AddrLen-1] = suffix
+	return k
+}
+
+func TestSnapshotSurvivesCommits(t *testing.T) {
+	entries := uint64(1_000_000)
+	if testing.Short() {
+		entries = 100_000
+	}
+	db := NewMDBX().InMem().MapSize(snapshotTestMapSize).SnapshotRenewInterval(time.Millisecond).MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		for i := uint64(0); i < entries; i++ {
+			if err := tx.Append(kv.PlainState, plainKey(i, 0), kv.EncodeBlockNum(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.BeginSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	// writer inserts keys between the original ones, overwrites originals and deletes its own inserts
+	done := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		writeErr error
+		commits  int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := uint64(0); ; round++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if writeErr = db.Update(ctx, func(tx kv.RwTx) error {
+				for j := uint64(0); j < 100; j++ {
+					i := (round*7919 + j*104729) % entries
+					if err := tx.Put(kv.PlainState, plainKey(i, 1), []byte{1}); err != nil {
+						return err
+					}
+					if err := tx.Put(kv.PlainState, plainKey(i, 0), []byte{2}); err != nil {
+						return err
+					}
+					if round > 0 {
+						prev := ((round-1)*7919 + j*104729) % entries
+						if err := tx.Delete(kv.PlainState, plainKey(prev, 1)); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}); writeErr != nil {
+				return
+			}
+			commits++
+		}
+	}()
+
+	var seen uint64
+	var last []byte
+	err = snap.ForEach(kv.PlainState, nil, func(k, v []byte) error {
+		if last != nil && bytes.Compare(k, last) <= 0 {
+			t.Fatalf("key %x after %x", k, last)
+		}
+		last = append(last[:0], k...)
+		if k[kv.AddrLen-1] == 0 {
+			if binary.BigEndian.Uint64(k) != seen {
+				t.Fatalf("have key %x, want entry %d", k, seen)
+			}
+			seen++
+		}
+		return nil
+	})
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	if seen != entries {
+		t.Fatalf("scanned %d of %d entries", seen, entries)
+	}
+	if snap.Renewals() == 0 || commits == 0 {
+		t.Fatalf("%d renewals, %d commits", snap.Renewals(), commits)
+	}
+}
+
+// an idle Snapshot holds no read transaction: commits meanwhile reuse the pages they free, as without a Snapshot
+func TestSnapshotIdleDoesNotPin(t *testing.T) {
+	ctx := context.Background()
+	growth := func(idleSnapshot bool) uint64 {
+		db := NewMDBX().InMem().MapSize(snapshotTestMapSize).SnapshotRenewInterval(time.Hour).MustOpen()
+		defer db.Close()
+		const entries = 100_000
+		if err := db.Update(ctx, func(tx kv.RwTx) error {
+			for i := uint64(0); i < entries; i++ {
+				if err := tx.Append(kv.PlainState, plainKey(i, 0), kv.EncodeBlockNum(i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if idleSnapshot {
+			snap, err := db.BeginSnapshot(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snap.Close()
+			if _, err := snap.GetOne(kv.PlainState, plainKey(0, 0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var size uint64
+		for round := uint64(0); round < 200; round++ {
+			if err := db.Update(ctx, func(tx kv.RwTx) (err error) {
+				for j := uint64(0); j < 100; j++ {
+					if err := tx.Put(kv.PlainState, plainKey((round*7919+j*104729)%entries, 0), []byte{byte(round)}); err != nil {
+						return err
+					}
+				}
+				size, err = tx.(*MdbxTx).DBSize()
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return size
+	}
+	if idle, none := growth(true), growth(false); idle > none+none/10 {
+		t.Fatalf("db grew to %d with an idle snapshot, to %d without", idle, none)
+	}
+}
+
+func TestSnapshotDupSortAndPins(t *testing.T) {
+	db := NewMDBX().InMem().SnapshotRenewInterval(time.Nanosecond).MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+
+	key := kv.StorageChangeSetKey(1, make([]byte, kv.AddrLen), 1)
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		for i := byte(0); i < 10; i++ {
+			if err := tx.Put(kv.StorageChangeSet, key, []byte{i}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(kv.StorageChangeSet, kv.StorageChangeSetKey(2, make([]byte, kv.AddrLen), 1), []byte{0}); err != nil {
+			return err
+		}
+		return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := db.BeginSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+
+	// every entry renews the transaction, the dup visited last is deleted before each renewal
+	var values []byte
+	if err := snap.ForPrefix(kv.StorageChangeSet, key, func(k, v []byte) error {
+		values = append(values, v[0])
+		dup := append([]byte{}, v...)
+		// writers run on their own OS thread, as they do in the node
+		errc := make(chan error)
+		go func() {
+			errc <- db.Update(ctx, func(tx kv.RwTx) error {
+				c, err := tx.RwCursorDupSort(kv.StorageChangeSet)
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				if _, _, err := c.SeekBothExact(key, dup); err != nil {
+					return err
+				}
+				return c.DeleteCurrent()
+			})
+		}()
+		return <-errc
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(values, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("have dups %v", values)
+	}
+
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, []byte{0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 0})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := snap.GetOne(kv.StorageChangeSet, key); !errors.Is(err, kv.ErrSnapshotSchemaChanged) {
+		t.Fatalf("have %v, want %v", err, kv.ErrSnapshotSchemaChanged)
+	}
+}
+
+func TestSnapshotCanonicalReorg(t *testing.T) {
+	db := NewMDBX().InMem().SnapshotRenewInterval(time.Nanosecond).MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+
+	setHead := func(num uint64, hash byte) {
+		if err := db.Update(ctx, func(tx kv.RwTx) error {
+			h := bytes.Repeat([]byte{hash}, 32)
+			if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(num), h); err != nil {
+				return err
+			}
+			if err := tx.Put(kv.HeaderNumber, h, kv.EncodeBlockNum(num)); err != nil {
+				return err
+			}
+			return tx.Put(kv.HeadBlockKey, []byte(kv.HeadBlockKey), h)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setHead(5, 0xaa)
+
+	snap, err := db.BeginSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	if snap.Height() != 5 {
+		t.Fatalf("have height %d, want 5", snap.Height())
+	}
+
+	// a new block on top keeps the pinned one
+	setHead(6, 0xbb)
+	time.Sleep(time.Millisecond)
+	if _, err := snap.GetOne(kv.PlainState, plainKey(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a sibling of the same height replaces it
+	setHead(5, 0xcc)
+	time.Sleep(time.Millisecond)
+	if _, err := snap.GetOne(kv.PlainState, plainKey(0, 0)); !errors.Is(err, kv.ErrSnapshotUnwound) {
+		t.Fatalf("have %v, want %v", err, kv.ErrSnapshotUnwound)
+	}
+}
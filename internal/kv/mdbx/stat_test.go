@@ -0,0 +1,89 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package mdbx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+func TestStat(t *testing.T) {
+	db := NewMDBX().InMem().MustOpen()
+	defer db.Close()
+	ctx := context.Background()
+
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i := uint64(0); i < 1000; i++ {
+		if err := tx.Put(kv.Headers, kv.EncodeBlockNum(i), make([]byte, 500)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(kv.Receipts, kv.EncodeBlockNum(i), make([]byte, 5000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the chaindata db has no txpool and downloader tables, they are skipped
+	stats, err := kv.Stat(ctx, tx.(kv.TableStater))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats[kv.PoolTransaction]; ok {
+		t.Fatal("stat of table from another db")
+	}
+	if st := stats[kv.Headers]; st.Entries != 1000 || st.LeafPages == 0 || st.Size == 0 {
+		t.Fatalf("unexpected %s stat: %+v", kv.Headers, st)
+	}
+	if st := stats[kv.Receipts]; st.Entries != 1000 || st.OverflowPages == 0 {
+		t.Fatalf("unexpected %s stat: %+v", kv.Receipts, st)
+	}
+	if st, ok := stats[kv.PlainState]; !ok || st.Entries != 0 {
+		t.Fatalf("empty table: %+v %t", st, ok)
+	}
+	if sorted := kv.SortTableStats(stats); sorted[0] != kv.Receipts || sorted[1] != kv.Headers {
+		t.Fatalf("unexpected order: %v", sorted[:2])
+	}
+
+	at := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if err := kv.WriteTableStatsSample(tx, at.Add(time.Duration(i)*time.Hour), stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var samples []kv.TableStatsSample
+	if err := kv.ReadTableStatsSamples(tx, func(s kv.TableStatsSample) error {
+		samples = append(samples, s)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 || !samples[2].Time.Equal(at.Add(2*time.Hour)) || samples[0].Tables[kv.Headers] != stats[kv.Headers] {
+		t.Fatalf("unexpected samples: %+v", samples)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := kv.Stat(cancelled, tx.(kv.TableStater)); err == nil {
+		t.Fatal("cancelled stat succeeded")
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/google/btree"
+)
+
+var (
+	// ErrKeyMismatch - Append of a key which is not after the last one, or AppendDup of a value not after the last dup
+	ErrKeyMismatch = errors.New("key/data pair is out of order")
+	// ErrKeyExists - PutNoDupData of a key/data pair which is already stored
+	ErrKeyExists = errors.New("key/data pair already exists")
+
+	errReadOnly      = errors.New("write in read-only transaction")
+	errTableNotFound = errors.New("table not found")
+)
+
+const btreeDegree = 32
+
+// btreeItem - one record of a table. DupSort tables keep one item per key/value pair ordered by key then value,
+// other tables one item per key ordered by key.
+type btreeItem struct {
+	k, v []byte
+}
+
+type btreeTable = btree.BTreeG[btreeItem]
+
+// compareKeys - key order of table with flags. IntegerKey tables order 4 and 8 byte keys as native (little-endian)
+// unsigned integers like MDBX does.
+func compareKeys(flags kv.TableFlags) func(a, b []byte) int {
+	if flags&kv.IntegerKey == 0 {
+		return bytes.Compare
+	}
+	return func(a, b []byte) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		var x, y uint64
+		switch len(a) {
+		case 4:
+			x, y = uint64(binary.LittleEndian.Uint32(a)), uint64(binary.LittleEndian.Uint32(b))
+		case 8:
+			x, y = binary.LittleEndian.Uint64(a), binary.LittleEndian.Uint64(b)
+		default:
+			return bytes.Compare(a, b)
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+}
+
+func lessFunc(cfg kv.TableCfgItem) btree.LessFunc[btreeItem] {
+	cmp := compareKeys(cfg.Flags)
+	if cfg.Flags&kv.DupSort == 0 {
+		return func(a, b btreeItem) bool { return cmp(a.k, b.k) < 0 }
+	}
+	return func(a, b btreeItem) bool {
+		if c := cmp(a.k, b.k); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.v, b.v) < 0
+	}
+}
+
+// BtreeKV - kv.RwDB keeping tables in ordered in-memory btrees, for tests which don't need a real MDBX file.
+// Tables follow their kv.TableCfg like MDBX: DupSort order, IntegerKey order and AutoDupSortKeysConversion.
+// Using a table missing in the config panics with kv.ErrUnknownBucket.
+// Like MDBX there is one write tx at a time, read txs see the db as of their begin.
+type BtreeKV struct {
+	label kv.Label
+	cfg   kv.TableCfg
+
+	writer sync.Mutex   // held by the write tx
+	mu     sync.RWMutex // guards tables and txID
+	tables map[string]*btreeTable
+	txID   uint64
+	closed atomic.Bool
+}
+
+// NewBtreeKV - empty db with the tables of cfg, deprecated tables are not created
+func NewBtreeKV(label kv.Label, cfg kv.TableCfg) *BtreeKV {
+	db := &BtreeKV{label: label, cfg: make(kv.TableCfg, len(cfg)), tables: make(map[string]*btreeTable, len(cfg))}
+	for name, tableCfg := range cfg {
+		db.cfg[name] = tableCfg
+		if !tableCfg.IsDeprecated {
+			db.tables[name] = btree.NewG(btreeDegree, lessFunc(tableCfg))
+		}
+	}
+	return db
+}
+
+func (db *BtreeKV) Close() { db.closed.Store(true) }
+
+func (db *BtreeKV) AllBuckets() kv.TableCfg { return db.cfg }
+
+func (db *BtreeKV) PageSize() uint64 { return kv.DefaultPageSize() }
+
+func (db *BtreeKV) BeginSnapshot(ctx context.Context) (kv.Snapshot, error) {
+	return kv.NewSnapshot(ctx, db, kv.DefaultSnapshotRenewInterval)
+}
+
+func (db *BtreeKV) BeginRo(_ context.Context) (kv.Tx, error) {
+	if db.closed.Load() {
+		return nil, fmt.Errorf("db closed")
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return &btreeTx{db: db, tables: db.tables, readOnly: true, id: db.txID}, nil
+}
+
+func (db *BtreeKV) BeginRw(_ context.Context) (kv.RwTx, error) {
+	if db.closed.Load() {
+		return nil, fmt.Errorf("db closed")
+	}
+	db.writer.Lock()
+	return db.beginRw(), nil
+}
+
+func (db *BtreeKV) beginRw() *btreeTx {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	tables := make(map[string]*btreeTable, len(db.tables))
+	for name, t := range db.tables {
+		tables[name] = t
+	}
+	return &btreeTx{db: db, tables: tables, cloned: make(map[string]bool), id: db.txID + 1}
+}
+
+func (db *BtreeKV) View(ctx context.Context, f func(tx kv.Tx) error) error {
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+func (db *BtreeKV) Update(ctx context.Context, f func(tx kv.RwTx) error) error {
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// btreeTx - read tx reads the tables committed at its begin, they are never modified. Write tx clones a table
+// (copy-on-write, cheap) the first time it writes to it and publishes its tables on commit.
+type btreeTx struct {
+	db       *BtreeKV
+	tables   map[string]*btreeTable
+	cloned   map[string]bool
+	readOnly bool
+	done     bool
+	id       uint64
+}
+
+func (tx *btreeTx) cfg(table string) kv.TableCfgItem {
+	cfg, ok := tx.db.cfg[table]
+	if !ok {
+		panic(fmt.Errorf("%w: %s", kv.ErrUnknownBucket, table))
+	}
+	return cfg
+}
+
+func (tx *btreeTx) tree(table string) (*btreeTable, error) {
+	tx.cfg(table)
+	t, ok := tx.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("table: %s, %w", table, errTableNotFound)
+	}
+	return t, nil
+}
+
+func (tx *btreeTx) writable(table string) (*btreeTable, error) {
+	if tx.readOnly || tx.done {
+		return nil, fmt.Errorf("table: %s, %w", table, errReadOnly)
+	}
+	t, err := tx.tree(table)
+	if err != nil {
+		return nil, err
+	}
+	if !tx.cloned[table] {
+		t = t.Clone()
+		tx.tables[table] = t
+		tx.cloned[table] = true
+	}
+	return t, nil
+}
+
+func (tx *btreeTx) ViewID() uint64 { return tx.id }
+
+func (tx *btreeTx) Commit() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	if tx.readOnly {
+		return nil
+	}
+	tx.db.mu.Lock()
+	tx.db.tables = tx.tables
+	tx.db.txID = tx.id
+	tx.db.mu.Unlock()
+	tx.db.writer.Unlock()
+	return nil
+}
+
+func (tx *btreeTx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	if !tx.readOnly {
+		tx.db.writer.Unlock()
+	}
+}
+
+func (tx *btreeTx) Reset() error {
+	tx.Rollback()
+	if tx.db.closed.Load() {
+		return fmt.Errorf("db closed")
+	}
+	tx.db.writer.Lock()
+	*tx = *tx.db.beginRw()
+	return nil
+}
+
+func (tx *btreeTx) CollectMetrics() {}
+
+func (tx *btreeTx) cursor(table string) *btreeCursor {
+	cfg := tx.cfg(table)
+	return &btreeCursor{tx: tx, table: table, cfg: cfg, cmp: compareKeys(cfg.Flags), less: lessFunc(cfg)}
+}
+
+func (tx *btreeTx) RwCursor(table string) (kv.RwCursor, error) {
+	c := tx.cursor(table)
+	return kv.NewDupSortConvertingCursor(c, table, c.cfg), nil
+}
+
+func (tx *btreeTx) Cursor(table string) (kv.Cursor, error) {
+	return tx.RwCursor(table)
+}
+
+func (tx *btreeTx) RwCursorDupSort(table string) (kv.RwCursorDupSort, error) {
+	c := tx.cursor(table)
+	if c.cfg.AutoDupSortKeysConversion {
+		return &convertingDupSortCursor{btreeCursor: c, conv: kv.NewDupSortConvertingCursor(c, table, c.cfg)}, nil
+	}
+	return c, nil
+}
+
+func (tx *btreeTx) CursorDupSort(table string) (kv.CursorDupSort, error) {
+	return tx.RwCursorDupSort(table)
+}
+
+func (tx *btreeTx) GetOne(table string, k []byte) ([]byte, error) {
+	c, _ := tx.RwCursor(table)
+	_, v, err := c.SeekExact(k)
+	return v, err
+}
+
+func (tx *btreeTx) Has(table string, key []byte) (bool, error) {
+	c, _ := tx.RwCursor(table)
+	k, _, err := c.Seek(key)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(key, k), nil
+}
+
+func (tx *btreeTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	c, _ := tx.RwCursor(table)
+	for k, v, err := c.Seek(fromPrefix); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if err := walker(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *btreeTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	c, _ := tx.RwCursor(table)
+	for k, v, err := c.Seek(prefix); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		if err := walker(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *btreeTx) ForAmount(table string, fromPrefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	if amount == 0 {
+		return nil
+	}
+	c, _ := tx.RwCursor(table)
+	for k, v, err := c.Seek(fromPrefix); k != nil && amount > 0; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if err := walker(k, v); err != nil {
+			return err
+		}
+		amount--
+	}
+	return nil
+}
+
+func (tx *btreeTx) Put(table string, k, v []byte) error {
+	c, _ := tx.RwCursor(table)
+	return c.Put(k, v)
+}
+
+func (tx *btreeTx) Delete(table string, k []byte) error {
+	c, _ := tx.RwCursor(table)
+	return c.Delete(k)
+}
+
+func (tx *btreeTx) Append(table string, k, v []byte) error {
+	c, _ := tx.RwCursor(table)
+	return c.Append(k, v)
+}
+
+func (tx *btreeTx) AppendDup(table string, k, v []byte) error {
+	return tx.cursor(table).AppendDup(k, v)
+}
+
+func (tx *btreeTx) IncrementSequence(table string, amount uint64) (uint64, error) {
+	current, err := tx.ReadSequence(table)
+	if err != nil {
+		return 0, err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, current+amount)
+	if err := tx.Put(kv.Sequence, []byte(table), v); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+func (tx *btreeTx) ReadSequence(table string) (uint64, error) {
+	v, err := tx.GetOne(kv.Sequence, []byte(table))
+	if err != nil || len(v) == 0 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// TableStat - entries and bytes of keys and values of table, there are no pages
+func (tx *btreeTx) TableStat(table string) (kv.TableStat, error) {
+	t, err := tx.tree(table)
+	if err != nil {
+		return kv.TableStat{}, err
+	}
+	st := kv.TableStat{Entries: uint64(t.Len())}
+	t.Ascend(func(it btreeItem) bool {
+		st.Size += uint64(len(it.k) + len(it.v))
+		return true
+	})
+	return st, nil
+}
+
+func (tx *btreeTx) BucketSize(table string) (uint64, error) {
+	st, err := tx.TableStat(table)
+	return st.Size, err
+}
+
+func (tx *btreeTx) DBSize() (uint64, error) {
+	var size uint64
+	for name := range tx.tables {
+		s, err := tx.BucketSize(name)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
+
+func (tx *btreeTx) CreateBucket(table string) error {
+	cfg := tx.cfg(table)
+	if _, ok := tx.tables[table]; ok {
+		return nil
+	}
+	if tx.readOnly || tx.done {
+		return fmt.Errorf("create bucket: %s, %w", table, errTableNotFound)
+	}
+	tx.tables[table] = btree.NewG(btreeDegree, lessFunc(cfg))
+	tx.cloned[table] = true
+	return nil
+}
+
+func (tx *btreeTx) DropBucket(table string) error {
+	if cfg, ok := tx.db.cfg[table]; !(ok && cfg.IsDeprecated) {
+		return fmt.Errorf("%w, bucket: %s", kv.ErrAttemptToDeleteNonDeprecatedBucket, table)
+	}
+	if tx.readOnly || tx.done {
+		return fmt.Errorf("table: %s, %w", table, errReadOnly)
+	}
+	delete(tx.tables, table)
+	delete(tx.cloned, table)
+	return nil
+}
+
+func (tx *btreeTx) ExistsBucket(table string) (bool, error) {
+	_, ok := tx.tables[table]
+	return ok, nil
+}
+
+func (tx *btreeTx) ClearBucket(table string) error {
+	if _, ok := tx.tables[table]; !ok {
+		return nil
+	}
+	if tx.readOnly || tx.done {
+		return fmt.Errorf("table: %s, %w", table, errReadOnly)
+	}
+	tx.tables[table] = btree.NewG(btreeDegree, lessFunc(tx.cfg(table)))
+	tx.cloned[table] = true
+	return nil
+}
+
+func (tx *btreeTx) ListBuckets() ([]string, error) {
+	res := make([]string, 0, len(tx.tables))
+	for name := range tx.tables {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
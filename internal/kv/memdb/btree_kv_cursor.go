@@ -0,0 +1,378 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/google/btree"
+)
+
+var errNotPositioned = errors.New("cursor is not positioned")
+
+// btreeCursor - cursor over a table of btreeTx in db format, like the MDBX one. It remembers the item it is at,
+// not a place in the tree, so it survives the writes of its tx: after DeleteCurrent Next moves to the item after
+// the deleted one. Keys and values it returns must not be modified.
+type btreeCursor struct {
+	tx    *btreeTx
+	table string
+	cfg   kv.TableCfgItem
+	cmp   func(a, b []byte) int
+	less  btree.LessFunc[btreeItem]
+
+	cur btreeItem
+	set bool
+}
+
+func (c *btreeCursor) tree() (*btreeTable, error) { return c.tx.tree(c.table) }
+
+func (c *btreeCursor) at(it btreeItem) ([]byte, []byte, error) {
+	c.cur, c.set = it, true
+	return it.k, it.v, nil
+}
+
+// ge - first item not less than pivot
+func (c *btreeCursor) ge(pivot btreeItem) (res btreeItem, ok bool, err error) {
+	t, err := c.tree()
+	if err != nil {
+		return res, false, err
+	}
+	t.AscendGreaterOrEqual(pivot, func(it btreeItem) bool {
+		res, ok = it, true
+		return false
+	})
+	return res, ok, nil
+}
+
+// gt - first item greater than pivot
+func (c *btreeCursor) gt(pivot btreeItem) (res btreeItem, ok bool, err error) {
+	t, err := c.tree()
+	if err != nil {
+		return res, false, err
+	}
+	t.AscendGreaterOrEqual(pivot, func(it btreeItem) bool {
+		if !c.less(pivot, it) {
+			return true
+		}
+		res, ok = it, true
+		return false
+	})
+	return res, ok, nil
+}
+
+// lt - last item less than pivot
+func (c *btreeCursor) lt(pivot btreeItem) (res btreeItem, ok bool, err error) {
+	t, err := c.tree()
+	if err != nil {
+		return res, false, err
+	}
+	t.DescendLessOrEqual(pivot, func(it btreeItem) bool {
+		if !c.less(it, pivot) {
+			return true
+		}
+		res, ok = it, true
+		return false
+	})
+	return res, ok, nil
+}
+
+// found - positions the cursor at it if ok, nil key and value otherwise
+func (c *btreeCursor) found(it btreeItem, ok bool, err error) ([]byte, []byte, error) {
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	return c.at(it)
+}
+
+func (c *btreeCursor) First() ([]byte, []byte, error) {
+	t, err := c.tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	it, ok := t.Min()
+	return c.found(it, ok, nil)
+}
+
+func (c *btreeCursor) Last() ([]byte, []byte, error) {
+	t, err := c.tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	it, ok := t.Max()
+	return c.found(it, ok, nil)
+}
+
+func (c *btreeCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	if len(seek) == 0 {
+		return c.First()
+	}
+	return c.found(c.ge(btreeItem{k: seek}))
+}
+
+func (c *btreeCursor) SeekExact(key []byte) ([]byte, []byte, error) {
+	it, ok, err := c.ge(btreeItem{k: key})
+	return c.found(it, ok && c.cmp(it.k, key) == 0, err)
+}
+
+func (c *btreeCursor) Next() ([]byte, []byte, error) {
+	if !c.set {
+		return c.First()
+	}
+	return c.found(c.gt(c.cur))
+}
+
+func (c *btreeCursor) Prev() ([]byte, []byte, error) {
+	if !c.set {
+		return c.Last()
+	}
+	return c.found(c.lt(c.cur))
+}
+
+// Current - item the cursor is at, or the one after it if it was deleted
+func (c *btreeCursor) Current() ([]byte, []byte, error) {
+	if !c.set {
+		return nil, nil, nil
+	}
+	return c.found(c.ge(c.cur))
+}
+
+func (c *btreeCursor) Count() (uint64, error) {
+	t, err := c.tree()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(t.Len()), nil
+}
+
+func (c *btreeCursor) Close() {}
+
+func (c *btreeCursor) insert(k, v []byte) error {
+	if len(k) == 0 {
+		return fmt.Errorf("empty keys are not supported. table: %s", c.table)
+	}
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	it := btreeItem{k: copyBytes(k), v: copyBytes(v)}
+	t.ReplaceOrInsert(it)
+	c.at(it)
+	return nil
+}
+
+// copyBytes - callers may reuse their buffers, the stored copy is never nil
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// Put - in DupSort table adds the value to the values of k
+func (c *btreeCursor) Put(k, v []byte) error { return c.insert(k, v) }
+
+func (c *btreeCursor) Append(k, v []byte) error {
+	if c.cfg.Flags&kv.DupSort != 0 {
+		return c.AppendDup(k, v)
+	}
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	// as in mdbx, appending the last key again overwrites its value
+	if last, ok := t.Max(); ok && c.cmp(k, last.k) < 0 {
+		return fmt.Errorf("bucket: %s, %w", c.table, ErrKeyMismatch)
+	}
+	return c.insert(k, v)
+}
+
+func (c *btreeCursor) AppendDup(k, v []byte) error {
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	if last, ok := t.Max(); ok && !c.less(last, btreeItem{k: k, v: v}) {
+		return fmt.Errorf("bucket: %s, %w", c.table, ErrKeyMismatch)
+	}
+	return c.insert(k, v)
+}
+
+// deleteKey - deletes all the values of k
+func (c *btreeCursor) deleteKey(k []byte) error {
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	var dups []btreeItem
+	t.AscendGreaterOrEqual(btreeItem{k: k}, func(it btreeItem) bool {
+		if c.cmp(it.k, k) != 0 {
+			return false
+		}
+		dups = append(dups, it)
+		return true
+	})
+	for _, it := range dups {
+		t.Delete(it)
+	}
+	return nil
+}
+
+func (c *btreeCursor) Delete(k []byte) error { return c.deleteKey(k) }
+
+func (c *btreeCursor) DeleteCurrent() error {
+	if !c.set {
+		return fmt.Errorf("table: %s, %w", c.table, errNotPositioned)
+	}
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	t.Delete(c.cur)
+	return nil
+}
+
+func (c *btreeCursor) SeekBothExact(key, value []byte) ([]byte, []byte, error) {
+	t, err := c.tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	it, ok := t.Get(btreeItem{k: key, v: value})
+	return c.found(it, ok && bytes.Equal(it.v, value), nil)
+}
+
+func (c *btreeCursor) SeekBothRange(key, value []byte) ([]byte, error) {
+	it, ok, err := c.ge(btreeItem{k: key, v: value})
+	_, v, err := c.found(it, ok && c.cmp(it.k, key) == 0, err)
+	return v, err
+}
+
+func (c *btreeCursor) FirstDup() ([]byte, error) {
+	if !c.set {
+		return nil, nil
+	}
+	it, ok, err := c.ge(btreeItem{k: c.cur.k})
+	_, v, err := c.found(it, ok && c.cmp(it.k, c.cur.k) == 0, err)
+	return v, err
+}
+
+// lastDup - last item having the key of the current one
+func (c *btreeCursor) lastDup() (res btreeItem, ok bool, err error) {
+	t, err := c.tree()
+	if err != nil || !c.set {
+		return res, false, err
+	}
+	t.AscendGreaterOrEqual(btreeItem{k: c.cur.k}, func(it btreeItem) bool {
+		if c.cmp(it.k, c.cur.k) != 0 {
+			return false
+		}
+		res, ok = it, true
+		return true
+	})
+	return res, ok, nil
+}
+
+func (c *btreeCursor) LastDup() ([]byte, error) {
+	_, v, err := c.found(c.lastDup())
+	return v, err
+}
+
+func (c *btreeCursor) NextDup() ([]byte, []byte, error) {
+	if !c.set {
+		return nil, nil, nil
+	}
+	it, ok, err := c.gt(c.cur)
+	return c.found(it, ok && c.cmp(it.k, c.cur.k) == 0, err)
+}
+
+func (c *btreeCursor) NextNoDup() ([]byte, []byte, error) {
+	if !c.set {
+		return c.First()
+	}
+	last, ok, err := c.lastDup()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		last = c.cur // all values of the key were deleted
+	}
+	return c.found(c.gt(last))
+}
+
+func (c *btreeCursor) CountDuplicates() (uint64, error) {
+	t, err := c.tree()
+	if err != nil || !c.set {
+		return 0, err
+	}
+	var n uint64
+	t.AscendGreaterOrEqual(btreeItem{k: c.cur.k}, func(it btreeItem) bool {
+		if c.cmp(it.k, c.cur.k) != 0 {
+			return false
+		}
+		n++
+		return true
+	})
+	return n, nil
+}
+
+func (c *btreeCursor) PutNoDupData(key, value []byte) error {
+	t, err := c.tree()
+	if err != nil {
+		return err
+	}
+	if it, ok := t.Get(btreeItem{k: key, v: value}); ok && bytes.Equal(it.v, value) {
+		return fmt.Errorf("bucket: %s, %w", c.table, ErrKeyExists)
+	}
+	return c.insert(key, value)
+}
+
+func (c *btreeCursor) DeleteCurrentDuplicates() error {
+	if !c.set {
+		return fmt.Errorf("table: %s, %w", c.table, errNotPositioned)
+	}
+	return c.deleteKey(c.cur.k)
+}
+
+func (c *btreeCursor) DeleteExact(k1, k2 []byte) error {
+	t, err := c.tx.writable(c.table)
+	if err != nil {
+		return err
+	}
+	if it, ok := t.Get(btreeItem{k: k1, v: k2}); ok && bytes.Equal(it.v, k2) {
+		t.Delete(it)
+	}
+	return nil
+}
+
+// convertingDupSortCursor - CursorDupSort of a table with AutoDupSortKeysConversion, like the MDBX one:
+// Cursor methods work with logical keys, DupSort methods with keys and values in db format.
+type convertingDupSortCursor struct {
+	*btreeCursor
+	conv kv.RwCursor
+}
+
+func (c *convertingDupSortCursor) First() ([]byte, []byte, error)        { return c.conv.First() }
+func (c *convertingDupSortCursor) Seek(k []byte) ([]byte, []byte, error) { return c.conv.Seek(k) }
+func (c *convertingDupSortCursor) SeekExact(k []byte) ([]byte, []byte, error) {
+	return c.conv.SeekExact(k)
+}
+func (c *convertingDupSortCursor) Next() ([]byte, []byte, error)    { return c.conv.Next() }
+func (c *convertingDupSortCursor) Prev() ([]byte, []byte, error)    { return c.conv.Prev() }
+func (c *convertingDupSortCursor) Last() ([]byte, []byte, error)    { return c.conv.Last() }
+func (c *convertingDupSortCursor) Current() ([]byte, []byte, error) { return c.conv.Current() }
+func (c *convertingDupSortCursor) Put(k, v []byte) error            { return c.conv.Put(k, v) }
+func (c *convertingDupSortCursor) Append(k, v []byte) error         { return c.conv.Append(k, v) }
+func (c *convertingDupSortCursor) Delete(k []byte) error            { return c.conv.Delete(k) }
@@ -0,0 +1,335 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// diffTables - a plain table, a DupSort table and one with AutoDupSortKeysConversion
+var diffTables = []string{kv.HeaderCanonical, kv.AccountChangeSet, kv.PlainState}
+
+// diffEnv - one db of the differential test with a cursor per table, dup is nil for PlainState
+// which is only used through logical keys
+type diffEnv struct {
+	db     kv.RwDB
+	tx     kv.RwTx
+	cursor map[string]kv.RwCursor
+	dup    map[string]kv.RwCursorDupSort
+}
+
+func (e *diffEnv) begin(t *testing.T) {
+	tx, err := e.db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.tx = tx
+	e.cursor, e.dup = map[string]kv.RwCursor{}, map[string]kv.RwCursorDupSort{}
+	for _, table := range diffTables {
+		if table == kv.PlainState {
+			if e.cursor[table], err = tx.RwCursor(table); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if e.dup[table], err = tx.RwCursorDupSort(table); err != nil {
+			t.Fatal(err)
+		}
+		e.cursor[table] = e.dup[table]
+	}
+}
+
+type diffStep struct {
+	table string
+	op    string
+	k, v  []byte
+}
+
+func (s diffStep) String() string { return fmt.Sprintf("%s %s %x %x", s.table, s.op, s.k, s.v) }
+
+// relative steps depend on the position of the cursor, they only run after a step positioned it
+var relativeSteps = map[string]bool{"next": true, "prev": true, "current": true, "deleteCurrent": true, "firstDup": true,
+	"lastDup": true, "nextDup": true, "nextNoDup": true, "countDuplicates": true, "deleteCurrentDuplicates": true}
+
+func result(k, v []byte, err error) string {
+	return fmt.Sprintf("%x %x %v", k, v, err != nil)
+}
+
+func (s diffStep) apply(t *testing.T, e *diffEnv) string {
+	c, d := e.cursor[s.table], e.dup[s.table]
+	switch s.op {
+	case "commit":
+		if err := e.tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		e.begin(t)
+		return ""
+	case "rollback":
+		e.tx.Rollback()
+		e.begin(t)
+		return ""
+	case "put":
+		return result(nil, nil, c.Put(s.k, s.v))
+	case "append":
+		return result(nil, nil, c.Append(s.k, s.v))
+	case "delete":
+		return result(nil, nil, c.Delete(s.k))
+	case "deleteCurrent":
+		return result(nil, nil, c.DeleteCurrent())
+	case "getOne":
+		v, err := e.tx.GetOne(s.table, s.k)
+		return result(nil, v, err)
+	case "has":
+		ok, err := e.tx.Has(s.table, s.k)
+		return fmt.Sprintf("%v %v", ok, err != nil)
+	case "count":
+		n, err := c.Count()
+		return fmt.Sprintf("%d %v", n, err != nil)
+	case "first":
+		return result(c.First())
+	case "last":
+		return result(c.Last())
+	case "seek":
+		return result(c.Seek(s.k))
+	case "seekExact":
+		return result(c.SeekExact(s.k))
+	case "next":
+		return result(c.Next())
+	case "prev":
+		return result(c.Prev())
+	case "current":
+		return result(c.Current())
+	case "seekBothExact":
+		return result(d.SeekBothExact(s.k, s.v))
+	case "seekBothRange":
+		v, err := d.SeekBothRange(s.k, s.v)
+		return result(nil, v, err)
+	case "firstDup":
+		v, err := d.FirstDup()
+		return result(nil, v, err)
+	case "lastDup":
+		v, err := d.LastDup()
+		return result(nil, v, err)
+	case "nextDup":
+		return result(d.NextDup())
+	case "nextNoDup":
+		return result(d.NextNoDup())
+	case "countDuplicates":
+		n, err := d.CountDuplicates()
+		return fmt.Sprintf("%d %v", n, err != nil)
+	case "putNoDupData":
+		return result(nil, nil, d.PutNoDupData(s.k, s.v))
+	case "deleteExact":
+		return result(nil, nil, d.DeleteExact(s.k, s.v))
+	case "deleteCurrentDuplicates":
+		return result(nil, nil, d.DeleteCurrentDuplicates())
+	}
+	t.Fatalf("unknown step %s", s)
+	return ""
+}
+
+// randKey - keys of a few values, so that steps hit existing records
+func randKey(r *rand.Rand, table string) []byte {
+	pick := func(n int, size int) []byte { return bytes.Repeat([]byte{byte(r.Intn(n) * 0x55)}, size) }
+	switch table {
+	case kv.PlainState:
+		if r.Intn(3) == 0 {
+			return pick(3, 20)
+		}
+		return append(append(pick(3, 20), pick(2, 8)...), pick(4, 32)...)
+	case kv.AccountChangeSet:
+		return pick(4, 8)
+	}
+	return pick(4, 1+r.Intn(2))
+}
+
+func randValue(r *rand.Rand) []byte {
+	v := make([]byte, 1+r.Intn(2))
+	for i := range v {
+		v[i] = byte(r.Intn(4) * 0x55)
+	}
+	return v
+}
+
+var (
+	diffWrites    = []string{"put", "put", "put", "delete", "deleteCurrent", "append"}
+	diffReads     = []string{"getOne", "has", "count", "first", "last", "seek", "seek", "seekExact", "next", "next", "prev", "current"}
+	diffDupWrites = []string{"putNoDupData", "deleteExact", "deleteCurrentDuplicates"}
+	// no firstDup: mdbx-go reads the key MDBX_FIRST_DUP leaves unset and panics
+	diffDupReads = []string{"seekBothExact", "seekBothRange", "lastDup", "nextDup", "nextNoDup", "countDuplicates"}
+)
+
+func randStep(r *rand.Rand, last func(table string) []byte) diffStep {
+	switch n := r.Intn(100); {
+	case n == 0:
+		return diffStep{op: "commit"}
+	case n == 1:
+		return diffStep{op: "rollback"}
+	}
+	s := diffStep{table: diffTables[r.Intn(len(diffTables))], k: nil, v: randValue(r)}
+	s.k = randKey(r, s.table)
+	ops := append(append([]string{}, diffWrites...), diffReads...)
+	if s.table == kv.AccountChangeSet {
+		ops = append(append(ops, diffDupWrites...), diffDupReads...)
+	}
+	s.op = ops[r.Intn(len(ops))]
+	if s.op == "append" {
+		switch {
+		case s.table == kv.PlainState:
+			// appends go to db format, their order is not the one of logical keys
+			s.op = "put"
+		case r.Intn(4) > 0 && last(s.table) != nil:
+			// mostly in order: after the last key, or after the last value of it in DupSort
+			s.k = append(copyBytes(last(s.table)), 0)
+			if s.table == kv.AccountChangeSet && r.Intn(2) == 0 {
+				s.k = copyBytes(last(s.table))
+				s.v = append(randValue(r), 0xff, 0xff)
+			}
+		case s.table == kv.AccountChangeSet:
+			// AppendDup of a key before the last one is not defined the same way by all engines
+			s.op = "put"
+		}
+	}
+	return s
+}
+
+func TestBtreeKVDifferential(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			r := rand.New(rand.NewSource(seed))
+			envs := []*diffEnv{{db: New()}, {db: NewMDBX()}}
+			for _, e := range envs {
+				defer e.db.Close()
+				e.begin(t)
+				defer func(e *diffEnv) { e.tx.Rollback() }(e)
+			}
+			last := func(table string) []byte {
+				c, err := envs[1].tx.Cursor(table)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c.Close()
+				k, _, _ := c.Last()
+				if table == kv.AccountChangeSet && k != nil {
+					return k[:8]
+				}
+				return k
+			}
+
+			positioned := map[string]bool{}
+			var log []string
+			for i := 0; i < 2000; i++ {
+				s := randStep(r, last)
+				if relativeSteps[s.op] && !positioned[s.table] {
+					s.op = "seek"
+				}
+				log = append(log, s.String())
+				have, want := s.apply(t, envs[0]), s.apply(t, envs[1])
+				if have != want {
+					t.Fatalf("step %d: memdb %q, mdbx %q, steps:\n%s", i, have, want, strings.Join(log, "\n"))
+				}
+				switch s.op {
+				case "first", "last", "seek", "seekExact", "next", "prev", "current", "seekBothExact", "seekBothRange",
+					"firstDup", "lastDup", "nextDup", "nextNoDup", "countDuplicates":
+					if !relativeSteps[s.op] {
+						positioned[s.table] = true
+					}
+					if strings.HasPrefix(have, "  ") || strings.HasPrefix(have, "0 ") {
+						positioned[s.table] = false // not found, engines leave the cursor at different places
+					}
+				case "commit", "rollback":
+					positioned = map[string]bool{}
+				default:
+					positioned[s.table] = false
+				}
+			}
+
+			for _, table := range diffTables {
+				var dumps [2][]string
+				for i, e := range envs {
+					if err := e.tx.ForEach(table, nil, func(k, v []byte) error {
+						dumps[i] = append(dumps[i], fmt.Sprintf("%x:%x", k, v))
+						return nil
+					}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if strings.Join(dumps[0], ",") != strings.Join(dumps[1], ",") {
+					t.Fatalf("%s differs:\nmemdb %v\nmdbx  %v", table, dumps[0], dumps[1])
+				}
+			}
+		})
+	}
+}
+
+func TestBtreeKVUnknownTable(t *testing.T) {
+	db := NewTestDB(t)
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, kv.ErrUnknownBucket) {
+			t.Fatalf("recovered %v, want %v", err, kv.ErrUnknownBucket)
+		}
+	}()
+	_ = tx.Put("NoSuchTable", []byte{1}, []byte{1})
+	t.Fatal("unknown table accepted")
+}
+
+func TestBtreeKVIsolation(t *testing.T) {
+	db := NewTestDB(t)
+	ctx := context.Background()
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Put(kv.HeaderCanonical, []byte{1}, []byte{1})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := db.BeginRo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Rollback()
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		if err := tx.Put(kv.HeaderCanonical, []byte{1}, []byte{2}); err != nil {
+			return err
+		}
+		return tx.Put(kv.HeaderCanonical, []byte{2}, []byte{2})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := ro.GetOne(kv.HeaderCanonical, []byte{1}); !bytes.Equal(v, []byte{1}) {
+		t.Fatalf("read tx sees %x of a later write", v)
+	}
+	if ok, _ := ro.Has(kv.HeaderCanonical, []byte{2}); ok {
+		t.Fatal("read tx sees a later insert")
+	}
+	// the read tx is a btreeTx underneath, its writes are refused at runtime
+	if err := ro.(kv.RwTx).Put(kv.HeaderCanonical, []byte{3}, nil); err == nil {
+		t.Fatal("read tx accepted a write")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+var ErrLayersMemoryLimit = errors.New("state layers memory limit reached")
+
+// LayerBase - persistent state under all layers (usually read tx of canonical chain)
+type LayerBase interface {
+	kv.Has
+	GetOne(bucket string, key []byte) (val []byte, err error)
+}
+
+type layerEntry struct {
+	val     []byte
+	deleted bool
+}
+
+type layerDiff map[string]map[string]layerEntry
+
+func (d layerDiff) get(table string, key []byte) (layerEntry, bool) {
+	t, ok := d[table]
+	if !ok {
+		return layerEntry{}, false
+	}
+	e, ok := t[string(key)]
+	return e, ok
+}
+
+func entrySize(key []byte, e layerEntry) uint64 {
+	return uint64(len(key) + len(e.val) + 1)
+}
+
+// Layer - immutable diff of 1 block on top of parent layer (or base if parent is nil).
+// Diffs are never modified after commit, so any amount of goroutines can build on top of same parent
+// concurrently - every child holds only own writes and reads parents through pointers, without copying.
+// Only the parent pointer changes: it's cut when the parent gets persisted, see LayerStore.Persisted.
+type Layer struct {
+	hash   types.Hash
+	parent atomic.Pointer[Layer]
+	diff   layerDiff
+	size   uint64
+}
+
+func (l *Layer) Hash() types.Hash { return l.hash }
+func (l *Layer) Parent() *Layer   { return l.parent.Load() }
+func (l *Layer) Size() uint64     { return l.size }
+
+func (l *Layer) lookup(table string, key []byte) (layerEntry, bool) {
+	for layer := l; layer != nil; layer = layer.Parent() {
+		if e, ok := layer.diff.get(table, key); ok {
+			return e, true
+		}
+	}
+	return layerEntry{}, false
+}
+
+// LayerTx - private mutable view of 1 block execution: own writes + parent layers + base.
+// Must be used by 1 goroutine. After LayerStore.Commit it must not be used.
+type LayerTx struct {
+	base   LayerBase
+	parent *Layer
+	diff   layerDiff
+	size   uint64
+}
+
+func (tx *LayerTx) GetOne(table string, key []byte) ([]byte, error) {
+	if e, ok := tx.diff.get(table, key); ok {
+		if e.deleted {
+			return nil, nil
+		}
+		return e.val, nil
+	}
+	if e, ok := tx.parent.lookup(table, key); ok {
+		if e.deleted {
+			return nil, nil
+		}
+		return e.val, nil
+	}
+	return tx.base.GetOne(table, key)
+}
+
+func (tx *LayerTx) Has(table string, key []byte) (bool, error) {
+	v, err := tx.GetOne(table, key)
+	if err != nil {
+		return false, err
+	}
+	return v != nil, nil
+}
+
+func (tx *LayerTx) Put(table string, k, v []byte) error {
+	tx.set(table, k, layerEntry{val: append([]byte{}, v...)})
+	return nil
+}
+
+func (tx *LayerTx) Delete(table string, k []byte) error {
+	tx.set(table, k, layerEntry{deleted: true})
+	return nil
+}
+
+func (tx *LayerTx) set(table string, k []byte, e layerEntry) {
+	t, ok := tx.diff[table]
+	if !ok {
+		t = map[string]layerEntry{}
+		tx.diff[table] = t
+	}
+	if old, ok := t[string(k)]; ok {
+		tx.size -= entrySize(k, old)
+	}
+	t[string(k)] = e
+	tx.size += entrySize(k, e)
+}
+
+// LayerStore - all live layers of not-yet-persisted blocks, keyed by block hash.
+// Tracks memory of all layers together and evicts layers of blocks which lost forkchoice.
+type LayerStore struct {
+	lock   sync.RWMutex
+	layers map[types.Hash]*Layer
+	size   uint64
+	peak   uint64
+	limit  uint64
+}
+
+// NewLayerStore - limit is max total size of all live layers in bytes, 0 means unlimited
+func NewLayerStore(limit uint64) *LayerStore {
+	return &LayerStore{layers: map[types.Hash]*Layer{}, limit: limit}
+}
+
+// Begin - creates private view on top of block `parent`. If parent has no layer - it's already persisted
+// and view reads base directly.
+func (s *LayerStore) Begin(base LayerBase, parent types.Hash) *LayerTx {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &LayerTx{base: base, parent: s.layers[parent], diff: layerDiff{}}
+}
+
+// Commit - freezes writes of tx into immutable layer of block `hash`
+func (s *LayerStore) Commit(tx *LayerTx, hash types.Hash) (*Layer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if existing, ok := s.layers[hash]; ok {
+		return existing, nil
+	}
+	if s.limit > 0 && s.size+tx.size > s.limit {
+		return nil, ErrLayersMemoryLimit
+	}
+	layer := &Layer{hash: hash, diff: tx.diff, size: tx.size}
+	layer.parent.Store(tx.parent)
+	tx.diff = nil
+	s.layers[hash] = layer
+	s.size += layer.size
+	if s.size > s.peak {
+		s.peak = s.size
+	}
+	return layer, nil
+}
+
+func (s *LayerStore) Get(hash types.Hash) (*Layer, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	l, ok := s.layers[hash]
+	return l, ok
+}
+
+// Size - total bytes held by live layers
+func (s *LayerStore) Size() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.size
+}
+
+// PeakSize - max value of Size since store creation
+func (s *LayerStore) PeakSize() uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.peak
+}
+
+// Len - amount of live layers
+func (s *LayerStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.layers)
+}
+
+// ForkChoice - keeps only layers of `head` ancestors and descendants, other blocks lost forkchoice.
+// If head has no layer it's already persisted: layers on top of base can't be told apart
+// from forks of other persisted blocks, so nothing is evicted.
+// Returns amount of evicted layers.
+func (s *LayerStore) ForkChoice(head types.Hash) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	headLayer, ok := s.layers[head]
+	if !ok {
+		return 0
+	}
+	keep := map[*Layer]struct{}{}
+	for l := headLayer; l != nil; l = l.Parent() {
+		keep[l] = struct{}{}
+	}
+	for _, l := range s.layers {
+		for p := l; p != nil; p = p.Parent() {
+			if p == headLayer {
+				keep[l] = struct{}{}
+				break
+			}
+		}
+	}
+
+	evicted := 0
+	for hash, l := range s.layers {
+		if _, ok := keep[l]; ok {
+			continue
+		}
+		delete(s.layers, hash)
+		s.size -= l.size
+		evicted++
+	}
+	return evicted
+}
+
+// Persisted - block `hash` and its ancestors were written to base: their layers are dropped and children
+// of `hash` read base directly. Layers forking off below `hash` are dropped too, base no longer holds the
+// state they were built on. If hash has no layer nothing is dropped.
+// Returns amount of dropped layers.
+func (s *LayerStore) Persisted(hash types.Hash) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	persisted, ok := s.layers[hash]
+	if !ok {
+		return 0
+	}
+	chain := map[*Layer]struct{}{}
+	for l := persisted; l != nil; l = l.Parent() {
+		chain[l] = struct{}{}
+	}
+	// a layer survives if the first persisted layer among its ancestors is `hash` itself
+	drop := map[*Layer]struct{}{}
+	var children []*Layer
+	for _, l := range s.layers {
+		if _, ok := chain[l]; ok {
+			drop[l] = struct{}{}
+			continue
+		}
+		p := l
+		for p != nil {
+			if _, ok := chain[p]; ok {
+				break
+			}
+			p = p.Parent()
+		}
+		if p != persisted {
+			drop[l] = struct{}{}
+		} else if l.Parent() == persisted {
+			children = append(children, l)
+		}
+	}
+
+	for l := range drop {
+		delete(s.layers, l.hash)
+		s.size -= l.size
+	}
+	for _, l := range children {
+		l.parent.Store(nil)
+	}
+	return len(drop)
+}
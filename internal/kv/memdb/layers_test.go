@@ -0,0 +1,219 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package memdb
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+type mapBase map[string][]byte
+
+func (b mapBase) GetOne(table string, key []byte) ([]byte, error) {
+	return b[table+string(key)], nil
+}
+
+func (b mapBase) Has(table string, key []byte) (bool, error) {
+	_, ok := b[table+string(key)]
+	return ok, nil
+}
+
+func TestLayersConcurrentSiblings(t *testing.T) {
+	const siblings = 16
+	base := mapBase{kv.PlainState + "shared": []byte("base"), kv.PlainState + "untouched": []byte("base")}
+	store := NewLayerStore(0)
+
+	parentHash := types.Hash{0xff}
+	parentTx := store.Begin(base, types.Hash{})
+	_ = parentTx.Put(kv.PlainState, []byte("shared"), []byte("parent"))
+	_ = parentTx.Put(kv.PlainState, []byte("parent-only"), bytes.Repeat([]byte{1}, 1024))
+	parent, err := store.Commit(parentTx, parentHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, siblings)
+	for i := 0; i < siblings; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx := store.Begin(base, parentHash)
+			v, _ := tx.GetOne(kv.PlainState, []byte("shared"))
+			if string(v) != "parent" {
+				errs <- fmt.Errorf("sibling %d: parent write not visible: %s", i, v)
+				return
+			}
+			own := []byte(fmt.Sprintf("sibling-%d", i))
+			_ = tx.Put(kv.PlainState, []byte("shared"), own)
+			_ = tx.Put(kv.PlainState, own, own)
+			_ = tx.Delete(kv.PlainState, []byte("untouched"))
+			if _, err := store.Commit(tx, types.Hash{byte(i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < siblings; i++ {
+		layer, ok := store.Get(types.Hash{byte(i)})
+		if !ok {
+			t.Fatalf("layer of sibling %d not found", i)
+		}
+		if layer.Parent() != parent {
+			t.Fatalf("sibling %d must share parent layer", i)
+		}
+		tx := store.Begin(base, types.Hash{byte(i)})
+		own := fmt.Sprintf("sibling-%d", i)
+		if v, _ := tx.GetOne(kv.PlainState, []byte("shared")); string(v) != own {
+			t.Fatalf("sibling %d sees %s instead of own write", i, v)
+		}
+		for j := 0; j < siblings; j++ {
+			if j == i {
+				continue
+			}
+			if ok, _ := tx.Has(kv.PlainState, []byte(fmt.Sprintf("sibling-%d", j))); ok {
+				t.Fatalf("sibling %d sees write of sibling %d", i, j)
+			}
+		}
+		if ok, _ := tx.Has(kv.PlainState, []byte("untouched")); ok {
+			t.Fatalf("sibling %d sees deleted key", i)
+		}
+	}
+	// parent is not affected by children
+	tx := store.Begin(base, parentHash)
+	if v, _ := tx.GetOne(kv.PlainState, []byte("shared")); string(v) != "parent" {
+		t.Fatalf("parent layer changed: %s", v)
+	}
+
+	// siblings reference parent diff without copying it
+	var childrenSize uint64
+	for i := 0; i < siblings; i++ {
+		l, _ := store.Get(types.Hash{byte(i)})
+		childrenSize += l.Size()
+	}
+	if store.PeakSize() != parent.Size()+childrenSize {
+		t.Fatalf("peak overlay memory: have %d, want %d", store.PeakSize(), parent.Size()+childrenSize)
+	}
+	if store.PeakSize() > parent.Size()+siblings*128 {
+		t.Fatalf("peak overlay memory too big: %d", store.PeakSize())
+	}
+
+	// forkchoice picks sibling 3, others lost
+	if evicted := store.ForkChoice(types.Hash{3}); evicted != siblings-1 {
+		t.Fatalf("evicted %d layers, want %d", evicted, siblings-1)
+	}
+	if store.Len() != 2 || store.Size() != parent.Size()+mustGet(t, store, types.Hash{3}).Size() {
+		t.Fatalf("unexpected live layers: %d, size %d", store.Len(), store.Size())
+	}
+}
+
+func mustGet(t *testing.T, s *LayerStore, h types.Hash) *Layer {
+	t.Helper()
+	l, ok := s.Get(h)
+	if !ok {
+		t.Fatalf("layer %x not found", h)
+	}
+	return l
+}
+
+func TestLayersForkChoicePersistedHead(t *testing.T) {
+	store := NewLayerStore(0)
+	for i := byte(1); i <= 2; i++ {
+		tx := store.Begin(mapBase{}, types.Hash{})
+		_ = tx.Put(kv.PlainState, []byte("k"), []byte{i})
+		if _, err := store.Commit(tx, types.Hash{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// head 9 is persisted, the layers on top of it are not known
+	if evicted := store.ForkChoice(types.Hash{9}); evicted != 0 {
+		t.Fatalf("evicted %d layers, want 0", evicted)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("unexpected live layers: %d", store.Len())
+	}
+	if evicted := store.ForkChoice(types.Hash{2}); evicted != 1 || store.Len() != 1 {
+		t.Fatalf("evicted %d layers, %d live, want 1 1", evicted, store.Len())
+	}
+}
+
+func TestLayersMemoryLimit(t *testing.T) {
+	store := NewLayerStore(100)
+	tx := store.Begin(mapBase{}, types.Hash{})
+	_ = tx.Put(kv.PlainState, []byte("k"), bytes.Repeat([]byte{1}, 200))
+	if _, err := store.Commit(tx, types.Hash{1}); err != ErrLayersMemoryLimit {
+		t.Fatalf("expected memory limit error, got %v", err)
+	}
+}
+
+func TestLayersPersisted(t *testing.T) {
+	base := mapBase{}
+	store := NewLayerStore(0)
+	commit := func(parent, hash types.Hash, val string) *Layer {
+		t.Helper()
+		tx := store.Begin(base, parent)
+		_ = tx.Put(kv.PlainState, []byte("k"), []byte(val))
+		_ = tx.Put(kv.PlainState, []byte(val), bytes.Repeat([]byte{1}, 100))
+		l, err := store.Commit(tx, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	// 1 <- 2 <- 3 <- 4, fork 1 <- 5 below the persisted block 2, fork 2 <- 6 above it
+	commit(types.Hash{}, types.Hash{1}, "1")
+	commit(types.Hash{1}, types.Hash{2}, "2")
+	three := commit(types.Hash{2}, types.Hash{3}, "3")
+	four := commit(types.Hash{3}, types.Hash{4}, "4")
+	commit(types.Hash{1}, types.Hash{5}, "5")
+	six := commit(types.Hash{2}, types.Hash{6}, "6")
+
+	if dropped := store.Persisted(types.Hash{9}); dropped != 0 || store.Len() != 6 {
+		t.Fatalf("unknown block: dropped %d, %d live", dropped, store.Len())
+	}
+	before := store.Size()
+	if dropped := store.Persisted(types.Hash{2}); dropped != 3 || store.Len() != 3 {
+		t.Fatalf("dropped %d layers, %d live, want 3 3", dropped, store.Len())
+	}
+	if want := three.Size() + four.Size() + six.Size(); store.Size() != want || store.Size() >= before {
+		t.Fatalf("size %d after persisting, was %d, want %d", store.Size(), before, want)
+	}
+	if three.Parent() != nil || six.Parent() != nil || four.Parent() != three {
+		t.Fatal("children of the persisted block not re-parented onto base")
+	}
+
+	// children read the persisted block from base now
+	base[kv.PlainState+"2"] = []byte("persisted")
+	tx := store.Begin(base, types.Hash{4})
+	if v, _ := tx.GetOne(kv.PlainState, []byte("2")); string(v) != "persisted" {
+		t.Fatalf("have %q, want value of base", v)
+	}
+	if v, _ := tx.GetOne(kv.PlainState, []byte("k")); string(v) != "4" {
+		t.Fatalf("have %q, want value of layer 4", v)
+	}
+}
@@ -23,7 +23,13 @@ import (
 	"testing"
 )
 
+// New - chaindata db kept in memory btrees, see BtreeKV
 func New() kv.RwDB {
+	return NewBtreeKV(kv.ChainDB, kv.ChaindataTablesCfg)
+}
+
+// NewMDBX - chaindata db in an in-memory MDBX file, for tests of MDBX itself or of behaviour BtreeKV doesn't have
+func NewMDBX() kv.RwDB {
 	return mdbx.NewMDBX().InMem().MustOpen()
 }
 
@@ -339,7 +339,7 @@ func (m *MemoryMutation) Flush(tx kv.RwTx) error {
 
 // Check if a bucket is dupsorted and has dupsort conversion off
 func isTablePurelyDupsort(bucket string) bool {
-	config, ok := kv.ChaindataTablesCfg[bucket]
+	config, ok := kv.Lookup(bucket)
 	// If we do not have the configuration we assume it is not dupsorted
 	if !ok {
 		return false
@@ -133,7 +133,7 @@ func (m *memoryMutationCursor) getNextOnDb(t NextType) (key []byte, value []byte
 }
 
 func (m *memoryMutationCursor) convertAutoDupsort(key []byte, value []byte) []byte {
-	config, ok := kv.ChaindataTablesCfg[m.table]
+	config, ok := kv.Lookup(m.table)
 	// If we do not have the configuration we assume it is not dupsorted
 	if !ok || !config.AutoDupSortKeysConversion {
 		return key
@@ -155,7 +155,7 @@ func (m *memoryMutationCursor) Current() ([]byte, []byte, error) {
 func (m *memoryMutationCursor) skipIntersection(memKey, memValue, dbKey, dbValue []byte, t NextType) (newDbKey []byte, newDbValue []byte, err error) {
 	newDbKey = dbKey
 	newDbValue = dbValue
-	config, ok := kv.ChaindataTablesCfg[m.table]
+	config, ok := kv.Lookup(m.table)
 	dupSortTable := ok && ((config.Flags & kv.DupSort) != 0)
 	autoKeyConversion := ok && config.AutoDupSortKeysConversion
 	dupsortOffset := 0
@@ -347,7 +347,7 @@ func (m *memoryMutationCursor) DeleteExact(k1, k2 []byte) error {
 }
 
 func (m *memoryMutationCursor) DeleteCurrentDuplicates() error {
-	config, ok := kv.ChaindataTablesCfg[m.table]
+	config, ok := kv.Lookup(m.table)
 	autoKeyConversion := ok && config.AutoDupSortKeysConversion
 	if autoKeyConversion {
 		panic("DeleteCurrentDuplicates Not implemented for AutoDupSortKeysConversion tables")
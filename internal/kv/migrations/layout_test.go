@@ -0,0 +1,73 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// TestChaindataDBILayout guards the DBIs of existing dbs: testdata/chaindata_dbi_layout.txt lists the tables
+// in the order they got DBIs. A table may only leave ChaindataTables deprecated or dropped by a migration,
+// and new tables are appended to the file, after all the existing ones.
+func TestChaindataDBILayout(t *testing.T) {
+	data, err := os.ReadFile("testdata/chaindata_dbi_layout.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := strings.Fields(string(data))
+
+	current := make(map[string]bool)
+	for _, name := range kv.Tables(kv.TableGroupChaindata) {
+		current[name] = true
+	}
+	retired := make(map[string]bool)
+	for _, name := range kv.ChaindataDeprecatedTables {
+		retired[name] = true
+	}
+	for _, m := range Migrations {
+		for _, name := range m.Drops {
+			retired[name] = true
+		}
+	}
+
+	layout := make(kv.DBILayout, len(golden))
+	for i, name := range golden {
+		if _, ok := layout[name]; ok {
+			t.Fatalf("%s is listed twice", name)
+		}
+		layout[name] = kv.DBI(i + 2) // 0 and 1 are the free and main DBIs of mdbx
+		if !current[name] && !retired[name] {
+			t.Errorf("%s left ChaindataTables but is neither deprecated nor dropped by a migration", name)
+		}
+	}
+	for name := range current {
+		if _, ok := layout[name]; !ok {
+			t.Errorf("%s is not in testdata/chaindata_dbi_layout.txt, append it", name)
+		}
+	}
+
+	order, _ := kv.DBIOrder(layout, kv.Tables(kv.TableGroupChaindata))
+	for i, name := range golden {
+		if order[i] != name {
+			t.Fatalf("table %d opens as %s, recorded as %s", i, order[i], name)
+		}
+	}
+}
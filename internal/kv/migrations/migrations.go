@@ -0,0 +1,252 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package migrations rewrites stored records when their layout changes. Migrations run in order at
+// startup, each one once: a finished migration is recorded in kv.Migrations under its name, with the
+// stage progress at the time it ran, for bug reports.
+package migrations
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// progressPrefix - kv.Migrations key prefix of the progress committed by an unfinished migration
+const progressPrefix = "_progress_"
+
+// CommitFunc - makes the progress of a migration durable: saves progress, commits the tx and returns
+// the tx the migration continues in. A migration interrupted later resumes from progress. rows are the
+// records gone through since the last commit, they drive the duration estimate.
+type CommitFunc func(progress []byte, rows uint64) (kv.RwTx, error)
+
+// Kind - what a migration does to the stored records
+type Kind uint8
+
+const (
+	ReadOnly    Kind = iota // checks records, writes nothing but its own record
+	Additive                // writes new records, existing ones stay as they are
+	Destructive             // rewrites or deletes existing records, needs approval, see Approval
+)
+
+func (k Kind) String() string {
+	switch k {
+	case ReadOnly:
+		return "read-only"
+	case Additive:
+		return "additive"
+	case Destructive:
+		return "destructive"
+	}
+	return fmt.Sprintf("kind(%d)", uint8(k))
+}
+
+// Migration - a rewrite of stored records
+type Migration struct {
+	Name string
+	Kind Kind
+	// Touches - tables the migration reads or rewrites. Their records are the rows of the estimate, and a
+	// destructive migration may need their size again until freed pages are reused.
+	Touches []string
+	// Drops - tables the migration drops, they leave ChaindataTables and the DBI layout of the db,
+	// see testdata/chaindata_dbi_layout.txt
+	Drops []string
+	// Up rewrites the records, from progress on if an earlier run committed some, nil otherwise. Long
+	// rewrites commit intermediate progress through commit and go on in the tx it returns.
+	Up func(tx kv.RwTx, progress []byte, commit CommitFunc) error
+}
+
+// Migrations - registered migrations in the order they are applied
+var Migrations = []Migration{
+	receiptsV2,
+	nonCanonicalTxs,
+}
+
+// Apply - runs the registered migrations not recorded in db yet, then writes kv.CurrentSchemaVersion.
+// A db written by a newer major version is refused with kv.ErrSchemaIncompatible. Pending destructive
+// migrations only run once approval accepts their dry run, see Approval.
+func Apply(ctx context.Context, db kv.RwDB, approval Approval) error {
+	return apply(ctx, db, Migrations, approval)
+}
+
+// Upgrade - Apply as kv.SchemaUpgrade, for mdbx.MdbxOpts.CheckSchema
+func Upgrade(approval Approval) kv.SchemaUpgrade {
+	return func(ctx context.Context, db kv.RwDB) error {
+		return Apply(ctx, db, approval)
+	}
+}
+
+func apply(ctx context.Context, db kv.RwDB, migrations []Migration, approval Approval) error {
+	seen := make(map[string]struct{}, len(migrations))
+	for _, m := range migrations {
+		if _, ok := seen[m.Name]; ok {
+			return fmt.Errorf("migration %s registered twice", m.Name)
+		}
+		seen[m.Name] = struct{}{}
+	}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		v, ok, err := kv.ReadSchemaVersion(tx)
+		if err == nil && ok && v.Major > kv.CurrentSchemaVersion.Major {
+			err = fmt.Errorf("%w: db has %s, binary supports %d.x", kv.ErrSchemaIncompatible, v, kv.CurrentSchemaVersion.Major)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+
+	plan, err := DryRun(ctx, db, migrations)
+	if err != nil {
+		return err
+	}
+	if err := approval.check(plan); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := run(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+	return db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, kv.CurrentSchemaVersion.Bytes())
+	})
+}
+
+func run(ctx context.Context, db kv.RwDB, m Migration) error {
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { tx.Rollback() }()
+
+	if done, err := applied(tx, m.Name); err != nil || done {
+		return err
+	}
+	progress, err := loadProgress(tx, m.Name)
+	if err != nil {
+		return err
+	}
+
+	commit := func(progress []byte, rows uint64) (kv.RwTx, error) {
+		if err := tx.Put(kv.Migrations, []byte(progressPrefix+m.Name), progress); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if tx, err = db.BeginRw(ctx); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}
+	if err := m.Up(tx, progress, commit); err != nil {
+		return err
+	}
+
+	stages, err := encodeStages(tx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(kv.Migrations, []byte(m.Name), stages); err != nil {
+		return err
+	}
+	if err := tx.Delete(kv.Migrations, []byte(progressPrefix+m.Name)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func loadProgress(tx kv.Getter, name string) ([]byte, error) {
+	v, err := tx.GetOne(kv.Migrations, []byte(progressPrefix+name))
+	if err != nil || len(v) == 0 {
+		return nil, err
+	}
+	return append([]byte{}, v...), nil
+}
+
+func applied(tx kv.Getter, name string) (bool, error) {
+	return tx.Has(kv.Migrations, []byte(name))
+}
+
+// Applied - stage progress recorded by the migration when it finished, ok=false if it didn't run yet
+func Applied(tx kv.Getter, name string) (stages map[string][]byte, ok bool, err error) {
+	if ok, err := applied(tx, name); err != nil || !ok {
+		return nil, false, err
+	}
+	v, err := tx.GetOne(kv.Migrations, []byte(name))
+	if err != nil {
+		return nil, false, err
+	}
+	stages, err = decodeStages(v)
+	if err != nil {
+		return nil, false, fmt.Errorf("migration %s: %w", name, err)
+	}
+	return stages, true, nil
+}
+
+// encodeStages - kv.SyncStageProgress records as uvarint length-prefixed key and value pairs.
+// Empty for a db without the table, the node db keeps no stages.
+func encodeStages(tx kv.RwTx) ([]byte, error) {
+	var buf []byte
+	if ok, err := tx.ExistsBucket(kv.SyncStageProgress); err != nil || !ok {
+		return []byte{}, err
+	}
+	if err := tx.ForEach(kv.SyncStageProgress, nil, func(k, v []byte) error {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+func decodeStages(v []byte) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	next := func() ([]byte, error) {
+		n, l := binary.Uvarint(v)
+		if l <= 0 || n > uint64(len(v)-l) {
+			return nil, fmt.Errorf("malformed stages record")
+		}
+		field := v[l : l+int(n)]
+		v = v[l+int(n):]
+		return field, nil
+	}
+	for len(v) > 0 {
+		k, err := next()
+		if err != nil {
+			return nil, err
+		}
+		val, err := next()
+		if err != nil {
+			return nil, err
+		}
+		res[string(k)] = append([]byte{}, val...)
+	}
+	return res, nil
+}
@@ -0,0 +1,394 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+var errCrash = errors.New("simulated crash")
+
+type txSigner func(nonce uint64) (data []byte, hash types.Hash)
+
+// newTxSigner - signs with a fixed key, fixture dbs of separate runs hold the same records
+func newTxSigner(t *testing.T) txSigner {
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	signer := transaction.NewLondonSigner(params.AllEthashProtocolChanges.ChainID)
+	chainID, _ := uint256.FromBig(params.AllEthashProtocolChanges.ChainID)
+	return func(nonce uint64) ([]byte, types.Hash) {
+		to := types.Address{0xee}
+		txn, err := transaction.SignNewTx(key, signer, &transaction.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: uint256.NewInt(1),
+			GasFeeCap: uint256.NewInt(10),
+			Gas:       21000,
+			From:      &from,
+			To:        &to,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := txn.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, txn.Hash()
+	}
+}
+
+type fixtureBlock struct {
+	num       uint64
+	hash      types.Hash
+	canonical bool
+	nonces    []uint64
+}
+
+// fixtureBlocks - in insertion order. Side blocks at 2, 4 and 5, the one at 4 shares a transaction with the canonical block.
+var fixtureBlocks = []fixtureBlock{
+	{1, types.Hash{1}, true, []uint64{1, 2}},
+	{2, types.Hash{2}, true, []uint64{3}},
+	{2, types.Hash{2, 2}, false, []uint64{4, 5}},
+	{3, types.Hash{3}, true, nil},
+	{4, types.Hash{4, 4}, false, []uint64{6, 7}},
+	{4, types.Hash{4}, true, []uint64{7, 8}},
+	{5, types.Hash{5}, true, []uint64{9}},
+	{5, types.Hash{5, 5}, false, []uint64{10}},
+	{6, types.Hash{6}, true, []uint64{11}},
+}
+
+// openFixtureDB - blocks in the layout before schema 5.0: the transactions of all blocks in EthTx under one
+// sequence, every block with a system transaction slot before and after its transactions
+func openFixtureDB(t *testing.T) (kv.RwDB, map[uint64]types.Hash) {
+	sign := newTxSigner(t)
+	hashes := make(map[uint64]types.Hash)
+	db := memdb.NewTestDB(t)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		var seq uint64
+		for _, b := range fixtureBlocks {
+			base := seq
+			seq += uint64(len(b.nonces)) + 2
+			if err := tx.Put(kv.EthTx, kv.EncodeBlockNum(base), []byte("system tx")); err != nil {
+				return err
+			}
+			for i, nonce := range b.nonces {
+				data, hash := sign(nonce)
+				hashes[nonce] = hash
+				if err := tx.Put(kv.EthTx, kv.EncodeBlockNum(base+1+uint64(i)), data); err != nil {
+					return err
+				}
+				if err := rawdb.WriteTxLookupEntry(tx, hash, b.num); err != nil {
+					return err
+				}
+			}
+			body := append(kv.EncodeBlockNum(base), 0, 0, 0, byte(len(b.nonces)+2))
+			if err := kv.WriteBodyForStorage(tx, b.num, b.hash[:], body); err != nil {
+				return err
+			}
+			if b.canonical {
+				if err := tx.Put(kv.HeaderCanonical, kv.EncodeBlockNum(b.num), b.hash[:]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Put(kv.Sequence, []byte(kv.EthTx), kv.EncodeBlockNum(seq)); err != nil {
+			return err
+		}
+		return tx.Put(kv.SyncStageProgress, []byte("Execution"), kv.EncodeBlockNum(6))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return db, hashes
+}
+
+func dumpTables(t *testing.T, db kv.RoDB, tables ...string) map[string]map[string]string {
+	res := make(map[string]map[string]string)
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		for _, table := range tables {
+			res[table] = make(map[string]string)
+			if err := tx.ForEach(table, nil, func(k, v []byte) error {
+				res[table][string(k)] = string(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+var approved = Approval{Approved: true}
+
+var migratedTables = []string{kv.EthTx, kv.NonCanonicalTxs, kv.BlockBody, kv.TxLookup, kv.Sequence}
+
+func TestNonCanonicalTxs(t *testing.T) {
+	db, hashes := openFixtureDB(t)
+	before := dumpTables(t, db, kv.EthTx)
+	if err := Apply(context.Background(), db, approved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		for _, b := range fixtureBlocks {
+			v, err := kv.ReadBodyForStorage(tx, b.num, b.hash[:])
+			if err != nil {
+				return err
+			}
+			body, err := decodeBody(v)
+			if err != nil {
+				return err
+			}
+			table := kv.EthTx
+			if !b.canonical {
+				table = kv.NonCanonicalTxs
+			}
+			if ok, err := tx.Has(table, kv.EncodeBlockNum(body.first)); err != nil || !ok {
+				t.Fatalf("block %d %x: system transaction missing from %s", b.num, b.hash[:2], table)
+			}
+			for i, nonce := range b.nonces {
+				data, err := tx.GetOne(table, kv.EncodeBlockNum(body.first+1+uint64(i)))
+				if err != nil {
+					return err
+				}
+				txn := new(transaction.Transaction)
+				if err := txn.Unmarshal(data); err != nil || txn.Hash() != hashes[nonce] {
+					t.Fatalf("block %d %x: transaction %d not in %s: %v", b.num, b.hash[:2], nonce, table, err)
+				}
+			}
+		}
+		// side blocks free their EthTx ids and take the first NonCanonicalTxs ids
+		n := 0
+		if err := tx.ForEach(kv.EthTx, nil, func(k, v []byte) error { n++; return nil }); err != nil || n != len(before[kv.EthTx])-8 {
+			t.Fatalf("%d transactions left in EthTx, err %v", n, err)
+		}
+		if seq, err := rawdb.ReadSequence(tx, kv.NonCanonicalTxs); err != nil || seq != 4+4+3 {
+			t.Fatalf("NonCanonicalTxs sequence %d, err %v, want 11", seq, err)
+		}
+
+		for nonce, want := range map[uint64]bool{1: true, 4: false, 5: false, 6: false, 7: true, 8: true, 10: false} {
+			entry, err := rawdb.ReadTxLookupEntry(tx, hashes[nonce])
+			if err != nil {
+				return err
+			}
+			if (entry != nil) != want {
+				t.Fatalf("lookup entry of transaction %d: %v, want present %v", nonce, entry, want)
+			}
+		}
+
+		stages, ok, err := Applied(tx, nonCanonicalTxs.Name)
+		if err != nil || !ok || !bytes.Equal(stages["Execution"], kv.EncodeBlockNum(6)) {
+			t.Fatalf("recorded stages %v, ok %v, err %v", stages, ok, err)
+		}
+		if v, ok, err := kv.ReadSchemaVersion(tx); err != nil || !ok || v != kv.CurrentSchemaVersion {
+			t.Fatalf("schema version %s, ok %v, err %v", v, ok, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// recorded, not run again
+	migrated := dumpTables(t, db, migratedTables...)
+	runs := 0
+	m := nonCanonicalTxs
+	up := m.Up
+	m.Up = func(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+		runs++
+		return up(tx, progress, commit)
+	}
+	if err := apply(context.Background(), db, []Migration{m}, approved); err != nil || runs != 0 {
+		t.Fatalf("second apply: runs %d, err %v", runs, err)
+	}
+	if !reflect.DeepEqual(migrated, dumpTables(t, db, migratedTables...)) {
+		t.Fatal("second apply changed the tables")
+	}
+}
+
+func TestNonCanonicalTxsResume(t *testing.T) {
+	defer func(n int) { commitEvery = n }(commitEvery)
+	commitEvery = 2
+
+	ref, _ := openFixtureDB(t)
+	if err := Apply(context.Background(), ref, approved); err != nil {
+		t.Fatal(err)
+	}
+	want := dumpTables(t, ref, migratedTables...)
+
+	// 9 bodies in batches of 2 commit 4 times: crash after each intermediate commit, and no crash
+	for crashAfter := 1; crashAfter <= 5; crashAfter++ {
+		db, _ := openFixtureDB(t)
+		m := nonCanonicalTxs
+		m.Up = func(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+			commits := 0
+			return moveNonCanonicalTxs(tx, progress, func(progress []byte, rows uint64) (kv.RwTx, error) {
+				tx, err := commit(progress, rows)
+				if commits++; err == nil && commits == crashAfter {
+					tx.Rollback()
+					return nil, errCrash
+				}
+				return tx, err
+			})
+		}
+		err := apply(context.Background(), db, []Migration{m}, approved)
+		if crashAfter == 5 {
+			if err != nil {
+				t.Fatalf("crash after %d commits: %v", crashAfter, err)
+			}
+		} else if !errors.Is(err, errCrash) {
+			t.Fatalf("crash after %d commits: err %v", crashAfter, err)
+		}
+
+		// a restart which lost the progress scans again from the start and skips the moved bodies
+		lost := crashAfter%2 == 0
+		if lost {
+			if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+				return tx.Delete(kv.Migrations, []byte(progressPrefix+m.Name))
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var resumed []byte
+		m.Up = func(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+			resumed = progress
+			return moveNonCanonicalTxs(tx, progress, commit)
+		}
+		if err := apply(context.Background(), db, []Migration{m}, approved); err != nil {
+			t.Fatal(err)
+		}
+		if crashAfter < 5 && !lost && resumed == nil {
+			t.Fatalf("crash after %d commits: restart didn't resume", crashAfter)
+		}
+		if got := dumpTables(t, db, migratedTables...); !reflect.DeepEqual(got, want) {
+			t.Fatalf("crash after %d commits: tables differ from an uninterrupted migration", crashAfter)
+		}
+	}
+}
+
+func TestApplySchemaVersion(t *testing.T) {
+	// db in the 5.0 layout already: side blocks are not touched
+	db, _ := openFixtureDB(t)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, kv.SchemaVersion{Major: 5}.Bytes())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := dumpTables(t, db, migratedTables...)
+	if err := Apply(context.Background(), db, approved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, dumpTables(t, db, migratedTables...)) {
+		t.Fatal("migration rewrote a 5.0 db")
+	}
+
+	// written by a newer binary
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, kv.SchemaVersion{Major: kv.CurrentSchemaVersion.Major + 1}.Bytes())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(context.Background(), db, approved); !errors.Is(err, kv.ErrSchemaIncompatible) {
+		t.Fatalf("newer db: err %v", err)
+	}
+
+	if err := apply(context.Background(), memdb.NewTestDB(t), []Migration{nonCanonicalTxs, nonCanonicalTxs}, approved); err == nil {
+		t.Fatal("duplicate migration accepted")
+	}
+}
+
+func TestUpgradeOnOpen(t *testing.T) {
+	ctx := context.Background()
+	var upgrades int
+	upgrade := func(ctx context.Context, db kv.RwDB) error {
+		upgrades++
+		return Upgrade(approved)(ctx, db)
+	}
+	readVersion := func(db kv.RwDB) (v kv.SchemaVersion) {
+		if err := db.View(ctx, func(tx kv.Tx) (err error) {
+			v, _, err = kv.ReadSchemaVersion(tx)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	setVersion := func(db kv.RwDB, v kv.SchemaVersion) {
+		if err := db.Update(ctx, func(tx kv.RwTx) error {
+			return tx.Put(kv.DatabaseInfo, kv.DBSchemaVersionKey, v.Bytes())
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fresh db gets the current version without migrations
+	db := memdb.NewTestDB(t)
+	if err := kv.EnforceSchemaVersion(ctx, db, upgrade); err != nil || upgrades != 0 {
+		t.Fatalf("fresh db: err %v, upgrades %d", err, upgrades)
+	}
+	if v := readVersion(db); v != kv.CurrentSchemaVersion {
+		t.Fatalf("fresh db: version %s", v)
+	}
+
+	// unversioned db holding blocks is migrated
+	db, _ = openFixtureDB(t)
+	if err := kv.EnforceSchemaVersion(ctx, db, upgrade); err != nil || upgrades != 1 {
+		t.Fatalf("legacy db: err %v, upgrades %d", err, upgrades)
+	}
+	if v := readVersion(db); v != kv.CurrentSchemaVersion {
+		t.Fatalf("legacy db: version %s", v)
+	}
+
+	// older minor runs migrations, unless there is nothing to run them
+	defer func(v kv.SchemaVersion) { kv.CurrentSchemaVersion = v }(kv.CurrentSchemaVersion)
+	older := kv.CurrentSchemaVersion
+	kv.CurrentSchemaVersion.Minor++
+	if err := kv.EnforceSchemaVersion(ctx, db, nil); !errors.Is(err, kv.ErrSchemaIncompatible) {
+		t.Fatalf("older minor without upgrade: err %v", err)
+	}
+	if err := kv.EnforceSchemaVersion(ctx, db, upgrade); err != nil || upgrades != 2 {
+		t.Fatalf("older minor: err %v, upgrades %d", err, upgrades)
+	}
+	if v := readVersion(db); v != kv.CurrentSchemaVersion {
+		t.Fatalf("older minor: version %s", v)
+	}
+
+	// a newer db is refused before migrations
+	kv.CurrentSchemaVersion = older
+	setVersion(db, kv.SchemaVersion{Major: older.Major + 1})
+	if err := kv.EnforceSchemaVersion(ctx, db, upgrade); !errors.Is(err, kv.ErrDowngradeNotAllowed) || upgrades != 2 {
+		t.Fatalf("newer major: err %v, upgrades %d", err, upgrades)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// nonCanonicalTxs - schema 5.0: EthTx keeps only the transactions of canonical blocks, the ones of other
+// blocks move to NonCanonicalTxs under ids of its own sequence. The progress is the BlockBody key of the
+// last body looked at.
+var nonCanonicalTxs = Migration{
+	Name:    "noncanonical_txs",
+	Kind:    Destructive,
+	Touches: []string{kv.BlockBody, kv.EthTx},
+	Up:      moveNonCanonicalTxs,
+}
+
+// commitEvery - bodies looked at between intermediate commits
+var commitEvery = 10_000
+
+var errBatchFull = errors.New("batch full")
+
+// txRange - ids [first, first+amount) of a body
+type txRange struct {
+	first  uint64
+	amount uint32
+}
+
+func moveNonCanonicalTxs(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+	if v, ok, err := kv.ReadSchemaVersion(tx); err != nil {
+		return err
+	} else if ok && v.Major >= 5 {
+		return nil
+	}
+
+	// moves don't touch canonical bodies, the ranges stay valid across commits
+	canonical, err := canonicalRanges(tx)
+	if err != nil {
+		return err
+	}
+	for {
+		var moves [][]byte
+		scanned := 0
+		err := tx.ForEach(kv.BlockBody, progress, func(k, v []byte) error {
+			if progress != nil && bytes.Equal(k, progress) {
+				return nil
+			}
+			if scanned == commitEvery {
+				return errBatchFull
+			}
+			scanned++
+			progress = append([]byte{}, k...)
+
+			num, hash, err := kv.ParseHeaderKey(k)
+			if err != nil {
+				return err
+			}
+			canonicalHash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(num))
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(canonicalHash, hash) {
+				return nil
+			}
+			body, err := decodeBody(v)
+			if err != nil {
+				return fmt.Errorf("body of block %d %x: %w", num, hash, err)
+			}
+			// bodies sharing ids with canonical ones are in the 5.0 layout already, and so are the ones
+			// moved by an interrupted run: EthTx holds none of their ids
+			if body.amount == 0 || canonical.overlaps(body) {
+				return nil
+			}
+			if ok, err := inEthTx(tx, body); err != nil || !ok {
+				return err
+			}
+			moves = append(moves, progress)
+			return nil
+		})
+		full := errors.Is(err, errBatchFull)
+		if err != nil && !full {
+			return err
+		}
+		for _, k := range moves {
+			if err := moveBodyTxs(tx, k, canonical); err != nil {
+				return err
+			}
+		}
+		if !full {
+			return nil
+		}
+		if tx, err = commit(progress, uint64(scanned)); err != nil {
+			return err
+		}
+	}
+}
+
+func decodeBody(v []byte) (txRange, error) {
+	if len(v) != 8+4 {
+		return txRange{}, fmt.Errorf("unexpected length %d, want 12", len(v))
+	}
+	return txRange{first: binary.BigEndian.Uint64(v), amount: binary.BigEndian.Uint32(v[8:])}, nil
+}
+
+// inEthTx - whether EthTx holds a transaction of body
+func inEthTx(tx kv.Tx, body txRange) (bool, error) {
+	for i := uint64(0); i < uint64(body.amount); i++ {
+		if ok, err := tx.Has(kv.EthTx, kv.EncodeBlockNum(body.first+i)); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// bodyRanges - id ranges of the non-empty canonical bodies by block number, their ids ascend along the chain
+type bodyRanges []txRange
+
+func canonicalRanges(tx kv.Tx) (bodyRanges, error) {
+	var res bodyRanges
+	if err := tx.ForEach(kv.HeaderCanonical, nil, func(k, hash []byte) error {
+		num, err := kv.DecodeBlockNum(k)
+		if err != nil {
+			return err
+		}
+		v, err := kv.ReadBodyForStorage(tx, num, hash)
+		if err != nil || v == nil {
+			return err
+		}
+		body, err := decodeBody(v)
+		if err != nil {
+			return fmt.Errorf("body of canonical block %d: %w", num, err)
+		}
+		if body.amount > 0 {
+			res = append(res, body)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r bodyRanges) overlaps(body txRange) bool {
+	end := body.first + uint64(body.amount)
+	i := sort.Search(len(r), func(i int) bool { return r[i].first+uint64(r[i].amount) > body.first })
+	return i < len(r) && r[i].first < end
+}
+
+// moveBodyTxs - moves the transactions of a non-canonical body to fresh NonCanonicalTxs ids, keeping their offsets,
+// and drops their TxLookup entries unless the canonical block of the same height holds them too
+func moveBodyTxs(tx kv.RwTx, key []byte, canonical bodyRanges) error {
+	num, hash, err := kv.ParseHeaderKey(key)
+	if err != nil {
+		return err
+	}
+	v, err := tx.GetOne(kv.BlockBody, key)
+	if err != nil {
+		return err
+	}
+	body, err := decodeBody(v)
+	if err != nil {
+		return err
+	}
+	keep, err := canonicalTxHashes(tx, num)
+	if err != nil {
+		return err
+	}
+
+	base, err := rawdb.ReadSequence(tx, kv.NonCanonicalTxs)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(kv.Sequence, []byte(kv.NonCanonicalTxs), kv.EncodeBlockNum(base+uint64(body.amount))); err != nil {
+		return err
+	}
+	for i := uint64(0); i < uint64(body.amount); i++ {
+		id := kv.EncodeBlockNum(body.first + i)
+		v, err := tx.GetOne(kv.EthTx, id)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		v = append([]byte{}, v...)
+		if err := tx.Put(kv.NonCanonicalTxs, kv.EncodeBlockNum(base+i), v); err != nil {
+			return err
+		}
+		if err := tx.Delete(kv.EthTx, id); err != nil {
+			return err
+		}
+		// system transactions take the first and the last id, they have no lookup entries
+		if i == 0 || i == uint64(body.amount)-1 {
+			continue
+		}
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(v); err != nil {
+			return fmt.Errorf("block %d %x: transaction %d: %w", num, hash, body.first+i, err)
+		}
+		h := txn.Hash()
+		if _, ok := keep[h]; ok {
+			continue
+		}
+		if entry, err := rawdb.ReadTxLookupEntry(tx, h); err != nil {
+			return err
+		} else if entry != nil && *entry == num {
+			if err := rawdb.DeleteTxLookupEntry(tx, h); err != nil {
+				return err
+			}
+		}
+	}
+	moved := make([]byte, 0, 12)
+	moved = binary.BigEndian.AppendUint64(moved, base)
+	moved = binary.BigEndian.AppendUint32(moved, body.amount)
+	return kv.WriteBodyForStorage(tx, num, hash, moved)
+}
+
+// canonicalTxHashes - hashes of the transactions of the canonical block num
+func canonicalTxHashes(tx kv.Tx, num uint64) (map[types.Hash]struct{}, error) {
+	res := make(map[types.Hash]struct{})
+	hash, err := tx.GetOne(kv.HeaderCanonical, kv.EncodeBlockNum(num))
+	if err != nil || hash == nil {
+		return res, err
+	}
+	v, err := kv.ReadBodyForStorage(tx, num, hash)
+	if err != nil || v == nil {
+		return res, err
+	}
+	body, err := decodeBody(v)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < uint64(body.amount); i++ {
+		v, err := tx.GetOne(kv.EthTx, kv.EncodeBlockNum(body.first+i))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(v); err != nil {
+			continue
+		}
+		res[txn.Hash()] = struct{}{}
+	}
+	return res, nil
+}
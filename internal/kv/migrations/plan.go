@@ -0,0 +1,241 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amazechain/amc/internal/diskguard"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+var (
+	// ErrNotApproved - destructive migrations are pending and were not approved, the db is left untouched
+	ErrNotApproved = errors.New("migrations not approved")
+	// ErrNoHeadroom - the volume of the db lacks the space the pending migrations may need
+	ErrNoHeadroom = errors.New("not enough disk space for migrations")
+
+	errSampled = errors.New("sampled")
+)
+
+// now - clock of the duration estimates
+var now = time.Now
+
+// Step - dry run of a pending migration
+type Step struct {
+	Name       string
+	Kind       Kind
+	Rows       uint64        // records of the touched tables
+	Duration   time.Duration // extrapolated from the first committed chunk, 0 if the migration never committed
+	DiskNeeded uint64        // size of the touched tables, 0 unless destructive
+}
+
+// Plan - dry runs of the pending migrations, in the order they run
+type Plan []Step
+
+// DryRun - estimates the pending migrations. Each runs in a tx which is rolled back at its first commit,
+// the cost per row of that chunk is extrapolated to all the records of the touched tables, see
+// EstimateMigrationDuration. Migrations are sampled on the current db, without the changes of the ones
+// before them.
+func DryRun(ctx context.Context, db kv.RwDB, migrations []Migration) (Plan, error) {
+	var plan Plan
+	for _, m := range migrations {
+		step, pending, err := dryRun(ctx, db, m)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: dry run: %w", m.Name, err)
+		}
+		if pending {
+			plan = append(plan, step)
+		}
+	}
+	return plan, nil
+}
+
+func dryRun(ctx context.Context, db kv.RwDB, m Migration) (step Step, pending bool, err error) {
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return step, false, err
+	}
+	defer tx.Rollback()
+	if done, err := applied(tx, m.Name); err != nil || done {
+		return step, false, err
+	}
+
+	step = Step{Name: m.Name, Kind: m.Kind}
+	counts := make(map[string]uint64, len(m.Touches))
+	for _, table := range m.Touches {
+		if _, ok := counts[table]; ok {
+			continue
+		}
+		c, err := tx.Cursor(table)
+		if err != nil {
+			return step, false, err
+		}
+		counts[table], err = c.Count()
+		c.Close()
+		if err != nil {
+			return step, false, err
+		}
+		step.Rows += counts[table]
+		if m.Kind == Destructive {
+			size, err := tx.BucketSize(table)
+			if err != nil {
+				return step, false, err
+			}
+			step.DiskNeeded += size
+		}
+	}
+
+	progress, err := loadProgress(tx, m.Name)
+	if err != nil {
+		return step, false, err
+	}
+	var rows uint64
+	start := now()
+	err = m.Up(tx, progress, func(_ []byte, n uint64) (kv.RwTx, error) {
+		rows = n
+		return nil, errSampled
+	})
+	elapsed := now().Sub(start)
+	switch {
+	case err == nil:
+		// the whole migration fit in the sample
+		step.Duration = elapsed
+	case errors.Is(err, errSampled):
+		if rows > 0 {
+			step.Duration = EstimateMigrationDuration(m, counts, int(elapsed.Nanoseconds()/int64(rows)))
+		}
+	default:
+		return step, false, err
+	}
+	return step, true, nil
+}
+
+// EstimateMigrationDuration - wall-clock estimate of m: every record of the tables it touches, counted
+// once per table, takes perRecordNanos. Tables missing from counts count as empty.
+func EstimateMigrationDuration(m Migration, counts map[string]uint64, perRecordNanos int) time.Duration {
+	if perRecordNanos <= 0 {
+		return 0
+	}
+	seen := make(map[string]struct{}, len(m.Touches))
+	var records uint64
+	for _, table := range m.Touches {
+		if _, ok := seen[table]; ok {
+			continue
+		}
+		seen[table] = struct{}{}
+		if counts[table] > math.MaxUint64-records {
+			return time.Duration(math.MaxInt64)
+		}
+		records += counts[table]
+	}
+	if records > uint64(math.MaxInt64)/uint64(perRecordNanos) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(records * uint64(perRecordNanos))
+}
+
+// Destructive - names of the destructive migrations of the plan
+func (p Plan) Destructive() []string {
+	var res []string
+	for _, s := range p {
+		if s.Kind == Destructive {
+			res = append(res, s.Name)
+		}
+	}
+	return res
+}
+
+// DiskNeeded - space the plan may need on the volume of the db
+func (p Plan) DiskNeeded() uint64 {
+	var res uint64
+	for _, s := range p {
+		res += s.DiskNeeded
+	}
+	return res
+}
+
+func (p Plan) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tKIND\tROWS\tESTIMATE\tDISK")
+	for _, s := range p {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d MiB\n", s.Name, s.Kind, s.Rows, s.Duration.Round(time.Second), s.DiskNeeded>>20)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// Approval - gate of the destructive migrations: Apply prints the dry run of the pending migrations and
+// runs the destructive ones only if they are approved up front or confirmed. Otherwise it returns
+// ErrNotApproved before any migration ran, so the db is never left partially migrated.
+type Approval struct {
+	Approved bool               // approved up front, e.g. by a command line flag
+	Confirm  func(Plan) bool    // asks the user, nil if nobody can be asked, see TerminalConfirm
+	Out      io.Writer          // receives the dry run summary, nil discards it
+	DataDir  string             // volume checked for headroom, none if empty
+	Stat     diskguard.StatFunc // nil is diskguard.StatVolume
+}
+
+func (a Approval) check(plan Plan) error {
+	if len(plan) == 0 {
+		return nil
+	}
+	if a.Out != nil {
+		fmt.Fprintf(a.Out, "Pending database migrations:\n%s", plan)
+	}
+	if a.DataDir != "" {
+		stat := a.Stat
+		if stat == nil {
+			stat = diskguard.StatVolume
+		}
+		usage, err := stat(a.DataDir)
+		if err != nil {
+			return err
+		}
+		if need := plan.DiskNeeded(); usage.Free < need {
+			return fmt.Errorf("%w: %d MiB free in %s, migrations may need %d MiB", ErrNoHeadroom, usage.Free>>20, a.DataDir, need>>20)
+		}
+	}
+	destructive := plan.Destructive()
+	if len(destructive) == 0 || a.Approved || (a.Confirm != nil && a.Confirm(plan)) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s rewrite existing records, check the estimate above and start again with the migrations approved",
+		ErrNotApproved, strings.Join(destructive, ", "))
+}
+
+// TerminalConfirm - asks on out whether to run the destructive migrations, accepts "y" or "yes" read from in
+func TerminalConfirm(in io.Reader, out io.Writer) func(Plan) bool {
+	return func(plan Plan) bool {
+		fmt.Fprintf(out, "Run the destructive migrations %s now? [y/N] ", strings.Join(plan.Destructive(), ", "))
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && answer == "" {
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/internal/diskguard"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+func TestApproval(t *testing.T) {
+	ctx := context.Background()
+	db, _ := openFixtureDB(t)
+	before := dumpTables(t, db, append(migratedTables, kv.Migrations, kv.DatabaseInfo)...)
+	untouched := func(what string) {
+		t.Helper()
+		if !reflect.DeepEqual(before, dumpTables(t, db, append(migratedTables, kv.Migrations, kv.DatabaseInfo)...)) {
+			t.Fatalf("%s: db changed", what)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Apply(ctx, db, Approval{Out: &out}); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("no approval: err %v", err)
+	}
+	untouched("no approval")
+	if !strings.Contains(out.String(), "noncanonical_txs") || !strings.Contains(out.String(), "destructive") {
+		t.Fatalf("summary misses the migration:\n%s", out.String())
+	}
+
+	var asked Plan
+	refuse := func(plan Plan) bool { asked = plan; return false }
+	if err := Apply(ctx, db, Approval{Confirm: refuse}); !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("refused: err %v", err)
+	}
+	untouched("refused")
+	rows := uint64(len(before[kv.BlockBody]) + len(before[kv.EthTx]))
+	if len(asked) != len(Migrations) || asked[1].Name != nonCanonicalTxs.Name || asked[1].Rows != rows || asked[1].DiskNeeded == 0 {
+		t.Fatalf("confirmation asked for %+v", asked)
+	}
+
+	full := func(string) (diskguard.Usage, error) { return diskguard.Usage{Free: 1, Total: 1 << 30}, nil }
+	if err := Apply(ctx, db, Approval{Approved: true, DataDir: "/data", Stat: full}); !errors.Is(err, ErrNoHeadroom) {
+		t.Fatalf("full disk: err %v", err)
+	}
+	untouched("full disk")
+
+	answer := strings.NewReader("Yes\n")
+	if err := Apply(ctx, db, Approval{Confirm: TerminalConfirm(answer, &out)}); err != nil {
+		t.Fatalf("confirmed: %v", err)
+	}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		_, ok, err := Applied(tx, nonCanonicalTxs.Name)
+		if err == nil && !ok {
+			t.Fatal("confirmed migration not applied")
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing pending, nothing to approve
+	out.Reset()
+	if err := Apply(ctx, db, Approval{Out: &out}); err != nil || out.Len() != 0 {
+		t.Fatalf("nothing pending: err %v, summary %q", err, out.String())
+	}
+
+	// additive migrations need no approval
+	additive := Migration{Name: "additive", Kind: Additive, Up: func(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+		return tx.Put(kv.DatabaseInfo, []byte("additive"), []byte{1})
+	}}
+	if err := apply(ctx, db, []Migration{additive}, Approval{}); err != nil {
+		t.Fatalf("additive: %v", err)
+	}
+}
+
+func TestTerminalConfirm(t *testing.T) {
+	for in, want := range map[string]bool{"y\n": true, " YES \n": true, "yes": true, "\n": false, "no\n": false, "": false} {
+		if got := TerminalConfirm(strings.NewReader(in), new(bytes.Buffer))(nil); got != want {
+			t.Fatalf("answer %q: %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestEstimateMigrationDuration(t *testing.T) {
+	counts := map[string]uint64{
+		kv.Receipts: 2_000_000,
+		kv.Log:      6_000_000,
+		kv.Headers:  2_000_000,
+	}
+	if got := EstimateMigrationDuration(receiptsV2, counts, 1500); got != 12*time.Second {
+		t.Fatalf("receipts migration = %v, want 12s", got)
+	}
+
+	for _, c := range []struct {
+		name    string
+		touches []string
+		nanos   int
+		want    time.Duration
+	}{
+		{"no tables", nil, 1000, 0},
+		{"table counted once", []string{kv.Headers, kv.Headers}, 1000, 2 * time.Second},
+		{"unknown table", []string{"Unknown", kv.Headers}, 1000, 2 * time.Second},
+		{"no cost", []string{kv.Log}, 0, 0},
+		{"negative cost", []string{kv.Log}, -5, 0},
+		{"overflow", []string{kv.Log}, math.MaxInt, time.Duration(math.MaxInt64)},
+	} {
+		m := Migration{Name: c.name, Touches: c.touches}
+		if got := EstimateMigrationDuration(m, counts, c.nanos); got != c.want {
+			t.Fatalf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+	huge := map[string]uint64{"a": math.MaxUint64, "b": 1}
+	if got := EstimateMigrationDuration(Migration{Touches: []string{"a", "b"}}, huge, 1); got != time.Duration(math.MaxInt64) {
+		t.Fatalf("sum overflow = %v", got)
+	}
+}
+
+func TestDryRunEstimate(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	var clock time.Time
+	now = func() time.Time { return clock }
+
+	const rows, chunk = 2000, 100
+	db := memdb.NewTestDB(t)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i := uint64(0); i < rows; i++ {
+			if err := tx.Put(kv.EthTx, kv.EncodeBlockNum(i), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// rows cost 1ms on average, unevenly: every 7th is 4x slower, later rows are up to 20% slower
+	cost := func(i uint64) time.Duration {
+		d := 800*time.Microsecond + time.Duration(i)*400*time.Microsecond/rows
+		if i%7 == 0 {
+			d *= 4
+		}
+		return d
+	}
+	var actual time.Duration
+	for i := uint64(0); i < rows; i++ {
+		actual += cost(i)
+	}
+
+	m := Migration{Name: "walk", Kind: Destructive, Touches: []string{kv.EthTx},
+		Up: func(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+			from := uint64(0)
+			if progress != nil {
+				from, _ = kv.DecodeBlockNum(progress)
+			}
+			for i := from; i < rows; i++ {
+				clock = clock.Add(cost(i))
+				if (i+1)%chunk == 0 && i+1 < rows {
+					var err error
+					if tx, err = commit(kv.EncodeBlockNum(i+1), chunk); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}}
+
+	plan, err := DryRun(context.Background(), db, []Migration{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Rows != rows {
+		t.Fatalf("plan %+v", plan)
+	}
+	if est := plan[0].Duration; est < actual*3/4 || est > actual*5/4 {
+		t.Fatalf("estimate %v, actual %v", est, actual)
+	}
+	if plan[0].DiskNeeded == 0 {
+		t.Fatal("no disk estimate for a destructive migration")
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/modules/rawdb"
+)
+
+// receiptsV2 - rewrites the version 1 Receipts records as version 2, which take their logs from Log.
+// rawdb.ReadRawReceipts rewrites the records it reads as well, this one rewrites the rest. The progress
+// is the Receipts key of the last record looked at. Its name differs from the Migrations entry in which
+// rawdb counts the lazily rewritten records.
+var receiptsV2 = Migration{
+	Name:    "receipts_v2_rewrite",
+	Kind:    Destructive,
+	Touches: []string{kv.Receipts, kv.Log},
+	Up:      rewriteReceipts,
+}
+
+func rewriteReceipts(tx kv.RwTx, progress []byte, commit CommitFunc) error {
+	for {
+		var outdated []uint64
+		scanned := 0
+		err := tx.ForEach(kv.Receipts, progress, func(k, v []byte) error {
+			if progress != nil && bytes.Equal(k, progress) {
+				return nil
+			}
+			if scanned == commitEvery {
+				return errBatchFull
+			}
+			scanned++
+			progress = append([]byte{}, k...)
+			if len(v) == 0 || v[0] == rawdb.ReceiptsVersion {
+				return nil
+			}
+			num, err := kv.DecodeBlockNum(k)
+			if err != nil {
+				return err
+			}
+			outdated = append(outdated, num)
+			return nil
+		})
+		full := errors.Is(err, errBatchFull)
+		if err != nil && !full {
+			return err
+		}
+		for _, num := range outdated {
+			if _, err := rawdb.UpgradeReceipts(tx, num); err != nil {
+				return err
+			}
+		}
+		if !full {
+			return nil
+		}
+		if tx, err = commit(progress, uint64(scanned)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/holiman/uint256"
+)
+
+func TestReceiptsV2(t *testing.T) {
+	defer func(n int) { commitEvery = n }(commitEvery)
+	commitEvery = 2
+
+	ctx := context.Background()
+	db := memdb.NewTestDB(t)
+	// blocks 1-5 in the version 1 layout, block 6 written as version 2 already
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		for n := uint64(1); n <= 6; n++ {
+			receipts := block.Receipts{{
+				Status:            1,
+				CumulativeGasUsed: 21000,
+				GasUsed:           21000,
+				TxHash:            types.Hash{byte(n)},
+				BlockNumber:       uint256.NewInt(n),
+				Logs:              []*block.Log{{Address: types.Address{byte(n)}, Topics: []types.Hash{{1}}, BlockNumber: uint256.NewInt(n)}},
+			}}
+			if err := rawdb.WriteReceipts(tx, n, receipts); err != nil {
+				return err
+			}
+			if n == 6 {
+				continue
+			}
+			legacy, err := receipts.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(kv.Receipts, kv.EncodeBlockNum(n), legacy); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := apply(ctx, db, []Migration{receiptsV2}, approved); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		if err := tx.ForEach(kv.Receipts, nil, func(k, v []byte) error {
+			if v[0] != rawdb.ReceiptsVersion {
+				t.Fatalf("receipts %x: version %d, want %d", k, v[0], rawdb.ReceiptsVersion)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if migrated, err := rawdb.ReceiptsMigrated(tx); err != nil || migrated != 5 {
+			t.Fatalf("%d records counted as rewritten, err %v", migrated, err)
+		}
+		_, ok, err := Applied(tx, receiptsV2.Name)
+		if err == nil && !ok {
+			t.Fatal("migration not recorded")
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
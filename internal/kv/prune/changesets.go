@@ -0,0 +1,134 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/walk"
+)
+
+// changeSetProgressPrefix - DatabaseInfo key prefix of the block of the last record pruned from a changeset table
+var changeSetProgressPrefix = []byte("pruneChangeSets.")
+
+// changeSetHistoryKey - key of history index which references the block of a changeset record
+var changeSetHistoryKey = map[string]func(k, v []byte) ([]byte, error){
+	// AccountChangeSet: blockNum -> address + account, AccountsHistory: address + shard
+	kv.AccountChangeSet: func(_, v []byte) ([]byte, error) {
+		if len(v) < kv.AddrLen {
+			return nil, fmt.Errorf("value length %d", len(v))
+		}
+		return v[:kv.AddrLen], nil
+	},
+	// StorageChangeSet: blockNum + address + incarnation -> location + value, StorageHistory: address + location + shard
+	kv.StorageChangeSet: func(k, v []byte) ([]byte, error) {
+		if len(k) != kv.BlockNumLen+kv.AddrLen+kv.IncarnationLen || len(v) < kv.HashLen {
+			return nil, fmt.Errorf("key length %d, value length %d", len(k), len(v))
+		}
+		return append(append(make([]byte, 0, kv.AddrLen+kv.HashLen), k[kv.BlockNumLen:kv.BlockNumLen+kv.AddrLen]...), v[:kv.HashLen]...), nil
+	},
+}
+
+// PruneChangeSets - deletes records of changeset table (AccountChangeSet or StorageChangeSet) of blocks
+// below pruneTo, at most limit records per call (limit <= 0 - no limit), and removes pruned blocks from
+// history index shards of every changed key. Block of the last pruned record is kept in DatabaseInfo
+// and the next call resumes from it: a call may stop in the middle of a block.
+// done - no records below pruneTo are left.
+func PruneChangeSets(tx kv.RwTx, table string, pruneTo uint64, limit int) (done bool, err error) {
+	historyKey, ok := changeSetHistoryKey[table]
+	if !ok {
+		return false, fmt.Errorf("%s is not a changeset table", table)
+	}
+	progressKey := append(append([]byte{}, changeSetProgressPrefix...), table...)
+	from, err := readChangeSetProgress(tx, progressKey)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := tx.RwCursorDupSort(table)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	// history key -> last block its change was pruned in. Changes of one key are pruned in block order,
+	// so none of its changes below that block is left.
+	pruned := make(map[string]uint64)
+	deleted, last, stopped := 0, uint64(0), false
+	if err := walk.Cursor(context.Background(), c, from, pruneTo, func(blockNum uint64, k, v []byte) error {
+		if limit > 0 && deleted == limit {
+			stopped = true
+			return walk.ErrStop
+		}
+		hk, err := historyKey(k, v)
+		if err != nil {
+			return fmt.Errorf("%s record of block %d: %w", table, blockNum, err)
+		}
+		pruned[string(hk)] = blockNum
+		if err := c.DeleteCurrent(); err != nil {
+			return fmt.Errorf("failed to remove for block %d: %w", blockNum, err)
+		}
+		deleted, last = deleted+1, blockNum
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if deleted == 0 {
+		return !stopped, nil
+	}
+
+	for _, index := range kv.DependentIndicesFor(table) {
+		for hk, blockNum := range pruned {
+			if err := bitmapdb.TruncateBelow64(tx, index, []byte(hk), blockNum+1); err != nil {
+				return false, fmt.Errorf("trim %s: %w", index, err)
+			}
+		}
+	}
+	return !stopped, tx.Put(kv.DatabaseInfo, progressKey, kv.EncodeBlockNum(last))
+}
+
+func readChangeSetProgress(tx kv.Getter, key []byte) (uint64, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, key)
+	if err != nil || len(v) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// PruneHistory - PruneChangeSets of both changeset tables to the history prune setting stored in DatabaseInfo.
+// StorageChangeSet is pruned once AccountChangeSet is done, limit applies to each of them.
+// done - nothing is left to prune at this head.
+func PruneHistory(tx kv.RwTx, head uint64, limit int) (done bool, err error) {
+	m, err := ReadPruneMode(tx)
+	if err != nil {
+		return false, err
+	}
+	pruneTo, ok := PruneThreshold(m.History, head)
+	if !ok {
+		return true, nil
+	}
+	for _, table := range []string{kv.AccountChangeSet, kv.StorageChangeSet} {
+		if done, err = PruneChangeSets(tx, table, pruneTo, limit); err != nil || !done {
+			return done, err
+		}
+	}
+	return true, nil
+}
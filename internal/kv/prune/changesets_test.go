@@ -0,0 +1,203 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+const (
+	changeSetBlocks = 20
+	changeSetAddrs  = 3
+)
+
+func shardKey(key []byte, shard uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, key...), shard)
+}
+
+func putShard(t *testing.T, tx kv.RwTx, table string, key []byte, shard uint64, blocks ...uint64) {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := roaring64.BitmapOf(blocks...).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put(table, shardKey(key, shard), buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeChangeSets - every address changes its account and one storage slot in each block.
+// AccountsHistory keeps one shard per address, StorageHistory two: blocks below 10 and the rest.
+func writeChangeSets(t *testing.T, tx kv.RwTx) {
+	t.Helper()
+	var all, low, high []uint64
+	for b := uint64(0); b < changeSetBlocks; b++ {
+		all = append(all, b)
+		if b < 10 {
+			low = append(low, b)
+		} else {
+			high = append(high, b)
+		}
+	}
+	for i := 0; i < changeSetAddrs; i++ {
+		addr, loc := addrOf(i), bytes.Repeat([]byte{byte(i)}, kv.HashLen)
+		for b := uint64(0); b < changeSetBlocks; b++ {
+			if err := tx.Put(kv.AccountChangeSet, kv.EncodeBlockNum(b), append(append([]byte{}, addr...), byte(b))); err != nil {
+				t.Fatal(err)
+			}
+			k := append(append(kv.EncodeBlockNum(b), addr...), kv.EncodeBlockNum(1)...)
+			if err := tx.Put(kv.StorageChangeSet, k, append(append([]byte{}, loc...), byte(b))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		putShard(t, tx, kv.AccountsHistory, addr, math.MaxUint64, all...)
+		putShard(t, tx, kv.StorageHistory, append(append([]byte{}, addr...), loc...), 9, low...)
+		putShard(t, tx, kv.StorageHistory, append(append([]byte{}, addr...), loc...), math.MaxUint64, high...)
+	}
+}
+
+func addrOf(i int) []byte {
+	addr := make([]byte, kv.AddrLen)
+	addr[0] = byte(i)
+	return addr
+}
+
+// changeSetBlocksOf - block numbers of records of changeset table in table order
+func changeSetBlocksOf(t *testing.T, tx kv.Tx, table string) []uint64 {
+	t.Helper()
+	var res []uint64
+	if err := tx.ForEach(table, nil, func(k, _ []byte) error {
+		res = append(res, binary.BigEndian.Uint64(k))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// history - union and number of shards of key
+func history(t *testing.T, tx kv.Tx, table string, key []byte) (*roaring64.Bitmap, int) {
+	t.Helper()
+	res, shards := roaring64.New(), 0
+	if err := tx.ForPrefix(table, key, func(_, v []byte) error {
+		bm := roaring64.New()
+		if err := bitmapdb.DecodeShard64(bm, v); err != nil {
+			return err
+		}
+		res.Or(bm)
+		shards++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return res, shards
+}
+
+func TestPruneChangeSetsResume(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeChangeSets(t, tx)
+
+	// 4 records per call: the first call prunes block 0 and the first address of block 1
+	done, err := PruneChangeSets(tx, kv.AccountChangeSet, 10, 4)
+	if err != nil || done {
+		t.Fatalf("first call: done %t, err %v", done, err)
+	}
+	blocks := changeSetBlocksOf(t, tx, kv.AccountChangeSet)
+	if len(blocks) != changeSetBlocks*changeSetAddrs-4 || blocks[0] != 1 || blocks[2] != 2 {
+		t.Fatalf("left blocks %v", blocks)
+	}
+	if bm, _ := history(t, tx, kv.AccountsHistory, addrOf(0)); bm.Minimum() != 2 {
+		t.Fatalf("address 0 history starts at %d after its block 1 change was pruned", bm.Minimum())
+	}
+	if bm, _ := history(t, tx, kv.AccountsHistory, addrOf(1)); bm.Minimum() != 1 {
+		t.Fatalf("address 1 history starts at %d while its block 1 change is kept", bm.Minimum())
+	}
+
+	calls := 1
+	for !done {
+		if done, err = PruneChangeSets(tx, kv.AccountChangeSet, 10, 4); err != nil {
+			t.Fatal(err)
+		}
+		calls++
+	}
+	if calls != 8 { // 30 records below block 10, 4 per call
+		t.Fatalf("pruned in %d calls", calls)
+	}
+	if blocks := changeSetBlocksOf(t, tx, kv.AccountChangeSet); len(blocks) != 10*changeSetAddrs || blocks[0] != 10 {
+		t.Fatalf("left blocks %v", blocks)
+	}
+	for i := 0; i < changeSetAddrs; i++ {
+		if bm, _ := history(t, tx, kv.AccountsHistory, addrOf(i)); bm.Minimum() != 10 || bm.GetCardinality() != 10 {
+			t.Fatalf("address %d history %v", i, bm.ToArray())
+		}
+	}
+	if v, _ := tx.GetOne(kv.DatabaseInfo, append(append([]byte{}, changeSetProgressPrefix...), kv.AccountChangeSet...)); binary.BigEndian.Uint64(v) != 9 {
+		t.Fatalf("progress %x, want block 9", v)
+	}
+
+	// unlimited: storage history loses its low shard entirely
+	if done, err := PruneChangeSets(tx, kv.StorageChangeSet, 12, 0); err != nil || !done {
+		t.Fatalf("done %t, err %v", done, err)
+	}
+	for i := 0; i < changeSetAddrs; i++ {
+		key := append(addrOf(i), bytes.Repeat([]byte{byte(i)}, kv.HashLen)...)
+		if bm, shards := history(t, tx, kv.StorageHistory, key); shards != 1 || bm.Minimum() != 12 {
+			t.Fatalf("address %d storage history %v in %d shards", i, bm.ToArray(), shards)
+		}
+	}
+	if _, err := PruneChangeSets(tx, kv.Receipts, 12, 0); err == nil {
+		t.Fatal("pruned non-changeset table")
+	}
+}
+
+func TestPruneHistoryTypes(t *testing.T) {
+	for _, tc := range []struct {
+		typ  []byte
+		n    uint64
+		want uint64 // first block left at head 19
+	}{
+		{kv.PruneTypeOlder, 5, 14},
+		{kv.PruneTypeBefore, 5, 5},
+		{kv.PruneTypeOlder, 30, 0},
+		{kv.PruneTypeBefore, 0, 0},
+	} {
+		_, tx := memdb.NewTestTx(t)
+		writeChangeSets(t, tx)
+		if err := tx.Put(kv.DatabaseInfo, kv.PruneHistory, kv.EncodeBlockNum(tc.n)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(kv.DatabaseInfo, kv.PruneHistoryType, tc.typ); err != nil {
+			t.Fatal(err)
+		}
+		if done, err := PruneHistory(tx, changeSetBlocks-1, 0); err != nil || !done {
+			t.Fatalf("%s %d: done %t, err %v", tc.typ, tc.n, done, err)
+		}
+		for _, table := range []string{kv.AccountChangeSet, kv.StorageChangeSet} {
+			if blocks := changeSetBlocksOf(t, tx, table); blocks[0] != tc.want {
+				t.Fatalf("%s %d: %s starts at block %d, want %d", tc.typ, tc.n, table, blocks[0], tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// BlockAmount - how much of a prunable data category is kept
+type BlockAmount interface {
+	// PruneTo - first block kept at the given head
+	PruneTo(head uint64) uint64
+	Enabled() bool
+}
+
+// Distance - keep the last N blocks (prune type "older"), math.MaxUint64 disables pruning
+type Distance uint64
+
+func (d Distance) Enabled() bool { return d != math.MaxUint64 }
+func (d Distance) PruneTo(head uint64) uint64 {
+	if !d.Enabled() || head <= uint64(d) {
+		return 0
+	}
+	return head - uint64(d)
+}
+
+// Before - keep blocks starting from N (prune type "before"), 0 disables pruning.
+// Clamped to head, blocks above head do not exist yet.
+type Before uint64
+
+func (b Before) Enabled() bool { return b != 0 }
+func (b Before) PruneTo(head uint64) uint64 {
+	if head < uint64(b) {
+		return head
+	}
+	return uint64(b)
+}
+
+// PruneThreshold - block number below which data is prunable at head, and whether d prunes at all.
+// A distance reaching past genesis gives 0, nothing is prunable yet.
+func PruneThreshold(d BlockAmount, head uint64) (uint64, bool) {
+	if !enabled(d) {
+		return 0, false
+	}
+	return d.PruneTo(head), true
+}
+
+// PruneMode - prune settings of every category, nil amounts keep all data
+type PruneMode struct {
+	History    BlockAmount
+	Receipts   BlockAmount
+	TxIndex    BlockAmount
+	CallTraces BlockAmount
+	// Senders - pruned senders are recovered from transaction signatures on demand
+	Senders BlockAmount
+}
+
+// modeTables - tables pruned by each PruneMode field
+var modeTables = []struct {
+	amount func(m PruneMode) BlockAmount
+	tables []string
+}{
+	{func(m PruneMode) BlockAmount { return m.History }, []string{kv.AccountChangeSet, kv.StorageChangeSet, kv.AccountsHistory, kv.StorageHistory}},
+	{func(m PruneMode) BlockAmount { return m.Receipts }, []string{kv.Receipts, kv.Log, kv.LogTopicIndex, kv.LogAddressIndex}},
+	{func(m PruneMode) BlockAmount { return m.TxIndex }, []string{kv.TxLookup}},
+	{func(m PruneMode) BlockAmount { return m.CallTraces }, []string{kv.CallTraceSet, kv.CallFromIndex, kv.CallToIndex}},
+	{func(m PruneMode) BlockAmount { return m.Senders }, []string{kv.Senders}},
+}
+
+func enabled(a BlockAmount) bool {
+	return a != nil && a.Enabled()
+}
+
+// deeper - whether next prunes data which prev keeps. Amounts of different types
+// can only be compared at a given head, so a type change is reported as deeper.
+func deeper(prev, next BlockAmount) bool {
+	if !enabled(next) {
+		return false
+	}
+	if !enabled(prev) {
+		return true
+	}
+	switch n := next.(type) {
+	case Distance:
+		if p, ok := prev.(Distance); ok {
+			return n < p
+		}
+	case Before:
+		if p, ok := prev.(Before); ok {
+			return n > p
+		}
+	}
+	return true
+}
+
+// PruneModeDelta - tables which will be pruned, or pruned deeper, under next than under prev.
+// Used to confirm stricter prune settings before applying them.
+func PruneModeDelta(prev, next PruneMode) []string {
+	var res []string
+	for _, m := range modeTables {
+		if deeper(m.amount(prev), m.amount(next)) {
+			res = append(res, m.tables...)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ReadPruneMode - prune settings stored in DatabaseInfo
+func ReadPruneMode(tx Reader) (PruneMode, error) {
+	var m PruneMode
+	for _, f := range []struct {
+		amount      *BlockAmount
+		key, typKey []byte
+	}{
+		{&m.History, kv.PruneHistory, kv.PruneHistoryType},
+		{&m.Receipts, kv.PruneReceipts, kv.PruneReceiptsType},
+		{&m.TxIndex, kv.PruneTxIndex, kv.PruneTxIndexType},
+		{&m.CallTraces, kv.PruneCallTraces, kv.PruneCallTracesType},
+		{&m.Senders, kv.PruneSenders, kv.PruneSendersType},
+	} {
+		v, err := tx.GetOne(kv.DatabaseInfo, f.key)
+		if err != nil {
+			return m, err
+		}
+		if len(v) != 8 {
+			continue
+		}
+		typ, err := tx.GetOne(kv.DatabaseInfo, f.typKey)
+		if err != nil {
+			return m, err
+		}
+		if string(typ) == string(kv.PruneTypeBefore) {
+			*f.amount = Before(binary.BigEndian.Uint64(v))
+		} else {
+			*f.amount = Distance(binary.BigEndian.Uint64(v))
+		}
+	}
+	return m, nil
+}
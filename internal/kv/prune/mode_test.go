@@ -0,0 +1,106 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+func TestPruneModeDelta(t *testing.T) {
+	cases := []struct {
+		name       string
+		prev, next PruneMode
+		want       []string
+	}{
+		{
+			name: "enable receipts",
+			prev: PruneMode{History: Distance(90_000)},
+			next: PruneMode{History: Distance(90_000), Receipts: Distance(90_000)},
+			want: []string{kv.LogAddressIndex, kv.LogTopicIndex, kv.Receipts, kv.Log},
+		},
+		{
+			name: "enable previously disabled distance",
+			prev: PruneMode{TxIndex: Distance(math.MaxUint64)},
+			next: PruneMode{TxIndex: Distance(1000)},
+			want: []string{kv.TxLookup},
+		},
+		{
+			name: "deeper history",
+			prev: PruneMode{History: Distance(90_000), CallTraces: Before(100)},
+			next: PruneMode{History: Distance(1000), CallTraces: Before(200)},
+			want: []string{kv.AccountsHistory, kv.AccountChangeSet, kv.CallFromIndex, kv.CallToIndex, kv.CallTraceSet, kv.StorageHistory, kv.StorageChangeSet},
+		},
+		{
+			name: "looser or unchanged",
+			prev: PruneMode{History: Distance(1000), Receipts: Before(200), TxIndex: Distance(5)},
+			next: PruneMode{History: Distance(90_000), Receipts: Before(100), TxIndex: Distance(5)},
+		},
+		{
+			name: "disable",
+			prev: PruneMode{History: Distance(1000)},
+			next: PruneMode{History: Before(0)},
+		},
+		{
+			name: "type change",
+			prev: PruneMode{TxIndex: Distance(1000)},
+			next: PruneMode{TxIndex: Before(1)},
+			want: []string{kv.TxLookup},
+		},
+	}
+	for _, c := range cases {
+		got := PruneModeDelta(c.prev, c.next)
+		want := append([]string(nil), c.want...)
+		sort.Strings(want)
+		if len(got) != 0 || len(want) != 0 {
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: have %v, want %v", c.name, got, want)
+			}
+		}
+	}
+}
+
+func TestPruneThreshold(t *testing.T) {
+	cases := []struct {
+		name    string
+		amount  BlockAmount
+		head    uint64
+		want    uint64
+		enabled bool
+	}{
+		{"older", Distance(90_000), 1_000_000, 910_000, true},
+		{"older at distance", Distance(90_000), 90_000, 0, true},
+		{"older clamped", Distance(90_000), 100, 0, true},
+		{"older zero", Distance(0), 100, 100, true},
+		{"before", Before(500), 1_000_000, 500, true},
+		{"before ahead of head", Before(500), 100, 100, true},
+		{"before at head", Before(500), 500, 500, true},
+		{"older disabled", Distance(math.MaxUint64), 1_000_000, 0, false},
+		{"before disabled", Before(0), 1_000_000, 0, false},
+		{"unset", nil, 1_000_000, 0, false},
+	}
+	for _, c := range cases {
+		got, enabled := PruneThreshold(c.amount, c.head)
+		if got != c.want || enabled != c.enabled {
+			t.Fatalf("%s: have %d %t, want %d %t", c.name, got, enabled, c.want, c.enabled)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// defaultSamples - number of key ranges read from each table when Settings.Samples is zero
+const defaultSamples = 64
+
+// sampleRecords - max records read from one sampled key range of an unordered (hash or address keyed) table
+const sampleRecords = 32
+
+var errStopWalk = errors.New("stop walk")
+
+// Reader - the subset of kv.Tx used by Project. Both internal/kv and erigon-lib transactions satisfy it.
+type Reader interface {
+	GetOne(table string, key []byte) ([]byte, error)
+	ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error
+	BucketSize(table string) (uint64, error)
+}
+
+// Settings - hypothetical prune configuration: drop data of blocks older than Older blocks behind the head
+type Settings struct {
+	Older   uint64 `json:"older"`
+	Samples int    `json:"samples,omitempty"`
+}
+
+type keyLayout int
+
+const (
+	// block_num_u64 + ... -> ...
+	layoutBlockPrefix keyLayout = iota
+	// hash -> block number (big endian, leading zeros trimmed)
+	layoutBlockValue
+	// ... + shard -> roaring bitmap of block numbers
+	layoutBitmap32
+	// ... + shard -> roaring64 bitmap of block numbers
+	layoutBitmap64
+)
+
+type category struct {
+	name   string
+	tables map[string]keyLayout
+	// recovered - pruned data is recomputed on read, no RPC method breaks
+	recovered bool
+}
+
+// categories - prunable data. The RPC methods which start failing for blocks without it are the ones
+// declaring its tables, see kv.DeclareAccess.
+var categories = []category{
+	{
+		name: "changesets",
+		tables: map[string]keyLayout{
+			kv.AccountChangeSet: layoutBlockPrefix,
+			kv.StorageChangeSet: layoutBlockPrefix,
+			kv.AccountsHistory:  layoutBitmap64,
+			kv.StorageHistory:   layoutBitmap64,
+		},
+	},
+	{
+		name: "receipts",
+		tables: map[string]keyLayout{
+			kv.Receipts: layoutBlockPrefix,
+			kv.Log:      layoutBlockPrefix,
+		},
+	},
+	{
+		name: "txLookup",
+		tables: map[string]keyLayout{
+			kv.TxLookup: layoutBlockValue,
+		},
+	},
+	{
+		name: "callTraces",
+		tables: map[string]keyLayout{
+			kv.CallTraceSet:  layoutBlockPrefix,
+			kv.CallFromIndex: layoutBitmap64,
+			kv.CallToIndex:   layoutBitmap64,
+		},
+	},
+	{
+		name: "senders",
+		tables: map[string]keyLayout{
+			kv.Senders: layoutBlockPrefix,
+		},
+		recovered: true,
+	},
+	{
+		name: "logIndices",
+		tables: map[string]keyLayout{
+			kv.LogTopicIndex:   layoutBitmap32,
+			kv.LogAddressIndex: layoutBitmap32,
+		},
+	},
+}
+
+// TableProjection - estimated share of one table lying beyond the prune horizon
+type TableProjection struct {
+	Table    string  `json:"table"`
+	Size     uint64  `json:"size"`
+	Beyond   uint64  `json:"beyond"`
+	Fraction float64 `json:"fraction"`
+}
+
+// CategoryProjection - sum of TableProjection over the tables of one category
+type CategoryProjection struct {
+	Name   string            `json:"name"`
+	Size   uint64            `json:"size"`
+	Beyond uint64            `json:"beyond"`
+	Tables []TableProjection `json:"tables"`
+}
+
+// MethodImpact - RPC method which would return pruned-data errors for blocks in [From, To]
+type MethodImpact struct {
+	Method     string   `json:"method"`
+	Categories []string `json:"categories"`
+	From       uint64   `json:"from"`
+	To         uint64   `json:"to"`
+}
+
+// Projection - report of what pruning with Settings would free and break
+type Projection struct {
+	Head       uint64               `json:"head"`
+	Horizon    uint64               `json:"horizon"`
+	Size       uint64               `json:"size"`
+	Beyond     uint64               `json:"beyond"`
+	Categories []CategoryProjection `json:"categories"`
+	Methods    []MethodImpact       `json:"methods"`
+}
+
+// Project - estimates per-category sizes of the data older than the horizon (head - settings.Older).
+// Each table is sampled at evenly spaced key ranges, and the sampled share of bytes beyond the horizon
+// is extrapolated to the table size reported by BucketSize.
+func Project(tx Reader, settings Settings) (*Projection, error) {
+	head, err := readHead(tx)
+	if err != nil {
+		return nil, err
+	}
+	samples := settings.Samples
+	if samples <= 0 {
+		samples = defaultSamples
+	}
+	p := &Projection{Head: head}
+	if head > settings.Older {
+		p.Horizon = head - settings.Older
+	}
+
+	impacts := make(map[string]*MethodImpact)
+	for _, c := range categories {
+		cp := CategoryProjection{Name: c.name}
+		tables := make([]string, 0, len(c.tables))
+		for table := range c.tables {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			tp, err := projectTable(tx, table, c.tables[table], p.Horizon, head, samples)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %w", table, err)
+			}
+			cp.Size += tp.Size
+			cp.Beyond += tp.Beyond
+			cp.Tables = append(cp.Tables, tp)
+		}
+		p.Size += cp.Size
+		p.Beyond += cp.Beyond
+		p.Categories = append(p.Categories, cp)
+
+		if p.Horizon == 0 || c.recovered {
+			continue
+		}
+		for _, m := range kv.Dependents(kv.RPCOwner, tables...) {
+			impact, ok := impacts[m]
+			if !ok {
+				impact = &MethodImpact{Method: m, From: 0, To: p.Horizon - 1}
+				impacts[m] = impact
+			}
+			impact.Categories = append(impact.Categories, c.name)
+		}
+	}
+	for _, impact := range impacts {
+		p.Methods = append(p.Methods, *impact)
+	}
+	sort.Slice(p.Methods, func(i, j int) bool { return p.Methods[i].Method < p.Methods[j].Method })
+	return p, nil
+}
+
+func readHead(tx Reader) (uint64, error) {
+	hash, err := tx.GetOne(kv.HeadBlockKey, []byte(kv.HeadBlockKey))
+	if err != nil {
+		return 0, err
+	}
+	if len(hash) == 0 {
+		return 0, fmt.Errorf("head block not found")
+	}
+	number, err := tx.GetOne(kv.HeaderNumber, hash)
+	if err != nil {
+		return 0, err
+	}
+	if len(number) != kv.BlockNumLen {
+		return 0, fmt.Errorf("head block number not found for hash %x", hash)
+	}
+	return kv.DecodeBlockNum(number)
+}
+
+func projectTable(tx Reader, table string, layout keyLayout, horizon, head uint64, samples int) (TableProjection, error) {
+	tp := TableProjection{Table: table}
+	size, err := tx.BucketSize(table)
+	if err != nil {
+		return tp, err
+	}
+	tp.Size = size
+	if size == 0 || horizon == 0 {
+		return tp, nil
+	}
+
+	var fraction float64
+	switch layout {
+	case layoutBlockPrefix:
+		fraction, err = blockPrefixFraction(tx, table, horizon, head, samples)
+	default:
+		fraction, err = unorderedFraction(tx, table, layout, horizon, samples)
+	}
+	if err != nil {
+		return tp, err
+	}
+	tp.Fraction = fraction
+	tp.Beyond = uint64(fraction * float64(size))
+	return tp, nil
+}
+
+// blockPrefixFraction - for tables keyed by block number: average bytes per block below and above
+// the horizon, each measured over samples windows
+func blockPrefixFraction(tx Reader, table string, horizon, head uint64, samples int) (float64, error) {
+	below, err := bytesPerBlock(tx, table, 0, horizon, samples)
+	if err != nil {
+		return 0, err
+	}
+	above, err := bytesPerBlock(tx, table, horizon, head+1, samples)
+	if err != nil {
+		return 0, err
+	}
+	pruned := below * float64(horizon)
+	kept := above * float64(head+1-horizon)
+	if pruned+kept == 0 {
+		return 0, nil
+	}
+	return pruned / (pruned + kept), nil
+}
+
+func bytesPerBlock(tx Reader, table string, from, to uint64, samples int) (float64, error) {
+	if to <= from {
+		return 0, nil
+	}
+	span := to - from
+	window := span / uint64(samples*16)
+	if window == 0 {
+		window = 1
+	}
+	step := span / uint64(samples)
+	if step < window {
+		step = window
+	}
+
+	var size, blocks uint64
+	start := make([]byte, 8)
+	for block := from; block < to; block += step {
+		end := block + window
+		if end > to {
+			end = to
+		}
+		binary.BigEndian.PutUint64(start, block)
+		err := tx.ForAmount(table, start, 1<<20, func(k, v []byte) error {
+			if len(k) < 8 || binary.BigEndian.Uint64(k) >= end {
+				return errStopWalk
+			}
+			size += uint64(len(k) + len(v))
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopWalk) {
+			return 0, err
+		}
+		blocks += end - block
+	}
+	return float64(size) / float64(blocks), nil
+}
+
+// unorderedFraction - for tables keyed by hash or address: reads sampleRecords records at samples
+// evenly spaced prefixes and weights each record by the share of its blocks below the horizon
+func unorderedFraction(tx Reader, table string, layout keyLayout, horizon uint64, samples int) (float64, error) {
+	var total, pruned float64
+	prefix := make([]byte, 2)
+	for i := 0; i < samples; i++ {
+		binary.BigEndian.PutUint16(prefix, uint16(i*(1<<16)/samples))
+		err := tx.ForAmount(table, prefix, sampleRecords, func(k, v []byte) error {
+			share, err := prunedShare(layout, v, horizon)
+			if err != nil {
+				return err
+			}
+			size := float64(len(k) + len(v))
+			total += size
+			pruned += size * share
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return pruned / total, nil
+}
+
+func prunedShare(layout keyLayout, v []byte, horizon uint64) (float64, error) {
+	switch layout {
+	case layoutBlockValue:
+		if new(big.Int).SetBytes(v).Uint64() < horizon {
+			return 1, nil
+		}
+		return 0, nil
+	case layoutBitmap32:
+		bm := roaring.New()
+		if err := bitmapdb.DecodeShard(bm, v); err != nil {
+			return 0, err
+		}
+		if bm.IsEmpty() {
+			return 0, nil
+		}
+		limit := horizon
+		if limit > 1<<32 {
+			limit = 1 << 32
+		}
+		return float64(bm.Rank(uint32(limit-1))) / float64(bm.GetCardinality()), nil
+	case layoutBitmap64:
+		bm := roaring64.New()
+		if err := bitmapdb.DecodeShard64(bm, v); err != nil {
+			return 0, err
+		}
+		if bm.IsEmpty() {
+			return 0, nil
+		}
+		return float64(bm.Rank(horizon-1)) / float64(bm.GetCardinality()), nil
+	}
+	return 0, fmt.Errorf("unknown key layout %d", layout)
+}
+
+// String - human-readable form of the projection
+func (p *Projection) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "head %d, prune horizon %d: %s of %s would be freed\n", p.Head, p.Horizon, formatSize(p.Beyond), formatSize(p.Size))
+	for _, c := range p.Categories {
+		fmt.Fprintf(&b, "%-12s %10s of %10s\n", c.Name, formatSize(c.Beyond), formatSize(c.Size))
+		for _, t := range c.Tables {
+			fmt.Fprintf(&b, "  %-30s %10s of %10s (%.1f%%)\n", t.Table, formatSize(t.Beyond), formatSize(t.Size), t.Fraction*100)
+		}
+	}
+	if len(p.Methods) == 0 {
+		b.WriteString("no RPC methods affected\n")
+		return b.String()
+	}
+	b.WriteString("RPC methods returning pruned-data errors:\n")
+	for _, m := range p.Methods {
+		fmt.Fprintf(&b, "  %-28s blocks %d-%d (%s)\n", m.Method, m.From, m.To, strings.Join(m.Categories, ", "))
+	}
+	return b.String()
+}
+
+func formatSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
@@ -0,0 +1,169 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+// fixture - known pruned and total bytes per table, as written
+type fixture map[string][2]float64
+
+func (f fixture) add(table string, k, v []byte, share float64) {
+	size := float64(len(k) + len(v))
+	sizes := f[table]
+	sizes[0] += size * share
+	sizes[1] += size
+	f[table] = sizes
+}
+
+func writeFixture(t *testing.T, tx kv.RwTx, head, horizon uint64) fixture {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(1))
+	known := make(fixture)
+	put := func(table string, k, v []byte, share float64) {
+		if err := tx.Put(table, k, v); err != nil {
+			t.Fatal(err)
+		}
+		known.add(table, k, v, share)
+	}
+	below := func(n uint64) float64 {
+		if n < horizon {
+			return 1
+		}
+		return 0
+	}
+
+	hash := make([]byte, 32)
+	rnd.Read(hash)
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, head)
+	if err := tx.Put(kv.HeadBlockKey, []byte(kv.HeadBlockKey), hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put(kv.HeaderNumber, hash, num); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := uint64(0); n <= head; n++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, n)
+		// later blocks carry more data, so extrapolating from the head would overestimate
+		put(kv.Receipts, key, make([]byte, 40+n/50+uint64(rnd.Intn(64))), below(n))
+		for i := 0; i < rnd.Intn(4); i++ {
+			logKey := make([]byte, 12)
+			copy(logKey, key)
+			binary.BigEndian.PutUint32(logKey[8:], uint32(i))
+			put(kv.Log, logKey, make([]byte, 100+rnd.Intn(400)), below(n))
+		}
+		for i := 0; i < 1+rnd.Intn(3); i++ {
+			v := make([]byte, 20+rnd.Intn(60))
+			rnd.Read(v)
+			put(kv.AccountChangeSet, key, v, below(n))
+		}
+		for i := 0; i < rnd.Intn(3); i++ {
+			txHash := make([]byte, 32)
+			rnd.Read(txHash)
+			put(kv.TxLookup, txHash, new(big.Int).SetUint64(n).Bytes(), below(n))
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		key := make([]byte, 24)
+		rnd.Read(key[:20])
+		binary.BigEndian.PutUint32(key[20:], math.MaxUint32)
+		bm := roaring.New()
+		for j := 0; j < 1+rnd.Intn(500); j++ {
+			bm.Add(uint32(rnd.Int63n(int64(head + 1))))
+		}
+		v, err := bm.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(kv.LogAddressIndex, key, v, float64(bm.Rank(uint32(horizon-1)))/float64(bm.GetCardinality()))
+	}
+	return known
+}
+
+func TestProjectAccuracy(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	const head, older = 9999, 3000
+	known := writeFixture(t, tx, head, head-older)
+
+	p, err := Project(tx, Settings{Older: older})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Head != head || p.Horizon != head-older {
+		t.Fatalf("head %d horizon %d, want %d %d", p.Head, p.Horizon, head, head-older)
+	}
+	checked := 0
+	for _, c := range p.Categories {
+		for _, tp := range c.Tables {
+			sizes, ok := known[tp.Table]
+			if !ok {
+				if tp.Beyond != 0 {
+					t.Fatalf("%s: empty table projected %d bytes", tp.Table, tp.Beyond)
+				}
+				continue
+			}
+			want := sizes[0] / sizes[1]
+			if math.Abs(tp.Fraction-want) > want*0.1 {
+				t.Fatalf("%s: projected fraction %.3f, known %.3f", tp.Table, tp.Fraction, want)
+			}
+			checked++
+		}
+	}
+	if checked != len(known) {
+		t.Fatalf("checked %d tables, want %d", checked, len(known))
+	}
+
+	for _, m := range p.Methods {
+		if m.From != 0 || m.To != head-older-1 {
+			t.Fatalf("%s: range %d-%d", m.Method, m.From, m.To)
+		}
+	}
+	if !strings.Contains(p.String(), "eth_getLogs") {
+		t.Fatalf("human-readable report misses affected methods:\n%s", p)
+	}
+	if _, err := json.Marshal(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectNothingToPrune(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeFixture(t, tx, 100, 0)
+
+	p, err := Project(tx, Settings{Older: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Horizon != 0 || p.Beyond != 0 || len(p.Methods) != 0 {
+		t.Fatalf("unexpected projection: %+v", p)
+	}
+}
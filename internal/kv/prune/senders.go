@@ -0,0 +1,62 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/walk"
+)
+
+// PruneSenders - deletes Senders records of blocks below the senders prune setting stored in DatabaseInfo,
+// at most limit records per call (limit <= 0 - no limit). Records are block-prefixed, so the next call
+// simply starts from the first record left.
+// done - nothing is left to prune at this head.
+func PruneSenders(tx kv.RwTx, head uint64, limit int) (done bool, err error) {
+	m, err := ReadPruneMode(tx)
+	if err != nil {
+		return false, err
+	}
+	pruneTo, ok := PruneThreshold(m.Senders, head)
+	if !ok {
+		return true, nil
+	}
+
+	c, err := tx.RwCursor(kv.Senders)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	deleted, stopped := 0, false
+	if err := walk.Cursor(context.Background(), c, 0, pruneTo, func(blockNum uint64, _, _ []byte) error {
+		if limit > 0 && deleted == limit {
+			stopped = true
+			return walk.ErrStop
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return fmt.Errorf("failed to remove senders of block %d: %w", blockNum, err)
+		}
+		deleted++
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return !stopped, nil
+}
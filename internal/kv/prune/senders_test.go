@@ -0,0 +1,57 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package prune
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+func TestPruneSenders(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	for b := uint64(0); b < 10; b++ {
+		if err := tx.Put(kv.Senders, append(kv.EncodeBlockNum(b), 0xaa), make([]byte, kv.AddrLen)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if done, err := PruneSenders(tx, 9, 0); err != nil || !done {
+		t.Fatalf("pruning disabled: done %t, err %v", done, err)
+	}
+	if err := tx.Put(kv.DatabaseInfo, kv.PruneSenders, kv.EncodeBlockNum(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// distance 3 at head 10 keeps blocks 7 and up: 7 records to delete, 3 per call
+	for _, want := range []bool{false, false, true} {
+		if done, err := PruneSenders(tx, 10, 3); err != nil || done != want {
+			t.Fatalf("done %t, want %t, err %v", done, want, err)
+		}
+	}
+	var blocks []uint64
+	if err := tx.ForEach(kv.Senders, nil, func(k, _ []byte) error {
+		blocks = append(blocks, binary.BigEndian.Uint64(k))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || blocks[0] != 7 {
+		t.Fatalf("blocks left %v", blocks)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// CrashRecoveryCheckTables - tables to verify after unclean shutdown: head pointers, stages progress
+// and tables which hold data of last written blocks
+func CrashRecoveryCheckTables() []string {
+	return []string{
+		HeadBlockKey,
+		HeadHeaderKey,
+		LastForkchoice,
+		SyncStageProgress,
+
+		HeaderCanonical,
+		Headers,
+		HeaderNumber,
+		HeaderTD,
+		BlockBody,
+		EthTx,
+		Senders,
+		Receipts,
+	}
+}
+
+// VerifyHeadConsistency - checks that HeadBlockKey and HeadHeaderKey point to existing canonical headers.
+// Empty pointers (fresh db) are not errors.
+func VerifyHeadConsistency(tx Getter) []error {
+	var errs []error
+	for _, pointer := range []string{HeadBlockKey, HeadHeaderKey} {
+		if err := verifyHeadPointer(tx, pointer); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func verifyHeadPointer(tx Getter, pointer string) error {
+	hash, err := tx.GetOne(pointer, []byte(pointer))
+	if err != nil {
+		return fmt.Errorf("%s: %w", pointer, err)
+	}
+	if len(hash) == 0 {
+		return nil
+	}
+	number, err := tx.GetOne(HeaderNumber, hash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pointer, err)
+	}
+	if len(number) != 8 {
+		return fmt.Errorf("%s: dangling hash %x, no HeaderNumber record", pointer, hash)
+	}
+	canonical, err := tx.GetOne(HeaderCanonical, number)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pointer, err)
+	}
+	if !bytes.Equal(canonical, hash) {
+		return fmt.Errorf("%s: hash %x is not canonical at block %x, canonical is %x", pointer, hash, number, canonical)
+	}
+	ok, err := tx.Has(Headers, HeaderKey(binary.BigEndian.Uint64(number), hash))
+	if err != nil {
+		return fmt.Errorf("%s: %w", pointer, err)
+	}
+	if !ok {
+		return fmt.Errorf("%s: dangling hash %x, no header at block %x", pointer, hash, number)
+	}
+	return nil
+}
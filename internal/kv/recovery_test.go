@@ -0,0 +1,78 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func putHeader(tx *mockTx, number uint64, hash []byte, canonical bool) {
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, number)
+	_ = tx.Put(Headers, append(append([]byte{}, num...), hash...), []byte{0xc0})
+	_ = tx.Put(HeaderNumber, hash, num)
+	if canonical {
+		_ = tx.Put(HeaderCanonical, num, hash)
+	}
+}
+
+func TestCrashRecoveryCheckTables(t *testing.T) {
+	tables := CrashRecoveryCheckTables()
+	for _, name := range []string{HeadBlockKey, HeadHeaderKey, LastForkchoice, SyncStageProgress, Headers, EthTx} {
+		found := false
+		for _, table := range tables {
+			found = found || table == name
+		}
+		if !found {
+			t.Fatalf("%s is missing", name)
+		}
+	}
+}
+
+func TestVerifyHeadConsistency(t *testing.T) {
+	tx := newMockTx()
+	if errs := VerifyHeadConsistency(tx); len(errs) != 0 {
+		t.Fatalf("fresh db must be consistent: %v", errs)
+	}
+
+	head := make([]byte, 32)
+	head[0] = 1
+	putHeader(tx, 10, head, true)
+	_ = tx.Put(HeadBlockKey, []byte(HeadBlockKey), head)
+	_ = tx.Put(HeadHeaderKey, []byte(HeadHeaderKey), head)
+	if errs := VerifyHeadConsistency(tx); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// head block points to a header which was never written
+	dangling := make([]byte, 32)
+	dangling[0] = 2
+	_ = tx.Put(HeadBlockKey, []byte(HeadBlockKey), dangling)
+	if errs := VerifyHeadConsistency(tx); len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+
+	// head header points to a non-canonical header
+	sibling := make([]byte, 32)
+	sibling[0] = 3
+	putHeader(tx, 10, sibling, false)
+	_ = tx.Put(HeadHeaderKey, []byte(HeadHeaderKey), sibling)
+	if errs := VerifyHeadConsistency(tx); len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// TableGroup - database a table is created in
+type TableGroup uint8
+
+const (
+	TableGroupChaindata TableGroup = iota
+	TableGroupTxPool
+	TableGroupSentry
+	TableGroupDownloader
+	TableGroupRecon
+)
+
+func (g TableGroup) String() string {
+	switch g {
+	case TableGroupChaindata:
+		return "chaindata"
+	case TableGroupTxPool:
+		return "txpool"
+	case TableGroupSentry:
+		return "sentry"
+	case TableGroupDownloader:
+		return "downloader"
+	case TableGroupRecon:
+		return "recon"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(g))
+	}
+}
+
+var registryLock sync.RWMutex
+
+// groupTables - active table list and config map of group
+func groupTables(g TableGroup) (*[]string, TableCfg, error) {
+	switch g {
+	case TableGroupChaindata:
+		return &ChaindataTables, ChaindataTablesCfg, nil
+	case TableGroupTxPool:
+		return &TxPoolTables, TxpoolTablesCfg, nil
+	case TableGroupSentry:
+		return &SentryTables, SentryTablesCfg, nil
+	case TableGroupDownloader:
+		return &DownloaderTables, DownloaderTablesCfg, nil
+	case TableGroupRecon:
+		return &ReconTables, ReconTablesCfg, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown table group %d", g)
+	}
+}
+
+var allTableGroups = []TableGroup{TableGroupChaindata, TableGroupTxPool, TableGroupSentry, TableGroupDownloader, TableGroupRecon}
+
+// RegisterTable - adds table to group, meant to be called from init of the module owning the table,
+// so that tables.go doesn't need to know about it. Names are unique across all groups.
+// Tables registered with cfg.IsDeprecated are opened only if they exist and are not listed by Tables.
+func RegisterTable(name string, cfg TableCfgItem, group TableGroup) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	tables, tablesCfg, err := groupTables(group)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("empty table name")
+	}
+	if _, ok := lookup(name); ok {
+		return fmt.Errorf("table %s is already registered", name)
+	}
+
+	cfg.DBI = 0
+	tablesCfg[name] = cfg
+	if cfg.IsDeprecated {
+		if group == TableGroupChaindata {
+			ChaindataDeprecatedTables = append(ChaindataDeprecatedTables, name)
+		}
+		return nil
+	}
+	// fresh slice: callers may hold the previous one
+	res := make([]string, 0, len(*tables)+1)
+	res = append(append(res, *tables...), name)
+	sort.Strings(res)
+	*tables = res
+	return nil
+}
+
+// Tables - sorted copy of the active tables of group, in DBI assignment order
+func Tables(group TableGroup) []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	tables, _, err := groupTables(group)
+	if err != nil {
+		return nil
+	}
+	res := append([]string(nil), *tables...)
+	sort.Strings(res)
+	return res
+}
+
+// Lookup - config of table in any group, including deprecated tables
+func Lookup(name string) (TableCfgItem, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return lookup(name)
+}
+
+func lookup(name string) (TableCfgItem, bool) {
+	for _, g := range allTableGroups {
+		_, tablesCfg, _ := groupTables(g)
+		if cfg, ok := tablesCfg[name]; ok {
+			return cfg, true
+		}
+	}
+	return TableCfgItem{}, false
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import "fmt"
+
+// SetRetention - sets the RetentionBlocks hint of a chaindata table, 0 keeps all blocks again
+func SetRetention(table string, blocks uint64) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	cfg, ok := ChaindataTablesCfg[table]
+	if !ok {
+		return fmt.Errorf("unknown chaindata table %s", table)
+	}
+	if blocks > 0 && !BlockKeyed(table) {
+		return fmt.Errorf("table %s is not keyed by block number, retention is not supported", table)
+	}
+	cfg.RetentionBlocks = blocks
+	ChaindataTablesCfg[table] = cfg
+	return nil
+}
+
+// TablesWithRetention - ChaindataTables with the RetentionBlocks hint, mapped to their window
+func TablesWithRetention() map[string]uint64 {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	res := make(map[string]uint64)
+	for _, name := range ChaindataTables {
+		if blocks := ChaindataTablesCfg[name].RetentionBlocks; blocks > 0 {
+			res[name] = blocks
+		}
+	}
+	return res
+}
+
+// PruneByRetention - deletes records of every table with the RetentionBlocks hint which are older than its
+// window at head: blocks head-RetentionBlocks+1..head are kept. Returns amount of deleted records per table.
+func PruneByRetention(tx RwTx, head uint64) (map[string]uint64, error) {
+	deleted := make(map[string]uint64)
+	for table, blocks := range TablesWithRetention() {
+		if !BlockKeyed(table) {
+			return deleted, fmt.Errorf("table %s is not keyed by block number, retention is not supported", table)
+		}
+		if head < blocks {
+			continue
+		}
+		n, err := pruneBelow(tx, table, head-blocks+1)
+		deleted[table] = n
+		if err != nil {
+			return deleted, fmt.Errorf("prune %s: %w", table, err)
+		}
+	}
+	return deleted, nil
+}
+
+// pruneBelow - deletes records of table keyed by block numbers below to
+func pruneBelow(tx RwTx, table string, to uint64) (uint64, error) {
+	c, err := tx.RwCursor(table)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	var deleted uint64
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return deleted, err
+		}
+		num, err := DecodeBlockNum(k)
+		if err != nil {
+			return deleted, err
+		}
+		if num >= to {
+			break
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestPruneByRetention(t *testing.T) {
+	for table, blocks := range map[string]uint64{Receipts: 3, Log: 5} {
+		if err := SetRetention(table, blocks); err != nil {
+			t.Fatal(err)
+		}
+		table := table
+		t.Cleanup(func() { _ = SetRetention(table, 0) })
+	}
+	if err := SetRetention(HeaderNumber, 5); err == nil {
+		t.Fatal("retention of hash keyed table accepted")
+	}
+	if err := SetRetention("NoSuchTable", 5); err == nil {
+		t.Fatal("retention of unknown table accepted")
+	}
+	if have := TablesWithRetention(); !reflect.DeepEqual(have, map[string]uint64{Receipts: 3, Log: 5}) {
+		t.Fatalf("tables with retention %v", have)
+	}
+
+	tx := newMockTx()
+	const blocks = 10
+	for num := uint64(0); num < blocks; num++ {
+		hash := bytes.Repeat([]byte{byte(num + 1)}, HashLen)
+		for _, put := range []struct {
+			table string
+			k     []byte
+		}{
+			{Headers, HeaderKey(num, hash)},
+			{Receipts, EncodeBlockNum(num)},
+			{Log, binary.BigEndian.AppendUint32(EncodeBlockNum(num), 0)},
+			{Log, binary.BigEndian.AppendUint32(EncodeBlockNum(num), 1)},
+		} {
+			if err := tx.Put(put.table, put.k, []byte{1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	count := func(table string) (n int, first uint64) {
+		first = blocks
+		if err := tx.ForEach(table, nil, func(k, _ []byte) error {
+			if num, _ := DecodeBlockNum(k); n == 0 {
+				first = num
+			}
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n, first
+	}
+
+	// window reaches past genesis, nothing to delete
+	deleted, err := PruneByRetention(tx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted[Receipts] != 0 || deleted[Log] != 0 {
+		t.Fatalf("deleted %v at head 2", deleted)
+	}
+
+	deleted, err = PruneByRetention(tx, blocks-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, map[string]uint64{Receipts: 7, Log: 10}) {
+		t.Fatalf("deleted %v", deleted)
+	}
+	for _, c := range []struct {
+		table string
+		n     int
+		first uint64
+	}{{Receipts, 3, 7}, {Log, 10, 5}, {Headers, blocks, 0}} {
+		if n, first := count(c.table); n != c.n || first != c.first {
+			t.Fatalf("%s: %d records from block %d, want %d from %d", c.table, n, first, c.n, c.first)
+		}
+	}
+
+	deleted, err = PruneByRetention(tx, blocks-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted[Receipts] != 0 || deleted[Log] != 0 {
+		t.Fatalf("second pass deleted %v", deleted)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Table categories of TableDescriptor
+const (
+	CategoryState     = "state"
+	CategoryHistory   = "history"
+	CategoryChain     = "chain"
+	CategoryIndex     = "index"
+	CategoryTrie      = "trie"
+	CategoryConsensus = "consensus"
+	CategoryMeta      = "meta"
+	CategoryDomain    = "domain"
+	CategoryBor       = "bor"
+)
+
+// TableDescriptor - machine-readable description of 1 table, for external schema registries
+type TableDescriptor struct {
+	Name           string `json:"name"`        // name of table in db
+	LogicalName    string `json:"logicalName"` // name of constant in this package
+	Flags          string `json:"flags"`
+	KeyLayout      string `json:"keyLayout"`
+	ValueEncoding  string `json:"valueEncoding"`
+	Category       string `json:"category"`
+	Deprecated     bool   `json:"deprecated"`
+	BloomEnabled   bool   `json:"bloomEnabled"`
+	WriteFrequency string `json:"writeFrequency"`
+	Derived        bool   `json:"derived"`
+	// RetentionBlocks - 0 when the table keeps all blocks
+	RetentionBlocks uint64 `json:"retentionBlocks,omitempty"`
+	Compressibility string `json:"compressibility"`
+}
+
+type tableSchema struct {
+	logical, key, value, category string
+}
+
+// invertedIndex - schema of the Keys/Idx pair of an inverted index
+func invertedIndex(logical, key, category string) (keys, idx tableSchema) {
+	return tableSchema{logical + "Keys", "tx_num_u64", key + " (DupSort)", category},
+		tableSchema{logical + "Idx", key, "tx_num_u64 (DupSort)", category}
+}
+
+// domain - schema of the Keys/Vals/HistoryKeys/HistoryVals/Settings/Idx tables of a state domain
+func domain(logical, key string) []tableSchema {
+	keys, idx := invertedIndex(logical, key, CategoryDomain)
+	return []tableSchema{
+		keys,
+		{logical + "Vals", key + "+tx_num_u64", "value", CategoryDomain},
+		{logical + "HistoryKeys", "tx_num_u64", key + " (DupSort)", CategoryDomain},
+		{logical + "HistoryVals", key + "+tx_num_u64", "value before change", CategoryDomain},
+		{logical + "Settings", "setting name", "setting value", CategoryDomain},
+		idx,
+	}
+}
+
+var tableSchemas = map[string]tableSchema{
+	PlainState:        {"PlainState", "address | address+incarnation_u64", "account (storage encoding) | storage_key+storage_value (DupSort)", CategoryState},
+	PlainContractCode: {"PlainContractCode", "address+incarnation_u64", "code_hash", CategoryState},
+	HashedAccounts:    {"HashedAccounts", "address_hash", "account (storage encoding)", CategoryState},
+	HashedStorage:     {"HashedStorage", "address_hash+incarnation_u64+storage_key_hash", "storage_value", CategoryState},
+	Code:              {"Code", "code_hash", "bytecode", CategoryState},
+	ContractCode:      {"ContractCode", "address_hash+incarnation_u64", "code_hash", CategoryState},
+	IncarnationMap:    {"IncarnationMap", "address", "incarnation_u64", CategoryState},
+	ContractTEVMCode:  {"ContractTEVMCode", "code_hash", "TEVM code", CategoryState},
+
+	AccountChangeSet: {"AccountChangeSet", "block_num_u64", "address+account before change (DupSort)", CategoryHistory},
+	StorageChangeSet: {"StorageChangeSet", "block_num_u64+address+incarnation_u64", "storage_key+storage_value before change (DupSort)", CategoryHistory},
+	AccountsHistory:  {"AccountsHistory", "address+shard_id_u64", "roaring64 bitmap of block numbers", CategoryHistory},
+	StorageHistory:   {"StorageHistory", "address+storage_key+shard_id_u64", "roaring64 bitmap of block numbers", CategoryHistory},
+
+	TrieOfAccounts: {"TrieOfAccounts", "nibbles", "trie node: hasState+hasTree+hasHash+hashes", CategoryTrie},
+	TrieOfStorage:  {"TrieOfStorage", "address_hash+incarnation_u64+nibbles", "trie node: hasState+hasTree+hasHash+hashes", CategoryTrie},
+
+	HeaderNumber:    {"HeaderNumber", "header_hash", "block_num_u64", CategoryChain},
+	HeaderCanonical: {"HeaderCanonical", "block_num_u64", "header_hash", CategoryChain},
+	Headers:         {"Headers", "block_num_u64+header_hash", "header (RLP)", CategoryChain},
+	HeaderTD:        {"HeaderTD", "block_num_u64+header_hash", "total difficulty (RLP)", CategoryChain},
+	BlockBody:       {"BlockBody", "block_num_u64+header_hash", "body for storage: base_tx_id+tx_amount+uncles", CategoryChain},
+	EthTx:           {"EthTx", "tx_id_u64", "transaction", CategoryChain},
+	NonCanonicalTxs: {"NonCanonicalTxs", "tx_id_u64", "transaction", CategoryChain},
+	Senders:         {"Senders", "block_num_u64+header_hash", "sender addresses, 20 bytes each or deduplicated", CategoryChain},
+	Receipts:        {"Receipts", "block_num_u64", "receipts of canonical block", CategoryChain},
+	Log:             {"Log", "block_num_u64+tx_index_u32", "logs of transaction", CategoryChain},
+	Issuance:        {"Issuance", "block_num_u64", "RLP(issuance, burnt)", CategoryChain},
+	HeadBlockKey:    {"HeadBlockKey", "\"" + HeadBlockKey + "\"", "header_hash", CategoryChain},
+	HeadHeaderKey:   {"HeadHeaderKey", "\"" + HeadHeaderKey + "\"", "header_hash", CategoryChain},
+	LastForkchoice:  {"LastForkchoice", "headBlockHash | safeBlockHash | finalizedBlockHash", "header_hash", CategoryChain},
+
+	TxLookup:                   {"TxLookup", "tx_hash", "block_num (big endian, leading zeros trimmed)", CategoryIndex},
+	LogTopicIndex:              {"LogTopicIndex", "topic+shard_id_u32", "roaring bitmap of block numbers", CategoryIndex},
+	LogAddressIndex:            {"LogAddressIndex", "address+shard_id_u32", "roaring bitmap of block numbers", CategoryIndex},
+	CallTraceSet:               {"CallTraceSet", "block_num_u64", "address+from/to flags (DupSort)", CategoryIndex},
+	CallFromIndex:              {"CallFromIndex", "address+shard_id_u64", "roaring64 bitmap of block numbers", CategoryIndex},
+	CallToIndex:                {"CallToIndex", "address+shard_id_u64", "roaring64 bitmap of block numbers", CategoryIndex},
+	CumulativeGasIndex:         {"CumulativeGasIndex", "block_num_u64", "cumulative gas used_u64", CategoryIndex},
+	CumulativeTransactionIndex: {"CumulativeTransactionIndex", "block_num_u64", "cumulative transactions amount_u64", CategoryIndex},
+
+	DatabaseInfo:      {"DatabaseInfo", "option name", "option value", CategoryMeta},
+	ConfigTable:       {"ConfigTable", "genesis_hash", "chain config (JSON)", CategoryMeta},
+	SyncStageProgress: {"SyncStageProgress", "stage name", "block_num_u64", CategoryMeta},
+	Migrations:        {"Migrations", "migration name", "stages progress when migration applied", CategoryMeta},
+	Sequence:          {"Sequence", "table name", "sequence_u64", CategoryMeta},
+	Snapshots:         {"Snapshots", "snapshot name", "snapshot hash", CategoryMeta},
+
+	CurrentExecutionPayload: {"CurrentExecutionPayload", "payload key", "execution payload", CategoryConsensus},
+	CliqueSeparate:          {"CliqueSeparate", "signer key", "signer data", CategoryConsensus},
+	CliqueSnapshot:          {"CliqueSnapshot", "block_num_u64+header_hash", "snapshot (JSON)", CategoryConsensus},
+	CliqueLastSnapshot:      {"CliqueLastSnapshot", "last snapshot key", "block_num_u64+header_hash", CategoryConsensus},
+	ParliaSnapshot:          {"ParliaSnapshot", "snapshot prefix+block_num_u64+header_hash", "snapshot (JSON)", CategoryConsensus},
+	Epoch:                   {"Epoch", "block_num_u64+header_hash", "transition proof", CategoryConsensus},
+	PendingEpoch:            {"PendingEpoch", "block_num_u64+header_hash", "transition proof", CategoryConsensus},
+	Clique:                  {"Clique", "snapshot key", "snapshot (JSON)", CategoryConsensus},
+	TransitionBlockKey:      {"TransitionBlockKey", "\"" + TransitionBlockKey + "\"", "header_hash", CategoryConsensus},
+
+	StateAccounts:   {"StateAccounts", "address", "account", CategoryDomain},
+	StateStorage:    {"StateStorage", "address+storage_key", "storage_value", CategoryDomain},
+	StateCode:       {"StateCode", "address", "bytecode", CategoryDomain},
+	StateCommitment: {"StateCommitment", "commitment key", "commitment branch", CategoryDomain},
+
+	BorReceipts: {"BorReceipts", "block_num_u64", "state sync receipt", CategoryBor},
+	BorTxLookup: {"BorTxLookup", "tx_hash", "block_num", CategoryBor},
+	BorSeparate: {"BorSeparate", "span or checkpoint key", "span or checkpoint data", CategoryBor},
+}
+
+func init() {
+	var families []tableSchema
+	for _, d := range [][2]string{{"Account", "address"}, {"Storage", "address+storage_key"}, {"Code", "address"}} {
+		families = append(families, domain(d[0], d[1])...)
+	}
+	for _, ii := range [][3]string{
+		{"LogAddress", "address", CategoryIndex},
+		{"LogTopics", "topic", CategoryIndex},
+		{"TracesFrom", "address", CategoryIndex},
+		{"TracesTo", "address", CategoryIndex},
+		{"RAccount", "address", CategoryDomain},
+		{"RStorage", "address+storage_key", CategoryDomain},
+		{"RCode", "address", CategoryDomain},
+	} {
+		keys, idx := invertedIndex(ii[0], ii[1], ii[2])
+		families = append(families, keys, idx)
+	}
+	// constants of these tables have same value as their logical name
+	for _, s := range families {
+		tableSchemas[s.logical] = s
+	}
+}
+
+// Compressibility - how well values of a table compress, zero value is CompressibilityMedium.
+// Compression is worth enabling by default on CompressibilityHigh tables.
+type Compressibility uint8
+
+const (
+	CompressibilityMedium Compressibility = iota
+	CompressibilityHigh
+	CompressibilityLow
+)
+
+func (c Compressibility) String() string {
+	switch c {
+	case CompressibilityHigh:
+		return "high"
+	case CompressibilityLow:
+		return "low"
+	default:
+		return "medium"
+	}
+}
+
+// compressibilityRules - value encoding markers, the first matching one classifies a table.
+// Hashes, code and addresses are close to random, roaring bitmaps are already compact,
+// structured encodings repeat field layouts and small numbers.
+var compressibilityRules = []struct {
+	marker *regexp.Regexp
+	class  Compressibility
+}{
+	{regexp.MustCompile(`roaring`), CompressibilityMedium},
+	{regexp.MustCompile(`hash`), CompressibilityLow},
+	{regexp.MustCompile(`\bbytecode\b|\bsender addresses\b`), CompressibilityLow},
+	{regexp.MustCompile(`\bRLP\b|\bJSON\b`), CompressibilityHigh},
+	{regexp.MustCompile(`\b(receipts|logs|transaction|account)\b`), CompressibilityHigh},
+}
+
+// CompressibilityClass - compressibility of table values inferred from their ValueEncoding,
+// CompressibilityMedium for tables without schema or known markers
+func CompressibilityClass(table string) Compressibility {
+	s, ok := tableSchemas[table]
+	if !ok {
+		return CompressibilityMedium
+	}
+	for _, r := range compressibilityRules {
+		if r.marker.MatchString(s.value) {
+			return r.class
+		}
+	}
+	return CompressibilityMedium
+}
+
+func (f TableFlags) String() string {
+	if f == Default {
+		return "Default"
+	}
+	var names []string
+	for _, flag := range []struct {
+		flag TableFlags
+		name string
+	}{{ReverseKey, "ReverseKey"}, {DupSort, "DupSort"}, {IntegerKey, "IntegerKey"}, {IntegerDup, "IntegerDup"}, {ReverseDup, "ReverseDup"}} {
+		if f&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// StructuredSchema - descriptors of ChaindataTables followed by ChaindataDeprecatedTables.
+// Fields unknown for a table stay empty.
+func StructuredSchema() []TableDescriptor {
+	res := make([]TableDescriptor, 0, len(ChaindataTables)+len(ChaindataDeprecatedTables))
+	for _, tables := range [][]string{ChaindataTables, ChaindataDeprecatedTables} {
+		for _, name := range tables {
+			cfg := ChaindataTablesCfg[name]
+			s := tableSchemas[name]
+			res = append(res, TableDescriptor{
+				Name:            name,
+				LogicalName:     s.logical,
+				Flags:           cfg.Flags.String(),
+				KeyLayout:       s.key,
+				ValueEncoding:   s.value,
+				Category:        s.category,
+				Deprecated:      cfg.IsDeprecated,
+				BloomEnabled:    cfg.BloomEnabled,
+				WriteFrequency:  cfg.WriteFrequency.String(),
+				Derived:         cfg.Derived,
+				RetentionBlocks: cfg.RetentionBlocks,
+				Compressibility: CompressibilityClass(name).String(),
+			})
+		}
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import "testing"
+
+func TestStructuredSchema(t *testing.T) {
+	schema := StructuredSchema()
+	if len(schema) != len(ChaindataTables)+len(ChaindataDeprecatedTables) {
+		t.Fatalf("have %d descriptors, want %d", len(schema), len(ChaindataTables)+len(ChaindataDeprecatedTables))
+	}
+	logical := make(map[string]string)
+	for i, d := range schema {
+		if d.Name == "" || d.LogicalName == "" || d.Flags == "" || d.KeyLayout == "" || d.ValueEncoding == "" || d.Category == "" || d.WriteFrequency == "" {
+			t.Fatalf("incomplete descriptor: %+v", d)
+		}
+		if other, ok := logical[d.LogicalName]; ok {
+			t.Fatalf("tables %s and %s have same logical name %s", d.Name, other, d.LogicalName)
+		}
+		logical[d.LogicalName] = d.Name
+		if d.Deprecated != (i >= len(ChaindataTables)) {
+			t.Fatalf("%s: deprecated %t", d.Name, d.Deprecated)
+		}
+	}
+
+	for _, d := range schema {
+		if d.Name == PlainState && (d.Flags != "DupSort" || d.Category != CategoryState || !d.BloomEnabled || d.WriteFrequency != "high") {
+			t.Fatalf("unexpected PlainState descriptor: %+v", d)
+		}
+		if d.Name == HeaderCanonical && d.LogicalName != "HeaderCanonical" {
+			t.Fatalf("unexpected HeaderCanonical descriptor: %+v", d)
+		}
+	}
+}
+
+func TestCompressibilityClass(t *testing.T) {
+	for table, want := range map[string]Compressibility{
+		Receipts:        CompressibilityHigh,
+		Log:             CompressibilityHigh,
+		Headers:         CompressibilityHigh,
+		EthTx:           CompressibilityHigh,
+		ConfigTable:     CompressibilityHigh,
+		Code:            CompressibilityLow,
+		HeaderCanonical: CompressibilityLow,
+		TrieOfAccounts:  CompressibilityLow,
+		Senders:         CompressibilityLow,
+		AccountsHistory: CompressibilityMedium,
+		LogTopicIndex:   CompressibilityMedium,
+		TxLookup:        CompressibilityMedium,
+		"NoSuchTable":   CompressibilityMedium,
+	} {
+		if have := CompressibilityClass(table); have != want {
+			t.Fatalf("%s: %s, want %s", table, have, want)
+		}
+	}
+	for _, d := range StructuredSchema() {
+		if d.Compressibility != CompressibilityClass(d.Name).String() {
+			t.Fatalf("%s: descriptor compressibility %s", d.Name, d.Compressibility)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// bodyForStorageLen - BaseTxId_u64 + TxAmount_u32, TxAmount includes reserved system-tx slots
+const bodyForStorageLen = 12
+
+var errStopCanonicalWalk = errors.New("stop canonical walk")
+
+// ReservedSequenceSpace - EthTx sequence ids taken by a block with userTxCount transactions
+// and a system-tx slot before and/or after them
+func ReservedSequenceSpace(userTxCount uint32, before, after bool) uint64 {
+	space := uint64(userTxCount)
+	if before {
+		space++
+	}
+	if after {
+		space++
+	}
+	return space
+}
+
+// FirstTxSeqForBlock - EthTx sequence id of the first slot of canonical block blockNum: the sum of
+// sequence space reserved by the bodies of all canonical blocks before it
+func FirstTxSeqForBlock(tx Tx, blockNum uint64) (uint64, error) {
+	var seq, next uint64
+	if err := tx.ForEach(HeaderCanonical, nil, func(k, hash []byte) error {
+		num, err := DecodeBlockNum(k)
+		if err != nil {
+			return err
+		}
+		if num >= blockNum {
+			return errStopCanonicalWalk
+		}
+		if num != next {
+			return fmt.Errorf("canonical hash of block %d is missing", next)
+		}
+		next++
+		body, err := ReadBodyForStorage(tx, num, hash)
+		if err != nil {
+			return err
+		}
+		if len(body) != bodyForStorageLen {
+			return fmt.Errorf("body of block %d: unexpected length %d", num, len(body))
+		}
+		seq += uint64(binary.BigEndian.Uint32(body[8:]))
+		return nil
+	}); err != nil && !errors.Is(err, errStopCanonicalWalk) {
+		return 0, err
+	}
+	if next != blockNum {
+		return 0, fmt.Errorf("canonical hash of block %d is missing", next)
+	}
+	return seq, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFirstTxSeqForBlock(t *testing.T) {
+	if ReservedSequenceSpace(3, true, true) != 5 || ReservedSequenceSpace(3, false, false) != 3 ||
+		ReservedSequenceSpace(0, true, false) != 1 || ReservedSequenceSpace(0, false, true) != 1 {
+		t.Fatal("unexpected reserved sequence space")
+	}
+
+	tx := newMockTx()
+	blocks := []struct {
+		userTxs       uint32
+		before, after bool
+	}{
+		{0, true, true},
+		{4, true, true},
+		{2, false, false},
+		{0, false, false},
+		{7, false, true},
+	}
+	var starts []uint64
+	for num, b := range blocks {
+		space := ReservedSequenceSpace(b.userTxs, b.before, b.after)
+		base, err := tx.IncrementSequence(EthTx, space)
+		if err != nil {
+			t.Fatal(err)
+		}
+		starts = append(starts, base)
+		hash := bytes.Repeat([]byte{byte(num + 1)}, HashLen)
+		body := make([]byte, 12)
+		binary.BigEndian.PutUint64(body, base)
+		binary.BigEndian.PutUint32(body[8:], uint32(space))
+		if err := tx.Put(HeaderCanonical, EncodeBlockNum(uint64(num)), hash); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteBodyForStorage(tx, uint64(num), hash, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for num, want := range starts {
+		have, err := FirstTxSeqForBlock(tx, uint64(num))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Fatalf("block %d: have first seq %d, want %d", num, have, want)
+		}
+	}
+	if have, err := FirstTxSeqForBlock(tx, uint64(len(blocks))); err != nil || have != 2+6+2+0+8 {
+		t.Fatalf("first seq after last block: %d %v", have, err)
+	}
+	if _, err := FirstTxSeqForBlock(tx, uint64(len(blocks))+1); err == nil {
+		t.Fatal("block beyond canonical chain accepted")
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// DefaultSnapshotRenewInterval - how long a Snapshot keeps one read transaction open
+const DefaultSnapshotRenewInterval = 5 * time.Second
+
+var (
+	// ErrSnapshotSchemaChanged - DBSchemaVersion changed under a Snapshot, keys read after renewal may have other layout
+	ErrSnapshotSchemaChanged = errors.New("snapshot: db schema version changed")
+	// ErrSnapshotUnwound - chain was unwound below the height a Snapshot is pinned to
+	ErrSnapshotUnwound = errors.New("snapshot: chain unwound below pinned height")
+)
+
+var snapshotRenewals = metrics.NewRegisteredCounter("db/snapshot/renewals", nil)
+
+// Snapshot - read view for long scans (eth_getLogs and alike).
+// Unlike Tx it does not pin one MDBX read transaction for the whole scan: the transaction is renewed every
+// renew interval between two walker calls and the cursor re-seeks to the last visited entry, so the free list
+// does not grow while writers commit. Between calls it holds no read transaction at all, an idle Snapshot
+// doesn't keep writers from reusing freed pages either.
+//
+// The view is pinned to the DBSchemaVersion, head block height and canonical hash at that height it was opened
+// at: data of blocks up to Height stays visible, and renewal fails with ErrSnapshotSchemaChanged or
+// ErrSnapshotUnwound instead of mixing layouts or silently reading unwound data. A reorg replacing the block at
+// Height counts as unwound, even if the new head is as high. Entries written after the snapshot was opened may become visible
+// after a renewal; block-keyed scans should stop at Height.
+//
+// A Snapshot must only be used by one goroutine at a time.
+type Snapshot interface {
+	Closer
+	Getter
+
+	SchemaVersion() []byte
+	Height() uint64
+	// Renewals - how many times the underlying read transaction was replaced
+	Renewals() uint64
+}
+
+type snapshot struct {
+	ctx      context.Context
+	db       RoDB
+	interval time.Duration
+
+	tx       Tx
+	begun    time.Time
+	version  []byte
+	height   uint64
+	hash     []byte // canonical hash at height
+	renewals uint64
+}
+
+// NewSnapshot - Snapshot over db renewing its read transaction every interval, used by RoDB implementations
+func NewSnapshot(ctx context.Context, db RoDB, interval time.Duration) (Snapshot, error) {
+	if interval <= 0 {
+		interval = DefaultSnapshotRenewInterval
+	}
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	s := &snapshot{ctx: ctx, db: db, interval: interval}
+	if s.version, s.height, err = readSnapshotPin(tx); err != nil {
+		return nil, err
+	}
+	if s.hash, err = tx.GetOne(HeaderCanonical, EncodeBlockNum(s.height)); err != nil {
+		return nil, err
+	}
+	s.hash = append([]byte{}, s.hash...)
+	return s, nil
+}
+
+func readSnapshotPin(tx Getter) (version []byte, height uint64, err error) {
+	v, err := tx.GetOne(DatabaseInfo, DBSchemaVersionKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if v != nil {
+		version = append([]byte{}, v...)
+	}
+	hash, err := tx.GetOne(HeadBlockKey, []byte(HeadBlockKey))
+	if err != nil || len(hash) == 0 {
+		return version, 0, err
+	}
+	num, err := tx.GetOne(HeaderNumber, hash)
+	if err != nil || len(num) == 0 {
+		return version, 0, err
+	}
+	height, err = DecodeBlockNum(num)
+	return version, height, err
+}
+
+// renew - begins the read transaction of a call, or replaces the one in use if it is older than interval.
+// Callers must not hold cursors of the old one.
+func (s *snapshot) renew() error {
+	if s.tx != nil && time.Since(s.begun) < s.interval {
+		return nil
+	}
+	replaced := s.tx != nil
+	s.release()
+	tx, err := s.db.BeginRo(s.ctx)
+	if err != nil {
+		return err
+	}
+	s.tx, s.begun = tx, time.Now()
+	version, height, err := readSnapshotPin(tx)
+	if err == nil && !bytes.Equal(version, s.version) {
+		err = fmt.Errorf("%w: %x -> %x", ErrSnapshotSchemaChanged, s.version, version)
+	}
+	if err == nil && height < s.height {
+		err = fmt.Errorf("%w: %d < %d", ErrSnapshotUnwound, height, s.height)
+	}
+	if err == nil {
+		var hash []byte
+		if hash, err = tx.GetOne(HeaderCanonical, EncodeBlockNum(s.height)); err == nil && !bytes.Equal(hash, s.hash) {
+			err = fmt.Errorf("%w: block %d %x -> %x", ErrSnapshotUnwound, s.height, s.hash, hash)
+		}
+	}
+	if err != nil {
+		s.release()
+		return err
+	}
+	if replaced {
+		s.renewals++
+		snapshotRenewals.Inc(1)
+	}
+	return nil
+}
+
+// release - ends the read transaction at the end of a call
+func (s *snapshot) release() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+func (s *snapshot) SchemaVersion() []byte { return s.version }
+func (s *snapshot) Height() uint64        { return s.height }
+func (s *snapshot) Renewals() uint64      { return s.renewals }
+
+func (s *snapshot) Close() { s.release() }
+
+// GetOne - the value is a copy, the transaction it was read in ends with the call
+func (s *snapshot) GetOne(bucket string, key []byte) ([]byte, error) {
+	if err := s.renew(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	v, err := s.tx.GetOne(bucket, key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (s *snapshot) Has(bucket string, key []byte) (bool, error) {
+	if err := s.renew(); err != nil {
+		return false, err
+	}
+	defer s.release()
+	return s.tx.Has(bucket, key)
+}
+
+func (s *snapshot) ForEach(bucket string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return s.walk(bucket, fromPrefix, nil, 0, walker)
+}
+
+func (s *snapshot) ForPrefix(bucket string, prefix []byte, walker func(k, v []byte) error) error {
+	return s.walk(bucket, prefix, prefix, 0, walker)
+}
+
+func (s *snapshot) ForAmount(bucket string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	if amount == 0 {
+		return nil
+	}
+	return s.walk(bucket, prefix, nil, amount, walker)
+}
+
+// walk - iterates from seek, stopping at the first key without prefix (if set) or after amount entries (if set).
+// At renewal the cursor is re-opened on the new transaction and positioned right after the last visited entry.
+func (s *snapshot) walk(bucket string, seek, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	cfg, _ := Lookup(bucket)
+	dupSort := cfg.Flags&DupSort != 0 && !cfg.AutoDupSortKeysConversion
+
+	if err := s.renew(); err != nil {
+		return err
+	}
+	defer s.release()
+	c, err := s.tx.Cursor(bucket)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+
+	var lastK, lastV []byte
+	k, v, err := c.Seek(seek)
+	for k != nil {
+		if err != nil {
+			return err
+		}
+		if prefix != nil && !bytes.HasPrefix(k, prefix) {
+			return nil
+		}
+		if err := walker(k, v); err != nil {
+			return err
+		}
+		if amount > 0 {
+			if amount--; amount == 0 {
+				return nil
+			}
+		}
+		if time.Since(s.begun) < s.interval {
+			k, v, err = c.Next()
+			continue
+		}
+
+		lastK = append(lastK[:0], k...)
+		lastV = append(lastV[:0], v...)
+		c.Close()
+		c = nil
+		if err := s.renew(); err != nil {
+			return err
+		}
+		if c, err = s.tx.Cursor(bucket); err != nil {
+			c = nil
+			return err
+		}
+		k, v, err = seekAfter(c, lastK, lastV, dupSort)
+	}
+	return err
+}
+
+// seekAfter - positions c at the first entry after lastK/lastV, which may have been deleted meanwhile
+func seekAfter(c Cursor, lastK, lastV []byte, dupSort bool) ([]byte, []byte, error) {
+	if !dupSort {
+		k, v, err := c.Seek(lastK)
+		if err != nil || k == nil || !bytes.Equal(k, lastK) {
+			return k, v, err
+		}
+		return c.Next()
+	}
+	dc := c.(CursorDupSort)
+	v, err := dc.SeekBothRange(lastK, lastV)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v != nil {
+		if !bytes.Equal(v, lastV) {
+			return lastK, v, nil
+		}
+		return c.Next()
+	}
+	// no dup of lastK from lastV on: first entry of the next key
+	k, v, err := c.Seek(lastK)
+	if err != nil || k == nil || !bytes.Equal(k, lastK) {
+		return k, v, err
+	}
+	return dc.NextNoDup()
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package stages
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// SyncStage - name of a sync stage, key of its records in SyncStageProgress
+type SyncStage string
+
+const (
+	Headers             SyncStage = "Headers"             // headers are downloaded and verified
+	Bodies              SyncStage = "Bodies"              // block bodies are downloaded
+	Senders             SyncStage = "Senders"             // transaction senders are recovered
+	Execution           SyncStage = "Execution"           // blocks are executed, PlainState and changesets are written
+	HashState           SyncStage = "HashState"           // PlainState is hashed into HashedAccounts and HashedStorage
+	IntermediateHashes  SyncStage = "IntermediateHashes"  // state root is verified
+	AccountHistoryIndex SyncStage = "AccountHistoryIndex" // AccountsHistory is built from AccountChangeSet
+	StorageHistoryIndex SyncStage = "StorageHistoryIndex" // StorageHistory is built from StorageChangeSet
+	LogIndex            SyncStage = "LogIndex"            // log topic and address indices are built
+	CallTraces          SyncStage = "CallTraces"          // call trace indices are built
+	TxLookup            SyncStage = "TxLookup"            // TxLookup is built
+	Finish              SyncStage = "Finish"              // all stages are done for the block
+)
+
+// AllStages - stages in execution order, unwinds run in reverse order
+var AllStages = []SyncStage{
+	Headers,
+	Bodies,
+	Senders,
+	Execution,
+	HashState,
+	IntermediateHashes,
+	AccountHistoryIndex,
+	StorageHistoryIndex,
+	LogIndex,
+	CallTraces,
+	TxLookup,
+	Finish,
+}
+
+// Key prefixes of the unwind point and invalidation records of a stage, its progress is keyed by the bare name
+const (
+	unwindPrefix       = "unwind_"
+	invalidationPrefix = "invalid_"
+)
+
+// EncodeStageData - 8 bytes big-endian block number followed by optional stage specific metadata
+func EncodeStageData(blockNum uint64, meta []byte) []byte {
+	v := make([]byte, 8, 8+len(meta))
+	binary.BigEndian.PutUint64(v, blockNum)
+	return append(v, meta...)
+}
+
+// DecodeStageData - inverse of EncodeStageData. A legacy record is a raw uint64 without metadata,
+// an absent record is block 0.
+func DecodeStageData(v []byte) (blockNum uint64, meta []byte, err error) {
+	if len(v) == 0 {
+		return 0, nil, nil
+	}
+	if len(v) < 8 {
+		return 0, nil, fmt.Errorf("stage data length %d, want at least 8", len(v))
+	}
+	if len(v) > 8 {
+		meta = append([]byte{}, v[8:]...)
+	}
+	return binary.BigEndian.Uint64(v), meta, nil
+}
+
+func getStageData(tx kv.Getter, key string) (uint64, []byte, error) {
+	v, err := tx.GetOne(kv.SyncStageProgress, []byte(key))
+	if err != nil {
+		return 0, nil, err
+	}
+	n, meta, err := DecodeStageData(v)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, meta, nil
+}
+
+// GetStageProgress - block the stage has processed, 0 if it never ran
+func GetStageProgress(tx kv.Getter, stage SyncStage) (uint64, error) {
+	n, _, err := getStageData(tx, string(stage))
+	return n, err
+}
+
+// SaveStageProgress - sets the progress of the stage and drops its metadata
+func SaveStageProgress(tx kv.Putter, stage SyncStage, progress uint64) error {
+	return tx.Put(kv.SyncStageProgress, []byte(stage), EncodeStageData(progress, nil))
+}
+
+// GetStageData - progress of the stage with the metadata saved along with it, nil if there is none
+func GetStageData(tx kv.Getter, stage SyncStage) (progress uint64, meta []byte, err error) {
+	return getStageData(tx, string(stage))
+}
+
+// SaveStageData - sets the progress of the stage with stage specific metadata, e.g. the position
+// of an interrupted run inside the block
+func SaveStageData(tx kv.Putter, stage SyncStage, progress uint64, meta []byte) error {
+	return tx.Put(kv.SyncStageProgress, []byte(stage), EncodeStageData(progress, meta))
+}
+
+// GetStageUnwind - block the stage has to unwind to, ok=false if no unwind is pending
+func GetStageUnwind(tx kv.Getter, stage SyncStage) (unwindPoint uint64, ok bool, err error) {
+	v, err := tx.GetOne(kv.SyncStageProgress, []byte(unwindPrefix+stage))
+	if err != nil || len(v) == 0 {
+		return 0, false, err
+	}
+	n, _, err := DecodeStageData(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s%s: %w", unwindPrefix, stage, err)
+	}
+	return n, true, nil
+}
+
+// SaveStageUnwind - records the pending unwind point of the stage
+func SaveStageUnwind(tx kv.Putter, stage SyncStage, unwindPoint uint64) error {
+	return tx.Put(kv.SyncStageProgress, []byte(unwindPrefix+stage), EncodeStageData(unwindPoint, nil))
+}
+
+// ClearStageUnwind - the stage finished its unwind
+func ClearStageUnwind(tx kv.Deleter, stage SyncStage) error {
+	return tx.Delete(kv.SyncStageProgress, []byte(unwindPrefix+stage))
+}
+
+// SaveStageInvalidation - records the bad block found by the stage, so other stages can find out
+// why the sync stopped and skip it. See StageInvalidations.
+func SaveStageInvalidation(tx kv.Putter, stage SyncStage, badBlockHash types.Hash) error {
+	return tx.Put(kv.SyncStageProgress, []byte(invalidationPrefix+stage), badBlockHash.Bytes())
+}
+
+// GetStageInvalidation - bad block recorded by the stage, ok=false if there is none
+func GetStageInvalidation(tx kv.Getter, stage SyncStage) (badBlockHash types.Hash, ok bool, err error) {
+	v, err := tx.GetOne(kv.SyncStageProgress, []byte(invalidationPrefix+stage))
+	if err != nil || len(v) == 0 {
+		return types.Hash{}, false, err
+	}
+	if len(v) != types.HashLength {
+		return types.Hash{}, false, fmt.Errorf("%s%s: hash length %d", invalidationPrefix, stage, len(v))
+	}
+	return types.BytesToHash(v), true, nil
+}
+
+// ClearStageInvalidation - the bad block of the stage was dealt with, e.g. unwound
+func ClearStageInvalidation(tx kv.Deleter, stage SyncStage) error {
+	return tx.Delete(kv.SyncStageProgress, []byte(invalidationPrefix+stage))
+}
+
+// StageInvalidations - bad blocks recorded by all stages
+func StageInvalidations(tx kv.Getter) (map[SyncStage]types.Hash, error) {
+	res := make(map[SyncStage]types.Hash)
+	for _, stage := range AllStages {
+		hash, ok, err := GetStageInvalidation(tx, stage)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res[stage] = hash
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package stages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+func TestStageProgress(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+
+	if n, err := GetStageProgress(tx, Execution); err != nil || n != 0 {
+		t.Fatalf("progress of stage which never ran: %d, %v", n, err)
+	}
+
+	// legacy record: raw uint64
+	if err := tx.Put(kv.SyncStageProgress, []byte(Senders), binary.BigEndian.AppendUint64(nil, 77)); err != nil {
+		t.Fatal(err)
+	}
+	if n, meta, err := GetStageData(tx, Senders); err != nil || n != 77 || meta != nil {
+		t.Fatalf("legacy record: %d, %x, %v", n, meta, err)
+	}
+
+	// stages updated in turn within one tx don't see each other's records
+	bad := types.BytesToHash([]byte{0xba, 0xd})
+	for i := uint64(1); i <= 10; i++ {
+		for j, stage := range []SyncStage{Headers, Bodies, Execution} {
+			if err := SaveStageProgress(tx, stage, i*10+uint64(j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := SaveStageUnwind(tx, Execution, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveStageInvalidation(tx, Execution, bad); err != nil {
+		t.Fatal(err)
+	}
+	for j, stage := range []SyncStage{Headers, Bodies, Execution} {
+		if n, err := GetStageProgress(tx, stage); err != nil || n != 100+uint64(j) {
+			t.Fatalf("progress of %s: %d, %v", stage, n, err)
+		}
+	}
+	if n, ok, err := GetStageUnwind(tx, Execution); err != nil || !ok || n != 10 {
+		t.Fatalf("unwind of %s: %d, %v, %v", Execution, n, ok, err)
+	}
+	if _, ok, err := GetStageUnwind(tx, Headers); err != nil || ok {
+		t.Fatalf("unwind of %s: %v, %v", Headers, ok, err)
+	}
+
+	invalid, err := StageInvalidations(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[Execution] != bad {
+		t.Fatalf("invalidations: %v", invalid)
+	}
+
+	if err := ClearStageUnwind(tx, Execution); err != nil {
+		t.Fatal(err)
+	}
+	if err := ClearStageInvalidation(tx, Execution); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := GetStageUnwind(tx, Execution); ok {
+		t.Fatal("unwind not cleared")
+	}
+	if _, ok, _ := GetStageInvalidation(tx, Execution); ok {
+		t.Fatal("invalidation not cleared")
+	}
+	if n, _ := GetStageProgress(tx, Execution); n != 102 {
+		t.Fatalf("progress changed by clearing: %d", n)
+	}
+}
+
+func TestStageDataMeta(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+
+	meta := []byte("tx=17")
+	if err := SaveStageData(tx, Execution, 42, meta); err != nil {
+		t.Fatal(err)
+	}
+	n, got, err := GetStageData(tx, Execution)
+	if err != nil || n != 42 || !bytes.Equal(got, meta) {
+		t.Fatalf("stage data: %d, %q, %v", n, got, err)
+	}
+	if n, err := GetStageProgress(tx, Execution); err != nil || n != 42 {
+		t.Fatalf("progress with metadata: %d, %v", n, err)
+	}
+
+	if err := SaveStageProgress(tx, Execution, 43); err != nil {
+		t.Fatal(err)
+	}
+	if n, got, _ := GetStageData(tx, Execution); n != 43 || got != nil {
+		t.Fatalf("metadata kept by SaveStageProgress: %d, %q", n, got)
+	}
+
+	if err := tx.Put(kv.SyncStageProgress, []byte(Execution), []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetStageProgress(tx, Execution); err == nil {
+		t.Fatal("short record accepted")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package stages
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// Unwinder - a stage able to drop what it wrote for the blocks above to
+type Unwinder interface {
+	Unwind(tx kv.RwTx, to uint64) error
+}
+
+// UnwindFunc - adapts a function to Unwinder
+type UnwindFunc func(tx kv.RwTx, to uint64) error
+
+func (f UnwindFunc) Unwind(tx kv.RwTx, to uint64) error { return f(tx, to) }
+
+type registeredStage struct {
+	stage    SyncStage
+	priority int
+	unwinder Unwinder
+}
+
+// UnwindCoordinator - rewinds the registered stages to a fork point, downstream stages first.
+//
+// An unwind first records the unwind point of every stage ahead of it, then unwinds the stages
+// in reverse priority, each in its own transaction along with its progress and the removal of
+// its unwind point. A crash in between leaves the unwind points of the remaining stages, Resume
+// completes them on restart.
+type UnwindCoordinator struct {
+	stages []registeredStage
+}
+
+func NewUnwindCoordinator() *UnwindCoordinator {
+	return &UnwindCoordinator{}
+}
+
+// Register - adds a stage, stages run forward by ascending priority and unwind by descending
+// priority. Stages of equal priority unwind in reverse registration order.
+func (c *UnwindCoordinator) Register(stage SyncStage, priority int, u Unwinder) {
+	for _, s := range c.stages {
+		if s.stage == stage {
+			panic(fmt.Sprintf("stage %s registered twice", stage))
+		}
+	}
+	c.stages = append(c.stages, registeredStage{stage: stage, priority: priority, unwinder: u})
+	sort.SliceStable(c.stages, func(i, j int) bool { return c.stages[i].priority < c.stages[j].priority })
+}
+
+// Unwind - rewinds every stage which progressed past to. An unwind still pending to a lower
+// block is kept, so a deeper unwind is never made shallower.
+func (c *UnwindCoordinator) Unwind(ctx context.Context, db kv.RwDB, to uint64) error {
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		for _, s := range c.stages {
+			progress, err := GetStageProgress(tx, s.stage)
+			if err != nil {
+				return err
+			}
+			point, pending, err := GetStageUnwind(tx, s.stage)
+			if err != nil {
+				return err
+			}
+			if progress <= to || (pending && point <= to) {
+				continue
+			}
+			if err := SaveStageUnwind(tx, s.stage, to); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("unwind to %d: %w", to, err)
+	}
+	return c.Resume(ctx, db)
+}
+
+// Resume - completes the pending unwinds of the stages, a no-op if there are none. To be called
+// on startup before the stages run forward.
+func (c *UnwindCoordinator) Resume(ctx context.Context, db kv.RwDB) error {
+	for i := len(c.stages) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := c.stages[i]
+		if err := db.Update(ctx, func(tx kv.RwTx) error {
+			point, pending, err := GetStageUnwind(tx, s.stage)
+			if err != nil || !pending {
+				return err
+			}
+			if err := s.unwinder.Unwind(tx, point); err != nil {
+				return err
+			}
+			if err := SaveStageProgress(tx, s.stage, point); err != nil {
+				return err
+			}
+			return ClearStageUnwind(tx, s.stage)
+		}); err != nil {
+			return fmt.Errorf("unwind stage %s: %w", s.stage, err)
+		}
+	}
+	return nil
+}
+
+// Pending - unwind points of the registered stages which didn't finish their unwind
+func (c *UnwindCoordinator) Pending(tx kv.Getter) (map[SyncStage]uint64, error) {
+	res := make(map[SyncStage]uint64)
+	for _, s := range c.stages {
+		point, ok, err := GetStageUnwind(tx, s.stage)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res[s.stage] = point
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package stages
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// testStage keeps one record per block it processed, so an unwind can be checked by the records left
+type testStage struct {
+	stage SyncStage
+	log   *[]SyncStage
+	crash bool // fails after deleting its records, as a process killed mid-unwind
+}
+
+func (s *testStage) key(n uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("test_"+s.stage), n)
+}
+
+func (s *testStage) run(tx kv.RwTx, to uint64) error {
+	for n := uint64(1); n <= to; n++ {
+		if err := tx.Put(kv.SyncStageProgress, s.key(n), []byte{1}); err != nil {
+			return err
+		}
+	}
+	return SaveStageProgress(tx, s.stage, to)
+}
+
+func (s *testStage) Unwind(tx kv.RwTx, to uint64) error {
+	progress, err := GetStageProgress(tx, s.stage)
+	if err != nil {
+		return err
+	}
+	for n := to + 1; n <= progress; n++ {
+		if err := tx.Delete(kv.SyncStageProgress, s.key(n)); err != nil {
+			return err
+		}
+	}
+	if s.crash {
+		return errCrash
+	}
+	*s.log = append(*s.log, s.stage)
+	return nil
+}
+
+func (s *testStage) records(tx kv.Getter, upTo uint64) (n uint64, err error) {
+	for i := uint64(1); i <= upTo; i++ {
+		if ok, err := tx.Has(kv.SyncStageProgress, s.key(i)); err != nil {
+			return 0, err
+		} else if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newTestCoordinator(log *[]SyncStage, crashAt SyncStage) (*UnwindCoordinator, []*testStage) {
+	c := NewUnwindCoordinator()
+	var ss []*testStage
+	// registered out of order, priorities decide
+	for _, i := range []int{3, 0, 5, 1, 4, 2} {
+		s := &testStage{stage: AllStages[i], log: log, crash: AllStages[i] == crashAt}
+		c.Register(s.stage, i, s)
+		ss = append(ss, s)
+	}
+	return c, ss
+}
+
+func TestUnwindCoordinator(t *testing.T) {
+	const head, fork = 20, 12
+	// a crash in each stage, and none
+	for _, crashAt := range []SyncStage{"", IntermediateHashes, HashState, Execution, Senders, Bodies, Headers} {
+		db := memdb.NewTestDB(t)
+		ctx := context.Background()
+
+		var log []SyncStage
+		c, ss := newTestCoordinator(&log, crashAt)
+		if err := db.Update(ctx, func(tx kv.RwTx) error {
+			for _, s := range ss {
+				progress := uint64(head)
+				if s.stage == Execution {
+					progress = 10 // behind the fork point, nothing to unwind
+				}
+				if err := s.run(tx, progress); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := c.Unwind(ctx, db, fork)
+		if crashAt == "" || crashAt == Execution {
+			// Execution is behind the fork point, it doesn't unwind
+			if err != nil {
+				t.Fatalf("crash at %q: %v", crashAt, err)
+			}
+		} else {
+			if !errors.Is(err, errCrash) {
+				t.Fatalf("crash at %s: unwind err %v", crashAt, err)
+			}
+			// restart
+			c, ss = newTestCoordinator(&log, "")
+			if err := c.Resume(ctx, db); err != nil {
+				t.Fatalf("crash at %s: resume: %v", crashAt, err)
+			}
+		}
+
+		wantLog := []SyncStage{IntermediateHashes, HashState, Senders, Bodies, Headers}
+		if len(log) != len(wantLog) {
+			t.Fatalf("crash at %s: stages unwound %v, want %v", crashAt, log, wantLog)
+		}
+		for i := range log {
+			if log[i] != wantLog[i] {
+				t.Fatalf("crash at %s: stages unwound %v, want %v", crashAt, log, wantLog)
+			}
+		}
+
+		if err := db.View(ctx, func(tx kv.Tx) error {
+			pending, err := c.Pending(tx)
+			if err != nil {
+				return err
+			}
+			if len(pending) != 0 {
+				t.Fatalf("crash at %s: unwinds left %v", crashAt, pending)
+			}
+			for _, s := range ss {
+				wantProgress := uint64(fork)
+				if s.stage == Execution {
+					wantProgress = 10
+				}
+				progress, err := GetStageProgress(tx, s.stage)
+				if err != nil {
+					return err
+				}
+				n, err := s.records(tx, head)
+				if err != nil {
+					return err
+				}
+				if progress != wantProgress || n != wantProgress {
+					t.Fatalf("crash at %s: stage %s at %d with %d records, want %d", crashAt, s.stage, progress, n, wantProgress)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUnwindCoordinatorDeeper(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	ctx := context.Background()
+	var log []SyncStage
+	c, ss := newTestCoordinator(&log, Bodies)
+	if err := db.Update(ctx, func(tx kv.RwTx) error {
+		for _, s := range ss {
+			if err := s.run(tx, 20); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unwind(ctx, db, 15); !errors.Is(err, errCrash) {
+		t.Fatalf("unwind err %v", err)
+	}
+
+	// a shallower unwind keeps the pending point, a deeper one lowers it
+	c, _ = newTestCoordinator(&log, Bodies)
+	if err := c.Unwind(ctx, db, 18); !errors.Is(err, errCrash) {
+		t.Fatalf("unwind err %v", err)
+	}
+	c, ss = newTestCoordinator(&log, "")
+	if err := c.Unwind(ctx, db, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(ctx, func(tx kv.Tx) error {
+		for _, s := range ss {
+			if progress, err := GetStageProgress(tx, s.stage); err != nil || progress != 5 {
+				t.Fatalf("stage %s at %d, err %v, want 5", s.stage, progress, err)
+			}
+			if n, err := s.records(tx, 20); err != nil || n != 5 {
+				t.Fatalf("stage %s has %d records, err %v, want 5", s.stage, n, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TableStat - storage engine statistics of one table
+type TableStat struct {
+	Entries       uint64 `json:"entries"`
+	BranchPages   uint64 `json:"branchPages"`
+	LeafPages     uint64 `json:"leafPages"`
+	OverflowPages uint64 `json:"overflowPages"`
+	Size          uint64 `json:"size"` // bytes of all pages
+}
+
+// TableStater - transaction exposing per-table statistics of the storage engine, MDBX transactions implement it
+type TableStater interface {
+	ExistsBucket(table string) (bool, error)
+	TableStat(table string) (TableStat, error)
+}
+
+// StatTables - tables covered by Stat: ChaindataTables, TxPoolTables and DownloaderTables
+func StatTables() []string {
+	seen := make(map[string]struct{})
+	var res []string
+	for _, group := range [][]string{ChaindataTables, TxPoolTables, DownloaderTables} {
+		for _, name := range group {
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				res = append(res, name)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Stat - statistics of every table of StatTables existing in the database
+func Stat(ctx context.Context, tx TableStater) (map[string]TableStat, error) {
+	return StatOf(ctx, tx, StatTables())
+}
+
+// StatOf - statistics of given tables, tables absent from the database (deprecated or of another db) are skipped
+func StatOf(ctx context.Context, tx TableStater, tables []string) (map[string]TableStat, error) {
+	res := make(map[string]TableStat, len(tables))
+	for _, name := range tables {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		exists, err := tx.ExistsBucket(name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		st, err := tx.TableStat(name)
+		if err != nil {
+			return nil, err
+		}
+		res[name] = st
+	}
+	return res, nil
+}
+
+// TableStatsSample - table statistics at some moment, stored periodically to follow database growth
+type TableStatsSample struct {
+	Time   time.Time            `json:"time"`
+	Tables map[string]TableStat `json:"tables"`
+}
+
+// tableStatsSampleKey - TableStatsKey + unix_seconds_u64, so samples are ordered by time
+func tableStatsSampleKey(at time.Time) []byte {
+	k := make([]byte, len(TableStatsKey)+8)
+	copy(k, TableStatsKey)
+	binary.BigEndian.PutUint64(k[len(TableStatsKey):], uint64(at.Unix()))
+	return k
+}
+
+// WriteTableStatsSample - stores stats taken at given time into DatabaseInfo
+func WriteTableStatsSample(tx Putter, at time.Time, stats map[string]TableStat) error {
+	v, err := json.Marshal(TableStatsSample{Time: at.UTC(), Tables: stats})
+	if err != nil {
+		return err
+	}
+	return tx.Put(DatabaseInfo, tableStatsSampleKey(at), v)
+}
+
+// ReadTableStatsSamples - walks stored samples from the oldest
+func ReadTableStatsSamples(tx Getter, walker func(s TableStatsSample) error) error {
+	return tx.ForPrefix(DatabaseInfo, TableStatsKey, func(k, v []byte) error {
+		if len(k) != len(TableStatsKey)+8 {
+			return nil
+		}
+		var s TableStatsSample
+		if err := json.Unmarshal(v, &s); err != nil {
+			return fmt.Errorf("table stats sample %x: %w", k, err)
+		}
+		return walker(s)
+	})
+}
+
+// SortTableStats - table names ordered by size, largest first
+func SortTableStats(stats map[string]TableStat) []string {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if stats[names[i]].Size != stats[names[j]].Size {
+			return stats[names[i]].Size > stats[names[j]].Size
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
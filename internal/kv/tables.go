@@ -17,11 +17,12 @@
 package kv
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 )
 
-// DBSchemaVersion versions list
+// DBSchemaVersion versions list, CurrentSchemaVersion is the last one
 // 5.0 - BlockTransaction table now has canonical ids (txs of non-canonical blocks moving to NonCanonicalTransaction table)
 // 6.0 - BlockTransaction table now has system-txs before and after block (records are absent if block has no system-tx, but sequence increasing)
 
@@ -259,7 +260,8 @@ const (
 	//
 	// if last existing shard size merge it with delta
 	// if serialized size of delta > ShardLimit - break down to multiple shards
-	// shard number - it's biggest value in bitmap
+	// shard number - counts up from 0, the newest shard has the biggest one and sorts first
+	// see bitmapdb.UpsertShardedIndex and bitmapdb.SeekInIndex
 	LogTopicIndex   = "LogTopicIndex"
 	LogAddressIndex = "LogAddressIndex"
 
@@ -313,7 +315,7 @@ const (
 	Inodes = "Inode"
 
 	// Transaction senders - stored separately from the block bodies
-	Senders = "TxSender" // block_num_u64 + blockHash -> sendersList (no serialization format, every 20 bytes is new sender)
+	Senders = "TxSender" // block_num_u64 + blockHash -> sendersList (20 bytes per sender, or deduplicated - see rawdb.EncodeSenders)
 
 	// headBlockKey tracks the latest know full block's hash.
 	HeadBlockKey = "LastBlock"
@@ -419,8 +421,16 @@ var (
 	PruneTxIndexType    = []byte("pruneTxIndexType")
 	PruneCallTraces     = []byte("pruneCallTraces")
 	PruneCallTracesType = []byte("pruneCallTracesType")
+	PruneSenders        = []byte("pruneSenders")
+	PruneSendersType    = []byte("pruneSendersType")
 
 	DBSchemaVersionKey = []byte("dbVersion")
+	// TableStatsKey - prefix of periodic table statistics samples, see WriteTableStatsSample
+	TableStatsKey = []byte("tableStats")
+	// DroppedTableKey - prefix of records of deprecated tables dropped, see DropDeprecatedTables
+	DroppedTableKey = []byte("droppedTable.")
+	// DBILayoutKey - table to DBI mapping recorded at db creation, see DBILayout
+	DBILayoutKey = []byte("dbiLayout")
 
 	BittorrentPeerID            = "peerID"
 	CurrentHeadersSnapshotHash  = []byte("CurrentHeadersSnapshotHash")
@@ -592,24 +602,86 @@ type TableCfgItem struct {
 	// Works only if AutoDupSortKeysConversion enabled
 	DupFromLen int
 	DupToLen   int
+	// BloomEnabled - hint: table has many lookups of absent keys, worth to keep bloom filter in front of it
+	BloomEnabled bool
+	// WriteFrequency - hint: how often table is written, hot tables benefit from larger commit batches
+	WriteFrequency WriteFrequency
+	// Derived - hint: table can be regenerated from source-of-truth tables, disaster recovery drops and rebuilds it
+	Derived bool
+	// RetentionBlocks - hint: keep records of the last RetentionBlocks blocks only, 0 keeps all. Only for tables
+	// keyed by block_num_u64, see PruneByRetention
+	RetentionBlocks uint64
+	// DupCmp - order of the dup values of a key, nil is byte-wise. With AutoDupSortKeysConversion it gets the
+	// key parts moved into the values as k1, k2 and the rest as v1, v2. See SortedDupValues
+	DupCmp CmpFunc
+	// ReadOnly - set by the open layer, writes to the table fail with ErrReadOnlyTable. See ReadOnlyWhenFrozen
+	ReadOnly bool
+}
+
+// WriteFrequency - zero value is WriteFrequencyMedium, so tables without hint are scheduled as usual
+type WriteFrequency uint8
+
+const (
+	WriteFrequencyMedium WriteFrequency = iota
+	WriteFrequencyHigh
+	WriteFrequencyLow
+)
+
+func (f WriteFrequency) String() string {
+	switch f {
+	case WriteFrequencyHigh:
+		return "high"
+	case WriteFrequencyLow:
+		return "low"
+	default:
+		return "medium"
+	}
 }
 
 var ChaindataTablesCfg = TableCfg{
+	HashedAccounts: {BloomEnabled: true, WriteFrequency: WriteFrequencyHigh, Derived: true},
 	HashedStorage: {
 		Flags:                     DupSort,
 		AutoDupSortKeysConversion: true,
 		DupFromLen:                72,
 		DupToLen:                  40,
+		WriteFrequency:            WriteFrequencyHigh,
+		Derived:                   true,
 	},
-	AccountChangeSet: {Flags: DupSort},
-	StorageChangeSet: {Flags: DupSort},
+	AccountChangeSet: {Flags: DupSort, WriteFrequency: WriteFrequencyHigh},
+	StorageChangeSet: {Flags: DupSort, WriteFrequency: WriteFrequencyHigh},
 	PlainState: {
 		Flags:                     DupSort,
 		AutoDupSortKeysConversion: true,
 		DupFromLen:                60,
 		DupToLen:                  28,
+		BloomEnabled:              true,
+		WriteFrequency:            WriteFrequencyHigh,
 	},
-	CallTraceSet: {Flags: DupSort},
+	CallTraceSet: {Flags: DupSort, WriteFrequency: WriteFrequencyHigh, Derived: true},
+	Code:         {BloomEnabled: true},
+	TxLookup:     {BloomEnabled: true, Derived: true},
+	HeaderNumber: {BloomEnabled: true, Derived: true},
+
+	AccountsHistory: {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	StorageHistory:  {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	LogTopicIndex:   {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	LogAddressIndex: {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	CallFromIndex:   {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	CallToIndex:     {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	TrieOfAccounts:  {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	TrieOfStorage:   {WriteFrequency: WriteFrequencyHigh, Derived: true},
+	ContractCode:    {Derived: true},
+	Senders:         {Derived: true},
+	ConfigTable:     {WriteFrequency: WriteFrequencyLow},
+	DatabaseInfo:    {WriteFrequency: WriteFrequencyLow},
+	Migrations:      {WriteFrequency: WriteFrequencyLow},
+
+	// re-executed from archive state
+	Receipts:                   {Derived: true},
+	Log:                        {Derived: true},
+	CumulativeGasIndex:         {Derived: true},
+	CumulativeTransactionIndex: {Derived: true},
 
 	AccountKeys:        {Flags: DupSort},
 	AccountHistoryKeys: {Flags: DupSort},
@@ -641,6 +713,263 @@ var SentryTablesCfg = TableCfg{}
 var DownloaderTablesCfg = TableCfg{}
 var ReconTablesCfg = TableCfg{}
 
+// TableSalt - deterministic hash salt of table, derived from it's index in sorted ChaindataTables.
+// Used by bloom filters to avoid collisions of same keys in different tables. Returns 0 for unknown table.
+func TableSalt(table string) uint64 {
+	i := sort.SearchStrings(ChaindataTables, table)
+	if i == len(ChaindataTables) || ChaindataTables[i] != table {
+		return 0
+	}
+	// splitmix64 finalizer - neighbour indices give unrelated salts
+	z := uint64(i+1) * 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// TablesByWriteFrequency - sorted list of ChaindataTables with given WriteFrequency hint, for commit batching
+func TablesByWriteFrequency(f WriteFrequency) []string {
+	var res []string
+	for _, name := range ChaindataTables {
+		if ChaindataTablesCfg[name].WriteFrequency == f {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// DerivedTables - sorted list of ChaindataTables with the Derived hint: indices, hashed state, trie,
+// and receipts, which an archive node re-executes
+func DerivedTables() []string {
+	var res []string
+	for _, name := range ChaindataTables {
+		if ChaindataTablesCfg[name].Derived {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// SourceOfTruthTables - sorted list of ChaindataTables which can't be regenerated: headers, bodies,
+// transactions, current state, metadata. Disaster recovery restores them from backup.
+func SourceOfTruthTables() []string {
+	var res []string
+	for _, name := range ChaindataTables {
+		if !ChaindataTablesCfg[name].Derived {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// checkpointSyncTables - tables imported from a trusted checkpoint. All other tables are
+// either derived from them later (plain state, senders, indices) or only cover blocks
+// after the checkpoint (changesets, history, receipts).
+var checkpointSyncTables = []string{
+	HashedAccounts,
+	HashedStorage,
+	Code,
+	TrieOfAccounts,
+	TrieOfStorage,
+	Headers,
+	HeaderNumber,
+	HeaderCanonical,
+	HeaderTD,
+}
+
+// CheckpointSyncTables - sorted list of tables which must be populated from the checkpoint
+// when resyncing without re-executing history
+func CheckpointSyncTables() []string {
+	res := append([]string(nil), checkpointSyncTables...)
+	sort.Strings(res)
+	return res
+}
+
+// fastSyncImportTables - tables imported by a fast sync at its pivot block. On top of the
+// checkpoint tables these carry the recent blocks before the pivot: bodies with their
+// transactions and the transaction id sequence, receipts and logs.
+var fastSyncImportTables = append([]string{
+	BlockBody,
+	EthTx,
+	Sequence,
+	Receipts,
+	Log,
+}, checkpointSyncTables...)
+
+// FastSyncImportTables - sorted list of tables which must be populated by a fast sync pivot import.
+// Unlike CheckpointSyncTables it includes bodies and receipts of the recent blocks.
+func FastSyncImportTables() []string {
+	res := append([]string(nil), fastSyncImportTables...)
+	sort.Strings(res)
+	return res
+}
+
+// RPCNamespaceTables - sorted list of tables the given RPC namespace requires, nil for namespaces
+// without chaindata access. A node serving several namespaces opens the union of their tables.
+// The tables are the ones declared for the namespace and its methods, see declaredAccess.
+func RPCNamespaceTables(ns string) []string {
+	var res []string
+	for _, use := range AccessMatrix() {
+		for _, owner := range append(use.Readers, use.Writers...) {
+			if owner == RPCOwner+ns || strings.HasPrefix(owner, RPCOwner+ns+"_") {
+				res = append(res, use.Table)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// engineTables - tables written only by the given consensus engine
+var engineTables = map[string][]string{
+	"clique": {CliqueSeparate, CliqueSnapshot, CliqueLastSnapshot},
+	"parlia": {ParliaSnapshot},
+	"bor":    {BorReceipts, BorTxLookup, BorSeparate},
+}
+
+// EngineTables - sorted lists of tables the consensus engine requires, and of tables of other engines which
+// must be dropped before switching to it. Engines without tables of their own, e.g. ethash or apos, require
+// nothing and forbid the tables of all the engines above. The name is case-insensitive.
+func EngineTables(engine string) (required, forbidden []string) {
+	engine = strings.ToLower(engine)
+	for name, tables := range engineTables {
+		if name == engine {
+			required = append(required, tables...)
+		} else {
+			forbidden = append(forbidden, tables...)
+		}
+	}
+	sort.Strings(required)
+	sort.Strings(forbidden)
+	return required, forbidden
+}
+
+// indexDependencies - secondary index -> base tables it is derived from. Deleting records of a base
+// table leaves dangling entries in these indices unless they are updated in the same tx.
+var indexDependencies = map[string][]string{
+	HeaderNumber:    {Headers},
+	TxLookup:        {EthTx},
+	LogTopicIndex:   {Log},
+	LogAddressIndex: {Log},
+	CallFromIndex:   {CallTraceSet},
+	CallToIndex:     {CallTraceSet},
+	AccountsHistory: {AccountChangeSet},
+	StorageHistory:  {StorageChangeSet},
+}
+
+// DependentIndicesFor - sorted list of indices which must be updated when records are deleted from table,
+// nil if no index is derived from it
+func DependentIndicesFor(table string) []string {
+	var res []string
+	for index, bases := range indexDependencies {
+		for _, base := range bases {
+			if base == table {
+				res = append(res, index)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// StateRootTables - sorted list of the tables a state root is computed from: hashed state, intermediate trie
+// hashes and Code for the code hashes. A standalone state root verification opens only them.
+func StateRootTables() []string {
+	res := []string{HashedAccounts, HashedStorage, TrieOfAccounts, TrieOfStorage, Code}
+	sort.Strings(res)
+	return res
+}
+
+// frozenTables - tables whose records are served from frozen snapshots
+var frozenTables = []string{Headers, BlockBody, EthTx}
+
+// ReadOnlyWhenFrozen - sorted list of snapshot-backed tables, to open read-only when the node replays from
+// frozen snapshots: their records in the db must not diverge from the snapshot files
+func ReadOnlyWhenFrozen() []string {
+	res := append([]string(nil), frozenTables...)
+	sort.Strings(res)
+	return res
+}
+
+// backupReferences - tables whose records point into each other, on top of indexDependencies. A backup which
+// captures them in different transactions restores e.g. changesets which don't lead to the restored PlainState.
+var backupReferences = [][]string{
+	{PlainState, PlainContractCode, Code, IncarnationMap, AccountChangeSet, StorageChangeSet},
+	{PlainState, HashedAccounts, HashedStorage, ContractCode, TrieOfAccounts, TrieOfStorage},
+	{Headers, HeaderCanonical, HeaderNumber, HeaderTD},
+	{HeaderCanonical, BlockBody, EthTx, Sequence, Senders},
+	{BlockBody, Receipts, Log, CallTraceSet},
+}
+
+// BackupConsistencyGroups - active chaindata tables split into groups which must be captured in one transaction
+// by a point-in-time backup. Every table is in exactly one group, unrelated tables are single-table groups.
+// Groups are sorted, and ordered by their first table.
+func BackupConsistencyGroups() [][]string {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(name string) string {
+		if p, ok := parent[name]; ok && p != name {
+			root := find(p)
+			parent[name] = root
+			return root
+		}
+		return name
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[ra] = rb
+		}
+	}
+
+	active := make(map[string]struct{}, len(ChaindataTables))
+	for _, name := range ChaindataTables {
+		active[name] = struct{}{}
+		parent[name] = name
+	}
+	link := func(tables []string) {
+		var first string
+		for _, name := range tables {
+			if _, ok := active[name]; !ok {
+				continue
+			}
+			if first == "" {
+				first = name
+				continue
+			}
+			union(first, name)
+		}
+	}
+	for _, tables := range backupReferences {
+		link(tables)
+	}
+	for index, bases := range indexDependencies {
+		link(append([]string{index}, bases...))
+	}
+
+	byRoot := make(map[string][]string)
+	for _, name := range ChaindataTables {
+		root := find(name)
+		byRoot[root] = append(byRoot[root], name)
+	}
+	res := make([][]string, 0, len(byRoot))
+	for _, group := range byRoot {
+		sort.Strings(group)
+		res = append(res, group)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i][0] < res[j][0] })
+	return res
+}
+
+// SupportsEfficientRangeDelete - false for tables with AutoDupSortKeysConversion, where every deleted
+// record is split back into key and dup value, and for unknown tables. Plain tables keyed by block
+// number delete a range with a single cursor sweep, pruning schedulers pick their strategy by this.
+func SupportsEfficientRangeDelete(table string) bool {
+	cfg, ok := ChaindataTablesCfg[table]
+	return ok && !cfg.AutoDupSortKeysConversion
+}
+
 func sortBuckets() {
 	sort.SliceStable(ChaindataTables, func(i, j int) bool {
 		return strings.Compare(ChaindataTables[i], ChaindataTables[j]) < 0
@@ -649,6 +978,85 @@ func sortBuckets() {
 
 func init() {
 	reinit()
+	if err := CheckDeprecationDisjoint(); err != nil {
+		panic(err)
+	}
+}
+
+// CheckDeprecationDisjoint - makes sure no bucket is listed in both ChaindataTables and ChaindataDeprecatedTables,
+// otherwise reinit would mark an active bucket as deprecated and it would never be created
+func CheckDeprecationDisjoint() error {
+	active := make(map[string]struct{}, len(ChaindataTables))
+	for _, name := range ChaindataTables {
+		active[name] = struct{}{}
+	}
+	var overlap []string
+	for _, name := range ChaindataDeprecatedTables {
+		if _, ok := active[name]; ok {
+			overlap = append(overlap, name)
+		}
+	}
+	if len(overlap) > 0 {
+		return fmt.Errorf("buckets are both active and deprecated: %s", strings.Join(overlap, ", "))
+	}
+	return nil
+}
+
+// VerifyDBIStability - makes sure sorted ChaindataTables still lists the tables in expected order, e.g. the order
+// recorded by the binary which created the db. DBIs are assigned in this order, so any change to sorting or to the
+// table set silently remaps them. The error lists added and removed tables and the first moved positions.
+func VerifyDBIStability(expected []string) error {
+	current := Tables(TableGroupChaindata)
+	if len(current) == len(expected) {
+		same := true
+		for i := range current {
+			if current[i] != expected[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return nil
+		}
+	}
+	currentIdx := make(map[string]int, len(current))
+	for i, name := range current {
+		currentIdx[name] = i
+	}
+	expectedIdx := make(map[string]int, len(expected))
+	for i, name := range expected {
+		expectedIdx[name] = i
+	}
+	var added, removed, moved []string
+	for _, name := range current {
+		if _, ok := expectedIdx[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for i, name := range expected {
+		j, ok := currentIdx[name]
+		if !ok {
+			removed = append(removed, name)
+			continue
+		}
+		if i != j && len(moved) < 10 {
+			moved = append(moved, fmt.Sprintf("%s %d->%d", name, i, j))
+		}
+	}
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added: "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(removed, ", "))
+	}
+	if len(moved) > 0 {
+		parts = append(parts, "moved: "+strings.Join(moved, ", "))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "duplicate tables in expected order")
+	}
+	return fmt.Errorf("dbi assignment order changed (%d tables, expected %d): %s", len(current), len(expected), strings.Join(parts, "; "))
 }
 
 func reinit() {
@@ -0,0 +1,406 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCheckDeprecationDisjoint(t *testing.T) {
+	if err := CheckDeprecationDisjoint(); err != nil {
+		t.Fatalf("default tables overlap: %v", err)
+	}
+
+	saved := ChaindataTables
+	defer func() { ChaindataTables = saved }()
+	ChaindataTables = append(append([]string{}, saved...), Clique)
+
+	err := CheckDeprecationDisjoint()
+	if err == nil {
+		t.Fatal("overlap of Clique not detected")
+	}
+	if !strings.Contains(err.Error(), Clique) {
+		t.Fatalf("error does not name the overlapping bucket: %v", err)
+	}
+}
+
+func TestTableSalt(t *testing.T) {
+	seen := make(map[uint64]string, len(ChaindataTables))
+	for _, name := range ChaindataTables {
+		salt := TableSalt(name)
+		if salt == 0 {
+			t.Fatalf("zero salt for %s", name)
+		}
+		if salt != TableSalt(name) {
+			t.Fatalf("salt of %s is not stable", name)
+		}
+		if other, ok := seen[salt]; ok {
+			t.Fatalf("tables %s and %s have same salt", name, other)
+		}
+		seen[salt] = name
+	}
+	if TableSalt("NoSuchTable") != 0 {
+		t.Fatal("unknown table must have zero salt")
+	}
+	if !ChaindataTablesCfg[PlainState].BloomEnabled || ChaindataTablesCfg[Headers].BloomEnabled {
+		t.Fatal("unexpected BloomEnabled hints")
+	}
+}
+
+func TestTablesByWriteFrequency(t *testing.T) {
+	contains := func(tables []string, name string) bool {
+		for _, table := range tables {
+			if table == name {
+				return true
+			}
+		}
+		return false
+	}
+	high := TablesByWriteFrequency(WriteFrequencyHigh)
+	low := TablesByWriteFrequency(WriteFrequencyLow)
+	medium := TablesByWriteFrequency(WriteFrequencyMedium)
+	if !contains(high, PlainState) {
+		t.Fatalf("%s is not High: %v", PlainState, high)
+	}
+	if !contains(low, ConfigTable) {
+		t.Fatalf("%s is not Low: %v", ConfigTable, low)
+	}
+	if contains(medium, PlainState) || contains(medium, ConfigTable) {
+		t.Fatal("table listed under two frequencies")
+	}
+	if len(high)+len(medium)+len(low) != len(ChaindataTables) {
+		t.Fatalf("frequencies cover %d tables, want %d", len(high)+len(medium)+len(low), len(ChaindataTables))
+	}
+}
+
+func TestCheckpointSyncTables(t *testing.T) {
+	want := []string{Code, HeaderCanonical, Headers, HeaderNumber, HeaderTD, HashedAccounts, HashedStorage, TrieOfAccounts, TrieOfStorage}
+	sort.Strings(want)
+	got := CheckpointSyncTables()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("have %v, want %v", got, want)
+	}
+	for _, name := range got {
+		if _, ok := ChaindataTablesCfg[name]; !ok {
+			t.Fatalf("%s is not a chaindata table", name)
+		}
+	}
+	excluded := map[string]struct{}{
+		AccountChangeSet: {}, StorageChangeSet: {}, AccountsHistory: {}, StorageHistory: {},
+	}
+	for _, name := range got {
+		if _, ok := excluded[name]; ok {
+			t.Fatalf("%s must be derived after the checkpoint", name)
+		}
+	}
+	got[0] = "mutated"
+	if CheckpointSyncTables()[0] == "mutated" {
+		t.Fatal("CheckpointSyncTables returned shared slice")
+	}
+}
+
+// TestFastSyncImportTables - a fast sync imports the checkpoint tables and the recent block bodies and receipts
+func TestFastSyncImportTables(t *testing.T) {
+	recent := []string{BlockBody, EthTx, Log, Receipts, Sequence}
+	want := append(CheckpointSyncTables(), recent...)
+	sort.Strings(want)
+	got := FastSyncImportTables()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("have %v, want %v", got, want)
+	}
+	for _, name := range got {
+		if _, ok := ChaindataTablesCfg[name]; !ok {
+			t.Fatalf("%s is not a chaindata table", name)
+		}
+	}
+	checkpoint := map[string]struct{}{}
+	for _, name := range CheckpointSyncTables() {
+		checkpoint[name] = struct{}{}
+	}
+	for _, name := range recent {
+		if _, ok := checkpoint[name]; ok {
+			t.Fatalf("%s is imported by checkpoint sync", name)
+		}
+	}
+	got[0] = "mutated"
+	if FastSyncImportTables()[0] == "mutated" {
+		t.Fatal("FastSyncImportTables returned shared slice")
+	}
+}
+
+func TestRegisterTable(t *testing.T) {
+	savedTables, savedDeprecated := ChaindataTables, ChaindataDeprecatedTables
+	defer func() {
+		ChaindataTables, ChaindataDeprecatedTables = savedTables, savedDeprecated
+		delete(ChaindataTablesCfg, "TestRegistered")
+		delete(ChaindataTablesCfg, "TestDeprecated")
+	}()
+
+	// Tables lists in DBI assignment order, the group lists keep declaration order
+	sorted := func(tables []string) []string {
+		res := append([]string(nil), tables...)
+		sort.Strings(res)
+		return res
+	}
+	if got := Tables(TableGroupChaindata); !reflect.DeepEqual(got, sorted(ChaindataTables)) {
+		t.Fatalf("chaindata tables differ: %v", got)
+	}
+	if got := Tables(TableGroupTxPool); !reflect.DeepEqual(got, sorted(TxPoolTables)) {
+		t.Fatalf("txpool tables differ: %v", got)
+	}
+	if err := RegisterTable(PlainState, TableCfgItem{}, TableGroupChaindata); err == nil {
+		t.Fatal("duplicate registration accepted")
+	}
+	if err := RegisterTable(Clique, TableCfgItem{}, TableGroupChaindata); err == nil {
+		t.Fatal("registration of deprecated name accepted")
+	}
+	if err := RegisterTable(PoolTransaction, TableCfgItem{}, TableGroupChaindata); err == nil {
+		t.Fatal("registration of name from another group accepted")
+	}
+
+	if err := RegisterTable("TestRegistered", TableCfgItem{Flags: DupSort}, TableGroupChaindata); err != nil {
+		t.Fatal(err)
+	}
+	got := Tables(TableGroupChaindata)
+	if !sort.StringsAreSorted(got) || len(got) != len(savedTables)+1 {
+		t.Fatalf("unexpected tables after registration: %v", got)
+	}
+	if cfg, ok := Lookup("TestRegistered"); !ok || cfg.Flags != DupSort {
+		t.Fatalf("lookup of registered table: %+v %t", cfg, ok)
+	}
+
+	if err := RegisterTable("TestDeprecated", TableCfgItem{IsDeprecated: true}, TableGroupChaindata); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, ok := Lookup("TestDeprecated"); !ok || !cfg.IsDeprecated {
+		t.Fatalf("deprecated flag not propagated: %+v %t", cfg, ok)
+	}
+	for _, name := range Tables(TableGroupChaindata) {
+		if name == "TestDeprecated" {
+			t.Fatal("deprecated table listed as active")
+		}
+	}
+	if err := CheckDeprecationDisjoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Lookup("NoSuchTable"); ok {
+		t.Fatal("lookup of unknown table succeeded")
+	}
+}
+
+func TestRPCNamespaceTables(t *testing.T) {
+	eth := RPCNamespaceTables("eth")
+	if !sort.StringsAreSorted(eth) {
+		t.Fatalf("eth tables are not sorted: %v", eth)
+	}
+	has := make(map[string]bool, len(eth))
+	for _, name := range eth {
+		if _, ok := ChaindataTablesCfg[name]; !ok {
+			t.Fatalf("%s is not a chaindata table", name)
+		}
+		has[name] = true
+	}
+	for _, name := range []string{Headers, BlockBody, Receipts, PlainState, Log, LogAddressIndex, StorageHistory} {
+		if !has[name] {
+			t.Fatalf("eth does not require %s: %v", name, eth)
+		}
+	}
+	if has[CallTraceSet] {
+		t.Fatal("eth requires call traces")
+	}
+
+	trace := RPCNamespaceTables("trace")
+	if want := []string{CallFromIndex, CallToIndex, CallTraceSet}; !reflect.DeepEqual(trace, want) {
+		t.Fatalf("have %v, want %v", trace, want)
+	}
+	trace[0] = "mutated"
+	if RPCNamespaceTables("trace")[0] == "mutated" {
+		t.Fatal("RPCNamespaceTables returned shared slice")
+	}
+	if RPCNamespaceTables("web3") != nil {
+		t.Fatal("web3 requires tables")
+	}
+}
+
+func TestDependentIndicesFor(t *testing.T) {
+	if have := DependentIndicesFor(EthTx); !reflect.DeepEqual(have, []string{TxLookup}) {
+		t.Fatalf("EthTx: have %v, want [%s]", have, TxLookup)
+	}
+	if have, want := DependentIndicesFor(Log), []string{LogAddressIndex, LogTopicIndex}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("Log: have %v, want %v", have, want)
+	}
+	if have := DependentIndicesFor(TxLookup); have != nil {
+		t.Fatalf("index %s has dependent indices %v", TxLookup, have)
+	}
+	for index, bases := range indexDependencies {
+		for _, name := range append([]string{index}, bases...) {
+			if _, ok := ChaindataTablesCfg[name]; !ok {
+				t.Fatalf("%s is not a chaindata table", name)
+			}
+		}
+	}
+}
+
+func TestSupportsEfficientRangeDelete(t *testing.T) {
+	if !SupportsEfficientRangeDelete(Headers) {
+		t.Fatalf("%s does not support range delete", Headers)
+	}
+	for _, name := range []string{PlainState, HashedStorage, "NoSuchTable"} {
+		if SupportsEfficientRangeDelete(name) {
+			t.Fatalf("%s supports range delete", name)
+		}
+	}
+}
+
+func TestDerivedTables(t *testing.T) {
+	derived, truth := DerivedTables(), SourceOfTruthTables()
+	if len(derived)+len(truth) != len(ChaindataTables) {
+		t.Fatalf("%d derived and %d source-of-truth tables, want %d in total", len(derived), len(truth), len(ChaindataTables))
+	}
+	in := func(tables []string, name string) bool {
+		i := sort.SearchStrings(tables, name)
+		return i < len(tables) && tables[i] == name
+	}
+	for _, name := range []string{LogAddressIndex, TxLookup, HashedAccounts, TrieOfAccounts, AccountsHistory} {
+		if !in(derived, name) || in(truth, name) {
+			t.Fatalf("%s is not derived", name)
+		}
+	}
+	for _, name := range []string{Headers, BlockBody, EthTx, PlainState} {
+		if !in(truth, name) || in(derived, name) {
+			t.Fatalf("%s is not source-of-truth", name)
+		}
+	}
+	for index := range indexDependencies {
+		if !ChaindataTablesCfg[index].Derived {
+			t.Fatalf("index %s is not derived", index)
+		}
+	}
+}
+
+func TestVerifyDBIStability(t *testing.T) {
+	expected := Tables(TableGroupChaindata)
+	if err := VerifyDBIStability(expected); err != nil {
+		t.Fatalf("current order rejected: %v", err)
+	}
+
+	reordered := append([]string(nil), expected...)
+	reordered[0], reordered[1] = reordered[1], reordered[0]
+	err := VerifyDBIStability(reordered)
+	if err == nil {
+		t.Fatal("reordered tables not detected")
+	}
+	if !strings.Contains(err.Error(), "moved: "+reordered[0]+" 0->1") {
+		t.Fatalf("error does not describe the move: %v", err)
+	}
+
+	removed := append(append([]string(nil), expected...), "NoSuchTable")
+	sort.Strings(removed)
+	err = VerifyDBIStability(removed)
+	if err == nil || !strings.Contains(err.Error(), "removed: NoSuchTable") {
+		t.Fatalf("dropped table not reported: %v", err)
+	}
+}
+
+func TestBackupConsistencyGroups(t *testing.T) {
+	groups := BackupConsistencyGroups()
+	groupOf := make(map[string]int)
+	for i, group := range groups {
+		if !sort.StringsAreSorted(group) {
+			t.Fatalf("group %d is not sorted: %v", i, group)
+		}
+		for _, name := range group {
+			if j, ok := groupOf[name]; ok {
+				t.Fatalf("%s is in groups %d and %d", name, j, i)
+			}
+			groupOf[name] = i
+		}
+	}
+	for _, name := range ChaindataTables {
+		if _, ok := groupOf[name]; !ok {
+			t.Fatalf("%s is in no group", name)
+		}
+	}
+
+	for _, name := range []string{AccountChangeSet, StorageChangeSet, AccountsHistory, StorageHistory} {
+		if groupOf[name] != groupOf[PlainState] {
+			t.Fatalf("%s is not backed up with %s", name, PlainState)
+		}
+	}
+	if groupOf[HeaderCanonical] != groupOf[Headers] {
+		t.Fatalf("%s is not backed up with %s", HeaderCanonical, Headers)
+	}
+}
+
+func TestEngineTables(t *testing.T) {
+	for _, c := range []struct {
+		engine              string
+		required, forbidden []string
+	}{
+		{"parlia", []string{ParliaSnapshot}, []string{BorTxLookup, BorReceipts, BorSeparate, CliqueLastSnapshot, CliqueSeparate, CliqueSnapshot}},
+		{"Clique", []string{CliqueLastSnapshot, CliqueSeparate, CliqueSnapshot}, []string{BorTxLookup, BorReceipts, BorSeparate, ParliaSnapshot}},
+		{"bor", []string{BorTxLookup, BorReceipts, BorSeparate}, []string{CliqueLastSnapshot, CliqueSeparate, CliqueSnapshot, ParliaSnapshot}},
+		{"apos", nil, []string{BorTxLookup, BorReceipts, BorSeparate, CliqueLastSnapshot, CliqueSeparate, CliqueSnapshot, ParliaSnapshot}},
+	} {
+		required, forbidden := EngineTables(c.engine)
+		if !reflect.DeepEqual(required, c.required) || !reflect.DeepEqual(forbidden, c.forbidden) {
+			t.Fatalf("%s: required %v forbidden %v, want %v and %v", c.engine, required, forbidden, c.required, c.forbidden)
+		}
+		for _, table := range append(required, forbidden...) {
+			if _, ok := ChaindataTablesCfg[table]; !ok {
+				t.Fatalf("%s: unknown table %s", c.engine, table)
+			}
+		}
+	}
+}
+
+func TestReadOnlyWhenFrozen(t *testing.T) {
+	frozen := ReadOnlyWhenFrozen()
+	if want := []string{BlockBody, EthTx, Headers}; !reflect.DeepEqual(frozen, want) {
+		t.Fatalf("have %v, want %v", frozen, want)
+	}
+	for _, name := range frozen {
+		if cfg, ok := ChaindataTablesCfg[name]; !ok || cfg.IsDeprecated || cfg.Derived {
+			t.Fatalf("%s is not an active source-of-truth table", name)
+		}
+	}
+	// tables written while replaying blocks stay writable
+	for _, name := range []string{HeaderCanonical, HeaderNumber, Senders, PlainState, Receipts, SyncStageProgress} {
+		for _, f := range frozen {
+			if f == name {
+				t.Fatalf("%s is read-only when frozen", name)
+			}
+		}
+	}
+}
+
+func TestStateRootTables(t *testing.T) {
+	tables := StateRootTables()
+	want := []string{HashedAccounts, HashedStorage, TrieOfAccounts, TrieOfStorage, Code}
+	sort.Strings(want)
+	if !reflect.DeepEqual(tables, want) {
+		t.Fatalf("have %v, want %v", tables, want)
+	}
+	for _, name := range tables {
+		if name == PlainState || name == PlainContractCode {
+			t.Fatalf("plain state table %s is required", name)
+		}
+	}
+}
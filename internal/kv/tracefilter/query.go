@@ -0,0 +1,321 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tracefilter - index side of trace_filter: the blocks of a range with calls from or to
+// given addresses, read from CallFromIndex and CallToIndex (see rawdb.FlushCallTraceIndexes).
+// Only these blocks have to be replayed to produce the traces.
+//
+// The blocks are streamed in ascending order: the bitmaps of the addresses are merged while
+// iterating, their union is never built.
+package tracefilter
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// Mode - how blocks matching FromAddress and blocks matching ToAddress are combined
+type Mode int
+
+const (
+	// Union - blocks with calls from any of FromAddress or to any of ToAddress
+	Union Mode = iota
+	// Intersection - blocks with calls from any of FromAddress and to any of ToAddress
+	Intersection
+)
+
+// CallTraceQuery - blocks [FromBlock, ToBlock] with calls from or to the addresses. Without
+// addresses every block of the range matches, with addresses of one side only Mode is ignored.
+//
+// After skips that many matching blocks, Count limits the number of blocks returned, 0 returns all.
+type CallTraceQuery struct {
+	FromBlock, ToBlock uint64
+	FromAddress        []types.Address
+	ToAddress          []types.Address
+	Mode               Mode
+	After, Count       uint64
+}
+
+// Execute - iterator over the matching blocks in ascending order. The bitmaps of the addresses are
+// read by Execute, tx must stay open while the iterator is used.
+func (q *CallTraceQuery) Execute(ctx context.Context, tx bitmapdb.IndexReader) (*Iterator, error) {
+	it := &Iterator{ctx: ctx, skip: q.After, left: q.Count, limited: q.Count > 0}
+	if q.FromBlock > q.ToBlock || q.FromBlock > math.MaxUint32 {
+		it.s = newBlockRange(1, 0)
+		return it, nil
+	}
+	from, to := uint32(q.FromBlock), uint32(math.MaxUint32)
+	if q.ToBlock < math.MaxUint32 {
+		to = uint32(q.ToBlock)
+	}
+
+	if len(q.FromAddress) == 0 && len(q.ToAddress) == 0 {
+		it.s = newBlockRange(uint64(from), uint64(to))
+		return it, nil
+	}
+	fromBlocks, err := addressBlocks(ctx, tx, kv.CallFromIndex, q.FromAddress, from, to)
+	if err != nil {
+		return nil, err
+	}
+	toBlocks, err := addressBlocks(ctx, tx, kv.CallToIndex, q.ToAddress, from, to)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(q.ToAddress) == 0:
+		it.s = fromBlocks
+	case len(q.FromAddress) == 0:
+		it.s = toBlocks
+	case q.Mode == Intersection:
+		it.s = &intersection{a: fromBlocks, b: toBlocks}
+	case q.Mode == Union:
+		it.s = newUnion(fromBlocks, toBlocks)
+	default:
+		return nil, fmt.Errorf("unknown trace filter mode %d", q.Mode)
+	}
+	return it, nil
+}
+
+// addressBlocks - union of the blocks of every address in table
+func addressBlocks(ctx context.Context, tx bitmapdb.IndexReader, table string, addrs []types.Address, from, to uint32) (stream, error) {
+	streams := make([]stream, 0, len(addrs))
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bm, err := bitmapdb.ReadIndex(tx, table, addr.Bytes(), from, to)
+		if err != nil {
+			return nil, err
+		}
+		if !bm.IsEmpty() {
+			streams = append(streams, &bitmapStream{bm.Iterator()})
+		}
+	}
+	return newUnion(streams...), nil
+}
+
+// Iterator - matching blocks of a CallTraceQuery
+type Iterator struct {
+	ctx     context.Context
+	s       stream
+	skip    uint64
+	left    uint64
+	limited bool
+}
+
+// Next - the next matching block, ok is false once all were returned
+func (it *Iterator) Next() (blockNum uint64, ok bool, err error) {
+	for ; it.skip > 0; it.skip-- {
+		if err := it.ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		if _, ok := it.s.next(); !ok {
+			it.skip = 0
+			return 0, false, nil
+		}
+	}
+	if it.limited && it.left == 0 {
+		return 0, false, nil
+	}
+	if err := it.ctx.Err(); err != nil {
+		return 0, false, err
+	}
+	blockNum, ok = it.s.next()
+	if ok && it.limited {
+		it.left--
+	}
+	return blockNum, ok, nil
+}
+
+// stream - ascending block numbers without duplicates
+type stream interface {
+	peek() (uint64, bool)
+	next() (uint64, bool)
+	advance(min uint64) // skips the numbers below min
+}
+
+type bitmapStream struct {
+	it roaring.IntPeekable
+}
+
+func (s *bitmapStream) peek() (uint64, bool) {
+	if !s.it.HasNext() {
+		return 0, false
+	}
+	return uint64(s.it.PeekNext()), true
+}
+
+func (s *bitmapStream) next() (uint64, bool) {
+	if !s.it.HasNext() {
+		return 0, false
+	}
+	return uint64(s.it.Next()), true
+}
+
+func (s *bitmapStream) advance(min uint64) {
+	if min > math.MaxUint32 {
+		for s.it.HasNext() {
+			s.it.Next()
+		}
+		return
+	}
+	s.it.AdvanceIfNeeded(uint32(min))
+}
+
+// blockRange - every block of [cur, to]
+type blockRange struct {
+	cur, to uint64
+	done    bool
+}
+
+func newBlockRange(from, to uint64) *blockRange {
+	return &blockRange{cur: from, to: to, done: from > to}
+}
+
+func (s *blockRange) peek() (uint64, bool) {
+	return s.cur, !s.done
+}
+
+func (s *blockRange) next() (uint64, bool) {
+	if s.done {
+		return 0, false
+	}
+	n := s.cur
+	if n == s.to {
+		s.done = true
+	} else {
+		s.cur++
+	}
+	return n, true
+}
+
+func (s *blockRange) advance(min uint64) {
+	switch {
+	case s.done || min <= s.cur:
+	case min > s.to:
+		s.done = true
+	default:
+		s.cur = min
+	}
+}
+
+// union - k-way merge of streams, a heap ordered by their next numbers
+type union []stream
+
+func newUnion(streams ...stream) stream {
+	u := make(union, 0, len(streams))
+	for _, s := range streams {
+		if _, ok := s.peek(); ok {
+			u = append(u, s)
+		}
+	}
+	heap.Init(&u)
+	return &u
+}
+
+func (u union) Len() int { return len(u) }
+func (u union) Less(i, j int) bool {
+	a, _ := u[i].peek()
+	b, _ := u[j].peek()
+	return a < b
+}
+func (u union) Swap(i, j int)       { u[i], u[j] = u[j], u[i] }
+func (u *union) Push(x interface{}) { *u = append(*u, x.(stream)) }
+func (u *union) Pop() interface{} {
+	old := *u
+	s := old[len(old)-1]
+	*u = old[:len(old)-1]
+	return s
+}
+
+func (u *union) peek() (uint64, bool) {
+	if len(*u) == 0 {
+		return 0, false
+	}
+	return (*u)[0].peek()
+}
+
+func (u *union) next() (uint64, bool) {
+	n, ok := u.peek()
+	if !ok {
+		return 0, false
+	}
+	u.advance(n + 1)
+	return n, true
+}
+
+// advance - only the streams behind min move, the heap top is checked until it is not
+func (u *union) advance(min uint64) {
+	for len(*u) > 0 {
+		top := (*u)[0]
+		if n, _ := top.peek(); n >= min {
+			return
+		}
+		top.advance(min)
+		if _, ok := top.peek(); ok {
+			heap.Fix(u, 0)
+		} else {
+			heap.Pop(u)
+		}
+	}
+}
+
+// intersection - numbers present in both streams
+type intersection struct {
+	a, b stream
+}
+
+func (s *intersection) peek() (uint64, bool) {
+	for {
+		x, ok := s.a.peek()
+		if !ok {
+			return 0, false
+		}
+		y, ok := s.b.peek()
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return x, true
+		case x < y:
+			s.a.advance(y)
+		default:
+			s.b.advance(x)
+		}
+	}
+}
+
+func (s *intersection) next() (uint64, bool) {
+	n, ok := s.peek()
+	if ok {
+		s.a.advance(n + 1)
+		s.b.advance(n + 1)
+	}
+	return n, ok
+}
+
+func (s *intersection) advance(min uint64) {
+	s.a.advance(min)
+	s.b.advance(min)
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracefilter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+var (
+	alice = types.Address{1}
+	bob   = types.Address{2}
+	carol = types.Address{3}
+)
+
+// writeIndex - blocks of addr in table, in shards of at most 64 bytes so that most
+// addresses have several shards
+func writeIndex(t *testing.T, tx kv.RwTx, table string, addr types.Address, blocks ...uint32) {
+	t.Helper()
+	if err := bitmapdb.UpsertShardedIndex(tx, table, addr.Bytes(), roaring.BitmapOf(blocks...), 64); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func every(from, to, step uint32) []uint32 {
+	var res []uint32
+	for b := from; b <= to; b += step {
+		res = append(res, b)
+	}
+	return res
+}
+
+// setup - alice calls every 3rd block, bob every 5th, carol is only called, every 2nd block.
+func setup(t *testing.T) kv.RwTx {
+	_, tx := memdb.NewTestTx(t)
+	writeIndex(t, tx, kv.CallFromIndex, alice, every(0, 300, 3)...)
+	writeIndex(t, tx, kv.CallFromIndex, bob, every(0, 300, 5)...)
+	writeIndex(t, tx, kv.CallToIndex, carol, every(0, 300, 2)...)
+	return tx
+}
+
+func collect(t *testing.T, tx kv.Tx, q CallTraceQuery) []uint64 {
+	t.Helper()
+	it, err := q.Execute(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []uint64
+	for {
+		n, ok, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return res
+		}
+		res = append(res, n)
+	}
+}
+
+func want(from, to uint64, match func(b uint64) bool) []uint64 {
+	var res []uint64
+	for b := from; b <= to; b++ {
+		if match(b) {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+func TestCallTraceQuery(t *testing.T) {
+	tx := setup(t)
+	cases := []struct {
+		name  string
+		q     CallTraceQuery
+		match func(b uint64) bool
+	}{
+		{"from union", CallTraceQuery{FromBlock: 10, ToBlock: 200, FromAddress: []types.Address{alice, bob}},
+			func(b uint64) bool { return b%3 == 0 || b%5 == 0 }},
+		{"to", CallTraceQuery{FromBlock: 0, ToBlock: 50, ToAddress: []types.Address{carol, alice}},
+			func(b uint64) bool { return b%2 == 0 }},
+		{"union", CallTraceQuery{FromBlock: 100, ToBlock: 1000, FromAddress: []types.Address{alice}, ToAddress: []types.Address{carol}},
+			func(b uint64) bool { return b <= 300 && (b%3 == 0 || b%2 == 0) }},
+		{"intersection", CallTraceQuery{FromBlock: 7, ToBlock: 290, FromAddress: []types.Address{alice, bob}, ToAddress: []types.Address{carol}, Mode: Intersection},
+			func(b uint64) bool { return b%2 == 0 && (b%3 == 0 || b%5 == 0) }},
+		{"empty intersection", CallTraceQuery{FromBlock: 0, ToBlock: 300, FromAddress: []types.Address{carol}, ToAddress: []types.Address{carol}, Mode: Intersection},
+			func(b uint64) bool { return false }},
+		{"all blocks", CallTraceQuery{FromBlock: 5, ToBlock: 20},
+			func(b uint64) bool { return true }},
+		{"inverted range", CallTraceQuery{FromBlock: 20, ToBlock: 5},
+			func(b uint64) bool { return true }},
+	}
+	for _, c := range cases {
+		have := collect(t, tx, c.q)
+		if w := want(c.q.FromBlock, c.q.ToBlock, c.match); !reflect.DeepEqual(have, w) {
+			t.Fatalf("%s: have %v, want %v", c.name, have, w)
+		}
+	}
+}
+
+func TestCallTraceQueryPaging(t *testing.T) {
+	tx := setup(t)
+	q := CallTraceQuery{FromBlock: 0, ToBlock: 300, FromAddress: []types.Address{alice, bob}}
+	all := collect(t, tx, q)
+
+	q.After, q.Count = 10, 5
+	if have := collect(t, tx, q); !reflect.DeepEqual(have, all[10:15]) {
+		t.Fatalf("page: have %v, want %v", have, all[10:15])
+	}
+	q.After, q.Count = uint64(len(all))-2, 5
+	if have := collect(t, tx, q); !reflect.DeepEqual(have, all[len(all)-2:]) {
+		t.Fatalf("last page: have %v, want %v", have, all[len(all)-2:])
+	}
+	q.After, q.Count = uint64(len(all)), 0
+	if have := collect(t, tx, q); len(have) != 0 {
+		t.Fatalf("past the end: have %v", have)
+	}
+}
+
+func TestCallTraceQueryCancel(t *testing.T) {
+	tx := setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	q := CallTraceQuery{FromBlock: 0, ToBlock: 300, FromAddress: []types.Address{alice}}
+	it, err := q.Execute(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := it.Next(); !ok || err != nil {
+		t.Fatalf("first block: ok %t, err %v", ok, err)
+	}
+	cancel()
+	if _, _, err := it.Next(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("have %v, want %v", err, context.Canceled)
+	}
+	if _, err := q.Execute(ctx, tx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("execute: have %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TrieRootKey - key of the root record in TrieOfAccounts: nibbles of the empty path
+var TrieRootKey = []byte{}
+
+// TrieNodeHasState - hasState bitmap of a TrieOfAccounts or TrieOfStorage record,
+// which starts with hasState, hasTree and hasHash as big-endian uint16
+func TrieNodeHasState(v []byte) (uint16, error) {
+	if len(v) < 6 {
+		return 0, fmt.Errorf("trie node of %d bytes, expected at least 6", len(v))
+	}
+	return binary.BigEndian.Uint16(v), nil
+}
+
+// ComputeTrieRootHasState - hasState of the account trie root from HashedAccounts:
+// bit i is set if some hashed account starts with nibble i
+func ComputeTrieRootHasState(tx Tx) (uint16, error) {
+	c, err := tx.Cursor(HashedAccounts)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	var hasState uint16
+	for nibble := 0; nibble < 16; nibble++ {
+		k, _, err := c.Seek([]byte{byte(nibble << 4)})
+		if err != nil {
+			return 0, err
+		}
+		if k == nil {
+			break
+		}
+		if int(k[0]>>4) == nibble {
+			hasState |= 1 << nibble
+		}
+	}
+	return hasState, nil
+}
+
+// VerifyTrieFirstLevel - checks the TrieOfAccounts invariant "first level in account_trie always exists if hasState>0":
+// each bit of the root hasState must have the record of its nibble. When the root record is missing, its hasState
+// is computed from HashedAccounts.
+func VerifyTrieFirstLevel(tx Tx) []error {
+	root, err := tx.GetOne(TrieOfAccounts, TrieRootKey)
+	if err != nil {
+		return []error{fmt.Errorf("%s root: %w", TrieOfAccounts, err)}
+	}
+	var hasState uint16
+	if root == nil {
+		hasState, err = ComputeTrieRootHasState(tx)
+	} else {
+		hasState, err = TrieNodeHasState(root)
+	}
+	if err != nil {
+		return []error{fmt.Errorf("%s root: %w", TrieOfAccounts, err)}
+	}
+
+	var errs []error
+	for nibble := byte(0); nibble < 16; nibble++ {
+		if hasState&(1<<nibble) == 0 {
+			continue
+		}
+		ok, err := tx.Has(TrieOfAccounts, []byte{nibble})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %x: %w", TrieOfAccounts, nibble, err))
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no first level record %x, root hasState %016b", TrieOfAccounts, nibble, hasState))
+		}
+	}
+	return errs
+}
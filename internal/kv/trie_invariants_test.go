@@ -0,0 +1,76 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"testing"
+)
+
+// trieNode - record with the given hasState and no hashes
+func trieNode(hasState uint16) []byte {
+	return []byte{byte(hasState >> 8), byte(hasState), 0, 0, 0, 0}
+}
+
+func TestVerifyTrieFirstLevel(t *testing.T) {
+	tx := newMockTx()
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 0 {
+		t.Fatalf("empty trie: %v", errs)
+	}
+
+	// root covers nibbles 0 and 0xb
+	_ = tx.Put(TrieOfAccounts, TrieRootKey, trieNode(1<<0|1<<0xb))
+	_ = tx.Put(TrieOfAccounts, []byte{0}, trieNode(1))
+	_ = tx.Put(TrieOfAccounts, []byte{0xb}, trieNode(1))
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 0 {
+		t.Fatalf("first level exists: %v", errs)
+	}
+
+	_ = tx.Delete(TrieOfAccounts, []byte{0xb})
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 1 {
+		t.Fatalf("missing first level record: %v", errs)
+	}
+
+	_ = tx.Put(TrieOfAccounts, TrieRootKey, []byte{1})
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 1 {
+		t.Fatalf("malformed root: %v", errs)
+	}
+}
+
+func TestVerifyTrieFirstLevelComputedRoot(t *testing.T) {
+	tx := newMockTx()
+	_ = tx.Put(HashedAccounts, bytes.Repeat([]byte{0x0b}, 32), []byte{1})
+	_ = tx.Put(HashedAccounts, bytes.Repeat([]byte{0x31}, 32), []byte{1})
+	_ = tx.Put(HashedAccounts, bytes.Repeat([]byte{0x3f}, 32), []byte{1})
+
+	hasState, err := ComputeTrieRootHasState(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasState != 1<<0|1<<3 {
+		t.Fatalf("hasState %016b", hasState)
+	}
+
+	_ = tx.Put(TrieOfAccounts, []byte{0}, trieNode(1))
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 1 {
+		t.Fatalf("missing first level record 3: %v", errs)
+	}
+	_ = tx.Put(TrieOfAccounts, []byte{3}, trieNode(1))
+	if errs := VerifyTrieFirstLevel(tx); len(errs) != 0 {
+		t.Fatalf("first level exists: %v", errs)
+	}
+}
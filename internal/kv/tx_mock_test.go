@@ -0,0 +1,387 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"sort"
+)
+
+// mockTx - in-memory RwTx for tests of this package (memdb can't be imported here).
+// Keys are stored in logical format: AutoDupSortKeysConversion is not applied.
+type mockTx struct {
+	tables map[string]*mockTable
+	seq    map[string]uint64
+}
+
+type mockTable struct {
+	dup   bool
+	pairs []mockPair
+}
+
+type mockPair struct {
+	k, v []byte
+}
+
+func newMockTx() *mockTx {
+	return &mockTx{tables: map[string]*mockTable{}, seq: map[string]uint64{}}
+}
+
+func (tx *mockTx) table(name string) *mockTable {
+	t, ok := tx.tables[name]
+	if !ok {
+		t = &mockTable{dup: ChaindataTablesCfg[name].Flags&DupSort != 0}
+		tx.tables[name] = t
+	}
+	return t
+}
+
+// seek - index of first pair >= (k, v)
+func (t *mockTable) seek(k, v []byte) int {
+	return sort.Search(len(t.pairs), func(i int) bool {
+		c := bytes.Compare(t.pairs[i].k, k)
+		if c != 0 {
+			return c > 0
+		}
+		return v == nil || bytes.Compare(t.pairs[i].v, v) >= 0
+	})
+}
+
+func (t *mockTable) put(k, v []byte) {
+	k, v = append([]byte{}, k...), append([]byte{}, v...)
+	if !t.dup {
+		i := t.seek(k, nil)
+		if i < len(t.pairs) && bytes.Equal(t.pairs[i].k, k) {
+			t.pairs[i].v = v
+			return
+		}
+		t.insert(i, k, v)
+		return
+	}
+	i := t.seek(k, v)
+	if i < len(t.pairs) && bytes.Equal(t.pairs[i].k, k) && bytes.Equal(t.pairs[i].v, v) {
+		return
+	}
+	t.insert(i, k, v)
+}
+
+func (t *mockTable) insert(i int, k, v []byte) {
+	t.pairs = append(t.pairs, mockPair{})
+	copy(t.pairs[i+1:], t.pairs[i:])
+	t.pairs[i] = mockPair{k: k, v: v}
+}
+
+func (t *mockTable) remove(i int) {
+	t.pairs = append(t.pairs[:i], t.pairs[i+1:]...)
+}
+
+func (t *mockTable) deleteKey(k []byte) {
+	i := t.seek(k, nil)
+	for i < len(t.pairs) && bytes.Equal(t.pairs[i].k, k) {
+		t.remove(i)
+	}
+}
+
+func (tx *mockTx) ViewID() uint64 { return 1 }
+
+func (tx *mockTx) Has(table string, key []byte) (bool, error) {
+	v, err := tx.GetOne(table, key)
+	return v != nil, err
+}
+
+func (tx *mockTx) GetOne(table string, key []byte) ([]byte, error) {
+	t := tx.table(table)
+	i := t.seek(key, nil)
+	if i < len(t.pairs) && bytes.Equal(t.pairs[i].k, key) {
+		return t.pairs[i].v, nil
+	}
+	return nil, nil
+}
+
+func (tx *mockTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	t := tx.table(table)
+	for i := t.seek(fromPrefix, nil); i < len(t.pairs); i++ {
+		if err := walker(t.pairs[i].k, t.pairs[i].v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *mockTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	t := tx.table(table)
+	for i := t.seek(prefix, nil); i < len(t.pairs) && bytes.HasPrefix(t.pairs[i].k, prefix); i++ {
+		if err := walker(t.pairs[i].k, t.pairs[i].v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *mockTx) ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	t := tx.table(table)
+	for i := t.seek(prefix, nil); i < len(t.pairs) && amount > 0; i, amount = i+1, amount-1 {
+		if err := walker(t.pairs[i].k, t.pairs[i].v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *mockTx) Commit() error { return nil }
+func (tx *mockTx) Rollback()     {}
+
+func (tx *mockTx) ReadSequence(table string) (uint64, error) { return tx.seq[table], nil }
+
+func (tx *mockTx) IncrementSequence(table string, amount uint64) (uint64, error) {
+	current := tx.seq[table]
+	tx.seq[table] = current + amount
+	return current, nil
+}
+
+func (tx *mockTx) BucketSize(table string) (uint64, error) {
+	var size uint64
+	for _, p := range tx.table(table).pairs {
+		size += uint64(len(p.k) + len(p.v))
+	}
+	return size, nil
+}
+
+func (tx *mockTx) DBSize() (uint64, error) {
+	var size uint64
+	for name := range tx.tables {
+		s, _ := tx.BucketSize(name)
+		size += s
+	}
+	return size, nil
+}
+
+func (tx *mockTx) Put(table string, k, v []byte) error {
+	tx.table(table).put(k, v)
+	return nil
+}
+
+func (tx *mockTx) Delete(table string, k []byte) error {
+	tx.table(table).deleteKey(k)
+	return nil
+}
+
+func (tx *mockTx) Append(table string, k, v []byte) error    { return tx.Put(table, k, v) }
+func (tx *mockTx) AppendDup(table string, k, v []byte) error { return tx.Put(table, k, v) }
+
+func (tx *mockTx) DropBucket(table string) error {
+	delete(tx.tables, table)
+	return nil
+}
+
+func (tx *mockTx) CreateBucket(table string) error {
+	tx.table(table)
+	return nil
+}
+
+func (tx *mockTx) ExistsBucket(table string) (bool, error) {
+	_, ok := tx.tables[table]
+	return ok, nil
+}
+
+func (tx *mockTx) ClearBucket(table string) error {
+	tx.table(table).pairs = nil
+	return nil
+}
+
+func (tx *mockTx) ListBuckets() ([]string, error) {
+	res := make([]string, 0, len(tx.tables))
+	for name := range tx.tables {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (tx *mockTx) CollectMetrics() {}
+func (tx *mockTx) Reset() error    { return nil }
+
+func (tx *mockTx) Cursor(table string) (Cursor, error)               { return tx.cursor(table), nil }
+func (tx *mockTx) CursorDupSort(table string) (CursorDupSort, error) { return tx.cursor(table), nil }
+func (tx *mockTx) RwCursor(table string) (RwCursor, error)           { return tx.cursor(table), nil }
+func (tx *mockTx) RwCursorDupSort(table string) (RwCursorDupSort, error) {
+	return tx.cursor(table), nil
+}
+
+func (tx *mockTx) cursor(table string) *mockCursor {
+	return &mockCursor{t: tx.table(table), i: -1}
+}
+
+type mockCursor struct {
+	t *mockTable
+	i int
+}
+
+func (c *mockCursor) at(i int) ([]byte, []byte, error) {
+	c.i = i
+	if i < 0 || i >= len(c.t.pairs) {
+		return nil, nil, nil
+	}
+	return c.t.pairs[i].k, c.t.pairs[i].v, nil
+}
+
+func (c *mockCursor) valid() bool { return c.i >= 0 && c.i < len(c.t.pairs) }
+
+func (c *mockCursor) First() ([]byte, []byte, error) { return c.at(0) }
+func (c *mockCursor) Last() ([]byte, []byte, error)  { return c.at(len(c.t.pairs) - 1) }
+func (c *mockCursor) Next() ([]byte, []byte, error)  { return c.at(c.i + 1) }
+func (c *mockCursor) Current() ([]byte, []byte, error) {
+	return c.at(c.i)
+}
+
+func (c *mockCursor) Prev() ([]byte, []byte, error) {
+	if c.i <= 0 {
+		c.i = -1
+		return nil, nil, nil
+	}
+	return c.at(c.i - 1)
+}
+
+func (c *mockCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	return c.at(c.t.seek(seek, nil))
+}
+
+func (c *mockCursor) SeekExact(key []byte) ([]byte, []byte, error) {
+	i := c.t.seek(key, nil)
+	if i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, key) {
+		return c.at(i)
+	}
+	return nil, nil, nil
+}
+
+func (c *mockCursor) Count() (uint64, error) { return uint64(len(c.t.pairs)), nil }
+func (c *mockCursor) Close()                 {}
+
+func (c *mockCursor) Put(k, v []byte) error {
+	c.t.put(k, v)
+	c.i = c.t.seek(k, v)
+	return nil
+}
+
+func (c *mockCursor) Append(k, v []byte) error       { return c.Put(k, v) }
+func (c *mockCursor) AppendDup(k, v []byte) error    { return c.Put(k, v) }
+func (c *mockCursor) PutNoDupData(k, v []byte) error { return c.Put(k, v) }
+
+func (c *mockCursor) Delete(k []byte) error {
+	c.t.deleteKey(k)
+	return nil
+}
+
+// DeleteCurrent - like in MDBX, following Next returns record after deleted one
+func (c *mockCursor) DeleteCurrent() error {
+	if c.valid() {
+		c.t.remove(c.i)
+		c.i--
+	}
+	return nil
+}
+
+func (c *mockCursor) DeleteExact(k1, k2 []byte) error {
+	i := c.t.seek(k1, k2)
+	if i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, k1) && bytes.Equal(c.t.pairs[i].v, k2) {
+		c.t.remove(i)
+	}
+	return nil
+}
+
+func (c *mockCursor) DeleteCurrentDuplicates() error {
+	if !c.valid() {
+		return nil
+	}
+	k := c.t.pairs[c.i].k
+	first := c.t.seek(k, nil)
+	c.t.deleteKey(k)
+	c.i = first - 1
+	return nil
+}
+
+func (c *mockCursor) SeekBothExact(key, value []byte) ([]byte, []byte, error) {
+	i := c.t.seek(key, value)
+	if i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, key) && bytes.Equal(c.t.pairs[i].v, value) {
+		return c.at(i)
+	}
+	return nil, nil, nil
+}
+
+func (c *mockCursor) SeekBothRange(key, value []byte) ([]byte, error) {
+	i := c.t.seek(key, value)
+	if i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, key) {
+		_, v, err := c.at(i)
+		return v, err
+	}
+	return nil, nil
+}
+
+func (c *mockCursor) FirstDup() ([]byte, error) {
+	if !c.valid() {
+		return nil, nil
+	}
+	_, v, err := c.at(c.t.seek(c.t.pairs[c.i].k, nil))
+	return v, err
+}
+
+func (c *mockCursor) LastDup() ([]byte, error) {
+	if !c.valid() {
+		return nil, nil
+	}
+	k := c.t.pairs[c.i].k
+	i := c.i
+	for i+1 < len(c.t.pairs) && bytes.Equal(c.t.pairs[i+1].k, k) {
+		i++
+	}
+	_, v, err := c.at(i)
+	return v, err
+}
+
+func (c *mockCursor) NextDup() ([]byte, []byte, error) {
+	if !c.valid() || c.i+1 >= len(c.t.pairs) || !bytes.Equal(c.t.pairs[c.i+1].k, c.t.pairs[c.i].k) {
+		return nil, nil, nil
+	}
+	return c.at(c.i + 1)
+}
+
+func (c *mockCursor) NextNoDup() ([]byte, []byte, error) {
+	if !c.valid() {
+		return c.at(c.i + 1)
+	}
+	k := c.t.pairs[c.i].k
+	i := c.i + 1
+	for i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, k) {
+		i++
+	}
+	return c.at(i)
+}
+
+func (c *mockCursor) CountDuplicates() (uint64, error) {
+	if !c.valid() {
+		return 0, nil
+	}
+	k := c.t.pairs[c.i].k
+	var n uint64
+	for i := c.t.seek(k, nil); i < len(c.t.pairs) && bytes.Equal(c.t.pairs[i].k, k); i++ {
+		n++
+	}
+	return n, nil
+}
+
+var _ RwTx = (*mockTx)(nil)
+var _ RwCursorDupSort = (*mockCursor)(nil)
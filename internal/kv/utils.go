@@ -18,9 +18,11 @@ package kv
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/amazechain/amc/common/types"
+	"golang.org/x/crypto/sha3"
 )
 
 var ErrKeyNotFound = errors.New("db: key not found")
@@ -88,3 +90,104 @@ func Bytesmask(fixedbits int) (fixedbytes int, mask byte) {
 	}
 	return fixedbytes, mask
 }
+
+// ContractsWithCode - full scan of PlainContractCode, returns address+incarnation keys of contracts with given code hash
+func ContractsWithCode(tx Tx, codeHash []byte) ([][]byte, error) {
+	c, err := tx.Cursor(PlainContractCode)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var res [][]byte
+	for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(v, codeHash) {
+			res = append(res, types.CopyBytes(k))
+		}
+	}
+	return res, nil
+}
+
+// VerifyIntegerKeyLengths - reports every key of table which is not 8 bytes long.
+// MDBX integer comparator of IntegerKey tables assumes 8-byte keys.
+func VerifyIntegerKeyLengths(tx Tx, table string) []error {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return []error{err}
+	}
+	defer c.Close()
+
+	var errs []error
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return append(errs, err)
+		}
+		if len(k) != 8 {
+			errs = append(errs, fmt.Errorf("%s: key %x has length %d, want 8", table, k, len(k)))
+		}
+	}
+	return errs
+}
+
+// HashTableContents - keccak256 over all key/value pairs of table, including every value
+// of DupSort keys. The hash is order-dependent: pairs are fed in cursor order, which is
+// the sorted order on every node, each key and value prefixed by its big-endian uint32
+// length so that different splits of the same bytes never collide.
+func HashTableContents(tx Tx, table string) ([32]byte, error) {
+	var res [32]byte
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return res, err
+	}
+	defer c.Close()
+
+	h := sha3.NewLegacyKeccak256()
+	var l [4]byte
+	for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return res, err
+		}
+		binary.BigEndian.PutUint32(l[:], uint32(len(k)))
+		h.Write(l[:])
+		h.Write(k)
+		binary.BigEndian.PutUint32(l[:], uint32(len(v)))
+		h.Write(l[:])
+		h.Write(v)
+	}
+	h.Sum(res[:0])
+	return res, nil
+}
+
+// HasStaleReconData - true if any of ReconTables is non-empty. Reconstitution clears them on
+// success, so data here is left over by an interrupted run and should be cleaned before the next one.
+// Tables absent from the db are skipped when tx is able to tell it.
+func HasStaleReconData(tx Tx) (bool, error) {
+	migrator, canCheck := tx.(BucketMigrator)
+	for _, table := range ReconTables {
+		if canCheck {
+			exists, err := migrator.ExistsBucket(table)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				continue
+			}
+		}
+		c, err := tx.Cursor(table)
+		if err != nil {
+			return false, err
+		}
+		k, _, err := c.First()
+		c.Close()
+		if err != nil {
+			return false, err
+		}
+		if k != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func contractKey(addr byte, incarnation uint64) []byte {
+	k := make([]byte, 28)
+	k[19] = addr
+	binary.BigEndian.PutUint64(k[20:], incarnation)
+	return k
+}
+
+func TestContractsWithCode(t *testing.T) {
+	tx := newMockTx()
+	shared := bytes.Repeat([]byte{0xaa}, 32)
+	other := bytes.Repeat([]byte{0xbb}, 32)
+	for _, c := range []struct {
+		key  []byte
+		code []byte
+	}{
+		{contractKey(1, 1), shared},
+		{contractKey(2, 1), other},
+		{contractKey(3, 1), shared},
+		{contractKey(3, 2), other},
+		{contractKey(4, 1), shared},
+	} {
+		if err := tx.Put(PlainContractCode, c.key, c.code); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	have, err := ContractsWithCode(tx, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{contractKey(1, 1), contractKey(3, 1), contractKey(4, 1)}
+	if len(have) != len(want) {
+		t.Fatalf("have %d contracts, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(have[i], want[i]) {
+			t.Fatalf("contract %d: have %x, want %x", i, have[i], want[i])
+		}
+	}
+
+	none, err := ContractsWithCode(tx, bytes.Repeat([]byte{0xcc}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("unexpected contracts %x", none)
+	}
+}
+
+func TestVerifyIntegerKeyLengths(t *testing.T) {
+	tx := newMockTx()
+	for _, k := range [][]byte{
+		{0, 0, 0, 0, 0, 0, 0, 1},
+		{0, 0, 0, 0, 0, 0, 0, 2},
+		{0, 0, 0, 3},
+		{0, 0, 0, 0, 0, 0, 0, 4, 0},
+	} {
+		if err := tx.Put(HeaderCanonical, k, []byte{1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := VerifyIntegerKeyLengths(tx, HeaderCanonical)
+	if len(errs) != 2 {
+		t.Fatalf("have %d errors, want 2: %v", len(errs), errs)
+	}
+
+	valid := newMockTx()
+	if err := valid.Put(HeaderCanonical, []byte{0, 0, 0, 0, 0, 0, 0, 1}, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := VerifyIntegerKeyLengths(valid, HeaderCanonical); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestHashTableContents(t *testing.T) {
+	fill := func(extra []byte) *mockTx {
+		tx := newMockTx()
+		for i := byte(0); i < 3; i++ {
+			if err := tx.Put(PlainContractCode, contractKey(i, 1), []byte{i, 0xaa}); err != nil {
+				t.Fatal(err)
+			}
+			// AccountChangeSet is DupSort: several values under one key
+			for _, v := range [][]byte{{1}, {2}, {3}} {
+				if err := tx.Put(AccountChangeSet, []byte{0, i}, append(v, i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if extra != nil {
+			if err := tx.Put(AccountChangeSet, []byte{0, 1}, extra); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tx
+	}
+	hash := func(tx Tx, table string) [32]byte {
+		h, err := HashTableContents(tx, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	a, b := fill(nil), fill(nil)
+	for _, table := range []string{PlainContractCode, AccountChangeSet} {
+		if hash(a, table) != hash(b, table) {
+			t.Fatalf("%s: identical contents hash differently", table)
+		}
+	}
+	if hash(a, PlainContractCode) == hash(newMockTx(), PlainContractCode) {
+		t.Fatal("empty table hashes like a filled one")
+	}
+
+	if err := b.Put(PlainContractCode, contractKey(1, 1), []byte{1, 0xab}); err != nil {
+		t.Fatal(err)
+	}
+	if hash(a, PlainContractCode) == hash(b, PlainContractCode) {
+		t.Fatal("differing value not detected")
+	}
+
+	// an additional duplicate value of an existing key changes the hash
+	if hash(a, AccountChangeSet) == hash(fill([]byte{9}), AccountChangeSet) {
+		t.Fatal("differing dup value not detected")
+	}
+}
+
+func TestHasStaleReconData(t *testing.T) {
+	tx := newMockTx()
+	if stale, err := HasStaleReconData(tx); err != nil || stale {
+		t.Fatalf("fresh db: stale=%t err=%v", stale, err)
+	}
+	for _, table := range ReconTables {
+		if err := tx.CreateBucket(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Put(PlainState, []byte{1}, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := HasStaleReconData(tx); err != nil || stale {
+		t.Fatalf("empty recon tables: stale=%t err=%v", stale, err)
+	}
+
+	if err := tx.Put(XStorage, []byte{2}, []byte{2}); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := HasStaleReconData(tx); err != nil || !stale {
+		t.Fatalf("leftover %s: stale=%t err=%v", XStorage, stale, err)
+	}
+	if err := tx.ClearBucket(XStorage); err != nil {
+		t.Fatal(err)
+	}
+	if stale, err := HasStaleReconData(tx); err != nil || stale {
+		t.Fatalf("cleaned recon tables: stale=%t err=%v", stale, err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaIncompatible - db was written with other major DBSchemaVersion, its layout can't be read by this binary
+var ErrSchemaIncompatible = errors.New("incompatible db schema version")
+
+// ErrDowngradeNotAllowed - db was written by a newer binary, wraps ErrSchemaIncompatible
+var ErrDowngradeNotAllowed = fmt.Errorf("%w: downgrade not allowed", ErrSchemaIncompatible)
+
+// SchemaVersion - version of db layout, stored in DatabaseInfo under DBSchemaVersionKey as 3 big-endian uint32.
+// Major changes break compatibility, see DBSchemaVersion versions list in tables.go.
+type SchemaVersion struct {
+	Major, Minor, Patch uint32
+}
+
+// CurrentSchemaVersion - layout written by this binary
+var CurrentSchemaVersion = SchemaVersion{Major: 6}
+
+func (v SchemaVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+func (v SchemaVersion) Bytes() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b, v.Major)
+	binary.BigEndian.PutUint32(b[4:], v.Minor)
+	binary.BigEndian.PutUint32(b[8:], v.Patch)
+	return b
+}
+
+// ReadSchemaVersion - stored version, ok=false for db which has none
+func ReadSchemaVersion(tx Getter) (v SchemaVersion, ok bool, err error) {
+	b, err := tx.GetOne(DatabaseInfo, DBSchemaVersionKey)
+	if err != nil || len(b) == 0 {
+		return v, false, err
+	}
+	if len(b) != 12 {
+		return v, false, fmt.Errorf("%w: stored version %x", ErrSchemaIncompatible, b)
+	}
+	return SchemaVersion{binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:])}, true, nil
+}
+
+// CheckSchemaVersion - compares the stored version with CurrentSchemaVersion (expected).
+// Versions differing only in patch are compatible, so is a fresh db (current is zero then).
+// A db of an older minor, or a pre-versioning db holding blocks, is not compatible and needs migrations.
+// A newer version is refused with ErrDowngradeNotAllowed, an older major with ErrSchemaIncompatible.
+func CheckSchemaVersion(tx Getter) (current, expected SchemaVersion, compatible bool, err error) {
+	expected = CurrentSchemaVersion
+	current, ok, err := ReadSchemaVersion(tx)
+	if err != nil {
+		return current, expected, false, err
+	}
+	if !ok {
+		var legacy bool
+		err = tx.ForAmount(HeaderCanonical, nil, 1, func(_, _ []byte) error {
+			legacy = true
+			return nil
+		})
+		return current, expected, !legacy, err
+	}
+	switch {
+	case current.Major > expected.Major || current.Major == expected.Major && current.Minor > expected.Minor:
+		return current, expected, false, fmt.Errorf("%w: db has %s, binary writes %s", ErrDowngradeNotAllowed, current, expected)
+	case current.Major < expected.Major:
+		return current, expected, false, fmt.Errorf("%w: db has %s, binary supports %d.x", ErrSchemaIncompatible, current, expected.Major)
+	}
+	return current, expected, current.Minor == expected.Minor, nil
+}
+
+// EnsureSchemaVersion - writes CurrentSchemaVersion into fresh db (written=true), checks the stored one otherwise.
+// A db which needs migrations is refused with ErrSchemaIncompatible, see EnforceSchemaVersion to run them.
+func EnsureSchemaVersion(tx RwTx) (written bool, err error) {
+	current, expected, compatible, err := CheckSchemaVersion(tx)
+	if err != nil {
+		return false, err
+	}
+	if !compatible {
+		return false, fmt.Errorf("%w: db has %s, binary writes %s, migrations required", ErrSchemaIncompatible, current, expected)
+	}
+	if current == (SchemaVersion{}) {
+		return true, tx.Put(DatabaseInfo, DBSchemaVersionKey, expected.Bytes())
+	}
+	return false, nil
+}
+
+// SchemaUpgrade - brings db of an older schema version up to CurrentSchemaVersion, see migrations.Upgrade
+type SchemaUpgrade func(ctx context.Context, db RwDB) error
+
+// EnforceSchemaVersion - open-time check: writes CurrentSchemaVersion into fresh db, runs upgrade for db
+// which needs migrations and refuses newer or older-major db. A nil upgrade refuses outdated db as well.
+func EnforceSchemaVersion(ctx context.Context, db RwDB, upgrade SchemaUpgrade) error {
+	var current, expected SchemaVersion
+	var compatible bool
+	if err := db.Update(ctx, func(tx RwTx) (err error) {
+		if current, expected, compatible, err = CheckSchemaVersion(tx); err != nil || !compatible {
+			return err
+		}
+		if current == (SchemaVersion{}) {
+			return tx.Put(DatabaseInfo, DBSchemaVersionKey, expected.Bytes())
+		}
+		return nil
+	}); err != nil || compatible {
+		return err
+	}
+	if upgrade == nil {
+		return fmt.Errorf("%w: db has %s, binary writes %s, migrations required", ErrSchemaIncompatible, current, expected)
+	}
+	if err := upgrade(ctx, db); err != nil {
+		return fmt.Errorf("upgrade db schema from %s: %w", current, err)
+	}
+	return db.View(ctx, func(tx Tx) (err error) {
+		if current, expected, compatible, err = CheckSchemaVersion(tx); err == nil && !compatible {
+			err = fmt.Errorf("%w: db still has %s after migrations, binary writes %s", ErrSchemaIncompatible, current, expected)
+		}
+		return err
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEnsureSchemaVersion(t *testing.T) {
+	tx := newMockTx()
+	written, err := EnsureSchemaVersion(tx)
+	if err != nil || !written {
+		t.Fatalf("fresh db: written %t, err %v", written, err)
+	}
+	if v, _ := tx.GetOne(DatabaseInfo, DBSchemaVersionKey); !bytes.Equal(v, CurrentSchemaVersion.Bytes()) {
+		t.Fatalf("stored %x, want %x", v, CurrentSchemaVersion.Bytes())
+	}
+	if written, err = EnsureSchemaVersion(tx); err != nil || written {
+		t.Fatalf("reopen: written %t, err %v", written, err)
+	}
+
+	compatible := CurrentSchemaVersion
+	compatible.Patch++
+	tx = newMockTx()
+	_ = tx.Put(DatabaseInfo, DBSchemaVersionKey, compatible.Bytes())
+	if written, err = EnsureSchemaVersion(tx); err != nil || written {
+		t.Fatalf("%s db: written %t, err %v", compatible, written, err)
+	}
+	if v, _ := tx.GetOne(DatabaseInfo, DBSchemaVersionKey); !bytes.Equal(v, compatible.Bytes()) {
+		t.Fatalf("compatible version overwritten with %x", v)
+	}
+
+	for _, stored := range [][]byte{
+		SchemaVersion{Major: CurrentSchemaVersion.Major - 1}.Bytes(),
+		SchemaVersion{Major: CurrentSchemaVersion.Major + 1}.Bytes(),
+		SchemaVersion{Major: CurrentSchemaVersion.Major, Minor: CurrentSchemaVersion.Minor + 1}.Bytes(),
+		{0, 0, 0, 6},
+	} {
+		tx = newMockTx()
+		_ = tx.Put(DatabaseInfo, DBSchemaVersionKey, stored)
+		if written, err = EnsureSchemaVersion(tx); !errors.Is(err, ErrSchemaIncompatible) || written {
+			t.Fatalf("stored %x: written %t, err %v", stored, written, err)
+		}
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	defer func(v SchemaVersion) { CurrentSchemaVersion = v }(CurrentSchemaVersion)
+	CurrentSchemaVersion = SchemaVersion{Major: 6, Minor: 2, Patch: 1}
+
+	tests := []struct {
+		name       string
+		stored     *SchemaVersion
+		blocks     bool
+		compatible bool
+		err        error
+	}{
+		{name: "fresh", compatible: true},
+		{name: "unversioned with blocks", blocks: true},
+		{name: "matching", stored: &SchemaVersion{6, 2, 1}, compatible: true},
+		{name: "older patch", stored: &SchemaVersion{6, 2, 0}, compatible: true},
+		{name: "newer patch", stored: &SchemaVersion{6, 2, 7}, compatible: true},
+		{name: "older minor", stored: &SchemaVersion{6, 1, 3}},
+		{name: "newer minor", stored: &SchemaVersion{6, 3, 0}, err: ErrDowngradeNotAllowed},
+		{name: "newer major", stored: &SchemaVersion{7, 0, 0}, err: ErrDowngradeNotAllowed},
+		{name: "older major", stored: &SchemaVersion{5, 9, 0}, err: ErrSchemaIncompatible},
+	}
+	for _, tt := range tests {
+		tx := newMockTx()
+		if tt.stored != nil {
+			_ = tx.Put(DatabaseInfo, DBSchemaVersionKey, tt.stored.Bytes())
+		}
+		if tt.blocks {
+			_ = tx.Put(HeaderCanonical, EncodeBlockNum(3), make([]byte, 32))
+		}
+		current, expected, compatible, err := CheckSchemaVersion(tx)
+		if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+			t.Fatalf("%s: err %v, want %v", tt.name, err, tt.err)
+		}
+		if compatible != tt.compatible {
+			t.Fatalf("%s: compatible %t, want %t", tt.name, compatible, tt.compatible)
+		}
+		if expected != CurrentSchemaVersion {
+			t.Fatalf("%s: expected %s, want %s", tt.name, expected, CurrentSchemaVersion)
+		}
+		if tt.stored != nil && current != *tt.stored || tt.stored == nil && current != (SchemaVersion{}) {
+			t.Fatalf("%s: current %s", tt.name, current)
+		}
+	}
+	if !errors.Is(ErrDowngradeNotAllowed, ErrSchemaIncompatible) {
+		t.Fatalf("ErrDowngradeNotAllowed does not wrap ErrSchemaIncompatible")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package walk - range walks over tables which keys start with big-endian block number
+// (AccountChangeSet, StorageChangeSet, Receipts, Log, CallTraceSet, ...).
+//
+// All walks visit blocks in [from, to): from is inclusive, to is exclusive.
+// On DupSort tables every value of a key is visited, not only the first one.
+package walk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// ErrStop - return it from Func to end the walk early without error
+var ErrStop = errors.New("walk: stop")
+
+// Func - visitor of one record, blockNum is decoded from the first 8 bytes of k
+type Func func(blockNum uint64, k, v []byte) error
+
+// BlockRange - visits records of blocks [from, to) of table in ascending order
+func BlockRange(ctx context.Context, tx kv.Tx, table string, from, to uint64, fn Func) error {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return Cursor(ctx, c, from, to, fn)
+}
+
+// ReverseBlockRange - visits records of blocks [from, to) of table in descending order
+func ReverseBlockRange(ctx context.Context, tx kv.Tx, table string, from, to uint64, fn Func) error {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return ReverseCursor(ctx, c, from, to, fn)
+}
+
+// Cursor - same as BlockRange, but over caller's cursor. fn may call DeleteCurrent on a RwCursor,
+// the walk then continues from the next record.
+func Cursor(ctx context.Context, c kv.Cursor, from, to uint64, fn Func) error {
+	if from >= to {
+		return nil
+	}
+	k, v, err := c.Seek(blockKey(from))
+	for ; k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		blockNum, err := decode(k)
+		if err != nil {
+			return err
+		}
+		if blockNum >= to {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(blockNum, k, v); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return err
+}
+
+// ReverseCursor - same as ReverseBlockRange, but over caller's cursor
+func ReverseCursor(ctx context.Context, c kv.Cursor, from, to uint64, fn Func) error {
+	if from >= to {
+		return nil
+	}
+	k, v, err := c.Seek(blockKey(to))
+	if err != nil {
+		return err
+	}
+	// position at the last value of the last key below `to`
+	if k == nil {
+		k, v, err = c.Last()
+	} else {
+		k, v, err = c.Prev()
+	}
+	for ; k != nil; k, v, err = c.Prev() {
+		if err != nil {
+			return err
+		}
+		blockNum, err := decode(k)
+		if err != nil {
+			return err
+		}
+		if blockNum < from {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(blockNum, k, v); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return err
+}
+
+func blockKey(blockNum uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, blockNum)
+	return k
+}
+
+func decode(k []byte) (uint64, error) {
+	if len(k) < 8 {
+		return 0, fmt.Errorf("key %x is shorter than block number", k)
+	}
+	return binary.BigEndian.Uint64(k), nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package walk
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+type record struct {
+	blockNum uint64
+	k, v     []byte
+}
+
+func (r record) String() string {
+	return fmt.Sprintf("%d:%x=%x", r.blockNum, r.k, r.v)
+}
+
+func fill(t *testing.T, tx kv.RwTx, table string, rnd *rand.Rand, dups int) {
+	t.Helper()
+	for n := uint64(0); n < 200; n++ {
+		if rnd.Intn(3) == 0 {
+			continue
+		}
+		k := blockKey(n)
+		if dups == 0 {
+			k = append(k, byte(rnd.Intn(256)))
+		}
+		for i := 0; i <= dups; i++ {
+			v := make([]byte, 1+rnd.Intn(8))
+			rnd.Read(v)
+			if err := tx.Put(table, k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func collect(t *testing.T, tx kv.Tx, table string, from, to uint64, reverse bool) []record {
+	t.Helper()
+	var res []record
+	fn := func(blockNum uint64, k, v []byte) error {
+		res = append(res, record{blockNum, append([]byte(nil), k...), append([]byte(nil), v...)})
+		return nil
+	}
+	walk := BlockRange
+	if reverse {
+		walk = ReverseBlockRange
+	}
+	if err := walk(context.Background(), tx, table, from, to, fn); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// bruteForce - full scan of table filtered by [from, to)
+func bruteForce(t *testing.T, tx kv.Tx, table string, from, to uint64, reverse bool) []record {
+	t.Helper()
+	var res []record
+	if err := tx.ForEach(table, nil, func(k, v []byte) error {
+		if n := binary.BigEndian.Uint64(k); n >= from && n < to {
+			res = append(res, record{n, append([]byte(nil), k...), append([]byte(nil), v...)})
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if reverse {
+		for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+			res[i], res[j] = res[j], res[i]
+		}
+	}
+	return res
+}
+
+func TestBlockRangeMatchesFullScan(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	rnd := rand.New(rand.NewSource(1))
+	fill(t, tx, kv.Receipts, rnd, 0)
+	fill(t, tx, kv.AccountChangeSet, rnd, 3)
+
+	for _, table := range []string{kv.Receipts, kv.AccountChangeSet} {
+		for i := 0; i < 300; i++ {
+			from, to := uint64(rnd.Intn(220)), uint64(rnd.Intn(220))
+			for _, reverse := range []bool{false, true} {
+				have := collect(t, tx, table, from, to, reverse)
+				want := bruteForce(t, tx, table, from, to, reverse)
+				if fmt.Sprint(have) != fmt.Sprint(want) {
+					t.Fatalf("%s [%d, %d) reverse=%t:\nhave %v\nwant %v", table, from, to, reverse, have, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCursorDeleteCurrent(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	fill(t, tx, kv.AccountChangeSet, rand.New(rand.NewSource(2)), 2)
+	want := bruteForce(t, tx, kv.AccountChangeSet, 0, 50, false)
+	want = append(want, bruteForce(t, tx, kv.AccountChangeSet, 150, 200, false)...)
+
+	c, err := tx.RwCursorDupSort(kv.AccountChangeSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := Cursor(context.Background(), c, 50, 100, func(uint64, []byte, []byte) error {
+		return c.DeleteCurrent()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// all values of a key at once, the walk goes on from the next key
+	if err := Cursor(context.Background(), c, 100, 150, func(uint64, []byte, []byte) error {
+		return c.DeleteCurrentDuplicates()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if have := bruteForce(t, tx, kv.AccountChangeSet, 0, 200, false); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Fatalf("have %v\nwant %v", have, want)
+	}
+}
+
+func TestBlockRangeStopAndCancel(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	fill(t, tx, kv.Receipts, rand.New(rand.NewSource(3)), 0)
+
+	visited := 0
+	if err := BlockRange(context.Background(), tx, kv.Receipts, 0, 200, func(uint64, []byte, []byte) error {
+		visited++
+		if visited == 5 {
+			return ErrStop
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if visited != 5 {
+		t.Fatalf("visited %d records after stop", visited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := BlockRange(ctx, tx, kv.Receipts, 0, 200, func(uint64, []byte, []byte) error {
+		t.Fatal("visited record of cancelled walk")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("have %v, want context.Canceled", err)
+	}
+}
+
+func TestRangeMatchesFullScan(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	rnd := rand.New(rand.NewSource(4))
+	fill(t, tx, kv.AccountChangeSet, rnd, 3)
+
+	c, err := tx.Cursor(kv.AccountChangeSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 300; i++ {
+		from, to := uint64(rnd.Intn(220)), uint64(rnd.Intn(220))
+		var have []record
+		for r := NewRange(c, from, to); r.Next(); {
+			have = append(have, record{r.BlockNum(), append([]byte(nil), r.Key()...), append([]byte(nil), r.Value()...)})
+		}
+		if want := bruteForce(t, tx, kv.AccountChangeSet, from, to, false); fmt.Sprint(have) != fmt.Sprint(want) {
+			t.Fatalf("[%d, %d):\nhave %v\nwant %v", from, to, have, want)
+		}
+	}
+
+	prefix := blockKey(uint64(rnd.Intn(200)))
+	var have []record
+	r := NewPrefix(c, prefix[:7])
+	for r.Next() {
+		have = append(have, record{binary.BigEndian.Uint64(r.Key()), append([]byte(nil), r.Key()...), append([]byte(nil), r.Value()...)})
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	from := binary.BigEndian.Uint64(append(prefix[:7:7], 0))
+	if want := bruteForce(t, tx, kv.AccountChangeSet, from, from+256, false); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Fatalf("prefix %x:\nhave %v\nwant %v", prefix[:7], have, want)
+	}
+}
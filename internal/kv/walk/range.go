@@ -0,0 +1,90 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package walk
+
+import (
+	"bytes"
+
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// Range - pull counterpart of Cursor: one record per Next, for iterators which hand records out one by one.
+// Range does not own the cursor, k and v are valid until the next call of Next.
+type Range struct {
+	c      kv.Cursor
+	seek   []byte
+	prefix []byte
+	to     uint64
+	bounds bool
+
+	started, done bool
+	blockNum      uint64
+	k, v          []byte
+	err           error
+}
+
+// NewRange - records of blocks [from, to) in ascending order
+func NewRange(c kv.Cursor, from, to uint64) *Range {
+	return &Range{c: c, seek: blockKey(from), to: to, bounds: true, done: from >= to}
+}
+
+// NewPrefix - records which keys start with prefix, in ascending order. BlockNum is not decoded.
+func NewPrefix(c kv.Cursor, prefix []byte) *Range {
+	return &Range{c: c, seek: prefix, prefix: prefix}
+}
+
+// Next - moves to the next record, false at the end of the range or on error
+func (r *Range) Next() bool {
+	if r.done {
+		return false
+	}
+	var err error
+	if !r.started {
+		r.started = true
+		r.k, r.v, err = r.c.Seek(r.seek)
+	} else {
+		r.k, r.v, err = r.c.Next()
+	}
+	if err != nil {
+		return r.stop(err)
+	}
+	if r.k == nil || (r.prefix != nil && !bytes.HasPrefix(r.k, r.prefix)) {
+		return r.stop(nil)
+	}
+	if r.bounds {
+		if r.blockNum, err = decode(r.k); err != nil {
+			return r.stop(err)
+		}
+		if r.blockNum >= r.to {
+			return r.stop(nil)
+		}
+	}
+	return true
+}
+
+func (r *Range) stop(err error) bool {
+	r.done, r.err = true, err
+	r.k, r.v = nil, nil
+	return false
+}
+
+func (r *Range) BlockNum() uint64 { return r.blockNum }
+func (r *Range) Key() []byte      { return r.k }
+func (r *Range) Value() []byte    { return r.v }
+
+// Err - error which ended the range, nil at its regular end
+func (r *Range) Err() error { return r.err }
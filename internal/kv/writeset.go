@@ -0,0 +1,188 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// ExecutionChanges - what execution of one block changed
+type ExecutionChanges struct {
+	Accounts [][]byte // addresses of changed accounts
+	Storage  []StorageChange
+	Code     []CodeChange
+	Txs      int      // amount of transactions, receipts are written for non-empty blocks
+	LogTxs   []uint32 // indices of transactions emitting logs
+	Traced   [][]byte // addresses touched by call traces
+}
+
+type StorageChange struct {
+	Addr        []byte
+	Incarnation uint64
+	Loc         []byte
+}
+
+type CodeChange struct {
+	Addr        []byte
+	Incarnation uint64
+	CodeHash    []byte
+}
+
+// ExecutionWriteSet - every db key written by the Execution stage for blockNum, by table, sorted and
+// without duplicates. In DupSort tables one key holds many values, so their entries are identified
+// by the key followed by the fixed-size head of the value (address, storage key), e.g.
+// block_num_u64 + address for AccountChangeSet.
+func ExecutionWriteSet(blockNum uint64, changes ExecutionChanges) map[string][][]byte {
+	ws := make(map[string][][]byte)
+	add := func(table string, parts ...[]byte) {
+		var k []byte
+		for _, p := range parts {
+			k = append(k, p...)
+		}
+		ws[table] = append(ws[table], k)
+	}
+	num := EncodeBlockNum(blockNum)
+	inc := func(i uint64) []byte {
+		b := make([]byte, IncarnationLen)
+		binary.BigEndian.PutUint64(b, i)
+		return b
+	}
+
+	for _, addr := range changes.Accounts {
+		add(PlainState, addr)
+		add(AccountChangeSet, num, addr)
+	}
+	for _, s := range changes.Storage {
+		add(PlainState, StorageKey(s.Addr, s.Incarnation, s.Loc))
+		add(StorageChangeSet, StorageChangeSetKey(blockNum, s.Addr, s.Incarnation), s.Loc)
+	}
+	for _, c := range changes.Code {
+		add(PlainContractCode, c.Addr, inc(c.Incarnation))
+		add(Code, c.CodeHash)
+	}
+	if changes.Txs > 0 {
+		add(Receipts, num)
+	}
+	for _, i := range changes.LogTxs {
+		txIdx := make([]byte, 4)
+		binary.BigEndian.PutUint32(txIdx, i)
+		add(Log, num, txIdx)
+	}
+	for _, addr := range changes.Traced {
+		add(CallTraceSet, num, addr)
+	}
+
+	for table, keys := range ws {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		res := keys[:0]
+		for i, k := range keys {
+			if i == 0 || !bytes.Equal(k, keys[i-1]) {
+				res = append(res, k)
+			}
+		}
+		ws[table] = res
+	}
+	return ws
+}
+
+// BlockRange - inclusive range of block numbers, empty if From > To
+type BlockRange struct {
+	From, To uint64
+}
+
+// Empty - true if the range has no blocks
+func (r BlockRange) Empty() bool { return r.From > r.To }
+
+// Len - amount of blocks in the range
+func (r BlockRange) Len() uint64 {
+	if r.Empty() {
+		return 0
+	}
+	return r.To - r.From + 1
+}
+
+// ReorgTableOps - blocks whose records a reorg deletes from and inserts into one table.
+// Where both ranges overlap the records are overwritten.
+type ReorgTableOps struct {
+	Delete BlockRange
+	Insert BlockRange
+}
+
+// ReorgPlan - tables a reorg writes to, see ReorgWriteSet
+type ReorgPlan struct {
+	Ancestor, OldHead, NewHead uint64
+	Tables                     map[string]ReorgTableOps
+}
+
+// reorgBlockTables - tables keyed by canonical block number, or derived from canonical blocks only:
+// records of the old segment are removed (state unwound) and the new segment is written (executed)
+var reorgBlockTables = []string{
+	HeaderCanonical,
+	EthTx,
+	TxLookup,
+	Receipts,
+	Log,
+	LogTopicIndex,
+	LogAddressIndex,
+	CallTraceSet,
+	CallFromIndex,
+	CallToIndex,
+	CumulativeGasIndex,
+	CumulativeTransactionIndex,
+	AccountChangeSet,
+	StorageChangeSet,
+	AccountsHistory,
+	StorageHistory,
+	PlainState,
+	PlainContractCode,
+	HashedAccounts,
+	HashedStorage,
+	TrieOfAccounts,
+	TrieOfStorage,
+}
+
+// reorgHeadTables - single pointers rewritten from the old head to the new head
+var reorgHeadTables = []string{
+	HeadHeaderKey,
+	HeadBlockKey,
+}
+
+// ReorgWriteSet - writes of a reorg replacing the canonical segment commonAncestor+1..oldHead with
+// commonAncestor+1..newHead, by table. Transactions of the old segment move from EthTx to
+// NonCanonicalTxs and the ones of the new segment the other way. Headers, bodies, total difficulties
+// and senders are keyed by block number and hash, both segments keep them and they are not listed.
+// A head at or below the ancestor gives an empty segment, e.g. a plain rewind.
+func ReorgWriteSet(commonAncestor uint64, oldHead, newHead uint64) ReorgPlan {
+	plan := ReorgPlan{Ancestor: commonAncestor, OldHead: oldHead, NewHead: newHead, Tables: make(map[string]ReorgTableOps)}
+	oldSeg := BlockRange{From: commonAncestor + 1, To: oldHead}
+	newSeg := BlockRange{From: commonAncestor + 1, To: newHead}
+	if oldSeg.Empty() && newSeg.Empty() {
+		return plan
+	}
+
+	for _, table := range reorgBlockTables {
+		plan.Tables[table] = ReorgTableOps{Delete: oldSeg, Insert: newSeg}
+	}
+	plan.Tables[NonCanonicalTxs] = ReorgTableOps{Delete: newSeg, Insert: oldSeg}
+	// the head hash changes even if the height does not
+	for _, table := range reorgHeadTables {
+		plan.Tables[table] = ReorgTableOps{Delete: BlockRange{oldHead, oldHead}, Insert: BlockRange{newHead, newHead}}
+	}
+	return plan
+}
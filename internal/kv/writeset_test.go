@@ -0,0 +1,122 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package kv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExecutionWriteSet(t *testing.T) {
+	a, b := bytes.Repeat([]byte{0xa}, AddrLen), bytes.Repeat([]byte{0xb}, AddrLen)
+	loc := fixed([]byte{1}, HashLen)
+	codeHash := bytes.Repeat([]byte{0xc}, HashLen)
+	ws := ExecutionWriteSet(3, ExecutionChanges{
+		Accounts: [][]byte{b, a, a},
+		Storage:  []StorageChange{{Addr: a, Incarnation: 1, Loc: loc}},
+		Code:     []CodeChange{{Addr: b, Incarnation: 1, CodeHash: codeHash}},
+		Txs:      2,
+		LogTxs:   []uint32{1},
+		Traced:   [][]byte{a, b},
+	})
+
+	num := EncodeBlockNum(3)
+	want := map[string][][]byte{
+		PlainState:        {a, StorageKey(a, 1, loc), b},
+		AccountChangeSet:  {append(append([]byte{}, num...), a...), append(append([]byte{}, num...), b...)},
+		StorageChangeSet:  {append(StorageChangeSetKey(3, a, 1), loc...)},
+		PlainContractCode: {StorageKey(b, 1, nil)[:AddrLen+IncarnationLen]},
+		Code:              {codeHash},
+		Receipts:          {num},
+		Log:               {append(append([]byte{}, num...), 0, 0, 0, 1)},
+		CallTraceSet:      {append(append([]byte{}, num...), a...), append(append([]byte{}, num...), b...)},
+	}
+	if len(ws) != len(want) {
+		t.Fatalf("have %d tables, want %d: %x", len(ws), len(want), ws)
+	}
+	for table, keys := range want {
+		have := ws[table]
+		if len(have) != len(keys) {
+			t.Fatalf("%s: have %x, want %x", table, have, keys)
+		}
+		for i := range keys {
+			if !bytes.Equal(have[i], keys[i]) {
+				t.Fatalf("%s key %d: have %x, want %x", table, i, have[i], keys[i])
+			}
+		}
+	}
+
+	empty := ExecutionWriteSet(4, ExecutionChanges{})
+	if len(empty) != 0 {
+		t.Fatalf("empty block writes %x", empty)
+	}
+}
+
+func TestReorgWriteSetSingleBlock(t *testing.T) {
+	plan := ReorgWriteSet(9, 10, 10)
+	tip := BlockRange{10, 10}
+	for _, table := range append(append([]string{NonCanonicalTxs}, reorgBlockTables...), reorgHeadTables...) {
+		ops, ok := plan.Tables[table]
+		if !ok {
+			t.Fatalf("%s is not written", table)
+		}
+		if ops.Delete != tip || ops.Insert != tip {
+			t.Fatalf("%s: have %+v, want block 10 replaced", table, ops)
+		}
+	}
+	for _, table := range []string{Headers, BlockBody, HeaderTD, HeaderNumber, Senders} {
+		if ops, ok := plan.Tables[table]; ok {
+			t.Fatalf("%s of both forks is kept, have %+v", table, ops)
+		}
+	}
+	if len(plan.Tables) != len(reorgBlockTables)+len(reorgHeadTables)+1 {
+		t.Fatalf("have %d tables", len(plan.Tables))
+	}
+}
+
+func TestReorgWriteSetMultiBlock(t *testing.T) {
+	plan := ReorgWriteSet(100, 103, 105)
+	oldSeg, newSeg := BlockRange{101, 103}, BlockRange{101, 105}
+	if oldSeg.Len() != 3 || newSeg.Len() != 5 {
+		t.Fatalf("have segments of %d and %d blocks", oldSeg.Len(), newSeg.Len())
+	}
+	for _, table := range []string{HeaderCanonical, EthTx, TxLookup, Receipts, LogAddressIndex, AccountChangeSet, AccountsHistory, PlainState, TrieOfStorage} {
+		if have := plan.Tables[table]; have.Delete != oldSeg || have.Insert != newSeg {
+			t.Fatalf("%s: have %+v, want delete %v insert %v", table, have, oldSeg, newSeg)
+		}
+	}
+	if have := plan.Tables[NonCanonicalTxs]; have.Delete != newSeg || have.Insert != oldSeg {
+		t.Fatalf("%s: have %+v", NonCanonicalTxs, have)
+	}
+	if have := plan.Tables[HeadBlockKey]; have.Delete != (BlockRange{103, 103}) || have.Insert != (BlockRange{105, 105}) {
+		t.Fatalf("%s: have %+v", HeadBlockKey, have)
+	}
+	for table := range plan.Tables {
+		if _, ok := ChaindataTablesCfg[table]; !ok {
+			t.Fatalf("%s is not a chaindata table", table)
+		}
+	}
+
+	// rewind: nothing is inserted
+	rewind := ReorgWriteSet(5, 8, 5)
+	if have := rewind.Tables[Receipts]; have.Delete != (BlockRange{6, 8}) || !have.Insert.Empty() {
+		t.Fatalf("rewind %s: have %+v", Receipts, have)
+	}
+	if none := ReorgWriteSet(5, 5, 5); len(none.Tables) != 0 {
+		t.Fatalf("no-op reorg writes %v", none.Tables)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package maintenance implements the node freeze used for backups, migrations
+// and incident response: reads keep being served while chain writes, sync,
+// txpool admission and block production are refused.
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules"
+	event "github.com/amazechain/amc/modules/event/v2"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// EnterTimeout bounds the wait for the chain write in progress when entering maintenance mode.
+const EnterTimeout = 30 * time.Second
+
+// ErrMaintenance is returned by every write refused in maintenance mode.
+var ErrMaintenance = errors.New("node is in maintenance mode")
+
+// maintenanceKey in DatabaseInfo is present while the node is frozen, so a restart stays frozen.
+var maintenanceKey = []byte("maintenance")
+
+// Mode gates chain writes. Writers bracket every batch with BeginWrite and
+// its release, Enter refuses new batches and waits for the running ones to
+// commit. A nil Mode never freezes.
+type Mode struct {
+	db kv.RwDB
+
+	mu      sync.Mutex
+	enabled bool
+	writers int
+	idle    chan struct{} // closed by the last writer leaving while Enter waits
+}
+
+// New returns the mode persisted in db.
+func New(db kv.RwDB) (*Mode, error) {
+	m := &Mode{db: db}
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		ok, err := tx.Has(modules.DatabaseInfo, maintenanceKey)
+		m.enabled = ok
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if m.enabled {
+		log.Warn("Node is in maintenance mode, sync, block production and transaction admission are halted")
+	}
+	return m, nil
+}
+
+// Of returns the maintenance mode of chain, nil if it has none.
+func Of(chain interface{}) *Mode {
+	if c, ok := chain.(interface{ Maintenance() *Mode }); ok {
+		return c.Maintenance()
+	}
+	return nil
+}
+
+// Enabled reports whether the node is frozen.
+func (m *Mode) Enabled() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled
+}
+
+// BeginWrite admits a chain write, release must be called once it is
+// committed or abandoned. It fails with ErrMaintenance while frozen.
+func (m *Mode) BeginWrite() (release func(), err error) {
+	if m == nil {
+		return func() {}, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.enabled {
+		return nil, ErrMaintenance
+	}
+	m.writers++
+	var once sync.Once
+	return func() { once.Do(m.endWrite) }, nil
+}
+
+func (m *Mode) endWrite() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writers--
+	if m.writers == 0 && m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+}
+
+// Enter freezes the node. New writes are refused at once, writes already
+// admitted are waited for until ctx is done or EnterTimeout passes, in which
+// case the node is left running.
+func (m *Mode) Enter(ctx context.Context) error {
+	m.mu.Lock()
+	if m.enabled {
+		m.mu.Unlock()
+		return nil
+	}
+	m.enabled = true
+	idle := make(chan struct{})
+	if m.writers == 0 {
+		close(idle)
+	} else {
+		m.idle = idle
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, EnterTimeout)
+	defer cancel()
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		m.set(false)
+		return ctx.Err()
+	}
+	if err := m.db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Put(modules.DatabaseInfo, maintenanceKey, []byte{1})
+	}); err != nil {
+		m.set(false)
+		return err
+	}
+	log.Warn("Entered maintenance mode")
+	event.GlobalEvent.Send(&common.MaintenanceEvent{Enabled: true})
+	return nil
+}
+
+// Exit unfreezes the node, sync resumes from the committed head.
+func (m *Mode) Exit(ctx context.Context) error {
+	if !m.Enabled() {
+		return nil
+	}
+	if err := m.db.Update(ctx, func(tx kv.RwTx) error {
+		return tx.Delete(modules.DatabaseInfo, maintenanceKey)
+	}); err != nil {
+		return err
+	}
+	m.set(false)
+	log.Info("Left maintenance mode")
+	event.GlobalEvent.Send(&common.MaintenanceEvent{Enabled: false})
+	return nil
+}
+
+func (m *Mode) set(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled, m.idle = enabled, nil
+}
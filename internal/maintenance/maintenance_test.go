@@ -0,0 +1,226 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package maintenance
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+const batchSize = 16
+
+func openDB(t *testing.T, path string) kv.RwDB {
+	t.Helper()
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(path).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	return db
+}
+
+func countKeys(t *testing.T, db kv.RoDB) (batches map[uint64]int, total int) {
+	t.Helper()
+	batches = make(map[uint64]int)
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		return tx.ForEach(modules.Headers, nil, func(k, _ []byte) error {
+			batches[binary.BigEndian.Uint64(k)]++
+			total++
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return batches, total
+}
+
+// TestMaintenanceUnderSync toggles maintenance mode while a writer commits
+// batches the way block import does and a reader keeps reading.
+func TestMaintenanceUnderSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chaindata")
+	db := openDB(t, path)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Enabled() {
+		t.Fatal("new database is frozen")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		wg        sync.WaitGroup
+		committed uint64
+		refused   uint64
+		readErr   atomic.Value
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for batch := uint64(0); ctx.Err() == nil; {
+			release, err := m.BeginWrite()
+			if errors.Is(err, ErrMaintenance) {
+				atomic.AddUint64(&refused, 1)
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if err := db.Update(ctx, func(tx kv.RwTx) error {
+				for i := uint64(0); i < batchSize; i++ {
+					k := make([]byte, 16)
+					binary.BigEndian.PutUint64(k, batch)
+					binary.BigEndian.PutUint64(k[8:], i)
+					if err := tx.Put(modules.Headers, k, []byte{1}); err != nil {
+						return err
+					}
+					time.Sleep(50 * time.Microsecond) // a long batch, Enter has to wait for it
+				}
+				return nil
+			}); err == nil {
+				atomic.AddUint64(&committed, 1)
+				batch++
+			}
+			release()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			if err := db.View(ctx, func(tx kv.Tx) error {
+				_, err := tx.GetOne(modules.Headers, make([]byte, 16))
+				return err
+			}); err != nil && ctx.Err() == nil {
+				readErr.Store(err)
+			}
+		}
+	}()
+
+	for round := 0; round < 5; round++ {
+		time.Sleep(5 * time.Millisecond)
+		if err := m.Enter(context.Background()); err != nil {
+			t.Fatalf("round %d: enter: %v", round, err)
+		}
+		frozen := atomic.LoadUint64(&committed)
+		batches, total := countKeys(t, db)
+		time.Sleep(5 * time.Millisecond)
+		if _, again := countKeys(t, db); again != total || atomic.LoadUint64(&committed) != frozen {
+			t.Fatalf("round %d: writes while frozen", round)
+		}
+		// the batch running on enter has committed in full
+		if uint64(len(batches)) != frozen || total != int(frozen)*batchSize {
+			t.Fatalf("round %d: %d batches committed, %d in db holding %d keys", round, frozen, len(batches), total)
+		}
+		if err := m.Exit(context.Background()); err != nil {
+			t.Fatalf("round %d: exit: %v", round, err)
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	if err, ok := readErr.Load().(error); ok {
+		t.Fatalf("read failed: %v", err)
+	}
+	if atomic.LoadUint64(&refused) == 0 {
+		t.Fatal("no write was refused")
+	}
+	batches, _ := countKeys(t, db)
+	for batch, n := range batches {
+		if n != batchSize {
+			t.Fatalf("batch %d: %d of %d keys", batch, n, batchSize)
+		}
+	}
+	db.Close()
+}
+
+func TestMaintenancePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chaindata")
+	db := openDB(t, path)
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Enter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// a restart stays frozen
+	db = openDB(t, path)
+	defer db.Close()
+	if m, err = New(db); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Enabled() {
+		t.Fatal("maintenance mode lost on restart")
+	}
+	if _, err := m.BeginWrite(); !errors.Is(err, ErrMaintenance) {
+		t.Fatalf("write while frozen: %v", err)
+	}
+	if err := m.Exit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = New(db); err != nil || m.Enabled() {
+		t.Fatalf("maintenance mode kept after exit: %v", err)
+	}
+}
+
+func TestMaintenanceEnterTimeout(t *testing.T) {
+	db := openDB(t, filepath.Join(t.TempDir(), "chaindata"))
+	defer db.Close()
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := m.BeginWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.Enter(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("enter with a stuck write: %v", err)
+	}
+	if m.Enabled() {
+		t.Fatal("frozen after failed enter")
+	}
+	release()
+	release() // idempotent
+	if err := m.Enter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilMode *Mode
+	if nilMode.Enabled() || Of(struct{}{}) != nil {
+		t.Fatal("nil mode is frozen")
+	}
+	if release, err := nilMode.BeginWrite(); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+}
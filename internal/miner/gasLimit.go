@@ -16,7 +16,27 @@
 
 package miner
 
-import "github.com/amazechain/amc/params"
+import (
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/params"
+)
+
+// GasLimitGovernor lets consortium chains steer the gas limit trajectory without forking the miner.
+// The returned value is a target: each block moves towards it by at most the per-block adjustment
+// limit (parentGasLimit/GasLimitBoundDivisor), so produced headers pass misc.VerifyGaslimit on peers.
+type GasLimitGovernor interface {
+	TargetGasLimit(parent *block.Header) uint64
+}
+
+// nextGasLimit returns the gas limit of the child of parent. parentGasLimit may differ from
+// parent.GasLimit on the EIP-1559 transition block.
+func nextGasLimit(governor GasLimitGovernor, parent *block.Header, parentGasLimit, gasCeil uint64) uint64 {
+	desired := gasCeil
+	if governor != nil {
+		desired = governor.TargetGasLimit(parent)
+	}
+	return CalcGasLimit(parentGasLimit, desired)
+}
 
 func CalcGasLimit(parentGasLimit, desiredLimit uint64) uint64 {
 	delta := parentGasLimit/params.GasLimitBoundDivisor - 1
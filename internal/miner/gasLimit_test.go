@@ -0,0 +1,86 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/internal/consensus/misc"
+	"github.com/holiman/uint256"
+)
+
+// rampGovernor - raises the gas limit by step per block for blocks (from, from+blocks], then holds
+type rampGovernor struct {
+	from, blocks uint64
+	start, step  uint64
+}
+
+func (g rampGovernor) TargetGasLimit(parent *block.Header) uint64 {
+	n := parent.Number.Uint64() + 1
+	switch {
+	case n <= g.from:
+		return g.start
+	case n > g.from+g.blocks:
+		return g.start + g.blocks*g.step
+	default:
+		return g.start + (n-g.from)*g.step
+	}
+}
+
+func TestGasLimitGovernorRamp(t *testing.T) {
+	const start = 30_000_000
+	governor := rampGovernor{from: 0, blocks: 20, start: start, step: 20_000}
+	parent := &block.Header{Number: uint256.NewInt(0), GasLimit: start}
+
+	for i := uint64(1); i <= 25; i++ {
+		limit := nextGasLimit(governor, parent, parent.GasLimit, 8_000_000)
+		if err := misc.VerifyGaslimit(parent.GasLimit, limit); err != nil {
+			t.Fatalf("block %d: produced gas limit rejected: %v", i, err)
+		}
+		want := governor.TargetGasLimit(parent)
+		if limit != want {
+			t.Fatalf("block %d: gas limit %d, want %d", i, limit, want)
+		}
+		parent = &block.Header{Number: uint256.NewInt(i), GasLimit: limit}
+	}
+	if parent.GasLimit != start+20*20_000 {
+		t.Fatalf("ramp ended at %d", parent.GasLimit)
+	}
+}
+
+func TestGasLimitGovernorBounded(t *testing.T) {
+	const start = 30_000_000
+	// target far beyond the per-block adjustment limit
+	governor := rampGovernor{from: 0, blocks: 1, start: start, step: start}
+	parent := &block.Header{Number: uint256.NewInt(0), GasLimit: start}
+
+	for i := uint64(1); i <= 5; i++ {
+		limit := nextGasLimit(governor, parent, parent.GasLimit, 8_000_000)
+		if limit <= parent.GasLimit {
+			t.Fatalf("block %d: gas limit %d does not move towards target", i, limit)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, limit); err != nil {
+			t.Fatalf("block %d: produced gas limit rejected: %v", i, err)
+		}
+		parent = &block.Header{Number: uint256.NewInt(i), GasLimit: limit}
+	}
+
+	if limit := nextGasLimit(nil, parent, parent.GasLimit, 8_000_000); limit >= parent.GasLimit {
+		t.Fatalf("without governor gas limit must move towards GasCeil, have %d", limit)
+	}
+}
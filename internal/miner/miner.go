@@ -142,6 +142,11 @@ func (m *Miner) SetCoinbase(addr types.Address) {
 	m.worker.setCoinbase(addr)
 }
 
+// SetGasLimitGovernor sets the hook consulted for the gas limit of produced blocks, nil restores GasCeil.
+func (m *Miner) SetGasLimitGovernor(governor GasLimitGovernor) {
+	m.worker.setGasLimitGovernor(governor)
+}
+
 func (m *Miner) PendingBlockAndReceipts() (block.IBlock, block.Receipts) {
 	return m.worker.pendingBlockAndReceipts()
 }
@@ -43,6 +43,7 @@ import (
 	"github.com/amazechain/amc/conf"
 
 	"github.com/amazechain/amc/internal/consensus"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/log"
 	event "github.com/amazechain/amc/modules/event/v2"
 	"github.com/amazechain/amc/params"
@@ -143,6 +144,9 @@ type worker struct {
 	cancel context.CancelFunc
 	//current     *environment
 	newTaskHook func(*task)
+	// gasLimitGovernor - optional target of produced blocks gas limit, GasCeil is used without it.
+	// Guarded by mu, setGasLimitGovernor may replace it while blocks are built.
+	gasLimitGovernor GasLimitGovernor
 
 	snapshotMu       sync.RWMutex // The lock used to protect the snapshots below
 	snapshotBlock    block.IBlock
@@ -224,6 +228,12 @@ func (w *worker) setCoinbase(addr types.Address) {
 	w.coinbase = addr
 }
 
+func (w *worker) setGasLimitGovernor(governor GasLimitGovernor) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gasLimitGovernor = governor
+}
+
 func (w *worker) runLoop() error {
 	defer w.cancel()
 	defer w.stop()
@@ -335,6 +345,10 @@ func (w *worker) taskLoop() error {
 }
 
 func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) error {
+	if maintenance.Of(w.chain).Enabled() {
+		log.Debug("Skip sealing work in maintenance mode")
+		return nil
+	}
 	start := time.Now()
 	if w.isRunning() {
 		if w.coinbase == (types.Address{}) {
@@ -355,7 +369,7 @@ func (w *worker) commitWork(interrupt *int32, noempty bool, timestamp int64) err
 	}
 	defer tx.Rollback()
 
-	stateReader := state.NewPlainStateReader(tx)
+	stateReader := state.NewCodeCachingReader(state.NewPlainStateReader(tx), state.DefaultCodeCache.Consumer("mining"))
 	ibs := state.New(stateReader)
 	// generate state for mobile verify
 	ibs.BeginWriteSnapshot()
@@ -479,6 +493,11 @@ func (w *worker) fillTransactions(interrupt *int32, env *environment, ibs *state
 
 	log.Tracef("fillTransactions txs len:%d", len(txs))
 	for _, tx := range txs {
+		// Skip transactions whose validity window doesn't cover this block
+		if err := internal.CheckTxValidity(w.chainConfig, header, tx); err != nil {
+			log.Debug("skip transaction", "hash", tx.Hash(), "err", err)
+			continue
+		}
 		// Start executing the transaction
 		_, err := miningCommitTx(tx, env.coinbase, &vm2.Config{}, w.chainConfig, ibs, env)
 		if nil != err {
@@ -528,6 +547,8 @@ func (w *worker) prepareWork(param *generateParams) (*environment, error) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
+	// read under w.mu, the same governor is used for the whole header
+	governor := w.gasLimitGovernor
 	timestamp := param.timestamp
 
 	parent := w.chain.CurrentBlock().Header().(*block.Header)
@@ -548,7 +569,7 @@ func (w *worker) prepareWork(param *generateParams) (*environment, error) {
 		ParentHash: parent.Hash(),
 		Coinbase:   param.coinbase,
 		Number:     uint256.NewInt(0).Add(parent.Number64(), uint256.NewInt(1)),
-		GasLimit:   CalcGasLimit(parent.GasLimit, w.conf.GasCeil),
+		GasLimit:   nextGasLimit(governor, parent, parent.GasLimit, w.conf.GasCeil),
 		Time:       uint64(timestamp),
 		Difficulty: uint256.NewInt(0),
 		// just for now
@@ -559,8 +580,8 @@ func (w *worker) prepareWork(param *generateParams) (*environment, error) {
 	if w.chainConfig.IsLondon(header.Number.Uint64()) {
 		header.BaseFee, _ = uint256.FromBig(misc.CalcBaseFee(w.chainConfig, parent))
 		if !w.chainConfig.IsLondon(parent.Number64().Uint64()) {
-			parentGasLimit := parent.GasLimit * params.ElasticityMultiplier
-			header.GasLimit = CalcGasLimit(parentGasLimit, w.minerConf.GasCeil)
+			parentGasLimit := parent.GasLimit * w.chainConfig.GetElasticityMultiplier(header.Number.Uint64())
+			header.GasLimit = nextGasLimit(governor, parent, parentGasLimit, w.minerConf.GasCeil)
 		}
 	}
 
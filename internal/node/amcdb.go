@@ -0,0 +1,88 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"errors"
+
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// amcDB - erigon-lib RwDB as RwDB of internal/kv, for the schema check and the migrations of internal/kv.
+// Both name the node tables alike, cursors of erigon-lib satisfy the cursor interfaces of internal/kv.
+type amcDB struct {
+	kv.RwDB
+}
+
+func (db amcDB) View(ctx context.Context, f func(tx amckv.Tx) error) error {
+	return db.RwDB.View(ctx, func(tx kv.Tx) error { return f(amcTx{tx}) })
+}
+
+func (db amcDB) Update(ctx context.Context, f func(tx amckv.RwTx) error) error {
+	return db.RwDB.Update(ctx, func(tx kv.RwTx) error { return f(amcRwTx{tx}) })
+}
+
+func (db amcDB) BeginRo(ctx context.Context) (amckv.Tx, error) {
+	tx, err := db.RwDB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return amcTx{tx}, nil
+}
+
+func (db amcDB) BeginRw(ctx context.Context) (amckv.RwTx, error) {
+	tx, err := db.RwDB.BeginRw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return amcRwTx{tx}, nil
+}
+
+func (db amcDB) BeginSnapshot(ctx context.Context) (amckv.Snapshot, error) {
+	return amckv.NewSnapshot(ctx, db, 0)
+}
+
+func (db amcDB) AllBuckets() amckv.TableCfg { return amckv.ChaindataTablesCfg }
+
+type amcTx struct {
+	kv.Tx
+}
+
+func (tx amcTx) Cursor(table string) (amckv.Cursor, error) { return tx.Tx.Cursor(table) }
+func (tx amcTx) CursorDupSort(table string) (amckv.CursorDupSort, error) {
+	return tx.Tx.CursorDupSort(table)
+}
+
+type amcRwTx struct {
+	kv.RwTx
+}
+
+func (tx amcRwTx) Cursor(table string) (amckv.Cursor, error) { return tx.RwTx.Cursor(table) }
+func (tx amcRwTx) CursorDupSort(table string) (amckv.CursorDupSort, error) {
+	return tx.RwTx.CursorDupSort(table)
+}
+func (tx amcRwTx) RwCursor(table string) (amckv.RwCursor, error) { return tx.RwTx.RwCursor(table) }
+func (tx amcRwTx) RwCursorDupSort(table string) (amckv.RwCursorDupSort, error) {
+	return tx.RwTx.RwCursorDupSort(table)
+}
+
+// Reset - erigon-lib transactions can't be renewed in place
+func (tx amcRwTx) Reset() error { return errors.New("reset of an erigon-lib transaction") }
+
+var _ amckv.RwDB = amcDB{}
@@ -0,0 +1,100 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/integrity"
+	"github.com/amazechain/amc/log"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+const (
+	// integrityStep - blocks of history verified by 1 idle step
+	integrityStep = 1000
+	// integrityInterval - how often the node tries an idle step
+	integrityInterval = 10 * time.Second
+	// integrityBudget - time the startup checks planned by integrity.IntegrityPlan may take
+	integrityBudget = 10 * time.Second
+)
+
+// integrityChecks - every check of integrity.Invariants, the idle pass advances the ones a startup plan seeded
+func integrityChecks() []integrity.Check {
+	checks := make([]integrity.Check, len(integrity.Invariants))
+	for i, inv := range integrity.Invariants {
+		checks[i] = inv.Check
+	}
+	return checks
+}
+
+// integrityTx - erigon-lib RwTx as integrity.Tx
+type integrityTx struct {
+	kv.RwTx
+}
+
+func (tx integrityTx) Cursor(table string) (amckv.Cursor, error) { return tx.RwTx.Cursor(table) }
+
+func (n *Node) integrityUpdate(ctx context.Context, f func(tx integrity.Tx) error) error {
+	return n.db.Update(ctx, func(tx kv.RwTx) error { return f(integrityTx{tx}) })
+}
+
+// verifyStartup - runs the checks integrity.IntegrityPlan fits into integrityBudget over blocks written since
+// the last start. Violations are logged and freeze the check, a violated critical invariant fails the start.
+func (n *Node) verifyStartup() error {
+	counts := make(map[string]uint64)
+	budget := integrityBudget
+	stats, err := TableStats(n.ctx, n.db)
+	switch {
+	case err == nil:
+		for table, st := range stats {
+			counts[table] = st.Entries
+		}
+	case errors.Is(err, amckv.ErrNotSupported):
+		// sizes unknown, only the critical checks
+		budget = 0
+	default:
+		return err
+	}
+	checks := integrity.PlanChecks(budget, counts)
+	head := n.blocks.CurrentBlock().Number64().Uint64()
+	var critical error
+	if err := n.integrityUpdate(n.ctx, func(tx integrity.Tx) error {
+		violations, err := integrity.RunStartup(tx, checks, head)
+		for _, v := range violations {
+			if inv, _ := integrity.InvariantOf(v.Check); inv.Critical && critical == nil {
+				critical = v
+			}
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	return critical
+}
+
+// verifyIdle - verifies older history while the downloader is not syncing
+func (n *Node) verifyIdle() {
+	idle := func() bool { return !n.downloader.IsDownloading() }
+	verifier := integrity.NewIdleVerifier(n.integrityUpdate, integrityChecks(), integrityStep, integrityInterval, idle)
+	if err := verifier.Run(n.ctx); err != nil && n.ctx.Err() == nil {
+		log.Warn("Failed to verify history", "err", err)
+	}
+}
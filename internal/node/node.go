@@ -26,6 +26,7 @@ import (
 	"github.com/holiman/uint256"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
 
@@ -53,6 +54,7 @@ import (
 	"time"
 
 	"github.com/amazechain/amc/accounts"
+	"github.com/amazechain/amc/accounts/external"
 	"github.com/amazechain/amc/accounts/keystore"
 	"github.com/amazechain/amc/api/protocol/types_pb"
 	"github.com/amazechain/amc/common"
@@ -66,11 +68,18 @@ import (
 	"github.com/amazechain/amc/internal/consensus"
 	"github.com/amazechain/amc/internal/consensus/apoa"
 	"github.com/amazechain/amc/internal/consensus/apos"
+	"github.com/amazechain/amc/internal/datadir"
+	"github.com/amazechain/amc/internal/diskguard"
 	"github.com/amazechain/amc/internal/download"
+	amckv "github.com/amazechain/amc/internal/kv"
+	amcmdbx "github.com/amazechain/amc/internal/kv/mdbx"
+	"github.com/amazechain/amc/internal/kv/migrations"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/internal/miner"
 	"github.com/amazechain/amc/internal/network"
 	"github.com/amazechain/amc/internal/pubsub"
 	"github.com/amazechain/amc/internal/txspool"
+	"github.com/amazechain/amc/modules/ethdb"
 	event "github.com/amazechain/amc/modules/event/v2"
 	"github.com/amazechain/amc/modules/rawdb"
 	"github.com/amazechain/amc/modules/rpc/jsonrpc"
@@ -79,6 +88,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 type Node struct {
@@ -95,6 +105,7 @@ type Node struct {
 	blocks          common.IBlockChain
 	engine          consensus.Engine
 	db              kv.RwDB
+	lease           *datadir.Holder
 	txspool         txs_pool.ITxsPool
 	txsFetcher      *txspool.TxsFetcher
 	nodeKey         crypto.PrivKey
@@ -124,6 +135,8 @@ type Node struct {
 	accman     *accounts.Manager
 	keyDir     string // key store directory
 	keyDirTemp bool   // If true, key directory will be removed by Stop
+
+	extSigner *external.ExternalSigner // seals with remote keys instead of the keystore, if set
 }
 
 func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
@@ -157,8 +170,34 @@ func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
 	}
 	log.Info("new node", "address", types.PrivateToAddress(privateKey))
 
+	var lease *datadir.Holder
+	if cfg.NodeCfg.DataDir != "" {
+		lease, err = datadir.Acquire(cfg.NodeCfg.DataDir, datadir.Options{
+			Takeover:   cfg.NodeCfg.DataDirTakeover,
+			OnShutdown: requestShutdown,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// every failed return below releases the datadir and the database again
+	var chainKv kv.RwDB
+	created := false
+	defer func() {
+		if created {
+			return
+		}
+		if chainKv != nil {
+			chainKv.Close()
+		}
+		if lease != nil {
+			lease.Release()
+		}
+	}()
+
 	//
-	chainKv, err := OpenDatabase(cfg, nil, name)
+	chainKv, err = OpenDatabase(cfg, nil, name)
 	if nil != err {
 		return nil, err
 	}
@@ -217,6 +256,21 @@ func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
 	}
 
 	bc, _ := internal.NewBlockChain(ctx, genesisBlock, engine, downloader, chainKv, pubsubServer, cfg.GenesisBlockCfg.Config)
+	if cfg.DatabaseCfg.EventJournal {
+		bc.(*internal.BlockChain).SetEventJournal(rawdb.EventJournalRetention{MaxAge: cfg.DatabaseCfg.EventJournalMaxAge})
+	}
+	if cfg.DatabaseCfg.FeeAccounting {
+		bc.(*internal.BlockChain).SetFeeAccounting(true)
+	}
+	if cfg.DatabaseCfg.AccessLists {
+		bc.(*internal.BlockChain).SetAccessLists(true, cfg.DatabaseCfg.AccessListRetention)
+	}
+	bc.(*internal.BlockChain).SetForkRetention(cfg.DatabaseCfg.ForkRetention)
+	mode, err := maintenance.New(chainKv)
+	if err != nil {
+		return nil, err
+	}
+	bc.(*internal.BlockChain).SetMaintenance(mode)
 	pool, _ := txspool.NewTxsPool(ctx, bc)
 
 	//todo
@@ -243,6 +297,17 @@ func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
 	downloader = download.NewDownloader(ctx, bc, s, pubsubServer, peers)
 
 	_ = s.SetHandler(message.MsgDownloader, downloader.ConnHandler)
+
+	if cfg.NodeCfg.DataDir != "" && cfg.DatabaseCfg.DiskGuardMargin > 0 {
+		guardCfg := diskguard.DefaultConfig
+		guardCfg.Dirs = []string{cfg.NodeCfg.DataDir}
+		guardCfg.Margin = cfg.DatabaseCfg.DiskGuardMargin << 20
+		guard := diskguard.New(guardCfg, diskguard.StatVolume, func() (uint64, uint64) {
+			return bc.CurrentBlock().Number64().Uint64(), downloader.(*download.Downloader).Highest()
+		})
+		bc.(*internal.BlockChain).SetDiskGuard(guard)
+		go guard.Run(ctx)
+	}
 	_ = s.SetHandler(message.MsgTransaction, txsFetcher.ConnHandler)
 
 	miner := miner.NewMiner(ctx, cfg, bc, engine, pool, nil)
@@ -265,6 +330,7 @@ func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
 		nodeKey:         privateKey,
 		blocks:          bc,
 		db:              chainKv,
+		lease:           lease,
 		shutDown:        make(chan struct{}),
 		pubsubServer:    pubsubServer,
 		peers:           peers,
@@ -306,8 +372,13 @@ func NewNode(ctx context.Context, cfg *conf.Config) (*Node, error) {
 	log.Info(strings.Repeat("-", 153))
 	log.Info("")
 
-	node.api = api.NewAPI(pubsubServer, s, peers, bc, chainKv, engine, pool, downloader, node.AccountManager(), cfg.GenesisBlockCfg.Config)
+	// RPC calls count their kv reads, see admin_slowQueries
+	apiKv := ethdb.WithReadAccounting(kv.ChainDB, chainKv)
+	jsonrpc.DefaultSlowQueryLog().Configure(cfg.NodeCfg.RPCSlowQueries, cfg.NodeCfg.RPCSlowQueryParams)
+	node.api = api.NewAPI(pubsubServer, s, peers, bc, apiKv, engine, pool, downloader, node.AccountManager(), cfg.GenesisBlockCfg.Config)
 	node.api.SetGpo(api.NewOracle(bc, miner, cfg.GenesisBlockCfg.Config, gpoParams))
+	node.api.SetLogsLimit(cfg.NodeCfg.RPCLogsLimit)
+	created = true
 	return &node, nil
 }
 
@@ -327,6 +398,11 @@ func (n *Node) Start() error {
 		return err
 	}
 
+	if err := n.verifyStartup(); err != nil {
+		log.Errorf("failed verify chain integrity, err: %v", err)
+		return err
+	}
+
 	if n.config.NodeCfg.Miner {
 
 		// Configure the local mining address
@@ -336,20 +412,14 @@ func (n *Node) Start() error {
 			return fmt.Errorf("etherbase missing: %v", err)
 		}
 
+		signFn, err := n.signFn(eb)
+		if err != nil {
+			return err
+		}
 		if poa, ok := n.engine.(*apoa.Apoa); ok {
-			wallet, err := n.accman.Find(accounts.Account{Address: eb})
-			if wallet == nil || err != nil {
-				log.Error("Etherbase account unavailable locally", "err", err)
-				return fmt.Errorf("signer missing: %v", err)
-			}
-			poa.Authorize(eb, wallet.SignData)
+			poa.Authorize(eb, signFn)
 		} else if pos, ok := n.engine.(*apos.APos); ok {
-			wallet, err := n.accman.Find(accounts.Account{Address: eb})
-			if wallet == nil || err != nil {
-				log.Error("Etherbase account unavailable locally", "err", err)
-				return fmt.Errorf("signer missing: %v", err)
-			}
-			pos.Authorize(eb, wallet.SignData)
+			pos.Authorize(eb, signFn)
 		}
 
 		n.miner.SetCoinbase(eb)
@@ -385,6 +455,8 @@ func (n *Node) Start() error {
 
 	go n.txsBroadcastLoop()
 	go n.txsMessageFetcherLoop()
+	go n.sampleTableStats()
+	go n.verifyIdle()
 
 	n.depositContract.Start()
 
@@ -408,6 +480,30 @@ func (n *Node) Start() error {
 	return nil
 }
 
+// SetExternalSigner makes the node seal blocks with keys of a remote signer
+// instead of the local keystore. It must be called before Start.
+func (n *Node) SetExternalSigner(signer *external.ExternalSigner) {
+	n.extSigner = signer
+}
+
+// signFn returns the function the sealers sign with as etherbase: the
+// external signer if one is set, the local wallet of etherbase otherwise.
+func (n *Node) signFn(eb types.Address) (func(accounts.Account, string, []byte) ([]byte, error), error) {
+	if n.extSigner != nil {
+		if !n.extSigner.Contains(accounts.Account{Address: eb}) {
+			log.Error("Etherbase account unavailable on external signer", "signer", n.extSigner.URL())
+			return nil, fmt.Errorf("signer missing: %s not on %s", eb, n.extSigner.URL())
+		}
+		return n.extSigner.SignData, nil
+	}
+	wallet, err := n.accman.Find(accounts.Account{Address: eb})
+	if wallet == nil || err != nil {
+		log.Error("Etherbase account unavailable locally", "err", err)
+		return nil, fmt.Errorf("signer missing: %v", err)
+	}
+	return wallet.SignData, nil
+}
+
 // ProtocolHandshake is part of the node's protocol handshake process,
 // where the node checks the consistency of the blockchain with the peer and updates its list of connected peers.
 func (n *Node) ProtocolHandshake(peer common.IPeer, genesisHash types.Hash, currentHeight *uint256.Int) (common.Peer, bool) {
@@ -630,6 +726,25 @@ func (n *Node) Close() {
 		n.cancel()
 		close(n.shutDown)
 		n.db.Close()
+		if n.extSigner != nil {
+			n.extSigner.Close()
+		}
+		if n.lease != nil {
+			if err := n.lease.Release(); err != nil {
+				log.Warn("Failed to release datadir lease", "err", err)
+			}
+		}
+	}
+}
+
+// requestShutdown - stops the process the way an operator would, so the usual graceful shutdown runs
+func requestShutdown() {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		log.Error("Failed to request shutdown", "err", err)
 	}
 }
 
@@ -760,8 +875,25 @@ func OpenDatabase(cfg *conf.Config, logger log2.Logger, name string) (kv.RwDB, e
 		modules.AmcInit()
 		kv.ChaindataTablesCfg = modules.AmcTableCfg
 
-		opts = opts.MapSize(8 * datasize.TB)
-		return opts.Open()
+		mapSize := amcmdbx.PlatformMapSize(8 * datasize.TB)
+		if err := amcmdbx.CheckMapSize(dbPath, mapSize); err != nil {
+			return nil, err
+		}
+		opts = opts.MapSize(mapSize)
+		db, err := opts.Open()
+		if err != nil {
+			return nil, err
+		}
+		// destructive migrations run if approved by flag, or confirmed by the operator at a terminal
+		approval := migrations.Approval{Approved: cfg.DatabaseCfg.MigrationsApprove, Out: os.Stdout, DataDir: cfg.NodeCfg.DataDir}
+		if terminal.IsTerminal(int(os.Stdin.Fd())) {
+			approval.Confirm = migrations.TerminalConfirm(os.Stdin, os.Stdout)
+		}
+		if err := amckv.EnforceSchemaVersion(context.Background(), amcDB{db}, migrations.Upgrade(approval)); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
 	}
 	chainKv, err = openFunc(false)
 	if err != nil {
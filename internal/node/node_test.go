@@ -0,0 +1,79 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/amazechain/amc/conf"
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/migrations"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// update - opens the db of datadir as the node does and runs f on it
+func update(t *testing.T, cfg *conf.Config, f func(tx kv.RwTx) error) {
+	t.Helper()
+	db, err := OpenDatabase(cfg, nil, amckv.ChainDB.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenDatabaseSchemaVersion(t *testing.T) {
+	name := amckv.ChainDB.String()
+
+	// fresh db gets the current version, a db of a newer binary is refused
+	cfg := &conf.Config{NodeCfg: conf.NodeConfig{DataDir: t.TempDir()}}
+	update(t, cfg, func(tx kv.RwTx) error {
+		v, ok, err := amckv.ReadSchemaVersion(tx)
+		if err == nil && (!ok || v != amckv.CurrentSchemaVersion) {
+			t.Fatalf("fresh db: version %s, ok %v", v, ok)
+		}
+		newer := amckv.SchemaVersion{Major: amckv.CurrentSchemaVersion.Major + 1}
+		return tx.Put(amckv.DatabaseInfo, amckv.DBSchemaVersionKey, newer.Bytes())
+	})
+	if _, err := OpenDatabase(cfg, nil, name); !errors.Is(err, amckv.ErrDowngradeNotAllowed) {
+		t.Fatalf("newer db: err %v, want %v", err, amckv.ErrDowngradeNotAllowed)
+	}
+
+	// an unversioned db holding blocks needs migrations, the destructive ones run once approved
+	cfg = &conf.Config{NodeCfg: conf.NodeConfig{DataDir: t.TempDir()}}
+	update(t, cfg, func(tx kv.RwTx) error {
+		if err := tx.Put(amckv.HeaderCanonical, amckv.EncodeBlockNum(0), make([]byte, amckv.HashLen)); err != nil {
+			return err
+		}
+		return tx.Delete(amckv.DatabaseInfo, amckv.DBSchemaVersionKey)
+	})
+	if _, err := OpenDatabase(cfg, nil, name); !errors.Is(err, migrations.ErrNotApproved) {
+		t.Fatalf("legacy db: err %v, want %v", err, migrations.ErrNotApproved)
+	}
+	cfg.DatabaseCfg.MigrationsApprove = true
+	update(t, cfg, func(tx kv.RwTx) error {
+		v, ok, err := amckv.ReadSchemaVersion(tx)
+		if err == nil && (!ok || v != amckv.CurrentSchemaVersion) {
+			t.Fatalf("approved migrations: version %s, ok %v", v, ok)
+		}
+		return err
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"context"
+	"time"
+
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/torquem-ch/mdbx-go/mdbx"
+)
+
+// tableStatsInterval - how often the node stores table statistics into DatabaseInfo
+const tableStatsInterval = time.Hour
+
+// bucketStater - methods of MDBX transactions behind amckv.TableStater
+type bucketStater interface {
+	ExistsBucket(table string) (bool, error)
+	BucketStat(table string) (*mdbx.Stat, error)
+}
+
+type tableStater struct {
+	tx       bucketStater
+	pageSize uint64
+}
+
+func (s tableStater) ExistsBucket(table string) (bool, error) { return s.tx.ExistsBucket(table) }
+
+func (s tableStater) TableStat(table string) (amckv.TableStat, error) {
+	st, err := s.tx.BucketStat(table)
+	if err != nil {
+		return amckv.TableStat{}, err
+	}
+	return amckv.TableStat{
+		Entries:       st.Entries,
+		BranchPages:   st.BranchPages,
+		LeafPages:     st.LeafPages,
+		OverflowPages: st.OverflowPages,
+		Size:          (st.LeafPages + st.BranchPages + st.OverflowPages) * s.pageSize,
+	}, nil
+}
+
+// TableStats - statistics of the node tables (modules.AmcTables) existing in db
+func TableStats(ctx context.Context, db kv.RoDB) (stats map[string]amckv.TableStat, err error) {
+	err = db.View(ctx, func(tx kv.Tx) error {
+		bs, ok := tx.(bucketStater)
+		if !ok {
+			return amckv.ErrNotSupported
+		}
+		stats, err = amckv.StatOf(ctx, tableStater{tx: bs, pageSize: db.PageSize()}, modules.AmcTables)
+		return err
+	})
+	return stats, err
+}
+
+// sampleTableStats - stores table statistics every tableStatsInterval, so database growth can be graphed
+func (n *Node) sampleTableStats() {
+	ticker := time.NewTicker(tableStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats, err := TableStats(n.ctx, n.db)
+			if err == nil {
+				err = n.db.Update(n.ctx, func(tx kv.RwTx) error {
+					return amckv.WriteTableStatsSample(tx, now, stats)
+				})
+			}
+			if err != nil {
+				log.Warn("Failed to sample table statistics", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package historyv2 reads state as of a past block from history indices and changesets,
+// see the AccountsHistory comment in internal/kv/tables.go for the algorithm.
+package historyv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+)
+
+// HistoryReader - value of an account or storage slot at the beginning of a block, i.e. after all
+// changes of the previous blocks. Reuses its decoding buffer, so it is not safe for concurrent use.
+type HistoryReader struct {
+	shard *roaring64.Bitmap
+}
+
+func NewHistoryReader() *HistoryReader {
+	return &HistoryReader{shard: roaring64.New()}
+}
+
+// ReadAccountAsOf - account of addr (encoded for storage) at the beginning of blockNum, nil if it did not exist
+func (r *HistoryReader) ReadAccountAsOf(tx kv.Tx, addr []byte, blockNum uint64) ([]byte, error) {
+	changeBlock, ok, err := r.firstChange(tx, kv.AccountsHistory, addr, blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return tx.GetOne(kv.PlainState, addr)
+	}
+	v, ok, err := changeSetValue(tx, kv.AccountChangeSet, kv.EncodeBlockNum(changeBlock), addr)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("AccountsHistory of %x references block %d, but AccountChangeSet has no record", addr, changeBlock)
+	}
+	return v, nil
+}
+
+// ReadStorageAsOf - value of storage slot loc of incarnation inc of addr at the beginning of blockNum
+func (r *HistoryReader) ReadStorageAsOf(tx kv.Tx, addr []byte, inc uint64, loc []byte, blockNum uint64) ([]byte, error) {
+	changeBlock, ok, err := r.firstChange(tx, kv.StorageHistory, append(append(make([]byte, 0, kv.AddrLen+kv.HashLen), addr...), loc...), blockNum)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		csKey := append(kv.EncodeBlockNum(changeBlock), addr...)
+		csKey = binary.BigEndian.AppendUint64(csKey, inc)
+		v, found, err := changeSetValue(tx, kv.StorageChangeSet, csKey, loc)
+		if err != nil || found {
+			return v, err
+		}
+		// StorageHistory has no incarnation: the change may be of a later incarnation, created after inc
+		// self-destructed. Self-destruct records no storage changes, so slots of inc kept their value till then.
+		destructed, err := selfDestructed(tx, addr, inc)
+		if err != nil {
+			return nil, err
+		}
+		if !destructed {
+			return nil, fmt.Errorf("StorageHistory of %x %x references block %d, but StorageChangeSet has no record of incarnation %d", addr, loc, changeBlock, inc)
+		}
+	}
+	return tx.GetOne(kv.PlainState, kv.StorageKey(addr, inc, loc))
+}
+
+// firstChange - smallest block >= blockNum in history index of key. ok is false when key is not changed
+// since blockNum: either there is no shard at all, or the seek lands on the last (0xFF) shard whose
+// blocks are all below blockNum - then the current value in PlainState is the answer.
+func (r *HistoryReader) firstChange(tx kv.Tx, table string, key []byte, blockNum uint64) (changeBlock uint64, ok bool, err error) {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return 0, false, err
+	}
+	defer c.Close()
+
+	k, v, err := c.Seek(binary.BigEndian.AppendUint64(append(make([]byte, 0, len(key)+8), key...), blockNum))
+	if err != nil {
+		return 0, false, err
+	}
+	if len(k) != len(key)+8 || !bytes.HasPrefix(k, key) {
+		return 0, false, nil
+	}
+	if err := bitmapdb.DecodeShard64(r.shard, v); err != nil {
+		return 0, false, fmt.Errorf("%s shard %x: %w", table, k, err)
+	}
+	it := r.shard.Iterator()
+	it.AdvanceIfNeeded(blockNum)
+	if !it.HasNext() {
+		return 0, false, nil
+	}
+	return it.Next(), true, nil
+}
+
+// changeSetValue - value of the changeset record of key with the given sub-key prefix, minus the prefix.
+// Empty values - the account or slot did not exist before the change - are returned as nil.
+func changeSetValue(tx kv.Tx, table string, key, subKey []byte) ([]byte, bool, error) {
+	c, err := tx.CursorDupSort(table)
+	if err != nil {
+		return nil, false, err
+	}
+	defer c.Close()
+
+	v, err := c.SeekBothRange(key, subKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if !bytes.HasPrefix(v, subKey) {
+		return nil, false, nil
+	}
+	if len(v) == len(subKey) {
+		return nil, true, nil
+	}
+	return v[len(subKey):], true, nil
+}
+
+// selfDestructed - whether incarnation inc of addr was deleted, IncarnationMap keeps the incarnation of the last deletion
+func selfDestructed(tx kv.Tx, addr []byte, inc uint64) (bool, error) {
+	v, err := tx.GetOne(kv.IncarnationMap, addr)
+	if err != nil {
+		return false, err
+	}
+	if len(v) != kv.IncarnationLen {
+		return false, nil
+	}
+	return binary.BigEndian.Uint64(v) >= inc, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package historyv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+)
+
+var (
+	addrA = bytes.Repeat([]byte{0xaa}, kv.AddrLen)
+	addrB = bytes.Repeat([]byte{0xbb}, kv.AddrLen)
+	locL  = bytes.Repeat([]byte{0x01}, kv.HashLen)
+)
+
+func put(tb testing.TB, tx kv.RwTx, table string, k, v []byte) {
+	tb.Helper()
+	if err := tx.Put(table, k, v); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func putShard(tb testing.TB, tx kv.RwTx, table string, key []byte, shard uint64, blocks ...uint64) {
+	tb.Helper()
+	var buf bytes.Buffer
+	if _, err := roaring64.BitmapOf(blocks...).WriteTo(&buf); err != nil {
+		tb.Fatal(err)
+	}
+	put(tb, tx, table, binary.BigEndian.AppendUint64(append([]byte{}, key...), shard), buf.Bytes())
+}
+
+func TestReadAccountAsOf(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	// A is created in block 2 and changed in blocks 5 and 9, B never changes
+	put(t, tx, kv.AccountChangeSet, kv.EncodeBlockNum(2), addrA)
+	put(t, tx, kv.AccountChangeSet, kv.EncodeBlockNum(5), append(append([]byte{}, addrA...), "v1"...))
+	put(t, tx, kv.AccountChangeSet, kv.EncodeBlockNum(9), append(append([]byte{}, addrA...), "v2"...))
+	putShard(t, tx, kv.AccountsHistory, addrA, 5, 2, 5)
+	putShard(t, tx, kv.AccountsHistory, addrA, ^uint64(0), 9)
+	put(t, tx, kv.PlainState, addrA, []byte("v3"))
+	put(t, tx, kv.PlainState, addrB, []byte("b"))
+
+	r := NewHistoryReader()
+	for _, tc := range []struct {
+		addr  []byte
+		block uint64
+		want  string
+	}{
+		{addrA, 0, ""}, {addrA, 2, ""},
+		{addrA, 3, "v1"}, {addrA, 5, "v1"},
+		{addrA, 6, "v2"}, {addrA, 9, "v2"},
+		{addrA, 10, "v3"}, {addrA, 100, "v3"}, // last shard has nothing >= block: PlainState
+		{addrB, 0, "b"}, {addrB, 100, "b"},
+	} {
+		v, err := r.ReadAccountAsOf(tx, tc.addr, tc.block)
+		if err != nil {
+			t.Fatalf("%x at %d: %v", tc.addr[:1], tc.block, err)
+		}
+		if string(v) != tc.want {
+			t.Fatalf("%x at %d: have %q, want %q", tc.addr[:1], tc.block, v, tc.want)
+		}
+	}
+}
+
+func storageChange(tb testing.TB, tx kv.RwTx, block, inc uint64, v string) {
+	tb.Helper()
+	k := binary.BigEndian.AppendUint64(append(kv.EncodeBlockNum(block), addrA...), inc)
+	put(tb, tx, kv.StorageChangeSet, k, append(append([]byte{}, locL...), v...))
+}
+
+func TestReadStorageAsOfSelfDestruct(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	// incarnation 1 sets L in block 3 and self-destructs in block 6, which records no storage changes.
+	// Incarnation 2 is created in block 7 and sets L in block 8.
+	storageChange(t, tx, 3, 1, "")
+	storageChange(t, tx, 8, 2, "")
+	putShard(t, tx, kv.StorageHistory, append(append([]byte{}, addrA...), locL...), ^uint64(0), 3, 8)
+	put(t, tx, kv.PlainState, kv.StorageKey(addrA, 1, locL), []byte("one"))
+	put(t, tx, kv.PlainState, kv.StorageKey(addrA, 2, locL), []byte("two"))
+
+	r := NewHistoryReader()
+	// without IncarnationMap the change of block 8 can't belong to incarnation 1
+	if _, err := r.ReadStorageAsOf(tx, addrA, 1, locL, 4); err == nil {
+		t.Fatal("inconsistent history is not reported")
+	}
+	put(t, tx, kv.IncarnationMap, addrA, binary.BigEndian.AppendUint64(nil, 1))
+
+	for _, tc := range []struct {
+		inc, block uint64
+		want       string
+	}{
+		{1, 1, ""}, {1, 3, ""},
+		{1, 4, "one"}, {1, 7, "one"}, // change of block 8 is of incarnation 2
+		{2, 7, ""}, {2, 8, ""},
+		{2, 9, "two"},
+	} {
+		v, err := r.ReadStorageAsOf(tx, addrA, tc.inc, locL, tc.block)
+		if err != nil {
+			t.Fatalf("incarnation %d at %d: %v", tc.inc, tc.block, err)
+		}
+		if string(v) != tc.want {
+			t.Fatalf("incarnation %d at %d: have %q, want %q", tc.inc, tc.block, v, tc.want)
+		}
+	}
+}
+
+const (
+	benchBlocks   = 100_000
+	benchAccounts = 100
+)
+
+func benchAddr(i uint64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, kv.AddrLen-8), i)
+}
+
+// writeBenchHistory - every block changes one account, round-robin, history is sharded as the indexer does
+func writeBenchHistory(b *testing.B, tx kv.RwTx) {
+	b.Helper()
+	index := make([]*roaring64.Bitmap, benchAccounts)
+	for i := range index {
+		index[i] = roaring64.New()
+	}
+	for block := uint64(0); block < benchBlocks; block++ {
+		i := block % benchAccounts
+		put(b, tx, kv.AccountChangeSet, kv.EncodeBlockNum(block), binary.BigEndian.AppendUint64(benchAddr(i), block))
+		index[i].Add(block)
+	}
+	for i, bm := range index {
+		if err := bitmapdb.WalkChunkWithKeys64(benchAddr(uint64(i)), bm, bitmapdb.ChunkLimit, func(k []byte, chunk *roaring64.Bitmap) error {
+			var buf bytes.Buffer
+			if _, err := chunk.WriteTo(&buf); err != nil {
+				return err
+			}
+			return tx.Put(kv.AccountsHistory, k, buf.Bytes())
+		}); err != nil {
+			b.Fatal(err)
+		}
+		put(b, tx, kv.PlainState, benchAddr(uint64(i)), []byte("head"))
+	}
+}
+
+func BenchmarkReadAccountAsOf(b *testing.B) {
+	_, tx := memdb.NewTestTx(b)
+	writeBenchHistory(b, tx)
+	r := NewHistoryReader()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := uint64(n) % benchAccounts
+		block := uint64(n*7919) % benchBlocks
+		if _, err := r.ReadAccountAsOf(tx, benchAddr(i), block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadAccountAsOfHead(b *testing.B) {
+	_, tx := memdb.NewTestTx(b)
+	writeBenchHistory(b, tx)
+	r := NewHistoryReader()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := r.ReadAccountAsOf(tx, benchAddr(uint64(n)%benchAccounts), benchBlocks); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package historyv2
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/walk"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// accountIncarnationField - field number of Incarnation in the state.Account protobuf accounts are encoded with
+const accountIncarnationField = 6
+
+// UnwindState - rolls PlainState back from block `from` to block `to`: applies the changesets of blocks
+// from..to+1 in reverse, restores IncarnationMap, then deletes the changesets above `to` and their blocks
+// from AccountsHistory/StorageHistory.
+//
+// Applying changesets in reverse leaves every key at the "before" value of its first change above `to`,
+// so only that one is written. Empty "before" values delete the key.
+func UnwindState(tx kv.RwTx, from, to uint64) error {
+	if to >= from {
+		return nil
+	}
+	accounts, err := firstChanges(tx, kv.AccountChangeSet, from, to, true, func(k, v []byte) ([]byte, []byte) {
+		return v[:kv.AddrLen], v[kv.AddrLen:]
+	})
+	if err != nil {
+		return err
+	}
+	storage, err := firstChanges(tx, kv.StorageChangeSet, from, to, false, func(k, v []byte) ([]byte, []byte) {
+		return kv.StorageKey(k[kv.BlockNumLen:kv.BlockNumLen+kv.AddrLen], binary.BigEndian.Uint64(k[kv.BlockNumLen+kv.AddrLen:]), v[:kv.HashLen]), v[kv.HashLen:]
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range accounts {
+		if err := restoreIncarnation(tx, c); err != nil {
+			return err
+		}
+		if err := restore(tx, c.key, c.before); err != nil {
+			return err
+		}
+		if err := bitmapdb.TruncateRange64(tx, kv.AccountsHistory, c.key, to+1); err != nil {
+			return err
+		}
+	}
+	for _, c := range storage {
+		if err := restore(tx, c.key, c.before); err != nil {
+			return err
+		}
+		// StorageHistory is keyed without incarnation, truncating it twice is harmless
+		historyKey := append(append(make([]byte, 0, kv.AddrLen+kv.HashLen), c.key[:kv.AddrLen]...), c.key[kv.AddrLen+kv.IncarnationLen:]...)
+		if err := bitmapdb.TruncateRange64(tx, kv.StorageHistory, historyKey, to+1); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{kv.AccountChangeSet, kv.StorageChangeSet} {
+		if err := truncateChangeSet(tx, table, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyChange - first change of key above the unwind point, and the incarnations of its later values
+type keyChange struct {
+	key          []byte
+	before       []byte
+	incarnations []uint64
+}
+
+// firstChanges - changes of blocks from..to+1 in changeset table, one per key, in order of first change.
+// split returns the PlainState key and the "before" value of a changeset entry.
+func firstChanges(tx kv.Tx, table string, from, to uint64, accounts bool, split func(k, v []byte) ([]byte, []byte)) ([]*keyChange, error) {
+	var changes []*keyChange
+	seen := make(map[string]*keyChange)
+	if err := walk.BlockRange(context.Background(), tx, table, to+1, math.MaxUint64, func(blockNum uint64, k, v []byte) error {
+		if blockNum > from {
+			return fmt.Errorf("changes of block %d above unwind start %d", blockNum, from)
+		}
+		key, before := split(k, v)
+		if c, ok := seen[string(key)]; ok {
+			// every later "before" value is a value the account had above `to`
+			if accounts {
+				c.incarnations = append(c.incarnations, accountIncarnation(before))
+			}
+			return nil
+		}
+		c := &keyChange{key: key, before: before}
+		seen[string(key)] = c
+		changes = append(changes, c)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("%s: %w", table, err)
+	}
+	return changes, nil
+}
+
+// restoreIncarnation - IncarnationMap keeps the incarnation of the last deletion of an account, and a new
+// contract gets the next incarnation. So if any incarnation the account had at `to` or got later was deleted
+// above `to`, the deletion known at `to` was of the one below the lowest of them.
+func restoreIncarnation(tx kv.RwTx, c *keyChange) error {
+	v, err := tx.GetOne(kv.PlainState, c.key)
+	if err != nil {
+		return err
+	}
+	lowest := accountIncarnation(c.before)
+	for _, inc := range append(c.incarnations, accountIncarnation(v)) {
+		if inc > 0 && (lowest == 0 || inc < lowest) {
+			lowest = inc
+		}
+	}
+	if lowest == 0 {
+		return nil
+	}
+	if destructed, err := selfDestructed(tx, c.key, lowest); err != nil || !destructed {
+		return err
+	}
+	if lowest == 1 {
+		return tx.Delete(kv.IncarnationMap, c.key)
+	}
+	return tx.Put(kv.IncarnationMap, c.key, binary.BigEndian.AppendUint64(nil, lowest-1))
+}
+
+func restore(tx kv.RwTx, key, before []byte) error {
+	if len(before) == 0 {
+		return tx.Delete(kv.PlainState, key)
+	}
+	return tx.Put(kv.PlainState, key, before)
+}
+
+// truncateChangeSet - deletes the changesets of blocks above `to`
+func truncateChangeSet(tx kv.RwTx, table string, to uint64) error {
+	c, err := tx.RwCursorDupSort(table)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// the walk goes on from the next key, as all values of this one are removed
+	return walk.Cursor(context.Background(), c, to+1, math.MaxUint64, func(uint64, []byte, []byte) error {
+		return c.DeleteCurrentDuplicates()
+	})
+}
+
+// accountIncarnation - incarnation of an account encoded for storage, 0 for an empty or undecodable value
+func accountIncarnation(enc []byte) uint64 {
+	for len(enc) > 0 {
+		num, typ, n := protowire.ConsumeTag(enc)
+		if n < 0 {
+			return 0
+		}
+		enc = enc[n:]
+		if num == accountIncarnationField && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(enc)
+			if n < 0 {
+				return 0
+			}
+			return v
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, enc); n < 0 {
+			return 0
+		}
+		enc = enc[n:]
+	}
+	return 0
+}
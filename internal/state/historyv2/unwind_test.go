@@ -0,0 +1,248 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package historyv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/memdb"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeAccount - account encoded for storage with the Nonce and Incarnation fields set
+func encodeAccount(nonce, inc uint64) []byte {
+	enc := protowire.AppendTag(nil, 1, protowire.VarintType)
+	enc = protowire.AppendVarint(enc, 1)
+	enc = protowire.AppendTag(enc, 2, protowire.VarintType)
+	enc = protowire.AppendVarint(enc, nonce)
+	enc = protowire.AppendTag(enc, 5, protowire.BytesType)
+	enc = protowire.AppendBytes(enc, bytes.Repeat([]byte{0xc0}, 32))
+	if inc > 0 {
+		enc = protowire.AppendTag(enc, accountIncarnationField, protowire.VarintType)
+		enc = protowire.AppendVarint(enc, inc)
+	}
+	return enc
+}
+
+func TestAccountIncarnation(t *testing.T) {
+	for _, inc := range []uint64{0, 1, 300} {
+		if have := accountIncarnation(encodeAccount(7, inc)); have != inc {
+			t.Fatalf("have %d, want %d", have, inc)
+		}
+	}
+	if have := accountIncarnation(nil); have != 0 {
+		t.Fatalf("empty account: %d", have)
+	}
+}
+
+// chainWriter - executes blocks the way the Execution stage writes them: PlainState, changesets with
+// the "before" values, history indices and IncarnationMap on self-destruct
+type chainWriter struct {
+	tb    testing.TB
+	tx    kv.RwTx
+	block uint64
+}
+
+func (w *chainWriter) index(table string, key []byte) {
+	bm, err := bitmapdb.Get64(w.tx, table, key, 0, bitmapdb.MaxUint64)
+	if err != nil {
+		w.tb.Fatal(err)
+	}
+	bm.Add(w.block)
+	// small shards, so truncation spans several of them
+	if err := bitmapdb.WalkChunkWithKeys64(key, bm, 40, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
+		var buf bytes.Buffer
+		if _, err := chunk.WriteTo(&buf); err != nil {
+			return err
+		}
+		return w.tx.Put(table, chunkKey, buf.Bytes())
+	}); err != nil {
+		w.tb.Fatal(err)
+	}
+}
+
+func (w *chainWriter) get(k []byte) []byte {
+	v, err := w.tx.GetOne(kv.PlainState, k)
+	if err != nil {
+		w.tb.Fatal(err)
+	}
+	return v
+}
+
+func (w *chainWriter) set(k, v []byte) {
+	var err error
+	if len(v) == 0 {
+		err = w.tx.Delete(kv.PlainState, k)
+	} else {
+		err = w.tx.Put(kv.PlainState, k, v)
+	}
+	if err != nil {
+		w.tb.Fatal(err)
+	}
+}
+
+func (w *chainWriter) account(addr, v []byte) {
+	put(w.tb, w.tx, kv.AccountChangeSet, kv.EncodeBlockNum(w.block), append(append([]byte{}, addr...), w.get(addr)...))
+	w.index(kv.AccountsHistory, addr)
+	w.set(addr, v)
+}
+
+func (w *chainWriter) storage(addr []byte, inc uint64, loc, v []byte) {
+	k := kv.StorageKey(addr, inc, loc)
+	put(w.tb, w.tx, kv.StorageChangeSet, kv.StorageChangeSetKey(w.block, addr, inc), append(append([]byte{}, loc...), w.get(k)...))
+	w.index(kv.StorageHistory, append(append([]byte{}, addr...), loc...))
+	w.set(k, v)
+}
+
+// selfDestruct - deletes the account, its storage stays in PlainState under the dead incarnation
+func (w *chainWriter) selfDestruct(addr []byte) {
+	put(w.tb, w.tx, kv.IncarnationMap, addr, binary.BigEndian.AppendUint64(nil, accountIncarnation(w.get(addr))))
+	w.account(addr, nil)
+}
+
+func dump(tb testing.TB, tx kv.Tx, tables ...string) map[string]string {
+	tb.Helper()
+	res := make(map[string]string)
+	for _, table := range tables {
+		if err := tx.ForEach(table, nil, func(k, v []byte) error {
+			res[fmt.Sprintf("%s %x", table, k)] = fmt.Sprintf("%x", v)
+			return nil
+		}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return res
+}
+
+func TestUnwindState(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	w := &chainWriter{tb: t, tx: tx}
+	rnd := rand.New(rand.NewSource(1))
+
+	var addrs, locs [][]byte
+	for i := 0; i < 8; i++ {
+		addrs = append(addrs, bytes.Repeat([]byte{byte(0x10 + i)}, kv.AddrLen))
+		locs = append(locs, bytes.Repeat([]byte{byte(i + 1)}, kv.HashLen))
+	}
+	// C lives at the unwind point and is destroyed and recreated above it,
+	// D is destroyed below the unwind point and recreated above it
+	addrC, addrD := addrs[0], addrs[1]
+	incs := map[string]uint64{string(addrC): 1, string(addrD): 1}
+	var nonce uint64
+	randomBlock := func() {
+		nonce++
+		for _, addr := range addrs[2:] {
+			if rnd.Intn(3) == 0 {
+				v := encodeAccount(nonce, 0)
+				if rnd.Intn(5) == 0 {
+					v = nil
+				}
+				w.account(addr, v)
+			}
+		}
+		for _, addr := range [][]byte{addrC, addrD} {
+			if inc := accountIncarnation(w.get(addr)); inc > 0 {
+				for _, loc := range locs {
+					if rnd.Intn(4) == 0 {
+						w.storage(addr, inc, loc, []byte(fmt.Sprintf("%d/%d", inc, nonce)))
+					}
+				}
+			}
+		}
+	}
+
+	const unwindTo, head = 20, 30
+	var snapshot map[string]string
+	for w.block = 1; w.block <= head; w.block++ {
+		switch w.block {
+		case 3:
+			w.account(addrC, encodeAccount(0, incs[string(addrC)]))
+			w.account(addrD, encodeAccount(0, incs[string(addrD)]))
+		case 5:
+			w.selfDestruct(addrD)
+		case 22, 28:
+			w.selfDestruct(addrC)
+		case 24:
+			incs[string(addrD)]++
+			w.account(addrD, encodeAccount(0, incs[string(addrD)]))
+		case 25:
+			incs[string(addrC)]++
+			w.account(addrC, encodeAccount(0, incs[string(addrC)]))
+		default:
+			randomBlock()
+		}
+		if w.block == unwindTo {
+			snapshot = dump(t, tx, kv.PlainState, kv.IncarnationMap)
+		}
+	}
+
+	if err := UnwindState(tx, head, unwindTo); err != nil {
+		t.Fatal(err)
+	}
+	if have := dump(t, tx, kv.PlainState, kv.IncarnationMap); !reflect.DeepEqual(have, snapshot) {
+		for k, v := range have {
+			if snapshot[k] != v {
+				t.Errorf("%s: have %s, want %s", k, v, snapshot[k])
+			}
+		}
+		for k, v := range snapshot {
+			if _, ok := have[k]; !ok {
+				t.Errorf("%s: missing, want %s", k, v)
+			}
+		}
+		t.FailNow()
+	}
+
+	for _, table := range []string{kv.AccountChangeSet, kv.StorageChangeSet} {
+		if err := tx.ForEach(table, kv.EncodeBlockNum(unwindTo+1), func(k, v []byte) error {
+			return fmt.Errorf("%s keeps changes of block %d", table, binary.BigEndian.Uint64(k))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range []string{kv.AccountsHistory, kv.StorageHistory} {
+		if err := tx.ForEach(table, nil, func(k, v []byte) error {
+			bm := roaring64.New()
+			if err := bitmapdb.DecodeShard64(bm, v); err != nil {
+				return err
+			}
+			if bm.IsEmpty() || bm.Maximum() > unwindTo {
+				return fmt.Errorf("%s shard %x holds %v", table, k, bm.ToArray())
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// history below the unwind point is intact
+	r := NewHistoryReader()
+	if v, err := r.ReadAccountAsOf(tx, addrD, 4); err != nil || !bytes.Equal(v, encodeAccount(0, 1)) {
+		t.Fatalf("D at 4: %x, %v", v, err)
+	}
+	if err := UnwindState(tx, head, unwindTo); err != nil {
+		t.Fatalf("repeated unwind: %v", err)
+	}
+}
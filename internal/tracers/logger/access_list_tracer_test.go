@@ -0,0 +1,142 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"context"
+	"testing"
+
+	common "github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/vm"
+	"github.com/amazechain/amc/internal/vm/evmtypes"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/state"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+// accessListLogger adapts AccessListTracer to vm.EVMLogger, the tracer only
+// needs CaptureState.
+type accessListLogger struct {
+	*AccessListTracer
+}
+
+func (accessListLogger) CaptureStart(vm.VMInterface, common.Address, common.Address, bool, []byte, uint64, *uint256.Int) {
+}
+
+func (accessListLogger) CaptureEnter(vm.OpCode, common.Address, common.Address, []byte, uint64, *uint256.Int) {
+}
+
+// TestAccessRecorderReplay replays a call with an AccessListTracer over a
+// state.AccessRecorder and checks that the recorder saw every account and
+// slot the tracer did, and only the sender besides.
+func TestAccessRecorderReplay(t *testing.T) {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(t.TempDir()).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var (
+		origin   = common.Address{0xaa}
+		contract = common.Address{0xc0}
+		rich     = common.Address{0xbb}
+		library  = common.Address{0xcc}
+	)
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x02, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH20),
+	}
+	code = append(code, rich[:]...)
+	code = append(code, byte(vm.BALANCE), byte(vm.POP), byte(vm.PUSH20))
+	code = append(code, library[:]...)
+	code = append(code, byte(vm.EXTCODESIZE), byte(vm.POP),
+		byte(vm.PUSH1), 0x07, byte(vm.PUSH1), 0x03, byte(vm.SSTORE), byte(vm.STOP))
+
+	// the pre-state, committed so the replay reads it through the recorder
+	pre := state.New(state.NewPlainStateReader(tx))
+	pre.CreateAccount(contract, true)
+	pre.SetCode(contract, code)
+	slot := common.Hash{31: 0x01}
+	pre.SetState(contract, &slot, *uint256.NewInt(5))
+	pre.AddBalance(rich, uint256.NewInt(1e9))
+	pre.AddBalance(origin, uint256.NewInt(1e9))
+	pre.SetCode(library, []byte{byte(vm.STOP)})
+	if err := pre.CommitBlock(params.TestRules, state.NewPlainStateWriterNoHistory(tx)); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := state.NewAccessRecorder(state.NewPlainStateReader(tx))
+	tracer := NewAccessListTracer(nil, origin, contract, nil)
+	blockCtx := evmtypes.BlockContext{
+		CanTransfer: func(db evmtypes.IntraBlockState, addr common.Address, amount *uint256.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db evmtypes.IntraBlockState, sender, recipient common.Address, amount *uint256.Int, bailout bool) {
+			db.SubBalance(sender, amount)
+			db.AddBalance(recipient, amount)
+		},
+		GetHash: func(uint64) common.Hash { return common.Hash{} },
+	}
+	evm := vm.NewEVM(blockCtx, evmtypes.TxContext{Origin: origin, GasPrice: uint256.NewInt(0)}, state.New(recorder), params.TestChainConfig, vm.Config{Debug: true, Tracer: accessListLogger{tracer}})
+	if _, _, err := evm.Call(vm.AccountRef(origin), contract, nil, 1_000_000, uint256.NewInt(0), false); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := make(map[common.Address]map[common.Hash]bool)
+	for _, tuple := range recorder.AccessList() {
+		slots := make(map[common.Hash]bool)
+		for _, key := range tuple.StorageKeys {
+			slots[key] = true
+		}
+		recorded[tuple.Address] = slots
+	}
+	traced := tracer.AccessList()
+	if len(traced) != 3 {
+		t.Fatalf("tracer saw %d accounts, want 3: %v", len(traced), traced)
+	}
+	for _, tuple := range traced {
+		slots, ok := recorded[tuple.Address]
+		if !ok {
+			t.Fatalf("account %x traced but not recorded", tuple.Address)
+		}
+		if len(slots) != len(tuple.StorageKeys) {
+			t.Fatalf("account %x: recorded %d slots, traced %d", tuple.Address, len(slots), len(tuple.StorageKeys))
+		}
+		for _, key := range tuple.StorageKeys {
+			if !slots[key] {
+				t.Fatalf("account %x: slot %x traced but not recorded", tuple.Address, key)
+			}
+		}
+		delete(recorded, tuple.Address)
+	}
+	delete(recorded, origin)
+	if len(recorded) != 0 {
+		t.Fatalf("recorded accounts the tracer did not see: %v", recorded)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/params"
+)
+
+// CheckTxValidity checks the validity window extension of a transaction against the
+// header of the block including it. Before the TxValidityBlock fork the extension
+// is not allowed at all.
+func CheckTxValidity(config *params.ChainConfig, header *block.Header, tx *transaction.Transaction) error {
+	v := tx.Validity()
+	if v == nil {
+		return nil
+	}
+	number := header.Number.Uint64()
+	if !config.IsTxValidity(number) {
+		return fmt.Errorf("%w: validity window of tx %x before fork", ErrTxTypeNotSupported, tx.Hash())
+	}
+	if v.Expired(number, header.Time) {
+		return fmt.Errorf("%w: tx %x valid until block %d time %d, included in block %d time %d",
+			ErrTxExpired, tx.Hash(), v.ValidUntilBlock, v.ValidUntilTime, number, header.Time)
+	}
+	return nil
+}
+
+// ValidateTxValidity runs CheckTxValidity for all transactions of a block.
+func ValidateTxValidity(config *params.ChainConfig, header *block.Header, txs []*transaction.Transaction) error {
+	for _, tx := range txs {
+		if err := CheckTxValidity(config, header, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
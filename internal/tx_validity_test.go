@@ -0,0 +1,71 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package internal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+func validityHeader(number, time uint64) *block.Header {
+	return &block.Header{Number: uint256.NewInt(number), Time: time}
+}
+
+func TestCheckTxValidity(t *testing.T) {
+	config := &params.ChainConfig{TxValidityBlock: big.NewInt(5)}
+	var addr types.Address
+	plain := transaction.NewTransaction(0, addr, &addr, uint256.NewInt(1), 21000, uint256.NewInt(1), nil)
+	tx := plain.WithValidity(&transaction.Validity{ValidUntilBlock: 11, ValidUntilTime: 1000})
+
+	for _, tc := range []struct {
+		name         string
+		tx           *transaction.Transaction
+		number, time uint64
+		want         error
+	}{
+		{"plain before fork", plain, 1, 2000, nil},
+		{"window before fork", tx, 4, 10, ErrTxTypeNotSupported},
+		{"just before expiry", tx, 11, 1000, nil},
+		{"block just after expiry", tx, 12, 1000, ErrTxExpired},
+		{"time just after expiry", tx, 11, 1001, ErrTxExpired},
+	} {
+		err := CheckTxValidity(config, validityHeader(tc.number, tc.time), tc.tx)
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s: have %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// The tx is included at block 10 on the old branch. A reorg replaces blocks 10 and 11,
+	// and the new branch includes it at block 12, past its window.
+	oldBranch := []*transaction.Transaction{plain, tx}
+	if err := ValidateTxValidity(config, validityHeader(10, 900), oldBranch); err != nil {
+		t.Fatalf("old branch block rejected: %v", err)
+	}
+	if err := ValidateTxValidity(config, validityHeader(11, 950), nil); err != nil {
+		t.Fatalf("empty new branch block rejected: %v", err)
+	}
+	if err := ValidateTxValidity(config, validityHeader(12, 960), oldBranch); !errors.Is(err, ErrTxExpired) {
+		t.Fatalf("new branch block with expired tx: have %v, want %v", err, ErrTxExpired)
+	}
+}
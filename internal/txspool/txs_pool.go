@@ -39,6 +39,7 @@ import (
 	"github.com/amazechain/amc/common/transaction"
 	"github.com/amazechain/amc/common/txs_pool"
 	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/maintenance"
 	"github.com/amazechain/amc/log"
 	event "github.com/amazechain/amc/modules/event/v2"
 )
@@ -55,6 +56,10 @@ const (
 	// more expensive to propagate; larger transactions also take more resources
 	// to validate whether they fit into the pool or not.
 	txMaxSize = 4 * txSlotSize // 128KB
+
+	// expiryCheckInterval is the interval of evicting transactions whose validity
+	// window passed by time while no new block arrived.
+	expiryCheckInterval = 5 * time.Second
 )
 
 var (
@@ -131,6 +136,9 @@ type TxsPool struct {
 	eip2718  bool // Fork indicator whether we are using EIP-2718 type transactions.
 	eip1559  bool // Fork indicator whether we are using EIP-1559 type transactions.
 	shanghai bool // Fork indicator whether we are in the Shanghai stage.
+	validity bool // Fork indicator whether transactions may carry a validity window.
+
+	pendingNumber uint64 // Number of the next block, validity windows are checked against it
 
 	locals   *accountSet
 	pending  map[types.Address]*txsList
@@ -195,6 +203,9 @@ func NewTxsPool(ctx context.Context, bc common.IBlockChain) (txs_pool.ITxsPool,
 	pool.wg.Add(1)
 	go pool.blockChangeLoop()
 
+	pool.wg.Add(1)
+	go pool.expiryLoop()
+
 	//todo for test
 	//pool.wg.Add(1)
 	//go pool.ethFetchTxPoolLoop()
@@ -202,7 +213,7 @@ func NewTxsPool(ctx context.Context, bc common.IBlockChain) (txs_pool.ITxsPool,
 	//pool.wg.Add(1)
 	//go pool.ethImportTxPoolLoop()
 
-	pool.wg.Add(1)
+	//pool.wg.Add(1)
 	//go pool.ethTxPoolCheckLoop()
 
 	return pool, nil
@@ -265,6 +276,12 @@ func (pool *TxsPool) addTxs(txs []*transaction.Transaction, local, sync bool) []
 		errs = make([]error, len(txs))
 		news = make([]*transaction.Transaction, 0, len(txs))
 	)
+	if maintenance.Of(pool.bc).Enabled() {
+		for i := range errs {
+			errs[i] = maintenance.ErrMaintenance
+		}
+		return errs
+	}
 	for i, tx := range txs {
 		// If the transaction is known, pre-set the error slot
 		hash := tx.Hash()
@@ -589,6 +606,15 @@ func (pool *TxsPool) validateTx(tx *transaction.Transaction, local bool) error {
 	if tx.Gas() < intrGas {
 		return internal.ErrIntrinsicGas
 	}
+	// Reject transactions which can't be included in the next block anymore
+	if v := tx.Validity(); v != nil {
+		if !pool.validity {
+			return internal.ErrTxTypeNotSupported
+		}
+		if v.Expired(pool.pendingNumber, uint64(time.Now().Unix())) {
+			return internal.ErrTxExpired
+		}
+	}
 	return nil
 }
 
@@ -722,6 +748,8 @@ func (pool *TxsPool) reset(oldBlock, newBlock block.IBlock) {
 	pool.istanbul = pool.chainconfig.IsIstanbul(next.Uint64())
 	pool.eip2718 = pool.chainconfig.IsBerlin(next.Uint64())
 	pool.eip1559 = pool.chainconfig.IsLondon(next.Uint64())
+	pool.validity = pool.chainconfig.IsTxValidity(next.Uint64())
+	pool.pendingNumber = next.Uint64()
 }
 
 // promoteExecutables moves transactions that have become processable from the
@@ -980,6 +1008,7 @@ func (pool *TxsPool) runReorg(done chan struct{}, reset *txspoolResetRequest, di
 		// the flatten operation can be avoided.
 		promoteAddrs = dirtyAccounts.flatten()
 	}
+	var expired []*transaction.Transaction
 	pool.mu.Lock()
 	if reset != nil {
 		// Reset from the old head to the new, rescheduling any reorged transactions
@@ -1006,6 +1035,7 @@ func (pool *TxsPool) runReorg(done chan struct{}, reset *txspoolResetRequest, di
 	// because of another transaction (e.g. higher gas price).
 	if reset != nil {
 		pool.demoteUnexecutables()
+		expired = pool.evictExpired()
 
 		if reset.newBlock != nil && pool.chainconfig.IsLondon(reset.newBlock.Number64().Uint64()+1) {
 			pendingBaseFee, _ := uint256.FromBig(misc.CalcBaseFee(pool.chainconfig, reset.newBlock.Header().(*block.Header)))
@@ -1026,6 +1056,10 @@ func (pool *TxsPool) runReorg(done chan struct{}, reset *txspoolResetRequest, di
 	pool.changesSinceReorg = 0 // Reset change counter
 	pool.mu.Unlock()
 
+	if len(expired) > 0 {
+		event.GlobalEvent.Send(&common.DroppedTxsEvent{Txs: expired, Reason: common.DropReasonExpired})
+	}
+
 	// Notify subsystems for newly added transactions
 	for _, tx := range promoted {
 		addr := *tx.From()
@@ -1122,6 +1156,52 @@ func (pool *TxsPool) scheduleLoop() {
 	}
 }
 
+// evictExpired removes all transactions whose validity window passed for the next block.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxsPool) evictExpired() []*transaction.Transaction {
+	var (
+		expired []*transaction.Transaction
+		now     = uint64(time.Now().Unix())
+	)
+	pool.all.Range(func(hash types.Hash, tx *transaction.Transaction, local bool) bool {
+		if tx.Validity().Expired(pool.pendingNumber, now) {
+			expired = append(expired, tx)
+		}
+		return true
+	}, true, true)
+	for _, tx := range expired {
+		pool.removeTx(tx.Hash(), true)
+	}
+	if len(expired) > 0 {
+		log.Debug("Evicted expired transactions", "count", len(expired), "pending", pool.pendingNumber)
+	}
+	return expired
+}
+
+// expiryLoop evicts transactions whose validity window passed by time between blocks.
+// Block number windows are checked on every reset.
+func (pool *TxsPool) expiryLoop() {
+	defer pool.wg.Done()
+
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.ctx.Done():
+			return
+		case <-ticker.C:
+			pool.mu.Lock()
+			expired := pool.evictExpired()
+			pool.mu.Unlock()
+			if len(expired) > 0 {
+				event.GlobalEvent.Send(&common.DroppedTxsEvent{Txs: expired, Reason: common.DropReasonExpired})
+			}
+		}
+	}
+}
+
 // blockChangeLoop
 func (pool *TxsPool) blockChangeLoop() {
 	defer pool.wg.Done()
@@ -1152,6 +1232,9 @@ func (pool *TxsPool) blockChangeLoop() {
 func (pool *TxsPool) Stop() {
 	pool.cancel()
 	pool.wg.Wait()
+	pool.mu.Lock()
+	pool.releaseState()
+	pool.mu.Unlock()
 	log.Info("Transaction pool stopped")
 }
 
@@ -1277,14 +1360,7 @@ func (pool *TxsPool) Stats() (int, int, int, int) {
 }
 
 func (pool *TxsPool) ResetState(blockHash types.Hash) error {
-	if pool.currentState != nil {
-		reader := pool.currentState.GetStateReader()
-		if reader != nil {
-			if hreader, ok := reader.(*state.HistoryStateReader); ok {
-				hreader.Rollback()
-			}
-		}
-	}
+	pool.releaseState()
 
 	tx, err := pool.bc.DB().BeginRo(pool.ctx)
 	if nil != err {
@@ -1292,9 +1368,20 @@ func (pool *TxsPool) ResetState(blockHash types.Hash) error {
 	}
 	blockNr := rawdb.ReadHeaderNumber(tx, blockHash)
 	if nil == blockNr {
+		tx.Rollback()
 		return fmt.Errorf("invaild block hash")
 	}
 	stateReader := state.NewStateHistoryReader(tx, tx, *blockNr)
 	pool.currentState = state.New(stateReader)
 	return nil
 }
+
+// releaseState rolls back the read transaction held by the current state.
+func (pool *TxsPool) releaseState() {
+	if pool.currentState == nil {
+		return
+	}
+	if hreader, ok := pool.currentState.GetStateReader().(*state.HistoryStateReader); ok {
+		hreader.Rollback()
+	}
+}
@@ -2,11 +2,12 @@ package changeset
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv/walk"
 	"github.com/amazechain/amc/modules"
-	"github.com/amazechain/amc/modules/ethdb"
 	"math"
 	"reflect"
 
@@ -146,25 +147,17 @@ func AvailableStorageFrom(tx kv.Tx) (uint64, error) {
 
 // [from:to)
 func ForRange(db kv.Tx, bucket string, from, to uint64, walker func(blockN uint64, k, v []byte) error) error {
-	var blockN uint64
 	c, err := db.Cursor(bucket)
 	if err != nil {
 		return err
 	}
 	defer c.Close()
-	return ethdb.Walk(c, modules.EncodeBlockNumber(from), 0, func(k, v []byte) (bool, error) {
-		var err error
-		blockN, k, v, err = FromDBFormat(k, v)
+	return walk.Cursor(context.Background(), c, from, to, func(_ uint64, k, v []byte) error {
+		blockN, k, v, err := FromDBFormat(k, v)
 		if err != nil {
-			return false, err
-		}
-		if blockN >= to {
-			return false, nil
-		}
-		if err = walker(blockN, k, v); err != nil {
-			return false, err
+			return err
 		}
-		return true, nil
+		return walker(blockN, k, v)
 	})
 }
 func ForEach(db kv.Tx, bucket string, startkey []byte, walker func(blockN uint64, k, v []byte) error) error {
@@ -191,39 +184,18 @@ func ForPrefix(db kv.Tx, bucket string, startkey []byte, walker func(blockN uint
 }
 
 func Truncate(tx kv.RwTx, from uint64) error {
-	keyStart := modules.EncodeBlockNumber(from)
-
-	{
-		c, err := tx.RwCursorDupSort(modules.AccountChangeSet)
+	for _, bucket := range []string{modules.AccountChangeSet, modules.StorageChangeSet} {
+		c, err := tx.RwCursorDupSort(bucket)
 		if err != nil {
 			return err
 		}
-		defer c.Close()
-		for k, _, err := c.Seek(keyStart); k != nil; k, _, err = c.NextNoDup() {
-			if err != nil {
-				return err
-			}
-			err = c.DeleteCurrentDuplicates()
-			if err != nil {
-				return err
-			}
-		}
-	}
-	{
-		c, err := tx.RwCursorDupSort(modules.StorageChangeSet)
+		err = walk.Cursor(context.Background(), c, from, math.MaxUint64, func(uint64, []byte, []byte) error {
+			return c.DeleteCurrentDuplicates()
+		})
+		c.Close()
 		if err != nil {
 			return err
 		}
-		defer c.Close()
-		for k, _, err := c.Seek(keyStart); k != nil; k, _, err = c.NextNoDup() {
-			if err != nil {
-				return err
-			}
-			err = c.DeleteCurrentDuplicates()
-			if err != nil {
-				return err
-			}
-		}
 	}
 	return nil
 }
@@ -0,0 +1,19 @@
+package bitmapdb
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/internal/bitmapdb"
+)
+
+// EncodeShard - serializes a shard with the smallest of the supported codecs, see bitmapdb.EncodeShard
+func EncodeShard(bm *roaring.Bitmap) ([]byte, error) { return bitmapdb.EncodeShard(bm) }
+
+// EncodeShard64 - 64-bit variant of EncodeShard
+func EncodeShard64(bm *roaring64.Bitmap) ([]byte, error) { return bitmapdb.EncodeShard64(bm) }
+
+// DecodeShard - reads a shard written by EncodeShard or a legacy roaring shard into bm
+func DecodeShard(bm *roaring.Bitmap, v []byte) error { return bitmapdb.DecodeShard(bm, v) }
+
+// DecodeShard64 - 64-bit variant of DecodeShard
+func DecodeShard64(bm *roaring64.Bitmap, v []byte) error { return bitmapdb.DecodeShard64(bm, v) }
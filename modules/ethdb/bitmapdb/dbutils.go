@@ -11,7 +11,6 @@ import (
 	"github.com/RoaringBitmap/roaring"
 	"github.com/RoaringBitmap/roaring/roaring64"
 	"github.com/c2h5oh/datasize"
-	libcommon "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
 )
 
@@ -138,13 +137,12 @@ func TruncateRange(db kv.RwTx, bucket string, key []byte, to uint32) error {
 		return err
 	}
 
-	buf := bytes.NewBuffer(nil)
 	return WalkChunkWithKeys(key, bm, ChunkLimit, func(chunkKey []byte, chunk *roaring.Bitmap) error {
-		buf.Reset()
-		if _, err := chunk.WriteTo(buf); err != nil {
+		v, err := EncodeShard(chunk)
+		if err != nil {
 			return err
 		}
-		return db.Put(bucket, chunkKey, libcommon.Copy(buf.Bytes()))
+		return db.Put(bucket, chunkKey, v)
 	})
 }
 
@@ -170,7 +168,7 @@ func Get(db kv.Tx, bucket string, key []byte, from, to uint32) (*roaring.Bitmap,
 		}
 		bm := NewBitmap()
 		defer ReturnToPool(bm)
-		if _, err := bm.ReadFrom(bytes.NewReader(v)); err != nil {
+		if err := DecodeShard(bm, v); err != nil {
 			return nil, err
 		}
 		chunks = append(chunks, bm)
@@ -293,13 +291,12 @@ func TruncateRange64(db kv.RwTx, bucket string, key []byte, to uint64) error {
 		return err
 	}
 
-	buf := bytes.NewBuffer(nil)
 	return WalkChunkWithKeys64(key, bm, ChunkLimit, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
-		buf.Reset()
-		if _, err := chunk.WriteTo(buf); err != nil {
+		v, err := EncodeShard64(chunk)
+		if err != nil {
 			return err
 		}
-		return db.Put(bucket, chunkKey, libcommon.Copy(buf.Bytes()))
+		return db.Put(bucket, chunkKey, v)
 	})
 }
 
@@ -326,8 +323,7 @@ func Get64(db kv.Tx, bucket string, key []byte, from, to uint64) (*roaring64.Bit
 		}
 		bm := NewBitmap64()
 		defer ReturnToPool64(bm)
-		_, err := bm.ReadFrom(bytes.NewReader(v))
-		if err != nil {
+		if err := DecodeShard64(bm, v); err != nil {
 			return nil, err
 		}
 		chunks = append(chunks, bm)
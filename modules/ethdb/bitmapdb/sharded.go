@@ -0,0 +1,26 @@
+package bitmapdb
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/internal/bitmapdb"
+)
+
+// UpsertShardedIndex - adds delta to the bitmap of key in LogTopicIndex or LogAddressIndex, see bitmapdb.UpsertShardedIndex
+func UpsertShardedIndex(tx bitmapdb.ShardTx, table string, key []byte, delta *roaring.Bitmap, shardLimit int) error {
+	return bitmapdb.UpsertShardedIndex(tx, table, key, delta, shardLimit)
+}
+
+// SeekInIndex - the smallest value >= n of key in an index written by UpsertShardedIndex
+func SeekInIndex(tx bitmapdb.IndexReader, table string, key []byte, n uint32) (uint32, bool, error) {
+	return bitmapdb.SeekInIndex(tx, table, key, n)
+}
+
+// ReadIndex - the values in [from, to] of key in an index written by UpsertShardedIndex
+func ReadIndex(tx bitmapdb.IndexReader, table string, key []byte, from, to uint32) (*roaring.Bitmap, error) {
+	return bitmapdb.ReadIndex(tx, table, key, from, to)
+}
+
+// TruncateShardedIndex - removes the values >= from of every key of an index written by UpsertShardedIndex
+func TruncateShardedIndex(tx bitmapdb.ShardTx, table string, from uint32) error {
+	return bitmapdb.TruncateShardedIndex(tx, table, from)
+}
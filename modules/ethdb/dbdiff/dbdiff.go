@@ -0,0 +1,160 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dbdiff compares tables of two node databases record by record. Meant for tests and tools
+// which assert that two nodes converged: it reports the first record where the databases part.
+package dbdiff
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// CanonicalTables - tables of modules.AmcTables which two nodes with the same head must hold byte
+// for byte, whatever branches they went through to get there: all of them but Divergent
+var CanonicalTables = canonicalTables()
+
+// Divergent - tables of modules.AmcTables which two nodes with the same head may hold differently,
+// with the reason
+var Divergent = map[string]string{
+	modules.Headers:      forkBlocks,
+	modules.HeaderNumber: forkBlocks,
+	modules.HeaderTD:     forkBlocks,
+	modules.BlockBody:    forkBlocks,
+	modules.BlockVerify:  forkBlocks,
+	modules.BlockRewards: forkBlocks,
+	modules.PoaSnapshot:  "snapshots of the checkpoint blocks of every branch the node imported",
+
+	modules.BlockTx:         "ids are taken in the order the node wrote the bodies, fork bodies included",
+	modules.NonCanonicalTxs: "transactions of the blocks the node unwound",
+	modules.Sequence:        "next ids of BlockTx and NonCanonicalTxs",
+
+	modules.Code:              "code of contracts created by unwound blocks is kept",
+	modules.PlainContractCode: "code of contracts created by unwound blocks is kept",
+	modules.IncarnationMap:    "incarnations of contracts destroyed by unwound blocks are kept",
+
+	modules.LogTopicIndex:   "entries of unwound blocks are kept, eth_getLogs checks the canonical block",
+	modules.LogAddressIndex: "entries of unwound blocks are kept, eth_getLogs checks the canonical block",
+
+	modules.EventJournal: "the head changes and reorgs the node went through",
+	modules.DatabaseInfo: "progress of the node's own background work",
+}
+
+const forkBlocks = "blocks of every branch the node imported, until they are pruned as stale forks"
+
+func canonicalTables() []string {
+	tables := make([]string, 0, len(modules.AmcTables))
+	for _, table := range modules.AmcTables {
+		if _, ok := Divergent[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// Divergence - first record of Table which differs between databases A and B.
+// A or B is nil when the record with Key exists only on the other side.
+type Divergence struct {
+	Table string
+	Key   []byte
+	A, B  []byte
+}
+
+func (d *Divergence) String() string {
+	switch {
+	case d.A == nil:
+		return fmt.Sprintf("%s: key %x only in B (value %x)", d.Table, d.Key, d.B)
+	case d.B == nil:
+		return fmt.Sprintf("%s: key %x only in A (value %x)", d.Table, d.Key, d.A)
+	default:
+		return fmt.Sprintf("%s: key %x differs: A %x, B %x", d.Table, d.Key, d.A, d.B)
+	}
+}
+
+// Tables - first divergence of tables checked in given order, nil if all of them are equal
+func Tables(ctx context.Context, a, b kv.Tx, tables []string) (*Divergence, error) {
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		d, err := Table(a, b, table)
+		if err != nil || d != nil {
+			return d, err
+		}
+	}
+	return nil, nil
+}
+
+// Table - first divergence of table, nil if both sides hold the same records.
+// Records are compared in cursor order, for DupSort tables it includes order of values.
+func Table(a, b kv.Tx, table string) (*Divergence, error) {
+	ca, err := a.Cursor(table)
+	if err != nil {
+		return nil, err
+	}
+	defer ca.Close()
+	cb, err := b.Cursor(table)
+	if err != nil {
+		return nil, err
+	}
+	defer cb.Close()
+
+	ka, va, err := ca.First()
+	if err != nil {
+		return nil, err
+	}
+	kb, vb, err := cb.First()
+	if err != nil {
+		return nil, err
+	}
+	for ka != nil || kb != nil {
+		switch cmp := compareKeys(ka, kb); {
+		case cmp < 0:
+			return &Divergence{Table: table, Key: clone(ka), A: clone(va)}, nil
+		case cmp > 0:
+			return &Divergence{Table: table, Key: clone(kb), B: clone(vb)}, nil
+		case !bytes.Equal(va, vb):
+			return &Divergence{Table: table, Key: clone(ka), A: clone(va), B: clone(vb)}, nil
+		}
+		if ka, va, err = ca.Next(); err != nil {
+			return nil, err
+		}
+		if kb, vb, err = cb.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// compareKeys - bytes.Compare where nil (end of table) goes after any key
+func compareKeys(a, b []byte) int {
+	switch {
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return bytes.Compare(a, b)
+}
+
+// clone - copy which is never nil, even of empty value
+func clone(v []byte) []byte {
+	return append([]byte{}, v...)
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package dbdiff
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+func put(t *testing.T, tx kv.RwTx, table string, k, v []byte) {
+	t.Helper()
+	if err := tx.Put(table, k, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// twins - two databases with the same Receipts and AccountChangeSet records
+func twins(t *testing.T) (a, b kv.RwTx) {
+	t.Helper()
+	modules.AmcInit()
+	kv.ChaindataTablesCfg = modules.AmcTableCfg
+	_, a = memdb.NewTestTx(t)
+	_, b = memdb.NewTestTx(t)
+	for _, tx := range []kv.RwTx{a, b} {
+		for n := byte(0); n < 10; n++ {
+			put(t, tx, modules.Receipts, modules.EncodeBlockNumber(uint64(n)), []byte{n})
+			put(t, tx, modules.AccountChangeSet, modules.EncodeBlockNumber(uint64(n)), []byte{n, 1})
+			put(t, tx, modules.AccountChangeSet, modules.EncodeBlockNumber(uint64(n)), []byte{n, 2})
+		}
+	}
+	return a, b
+}
+
+func TestTables(t *testing.T) {
+	tables := []string{modules.Receipts, modules.AccountChangeSet}
+	k5 := modules.EncodeBlockNumber(5)
+	for _, tc := range []struct {
+		name    string
+		diverge func(t *testing.T, a, b kv.RwTx)
+		want    *Divergence // nil - converged
+	}{
+		{"equal", func(*testing.T, kv.RwTx, kv.RwTx) {}, nil},
+		{"value differs", func(t *testing.T, a, b kv.RwTx) {
+			put(t, b, modules.Receipts, k5, []byte{0xff})
+		}, &Divergence{Table: modules.Receipts, Key: k5, A: []byte{5}, B: []byte{0xff}}},
+		{"only in A", func(t *testing.T, a, b kv.RwTx) {
+			put(t, a, modules.Receipts, modules.EncodeBlockNumber(20), []byte{20})
+		}, &Divergence{Table: modules.Receipts, Key: modules.EncodeBlockNumber(20), A: []byte{20}}},
+		{"only in B", func(t *testing.T, a, b kv.RwTx) {
+			if err := a.Delete(modules.Receipts, k5); err != nil {
+				t.Fatal(err)
+			}
+		}, &Divergence{Table: modules.Receipts, Key: k5, B: []byte{5}}},
+		{"extra dup", func(t *testing.T, a, b kv.RwTx) {
+			put(t, b, modules.AccountChangeSet, k5, []byte{5, 0})
+		}, &Divergence{Table: modules.AccountChangeSet, Key: k5, A: []byte{5, 1}, B: []byte{5, 0}}},
+		{"first table wins", func(t *testing.T, a, b kv.RwTx) {
+			put(t, a, modules.AccountChangeSet, modules.EncodeBlockNumber(1), []byte{1, 3})
+			put(t, b, modules.Receipts, modules.EncodeBlockNumber(9), []byte{0})
+		}, &Divergence{Table: modules.Receipts, Key: modules.EncodeBlockNumber(9), A: []byte{9}, B: []byte{0}}},
+	} {
+		a, b := twins(t)
+		tc.diverge(t, a, b)
+		have, err := Tables(context.Background(), a, b, tables)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if (have == nil) != (tc.want == nil) {
+			t.Fatalf("%s: have %v, want %v", tc.name, have, tc.want)
+		}
+		if have == nil {
+			continue
+		}
+		if have.Table != tc.want.Table || !bytes.Equal(have.Key, tc.want.Key) ||
+			!bytes.Equal(have.A, tc.want.A) || !bytes.Equal(have.B, tc.want.B) ||
+			(have.A == nil) != (tc.want.A == nil) || (have.B == nil) != (tc.want.B == nil) {
+			t.Fatalf("%s: have %s, want %s", tc.name, have, tc.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethdb
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+const readLabels = int(kv.InMem) + 1
+
+// ReadStats counts the kv reads of one operation, e.g. an RPC call, per label of the db read.
+// Safe for concurrent use.
+type ReadStats struct {
+	reads [readLabels]uint64
+	bytes [readLabels]uint64
+}
+
+type readStatsKey struct{}
+
+// WithReadStats returns ctx carrying new ReadStats, which count the reads of transactions begun with it
+// on dbs wrapped by WithReadAccounting.
+func WithReadStats(ctx context.Context) (context.Context, *ReadStats) {
+	s := new(ReadStats)
+	return context.WithValue(ctx, readStatsKey{}, s), s
+}
+
+// ReadStatsFrom returns the ReadStats of ctx, nil if it carries none.
+func ReadStatsFrom(ctx context.Context) *ReadStats {
+	s, _ := ctx.Value(readStatsKey{}).(*ReadStats)
+	return s
+}
+
+func (s *ReadStats) add(label kv.Label, k, v []byte) {
+	if int(label) >= readLabels {
+		label = kv.InMem
+	}
+	atomic.AddUint64(&s.reads[label], 1)
+	atomic.AddUint64(&s.bytes[label], uint64(len(k)+len(v)))
+}
+
+// Of returns the reads and bytes read from dbs of label.
+func (s *ReadStats) Of(label kv.Label) (reads, bytes uint64) {
+	if int(label) >= readLabels {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&s.reads[label]), atomic.LoadUint64(&s.bytes[label])
+}
+
+// Total returns the reads and bytes read from all dbs.
+func (s *ReadStats) Total() (reads, bytes uint64) {
+	for label := 0; label < readLabels; label++ {
+		r, b := s.Of(kv.Label(label))
+		reads, bytes = reads+r, bytes+b
+	}
+	return reads, bytes
+}
+
+// WithReadAccounting returns db counting the reads of read transactions begun with a context carrying
+// ReadStats, under label. Other transactions are not wrapped. Range iterators are not counted.
+func WithReadAccounting(label kv.Label, db kv.RwDB) kv.RwDB {
+	return &accountedDB{RwDB: db, label: label}
+}
+
+type accountedDB struct {
+	kv.RwDB
+	label kv.Label
+}
+
+func (db *accountedDB) BeginRo(ctx context.Context) (kv.Tx, error) {
+	tx, err := db.RwDB.BeginRo(ctx)
+	stats := ReadStatsFrom(ctx)
+	if err != nil || stats == nil {
+		return tx, err
+	}
+	return &accountedTx{Tx: tx, label: db.label, stats: stats}, nil
+}
+
+func (db *accountedDB) View(ctx context.Context, f func(tx kv.Tx) error) error {
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return f(tx)
+}
+
+// accountedTx counts the records its reads return
+type accountedTx struct {
+	kv.Tx
+	label kv.Label
+	stats *ReadStats
+}
+
+func (tx *accountedTx) count(k, v []byte, err error) ([]byte, []byte, error) {
+	if err == nil && k != nil {
+		tx.stats.add(tx.label, k, v)
+	}
+	return k, v, err
+}
+
+func (tx *accountedTx) walker(walker func(k, v []byte) error) func(k, v []byte) error {
+	return func(k, v []byte) error {
+		tx.stats.add(tx.label, k, v)
+		return walker(k, v)
+	}
+}
+
+func (tx *accountedTx) Has(table string, key []byte) (bool, error) {
+	ok, err := tx.Tx.Has(table, key)
+	if err == nil {
+		tx.stats.add(tx.label, key, nil)
+	}
+	return ok, err
+}
+
+func (tx *accountedTx) GetOne(table string, key []byte) ([]byte, error) {
+	v, err := tx.Tx.GetOne(table, key)
+	if err == nil {
+		tx.stats.add(tx.label, key, v)
+	}
+	return v, err
+}
+
+func (tx *accountedTx) ForEach(table string, fromPrefix []byte, walker func(k, v []byte) error) error {
+	return tx.Tx.ForEach(table, fromPrefix, tx.walker(walker))
+}
+
+func (tx *accountedTx) ForPrefix(table string, prefix []byte, walker func(k, v []byte) error) error {
+	return tx.Tx.ForPrefix(table, prefix, tx.walker(walker))
+}
+
+func (tx *accountedTx) ForAmount(table string, prefix []byte, amount uint32, walker func(k, v []byte) error) error {
+	return tx.Tx.ForAmount(table, prefix, amount, tx.walker(walker))
+}
+
+func (tx *accountedTx) Cursor(table string) (kv.Cursor, error) {
+	c, err := tx.Tx.Cursor(table)
+	if err != nil {
+		return nil, err
+	}
+	return &accountedCursor{Cursor: c, tx: tx}, nil
+}
+
+func (tx *accountedTx) CursorDupSort(table string) (kv.CursorDupSort, error) {
+	c, err := tx.Tx.CursorDupSort(table)
+	if err != nil {
+		return nil, err
+	}
+	return &accountedDupSortCursor{CursorDupSort: c, tx: tx}, nil
+}
+
+type accountedCursor struct {
+	kv.Cursor
+	tx *accountedTx
+}
+
+func (c *accountedCursor) First() ([]byte, []byte, error)   { return c.tx.count(c.Cursor.First()) }
+func (c *accountedCursor) Last() ([]byte, []byte, error)    { return c.tx.count(c.Cursor.Last()) }
+func (c *accountedCursor) Next() ([]byte, []byte, error)    { return c.tx.count(c.Cursor.Next()) }
+func (c *accountedCursor) Prev() ([]byte, []byte, error)    { return c.tx.count(c.Cursor.Prev()) }
+func (c *accountedCursor) Current() ([]byte, []byte, error) { return c.tx.count(c.Cursor.Current()) }
+
+func (c *accountedCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	return c.tx.count(c.Cursor.Seek(seek))
+}
+
+func (c *accountedCursor) SeekExact(key []byte) ([]byte, []byte, error) {
+	return c.tx.count(c.Cursor.SeekExact(key))
+}
+
+type accountedDupSortCursor struct {
+	kv.CursorDupSort
+	tx *accountedTx
+}
+
+func (c *accountedDupSortCursor) First() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.First())
+}
+func (c *accountedDupSortCursor) Last() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.Last())
+}
+func (c *accountedDupSortCursor) Next() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.Next())
+}
+func (c *accountedDupSortCursor) Prev() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.Prev())
+}
+func (c *accountedDupSortCursor) Current() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.Current())
+}
+func (c *accountedDupSortCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.Seek(seek))
+}
+func (c *accountedDupSortCursor) SeekExact(key []byte) ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.SeekExact(key))
+}
+func (c *accountedDupSortCursor) SeekBothExact(key, value []byte) ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.SeekBothExact(key, value))
+}
+func (c *accountedDupSortCursor) NextDup() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.NextDup())
+}
+func (c *accountedDupSortCursor) NextNoDup() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.NextNoDup())
+}
+func (c *accountedDupSortCursor) PrevDup() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.PrevDup())
+}
+func (c *accountedDupSortCursor) PrevNoDup() ([]byte, []byte, error) {
+	return c.tx.count(c.CursorDupSort.PrevNoDup())
+}
+
+func (c *accountedDupSortCursor) SeekBothRange(key, value []byte) ([]byte, error) {
+	v, err := c.CursorDupSort.SeekBothRange(key, value)
+	if err == nil && v != nil {
+		c.tx.stats.add(c.tx.label, key, v)
+	}
+	return v, err
+}
@@ -0,0 +1,222 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/avm/rlp"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// errAccessListsDone stops the walks of BackfillBlockAccessLists past the last block.
+var errAccessListsDone = errors.New("access lists collected")
+
+// WriteBlockAccessList stores the accounts and storage slots block number
+// touched, replacing a stored list of the block.
+func WriteBlockAccessList(db kv.Putter, number uint64, hash types.Hash, list transaction.AccessList) error {
+	data, err := rlp.EncodeToBytes(list)
+	if err != nil {
+		return err
+	}
+	return db.Put(modules.BlockAccessList, modules.BlockBodyKey(number, hash), data)
+}
+
+// ReadBlockAccessList returns the access list of a block, nil if none was
+// recorded. A recorded block without accesses has an empty list.
+func ReadBlockAccessList(db kv.Getter, number uint64, hash types.Hash) (transaction.AccessList, error) {
+	data, err := db.GetOne(modules.BlockAccessList, modules.BlockBodyKey(number, hash))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	list := transaction.AccessList{}
+	if err := rlp.DecodeBytes(data, &list); err != nil {
+		return nil, fmt.Errorf("access list of block %d %x: %w", number, hash, err)
+	}
+	if list == nil {
+		list = transaction.AccessList{}
+	}
+	return list, nil
+}
+
+// DeleteBlockAccessList removes the access list of an unwound block.
+func DeleteBlockAccessList(db kv.Deleter, number uint64, hash types.Hash) error {
+	return db.Delete(modules.BlockAccessList, modules.BlockBodyKey(number, hash))
+}
+
+// PruneBlockAccessLists removes the access lists of the blocks below number.
+func PruneBlockAccessLists(tx kv.RwTx, below uint64) error {
+	c, err := tx.RwCursor(modules.BlockAccessList)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint64(k) >= below {
+			return nil
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackfillBlockAccessLists stores the access lists of the canonical blocks
+// from..to recorded before access lists were enabled. They are rebuilt from
+// the accounts and slots the blocks changed and the addresses their calls
+// came from or went to, accounts and slots which were only read are missing.
+// Blocks which have a list already keep it.
+func BackfillBlockAccessLists(tx kv.RwTx, from, to uint64, tmpdir string, quit <-chan struct{}) (int, error) {
+	collector := etl.NewCollector("BlockAccessList", tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer collector.Close()
+
+	collect := func(number uint64, addr []byte, slot []byte) error {
+		k := make([]byte, modules.NumberLength+types.AddressLength+len(slot))
+		binary.BigEndian.PutUint64(k, number)
+		copy(k[modules.NumberLength:], addr)
+		copy(k[modules.NumberLength+types.AddressLength:], slot)
+		return collector.Collect(k, nil)
+	}
+	walk := func(table string, f func(number uint64, k, v []byte) error) error {
+		err := tx.ForEach(table, modules.EncodeBlockNumber(from), func(k, v []byte) error {
+			if err := libcommon.Stopped(quit); err != nil {
+				return err
+			}
+			number := binary.BigEndian.Uint64(k)
+			if number > to {
+				return errAccessListsDone
+			}
+			return f(number, k, v)
+		})
+		if errors.Is(err, errAccessListsDone) {
+			return nil
+		}
+		return err
+	}
+	if err := walk(modules.AccountChangeSet, func(number uint64, _, v []byte) error {
+		return collect(number, v[:types.AddressLength], nil)
+	}); err != nil {
+		return 0, err
+	}
+	if err := walk(modules.StorageChangeSet, func(number uint64, k, v []byte) error {
+		return collect(number, k[modules.NumberLength:modules.NumberLength+types.AddressLength], v[:types.HashLength])
+	}); err != nil {
+		return 0, err
+	}
+	if err := walk(modules.CallTraceSet, func(number uint64, _, v []byte) error {
+		return collect(number, v[:types.AddressLength], nil)
+	}); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{modules.CallFromIndex, modules.CallToIndex} {
+		bm := roaring.New()
+		if err := tx.ForEach(table, nil, func(k, v []byte) error {
+			if err := libcommon.Stopped(quit); err != nil {
+				return err
+			}
+			bm.Clear()
+			if err := bitmapdb.DecodeShard(bm, v); err != nil {
+				return err
+			}
+			addr := k[:len(k)-2]
+			it := bm.Iterator()
+			it.AdvanceIfNeeded(uint32(from))
+			for it.HasNext() {
+				number := uint64(it.Next())
+				if number > to {
+					break
+				}
+				if err := collect(number, addr, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	b := &accessListBuilder{tx: tx}
+	if err := collector.Load(tx, "", func(k, _ []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		return b.add(k)
+	}, etl.TransformArgs{Quit: quit}); err != nil {
+		return b.written, err
+	}
+	return b.written, b.flush()
+}
+
+// accessListBuilder assembles the access lists of BackfillBlockAccessLists
+// from its sorted number + address [+ slot] keys.
+type accessListBuilder struct {
+	tx      kv.RwTx
+	number  uint64
+	list    transaction.AccessList
+	written int
+}
+
+func (b *accessListBuilder) add(k []byte) error {
+	number := binary.BigEndian.Uint64(k)
+	if len(b.list) > 0 && number != b.number {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	b.number = number
+	addr := types.BytesToAddress(k[modules.NumberLength : modules.NumberLength+types.AddressLength])
+	if len(b.list) == 0 || b.list[len(b.list)-1].Address != addr {
+		b.list = append(b.list, transaction.AccessTuple{Address: addr, StorageKeys: []types.Hash{}})
+	}
+	if slot := k[modules.NumberLength+types.AddressLength:]; len(slot) > 0 {
+		tuple := &b.list[len(b.list)-1]
+		if key := types.BytesToHash(slot); len(tuple.StorageKeys) == 0 || tuple.StorageKeys[len(tuple.StorageKeys)-1] != key {
+			tuple.StorageKeys = append(tuple.StorageKeys, key)
+		}
+	}
+	return nil
+}
+
+// flush writes the list of the current block unless the block is not
+// canonical or has a list already.
+func (b *accessListBuilder) flush() error {
+	if len(b.list) == 0 {
+		return nil
+	}
+	list := b.list
+	b.list = nil
+	hash, err := ReadCanonicalHash(b.tx, b.number)
+	if err != nil || hash == (types.Hash{}) {
+		return err
+	}
+	if ok, err := b.tx.Has(modules.BlockAccessList, modules.BlockBodyKey(b.number, hash)); err != nil || ok {
+		return err
+	}
+	b.written++
+	return WriteBlockAccessList(b.tx, b.number, hash, list)
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+)
+
+func TestBlockAccessList(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	list := transaction.AccessList{
+		{Address: types.Address{0x01}, StorageKeys: []types.Hash{{0x01}, {0x02}}},
+		{Address: types.Address{0x02}, StorageKeys: []types.Hash{}},
+	}
+	for number := uint64(1); number <= 5; number++ {
+		if err := WriteBlockAccessList(tx, number, types.Hash{byte(number)}, list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteBlockAccessList(tx, 6, types.Hash{6}, transaction.AccessList{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if have, err := ReadBlockAccessList(tx, 3, types.Hash{3}); err != nil || !reflect.DeepEqual(have, list) {
+		t.Fatalf("read %v, err %v, want %v", have, err, list)
+	}
+	if have, err := ReadBlockAccessList(tx, 3, types.Hash{4}); err != nil || have != nil {
+		t.Fatalf("read of unrecorded block %v, err %v", have, err)
+	}
+	if have, err := ReadBlockAccessList(tx, 6, types.Hash{6}); err != nil || have == nil || len(have) != 0 {
+		t.Fatalf("read of block without accesses %v, err %v", have, err)
+	}
+
+	if err := DeleteBlockAccessList(tx, 5, types.Hash{5}); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ReadBlockAccessList(tx, 5, types.Hash{5}); err != nil || have != nil {
+		t.Fatalf("read of unwound block %v, err %v", have, err)
+	}
+	if err := PruneBlockAccessLists(tx, 3); err != nil {
+		t.Fatal(err)
+	}
+	for number, want := range map[uint64]bool{1: false, 2: false, 3: true, 4: true, 6: true} {
+		if ok, err := tx.Has(modules.BlockAccessList, modules.BlockBodyKey(number, types.Hash{byte(number)})); err != nil || ok != want {
+			t.Fatalf("block %d after prune: recorded %v, err %v, want %v", number, ok, err, want)
+		}
+	}
+}
+
+func TestBackfillBlockAccessLists(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var (
+		sender, token, coinbase = types.Address{0x0a}, types.Address{0x0b}, types.Address{0x0c}
+		callee, flushed         = types.Address{0x0d}, types.Address{0x0e}
+		balance, allowance      = types.Hash{0x01}, types.Hash{0x02}
+	)
+	for number := uint64(1); number <= 4; number++ {
+		if err := WriteCanonicalHash(tx, types.Hash{byte(number)}, number); err != nil {
+			t.Fatal(err)
+		}
+		for _, addr := range []types.Address{coinbase, sender} {
+			if err := tx.Put(modules.AccountChangeSet, modules.EncodeBlockNumber(number), addr.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	putStorageChange(t, tx, 2, token, allowance, nil)
+	putStorageChange(t, tx, 2, token, balance, []byte{1})
+	putStorageChange(t, tx, 3, token, balance, []byte{2})
+	// block 2 is flushed into the call indices, block 3 is not
+	bm := roaring.BitmapOf(2)
+	if err := bitmapdb.UpsertShardedIndex(tx, modules.CallToIndex, flushed.Bytes(), bm, int(bitmapdb.ChunkLimit)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordCallTraces(tx, 3, map[types.Address]byte{sender: CallTraceFrom, callee: CallTraceTo}); err != nil {
+		t.Fatal(err)
+	}
+	// block 4 was recorded during execution, it keeps its list
+	recorded := transaction.AccessList{{Address: coinbase, StorageKeys: []types.Hash{}}, {Address: types.Address{0xff}, StorageKeys: []types.Hash{{0xff}}}}
+	if err := WriteBlockAccessList(tx, 4, types.Hash{4}, recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := BackfillBlockAccessLists(tx, 2, 4, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("backfilled %d blocks, want 2", n)
+	}
+	for number, want := range map[uint64]transaction.AccessList{
+		1: nil,
+		2: {
+			{Address: sender, StorageKeys: []types.Hash{}},
+			{Address: token, StorageKeys: []types.Hash{balance, allowance}},
+			{Address: coinbase, StorageKeys: []types.Hash{}},
+			{Address: flushed, StorageKeys: []types.Hash{}},
+		},
+		3: {
+			{Address: sender, StorageKeys: []types.Hash{}},
+			{Address: token, StorageKeys: []types.Hash{balance}},
+			{Address: coinbase, StorageKeys: []types.Hash{}},
+			{Address: callee, StorageKeys: []types.Hash{}},
+		},
+		4: recorded,
+	} {
+		have, err := ReadBlockAccessList(tx, number, types.Hash{byte(number)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("block %d: access list %v, want %v", number, have, want)
+		}
+	}
+	stored := 0
+	if err := tx.ForEach(modules.BlockAccessList, nil, func(_, _ []byte) error {
+		stored++
+		return nil
+	}); err != nil || stored != 3 {
+		t.Fatalf("%d access lists stored, err %v, want 3", stored, err)
+	}
+}
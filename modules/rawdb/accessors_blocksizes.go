@@ -0,0 +1,410 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+const (
+	// BlockSizeWindowLen is the number of blocks BlockSizeWindows sums up per entry.
+	BlockSizeWindowLen = 1000
+	// blockSizeSampleEvery makes every that many block compressed, a few samples per window.
+	blockSizeSampleEvery = 250
+)
+
+// BlockSize is the stored size of one block in bytes, split by table.
+type BlockSize struct {
+	Header   uint64 `json:"header"`
+	Body     uint64 `json:"body"`
+	Txs      uint64 `json:"txs"`
+	Receipts uint64 `json:"receipts"` // receipts and logs
+	// Compressed is the deflated size of all of the above, zero unless the block was sampled
+	Compressed uint64 `json:"compressed,omitempty"`
+}
+
+// Total is the stored size of the block.
+func (s BlockSize) Total() uint64 {
+	return s.Header + s.Body + s.Txs + s.Receipts
+}
+
+// BlockSizeWindow sums up the sizes of the blocks of a BlockSizeWindowLen
+// aligned window of block numbers.
+type BlockSizeWindow struct {
+	First    uint64 `json:"first"` // first block number of the window
+	Blocks   uint64 `json:"blocks"`
+	Header   uint64 `json:"header"`
+	Body     uint64 `json:"body"`
+	Txs      uint64 `json:"txs"`
+	Receipts uint64 `json:"receipts"`
+	// SampledRaw and SampledCompressed are the sizes of the sampled blocks before and after compression
+	SampledRaw        uint64 `json:"sampledRaw"`
+	SampledCompressed uint64 `json:"sampledCompressed"`
+}
+
+// Total is the stored size of the blocks of the window.
+func (w BlockSizeWindow) Total() uint64 {
+	return w.Header + w.Body + w.Txs + w.Receipts
+}
+
+// Ratio is the compressed to stored size ratio of the sampled blocks, ok is
+// false if no block of the window was sampled.
+func (w BlockSizeWindow) Ratio() (ratio float64, ok bool) {
+	if w.SampledRaw == 0 {
+		return 0, false
+	}
+	return float64(w.SampledCompressed) / float64(w.SampledRaw), true
+}
+
+func (w *BlockSizeWindow) add(s BlockSize, sign int) {
+	apply := func(to *uint64, v uint64) {
+		if sign > 0 {
+			*to += v
+		} else {
+			*to -= v
+		}
+	}
+	apply(&w.Blocks, 1)
+	apply(&w.Header, s.Header)
+	apply(&w.Body, s.Body)
+	apply(&w.Txs, s.Txs)
+	apply(&w.Receipts, s.Receipts)
+	if s.Compressed > 0 {
+		apply(&w.SampledRaw, s.Total())
+		apply(&w.SampledCompressed, s.Compressed)
+	}
+}
+
+func encodeBlockSize(s BlockSize) []byte {
+	n := 32
+	if s.Compressed > 0 {
+		n = 40
+	}
+	v := make([]byte, n)
+	binary.BigEndian.PutUint64(v, s.Header)
+	binary.BigEndian.PutUint64(v[8:], s.Body)
+	binary.BigEndian.PutUint64(v[16:], s.Txs)
+	binary.BigEndian.PutUint64(v[24:], s.Receipts)
+	if s.Compressed > 0 {
+		binary.BigEndian.PutUint64(v[32:], s.Compressed)
+	}
+	return v
+}
+
+func decodeBlockSize(v []byte) (BlockSize, error) {
+	if len(v) != 32 && len(v) != 40 {
+		return BlockSize{}, fmt.Errorf("%s: bad value length %d", modules.BlockSizes, len(v))
+	}
+	s := BlockSize{
+		Header:   binary.BigEndian.Uint64(v),
+		Body:     binary.BigEndian.Uint64(v[8:]),
+		Txs:      binary.BigEndian.Uint64(v[16:]),
+		Receipts: binary.BigEndian.Uint64(v[24:]),
+	}
+	if len(v) == 40 {
+		s.Compressed = binary.BigEndian.Uint64(v[32:])
+	}
+	return s, nil
+}
+
+func encodeBlockSizeWindow(w BlockSizeWindow) []byte {
+	v := make([]byte, 56)
+	for i, f := range []uint64{w.Blocks, w.Header, w.Body, w.Txs, w.Receipts, w.SampledRaw, w.SampledCompressed} {
+		binary.BigEndian.PutUint64(v[i*8:], f)
+	}
+	return v
+}
+
+func decodeBlockSizeWindow(k, v []byte) (BlockSizeWindow, error) {
+	if len(k) != 8 || len(v) != 56 {
+		return BlockSizeWindow{}, fmt.Errorf("%s: bad entry %x", modules.BlockSizeWindows, k)
+	}
+	w := BlockSizeWindow{First: binary.BigEndian.Uint64(k) * BlockSizeWindowLen}
+	for i, f := range []*uint64{&w.Blocks, &w.Header, &w.Body, &w.Txs, &w.Receipts, &w.SampledRaw, &w.SampledCompressed} {
+		*f = binary.BigEndian.Uint64(v[i*8:])
+	}
+	return w, nil
+}
+
+// ReadBlockSize returns the size RecordBlockSize stored for block number, ok
+// is false if the block has none.
+func ReadBlockSize(tx kv.Getter, number uint64) (BlockSize, bool, error) {
+	v, err := tx.GetOne(modules.BlockSizes, modules.EncodeBlockNumber(number))
+	if err != nil || v == nil {
+		return BlockSize{}, false, err
+	}
+	s, err := decodeBlockSize(v)
+	return s, err == nil, err
+}
+
+// ReadBlockSizeWindow returns the totals of the window containing block number.
+func ReadBlockSizeWindow(tx kv.Getter, number uint64) (BlockSizeWindow, error) {
+	k := modules.EncodeBlockNumber(number / BlockSizeWindowLen)
+	v, err := tx.GetOne(modules.BlockSizeWindows, k)
+	if err != nil {
+		return BlockSizeWindow{}, err
+	}
+	if v == nil {
+		return BlockSizeWindow{First: number / BlockSizeWindowLen * BlockSizeWindowLen}, nil
+	}
+	return decodeBlockSizeWindow(k, v)
+}
+
+// ReadBlockSizeWindows returns the totals of every window having recorded blocks.
+func ReadBlockSizeWindows(tx kv.Tx) ([]BlockSizeWindow, error) {
+	var res []BlockSizeWindow
+	err := tx.ForEach(modules.BlockSizeWindows, nil, func(k, v []byte) error {
+		w, err := decodeBlockSizeWindow(k, v)
+		if err != nil {
+			return err
+		}
+		res = append(res, w)
+		return nil
+	})
+	return res, err
+}
+
+func writeBlockSizeWindow(tx kv.RwTx, w BlockSizeWindow) error {
+	k := modules.EncodeBlockNumber(w.First / BlockSizeWindowLen)
+	if w.Blocks == 0 {
+		return tx.Delete(modules.BlockSizeWindows, k)
+	}
+	return tx.Put(modules.BlockSizeWindows, k, encodeBlockSizeWindow(w))
+}
+
+// WriteBlockSize stores the size of block number and moves the totals of its
+// window from the size recorded before, if any, to the new one.
+func WriteBlockSize(tx kv.RwTx, number uint64, s BlockSize) error {
+	w, err := ReadBlockSizeWindow(tx, number)
+	if err != nil {
+		return err
+	}
+	old, ok, err := ReadBlockSize(tx, number)
+	if err != nil {
+		return err
+	}
+	if ok {
+		w.add(old, -1)
+	}
+	w.add(s, 1)
+	if err := tx.Put(modules.BlockSizes, modules.EncodeBlockNumber(number), encodeBlockSize(s)); err != nil {
+		return err
+	}
+	return writeBlockSizeWindow(tx, w)
+}
+
+// MeasureBlockSize sums up the stored header, body, transactions, receipts
+// and logs of a block. If compress is set it also deflates them to sample
+// how well the block compresses.
+func MeasureBlockSize(tx kv.Tx, hash types.Hash, number uint64, compress bool) (BlockSize, error) {
+	var (
+		s   BlockSize
+		buf bytes.Buffer
+	)
+	add := func(to *uint64, v []byte) {
+		*to += uint64(len(v))
+		if compress {
+			buf.Write(v)
+		}
+	}
+
+	header, err := tx.GetOne(modules.Headers, modules.HeaderKey(number, hash))
+	if err != nil {
+		return s, err
+	}
+	add(&s.Header, header)
+
+	body, err := tx.GetOne(modules.BlockBody, modules.BlockBodyKey(number, hash))
+	if err != nil {
+		return s, err
+	}
+	add(&s.Body, body)
+	if len(body) >= 12 {
+		baseTxId, txAmount := binary.BigEndian.Uint64(body), binary.BigEndian.Uint32(body[8:])
+		if err := tx.ForAmount(modules.BlockTx, modules.EncodeBlockNumber(baseTxId), txAmount, func(_, v []byte) error {
+			add(&s.Txs, v)
+			return nil
+		}); err != nil {
+			return s, err
+		}
+	}
+
+	key := modules.EncodeBlockNumber(number)
+	receipts, err := tx.GetOne(modules.Receipts, key)
+	if err != nil {
+		return s, err
+	}
+	add(&s.Receipts, receipts)
+	if err := tx.ForPrefix(modules.Log, key, func(_, v []byte) error {
+		add(&s.Receipts, v)
+		return nil
+	}); err != nil {
+		return s, err
+	}
+
+	if compress && buf.Len() > 0 {
+		var out bytes.Buffer
+		w, err := flate.NewWriter(&out, flate.DefaultCompression)
+		if err != nil {
+			return s, err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return s, err
+		}
+		if err := w.Close(); err != nil {
+			return s, err
+		}
+		s.Compressed = uint64(out.Len())
+	}
+	return s, nil
+}
+
+// RecordBlockSize measures the stored block and records its size, every
+// blockSizeSampleEvery block is compressed to estimate segment sizes.
+func RecordBlockSize(tx kv.RwTx, hash types.Hash, number uint64) error {
+	s, err := MeasureBlockSize(tx, hash, number, number%blockSizeSampleEvery == 0)
+	if err != nil {
+		return err
+	}
+	return WriteBlockSize(tx, number, s)
+}
+
+// TruncateBlockSizes removes the sizes of the blocks from block from on and
+// takes them out of the window totals.
+func TruncateBlockSizes(tx kv.RwTx, from uint64) error {
+	c, err := tx.RwCursor(modules.BlockSizes)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var w BlockSizeWindow
+	dirty := false
+	for k, v, err := c.Seek(modules.EncodeBlockNumber(from)); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		number := binary.BigEndian.Uint64(k)
+		if !dirty || number/BlockSizeWindowLen != w.First/BlockSizeWindowLen {
+			if dirty {
+				if err := writeBlockSizeWindow(tx, w); err != nil {
+					return err
+				}
+			}
+			if w, err = ReadBlockSizeWindow(tx, number); err != nil {
+				return err
+			}
+			dirty = true
+		}
+		s, err := decodeBlockSize(v)
+		if err != nil {
+			return err
+		}
+		w.add(s, -1)
+		if err := c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	if dirty {
+		return writeBlockSizeWindow(tx, w)
+	}
+	return nil
+}
+
+// RetireRange is a range of blocks, both ends included, to be frozen into one segment.
+type RetireRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+	// Size is the estimated compressed size of the segment
+	Size uint64 `json:"size"`
+}
+
+// ScheduleRetirement splits the blocks [from, to] into ranges of about target
+// compressed bytes each. Block sizes are scaled by the compression ratio
+// sampled in their window, or in the last window sampled before it. Windows
+// fitting into the current range whole are taken from BlockSizeWindows, the
+// block sizes are only read where a range ends. The blocks after the last
+// full range are left for a later schedule.
+func ScheduleRetirement(tx kv.Tx, from, to, target uint64) ([]RetireRange, error) {
+	if target == 0 || from > to {
+		return nil, nil
+	}
+	c, err := tx.Cursor(modules.BlockSizes)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var (
+		res   []RetireRange
+		ratio = 1.0
+		start = from
+		acc   float64
+		goal  = float64(target)
+	)
+	for first := from / BlockSizeWindowLen * BlockSizeWindowLen; first <= to; first += BlockSizeWindowLen {
+		w, err := ReadBlockSizeWindow(tx, first)
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := w.Ratio(); ok {
+			ratio = r
+		}
+		last := first + BlockSizeWindowLen - 1
+		if est := float64(w.Total()) * ratio; first >= from && last <= to && acc+est < goal {
+			acc += est
+			continue
+		}
+
+		lo := first
+		if lo < from {
+			lo = from
+		}
+		for k, v, err := c.Seek(modules.EncodeBlockNumber(lo)); k != nil; k, v, err = c.Next() {
+			if err != nil {
+				return nil, err
+			}
+			number := binary.BigEndian.Uint64(k)
+			if number > last || number > to {
+				break
+			}
+			s, err := decodeBlockSize(v)
+			if err != nil {
+				return nil, err
+			}
+			est := float64(s.Total()) * ratio
+			// end the range before the block if that is closer to the target than after it
+			if acc > 0 && number > start && acc+est-goal > goal-acc {
+				res = append(res, RetireRange{From: start, To: number - 1, Size: uint64(acc)})
+				start, acc = number, 0
+			}
+			acc += est
+			if acc >= goal {
+				res = append(res, RetireRange{From: start, To: number, Size: uint64(acc)})
+				start, acc = number+1, 0
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/holiman/uint256"
+)
+
+func TestRecordBlockSize(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	var headers []*block.Header
+	for n := uint64(0); n < 3; n++ {
+		// zeroes compress well, so the sampled block must shrink
+		header := &block.Header{Number: uint256.NewInt(n), Difficulty: uint256.NewInt(1), BaseFee: uint256.NewInt(0), Extra: make([]byte, 4096)}
+		WriteHeader(tx, header)
+		if err := WriteBodyForStorage(tx, header.Hash(), n, &block.BodyForStorage{BaseTxId: n * 2, TxAmount: 2}); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteReceipts(tx, n, block.Receipts{{Status: 1, BlockNumber: uint256.NewInt(n)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := RecordBlockSize(tx, header.Hash(), n); err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, header)
+	}
+
+	sampled, ok, err := ReadBlockSize(tx, 0)
+	if err != nil || !ok {
+		t.Fatalf("block 0 has no size, err %v", err)
+	}
+	if sampled.Header < 4096 || sampled.Body != 12 || sampled.Receipts == 0 {
+		t.Fatalf("block 0 size %+v", sampled)
+	}
+	if sampled.Compressed == 0 || sampled.Compressed >= sampled.Total() {
+		t.Fatalf("block 0 compressed to %d of %d bytes", sampled.Compressed, sampled.Total())
+	}
+	if s, _, _ := ReadBlockSize(tx, 1); s.Compressed != 0 {
+		t.Fatalf("block 1 was sampled")
+	}
+
+	// recording a block again replaces it in the window totals
+	if err := RecordBlockSize(tx, headers[1].Hash(), 1); err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for n := uint64(0); n < 3; n++ {
+		s, _, _ := ReadBlockSize(tx, n)
+		total += s.Total()
+	}
+	w, err := ReadBlockSizeWindow(tx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.First != 0 || w.Blocks != 3 || w.Total() != total {
+		t.Fatalf("window %+v, want 3 blocks of %d bytes", w, total)
+	}
+	if r, ok := w.Ratio(); !ok || r >= 1 {
+		t.Fatalf("window ratio %v", r)
+	}
+
+	if err := TruncateBlockSizes(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = ReadBlockSizeWindow(tx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if w.Blocks != 1 || w.Total() != sampled.Total() {
+		t.Fatalf("window after truncation %+v", w)
+	}
+	if err := TruncateBlockSizes(tx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if windows, err := ReadBlockSizeWindows(tx); err != nil || len(windows) != 0 {
+		t.Fatalf("windows left after truncation: %v, err %v", windows, err)
+	}
+}
+
+// skewedBlockSize is the size of fixture block n: small early blocks, a burst
+// of large and badly compressing blocks, then medium ones. Every sampled block
+// compresses like the blocks of its window.
+func skewedBlockSize(n uint64) BlockSize {
+	var s BlockSize
+	switch {
+	case n < 4000:
+		s = BlockSize{Header: 500, Body: 12, Txs: 300 + n%7*100, Receipts: 200}
+	case n < 6000:
+		s = BlockSize{Header: 500, Body: 12, Txs: 60000 + n%13*4000, Receipts: 20000}
+	default:
+		s = BlockSize{Header: 500, Body: 12, Txs: 8000 + n%5*2000, Receipts: 3000}
+	}
+	if n%blockSizeSampleEvery == 0 {
+		ratio := 0.3
+		if n >= 4000 && n < 6000 {
+			ratio = 0.9
+		}
+		s.Compressed = uint64(float64(s.Total()) * ratio)
+	}
+	return s
+}
+
+func TestScheduleRetirement(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	const head = 12000
+	for n := uint64(0); n <= head; n++ {
+		if err := WriteBlockSize(tx, n, skewedBlockSize(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const target = 8 << 20
+	for _, from := range []uint64{0, 1234} {
+		ranges, err := ScheduleRetirement(tx, from, head, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranges) < 5 {
+			t.Fatalf("from %d: %d ranges", from, len(ranges))
+		}
+		next := from
+		minBlocks, maxBlocks := uint64(math.MaxUint64), uint64(0)
+		for _, r := range ranges {
+			if r.From != next || r.To < r.From || r.To > head {
+				t.Fatalf("from %d: range %+v does not follow block %d", from, r, next)
+			}
+			next = r.To + 1
+			blocks := r.To - r.From + 1
+			if blocks < minBlocks {
+				minBlocks = blocks
+			}
+			if blocks > maxBlocks {
+				maxBlocks = blocks
+			}
+
+			// compressed size of the range, block by block
+			var size float64
+			for n := r.From; n <= r.To; n++ {
+				ratio := 0.3
+				if n >= 4000 && n < 6000 {
+					ratio = 0.9
+				}
+				size += float64(skewedBlockSize(n).Total()) * ratio
+			}
+			if math.Abs(size-target) > 0.2*target {
+				t.Errorf("from %d: range %d-%d has %.0f bytes, want %d ±20%%", from, r.From, r.To, size, target)
+			}
+			if math.Abs(size-float64(r.Size)) > 0.001*size {
+				t.Errorf("from %d: range %d-%d estimated %d bytes, fixture has %.0f", from, r.From, r.To, r.Size, size)
+			}
+		}
+		if maxBlocks < 10*minBlocks {
+			t.Errorf("from %d: block counts of ranges do not follow block sizes: %+v", from, ranges)
+		}
+	}
+}
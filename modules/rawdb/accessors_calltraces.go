@@ -0,0 +1,156 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv/walk"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// The bits of a CallTraceSet record.
+const (
+	CallTraceFrom byte = 1 << iota // the address called
+	CallTraceTo                    // the address was called
+)
+
+// RecordCallTraces stores the addresses the calls of a block came from or went to,
+// replacing the records of the block. Addresses without a bit are skipped.
+func RecordCallTraces(tx kv.RwTx, blockNum uint64, touches map[types.Address]byte) error {
+	key := modules.EncodeBlockNumber(blockNum)
+	if err := tx.Delete(modules.CallTraceSet, key); err != nil {
+		return err
+	}
+	values := make([][]byte, 0, len(touches))
+	for addr, bits := range touches {
+		bits &= CallTraceFrom | CallTraceTo
+		if bits == 0 {
+			continue
+		}
+		v := make([]byte, types.AddressLength+1)
+		copy(v, addr.Bytes())
+		v[types.AddressLength] = bits
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return bytes.Compare(values[i], values[j]) < 0 })
+	for _, v := range values {
+		if err := tx.Put(modules.CallTraceSet, key, v); err != nil {
+			return fmt.Errorf("record call traces of block %d: %w", blockNum, err)
+		}
+	}
+	return nil
+}
+
+// FlushCallTraceIndexes moves the CallTraceSet records of the blocks up to toBlock into
+// the CallFromIndex and CallToIndex bitmaps and deletes them. An address both calling
+// and called in a block goes into both indices.
+func FlushCallTraceIndexes(tx kv.RwTx, toBlock uint64) error {
+	if toBlock > math.MaxUint32 {
+		return fmt.Errorf("flush call traces to block %d: beyond index range", toBlock)
+	}
+	from := make(map[string]*roaring.Bitmap)
+	to := make(map[string]*roaring.Bitmap)
+	var consumed [][]byte
+	add := func(m map[string]*roaring.Bitmap, addr []byte, n uint32) {
+		bm, ok := m[string(addr)]
+		if !ok {
+			bm = roaring.New()
+			m[string(addr)] = bm
+		}
+		bm.Add(n)
+	}
+	c, err := tx.Cursor(modules.CallTraceSet)
+	if err != nil {
+		return err
+	}
+	err = walk.Cursor(context.Background(), c, 0, toBlock+1, func(n uint64, k, v []byte) error {
+		if len(consumed) == 0 || !bytes.Equal(consumed[len(consumed)-1], k) {
+			consumed = append(consumed, types.CopyBytes(k))
+		}
+		if len(v) != types.AddressLength+1 {
+			return fmt.Errorf("call trace of block %d: %d bytes", n, len(v))
+		}
+		addr, bits := v[:types.AddressLength], v[types.AddressLength]
+		if bits&CallTraceFrom != 0 {
+			add(from, addr, uint32(n))
+		}
+		if bits&CallTraceTo != 0 {
+			add(to, addr, uint32(n))
+		}
+		return nil
+	})
+	c.Close()
+	if err != nil {
+		return fmt.Errorf("flush call traces: %w", err)
+	}
+	for _, idx := range []struct {
+		table string
+		m     map[string]*roaring.Bitmap
+	}{{modules.CallFromIndex, from}, {modules.CallToIndex, to}} {
+		addrs := make([]string, 0, len(idx.m))
+		for addr := range idx.m {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			if err := bitmapdb.UpsertShardedIndex(tx, idx.table, []byte(addr), idx.m[addr], int(bitmapdb.ChunkLimit)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range consumed {
+		if err := tx.Delete(modules.CallTraceSet, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnwindCallTraceIndexes removes the blocks above n from CallFromIndex and CallToIndex,
+// and drops the records of these blocks not flushed yet.
+func UnwindCallTraceIndexes(tx kv.RwTx, n uint64) error {
+	c, err := tx.RwCursorDupSort(modules.CallTraceSet)
+	if err != nil {
+		return err
+	}
+	// the walk goes on from the next key, as all values of this one are removed
+	err = walk.Cursor(context.Background(), c, n+1, math.MaxUint64, func(uint64, []byte, []byte) error {
+		return c.DeleteCurrentDuplicates()
+	})
+	c.Close()
+	if err != nil {
+		return err
+	}
+	if n >= math.MaxUint32 {
+		return nil
+	}
+	for _, table := range []string{modules.CallFromIndex, modules.CallToIndex} {
+		if err := bitmapdb.TruncateShardedIndex(tx, table, uint32(n+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func callIndexBlocks(t *testing.T, tx kv.Tx, table string, addr types.Address) []uint32 {
+	t.Helper()
+	bm, err := bitmapdb.ReadIndex(tx, table, addr.Bytes(), 0, 1<<32-1)
+	if err != nil {
+		t.Fatalf("read %s: %v", table, err)
+	}
+	return bm.ToArray()
+}
+
+// callTraceSetBlocks - the blocks with unflushed records, a block per record
+func callTraceSetBlocks(t *testing.T, tx kv.Tx) []uint64 {
+	t.Helper()
+	var blocks []uint64
+	if err := tx.ForEach(modules.CallTraceSet, nil, func(k, _ []byte) error {
+		n, err := modules.DecodeBlockNumber(k)
+		blocks = append(blocks, n)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func equalBlocks(a []uint32, b ...uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFlushCallTraceIndexes(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	caller, callee, both := types.Address{0x01}, types.Address{0x02}, types.Address{0x03}
+	for n := uint64(1); n <= 6; n++ {
+		touches := map[types.Address]byte{caller: CallTraceFrom, callee: CallTraceTo}
+		if n%2 == 0 {
+			touches[both] = CallTraceFrom | CallTraceTo
+		}
+		if err := RecordCallTraces(tx, n, touches); err != nil {
+			t.Fatalf("record %d: %v", n, err)
+		}
+	}
+	// recording a block again replaces its records
+	if err := RecordCallTraces(tx, 5, map[types.Address]byte{caller: CallTraceFrom}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := FlushCallTraceIndexes(tx, 4); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallFromIndex, both); !equalBlocks(blocks, 2, 4) {
+		t.Fatalf("from index of both: %v", blocks)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallToIndex, both); !equalBlocks(blocks, 2, 4) {
+		t.Fatalf("to index of both: %v", blocks)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallToIndex, caller); len(blocks) != 0 {
+		t.Fatalf("caller in to index: %v", blocks)
+	}
+	// flushed blocks are consumed, the rest is kept
+	if blocks := callTraceSetBlocks(t, tx); len(blocks) != 4 || blocks[0] != 5 || blocks[3] != 6 {
+		t.Fatalf("unflushed records of blocks %v, want 5 6 6 6", blocks)
+	}
+
+	if err := FlushCallTraceIndexes(tx, 10); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallFromIndex, caller); !equalBlocks(blocks, 1, 2, 3, 4, 5, 6) {
+		t.Fatalf("from index of caller: %v", blocks)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallToIndex, callee); !equalBlocks(blocks, 1, 2, 3, 4, 6) {
+		t.Fatalf("to index of callee: %v", blocks)
+	}
+	if err := RecordCallTraces(tx, 7, map[types.Address]byte{callee: CallTraceFrom}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UnwindCallTraceIndexes(tx, 3); err != nil {
+		t.Fatalf("unwind: %v", err)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallFromIndex, caller); !equalBlocks(blocks, 1, 2, 3) {
+		t.Fatalf("from index of caller after unwind: %v", blocks)
+	}
+	if blocks := callIndexBlocks(t, tx, modules.CallToIndex, both); !equalBlocks(blocks, 2) {
+		t.Fatalf("to index of both after unwind: %v", blocks)
+	}
+	if blocks := callTraceSetBlocks(t, tx); len(blocks) != 0 {
+		t.Fatalf("unflushed records of blocks %v after unwind", blocks)
+	}
+}
@@ -23,8 +23,10 @@ import (
 	"fmt"
 	"github.com/amazechain/amc/api/protocol/types_pb"
 	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/hashing"
 	"github.com/amazechain/amc/common/transaction"
 	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/kv/walk"
 	"github.com/amazechain/amc/log"
 	"github.com/amazechain/amc/modules"
 	"github.com/golang/protobuf/proto"
@@ -299,7 +301,40 @@ func CanonicalTxnByID(db kv.Getter, id uint64) (*transaction.Transaction, error)
 	return tx, nil
 }
 
+// readBodyTxs - ordinary transactions of the canonical block body, every one of them must be stored.
+// The first and the last id of the range are reserved for system transactions.
+func readBodyTxs(db kv.Getter, number uint64, body *block.BodyForStorage) ([]*transaction.Transaction, error) {
+	if body.TxAmount <= 2 {
+		return nil, nil
+	}
+	txs := make([]*transaction.Transaction, 0, body.TxAmount-2)
+	for id := body.BaseTxId + 1; id+1 < body.BaseTxId+uint64(body.TxAmount); id++ {
+		data, err := db.GetOne(modules.BlockTx, modules.EncodeBlockNumber(id))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("transaction %d of block %d is missing", id, number)
+		}
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(data); err != nil {
+			return nil, fmt.Errorf("transaction %d of block %d: %w", id, number, err)
+		}
+		txs = append(txs, txn)
+	}
+	return txs, nil
+}
+
 func CanonicalTransactions(db kv.Getter, baseTxId uint64, amount uint32) ([]*transaction.Transaction, error) {
+	return readTransactions(db, modules.BlockTx, baseTxId, amount)
+}
+
+// NonCanonicalTransactions reads the transactions of a block demoted with DemoteCanonicalTxs.
+func NonCanonicalTransactions(db kv.Getter, baseTxId uint64, amount uint32) ([]*transaction.Transaction, error) {
+	return readTransactions(db, modules.NonCanonicalTxs, baseTxId, amount)
+}
+
+func readTransactions(db kv.Getter, table string, baseTxId uint64, amount uint32) ([]*transaction.Transaction, error) {
 	if amount == 0 {
 		return []*transaction.Transaction{}, nil
 	}
@@ -308,7 +343,7 @@ func CanonicalTransactions(db kv.Getter, baseTxId uint64, amount uint32) ([]*tra
 	binary.BigEndian.PutUint64(txIdKey, baseTxId)
 	i := uint32(0)
 
-	if err := db.ForAmount(modules.BlockTx, txIdKey, amount, func(k, v []byte) error {
+	if err := db.ForAmount(table, txIdKey, amount, func(k, v []byte) error {
 		var decodeErr error
 		tx := new(transaction.Transaction)
 		if decodeErr = tx.Unmarshal(v); nil != decodeErr {
@@ -493,9 +528,9 @@ func ReadSenders(db kv.Getter, hash types.Hash, number uint64) ([]types.Address,
 	if err != nil {
 		return nil, fmt.Errorf("readSenders failed: %w", err)
 	}
-	senders := make([]types.Address, len(data)/types.AddressLength)
-	for i := 0; i < len(senders); i++ {
-		copy(senders[i][:], data[i*types.AddressLength:])
+	senders, err := DecodeSenders(data)
+	if err != nil {
+		return nil, fmt.Errorf("readSenders of block %d: %w", number, err)
 	}
 	return senders, nil
 }
@@ -512,7 +547,7 @@ func WriteRawBodyIfNotExists(db kv.RwTx, hash types.Hash, number uint64, body *b
 }
 
 func WriteRawBody(db kv.RwTx, hash types.Hash, number uint64, body *block.RawBody) (ok bool, lastTxnNum uint64, err error) {
-	baseTxId, err := db.IncrementSequence(modules.BlockTx, uint64(len(body.Transactions))+2)
+	baseTxId, err := IncrementSequence(db, modules.BlockTx, uint64(len(body.Transactions))+2)
 	if err != nil {
 		return false, 0, err
 	}
@@ -533,7 +568,7 @@ func WriteRawBody(db kv.RwTx, hash types.Hash, number uint64, body *block.RawBod
 func WriteBody(db kv.RwTx, hash types.Hash, number uint64, body *block.Body) error {
 	// Pre-processing
 	body.SendersFromTxs()
-	baseTxId, err := db.IncrementSequence(modules.BlockTx, uint64(len(body.Txs))+2)
+	baseTxId, err := IncrementSequence(db, modules.BlockTx, uint64(len(body.Txs))+2)
 	if err != nil {
 		return err
 	}
@@ -679,43 +714,6 @@ func HasReceipts(db kv.Has, number uint64) bool {
 	return true
 }
 
-// ReadRawReceipts retrieves all the transaction receipts belonging to a block.
-// The receipt metadata fields are not guaranteed to be populated, so they
-// should not be used. Use ReadReceipts instead if the metadata is needed.
-func ReadRawReceipts(db kv.Tx, blockNum uint64) block.Receipts {
-	// Retrieve the flattened receipt slice
-	data, err := db.GetOne(modules.Receipts, modules.EncodeBlockNumber(blockNum))
-	if err != nil {
-		log.Error("ReadRawReceipts failed", "err", err)
-	}
-	if len(data) == 0 {
-		return nil
-	}
-	var receipts block.Receipts
-
-	if err := receipts.Unmarshal(data); nil != err {
-		log.Error("ReadRawReceipts failed", "err", err)
-		return nil
-	}
-
-	//prefix := make([]byte, 8)
-	//binary.BigEndian.PutUint64(prefix, blockNum)
-	//if err := db.ForPrefix(Log, prefix, func(k, v []byte) error {
-	//	var logs block.Logs
-	//	if err := logs.Unmarshal(v); err != nil {
-	//		return fmt.Errorf("receipt unmarshal failed:  %w", err)
-	//	}
-	//
-	//	receipts[binary.BigEndian.Uint32(k[8:])].Logs = logs
-	//	return nil
-	//}); err != nil {
-	//	log.Error("logs fetching failed", "err", err)
-	//	return nil
-	//}
-
-	return receipts
-}
-
 // ReadReceipts retrieves all the transaction receipts belonging to a block, including
 // its corresponding metadata fields. If it is unable to populate these metadata
 // fields then nil is returned.
@@ -765,80 +763,21 @@ func ReadReceiptsByHash(db kv.Tx, hash types.Hash) (block.Receipts, error) {
 	return receipts, nil
 }
 
-// WriteReceipts stores all the transaction receipts belonging to a block.
-func WriteReceipts(tx kv.Putter, number uint64, receipts block.Receipts) error {
-	for txId, r := range receipts {
-		if len(r.Logs) == 0 {
-			continue
-		}
-		var logs block.Logs
-		logs = r.Logs
-		v, err := logs.Marshal()
+// TruncateReceipts removes all receipt for given block number or newer
+func TruncateReceipts(db kv.RwTx, number uint64) error {
+	for _, table := range []string{modules.Receipts, modules.Log} {
+		c, err := db.RwCursor(table)
 		if err != nil {
-			return fmt.Errorf("encode block logs for block %d: %w", number, err)
-		}
-
-		if err = tx.Put(modules.Log, modules.LogKey(number, uint32(txId)), v); err != nil {
-			return fmt.Errorf("writing logs for block %d: %w", number, err)
-		}
-	}
-
-	v, err := receipts.Marshal()
-	if err != nil {
-		return fmt.Errorf("encode block receipts for block %d: %w", number, err)
-	}
-
-	if err = tx.Put(modules.Receipts, modules.EncodeBlockNumber(number), v); err != nil {
-		return fmt.Errorf("writing receipts for block %d: %w", number, err)
-	}
-	return nil
-}
-
-// AppendReceipts stores all the transaction receipts belonging to a block.
-func AppendReceipts(tx kv.StatelessWriteTx, blockNumber uint64, receipts block.Receipts) error {
-	for txId, r := range receipts {
-		if len(r.Logs) == 0 {
-			continue
-		}
-
-		var logs block.Logs
-		logs = r.Logs
-		v, err := logs.Marshal()
-		if nil != err {
 			return err
 		}
-
-		if err = tx.Append(modules.Log, modules.LogKey(blockNumber, uint32(txId)), v); err != nil {
-			return fmt.Errorf("writing receipts for block %d: %w", blockNumber, err)
+		err = walk.Cursor(context.Background(), c, number, math.MaxUint64, func(uint64, []byte, []byte) error {
+			return c.DeleteCurrent()
+		})
+		c.Close()
+		if err != nil {
+			return err
 		}
 	}
-
-	rv, err := receipts.Marshal()
-	if err != nil {
-		return fmt.Errorf("encode block receipts for block %d: %w", blockNumber, err)
-	}
-
-	if err = tx.Append(modules.Receipts, modules.EncodeBlockNumber(blockNumber), rv); err != nil {
-		return fmt.Errorf("writing receipts for block %d: %w", blockNumber, err)
-	}
-	return nil
-}
-
-// TruncateReceipts removes all receipt for given block number or newer
-func TruncateReceipts(db kv.RwTx, number uint64) error {
-	if err := db.ForEach(modules.Receipts, modules.EncodeBlockNumber(number), func(k, _ []byte) error {
-		return db.Delete(modules.Receipts, k)
-	}); err != nil {
-		return err
-	}
-
-	from := make([]byte, 8)
-	binary.BigEndian.PutUint64(from, number)
-	if err := db.ForEach(modules.Log, from, func(k, _ []byte) error {
-		return db.Delete(modules.Log, k)
-	}); err != nil {
-		return err
-	}
 	return nil
 }
 
@@ -873,6 +812,16 @@ func ReadBlock(tx kv.Getter, hash types.Hash, number uint64) *block.Block {
 	if body == nil {
 		return nil
 	}
+	// fork bodies point into BlockTx unless their block was demoted, the ids alone do not tell
+	if canonical, _ := ReadCanonicalHash(tx, number); canonical != hash && hashing.DeriveSha(transaction.Transactions(body.Txs)) != header.TxHash {
+		_, baseTxId, txAmount := ReadBody(tx, hash, number)
+		txs, err := NonCanonicalTransactions(tx, baseTxId, txAmount)
+		if err != nil {
+			log.Error("failed to read demoted transactions", "hash", hash, "block", number, "err", err)
+			return nil
+		}
+		body.Txs = txs
+	}
 	return block.NewBlockFromStorage(hash, header, body)
 }
 
@@ -1041,7 +990,7 @@ func SecondKey(tx kv.Tx, table string) ([]byte, error) {
 }
 
 // TruncateBlocks - delete block >= blockFrom
-// does decrement sequences of kv.EthTx and kv.NonCanonicalTxs
+// does decrement the sequence of modules.BlockTx, see ResetSequence
 // doesn't delete Receipts, Senders, Canonical markers, TotalDifficulty
 func TruncateBlocks(ctx context.Context, tx kv.RwTx, blockFrom uint64) error {
 	logEvery := time.NewTicker(20 * time.Second)
@@ -1084,7 +1033,9 @@ func TruncateBlocks(ctx context.Context, tx kv.RwTx, blockFrom uint64) error {
 			}); err != nil {
 				return err
 			}
-			sequenceTo[bucket] = b.BaseTxId
+			if to, ok := sequenceTo[bucket]; !ok || b.BaseTxId < to {
+				sequenceTo[bucket] = b.BaseTxId
+			}
 		}
 		// Copying k because otherwise the same memory will be reused
 		// for the next key and Delete below will end up deleting 1 more record than required
@@ -1104,6 +1055,11 @@ func TruncateBlocks(ctx context.Context, tx kv.RwTx, blockFrom uint64) error {
 		default:
 		}
 	}
+	for bucket, to := range sequenceTo {
+		if err := ResetSequence(tx, bucket, to); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -1222,29 +1178,20 @@ func PruneTable(tx kv.RwTx, table string, pruneTo uint64, ctx context.Context, l
 	defer c.Close()
 
 	i := 0
-	for k, _, err := c.First(); k != nil; k, _, err = c.Next() {
-		if err != nil {
-			return err
-		}
+	err = walk.Cursor(ctx, c, 0, pruneTo, func(blockNum uint64, _, _ []byte) error {
 		i++
 		if i > limit {
-			break
-		}
-
-		blockNum := binary.BigEndian.Uint64(k)
-		if blockNum >= pruneTo {
-			break
-		}
-		select {
-		case <-ctx.Done():
-			return common2.ErrStopped
-		default:
+			return walk.ErrStop
 		}
-		if err = c.DeleteCurrent(); err != nil {
+		if err := c.DeleteCurrent(); err != nil {
 			return fmt.Errorf("failed to remove for block %d: %w", blockNum, err)
 		}
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return common2.ErrStopped
 	}
-	return nil
+	return err
 }
 
 func PruneTableDupSort(tx kv.RwTx, table string, logPrefix string, pruneTo uint64, logEvery *time.Ticker, ctx context.Context) error {
@@ -1254,26 +1201,22 @@ func PruneTableDupSort(tx kv.RwTx, table string, logPrefix string, pruneTo uint6
 	}
 	defer c.Close()
 
-	for k, _, err := c.First(); k != nil; k, _, err = c.NextNoDup() {
-		if err != nil {
-			return fmt.Errorf("failed to move %s cleanup cursor: %w", table, err)
-		}
-		blockNum := binary.BigEndian.Uint64(k)
-		if blockNum >= pruneTo {
-			break
-		}
+	err = walk.Cursor(ctx, c, 0, pruneTo, func(blockNum uint64, _, _ []byte) error {
 		select {
 		case <-logEvery.C:
 			log.Info(fmt.Sprintf("[%s]", logPrefix), "table", table, "block", blockNum)
-		case <-ctx.Done():
-			return common2.ErrStopped
 		default:
 		}
-		if err = c.DeleteCurrentDuplicates(); err != nil {
+		// the walk goes on from the next key, as all values of this one are removed
+		if err := c.DeleteCurrentDuplicates(); err != nil {
 			return fmt.Errorf("failed to remove for block %d: %w", blockNum, err)
 		}
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return common2.ErrStopped
 	}
-	return nil
+	return err
 }
 
 func ReadCurrentBlockNumber(db kv.Getter) *uint64 {
@@ -0,0 +1,307 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// WriteCumulativeIndexes stores the gas used and the number of transactions
+// of the canonical chain up to and including block blockNum.
+func WriteCumulativeIndexes(tx kv.Putter, blockNum, cumGas, cumTxs uint64) error {
+	key := modules.EncodeBlockNumber(blockNum)
+	if err := tx.Put(modules.CumulativeGasIndex, key, modules.EncodeBlockNumber(cumGas)); err != nil {
+		return err
+	}
+	return tx.Put(modules.CumulativeTransactionIndex, key, modules.EncodeBlockNumber(cumTxs))
+}
+
+// ReadCumulativeIndexes returns the totals WriteCumulativeIndexes stored for
+// blockNum, ok is false if the block has none.
+func ReadCumulativeIndexes(tx kv.Getter, blockNum uint64) (cumGas, cumTxs uint64, ok bool, err error) {
+	key := modules.EncodeBlockNumber(blockNum)
+	gas, err := tx.GetOne(modules.CumulativeGasIndex, key)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	txs, err := tx.GetOne(modules.CumulativeTransactionIndex, key)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(gas) != 8 || len(txs) != 8 {
+		return 0, 0, false, nil
+	}
+	return binary.BigEndian.Uint64(gas), binary.BigEndian.Uint64(txs), true, nil
+}
+
+// AppendCumulativeIndexes adds a new canonical head with gasUsed gas and txs
+// transactions on top of the totals of its parent. Heads whose parent has no
+// totals are skipped, RepairCumulativeIndexes fills them in.
+func AppendCumulativeIndexes(tx kv.RwTx, blockNum, gasUsed, txs uint64) error {
+	var cumGas, cumTxs uint64
+	if blockNum > 0 {
+		var ok bool
+		var err error
+		if cumGas, cumTxs, ok, err = ReadCumulativeIndexes(tx, blockNum-1); err != nil || !ok {
+			return err
+		}
+	}
+	return WriteCumulativeIndexes(tx, blockNum, cumGas+gasUsed, cumTxs+txs)
+}
+
+// TruncateCumulativeIndexes removes the totals of the blocks from block from on.
+func TruncateCumulativeIndexes(tx kv.RwTx, from uint64) error {
+	for _, table := range []string{modules.CumulativeGasIndex, modules.CumulativeTransactionIndex} {
+		c, err := tx.RwCursor(table)
+		if err != nil {
+			return err
+		}
+		for k, _, err := c.Seek(modules.EncodeBlockNumber(from)); k != nil; k, _, err = c.Next() {
+			if err != nil {
+				c.Close()
+				return err
+			}
+			if err := c.DeleteCurrent(); err != nil {
+				c.Close()
+				return err
+			}
+		}
+		c.Close()
+	}
+	return nil
+}
+
+// FindBlockByCumulativeGas returns the first block by which the canonical
+// chain used at least target gas, ok is false if the indexed blocks used less.
+func FindBlockByCumulativeGas(tx kv.Tx, target uint64) (uint64, bool, error) {
+	return searchCumulative(tx, modules.CumulativeGasIndex, target)
+}
+
+// FindBlockByCumulativeTxCount returns the first block by which the canonical
+// chain had at least target transactions, ok is false if the indexed blocks had less.
+func FindBlockByCumulativeTxCount(tx kv.Tx, target uint64) (uint64, bool, error) {
+	return searchCumulative(tx, modules.CumulativeTransactionIndex, target)
+}
+
+// searchCumulative binary searches the block numbers of a cumulative index
+// for the first block whose total reaches target. Seek lands on the next
+// indexed block, so the search tolerates gaps in the index.
+func searchCumulative(tx kv.Tx, table string, target uint64) (uint64, bool, error) {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return 0, false, err
+	}
+	defer c.Close()
+
+	first, _, err := c.First()
+	if err != nil || first == nil {
+		return 0, false, err
+	}
+	last, v, err := c.Last()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(v) != 8 || binary.BigEndian.Uint64(v) < target {
+		return 0, false, nil
+	}
+	lo, hi := binary.BigEndian.Uint64(first), binary.BigEndian.Uint64(last)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		k, v, err := c.Seek(modules.EncodeBlockNumber(mid))
+		if err != nil {
+			return 0, false, err
+		}
+		if k == nil || len(v) != 8 {
+			return 0, false, fmt.Errorf("%s: bad entry at block %d", table, mid)
+		}
+		if binary.BigEndian.Uint64(v) >= target {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	k, _, err := c.Seek(modules.EncodeBlockNumber(lo))
+	if err != nil || k == nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(k), true, nil
+}
+
+// EstimateBlocksForGasBudget returns how many blocks from block fromBlock on
+// fit into gasBudget, at least one. It returns 0 if the totals of the blocks
+// before fromBlock are not indexed, callers then size by block count.
+func EstimateBlocksForGasBudget(tx kv.Tx, fromBlock, gasBudget uint64) (uint64, error) {
+	var base uint64
+	if fromBlock > 0 {
+		var ok bool
+		var err error
+		if base, _, ok, err = ReadCumulativeIndexes(tx, fromBlock-1); err != nil || !ok {
+			return 0, err
+		}
+	}
+	target := uint64(math.MaxUint64)
+	if base < math.MaxUint64-gasBudget {
+		target = base + gasBudget + 1
+	}
+	over, ok, err := FindBlockByCumulativeGas(tx, target)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		c, err := tx.Cursor(modules.CumulativeGasIndex)
+		if err != nil {
+			return 0, err
+		}
+		defer c.Close()
+		last, _, err := c.Last()
+		if err != nil || last == nil {
+			return 0, err
+		}
+		over = binary.BigEndian.Uint64(last) + 1
+	}
+	if over <= fromBlock {
+		return 1, nil
+	}
+	return over - fromBlock, nil
+}
+
+// CumulativeIndexReport is the result of RepairCumulativeIndexes.
+type CumulativeIndexReport struct {
+	From         uint64   `json:"from"`
+	To           uint64   `json:"to"`                     // last canonical block checked
+	Missing      []uint64 `json:"missing,omitempty"`      // blocks without totals
+	NonMonotonic []uint64 `json:"nonMonotonic,omitempty"` // blocks with totals below the ones of their parent
+	Mismatched   []uint64 `json:"mismatched,omitempty"`   // blocks with totals other than recomputed
+	Repaired     int      `json:"repaired"`
+}
+
+// errCumulativeDone stops the walk of RepairCumulativeIndexes past the last block.
+var errCumulativeDone = errors.New("cumulative indexes checked")
+
+// RepairCumulativeIndexes checks the cumulative indexes of the canonical
+// blocks from..to against the totals recomputed from the bodies and
+// receipts, block gas falls back to the header when receipts are pruned.
+// The recomputation starts at the last indexed block before from, or at
+// the genesis. Unless dryRun, missing and wrong totals are rewritten.
+func RepairCumulativeIndexes(tx kv.RwTx, from, to uint64, dryRun bool) (*CumulativeIndexReport, error) {
+	r := &CumulativeIndexReport{From: from}
+	start, cumGas, cumTxs, err := cumulativeBase(tx, from)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.ForEach(modules.HeaderCanonical, modules.EncodeBlockNumber(start), func(k, v []byte) error {
+		n := binary.BigEndian.Uint64(k)
+		if n > to {
+			return errCumulativeDone
+		}
+		hash := types.BytesToHash(v)
+		gas, txs, err := blockTotals(tx, n, hash)
+		if err != nil {
+			return err
+		}
+		prevGas, prevTxs := cumGas, cumTxs
+		cumGas, cumTxs = cumGas+gas, cumTxs+txs
+		if n < from {
+			return nil
+		}
+		r.To = n
+
+		storedGas, storedTxs, ok, err := ReadCumulativeIndexes(tx, n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !ok:
+			r.Missing = append(r.Missing, n)
+		case storedGas < prevGas || storedTxs < prevTxs:
+			r.NonMonotonic = append(r.NonMonotonic, n)
+		case storedGas != cumGas || storedTxs != cumTxs:
+			r.Mismatched = append(r.Mismatched, n)
+		default:
+			return nil
+		}
+		if dryRun {
+			return nil
+		}
+		r.Repaired++
+		return WriteCumulativeIndexes(tx, n, cumGas, cumTxs)
+	}); err != nil && !errors.Is(err, errCumulativeDone) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// cumulativeBase returns the block the recomputation of the totals of block
+// from starts at, and the totals before it.
+func cumulativeBase(tx kv.Tx, from uint64) (start, cumGas, cumTxs uint64, err error) {
+	if from == 0 {
+		return 0, 0, 0, nil
+	}
+	c, err := tx.Cursor(modules.CumulativeGasIndex)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer c.Close()
+	k, _, err := c.Seek(modules.EncodeBlockNumber(from))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if k == nil {
+		k, _, err = c.Last()
+	} else {
+		k, _, err = c.Prev()
+	}
+	if err != nil || k == nil {
+		return 0, 0, 0, err
+	}
+	n := binary.BigEndian.Uint64(k)
+	cumGas, cumTxs, ok, err := ReadCumulativeIndexes(tx, n)
+	if err != nil || !ok {
+		return 0, 0, 0, err
+	}
+	return n + 1, cumGas, cumTxs, nil
+}
+
+// blockTotals returns the gas used and the number of transactions of a block.
+func blockTotals(tx kv.Tx, number uint64, hash types.Hash) (gas, txs uint64, err error) {
+	body, err := ReadStorageBody(tx, hash, number)
+	if err != nil {
+		return 0, 0, fmt.Errorf("body of block %d %x: %w", number, hash, err)
+	}
+	// the first and the last id of the range are reserved for system transactions
+	if body.TxAmount > 2 {
+		txs = uint64(body.TxAmount - 2)
+	}
+	if txs == 0 {
+		return 0, 0, nil
+	}
+	if receipts := ReadRawReceipts(tx, number); len(receipts) > 0 {
+		return receipts[len(receipts)-1].CumulativeGasUsed, txs, nil
+	}
+	header := ReadHeader(tx, hash, number)
+	if header == nil {
+		return 0, 0, fmt.Errorf("header of block %d %x is missing", number, hash)
+	}
+	return header.GasUsed, txs, nil
+}
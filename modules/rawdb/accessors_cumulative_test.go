@@ -0,0 +1,216 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// cumulativeGas is the gas used by test block n, every fourth block is empty.
+func cumulativeGas(n uint64) (gas, txs uint64) {
+	if n%4 == 0 {
+		return 0, 0
+	}
+	return n * 1000, n%3 + 1
+}
+
+// writeCumulativeBlock writes canonical block n with the header, body and,
+// unless pruned, receipts RepairCumulativeIndexes recomputes totals from.
+func writeCumulativeBlock(t *testing.T, tx kv.RwTx, n uint64, pruned bool) {
+	t.Helper()
+	gas, txs := cumulativeGas(n)
+	header := &block.Header{Number: uint256.NewInt(n), GasUsed: gas, BaseFee: uint256.NewInt(0), Difficulty: uint256.NewInt(1), Time: n}
+	WriteHeader(tx, header)
+	hash := header.Hash()
+	if err := WriteCanonicalHash(tx, hash, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBodyForStorage(tx, hash, n, &block.BodyForStorage{BaseTxId: n * 10, TxAmount: uint32(txs) + 2}); err != nil {
+		t.Fatal(err)
+	}
+	if pruned || txs == 0 {
+		return
+	}
+	receipts := make(block.Receipts, txs)
+	for i := range receipts {
+		receipts[i] = &block.Receipt{Status: 1, CumulativeGasUsed: gas * uint64(i+1) / txs, BlockNumber: uint256.NewInt(n), TransactionIndex: uint(i)}
+	}
+	if err := WriteReceipts(tx, n, receipts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCumulativeIndexes(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, ok, err := FindBlockByCumulativeGas(tx, 1); err != nil || ok {
+		t.Fatalf("empty index found a block, err %v", err)
+	}
+	const head = 40
+	var totalGas, totalTxs [head + 1]uint64
+	for n := uint64(0); n <= head; n++ {
+		gas, txs := cumulativeGas(n)
+		if err := AppendCumulativeIndexes(tx, n, gas, txs); err != nil {
+			t.Fatal(err)
+		}
+		totalGas[n], totalTxs[n] = gas, txs
+		if n > 0 {
+			totalGas[n] += totalGas[n-1]
+			totalTxs[n] += totalTxs[n-1]
+		}
+	}
+	// find the first block reaching every total by a scan
+	find := func(totals []uint64, target uint64) (uint64, bool) {
+		for n, total := range totals {
+			if total >= target {
+				return uint64(n), true
+			}
+		}
+		return 0, false
+	}
+	for target := uint64(0); target <= totalGas[head]+1000; target += 250 {
+		wantN, wantOk := find(totalGas[:], target)
+		if n, ok, err := FindBlockByCumulativeGas(tx, target); err != nil || n != wantN || ok != wantOk {
+			t.Fatalf("gas %d: block %d %v, err %v, want %d %v", target, n, ok, err, wantN, wantOk)
+		}
+	}
+	for target := uint64(0); target <= totalTxs[head]+1; target++ {
+		wantN, wantOk := find(totalTxs[:], target)
+		if n, ok, err := FindBlockByCumulativeTxCount(tx, target); err != nil || n != wantN || ok != wantOk {
+			t.Fatalf("txs %d: block %d %v, err %v, want %d %v", target, n, ok, err, wantN, wantOk)
+		}
+	}
+
+	for _, c := range []struct{ from, budget, want uint64 }{
+		{1, 1000, 1},             // block 1 alone
+		{1, 5999, 2},             // blocks 1 and 2 use 3000
+		{1, 6000, 4},             // blocks 1..3 use exactly 6000, empty block 4 fits too
+		{3, 1, 1},                // a block over budget still counts
+		{4, 5000, 2},             // empty block 4 and block 5
+		{30, 1 << 40, head - 29}, // up to the last indexed block
+	} {
+		if n, err := EstimateBlocksForGasBudget(tx, c.from, c.budget); err != nil || n != c.want {
+			t.Fatalf("from %d budget %d: %d blocks, err %v, want %d", c.from, c.budget, n, err, c.want)
+		}
+	}
+
+	// a reorg to block 30 drops the totals above it, the search tolerates gaps
+	if err := TruncateCumulativeIndexes(tx, 31); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok, err := ReadCumulativeIndexes(tx, 31); err != nil || ok {
+		t.Fatalf("totals of block 31 after truncate, err %v", err)
+	}
+	for _, n := range []uint64{5, 6, 7, 8, 9} {
+		if err := tx.Delete(modules.CumulativeGasIndex, modules.EncodeBlockNumber(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, ok, err := FindBlockByCumulativeGas(tx, totalGas[6]); err != nil || !ok || n != 10 {
+		t.Fatalf("gas in a gap: block %d %v, err %v, want 10", n, ok, err)
+	}
+	if n, ok, err := FindBlockByCumulativeGas(tx, totalGas[30]+1); err != nil || ok {
+		t.Fatalf("gas beyond the truncated index: block %d %v, err %v", n, ok, err)
+	}
+	if n, err := EstimateBlocksForGasBudget(tx, 7, 1000); err != nil || n != 0 {
+		t.Fatalf("estimate from a gap: %d blocks, err %v, want 0", n, err)
+	}
+}
+
+func TestRepairCumulativeIndexes(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	const head = 20
+	var gas, txs uint64
+	want := make(map[uint64][2]uint64)
+	for n := uint64(0); n <= head; n++ {
+		// receipts of the first blocks are pruned
+		writeCumulativeBlock(t, tx, n, n < 6)
+		g, c := cumulativeGas(n)
+		gas, txs = gas+g, txs+c
+		want[n] = [2]uint64{gas, txs}
+		if n <= 12 {
+			if err := WriteCumulativeIndexes(tx, n, gas, txs); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// blocks 13..20 were imported before the index existed, 7 is lost,
+	// 9 fell below its parent and 11 is off
+	if err := tx.Delete(modules.CumulativeTransactionIndex, modules.EncodeBlockNumber(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCumulativeIndexes(tx, 9, 1, want[9][1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCumulativeIndexes(tx, 11, want[11][0]+1, want[11][1]); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairCumulativeIndexes(tx, 8, head, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &CumulativeIndexReport{
+		From:         8,
+		To:           head,
+		Missing:      []uint64{13, 14, 15, 16, 17, 18, 19, 20},
+		NonMonotonic: []uint64{9},
+		Mismatched:   []uint64{11},
+	}
+	if !reflect.DeepEqual(report, expect) {
+		t.Fatalf("dry run report %+v, want %+v", report, expect)
+	}
+	if _, _, ok, err := ReadCumulativeIndexes(tx, 13); err != nil || ok {
+		t.Fatalf("dry run wrote totals of block 13, err %v", err)
+	}
+
+	if report, err = RepairCumulativeIndexes(tx, 0, head, false); err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != 11 || !reflect.DeepEqual(report.Missing, []uint64{7, 13, 14, 15, 16, 17, 18, 19, 20}) {
+		t.Fatalf("repair report %+v", report)
+	}
+	for n := uint64(0); n <= head; n++ {
+		gas, txs, ok, err := ReadCumulativeIndexes(tx, n)
+		if err != nil || !ok || [2]uint64{gas, txs} != want[n] {
+			t.Fatalf("block %d after repair: %d gas %d txs %v, err %v, want %v", n, gas, txs, ok, err, want[n])
+		}
+	}
+	if report, err = RepairCumulativeIndexes(tx, 0, head, false); err != nil || report.Repaired != 0 {
+		t.Fatalf("second repair %+v, err %v", report, err)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/avm/rlp"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// eventJournalAckKey tracks the highest sequence acknowledged by consumers.
+var eventJournalAckKey = []byte("EventJournalAck")
+
+// JournalEventKind is the type of a journaled chain event.
+type JournalEventKind uint8
+
+const (
+	// JournalNewBlock is appended when a block becomes the canonical head.
+	JournalNewBlock JournalEventKind = iota + 1
+	// JournalLogs is appended after JournalNewBlock for blocks emitting logs.
+	JournalLogs
+	// JournalReorg is appended when canonical blocks are unwound. Number
+	// and Hash are the common ancestor, Unwound lists the removed blocks
+	// from the old head down.
+	JournalReorg
+)
+
+func (k JournalEventKind) String() string {
+	switch k {
+	case JournalNewBlock:
+		return "newBlock"
+	case JournalLogs:
+		return "logs"
+	case JournalReorg:
+		return "reorg"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+// JournalEvent is an entry of the durable event journal.
+type JournalEvent struct {
+	Seq      uint64           `json:"seq" rlp:"-"`
+	Kind     JournalEventKind `json:"kind"`
+	Time     uint64           `json:"time"`
+	Number   uint64           `json:"number"`
+	Hash     types.Hash       `json:"hash"`
+	LogCount uint64           `json:"logCount,omitempty"`
+	Unwound  []types.Hash     `json:"unwound,omitempty"`
+}
+
+// EventJournalRetention bounds the journal size. Entries at or below the
+// acknowledged sequence are always prunable.
+type EventJournalRetention struct {
+	MaxAge time.Duration // zero keeps unacknowledged entries forever
+}
+
+// AppendJournalEvent assigns the next sequence number to ev and appends it.
+// Sequences come from the table sequence, so they are never reused, even
+// after pruning, and a rolled back transaction leaves no gap.
+func AppendJournalEvent(tx kv.RwTx, ev *JournalEvent) error {
+	base, err := tx.IncrementSequence(modules.EventJournal, 1)
+	if err != nil {
+		return err
+	}
+	data, err := rlp.EncodeToBytes(ev)
+	if err != nil {
+		return err
+	}
+	ev.Seq = base + 1
+	return tx.Append(modules.EventJournal, modules.EncodeBlockNumber(ev.Seq), data)
+}
+
+// ReadJournalEvents returns up to limit events with a sequence greater than
+// afterSeq, in sequence order.
+func ReadJournalEvents(tx kv.Tx, afterSeq uint64, limit int) ([]*JournalEvent, error) {
+	var events []*JournalEvent
+	if limit <= 0 {
+		return events, nil
+	}
+	c, err := tx.Cursor(modules.EventJournal)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	for k, v, err := c.Seek(modules.EncodeBlockNumber(afterSeq + 1)); k != nil && len(events) < limit; k, v, err = c.Next() {
+		if err != nil {
+			return nil, err
+		}
+		ev := new(JournalEvent)
+		if err := rlp.DecodeBytes(v, ev); err != nil {
+			return nil, fmt.Errorf("invalid journal event %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		ev.Seq = binary.BigEndian.Uint64(k)
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// ReadJournalHead returns the sequence of the last appended event, zero if
+// nothing was ever appended.
+func ReadJournalHead(tx kv.Tx) (uint64, error) {
+	return tx.ReadSequence(modules.EventJournal)
+}
+
+// ReadJournalAck returns the highest acknowledged sequence.
+func ReadJournalAck(db kv.Getter) (uint64, error) {
+	data, err := db.GetOne(modules.DatabaseInfo, eventJournalAckKey)
+	if err != nil || len(data) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// WriteJournalAck records that consumers processed every event up to seq.
+// The acknowledged position never moves backwards.
+func WriteJournalAck(tx kv.RwTx, seq uint64) error {
+	current, err := ReadJournalAck(tx)
+	if err != nil {
+		return err
+	}
+	if seq <= current {
+		return nil
+	}
+	head, err := ReadJournalHead(tx)
+	if err != nil {
+		return err
+	}
+	if seq > head {
+		return fmt.Errorf("ack %d is beyond journal head %d", seq, head)
+	}
+	return tx.Put(modules.DatabaseInfo, eventJournalAckKey, modules.EncodeBlockNumber(seq))
+}
+
+// PruneEventJournal deletes acknowledged entries and entries older than
+// the retention age, and returns the number of deleted entries. Pruning
+// only ever removes a prefix of the journal, so consumers never observe
+// holes.
+func PruneEventJournal(tx kv.RwTx, retention EventJournalRetention, now time.Time) (int, error) {
+	ack, err := ReadJournalAck(tx)
+	if err != nil {
+		return 0, err
+	}
+	var cutoff uint64
+	if retention.MaxAge > 0 {
+		if t := now.Add(-retention.MaxAge).Unix(); t > 0 {
+			cutoff = uint64(t)
+		}
+	}
+
+	c, err := tx.RwCursor(modules.EventJournal)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	var pruned int
+	for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+		if err != nil {
+			return pruned, err
+		}
+		if binary.BigEndian.Uint64(k) > ack {
+			ev := new(JournalEvent)
+			if err := rlp.DecodeBytes(v, ev); err != nil {
+				return pruned, err
+			}
+			if ev.Time >= cutoff {
+				break
+			}
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+func openJournalDB(t testing.TB, path string) kv.RwDB {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(path).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	return db
+}
+
+func appendEvents(t *testing.T, tx kv.RwTx, from, n uint64) {
+	for i := from; i < from+n; i++ {
+		ev := &JournalEvent{Kind: JournalNewBlock, Time: uint64(time.Now().Unix()), Number: i, Hash: types.Hash{byte(i)}}
+		if err := AppendJournalEvent(tx, ev); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+func checkJournal(t *testing.T, db kv.RwDB, first, last uint64) {
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		events, err := ReadJournalEvents(tx, 0, 100)
+		if err != nil {
+			return err
+		}
+		if uint64(len(events)) != last-first+1 {
+			t.Fatalf("have %d events, want %d", len(events), last-first+1)
+		}
+		for i, ev := range events {
+			if ev.Seq != first+uint64(i) {
+				t.Fatalf("event %d has seq %d, want %d", i, ev.Seq, first+uint64(i))
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestJournalCrashRestart checks that events published in a committed
+// transaction survive a restart, and that a crash before commit neither
+// loses committed events nor leaves a hole in the sequence.
+func TestJournalCrashRestart(t *testing.T) {
+	path := t.TempDir()
+	db := openJournalDB(t, path)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		appendEvents(t, tx, 1, 3)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash in the middle of publishing: the transaction is never committed.
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendEvents(t, tx, 4, 2)
+	tx.Rollback()
+	db.Close()
+
+	db = openJournalDB(t, path)
+	defer db.Close()
+	checkJournal(t, db, 1, 3)
+
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		appendEvents(t, tx, 4, 2)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, db, 1, 5)
+
+	// Pruning acknowledged entries keeps sequences monotonic.
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		if err := WriteJournalAck(tx, 2); err != nil {
+			return err
+		}
+		if n, err := PruneEventJournal(tx, EventJournalRetention{}, time.Now()); err != nil || n != 2 {
+			t.Fatalf("pruned %d (%v), want 2", n, err)
+		}
+		appendEvents(t, tx, 6, 1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, db, 3, 6)
+}
+
+func TestJournalRetention(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	now := time.Now()
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Minute} {
+			ev := &JournalEvent{Kind: JournalNewBlock, Time: uint64(now.Add(-age).Unix()), Number: uint64(i)}
+			if err := AppendJournalEvent(tx, ev); err != nil {
+				return err
+			}
+		}
+		if err := WriteJournalAck(tx, 5); err == nil {
+			t.Fatalf("ack beyond head accepted")
+		}
+		n, err := PruneEventJournal(tx, EventJournalRetention{MaxAge: time.Hour}, now)
+		if err != nil || n != 2 {
+			t.Fatalf("pruned %d (%v), want 2", n, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkJournal(t, db, 3, 3)
+}
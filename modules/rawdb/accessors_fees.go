@@ -0,0 +1,383 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/avm/rlp"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/etl"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// FeeAccountingShard is the number of blocks summed up by one FeeAccounting
+// record. Ranges not aligned to shards are completed from the per-block
+// FeeAccountingChanges, so a query reads at most two shards worth of blocks.
+const FeeAccountingShard = 4096
+
+// feeAccountingShard is FeeAccountingShard, tests shrink it.
+var feeAccountingShard uint64 = FeeAccountingShard
+
+// FeeAccount holds fee totals of an address. GasUsed and FeesPaid are of the
+// transactions it sent, TipsReceived are the priority fees it earned as block
+// fee recipient. Amounts are in wei.
+type FeeAccount struct {
+	GasUsed      uint64
+	FeesPaid     *big.Int
+	TipsReceived *big.Int
+}
+
+func NewFeeAccount() *FeeAccount {
+	return &FeeAccount{FeesPaid: new(big.Int), TipsReceived: new(big.Int)}
+}
+
+func (a *FeeAccount) add(b *FeeAccount) {
+	a.GasUsed += b.GasUsed
+	a.FeesPaid.Add(a.FeesPaid, b.FeesPaid)
+	a.TipsReceived.Add(a.TipsReceived, b.TipsReceived)
+}
+
+func (a *FeeAccount) sub(b *FeeAccount) {
+	a.GasUsed -= b.GasUsed
+	a.FeesPaid.Sub(a.FeesPaid, b.FeesPaid)
+	a.TipsReceived.Sub(a.TipsReceived, b.TipsReceived)
+}
+
+func (a *FeeAccount) isZero() bool {
+	return a.GasUsed == 0 && a.FeesPaid.Sign() == 0 && a.TipsReceived.Sign() == 0
+}
+
+func decodeFeeAccount(data []byte) (*FeeAccount, error) {
+	a := NewFeeAccount()
+	if len(data) == 0 {
+		return a, nil
+	}
+	if err := rlp.DecodeBytes(data, a); err != nil {
+		return nil, fmt.Errorf("invalid fee account: %w", err)
+	}
+	return a, nil
+}
+
+// FeeChange is the contribution of one block to the fee account of Address.
+type FeeChange struct {
+	Address types.Address
+	Account *FeeAccount
+}
+
+// effectiveFees returns the gas price paid by tx and the part of it the fee
+// recipient earns under baseFee.
+func effectiveFees(tx *transaction.Transaction, baseFee *uint256.Int) (price, tip *big.Int) {
+	if baseFee == nil {
+		price = tx.GasPrice().ToBig()
+		return price, price
+	}
+	base := baseFee.ToBig()
+	price = new(big.Int).Add(base, tx.GasTipCap().ToBig())
+	if feeCap := tx.GasFeeCap().ToBig(); feeCap.Cmp(price) < 0 {
+		price = feeCap
+	}
+	tip = new(big.Int).Sub(price, base)
+	if tip.Sign() < 0 {
+		tip.SetUint64(0)
+	}
+	return price, tip
+}
+
+// BlockFeeChanges computes the fee changes of b from its receipts, ordered by address.
+func BlockFeeChanges(b block.IBlock, receipts block.Receipts) ([]*FeeChange, error) {
+	txs := b.Transactions()
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	if len(receipts) != len(txs) {
+		return nil, fmt.Errorf("block %d has %d transactions and %d receipts", b.Number64().Uint64(), len(txs), len(receipts))
+	}
+	header := b.Header().(*block.Header)
+	accounts := make(map[types.Address]*FeeAccount)
+	account := func(addr types.Address) *FeeAccount {
+		a, ok := accounts[addr]
+		if !ok {
+			a = NewFeeAccount()
+			accounts[addr] = a
+		}
+		return a
+	}
+	for i, tx := range txs {
+		from := tx.From()
+		if from == nil {
+			return nil, fmt.Errorf("transaction %d of block %d has no sender", i, b.Number64().Uint64())
+		}
+		price, tip := effectiveFees(tx, header.BaseFee)
+		gas := new(big.Int).SetUint64(receipts[i].GasUsed)
+
+		sender := account(*from)
+		sender.GasUsed += receipts[i].GasUsed
+		sender.FeesPaid.Add(sender.FeesPaid, new(big.Int).Mul(gas, price))
+		recipient := account(header.Coinbase)
+		recipient.TipsReceived.Add(recipient.TipsReceived, new(big.Int).Mul(gas, tip))
+	}
+
+	changes := make([]*FeeChange, 0, len(accounts))
+	for addr, a := range accounts {
+		if !a.isZero() {
+			changes = append(changes, &FeeChange{Address: addr, Account: a})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return bytes.Compare(changes[i].Address[:], changes[j].Address[:]) < 0 })
+	return changes, nil
+}
+
+func feeShardKey(addr types.Address, shard uint64) []byte {
+	k := make([]byte, types.AddressLength+8)
+	copy(k, addr[:])
+	binary.BigEndian.PutUint64(k[types.AddressLength:], shard)
+	return k
+}
+
+func readFeeChanges(db kv.Getter, number uint64, hash types.Hash) ([]*FeeChange, error) {
+	data, err := db.GetOne(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash))
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	var changes []*FeeChange
+	if err := rlp.DecodeBytes(data, &changes); err != nil {
+		return nil, fmt.Errorf("invalid fee changes of block %d: %w", number, err)
+	}
+	return changes, nil
+}
+
+// updateFeeShard applies delta to the shard of addr holding number, empty shards are deleted.
+func updateFeeShard(tx kv.RwTx, addr types.Address, number uint64, delta *FeeAccount, apply func(a, delta *FeeAccount)) error {
+	k := feeShardKey(addr, number/feeAccountingShard)
+	data, err := tx.GetOne(modules.FeeAccounting, k)
+	if err != nil {
+		return err
+	}
+	a, err := decodeFeeAccount(data)
+	if err != nil {
+		return err
+	}
+	apply(a, delta)
+	if a.isZero() {
+		return tx.Delete(modules.FeeAccounting, k)
+	}
+	if data, err = rlp.EncodeToBytes(a); err != nil {
+		return err
+	}
+	return tx.Put(modules.FeeAccounting, k, data)
+}
+
+// AppendFeeAccounting adds the fees of a new canonical block to the index. A
+// block already indexed is skipped.
+func AppendFeeAccounting(tx kv.RwTx, b block.IBlock, receipts block.Receipts) error {
+	number, hash := b.Number64().Uint64(), b.Hash()
+	if ok, err := tx.Has(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash)); err != nil || ok {
+		return err
+	}
+	changes, err := BlockFeeChanges(b, receipts)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	data, err := rlp.EncodeToBytes(changes)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash), data); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := updateFeeShard(tx, c.Address, number, c.Account, (*FeeAccount).add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnwindFeeAccounting removes the fees of an unwound block from the index.
+func UnwindFeeAccounting(tx kv.RwTx, number uint64, hash types.Hash) error {
+	changes, err := readFeeChanges(tx, number, hash)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	for _, c := range changes {
+		if err := updateFeeShard(tx, c.Address, number, c.Account, (*FeeAccount).sub); err != nil {
+			return err
+		}
+	}
+	return tx.Delete(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash))
+}
+
+// ReadFeeAccounting sums the fees of addr over blocks from..to inclusive.
+func ReadFeeAccounting(tx kv.Tx, addr types.Address, from, to uint64) (*FeeAccount, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid block range %d..%d", from, to)
+	}
+	res := NewFeeAccount()
+	// shards [firstFull, lastFull) lie within the range
+	firstFull, lastFull := (from+feeAccountingShard-1)/feeAccountingShard, (to+1)/feeAccountingShard
+	if firstFull >= lastFull {
+		return res, addBlockFees(tx, addr, from, to, res)
+	}
+	if err := addShardFees(tx, addr, firstFull, lastFull, res); err != nil {
+		return nil, err
+	}
+	if from < firstFull*feeAccountingShard {
+		if err := addBlockFees(tx, addr, from, firstFull*feeAccountingShard-1, res); err != nil {
+			return nil, err
+		}
+	}
+	if lastFull*feeAccountingShard <= to {
+		if err := addBlockFees(tx, addr, lastFull*feeAccountingShard, to, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func addShardFees(tx kv.Tx, addr types.Address, from, to uint64, res *FeeAccount) error {
+	c, err := tx.Cursor(modules.FeeAccounting)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, v, err := c.Seek(feeShardKey(addr, from)); ; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if k == nil {
+			return nil
+		}
+		if !bytes.HasPrefix(k, addr[:]) || binary.BigEndian.Uint64(k[types.AddressLength:]) >= to {
+			return nil
+		}
+		a, err := decodeFeeAccount(v)
+		if err != nil {
+			return err
+		}
+		res.add(a)
+	}
+}
+
+func addBlockFees(tx kv.Tx, addr types.Address, from, to uint64, res *FeeAccount) error {
+	c, err := tx.Cursor(modules.FeeAccountingChanges)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, v, err := c.Seek(modules.EncodeBlockNumber(from)); ; k, v, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if k == nil || binary.BigEndian.Uint64(k) > to {
+			return nil
+		}
+		var changes []*FeeChange
+		if err := rlp.DecodeBytes(v, &changes); err != nil {
+			return fmt.Errorf("invalid fee changes %x: %w", k, err)
+		}
+		i := sort.Search(len(changes), func(i int) bool { return bytes.Compare(changes[i].Address[:], addr[:]) >= 0 })
+		if i < len(changes) && changes[i].Address == addr {
+			res.add(changes[i].Account)
+		}
+	}
+}
+
+// BackfillFeeAccounting indexes the canonical blocks from..to which are not
+// indexed yet, from their stored receipts. Shard totals are merged through an
+// ETL collector in tmpdir. It returns the number of blocks indexed.
+func BackfillFeeAccounting(tx kv.RwTx, from, to uint64, tmpdir string, quit <-chan struct{}) (int, error) {
+	collector := etl.NewCollector("FeeAccounting", tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer collector.Close()
+
+	indexed := 0
+	for number := from; number <= to; number++ {
+		if err := libcommon.Stopped(quit); err != nil {
+			return indexed, err
+		}
+		hash, err := ReadCanonicalHash(tx, number)
+		if err != nil {
+			return indexed, err
+		}
+		if hash == (types.Hash{}) {
+			break
+		}
+		if ok, err := tx.Has(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash)); err != nil {
+			return indexed, err
+		} else if ok {
+			continue
+		}
+		b := ReadBlock(tx, hash, number)
+		if b == nil {
+			return indexed, fmt.Errorf("canonical block %d %x is missing", number, hash)
+		}
+		changes, err := BlockFeeChanges(b, ReadRawReceipts(tx, number))
+		if err != nil {
+			return indexed, err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		data, err := rlp.EncodeToBytes(changes)
+		if err != nil {
+			return indexed, err
+		}
+		if err := tx.Put(modules.FeeAccountingChanges, modules.BlockBodyKey(number, hash), data); err != nil {
+			return indexed, err
+		}
+		for _, c := range changes {
+			if data, err = rlp.EncodeToBytes(c.Account); err != nil {
+				return indexed, err
+			}
+			if err := collector.Collect(feeShardKey(c.Address, number/feeAccountingShard), data); err != nil {
+				return indexed, err
+			}
+		}
+		indexed++
+	}
+	return indexed, collector.Load(tx, modules.FeeAccounting, mergeFeeAccounts, etl.TransformArgs{Quit: quit})
+}
+
+// mergeFeeAccounts adds the collected delta to the shard total in the table.
+func mergeFeeAccounts(k, v []byte, table etl.CurrentTableReader, next etl.LoadNextFunc) error {
+	data, err := table.Get(k)
+	if err != nil {
+		return err
+	}
+	a, err := decodeFeeAccount(data)
+	if err != nil {
+		return err
+	}
+	delta, err := decodeFeeAccount(v)
+	if err != nil {
+		return err
+	}
+	a.add(delta)
+	if data, err = rlp.EncodeToBytes(a); err != nil {
+		return err
+	}
+	return next(k, k, data)
+}
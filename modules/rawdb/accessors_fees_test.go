@@ -0,0 +1,191 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+var (
+	feeSenders   = []types.Address{{0xa1}, {0xa2}, {0xa3}}
+	feeCoinbases = []types.Address{{0xc1}, {0xa2}} // 0xa2 both sends and earns tips
+)
+
+// feeBlock builds block number with n transactions, legacy ones before block
+// 16 and dynamic fee ones after, salt makes sibling blocks differ.
+func feeBlock(number uint64, n int, salt uint64) (*block.Block, block.Receipts) {
+	header := &block.Header{
+		Number:     uint256.NewInt(number),
+		Coinbase:   feeCoinbases[number%2],
+		BaseFee:    uint256.NewInt(0),
+		Difficulty: uint256.NewInt(1),
+		Time:       number + salt,
+	}
+	if number >= 16 {
+		header.BaseFee = uint256.NewInt(5)
+	}
+	to := types.Address{0xee}
+	txs := make([]*transaction.Transaction, n)
+	receipts := make(block.Receipts, n)
+	for i := range txs {
+		from := feeSenders[(int(number)+i)%len(feeSenders)]
+		nonce := number*100 + uint64(i)
+		if number < 16 {
+			txs[i] = transaction.NewTx(&transaction.LegacyTx{
+				Nonce: nonce, GasPrice: uint256.NewInt(3 + salt), Gas: 50000, To: &to, From: &from, Value: uint256.NewInt(1),
+			})
+		} else {
+			txs[i] = transaction.NewTx(&transaction.DynamicFeeTx{
+				ChainID: uint256.NewInt(1), Nonce: nonce, GasTipCap: uint256.NewInt(uint64(i%3) + salt), GasFeeCap: uint256.NewInt(7),
+				Gas: 50000, To: &to, From: &from, Value: uint256.NewInt(1),
+			})
+		}
+		receipts[i] = &block.Receipt{Status: 1, GasUsed: 21000 + uint64(i)*1000, BlockNumber: uint256.NewInt(number), TransactionIndex: uint(i)}
+	}
+	return block.NewBlock(header, txs).(*block.Block), receipts
+}
+
+func writeFeeBlock(t *testing.T, tx kv.RwTx, b *block.Block, receipts block.Receipts) {
+	t.Helper()
+	if err := WriteBlock(tx, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCanonicalHash(tx, b.Hash(), b.Number64().Uint64()); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteReceipts(tx, b.Number64().Uint64(), receipts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scanFees sums the fees of addr over from..to from the canonical blocks and receipts.
+func scanFees(t *testing.T, tx kv.Tx, addr types.Address, from, to uint64) *FeeAccount {
+	t.Helper()
+	res := NewFeeAccount()
+	for number := from; number <= to; number++ {
+		hash, err := ReadCanonicalHash(tx, number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := ReadBlock(tx, hash, number)
+		if b == nil {
+			continue
+		}
+		base := b.Header().(*block.Header).BaseFee.ToBig()
+		for i, r := range ReadRawReceipts(tx, number) {
+			txn := b.Transactions()[i]
+			price := txn.GasPrice().ToBig()
+			if txn.Type() == transaction.DynamicFeeTxType {
+				price = new(big.Int).Add(base, txn.GasTipCap().ToBig())
+				if price.Cmp(txn.GasFeeCap().ToBig()) > 0 {
+					price = txn.GasFeeCap().ToBig()
+				}
+			}
+			gas := new(big.Int).SetUint64(r.GasUsed)
+			if *txn.From() == addr {
+				res.GasUsed += r.GasUsed
+				res.FeesPaid.Add(res.FeesPaid, new(big.Int).Mul(gas, price))
+			}
+			if b.Coinbase() == addr {
+				res.TipsReceived.Add(res.TipsReceived, new(big.Int).Mul(gas, new(big.Int).Sub(price, base)))
+			}
+		}
+	}
+	return res
+}
+
+func TestFeeAccounting(t *testing.T) {
+	defer func(shard uint64) { feeAccountingShard = shard }(feeAccountingShard)
+	feeAccountingShard = 8
+
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	const head = 40
+	for number := uint64(1); number <= head; number++ {
+		// every seventh block is empty
+		b, receipts := feeBlock(number, int(number%7), 0)
+		writeFeeBlock(t, tx, b, receipts)
+		if number <= 12 {
+			if err := AppendFeeAccounting(tx, b, receipts); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	indexed, err := BackfillFeeAccounting(tx, 1, head, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 28 - 4; indexed != want { // blocks 13..40 less the empty 14, 21, 28 and 35
+		t.Fatalf("backfilled %d blocks, want %d", indexed, want)
+	}
+
+	// reorg of the head block onto a sibling with other tips
+	old := ReadBlock(tx, mustCanonicalHash(t, tx, head), head)
+	if err := UnwindFeeAccounting(tx, head, old.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	b, receipts := feeBlock(head, 3, 1)
+	writeFeeBlock(t, tx, b, receipts)
+	if err := AppendFeeAccounting(tx, b, receipts); err != nil {
+		t.Fatal(err)
+	}
+	// appending again is a no-op
+	if err := AppendFeeAccounting(tx, b, receipts); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := [][2]uint64{{1, head}, {0, 7}, {8, 15}, {3, 5}, {5, 30}, {8, 39}, {17, 17}, {33, head}}
+	for _, addr := range append(append([]types.Address{{0xff}}, feeSenders...), feeCoinbases...) {
+		for _, r := range ranges {
+			have, err := ReadFeeAccounting(tx, addr, r[0], r[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := scanFees(t, tx, addr, r[0], r[1])
+			if have.GasUsed != want.GasUsed || have.FeesPaid.Cmp(want.FeesPaid) != 0 || have.TipsReceived.Cmp(want.TipsReceived) != 0 {
+				t.Fatalf("%x blocks %d..%d: have %d %v %v, want %d %v %v", addr, r[0], r[1],
+					have.GasUsed, have.FeesPaid, have.TipsReceived, want.GasUsed, want.FeesPaid, want.TipsReceived)
+			}
+		}
+	}
+	if _, err := ReadFeeAccounting(tx, feeSenders[0], 5, 4); err == nil {
+		t.Fatal("inverted range accepted")
+	}
+}
+
+func mustCanonicalHash(t *testing.T, tx kv.Tx, number uint64) types.Hash {
+	t.Helper()
+	hash, err := ReadCanonicalHash(tx, number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
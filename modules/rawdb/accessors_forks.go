@@ -0,0 +1,225 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	staleForkBlocks = metrics.NewRegisteredCounter("db/forks/pruned/blocks", nil)
+	staleForkRows   = metrics.NewRegisteredCounter("db/forks/pruned/rows", nil)
+	staleForkTxs    = metrics.NewRegisteredCounter("db/forks/pruned/txs", nil)
+)
+
+// StaleForkStats - what one PruneStaleForks pass did
+type StaleForkStats struct {
+	Blocks      uint64 // non-canonical blocks removed
+	Rows        uint64 // rows deleted, transactions included
+	Txs         uint64 // transaction records deleted
+	RetainedTxs uint64 // transactions of removed blocks left in place, see PruneStaleForks
+	Next        uint64 // height the next pass starts at
+}
+
+// staleForkNextKey is the DatabaseInfo key of the height the next
+// PruneStaleForks pass starts at.
+var staleForkNextKey = []byte("StaleForkNext")
+
+// ReadStaleForkNext returns the height the next stale fork pass starts at, 0
+// if no pass ran yet.
+func ReadStaleForkNext(db kv.Getter) (uint64, error) {
+	data, err := db.GetOne(modules.DatabaseInfo, staleForkNextKey)
+	if err != nil || len(data) != 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// WriteStaleForkNext records the Next of a stale fork pass, in the tx of the
+// pass so a restart neither repeats nor skips heights.
+func WriteStaleForkNext(tx kv.Putter, next uint64) error {
+	return tx.Put(modules.DatabaseInfo, staleForkNextKey, modules.EncodeBlockNumber(next))
+}
+
+// PruneStaleForks deletes the headers, bodies, total difficulties, senders,
+// verifiers and rewards of non-canonical blocks at heights [from, below). A
+// pass looks at about limit headers, finishing the height it is in, and
+// reports in Next where the next one starts. Blocks for which keep returns
+// true, and heights without a canonical block, are left alone.
+//
+// Transaction ids name a record only together with the table holding it (see
+// ReadSequence). Fork bodies point into BlockTx unless their block was
+// demoted with DemoteCanonicalTxs, so the transactions are deleted only while
+// NonCanonicalTxs never handed out an id, and only if none of them is owned
+// by a canonical body. Otherwise they are retained and counted in RetainedTxs.
+func PruneStaleForks(tx kv.RwTx, from, below uint64, limit int, keep func(types.Hash) bool) (StaleForkStats, error) {
+	stats := StaleForkStats{Next: from}
+	if from >= below {
+		return stats, nil
+	}
+	demoted, err := ReadSequence(tx, modules.NonCanonicalTxs)
+	if err != nil {
+		return stats, err
+	}
+
+	c, err := tx.Cursor(modules.Headers)
+	if err != nil {
+		return stats, err
+	}
+	defer c.Close()
+	var (
+		forks     [][]byte
+		canonical types.Hash
+		height    uint64
+		examined  int
+	)
+	stats.Next = below
+	for k, _, err := c.Seek(modules.EncodeBlockNumber(from)); k != nil; k, _, err = c.Next() {
+		if err != nil {
+			return stats, err
+		}
+		n := binary.BigEndian.Uint64(k[:8])
+		if n >= below {
+			break
+		}
+		if examined == 0 || n != height {
+			if examined >= limit {
+				stats.Next = n
+				break
+			}
+			if canonical, err = ReadCanonicalHash(tx, n); err != nil {
+				return stats, err
+			}
+			height = n
+		}
+		examined++
+		hash := types.BytesToHash(k[8:])
+		if canonical == (types.Hash{}) || hash == canonical || (keep != nil && keep(hash)) {
+			continue
+		}
+		forks = append(forks, types.CopyBytes(k))
+	}
+
+	for _, k := range forks {
+		if err := deleteForkBlock(tx, k, demoted == 0, &stats); err != nil {
+			return stats, err
+		}
+		stats.Blocks++
+	}
+	staleForkBlocks.Inc(int64(stats.Blocks))
+	staleForkRows.Inc(int64(stats.Rows))
+	staleForkTxs.Inc(int64(stats.Txs))
+	return stats, nil
+}
+
+// deleteForkBlock - deletes the records of the block keyed number + hash
+func deleteForkBlock(tx kv.RwTx, key []byte, deleteTxs bool, stats *StaleForkStats) error {
+	number := binary.BigEndian.Uint64(key[:8])
+	hash := types.BytesToHash(key[8:])
+
+	body, err := ReadBodyForStorageByKey(tx, key)
+	if err != nil {
+		return err
+	}
+	if body != nil && body.TxAmount > 2 {
+		owned := deleteTxs
+		if owned {
+			if owned, err = forkOwnsTxs(tx, body.BaseTxId, uint64(body.TxAmount)); err != nil {
+				return err
+			}
+		}
+		if !owned {
+			stats.RetainedTxs += uint64(body.TxAmount) - 2
+		} else {
+			for id := body.BaseTxId; id < body.BaseTxId+uint64(body.TxAmount); id++ {
+				if err := deleteRow(tx, modules.BlockTx, modules.EncodeBlockNumber(id), stats); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if n := ReadHeaderNumber(tx, hash); n != nil && *n == number {
+		if err := deleteRow(tx, modules.HeaderNumber, hash[:], stats); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{modules.Headers, modules.BlockBody, modules.HeaderTD, modules.Senders, modules.BlockVerify, modules.BlockRewards} {
+		if err := deleteRow(tx, table, key, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forkOwnsTxs - false if a transaction record in BlockTx ids [base, base+amount) belongs to a
+// canonical body, as after an unwind handed the ids out again
+func forkOwnsTxs(tx kv.Getter, base, amount uint64) (bool, error) {
+	for id := base + 1; id < base+amount-1; id++ {
+		v, err := tx.GetOne(modules.BlockTx, modules.EncodeBlockNumber(id))
+		if err != nil {
+			return false, err
+		}
+		if v == nil {
+			continue
+		}
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(v); err != nil {
+			return false, err
+		}
+		n, err := ReadTxLookupEntry(tx, txn.Hash())
+		if err != nil {
+			return false, err
+		}
+		if n == nil {
+			continue
+		}
+		canonical, err := ReadCanonicalHash(tx, *n)
+		if err != nil {
+			return false, err
+		}
+		body, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(*n, canonical))
+		if err != nil {
+			return false, err
+		}
+		if body != nil && id >= body.BaseTxId && id < body.BaseTxId+uint64(body.TxAmount) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func deleteRow(tx kv.RwTx, table string, key []byte, stats *StaleForkStats) error {
+	has, err := tx.Has(table, key)
+	if err != nil || !has {
+		return err
+	}
+	if err := tx.Delete(table, key); err != nil {
+		return err
+	}
+	stats.Rows++
+	if table == modules.BlockTx {
+		stats.Txs++
+	}
+	return nil
+}
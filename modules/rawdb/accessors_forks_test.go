@@ -0,0 +1,254 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// putForkTestBlock writes header, td and body of a block, which salt tells
+// apart from its siblings, and makes it canonical if asked.
+func putForkTestBlock(t *testing.T, tx kv.RwTx, number, salt uint64, txs []*transaction.Transaction, canonical bool) types.Hash {
+	t.Helper()
+	header := &block.Header{
+		Number:     uint256.NewInt(number),
+		BaseFee:    uint256.NewInt(0),
+		Difficulty: uint256.NewInt(1),
+		Time:       number*10 + salt,
+	}
+	hash := header.Hash()
+	WriteHeader(tx, header)
+	if err := WriteTd(tx, hash, number, uint256.NewInt(number)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBody(tx, hash, number, &block.Body{Txs: txs}); err != nil {
+		t.Fatal(err)
+	}
+	if !canonical {
+		return hash
+	}
+	if err := WriteCanonicalHash(tx, hash, number); err != nil {
+		t.Fatal(err)
+	}
+	for _, txn := range txs {
+		if err := WriteTxLookupEntry(tx, txn.Hash(), number); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return hash
+}
+
+func blockRows(t *testing.T, tx kv.Tx, number uint64, hash types.Hash) (rows int) {
+	t.Helper()
+	key := modules.BlockBodyKey(number, hash)
+	for _, table := range []string{modules.Headers, modules.BlockBody, modules.HeaderTD} {
+		if has, err := tx.Has(table, key); err != nil {
+			t.Fatal(err)
+		} else if has {
+			rows++
+		}
+	}
+	if has, err := tx.Has(modules.HeaderNumber, hash[:]); err != nil {
+		t.Fatal(err)
+	} else if has {
+		rows++
+	}
+	return rows
+}
+
+func TestPruneStaleForks(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, senders := signedTxs(t, []*ecdsa.PrivateKey{key}, 12)
+	for i, txn := range txs {
+		txn.SetFrom(senders[i])
+	}
+
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	canonical := map[uint64]types.Hash{}
+	for n := uint64(0); n <= 20; n++ {
+		var blockTxs []*transaction.Transaction
+		if n == 8 {
+			blockTxs = txs[:2]
+		}
+		canonical[n] = putForkTestBlock(t, tx, n, 0, blockTxs, true)
+	}
+	type fork struct {
+		number uint64
+		hash   types.Hash
+		txs    []*transaction.Transaction
+		pruned bool
+	}
+	forks := []*fork{
+		{number: 3, txs: txs[2:4], pruned: true},
+		{number: 3, pruned: true}, // two abandoned siblings at one height
+		{number: 4, txs: txs[4:5], pruned: true},
+		{number: 8, txs: txs[5:6], pruned: true},
+		{number: 11, txs: txs[6:8]},  // queued in memory
+		{number: 15, txs: txs[8:10]}, // at the cutoff
+		{number: 18, txs: txs[10:]},  // above the cutoff
+	}
+	for i, f := range forks {
+		f.hash = putForkTestBlock(t, tx, f.number, uint64(i)+1, f.txs, false)
+	}
+	keep := func(hash types.Hash) bool { return hash == forks[4].hash }
+	prunedBody, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(3, forks[0].hash))
+	if err != nil || prunedBody == nil {
+		t.Fatalf("fork body %v, %v", prunedBody, err)
+	}
+
+	// small chunks: every pass makes progress and finishes its height
+	var total StaleForkStats
+	passes := 0
+	for next := uint64(0); next < 15; passes++ {
+		stats, err := PruneStaleForks(tx, next, 15, 2, keep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Next <= next {
+			t.Fatalf("pass from %d stopped at %d", next, stats.Next)
+		}
+		next = stats.Next
+		total.Blocks += stats.Blocks
+		total.Txs += stats.Txs
+		total.RetainedTxs += stats.RetainedTxs
+	}
+	if passes < 5 {
+		t.Fatalf("%d passes, want the work split into chunks", passes)
+	}
+	if total.Blocks != 4 || total.Txs != 4 || total.RetainedTxs != 0 {
+		t.Fatalf("pruned %+v, want 4 blocks and 4 transactions", total)
+	}
+
+	for i, f := range forks {
+		rows := blockRows(t, tx, f.number, f.hash)
+		if f.pruned && rows != 0 {
+			t.Fatalf("fork %d at %d: %d rows left", i, f.number, rows)
+		}
+		if !f.pruned && rows != 4 {
+			t.Fatalf("fork %d at %d: %d rows, want all 4 kept", i, f.number, rows)
+		}
+	}
+	for n, hash := range canonical {
+		if rows := blockRows(t, tx, n, hash); rows != 4 {
+			t.Fatalf("canonical block %d: %d rows", n, rows)
+		}
+	}
+	checkBlockTxs(t, tx, modules.BlockTx, canonical[8], 8, txs[:2], false)
+	checkBlockTxs(t, tx, modules.BlockTx, forks[5].hash, 15, txs[8:10], false)
+	for id := prunedBody.BaseTxId; id < prunedBody.BaseTxId+uint64(prunedBody.TxAmount); id++ {
+		if v, err := tx.GetOne(modules.BlockTx, modules.EncodeBlockNumber(id)); err != nil || v != nil {
+			t.Fatalf("transaction %d of a pruned fork left: %x, %v", id, v, err)
+		}
+	}
+}
+
+func TestPruneStaleForksRetainsTxs(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, senders := signedTxs(t, []*ecdsa.PrivateKey{key}, 4)
+	for i, txn := range txs {
+		txn.SetFrom(senders[i])
+	}
+
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for n := uint64(0); n <= 4; n++ {
+		var blockTxs []*transaction.Transaction
+		if n == 2 {
+			blockTxs = txs[:2]
+		}
+		putForkTestBlock(t, tx, n, 0, blockTxs, true)
+	}
+	// a fork body pointing at the ids of the canonical block 2, as after an unwind
+	shared := putForkTestBlock(t, tx, 1, 1, nil, false)
+	canonical, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(2, mustCanonicalHash(t, tx, 2)))
+	if err != nil || canonical == nil {
+		t.Fatalf("canonical body %v, %v", canonical, err)
+	}
+	if err := WriteBodyForStorage(tx, shared, 1, canonical); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := PruneStaleForks(tx, 0, 4, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 1 || stats.Txs != 0 || stats.RetainedTxs != 2 {
+		t.Fatalf("shared ids: pruned %+v", stats)
+	}
+	checkBlockTxs(t, tx, modules.BlockTx, mustCanonicalHash(t, tx, 2), 2, txs[:2], false)
+
+	// once a block was demoted the table of a fork body is unknown
+	putForkTestBlock(t, tx, 3, 1, txs[2:], false)
+	if _, err := IncrementSequence(tx, modules.NonCanonicalTxs, 4); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = PruneStaleForks(tx, 0, 4, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 1 || stats.Txs != 0 || stats.RetainedTxs != 2 {
+		t.Fatalf("after demotion: pruned %+v", stats)
+	}
+}
+
+func TestStaleForkNext(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		if next, err := ReadStaleForkNext(tx); err != nil || next != 0 {
+			t.Fatalf("fresh db: next %d, err %v", next, err)
+		}
+		return WriteStaleForkNext(tx, 1234)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		if next, err := ReadStaleForkNext(tx); err != nil || next != 1234 {
+			t.Fatalf("next %d, err %v, want 1234", next, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
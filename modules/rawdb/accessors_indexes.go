@@ -17,12 +17,17 @@
 package rawdb
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
 	"github.com/amazechain/amc/common/block"
 	"github.com/amazechain/amc/common/transaction"
 	"github.com/amazechain/amc/common/types"
 	"github.com/amazechain/amc/log"
 	"github.com/amazechain/amc/modules"
 	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/etl"
 	"github.com/ledgerwatch/erigon-lib/kv"
 )
 
@@ -61,6 +66,65 @@ func WriteTxLookupEntries(db kv.Putter, block *block.Block) {
 	}
 }
 
+// WriteTxLookupEntry stores the lookup entry of one transaction of the block number.
+func WriteTxLookupEntry(db kv.Putter, txnHash types.Hash, number uint64) error {
+	return db.Put(modules.TxLookup, txnHash.Bytes(), uint256.NewInt(number).Bytes())
+}
+
+// errTxLookupDone stops the walk of RebuildTxLookup over the canonical chain.
+var errTxLookupDone = errors.New("tx lookup rebuilt")
+
+// RebuildTxLookup regenerates the TxLookup entries of the canonical blocks
+// from..to from the transactions in BlockTx. The system transaction slots of
+// every body are skipped, blocks without transactions write nothing. Entries
+// are sorted in tmpdir before they are loaded, progress is called with the
+// number of every walked block and may be nil. Entries of other blocks are
+// left as they are.
+func RebuildTxLookup(tx kv.RwTx, from, to uint64, tmpdir string, progress func(uint64)) error {
+	collector := etl.NewCollector("TxLookup", tmpdir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer collector.Close()
+
+	next := from
+	if err := tx.ForEach(modules.HeaderCanonical, modules.EncodeBlockNumber(from), func(k, v []byte) error {
+		number := binary.BigEndian.Uint64(k)
+		if number > to {
+			return errTxLookupDone
+		}
+		if number != next {
+			return fmt.Errorf("canonical hash of block %d is missing", next)
+		}
+		next++
+		body, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(number, types.BytesToHash(v)))
+		if err != nil {
+			return err
+		}
+		if body == nil {
+			return fmt.Errorf("body of canonical block %d %x is missing", number, v)
+		}
+		txs, err := readBodyTxs(tx, number, body)
+		if err != nil {
+			return err
+		}
+		value := uint256.NewInt(number).Bytes()
+		for _, txn := range txs {
+			h := txn.Hash()
+			if err := collector.Collect(h.Bytes(), value); err != nil {
+				return err
+			}
+		}
+		if progress != nil {
+			progress(number)
+		}
+		return nil
+	}); err != nil && !errors.Is(err, errTxLookupDone) {
+		return err
+	}
+	if next <= to {
+		return fmt.Errorf("canonical hash of block %d is missing", next)
+	}
+	return collector.Load(tx, modules.TxLookup, etl.IdentityLoadFunc, etl.TransformArgs{})
+}
+
 // DeleteTxLookupEntry removes all transaction data associated with a hash.
 func DeleteTxLookupEntry(db kv.Deleter, hash types.Hash) error {
 	return db.Delete(modules.TxLookup, hash.Bytes())
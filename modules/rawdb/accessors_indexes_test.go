@@ -0,0 +1,131 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+// putCanonicalBlock - canonical block with its transactions in BlockTx between system records
+func putCanonicalBlock(t testing.TB, tx kv.RwTx, number uint64, txs []*transaction.Transaction) {
+	t.Helper()
+	hash := types.Hash{byte(number), 0xca}
+	amount := uint32(len(txs)) + 2
+	baseTxId, err := IncrementSequence(tx, modules.BlockTx, uint64(amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(id uint64, v []byte) {
+		if err := tx.Put(modules.BlockTx, modules.EncodeBlockNumber(id), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(baseTxId, systemBefore)
+	for i, txn := range txs {
+		data, err := txn.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(baseTxId+1+uint64(i), data)
+	}
+	put(baseTxId+uint64(amount)-1, systemAfter)
+	if err := WriteCanonicalHash(tx, hash, number); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBodyForStorage(tx, hash, number, &block.BodyForStorage{BaseTxId: baseTxId, TxAmount: amount}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countEntries(t *testing.T, tx kv.Tx, table string) int {
+	t.Helper()
+	n := 0
+	if err := tx.ForEach(table, nil, func(k, v []byte) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRebuildTxLookup(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, senders := signedTxs(t, []*ecdsa.PrivateKey{key}, 5)
+	for i, txn := range txs {
+		txn.SetFrom(senders[i])
+	}
+	_, tx := memdb.NewTestTx(t)
+	blocks := [][]*transaction.Transaction{nil, txs[:2], nil, txs[2:]}
+	for number, b := range blocks {
+		putCanonicalBlock(t, tx, uint64(number), b)
+	}
+
+	var walked []uint64
+	if err := RebuildTxLookup(tx, 0, 3, t.TempDir(), func(number uint64) { walked = append(walked, number) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 4 || walked[0] != 0 || walked[3] != 3 {
+		t.Fatalf("walked blocks %v", walked)
+	}
+	for number, b := range blocks {
+		for _, txn := range b {
+			if entry, err := ReadTxLookupEntry(tx, txn.Hash()); err != nil || entry == nil || *entry != uint64(number) {
+				t.Fatalf("lookup of tx %x: %v, %v, want block %d", txn.Hash(), entry, err, number)
+			}
+		}
+	}
+	if n := countEntries(t, tx, modules.TxLookup); n != len(txs) {
+		t.Fatalf("%d lookup entries", n)
+	}
+
+	// a partial range leaves the entries of other blocks alone
+	if err := tx.ClearBucket(modules.TxLookup); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildTxLookup(tx, 3, 3, t.TempDir(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := ReadTxLookupEntry(tx, txs[0].Hash()); entry != nil {
+		t.Fatalf("lookup of a tx of block 1 written by rebuild of block 3")
+	}
+	if n := countEntries(t, tx, modules.TxLookup); n != 3 {
+		t.Fatalf("%d lookup entries of block 3", n)
+	}
+
+	if err := RebuildTxLookup(tx, 2, 4, t.TempDir(), nil); err == nil {
+		t.Fatal("rebuild past the last canonical block")
+	}
+	if err := tx.Delete(modules.BlockBody, modules.BlockBodyKey(1, types.Hash{1, 0xca})); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildTxLookup(tx, 0, 3, t.TempDir(), nil); err == nil {
+		t.Fatal("rebuild of a block without body")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// logIndexFromKey is the DatabaseInfo key of the earliest block covered by
+// LogAddressIndex and LogTopicIndex.
+var logIndexFromKey = []byte("LogIndexFrom")
+
+// ReadLogIndexFrom returns the earliest block of the log indices, ok is false
+// if nothing was indexed yet. Blocks below it have to be scanned.
+func ReadLogIndexFrom(db kv.Getter) (uint64, bool, error) {
+	data, err := db.GetOne(modules.DatabaseInfo, logIndexFromKey)
+	if err != nil || len(data) != 8 {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// WriteLogIndexFrom records the earliest block of the log indices, after the
+// indices were pruned below it.
+func WriteLogIndexFrom(tx kv.Putter, number uint64) error {
+	return tx.Put(modules.DatabaseInfo, logIndexFromKey, modules.EncodeBlockNumber(number))
+}
+
+// WriteLogIndex adds the block number to the LogAddressIndex bitmap of every
+// address and the LogTopicIndex bitmap of every topic logged in receipts.
+// Topics are indexed regardless of their position.
+func WriteLogIndex(tx kv.RwTx, number uint64, receipts block.Receipts) error {
+	if number > math.MaxUint32 {
+		return fmt.Errorf("log index of block %d: beyond index range", number)
+	}
+	addresses := make(map[string]struct{})
+	topics := make(map[string]struct{})
+	for _, r := range receipts {
+		for _, l := range r.Logs {
+			addresses[string(l.Address.Bytes())] = struct{}{}
+			for _, topic := range l.Topics {
+				topics[string(topic.Bytes())] = struct{}{}
+			}
+		}
+	}
+	delta := roaring.BitmapOf(uint32(number))
+	for addr := range addresses {
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogAddressIndex, []byte(addr), delta, int(bitmapdb.ChunkLimit)); err != nil {
+			return err
+		}
+	}
+	for topic := range topics {
+		if err := bitmapdb.UpsertShardedIndex(tx, modules.LogTopicIndex, []byte(topic), delta, int(bitmapdb.ChunkLimit)); err != nil {
+			return err
+		}
+	}
+	if _, ok, err := ReadLogIndexFrom(tx); err != nil || ok {
+		return err
+	}
+	return WriteLogIndexFrom(tx, number)
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// PromoteNonCanonicalTxs moves the transactions of a block becoming canonical from
+// NonCanonicalTxs to BlockTx ids starting at newBaseTxId, which the caller reserves with
+// IncrementSequence(tx, BlockTx, TxAmount) of the body. TxLookup of every ordinary
+// transaction is pointed at the block, the body record follows the move.
+func PromoteNonCanonicalTxs(tx kv.RwTx, blockHash []byte, newBaseTxId uint64) error {
+	return moveBlockTxs(tx, blockHash, modules.NonCanonicalTxs, modules.BlockTx, newBaseTxId)
+}
+
+// DemoteCanonicalTxs moves the transactions of a block losing canonical status from
+// BlockTx to NonCanonicalTxs ids starting at newBaseTxId, which the caller reserves with
+// IncrementSequence(tx, NonCanonicalTxs, TxAmount) of the body, and drops their TxLookup
+// entries. In a reorg the old blocks are demoted before the new ones are promoted.
+func DemoteCanonicalTxs(tx kv.RwTx, blockHash []byte, newBaseTxId uint64) error {
+	return moveBlockTxs(tx, blockHash, modules.BlockTx, modules.NonCanonicalTxs, newBaseTxId)
+}
+
+func moveBlockTxs(tx kv.RwTx, blockHash []byte, from, to string, newBaseTxId uint64) error {
+	hash := types.BytesToHash(blockHash)
+	number := ReadHeaderNumber(tx, hash)
+	if number == nil {
+		return fmt.Errorf("move transactions of block %x: unknown block", blockHash)
+	}
+	body, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(*number, hash))
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return fmt.Errorf("move transactions of block %d %x: no body", *number, blockHash)
+	}
+
+	// ids keep their offset in the range: system transactions before and after the block take
+	// the first and the last one, whether their records exist or not
+	for i := uint64(0); i < uint64(body.TxAmount); i++ {
+		system := i == 0 || i == uint64(body.TxAmount)-1
+		id := modules.EncodeBlockNumber(body.BaseTxId + i)
+		v, err := tx.GetOne(from, id)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			if system {
+				continue
+			}
+			return fmt.Errorf("move transactions of block %d: transaction %d missing from %s", *number, body.BaseTxId+i, from)
+		}
+		v = types.CopyBytes(v)
+		if err := tx.Put(to, modules.EncodeBlockNumber(newBaseTxId+i), v); err != nil {
+			return err
+		}
+		if err := tx.Delete(from, id); err != nil {
+			return err
+		}
+		if system {
+			continue
+		}
+		txn := new(transaction.Transaction)
+		if err := txn.Unmarshal(v); err != nil {
+			return fmt.Errorf("move transactions of block %d: transaction %d: %w", *number, body.BaseTxId+i, err)
+		}
+		if to == modules.BlockTx {
+			err = WriteTxLookupEntry(tx, txn.Hash(), *number)
+		} else {
+			err = DeleteTxLookupEntry(tx, txn.Hash())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return WriteBodyForStorage(tx, hash, *number, &block.BodyForStorage{BaseTxId: newBaseTxId, TxAmount: body.TxAmount})
+}
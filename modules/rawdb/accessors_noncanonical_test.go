@@ -0,0 +1,206 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"path/filepath"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+var (
+	systemBefore = []byte("system tx before block")
+	systemAfter  = []byte("system tx after block")
+)
+
+// putNonCanonicalBlock - block with its transactions in NonCanonicalTxs, system records only if withSystem
+func putNonCanonicalBlock(t *testing.T, tx kv.RwTx, hash types.Hash, number uint64, txs []*transaction.Transaction, withSystem bool) {
+	t.Helper()
+	amount := uint32(len(txs)) + 2
+	baseTxId, err := tx.IncrementSequence(modules.NonCanonicalTxs, uint64(amount))
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(id uint64, v []byte) {
+		if err := tx.Put(modules.NonCanonicalTxs, modules.EncodeBlockNumber(id), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, txn := range txs {
+		data, err := txn.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(baseTxId+1+uint64(i), data)
+	}
+	if withSystem {
+		put(baseTxId, systemBefore)
+		put(baseTxId+uint64(amount)-1, systemAfter)
+	}
+	if err := WriteHeaderNumber(tx, hash, number); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBodyForStorage(tx, hash, number, &block.BodyForStorage{BaseTxId: baseTxId, TxAmount: amount}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkBlockTxs - the block body range of table holds txs after an optional system record on each side
+func checkBlockTxs(t *testing.T, tx kv.Tx, table string, hash types.Hash, number uint64, txs []*transaction.Transaction, withSystem bool) {
+	t.Helper()
+	body, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(number, hash))
+	if err != nil || body == nil || body.TxAmount != uint32(len(txs))+2 {
+		t.Fatalf("block %d: body %+v, %v", number, body, err)
+	}
+	for i := uint64(0); i < uint64(body.TxAmount); i++ {
+		v, err := tx.GetOne(table, modules.EncodeBlockNumber(body.BaseTxId+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		switch {
+		case i == 0:
+			if withSystem {
+				want = systemBefore
+			}
+		case i == uint64(body.TxAmount)-1:
+			if withSystem {
+				want = systemAfter
+			}
+		default:
+			if want, err = txs[i-1].Marshal(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(v, want) {
+			t.Fatalf("block %d: %s id %d holds %x, want %x", number, table, body.BaseTxId+i, v, want)
+		}
+	}
+	if table != modules.BlockTx {
+		return
+	}
+	have, err := CanonicalTransactions(tx, body.BaseTxId+1, body.TxAmount-2)
+	if err != nil || len(have) != len(txs) {
+		t.Fatalf("block %d: canonical transactions %d, %v", number, len(have), err)
+	}
+	for i, txn := range have {
+		if txn.Hash() != txs[i].Hash() {
+			t.Fatalf("block %d: transaction %d is %x, want %x", number, i, txn.Hash(), txs[i].Hash())
+		}
+	}
+}
+
+func TestPromoteDemoteTxs(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 2; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	txs, senders := signedTxs(t, keys, 6)
+	for i, txn := range txs {
+		txn.SetFrom(senders[i])
+	}
+	// a has no system records, b has both
+	a, b := types.Hash{0xaa}, types.Hash{0xbb}
+	aTxs, bTxs := txs[:3], txs[3:]
+
+	db := openJournalDB(t, filepath.Join(t.TempDir(), "txs"))
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	putNonCanonicalBlock(t, tx, a, 5, aTxs, false)
+	putNonCanonicalBlock(t, tx, b, 6, bTxs, true)
+	checkBlockTxs(t, tx, modules.NonCanonicalTxs, a, 5, aTxs, false)
+	checkBlockTxs(t, tx, modules.NonCanonicalTxs, b, 6, bTxs, true)
+
+	// canonical ids are taken before the promotion, a shifted range must not break it
+	if _, err := tx.IncrementSequence(modules.BlockTx, 7); err != nil {
+		t.Fatal(err)
+	}
+	for _, blk := range []struct {
+		hash   types.Hash
+		number uint64
+		txs    []*transaction.Transaction
+		system bool
+	}{{a, 5, aTxs, false}, {b, 6, bTxs, true}} {
+		newBaseTxId, err := tx.IncrementSequence(modules.BlockTx, uint64(len(blk.txs))+2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PromoteNonCanonicalTxs(tx, blk.hash[:], newBaseTxId); err != nil {
+			t.Fatal(err)
+		}
+		checkBlockTxs(t, tx, modules.BlockTx, blk.hash, blk.number, blk.txs, blk.system)
+		for _, txn := range blk.txs {
+			if n, err := ReadTxLookupEntry(tx, txn.Hash()); err != nil || n == nil || *n != blk.number {
+				t.Fatalf("block %d: lookup of %x: %v, %v", blk.number, txn.Hash(), n, err)
+			}
+		}
+	}
+	if err := tx.ForEach(modules.NonCanonicalTxs, nil, func(k, _ []byte) error {
+		t.Fatalf("NonCanonicalTxs keeps id %x after promotion", k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b loses canonical status
+	newBaseTxId, err := tx.IncrementSequence(modules.NonCanonicalTxs, uint64(len(bTxs))+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DemoteCanonicalTxs(tx, b[:], newBaseTxId); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockTxs(t, tx, modules.NonCanonicalTxs, b, 6, bTxs, true)
+	checkBlockTxs(t, tx, modules.BlockTx, a, 5, aTxs, false)
+	for _, txn := range bTxs {
+		if n, err := ReadTxLookupEntry(tx, txn.Hash()); err != nil || n != nil {
+			t.Fatalf("lookup of demoted %x: %v, %v", txn.Hash(), n, err)
+		}
+	}
+	count := 0
+	if err := tx.ForEach(modules.BlockTx, nil, func(k, _ []byte) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(aTxs) {
+		t.Fatalf("BlockTx holds %d records, want %d", count, len(aTxs))
+	}
+
+	if err := PromoteNonCanonicalTxs(tx, types.Hash{0xcc}.Bytes(), 100); err == nil {
+		t.Fatal("promoted unknown block")
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Receipts records start with a version byte. Records written before the
+// version existed are bare protobuf, which never starts with 0x01 or 0x02
+// (field number 0 is invalid), so they are read as version 1.
+const (
+	receiptsV1 byte = 1 // protobuf of block.Receipts
+	receiptsV2 byte = 2 // cbor, see encodeReceiptsV2
+)
+
+// ReceiptsVersion is the version byte of the records WriteReceipts writes.
+const ReceiptsVersion = receiptsV2
+
+// receiptsMigration is the Migrations entry counting the version 1 records
+// rewritten as version 2 by ReadRawReceipts.
+const receiptsMigration = "receipts_v2"
+
+const receiptV2Fields = 8
+
+// ReadRawReceipts retrieves all the transaction receipts belonging to a block.
+// The receipt metadata fields are not guaranteed to be populated, so they
+// should not be used. Use ReadReceipts instead if the metadata is needed.
+//
+// A version 1 record read through a read-write tx is rewritten as version 2.
+func ReadRawReceipts(db kv.Tx, blockNum uint64) block.Receipts {
+	// Retrieve the flattened receipt slice
+	data, err := db.GetOne(modules.Receipts, modules.EncodeBlockNumber(blockNum))
+	if err != nil {
+		log.Error("ReadRawReceipts failed", "err", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	receipts, err := decodeReceipts(db, blockNum, data)
+	if err != nil {
+		log.Error("ReadRawReceipts failed", "block", blockNum, "err", err)
+		return nil
+	}
+	if tx, ok := db.(kv.RwTx); ok && data[0] != receiptsV2 && len(receipts) > 0 {
+		if err := migrateReceipts(tx, blockNum, receipts); err != nil {
+			log.Debug("Receipts migration failed", "block", blockNum, "err", err)
+		}
+	}
+	return receipts
+}
+
+// WriteReceipts stores all the transaction receipts belonging to a block.
+func WriteReceipts(tx kv.Putter, number uint64, receipts block.Receipts) error {
+	for txId, r := range receipts {
+		if len(r.Logs) == 0 {
+			continue
+		}
+		var logs block.Logs
+		logs = r.Logs
+		v, err := logs.Marshal()
+		if err != nil {
+			return fmt.Errorf("encode block logs for block %d: %w", number, err)
+		}
+
+		if err = tx.Put(modules.Log, modules.LogKey(number, uint32(txId)), v); err != nil {
+			return fmt.Errorf("writing logs for block %d: %w", number, err)
+		}
+	}
+
+	v, err := encodeReceiptsV2(receipts)
+	if err != nil {
+		return fmt.Errorf("encode block receipts for block %d: %w", number, err)
+	}
+
+	if err = tx.Put(modules.Receipts, modules.EncodeBlockNumber(number), v); err != nil {
+		return fmt.Errorf("writing receipts for block %d: %w", number, err)
+	}
+	return nil
+}
+
+// AppendReceipts stores all the transaction receipts belonging to a block.
+func AppendReceipts(tx kv.StatelessWriteTx, blockNumber uint64, receipts block.Receipts) error {
+	for txId, r := range receipts {
+		if len(r.Logs) == 0 {
+			continue
+		}
+
+		var logs block.Logs
+		logs = r.Logs
+		v, err := logs.Marshal()
+		if nil != err {
+			return err
+		}
+
+		if err = tx.Append(modules.Log, modules.LogKey(blockNumber, uint32(txId)), v); err != nil {
+			return fmt.Errorf("writing receipts for block %d: %w", blockNumber, err)
+		}
+	}
+
+	rv, err := encodeReceiptsV2(receipts)
+	if err != nil {
+		return fmt.Errorf("encode block receipts for block %d: %w", blockNumber, err)
+	}
+
+	if err = tx.Append(modules.Receipts, modules.EncodeBlockNumber(blockNumber), rv); err != nil {
+		return fmt.Errorf("writing receipts for block %d: %w", blockNumber, err)
+	}
+	return nil
+}
+
+// ReceiptsMigrated returns how many version 1 receipts records were
+// rewritten as version 2 so far.
+func ReceiptsMigrated(db kv.Getter) (uint64, error) {
+	v, err := db.GetOne(modules.Migrations, []byte(receiptsMigration))
+	if err != nil || len(v) == 0 {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("bad %s migration progress of %d bytes", receiptsMigration, len(v))
+	}
+	return modules.DecodeBlockNumber(v)
+}
+
+// UpgradeReceipts rewrites the receipts record of a block as version 2 if it is
+// an older one, what ReadRawReceipts does lazily. Reports whether it rewrote.
+func UpgradeReceipts(tx kv.GetPut, blockNum uint64) (bool, error) {
+	data, err := tx.GetOne(modules.Receipts, modules.EncodeBlockNumber(blockNum))
+	if err != nil || len(data) == 0 || data[0] == receiptsV2 {
+		return false, err
+	}
+	receipts, err := decodeReceipts(tx, blockNum, data)
+	if err != nil {
+		return false, fmt.Errorf("receipts of block %d: %w", blockNum, err)
+	}
+	if len(receipts) == 0 {
+		return false, nil
+	}
+	return true, migrateReceipts(tx, blockNum, receipts)
+}
+
+func migrateReceipts(tx kv.GetPut, number uint64, receipts block.Receipts) error {
+	// the version 2 record takes the logs from the Log table
+	for i, r := range receipts {
+		if len(r.Logs) == 0 {
+			continue
+		}
+		has, err := tx.Has(modules.Log, modules.LogKey(number, uint32(i)))
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("logs of transaction %d missing", i)
+		}
+	}
+	v, err := encodeReceiptsV2(receipts)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(modules.Receipts, modules.EncodeBlockNumber(number), v); err != nil {
+		return err
+	}
+	migrated, err := ReceiptsMigrated(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Put(modules.Migrations, []byte(receiptsMigration), modules.EncodeBlockNumber(migrated+1))
+}
+
+func decodeReceipts(db kv.Getter, number uint64, data []byte) (block.Receipts, error) {
+	var receipts block.Receipts
+	switch data[0] {
+	case receiptsV2:
+		return decodeReceiptsV2(db, number, data[1:])
+	case receiptsV1:
+		data = data[1:]
+	}
+	if err := receipts.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// encodeReceiptsV2 encodes receipts as
+//
+//	[blockHash, [[type, postState, status, cumulativeGasDelta, gasUsed, txHash, contractAddress, logCount], ...]]
+//
+// after the version byte. The logs are not repeated, they are read back from
+// the Log table. The block number, transaction index and bloom of the
+// receipts are derived when reading. No receipts encode to an empty record.
+func encodeReceiptsV2(receipts block.Receipts) ([]byte, error) {
+	if len(receipts) == 0 {
+		return nil, nil
+	}
+	buf := []byte{receiptsV2}
+	buf = appendCBORArray(buf, 2)
+	buf = appendCBORBytes(buf, receipts[0].BlockHash[:])
+	buf = appendCBORArray(buf, len(receipts))
+	var cumulative uint64
+	for i, r := range receipts {
+		if r.CumulativeGasUsed < cumulative {
+			return nil, fmt.Errorf("cumulative gas of receipt %d goes down from %d to %d", i, cumulative, r.CumulativeGasUsed)
+		}
+		if r.BlockHash != receipts[0].BlockHash {
+			return nil, fmt.Errorf("receipt %d of block %x", i, r.BlockHash)
+		}
+		buf = appendCBORArray(buf, receiptV2Fields)
+		buf = appendCBORUint(buf, uint64(r.Type))
+		buf = appendCBORBytes(buf, r.PostState)
+		buf = appendCBORUint(buf, r.Status)
+		buf = appendCBORUint(buf, r.CumulativeGasUsed-cumulative)
+		buf = appendCBORUint(buf, r.GasUsed)
+		buf = appendCBORBytes(buf, r.TxHash[:])
+		if r.ContractAddress == (types.Address{}) {
+			buf = appendCBORBytes(buf, nil)
+		} else {
+			buf = appendCBORBytes(buf, r.ContractAddress[:])
+		}
+		buf = appendCBORUint(buf, uint64(len(r.Logs)))
+		cumulative = r.CumulativeGasUsed
+	}
+	return buf, nil
+}
+
+func decodeReceiptsV2(db kv.Getter, number uint64, data []byte) (block.Receipts, error) {
+	d := &cborDecoder{data: data}
+	if err := d.array(2); err != nil {
+		return nil, err
+	}
+	blockHash, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	n, err := d.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+	receipts := make(block.Receipts, n)
+	var cumulative uint64
+	for i := range receipts {
+		r := &block.Receipt{
+			BlockHash:        types.BytesToHash(blockHash),
+			BlockNumber:      uint256.NewInt(number),
+			TransactionIndex: uint(i),
+		}
+		if err := d.array(receiptV2Fields); err != nil {
+			return nil, fmt.Errorf("receipt %d: %w", i, err)
+		}
+		typ, err := d.uint()
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d type: %w", i, err)
+		}
+		r.Type = uint8(typ)
+		if r.PostState, err = d.bytes(); err != nil {
+			return nil, fmt.Errorf("receipt %d post state: %w", i, err)
+		}
+		if len(r.PostState) == 0 {
+			r.PostState = nil
+		}
+		if r.Status, err = d.uint(); err != nil {
+			return nil, fmt.Errorf("receipt %d status: %w", i, err)
+		}
+		delta, err := d.uint()
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d cumulative gas: %w", i, err)
+		}
+		cumulative += delta
+		r.CumulativeGasUsed = cumulative
+		if r.GasUsed, err = d.uint(); err != nil {
+			return nil, fmt.Errorf("receipt %d gas: %w", i, err)
+		}
+		txHash, err := d.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d tx hash: %w", i, err)
+		}
+		r.TxHash = types.BytesToHash(txHash)
+		contract, err := d.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d contract: %w", i, err)
+		}
+		r.ContractAddress = types.BytesToAddress(contract)
+		logCount, err := d.uint()
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d log count: %w", i, err)
+		}
+		if logCount > 0 {
+			if r.Logs, err = readTxLogs(db, number, uint32(i), logCount); err != nil {
+				return nil, err
+			}
+		}
+		copy(r.Bloom[:], block.LogsBloom(r.Logs))
+		receipts[i] = r
+	}
+	if len(d.data) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(d.data))
+	}
+	return receipts, nil
+}
+
+func readTxLogs(db kv.Getter, number uint64, txId uint32, count uint64) (block.Logs, error) {
+	v, err := db.GetOne(modules.Log, modules.LogKey(number, txId))
+	if err != nil {
+		return nil, err
+	}
+	var logs block.Logs
+	if err := logs.Unmarshal(v); err != nil {
+		return nil, fmt.Errorf("logs of transaction %d: %w", txId, err)
+	}
+	if uint64(len(logs)) != count {
+		return nil, fmt.Errorf("%d logs of transaction %d, want %d", len(logs), txId, count)
+	}
+	return logs, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func testReceipts(number uint64, logCounts ...int) block.Receipts {
+	blockHash := types.Hash{byte(number), 0xbb}
+	receipts := make(block.Receipts, len(logCounts))
+	var cumulative uint64
+	for i, n := range logCounts {
+		cumulative += 21000 + uint64(n)*375
+		r := &block.Receipt{
+			Status:            uint64(i % 2),
+			CumulativeGasUsed: cumulative,
+			TxHash:            types.Hash{byte(i), 0x7a},
+			GasUsed:           21000 + uint64(n)*375,
+			BlockHash:         blockHash,
+			BlockNumber:       uint256.NewInt(number),
+			TransactionIndex:  uint(i),
+		}
+		if i == 0 {
+			r.ContractAddress = types.Address{0xc0}
+		}
+		for j := 0; j < n; j++ {
+			r.Logs = append(r.Logs, &block.Log{
+				Address:     types.Address{byte(j % 3), 0xaa},
+				Topics:      []types.Hash{{0x01}, {byte(j)}},
+				Data:        []byte{byte(j), byte(j >> 8)},
+				BlockNumber: uint256.NewInt(number),
+				TxHash:      r.TxHash,
+				TxIndex:     uint(i),
+				BlockHash:   blockHash,
+				Index:       uint(j),
+			})
+		}
+		copy(r.Bloom[:], block.LogsBloom(r.Logs))
+		receipts[i] = r
+	}
+	return receipts
+}
+
+func TestReceiptsRoundTrip(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	cases := map[uint64]block.Receipts{
+		1: testReceipts(1, 0),
+		2: testReceipts(2, 0, 0, 0), // failed txs without logs
+		3: testReceipts(3, 2, 0, 1),
+		4: testReceipts(4, 1200), // more logs than fit a small cbor length
+	}
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for number, receipts := range cases {
+			if err := WriteReceipts(tx, number, receipts); err != nil {
+				return err
+			}
+		}
+		return WriteReceipts(tx, 5, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		for number, want := range cases {
+			v, _ := tx.GetOne(modules.Receipts, modules.EncodeBlockNumber(number))
+			if len(v) == 0 || v[0] != receiptsV2 {
+				t.Fatalf("block %d: record is not version 2", number)
+			}
+			if got := ReadRawReceipts(tx, number); !reflect.DeepEqual(got, want) {
+				t.Fatalf("block %d: receipts differ after round trip", number)
+			}
+		}
+		if got := ReadRawReceipts(tx, 5); got != nil {
+			t.Fatalf("no receipts read as %v", got)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiptsMigration(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	want := testReceipts(7, 3, 0)
+	// a record as written before receipts were versioned
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		if err := WriteReceipts(tx, 7, want); err != nil {
+			return err
+		}
+		legacy, err := want.Marshal()
+		if err != nil {
+			return err
+		}
+		return tx.Put(modules.Receipts, modules.EncodeBlockNumber(7), legacy)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	version := func(tx kv.Getter) byte {
+		v, _ := tx.GetOne(modules.Receipts, modules.EncodeBlockNumber(7))
+		return v[0]
+	}
+	// read-only reads decode the old record and leave it alone
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		if got := ReadRawReceipts(tx, 7); !reflect.DeepEqual(got, want) {
+			t.Fatalf("version 1 receipts differ")
+		}
+		if v := version(tx); v == receiptsV2 {
+			t.Fatalf("read-only read rewrote the record")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		if got := ReadRawReceipts(tx, 7); !reflect.DeepEqual(got, want) {
+			t.Fatalf("version 1 receipts differ")
+		}
+		if v := version(tx); v != receiptsV2 {
+			t.Fatalf("record version = %d after read-write read, want %d", v, receiptsV2)
+		}
+		if got := ReadRawReceipts(tx, 7); !reflect.DeepEqual(got, want) {
+			t.Fatalf("migrated receipts differ")
+		}
+		migrated, err := ReceiptsMigrated(tx)
+		if err != nil || migrated != 1 {
+			t.Fatalf("migrated = %d, %v, want 1", migrated, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTruncateReceipts(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for number := uint64(1); number <= 4; number++ {
+			if err := WriteReceipts(tx, number, testReceipts(number, 1, 2)); err != nil {
+				return err
+			}
+		}
+		return TruncateReceipts(tx, 3)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		for number := uint64(1); number <= 4; number++ {
+			has, err := tx.Has(modules.Receipts, modules.EncodeBlockNumber(number))
+			if err != nil {
+				return err
+			}
+			var logs int
+			if err := tx.ForPrefix(modules.Log, modules.EncodeBlockNumber(number), func(_, _ []byte) error {
+				logs++
+				return nil
+			}); err != nil {
+				return err
+			}
+			if kept := number < 3; has != kept || (logs > 0) != kept {
+				t.Fatalf("block %d: receipts %t, %d log records, want kept %t", number, has, logs, kept)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/params"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Senders values have one of two layouts:
+//   - plain: 20 bytes per transaction
+//   - dedup: uvarint amount of transactions, then per transaction a uvarint reference - 0 is followed
+//     by a new address, i > 0 repeats the i-th new address of the block. Padded with a zero byte
+//     when its length is a multiple of 20, so the layouts never collide.
+//
+// EncodeSenders picks dedup only when it is shorter.
+
+var errSendersEncoding = errors.New("malformed senders")
+
+// EncodeSenders - Senders value of the block transactions senders
+func EncodeSenders(senders []types.Address) []byte {
+	plain := len(senders) * types.AddressLength
+	seen := make(map[types.Address]uint64, len(senders))
+	buf := binary.AppendUvarint(make([]byte, 0, plain), uint64(len(senders)))
+	for _, s := range senders {
+		if i, ok := seen[s]; ok {
+			buf = binary.AppendUvarint(buf, i)
+			continue
+		}
+		seen[s] = uint64(len(seen)) + 1
+		buf = append(append(buf, 0), s[:]...)
+		if len(buf) >= plain {
+			break
+		}
+	}
+	if len(buf)%types.AddressLength == 0 {
+		buf = append(buf, 0)
+	}
+	if len(buf) < plain {
+		return buf
+	}
+	buf = buf[:0]
+	for _, s := range senders {
+		buf = append(buf, s[:]...)
+	}
+	return buf
+}
+
+// DecodeSenders - senders of Senders value of either layout
+func DecodeSenders(data []byte) ([]types.Address, error) {
+	if len(data)%types.AddressLength == 0 {
+		senders := make([]types.Address, len(data)/types.AddressLength)
+		for i := range senders {
+			copy(senders[i][:], data[i*types.AddressLength:])
+		}
+		return senders, nil
+	}
+	n, l := binary.Uvarint(data)
+	// every transaction takes at least one byte
+	if l <= 0 || n > uint64(len(data)-l) {
+		return nil, fmt.Errorf("%w: bad transactions amount", errSendersEncoding)
+	}
+	data = data[l:]
+	senders := make([]types.Address, n)
+	var distinct []int
+	for i := range senders {
+		ref, l := binary.Uvarint(data)
+		if l <= 0 {
+			return nil, fmt.Errorf("%w: bad reference of transaction %d", errSendersEncoding, i)
+		}
+		data = data[l:]
+		switch {
+		case ref == 0:
+			if len(data) < types.AddressLength {
+				return nil, fmt.Errorf("%w: short address of transaction %d", errSendersEncoding, i)
+			}
+			copy(senders[i][:], data)
+			data = data[types.AddressLength:]
+			distinct = append(distinct, i)
+		case ref <= uint64(len(distinct)):
+			senders[i] = senders[distinct[ref-1]]
+		default:
+			return nil, fmt.Errorf("%w: transaction %d references sender %d of %d", errSendersEncoding, i, ref, len(distinct))
+		}
+	}
+	return senders, nil
+}
+
+func WriteSenders(db kv.Putter, hash types.Hash, number uint64, senders []types.Address) error {
+	if err := db.Put(modules.Senders, modules.BlockBodyKey(number, hash), EncodeSenders(senders)); err != nil {
+		return fmt.Errorf("failed to store block senders: %w", err)
+	}
+	return nil
+}
+
+// SenderRecovery - senders of blocks whose Senders records were pruned, recovered from the transaction
+// signatures. All requests share a bounded pool of recovery workers, recently recovered blocks are
+// kept in an LRU. Recovery of a block gives up after timeout, so deep-history requests with full
+// transactions stay slower than recent ones, but bounded.
+type SenderRecovery struct {
+	config  *params.ChainConfig
+	workers chan struct{}
+	timeout time.Duration
+	recent  *lru.Cache // block hash -> []types.Address
+}
+
+func NewSenderRecovery(config *params.ChainConfig, workers, recentBlocks int, timeout time.Duration) (*SenderRecovery, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("sender recovery needs at least one worker, have %d", workers)
+	}
+	recent, err := lru.New(recentBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return &SenderRecovery{config: config, workers: make(chan struct{}, workers), timeout: timeout, recent: recent}, nil
+}
+
+// Senders - senders of the block transactions: stored ones when the block keeps them, recovered otherwise
+func (r *SenderRecovery) Senders(ctx context.Context, db kv.Getter, hash types.Hash, number uint64, txs []*transaction.Transaction) ([]types.Address, error) {
+	senders, err := ReadSenders(db, hash, number)
+	if err != nil {
+		return nil, err
+	}
+	if len(senders) == len(txs) {
+		return senders, nil
+	}
+	if v, ok := r.recent.Get(hash); ok {
+		return v.([]types.Address), nil
+	}
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	signer := transaction.MakeSigner(r.config, new(big.Int).SetUint64(number))
+	senders = make([]types.Address, len(txs))
+	errs := make([]error, len(txs))
+	var wg sync.WaitGroup
+	for i, tx := range txs {
+		if err := r.acquire(ctx); err != nil {
+			wg.Wait()
+			return nil, fmt.Errorf("recover senders of block %d: %w", number, err)
+		}
+		wg.Add(1)
+		go func(i int, tx *transaction.Transaction) {
+			defer func() {
+				<-r.workers
+				wg.Done()
+			}()
+			senders[i], errs[i] = transaction.Sender(signer, tx)
+		}(i, tx)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("recover sender of transaction %d of block %d: %w", i, number, err)
+		}
+	}
+	r.recent.Add(hash, senders)
+	return senders, nil
+}
+
+// acquire - takes a worker slot, fails once ctx is done even if a slot is free
+func (r *SenderRecovery) acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case r.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// senderBatchSize is the number of transactions RecoverSenders recovers at once. Transactions of
+// consecutive blocks share a batch, so small blocks keep all workers busy.
+const senderBatchSize = 4096
+
+// senderBlock - block whose senders are recovered by RecoverSenders
+type senderBlock struct {
+	number  uint64
+	hash    types.Hash
+	signer  transaction.Signer
+	txs     []*transaction.Transaction
+	senders []types.Address
+	errs    []error
+}
+
+// RecoverSenders writes the Senders records of the canonical blocks from..to which have none, or
+// one not matching the amount of their transactions. Senders are recovered from the transaction
+// signatures on workers goroutines, runtime.NumCPU() when workers is not positive. Blocks without
+// transactions are skipped, the walk ends early at the last canonical block.
+func RecoverSenders(ctx context.Context, tx kv.RwTx, from, to uint64, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	genesis, err := ReadCanonicalHash(tx, 0)
+	if err != nil {
+		return err
+	}
+	config, err := ReadChainConfig(tx, genesis)
+	if err != nil {
+		return err
+	}
+
+	var (
+		batch   []*senderBlock
+		pending int
+	)
+	flush := func() error {
+		if err := recoverBatch(ctx, batch, workers); err != nil {
+			return err
+		}
+		for _, b := range batch {
+			if err := WriteSenders(tx, b.hash, b.number, b.senders); err != nil {
+				return err
+			}
+		}
+		batch, pending = batch[:0], 0
+		return nil
+	}
+	for number := from; number <= to; number++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hash, err := ReadCanonicalHash(tx, number)
+		if err != nil {
+			return err
+		}
+		if hash == (types.Hash{}) {
+			break
+		}
+		body, err := ReadBodyForStorageByKey(tx, modules.BlockBodyKey(number, hash))
+		if err != nil {
+			return err
+		}
+		if body == nil {
+			return fmt.Errorf("body of canonical block %d %x is missing", number, hash)
+		}
+		txs, err := readBodyTxs(tx, number, body)
+		if err != nil {
+			return err
+		}
+		if len(txs) == 0 {
+			continue
+		}
+		// malformed records are rewritten as well
+		if senders, err := ReadSenders(tx, hash, number); err == nil && len(senders) == len(txs) {
+			continue
+		}
+		batch = append(batch, &senderBlock{
+			number:  number,
+			hash:    hash,
+			signer:  transaction.MakeSigner(config, new(big.Int).SetUint64(number)),
+			txs:     txs,
+			senders: make([]types.Address, len(txs)),
+			errs:    make([]error, len(txs)),
+		})
+		if pending += len(txs); pending >= senderBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// recoverBatch - recovers the senders of the blocks on workers goroutines, the error of the first
+// transaction which failed names its block and index
+func recoverBatch(ctx context.Context, batch []*senderBlock, workers int) error {
+	type job struct {
+		b *senderBlock
+		i int
+	}
+	jobs := make(chan job, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.b.senders[j.i], j.b.errs[j.i] = transaction.Sender(j.b.signer, j.b.txs[j.i])
+			}
+		}()
+	}
+	var canceled error
+feed:
+	for _, b := range batch {
+		for i := range b.txs {
+			select {
+			case jobs <- job{b, i}:
+			case <-ctx.Done():
+				canceled = ctx.Err()
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if canceled != nil {
+		return canceled
+	}
+
+	for _, b := range batch {
+		for i, err := range b.errs {
+			if err != nil {
+				return fmt.Errorf("recover sender of transaction %d of block %d: %w", i, b.number, err)
+			}
+		}
+	}
+	return nil
+}
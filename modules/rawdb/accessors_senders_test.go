@@ -0,0 +1,290 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+func TestSendersEncoding(t *testing.T) {
+	a, b, c := types.Address{1}, types.Address{2}, types.Address{3}
+	for _, tc := range []struct {
+		name    string
+		senders []types.Address
+		dedup   bool
+	}{
+		{"empty", nil, false},
+		{"one", []types.Address{a}, false},
+		{"distinct", []types.Address{a, b, c}, false},
+		{"repeats", []types.Address{a, b, a, a, c, b, a}, true},
+		{"single sender", []types.Address{c, c, c, c, c}, true},
+	} {
+		data := EncodeSenders(tc.senders)
+		if plain := len(tc.senders) * types.AddressLength; tc.dedup != (len(data) < plain) {
+			t.Fatalf("%s: %d bytes, plain layout %d", tc.name, len(data), plain)
+		}
+		have, err := DecodeSenders(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(have) != len(tc.senders) || (len(have) > 0 && !reflect.DeepEqual(have, tc.senders)) {
+			t.Fatalf("%s: have %v, want %v", tc.name, have, tc.senders)
+		}
+	}
+
+	for _, data := range [][]byte{
+		{3, 0, 1},                                // short address
+		{2, 0, 1, 2, 3},                          // transactions beyond the data
+		append(append([]byte{2, 0}, a[:]...), 2), // reference to a missing sender
+	} {
+		if _, err := DecodeSenders(data); !errors.Is(err, errSendersEncoding) {
+			t.Fatalf("%x: %v", data, err)
+		}
+	}
+}
+
+func signedTxs(t testing.TB, keys []*ecdsa.PrivateKey, n int) ([]*transaction.Transaction, []types.Address) {
+	t.Helper()
+	signer := transaction.NewLondonSigner(params.AllEthashProtocolChanges.ChainID)
+	chainID, _ := uint256.FromBig(params.AllEthashProtocolChanges.ChainID)
+	txs := make([]*transaction.Transaction, n)
+	senders := make([]types.Address, n)
+	for i := range txs {
+		key := keys[i%len(keys)]
+		to := types.Address{0xee}
+		tx, err := transaction.SignNewTx(key, signer, &transaction.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     uint64(i),
+			GasTipCap: uint256.NewInt(1),
+			GasFeeCap: uint256.NewInt(10),
+			Gas:       21000,
+			To:        &to,
+			Value:     uint256.NewInt(uint64(i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs[i], senders[i] = tx, crypto.PubkeyToAddress(key.PublicKey)
+	}
+	return txs, senders
+}
+
+func TestSenderRecovery(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 3; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	txs, stored := signedTxs(t, keys, 16)
+	hash, number := types.Hash{0x42}, uint64(7)
+
+	db := openJournalDB(t, filepath.Join(t.TempDir(), "senders"))
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := WriteSenders(tx, hash, number, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewSenderRecovery(params.AllEthashProtocolChanges, 2, 4, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := r.Senders(context.Background(), tx, hash, number, txs); err != nil || !reflect.DeepEqual(have, stored) {
+		t.Fatalf("stored senders: have %v, %v", have, err)
+	}
+	if r.recent.Len() != 0 {
+		t.Fatal("stored senders went through recovery")
+	}
+
+	// pruned: recovered senders match the ones stored before
+	if err := tx.Delete(modules.Senders, modules.BlockBodyKey(number, hash)); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Senders(canceled, tx, hash, number, txs); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled recovery: %v", err)
+	}
+	have, err := r.Senders(context.Background(), tx, hash, number, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, stored) {
+		t.Fatalf("recovered %v, stored %v", have, stored)
+	}
+	if _, ok := r.recent.Get(hash); !ok {
+		t.Fatal("recovered senders are not cached")
+	}
+	if len(r.workers) != 0 {
+		t.Fatalf("%d workers left busy", len(r.workers))
+	}
+}
+
+// newAmcTx - rw tx of a db with the amc tables, memdb only knows the erigon ones and misses ChainConfig
+func newAmcTx(tb testing.TB) kv.RwTx {
+	db := openJournalDB(tb, tb.TempDir())
+	tb.Cleanup(db.Close)
+	return memdb.BeginRw(tb, db)
+}
+
+// senderChain - canonical blocks with the given amounts of signed transactions, senders not stored
+func senderChain(t testing.TB, tx kv.RwTx, amounts []int) ([][]*transaction.Transaction, [][]types.Address) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range amounts {
+		total += n
+	}
+	all, senders := signedTxs(t, []*ecdsa.PrivateKey{key}, total)
+	if err := WriteChainConfig(tx, types.Hash{0, 0xca}, params.AllEthashProtocolChanges); err != nil {
+		t.Fatal(err)
+	}
+	blocks, blockSenders := make([][]*transaction.Transaction, len(amounts)), make([][]types.Address, len(amounts))
+	for number, n := range amounts {
+		blocks[number], blockSenders[number] = all[:n], senders[:n]
+		all, senders = all[n:], senders[n:]
+		for i, txn := range blocks[number] {
+			txn.SetFrom(blockSenders[number][i])
+		}
+		putCanonicalBlock(t, tx, uint64(number), blocks[number])
+	}
+	return blocks, blockSenders
+}
+
+func TestRecoverSenders(t *testing.T) {
+	tx := newAmcTx(t)
+	_, senders := senderChain(t, tx, []int{0, 5, 0, 7})
+	hash := func(number uint64) types.Hash { return types.Hash{byte(number), 0xca} }
+
+	// a record of the right length is kept, one of the wrong length is rewritten
+	kept := make([]types.Address, 7)
+	for i := range kept {
+		kept[i] = types.Address{0xbb, byte(i)}
+	}
+	if err := WriteSenders(tx, hash(3), 3, kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSenders(tx, hash(1), 1, senders[3][:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecoverSenders(context.Background(), tx, 0, 10, 2); err != nil {
+		t.Fatal(err)
+	}
+	for number, want := range map[uint64][]types.Address{1: senders[1], 3: kept} {
+		if have, err := ReadSenders(tx, hash(number), number); err != nil || !reflect.DeepEqual(have, want) {
+			t.Fatalf("block %d: senders %v, %v, want %v", number, have, err, want)
+		}
+	}
+	for _, number := range []uint64{0, 2} {
+		if ok, err := tx.Has(modules.Senders, modules.BlockBodyKey(number, hash(number))); err != nil || ok {
+			t.Fatalf("senders of empty block %d written", number)
+		}
+	}
+
+	if err := tx.Delete(modules.Senders, modules.BlockBodyKey(3, hash(3))); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := RecoverSenders(canceled, tx, 0, 3, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled recovery: %v", err)
+	}
+	if err := RecoverSenders(context.Background(), tx, 3, 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := ReadSenders(tx, hash(3), 3); err != nil || !reflect.DeepEqual(have, senders[3]) {
+		t.Fatalf("recovered senders %v, %v, want %v", have, err, senders[3])
+	}
+
+	// transaction signed for another chain
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := types.Address{0xee}
+	foreign, err := transaction.SignNewTx(key, transaction.NewLondonSigner(big.NewInt(9999)), &transaction.DynamicFeeTx{
+		ChainID:   uint256.NewInt(9999),
+		GasTipCap: uint256.NewInt(1),
+		GasFeeCap: uint256.NewInt(10),
+		Gas:       21000,
+		To:        &to,
+		Value:     uint256.NewInt(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign.SetFrom(crypto.PubkeyToAddress(key.PublicKey))
+	txs, _ := signedTxs(t, []*ecdsa.PrivateKey{key}, 2)
+	for _, txn := range txs {
+		txn.SetFrom(crypto.PubkeyToAddress(key.PublicKey))
+	}
+	putCanonicalBlock(t, tx, 4, []*transaction.Transaction{txs[0], foreign, txs[1]})
+	if err := RecoverSenders(context.Background(), tx, 4, 4, 0); err == nil || !strings.Contains(err.Error(), "transaction 1 of block 4") {
+		t.Fatalf("bad signature: %v", err)
+	}
+}
+
+func BenchmarkRecoverSenders(b *testing.B) {
+	tx := newAmcTx(b)
+	amounts := make([]int, 64)
+	for i := range amounts {
+		amounts[i] = 100
+	}
+	senderChain(b, tx, amounts)
+	var elapsed time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := tx.ClearBucket(modules.Senders); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		start := time.Now()
+		if err := RecoverSenders(context.Background(), tx, 0, uint64(len(amounts)-1), 0); err != nil {
+			b.Fatal(err)
+		}
+		elapsed += time.Since(start)
+	}
+	b.ReportMetric(float64(b.N*len(amounts)*100)/elapsed.Seconds(), "recoveries/s")
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Sequences are per-table counters kept in the Sequence table: table name -> big-endian uint64 of the
+// next free id. They are only moved by the write tx, and write txs are serialized, so ids reserved with
+// IncrementSequence are unique among committed txs; ids of a rolled back tx are reserved again.
+//
+// BlockTx and NonCanonicalTxs key their records by ids of the same uint64 space but count them
+// separately: an id names a record only together with its table. A block moving between the tables takes
+// fresh ids from the counter of the destination (see PromoteNonCanonicalTxs), its ids in the source
+// table are not given back. Unwinding moves the BlockTx counter back to the first id of the truncated
+// bodies with ResetSequence, ids held by NonCanonicalTxs are not affected.
+
+// ReadSequence - next free id of table
+func ReadSequence(tx kv.Getter, table string) (uint64, error) {
+	v, err := tx.GetOne(modules.Sequence, []byte(table))
+	if err != nil {
+		return 0, err
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("sequence of %s: unexpected length %d", table, len(v))
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// IncrementSequence - reserves amount ids of table, returns the first of them
+func IncrementSequence(tx kv.RwTx, table string, amount uint64) (uint64, error) {
+	first, err := ReadSequence(tx, table)
+	if err != nil {
+		return 0, err
+	}
+	if amount > math.MaxUint64-first {
+		return 0, fmt.Errorf("sequence of %s: %d more ids after %d overflow", table, amount, first)
+	}
+	if err := putSequence(tx, table, first+amount); err != nil {
+		return 0, err
+	}
+	return first, nil
+}
+
+// ResetSequence - frees ids of table from next on, the caller has deleted their records. Unwind only:
+// moving the counter forward is refused, ids are reserved by IncrementSequence.
+func ResetSequence(tx kv.RwTx, table string, next uint64) error {
+	current, err := ReadSequence(tx, table)
+	if err != nil {
+		return err
+	}
+	if next > current {
+		return fmt.Errorf("sequence of %s: reset to %d would move it forward from %d", table, next, current)
+	}
+	return putSequence(tx, table, next)
+}
+
+func putSequence(tx kv.RwTx, table string, next uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, next)
+	return tx.Put(modules.Sequence, []byte(table), v)
+}
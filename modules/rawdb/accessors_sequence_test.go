@@ -0,0 +1,181 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"path/filepath"
+	"testing"
+
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+func TestSequence(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	if next, err := ReadSequence(tx, modules.BlockTx); err != nil || next != 0 {
+		t.Fatalf("fresh sequence %d, %v", next, err)
+	}
+	for _, c := range []struct{ amount, first uint64 }{{5, 0}, {0, 5}, {3, 5}} {
+		if first, err := IncrementSequence(tx, modules.BlockTx, c.amount); err != nil || first != c.first {
+			t.Fatalf("increment by %d: first id %d, want %d, %v", c.amount, first, c.first, err)
+		}
+	}
+	// same encoding as the tx method
+	if next, err := tx.ReadSequence(modules.BlockTx); err != nil || next != 8 {
+		t.Fatalf("tx sequence %d, %v", next, err)
+	}
+	if err := ResetSequence(tx, modules.BlockTx, 9); err == nil {
+		t.Fatal("sequence moved forward by reset")
+	}
+	if err := ResetSequence(tx, modules.BlockTx, 6); err != nil {
+		t.Fatal(err)
+	}
+	if first, err := IncrementSequence(tx, modules.BlockTx, 1); err != nil || first != 6 {
+		t.Fatalf("first id after reset %d, %v", first, err)
+	}
+	if next, err := ReadSequence(tx, modules.NonCanonicalTxs); err != nil || next != 0 {
+		t.Fatalf("NonCanonicalTxs sequence %d, %v", next, err)
+	}
+
+	if _, err := IncrementSequence(tx, modules.BlockTx, ^uint64(0)); err == nil {
+		t.Fatal("sequence overflow accepted")
+	}
+	if err := tx.Put(modules.Sequence, []byte(modules.BlockTx), []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSequence(tx, modules.BlockTx); err == nil {
+		t.Fatal("malformed sequence accepted")
+	}
+}
+
+// TestSequenceNonCanonicalTxs - BlockTx and NonCanonicalTxs ids are counted apart: moving a block takes
+// fresh ids of the destination, unwinding the BlockTx counter does not touch NonCanonicalTxs ids
+func TestSequenceNonCanonicalTxs(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, senders := signedTxs(t, []*ecdsa.PrivateKey{key}, 4)
+	for i, txn := range txs {
+		txn.SetFrom(senders[i])
+	}
+	a, b := types.Hash{0xaa}, types.Hash{0xbb}
+
+	db := openJournalDB(t, filepath.Join(t.TempDir(), "seq"))
+	defer db.Close()
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	putNonCanonicalBlock(t, tx, a, 5, txs[:2], true)
+	putNonCanonicalBlock(t, tx, b, 6, txs[2:], true)
+	for _, hash := range []types.Hash{a, b} {
+		base, err := IncrementSequence(tx, modules.BlockTx, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := PromoteNonCanonicalTxs(tx, hash[:], base); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// promotion does not give NonCanonicalTxs ids back
+	if next, err := ReadSequence(tx, modules.NonCanonicalTxs); err != nil || next != 8 {
+		t.Fatalf("NonCanonicalTxs sequence %d, %v", next, err)
+	}
+
+	// unwind of b: demoted to fresh NonCanonicalTxs ids, its BlockTx ids are freed
+	base, err := IncrementSequence(tx, modules.NonCanonicalTxs, 4)
+	if err != nil || base != 8 {
+		t.Fatalf("NonCanonicalTxs base %d, %v", base, err)
+	}
+	if err := DemoteCanonicalTxs(tx, b[:], base); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetSequence(tx, modules.BlockTx, 4); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockTxs(t, tx, modules.NonCanonicalTxs, b, 6, txs[2:], true)
+	checkBlockTxs(t, tx, modules.BlockTx, a, 5, txs[:2], true)
+
+	// b back on the canonical chain reuses the freed BlockTx ids
+	if base, err = IncrementSequence(tx, modules.BlockTx, 4); err != nil || base != 4 {
+		t.Fatalf("BlockTx base %d, %v", base, err)
+	}
+	if err := PromoteNonCanonicalTxs(tx, b[:], base); err != nil {
+		t.Fatal(err)
+	}
+	checkBlockTxs(t, tx, modules.BlockTx, b, 6, txs[2:], true)
+	if next, err := ReadSequence(tx, modules.NonCanonicalTxs); err != nil || next != 12 {
+		t.Fatalf("NonCanonicalTxs sequence %d, %v", next, err)
+	}
+}
+
+// FuzzSequence - ops are pairs of bytes: an even first byte increments by the second mod 16, an odd one
+// resets to the second. Ids reserved by increments are held until a reset frees them, an increment
+// must never return a held id.
+func FuzzSequence(f *testing.F) {
+	f.Add([]byte{0, 5, 0, 3, 1, 2, 0, 7})
+	f.Add([]byte{1, 9, 0, 15, 1, 15, 1, 0, 0, 1})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		_, tx := memdb.NewTestTx(t)
+		held := make(map[uint64]bool)
+		var next uint64
+		for i := 0; i+1 < len(ops); i += 2 {
+			arg := uint64(ops[i+1])
+			if ops[i]%2 == 0 {
+				amount := arg % 16
+				first, err := IncrementSequence(tx, modules.BlockTx, amount)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for id := first; id < first+amount; id++ {
+					if held[id] {
+						t.Fatalf("op %d: id %d handed out twice", i/2, id)
+					}
+					held[id] = true
+				}
+				next = first + amount
+			} else {
+				err := ResetSequence(tx, modules.BlockTx, arg)
+				if arg > next {
+					if err == nil {
+						t.Fatalf("op %d: reset from %d forward to %d", i/2, next, arg)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				for id := range held {
+					if id >= arg {
+						delete(held, id)
+					}
+				}
+				next = arg
+			}
+			if have, err := ReadSequence(tx, modules.BlockTx); err != nil || have != next {
+				t.Fatalf("op %d: sequence %d, want %d, %v", i/2, have, next, err)
+			}
+		}
+	})
+}
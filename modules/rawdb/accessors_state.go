@@ -88,12 +88,12 @@ func writeIndexAndChangeSet(changeDB kv.RwDB, blockNr types.Int256, addr types.A
 	index.Add(blockNr.Uint64())
 	//3. put bitmap
 	if err = bitmapdb.WalkChunkWithKeys64(addr.Bytes(), index, bitmapdb.ChunkLimit, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
-		buf := bytes.NewBuffer(nil)
-		if _, err = chunk.WriteTo(buf); err != nil {
+		v, err := bitmapdb.EncodeShard64(chunk)
+		if err != nil {
 			return err
 		}
 		//log.Debugf("put bitmap key %x, bitmap: %s", chunkKey, chunk.String())
-		err = txn.Put(kv.AccountsHistory, chunkKey, utils.Copy(buf.Bytes()))
+		err = txn.Put(kv.AccountsHistory, chunkKey, v)
 		if err != nil {
 			return err
 		}
@@ -150,7 +150,7 @@ func findIndexAndChangeSet(changeDB kv.RoDB, blockNr types.Int256, addr types.Ad
 	}
 
 	bitmapIndex = roaring64.New()
-	if _, err := bitmapIndex.ReadFrom(bytes.NewReader(findValue)); err != nil {
+	if err := bitmapdb.DecodeShard64(bitmapIndex, findValue); err != nil {
 		return nil, err
 	}
 
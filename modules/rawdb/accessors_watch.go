@@ -0,0 +1,271 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/avm/rlp"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// WatchHit is a change of a watched storage slot by a canonical block. A
+// retraction is appended for every hit whose block is unwound; it repeats the
+// fields of the hit and references it by RetractsSeq.
+type WatchHit struct {
+	Seq         uint64        `json:"seq" rlp:"-"`
+	Number      uint64        `json:"number"`
+	Hash        types.Hash    `json:"hash"`
+	Address     types.Address `json:"address"`
+	Slot        types.Hash    `json:"slot"`
+	Previous    []byte        `json:"previous"` // value before the block, empty if the slot was unset
+	Retracted   bool          `json:"retracted,omitempty"`
+	RetractsSeq uint64        `json:"retractsSeq,omitempty"`
+}
+
+// StorageWatchIndex is the compiled set of watched slots. A nil index
+// matches nothing.
+type StorageWatchIndex map[types.Address]map[types.Hash]struct{}
+
+// Addresses returns the watched addresses in byte order.
+func (idx StorageWatchIndex) Addresses() []types.Address {
+	addrs := make([]types.Address, 0, len(idx))
+	for addr := range idx {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
+// Match reports whether slot of addr is watched.
+func (idx StorageWatchIndex) Match(addr types.Address, slot types.Hash) bool {
+	_, ok := idx[addr][slot]
+	return ok
+}
+
+func storageWatchKey(addr types.Address, slot types.Hash) []byte {
+	k := make([]byte, types.AddressLength+types.HashLength)
+	copy(k, addr[:])
+	copy(k[types.AddressLength:], slot[:])
+	return k
+}
+
+// WriteStorageWatch adds slots of addr to the watchlist.
+func WriteStorageWatch(tx kv.RwTx, addr types.Address, slots []types.Hash) error {
+	for _, slot := range slots {
+		if err := tx.Put(modules.StorageWatch, storageWatchKey(addr, slot), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteStorageWatch removes slots of addr from the watchlist, every slot of
+// addr if slots is empty.
+func DeleteStorageWatch(tx kv.RwTx, addr types.Address, slots []types.Hash) error {
+	if len(slots) > 0 {
+		for _, slot := range slots {
+			if err := tx.Delete(modules.StorageWatch, storageWatchKey(addr, slot)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	c, err := tx.RwCursor(modules.StorageWatch)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for k, _, err := c.Seek(addr[:]); k != nil && bytes.HasPrefix(k, addr[:]); k, _, err = c.Next() {
+		if err != nil {
+			return err
+		}
+		if err := c.DeleteCurrent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadStorageWatchIndex compiles the persisted watchlist, nil if it is empty.
+func ReadStorageWatchIndex(tx kv.Tx) (StorageWatchIndex, error) {
+	var idx StorageWatchIndex
+	err := tx.ForEach(modules.StorageWatch, nil, func(k, _ []byte) error {
+		if len(k) != types.AddressLength+types.HashLength {
+			return fmt.Errorf("invalid storage watch key %x", k)
+		}
+		if idx == nil {
+			idx = make(StorageWatchIndex)
+		}
+		addr, slot := types.BytesToAddress(k[:types.AddressLength]), types.BytesToHash(k[types.AddressLength:])
+		if idx[addr] == nil {
+			idx[addr] = make(map[types.Hash]struct{})
+		}
+		idx[addr][slot] = struct{}{}
+		return nil
+	})
+	return idx, err
+}
+
+func appendWatchHit(tx kv.RwTx, hit *WatchHit) error {
+	base, err := tx.IncrementSequence(modules.StorageWatchHits, 1)
+	if err != nil {
+		return err
+	}
+	data, err := rlp.EncodeToBytes(hit)
+	if err != nil {
+		return err
+	}
+	hit.Seq = base + 1
+	return tx.Append(modules.StorageWatchHits, modules.EncodeBlockNumber(hit.Seq), data)
+}
+
+// AppendStorageWatchHits checks the storage changes of canonical block
+// number against idx and appends a hit for each watched slot changed. Only the
+// changesets of watched addresses are read, so an empty index costs nothing.
+func AppendStorageWatchHits(tx kv.RwTx, idx StorageWatchIndex, number uint64, hash types.Hash) ([]*WatchHit, error) {
+	if len(idx) == 0 {
+		return nil, nil
+	}
+	var hits []*WatchHit
+	prefix := make([]byte, modules.NumberLength+types.AddressLength)
+	binary.BigEndian.PutUint64(prefix, number)
+	for _, addr := range idx.Addresses() {
+		copy(prefix[modules.NumberLength:], addr[:])
+		// key: number + address + incarnation, value: slot + previous value
+		if err := tx.ForPrefix(modules.StorageChangeSet, prefix, func(_, v []byte) error {
+			if len(v) < types.HashLength {
+				return fmt.Errorf("storage changes purged for block %d", number)
+			}
+			slot := types.BytesToHash(v[:types.HashLength])
+			if !idx.Match(addr, slot) {
+				return nil
+			}
+			hits = append(hits, &WatchHit{
+				Number:   number,
+				Hash:     hash,
+				Address:  addr,
+				Slot:     slot,
+				Previous: types.CopyBytes(v[types.HashLength:]),
+			})
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	for _, hit := range hits {
+		if err := appendWatchHit(tx, hit); err != nil {
+			return nil, err
+		}
+	}
+	return hits, nil
+}
+
+// RetractStorageWatchHits appends a retraction for every hit of the unwound
+// blocks above ancestor that was not retracted yet.
+func RetractStorageWatchHits(tx kv.RwTx, ancestor uint64, unwound []types.Hash) ([]*WatchHit, error) {
+	if len(unwound) == 0 {
+		return nil, nil
+	}
+	dropped := make(map[types.Hash]struct{}, len(unwound))
+	for _, h := range unwound {
+		dropped[h] = struct{}{}
+	}
+
+	c, err := tx.Cursor(modules.StorageWatchHits)
+	if err != nil {
+		return nil, err
+	}
+	retracted := make(map[uint64]struct{})
+	var pending []*WatchHit
+	// hits are appended in chain order, walk back until the ancestor
+	for k, v, err := c.Last(); k != nil; k, v, err = c.Prev() {
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		hit := new(WatchHit)
+		if err := rlp.DecodeBytes(v, hit); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid watch hit %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		hit.Seq = binary.BigEndian.Uint64(k)
+		if hit.Retracted {
+			retracted[hit.RetractsSeq] = struct{}{}
+			continue
+		}
+		if hit.Number <= ancestor {
+			break
+		}
+		if _, ok := dropped[hit.Hash]; !ok {
+			continue
+		}
+		if _, ok := retracted[hit.Seq]; ok {
+			continue
+		}
+		pending = append(pending, hit)
+	}
+	c.Close()
+
+	res := make([]*WatchHit, 0, len(pending))
+	// retract newest first, like the unwind itself
+	for _, hit := range pending {
+		r := *hit
+		r.Retracted, r.RetractsSeq = true, hit.Seq
+		if err := appendWatchHit(tx, &r); err != nil {
+			return nil, err
+		}
+		res = append(res, &r)
+	}
+	return res, nil
+}
+
+// ReadStorageWatchHits returns up to limit hits and retractions with a
+// sequence greater than afterSeq, in sequence order.
+func ReadStorageWatchHits(tx kv.Tx, afterSeq uint64, limit int) ([]*WatchHit, error) {
+	var hits []*WatchHit
+	if limit <= 0 {
+		return hits, nil
+	}
+	c, err := tx.Cursor(modules.StorageWatchHits)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	for k, v, err := c.Seek(modules.EncodeBlockNumber(afterSeq + 1)); k != nil && len(hits) < limit; k, v, err = c.Next() {
+		if err != nil {
+			return nil, err
+		}
+		hit := new(WatchHit)
+		if err := rlp.DecodeBytes(v, hit); err != nil {
+			return nil, fmt.Errorf("invalid watch hit %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		hit.Seq = binary.BigEndian.Uint64(k)
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// ReadStorageWatchHead returns the sequence of the last appended hit.
+func ReadStorageWatchHead(tx kv.Tx) (uint64, error) {
+	return tx.ReadSequence(modules.StorageWatchHits)
+}
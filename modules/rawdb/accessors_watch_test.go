@@ -0,0 +1,125 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+func putStorageChange(t *testing.T, tx kv.RwTx, number uint64, addr types.Address, slot types.Hash, prev []byte) {
+	k := make([]byte, modules.NumberLength+types.AddressLength+types.IncarnationLength)
+	binary.BigEndian.PutUint64(k, number)
+	copy(k[modules.NumberLength:], addr[:])
+	binary.BigEndian.PutUint16(k[modules.NumberLength+types.AddressLength:], 1)
+	if err := tx.Put(modules.StorageChangeSet, k, append(append([]byte{}, slot[:]...), prev...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageWatchHits(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	oracle, other := types.Address{0x0a}, types.Address{0x0b}
+	price, pause, unwatched := types.Hash{0x01}, types.Hash{0x02}, types.Hash{0x03}
+
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		idx, err := ReadStorageWatchIndex(tx)
+		if err != nil || idx != nil {
+			t.Fatalf("empty watchlist compiled to %v, err %v", idx, err)
+		}
+		if hits, err := AppendStorageWatchHits(tx, idx, 1, types.Hash{1}); err != nil || len(hits) != 0 {
+			t.Fatalf("empty index hits %v, err %v", hits, err)
+		}
+		if err := WriteStorageWatch(tx, oracle, []types.Hash{price, pause}); err != nil {
+			return err
+		}
+		if err := WriteStorageWatch(tx, other, []types.Hash{price}); err != nil {
+			return err
+		}
+		if err := DeleteStorageWatch(tx, other, nil); err != nil {
+			return err
+		}
+		if idx, err = ReadStorageWatchIndex(tx); err != nil {
+			return err
+		}
+		if len(idx) != 1 || !idx.Match(oracle, price) || !idx.Match(oracle, pause) || idx.Match(other, price) {
+			t.Fatalf("unexpected index %v", idx)
+		}
+
+		// block 2 changes the watched slot, block 3 only unwatched slots
+		putStorageChange(t, tx, 2, oracle, price, []byte{7})
+		putStorageChange(t, tx, 2, other, price, []byte{8})
+		putStorageChange(t, tx, 3, oracle, unwatched, []byte{9})
+		hits, err := AppendStorageWatchHits(tx, idx, 2, types.Hash{2})
+		if err != nil {
+			return err
+		}
+		if len(hits) != 1 || hits[0].Seq != 1 || hits[0].Slot != price || hits[0].Previous[0] != 7 {
+			t.Fatalf("unexpected hits of changed slot %+v", hits)
+		}
+		if hits, err = AppendStorageWatchHits(tx, idx, 3, types.Hash{3}); err != nil || len(hits) != 0 {
+			t.Fatalf("unchanged slot hits %v, err %v", hits, err)
+		}
+
+		// block 4 is reorged out, block 2 stays canonical
+		putStorageChange(t, tx, 4, oracle, pause, nil)
+		if hits, err = AppendStorageWatchHits(tx, idx, 4, types.Hash{4}); err != nil || len(hits) != 1 {
+			t.Fatalf("hits of block 4 %v, err %v", hits, err)
+		}
+		retracted, err := RetractStorageWatchHits(tx, 3, []types.Hash{{4}})
+		if err != nil {
+			return err
+		}
+		if len(retracted) != 1 || !retracted[0].Retracted || retracted[0].RetractsSeq != 2 || retracted[0].Slot != pause {
+			t.Fatalf("unexpected retractions %+v", retracted)
+		}
+		// unwinding further does not retract twice
+		if retracted, err = RetractStorageWatchHits(tx, 1, []types.Hash{{2}, {3}, {4}}); err != nil {
+			return err
+		}
+		if len(retracted) != 1 || retracted[0].RetractsSeq != 1 {
+			t.Fatalf("unexpected second retractions %+v", retracted)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		head, err := ReadStorageWatchHead(tx)
+		if err != nil || head != 4 {
+			t.Fatalf("head %d, err %v", head, err)
+		}
+		hits, err := ReadStorageWatchHits(tx, 2, 10)
+		if err != nil {
+			return err
+		}
+		if len(hits) != 2 || hits[0].Seq != 3 || hits[1].Seq != 4 || !hits[0].Retracted || !hits[1].Retracted {
+			t.Fatalf("unexpected hits after 2: %+v", hits)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
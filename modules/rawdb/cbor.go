@@ -0,0 +1,137 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The subset of CBOR (RFC 8949) used by the stored receipts: unsigned
+// integers, byte strings and arrays, all with definite lengths.
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborArray = 4
+)
+
+var errCBORShort = errors.New("cbor: unexpected end of data")
+
+func appendCBORHead(buf []byte, major byte, v uint64) []byte {
+	m := major << 5
+	switch {
+	case v < 24:
+		return append(buf, m|byte(v))
+	case v <= 0xff:
+		return append(buf, m|24, byte(v))
+	case v <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, m|25), uint16(v))
+	case v <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, m|26), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, m|27), v)
+}
+
+func appendCBORUint(buf []byte, v uint64) []byte {
+	return appendCBORHead(buf, cborUint, v)
+}
+
+func appendCBORBytes(buf []byte, b []byte) []byte {
+	return append(appendCBORHead(buf, cborBytes, uint64(len(b))), b...)
+}
+
+func appendCBORArray(buf []byte, n int) []byte {
+	return appendCBORHead(buf, cborArray, uint64(n))
+}
+
+// cborDecoder reads the values written by the appendCBOR functions.
+type cborDecoder struct {
+	data []byte
+}
+
+func (d *cborDecoder) head(major byte) (uint64, error) {
+	if len(d.data) == 0 {
+		return 0, errCBORShort
+	}
+	b := d.data[0]
+	if b>>5 != major {
+		return 0, fmt.Errorf("cbor: major type %d, want %d", b>>5, major)
+	}
+	info, rest := b&0x1f, d.data[1:]
+	var v uint64
+	switch {
+	case info < 24:
+		v = uint64(info)
+	case info == 24 && len(rest) >= 1:
+		v, rest = uint64(rest[0]), rest[1:]
+	case info == 25 && len(rest) >= 2:
+		v, rest = uint64(binary.BigEndian.Uint16(rest)), rest[2:]
+	case info == 26 && len(rest) >= 4:
+		v, rest = uint64(binary.BigEndian.Uint32(rest)), rest[4:]
+	case info == 27 && len(rest) >= 8:
+		v, rest = binary.BigEndian.Uint64(rest), rest[8:]
+	case info <= 27:
+		return 0, errCBORShort
+	default:
+		return 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+	d.data = rest
+	return v, nil
+}
+
+func (d *cborDecoder) uint() (uint64, error) {
+	return d.head(cborUint)
+}
+
+func (d *cborDecoder) bytes() ([]byte, error) {
+	n, err := d.head(cborBytes)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(d.data)) < n {
+		return nil, errCBORShort
+	}
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// array reads the head of an array of want items.
+func (d *cborDecoder) array(want int) error {
+	n, err := d.head(cborArray)
+	if err != nil {
+		return err
+	}
+	if n != uint64(want) {
+		return fmt.Errorf("cbor: array of %d items, want %d", n, want)
+	}
+	return nil
+}
+
+// arrayLen reads the head of an array of any length.
+func (d *cborDecoder) arrayLen() (int, error) {
+	n, err := d.head(cborArray)
+	if err != nil {
+		return 0, err
+	}
+	// every item takes at least one byte
+	if n > uint64(len(d.data)) {
+		return 0, errCBORShort
+	}
+	return int(n), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/modules"
+)
+
+// Value formats of the block tables as written by this package, checked by kv.VerifyCodecs
+func init() {
+	for table, value := range map[string]func([]byte) error{
+		modules.Headers:   decodeHeader,
+		modules.HeaderTD:  decodeTd,
+		modules.BlockBody: decodeBodyForStorage,
+	} {
+		if err := kv.RegisterCodec(table, kv.Codec{Key: decodeHeaderKey, Value: value}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func decodeHeaderKey(k []byte) error {
+	_, _, err := kv.ParseHeaderKey(k)
+	return err
+}
+
+func decodeHeader(v []byte) (err error) {
+	// conversion from protobuf dereferences fields without nil checks
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("header %x: %v", v, r)
+		}
+	}()
+	return new(block.Header).Unmarshal(v)
+}
+
+func decodeTd(v []byte) error {
+	if len(v) > 32 {
+		return fmt.Errorf("total difficulty %x: longer than 32 bytes", v)
+	}
+	return nil
+}
+
+func decodeBodyForStorage(v []byte) error {
+	if len(v) != 8+4 {
+		return fmt.Errorf("body %x: unexpected length %d, want 12", v, len(v))
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/api/protocol/types_pb"
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	amckv "github.com/amazechain/amc/internal/kv"
+	"github.com/amazechain/amc/internal/kv/walk"
+	"github.com/amazechain/amc/modules"
+	"github.com/golang/protobuf/proto"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// Typed iterators decode records of one table for the caller. Contract:
+//   - single pass: records come in key order, an iterator can't be rewound;
+//   - Next moves to the next record, false at the end, on error and after Close. Check Err after the loop;
+//   - decoded values (Header, Receipts, Account) are reused: valid until the next call of Next, copy to keep;
+//   - accessors panic unless the last Next returned true;
+//   - an iterator is bound to its transaction: it must be closed before the tx commits or rolls back.
+//     Records of a closed tx are invalid, Next on such iterator ends with an error;
+//   - Close releases the cursor, it's safe to call Close more than once and to stop iterating early.
+
+// DecodeError - record which can't be decoded, Key is the offending key
+type DecodeError struct {
+	Table string
+	Key   []byte
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s record %x: %v", e.Table, e.Key, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// iterator - cursor walk shared by the typed iterators
+type iterator struct {
+	table  string
+	c      amckv.Cursor
+	owned  bool // cursor opened by the iterator, closed together with it
+	r      *walk.Range
+	valid  bool
+	closed bool
+	err    error
+}
+
+// next - raw record for the typed Next to decode
+func (it *iterator) next() (k, v []byte, ok bool) {
+	it.valid = false
+	if it.closed || it.err != nil {
+		return nil, nil, false
+	}
+	if !it.r.Next() {
+		it.err = it.r.Err()
+		return nil, nil, false
+	}
+	return it.r.Key(), it.r.Value(), true
+}
+
+// decoded - result of decoding the record returned by next
+func (it *iterator) decoded(k []byte, err error) bool {
+	if err != nil {
+		it.err = &DecodeError{Table: it.table, Key: append([]byte(nil), k...), Err: err}
+		return false
+	}
+	it.valid = true
+	return true
+}
+
+func (it *iterator) mustBeValid() {
+	if !it.valid {
+		panic(fmt.Sprintf("rawdb: %s iterator is not positioned on a record", it.table))
+	}
+}
+
+// Err - error which ended iteration, nil at the regular end
+func (it *iterator) Err() error { return it.err }
+
+func (it *iterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed, it.valid = true, false
+	if it.owned {
+		it.c.Close()
+	}
+}
+
+func openIterator(tx kv.Tx, table string) (iterator, error) {
+	c, err := tx.Cursor(table)
+	if err != nil {
+		return iterator{}, err
+	}
+	return iterator{table: table, c: c, owned: true}, nil
+}
+
+// HeaderIterator - headers of blocks [from, to), non-canonical included
+type HeaderIterator struct {
+	iterator
+	pb     types_pb.Header
+	header block.Header
+	hash   types.Hash
+}
+
+// IterateHeaders - headers of blocks [from, to) in block number order
+func IterateHeaders(tx kv.Tx, from, to uint64) (*HeaderIterator, error) {
+	it, err := openIterator(tx, modules.Headers)
+	if err != nil {
+		return nil, err
+	}
+	it.r = walk.NewRange(it.c, from, to)
+	return &HeaderIterator{iterator: it}, nil
+}
+
+// NewHeaderIterator - IterateHeaders over a Headers cursor owned by the caller
+func NewHeaderIterator(c amckv.Cursor, from, to uint64) *HeaderIterator {
+	return &HeaderIterator{iterator: iterator{table: modules.Headers, c: c, r: walk.NewRange(c, from, to)}}
+}
+
+func (it *HeaderIterator) Next() bool {
+	k, v, ok := it.next()
+	if !ok {
+		return false
+	}
+	if len(k) != modules.NumberLength+types.HashLength {
+		return it.decoded(k, fmt.Errorf("key length %d", len(k)))
+	}
+	it.hash.SetBytes(k[modules.NumberLength:])
+	if err := proto.Unmarshal(v, &it.pb); err != nil {
+		return it.decoded(k, err)
+	}
+	return it.decoded(k, it.header.FromProtoMessage(&it.pb))
+}
+
+func (it *HeaderIterator) BlockNum() uint64 { it.mustBeValid(); return it.r.BlockNum() }
+func (it *HeaderIterator) Hash() types.Hash { it.mustBeValid(); return it.hash }
+
+// Header - reused by the next call of Next, see block.CopyHeader
+func (it *HeaderIterator) Header() *block.Header { it.mustBeValid(); return &it.header }
+
+// ReceiptIterator - receipts of canonical blocks [from, to)
+type ReceiptIterator struct {
+	iterator
+	tx       kv.Tx // version 2 records take the logs from the Log table
+	pb       types_pb.Receipts
+	receipts block.Receipts
+}
+
+// IterateCanonicalReceipts - receipts of blocks [from, to) in block number order, blocks without receipts are skipped
+func IterateCanonicalReceipts(tx kv.Tx, from, to uint64) (*ReceiptIterator, error) {
+	it, err := openIterator(tx, modules.Receipts)
+	if err != nil {
+		return nil, err
+	}
+	it.r = walk.NewRange(it.c, from, to)
+	return &ReceiptIterator{iterator: it, tx: tx}, nil
+}
+
+func (it *ReceiptIterator) Next() bool {
+	k, v, ok := it.next()
+	if !ok {
+		return false
+	}
+	it.receipts = it.receipts[:0]
+	if len(v) > 0 && v[0] == receiptsV2 {
+		number, err := modules.DecodeBlockNumber(k)
+		if err == nil {
+			it.receipts, err = decodeReceiptsV2(it.tx, number, v[1:])
+		}
+		return it.decoded(k, err)
+	}
+	if len(v) > 0 && v[0] == receiptsV1 {
+		v = v[1:]
+	}
+	if err := proto.Unmarshal(v, &it.pb); err != nil {
+		return it.decoded(k, err)
+	}
+	return it.decoded(k, it.receipts.FromProtoMessage(&it.pb))
+}
+
+func (it *ReceiptIterator) BlockNum() uint64 { it.mustBeValid(); return it.r.BlockNum() }
+
+// Receipts - slice is reused by the next call of Next, receipts themselves are not
+func (it *ReceiptIterator) Receipts() block.Receipts { it.mustBeValid(); return it.receipts }
+
+// AccountIterator - accounts which address starts with prefix
+type AccountIterator struct {
+	iterator
+	acc  account.StateAccount
+	addr types.Address
+}
+
+// IterateAccounts - accounts which address starts with prefix in address order, nil prefix for all
+func IterateAccounts(tx kv.Tx, prefix []byte) (*AccountIterator, error) {
+	it, err := openIterator(tx, modules.Account)
+	if err != nil {
+		return nil, err
+	}
+	it.r = walk.NewPrefix(it.c, prefix)
+	return &AccountIterator{iterator: it}, nil
+}
+
+func (it *AccountIterator) Next() bool {
+	k, v, ok := it.next()
+	if !ok {
+		return false
+	}
+	if len(k) != types.AddressLength {
+		return it.decoded(k, fmt.Errorf("key length %d", len(k)))
+	}
+	it.addr.SetBytes(k)
+	return it.decoded(k, it.acc.DecodeForStorage(v))
+}
+
+func (it *AccountIterator) Address() types.Address { it.mustBeValid(); return it.addr }
+
+// Account - reused by the next call of Next, see StateAccount.Copy
+func (it *AccountIterator) Account() *account.StateAccount { it.mustBeValid(); return &it.acc }
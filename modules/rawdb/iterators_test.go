@@ -0,0 +1,420 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/amazechain/amc/api/protocol/types_pb"
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/golang/protobuf/proto"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+var errTxClosed = errors.New("tx is closed")
+
+// misuseTx - on Rollback marks itself closed instead of releasing the tx, cursors opened through it
+// fail from then on: an iterator which outlives its tx must end with errTxClosed, not read freed pages
+type misuseTx struct {
+	kv.Tx
+	closed bool
+}
+
+func (tx *misuseTx) Rollback() { tx.closed = true }
+
+func (tx *misuseTx) Cursor(table string) (kv.Cursor, error) {
+	c, err := tx.Tx.Cursor(table)
+	if err != nil {
+		return nil, err
+	}
+	return &misuseCursor{Cursor: c, tx: tx}, nil
+}
+
+type misuseCursor struct {
+	kv.Cursor
+	tx *misuseTx
+}
+
+func (c *misuseCursor) Seek(seek []byte) ([]byte, []byte, error) {
+	if c.tx.closed {
+		return nil, nil, errTxClosed
+	}
+	return c.Cursor.Seek(seek)
+}
+
+func (c *misuseCursor) Next() ([]byte, []byte, error) {
+	if c.tx.closed {
+		return nil, nil, errTxClosed
+	}
+	return c.Cursor.Next()
+}
+
+type typedIterator interface {
+	Next() bool
+	Err() error
+	Close()
+}
+
+func mustPanic(t *testing.T, what string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("%s: accessor didn't panic", what)
+		}
+	}()
+	fn()
+}
+
+// checkIteratorContract - verifies the contract documented in iterators.go. open opens the iterator
+// expected to yield want records, access calls all its accessors.
+func checkIteratorContract(t *testing.T, db kv.RoDB, want int, open func(kv.Tx) (typedIterator, error), access func(typedIterator)) {
+	t.Helper()
+	run := func(fn func(tx *misuseTx, it typedIterator)) {
+		t.Helper()
+		tx, err := db.BeginRo(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		mtx := &misuseTx{Tx: tx}
+		it, err := open(mtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		fn(mtx, it)
+	}
+
+	run(func(_ *misuseTx, it typedIterator) {
+		mustPanic(t, "before Next", func() { access(it) })
+		n := 0
+		for it.Next() {
+			access(it)
+			n++
+		}
+		if err := it.Err(); err != nil || n != want {
+			t.Fatalf("iterated %d records, want %d, err %v", n, want, err)
+		}
+		mustPanic(t, "after the end", func() { access(it) })
+		if it.Next() {
+			t.Fatalf("Next after the end returned true")
+		}
+	})
+	if want < 2 {
+		return
+	}
+	run(func(_ *misuseTx, it typedIterator) {
+		if !it.Next() {
+			t.Fatalf("no first record: %v", it.Err())
+		}
+		it.Close()
+		it.Close()
+		if it.Next() || it.Err() != nil {
+			t.Fatalf("Next after Close returned true or err %v", it.Err())
+		}
+		mustPanic(t, "after Close", func() { access(it) })
+	})
+	run(func(tx *misuseTx, it typedIterator) {
+		if !it.Next() {
+			t.Fatalf("no first record: %v", it.Err())
+		}
+		tx.Rollback()
+		if it.Next() {
+			t.Fatalf("Next returned a record of closed tx")
+		}
+		if !errors.Is(it.Err(), errTxClosed) {
+			t.Fatalf("err %v, want %v", it.Err(), errTxClosed)
+		}
+	})
+}
+
+func testHeader(number uint64, extra byte) *block.Header {
+	return &block.Header{
+		Number:     uint256.NewInt(number),
+		Difficulty: uint256.NewInt(2),
+		BaseFee:    uint256.NewInt(1),
+		GasLimit:   30_000_000,
+		Time:       1_600_000_000 + number,
+		Extra:      []byte{extra},
+	}
+}
+
+func TestIterateHeaders(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	// block 3 and 7 have a second, non-canonical header
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for n := uint64(0); n < 10; n++ {
+			WriteHeader(tx, testHeader(n, 0))
+			if n == 3 || n == 7 {
+				WriteHeader(tx, testHeader(n, 1))
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	it, err := IterateHeaders(tx, 2, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var got []uint64
+	for it.Next() {
+		h := it.Header()
+		if h.Number.Uint64() != it.BlockNum() {
+			t.Fatalf("header number %d under key of block %d", h.Number.Uint64(), it.BlockNum())
+		}
+		if want := ReadHeader(tx, it.Hash(), it.BlockNum()); want == nil || want.Hash() != h.Hash() {
+			t.Fatalf("block %d: decoded header differs from ReadHeader", it.BlockNum())
+		}
+		got = append(got, it.BlockNum())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2, 3, 3, 4, 5, 6, 7, 7}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("iterated blocks %v, want %v", got, want)
+	}
+
+	checkIteratorContract(t, db, 8, func(tx kv.Tx) (typedIterator, error) {
+		return IterateHeaders(tx, 2, 8)
+	}, func(it typedIterator) {
+		hi := it.(*HeaderIterator)
+		_, _, _ = hi.Header(), hi.Hash(), hi.BlockNum()
+	})
+}
+
+func TestIterateCanonicalReceipts(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	// blocks without transactions have no receipts
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for _, n := range []uint64{1, 2, 4, 6} {
+			receipts := make(block.Receipts, n)
+			for i := range receipts {
+				receipts[i] = &block.Receipt{Status: 1, GasUsed: 21000, BlockNumber: uint256.NewInt(n), TransactionIndex: uint(i)}
+			}
+			if err := WriteReceipts(tx, n, receipts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		it, err := IterateCanonicalReceipts(tx, 0, 100)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		var blocks []uint64
+		for it.Next() {
+			receipts := it.Receipts()
+			if uint64(len(receipts)) != it.BlockNum() {
+				t.Fatalf("block %d: %d receipts", it.BlockNum(), len(receipts))
+			}
+			for i, r := range receipts {
+				if r.BlockNumber.Uint64() != it.BlockNum() || r.TransactionIndex != uint(i) {
+					t.Fatalf("block %d: receipt %d decoded as block %d index %d", it.BlockNum(), i, r.BlockNumber.Uint64(), r.TransactionIndex)
+				}
+			}
+			blocks = append(blocks, it.BlockNum())
+		}
+		if fmt.Sprint(blocks) != "[1 2 4 6]" {
+			t.Fatalf("iterated blocks %v, want [1 2 4 6]", blocks)
+		}
+		return it.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkIteratorContract(t, db, 2, func(tx kv.Tx) (typedIterator, error) {
+		return IterateCanonicalReceipts(tx, 2, 6)
+	}, func(it typedIterator) {
+		ri := it.(*ReceiptIterator)
+		_, _ = ri.Receipts(), ri.BlockNum()
+	})
+}
+
+func TestIterateAccounts(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i := 0; i < 8; i++ {
+			acc := account.NewAccount()
+			acc.Nonce = uint64(i)
+			acc.Balance.SetUint64(uint64(1000 * i))
+			v := make([]byte, acc.EncodingLengthForStorage())
+			acc.EncodeForStorage(v)
+			addr := types.Address{byte(i / 4), byte(i)}
+			if err := tx.Put(modules.Account, addr[:], v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		it, err := IterateAccounts(tx, []byte{1})
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		n := 0
+		for it.Next() {
+			addr, acc := it.Address(), it.Account()
+			if addr[0] != 1 || acc.Nonce != uint64(addr[1]) || acc.Balance.Uint64() != 1000*acc.Nonce {
+				t.Fatalf("account %x decoded as nonce %d balance %d", addr, acc.Nonce, acc.Balance.Uint64())
+			}
+			n++
+		}
+		if n != 4 {
+			t.Fatalf("iterated %d accounts, want 4", n)
+		}
+		return it.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	checkIteratorContract(t, db, 8, func(tx kv.Tx) (typedIterator, error) {
+		return IterateAccounts(tx, nil)
+	}, func(it typedIterator) {
+		ai := it.(*AccountIterator)
+		_, _ = ai.Address(), ai.Account()
+	})
+}
+
+func TestIteratorDecodeError(t *testing.T) {
+	db := openJournalDB(t, t.TempDir())
+	defer db.Close()
+
+	bad := modules.HeaderKey(4, types.Hash{0xba, 0xd})
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for n := uint64(0); n < 8; n++ {
+			WriteHeader(tx, testHeader(n, 0))
+		}
+		return tx.Put(modules.Headers, bad, []byte{0xff}) // truncated varint
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(context.Background(), func(tx kv.Tx) error {
+		it, err := IterateHeaders(tx, 0, 8)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		n := 0
+		for it.Next() {
+			n++
+		}
+		var decodeErr *DecodeError
+		if !errors.As(it.Err(), &decodeErr) {
+			t.Fatalf("err %v, want DecodeError", it.Err())
+		}
+		if decodeErr.Table != modules.Headers || string(decodeErr.Key) != string(bad) {
+			t.Fatalf("DecodeError of %s %x, want %s %x", decodeErr.Table, decodeErr.Key, modules.Headers, bad)
+		}
+		if n > 5 || it.Next() {
+			t.Fatalf("iteration went on past the bad record")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkIterateHeaders - HeaderIterator against decoding every record into fresh objects the way ReadHeader does
+func BenchmarkIterateHeaders(b *testing.B) {
+	const headers = 10_000
+	db := openJournalDB(b, b.TempDir())
+	defer db.Close()
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for n := uint64(0); n < headers; n++ {
+			WriteHeader(tx, testHeader(n, 0))
+		}
+		return nil
+	}); err != nil {
+		b.Fatal(err)
+	}
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	b.Run("iterator", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			it, err := IterateHeaders(tx, 0, headers)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for it.Next() {
+				_ = it.Header()
+			}
+			if err := it.Err(); err != nil {
+				b.Fatal(err)
+			}
+			it.Close()
+		}
+	})
+	b.Run("per-record", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, err := tx.Cursor(modules.Headers)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for k, v, err := c.First(); k != nil; k, v, err = c.Next() {
+				if err != nil {
+					b.Fatal(err)
+				}
+				pb, header := new(types_pb.Header), new(block.Header)
+				if err := proto.Unmarshal(v, pb); err != nil {
+					b.Fatal(err)
+				}
+				if err := header.FromProtoMessage(pb); err != nil {
+					b.Fatal(err)
+				}
+			}
+			c.Close()
+		}
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/amazechain/amc/internal/avm/rlp"
+)
+
+// RemarshalHeader - decodes an RLP header list into its raw fields, lets mutate rewrite them in place and
+// re-encodes the list. Fields are kept as encoded, so trailing fields added by newer forks survive the
+// rewrite. Headers written by WriteHeader are protobuf, this is for RLP encoded ones.
+func RemarshalHeader(raw []byte, mutate func(fields []rlp.RawValue) error) ([]byte, error) {
+	var fields []rlp.RawValue
+	if err := rlp.DecodeBytes(raw, &fields); err != nil {
+		return nil, fmt.Errorf("header %x: %w", raw, err)
+	}
+	if mutate != nil {
+		if err := mutate(fields); err != nil {
+			return nil, err
+		}
+	}
+	for i, f := range fields {
+		if _, _, rest, err := rlp.Split(f); err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("header field %d: not a single RLP value: %x", i, []byte(f))
+		}
+	}
+	return rlp.EncodeToBytes(fields)
+}
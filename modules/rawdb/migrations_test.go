@@ -0,0 +1,85 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/avm/rlp"
+)
+
+func TestRemarshalHeader(t *testing.T) {
+	type futureHeader struct {
+		ParentHash types.Hash
+		Number     *big.Int
+		GasLimit   uint64
+		Extra      []byte
+		// fields of a newer fork unknown to the migration
+		Future1 uint64
+		Future2 []uint64
+	}
+	header := futureHeader{
+		ParentHash: types.BytesToHash([]byte{1, 2, 3}),
+		Number:     big.NewInt(100),
+		GasLimit:   30_000_000,
+		Extra:      []byte("extra"),
+		Future1:    7,
+		Future2:    []uint64{8, 9},
+	}
+	raw, err := rlp.EncodeToBytes(&header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := RemarshalHeader(raw, nil)
+	if err != nil || !bytes.Equal(same, raw) {
+		t.Fatalf("round trip changed the header: %x, err %v", same, err)
+	}
+
+	rewritten, err := RemarshalHeader(raw, func(fields []rlp.RawValue) error {
+		if len(fields) != 6 {
+			t.Fatalf("%d fields, want 6", len(fields))
+		}
+		fields[2], err = rlp.EncodeToBytes(uint64(15_000_000))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got futureHeader
+	if err := rlp.DecodeBytes(rewritten, &got); err != nil {
+		t.Fatal(err)
+	}
+	header.GasLimit = 15_000_000
+	if got.GasLimit != header.GasLimit || got.Future1 != 7 || len(got.Future2) != 2 || got.Future2[1] != 9 ||
+		got.Number.Cmp(header.Number) != 0 || got.ParentHash != header.ParentHash || !bytes.Equal(got.Extra, header.Extra) {
+		t.Fatalf("rewritten header %+v, want %+v", got, header)
+	}
+
+	if _, err := RemarshalHeader(raw, func(fields []rlp.RawValue) error {
+		fields[0] = rlp.RawValue{0x01, 0x02}
+		return nil
+	}); err == nil {
+		t.Fatal("invalid field accepted")
+	}
+	if _, err := RemarshalHeader([]byte{0x01}, nil); err == nil {
+		t.Fatal("non-list header accepted")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/amazechain/amc/log"
+	"github.com/amazechain/amc/modules/ethdb"
 )
 
 type handler struct {
@@ -157,6 +158,10 @@ func (h *handler) cancelAllRequests(err error, inflightReq *requestOp) {
 			didClose[op] = true
 		}
 	}
+	for id, sub := range h.clientSubs {
+		delete(h.clientSubs, id)
+		sub.close(err)
+	}
 }
 
 func (h *handler) addSubscriptions(nn []*Notifier) {
@@ -216,6 +221,9 @@ func (h *handler) handleSubscriptionResult(msg *jsonrpcMessage) {
 		log.Debug("Dropping invalid subscription message")
 		return
 	}
+	if h.clientSubs[result.ID] != nil {
+		h.clientSubs[result.ID].deliver(result.Result)
+	}
 }
 
 func (h *handler) handleResponse(msg *jsonrpcMessage) {
@@ -225,7 +233,23 @@ func (h *handler) handleResponse(msg *jsonrpcMessage) {
 		return
 	}
 	delete(h.respWait, string(msg.ID))
-	op.resp <- msg
+	// For normal responses, just forward the reply to Call/BatchCall.
+	if op.sub == nil {
+		op.resp <- msg
+		return
+	}
+	// For subscription responses, start the subscription if the server
+	// indicates success. Subscribe gets unblocked in either case through
+	// the op.resp channel.
+	defer close(op.resp)
+	if msg.Error != nil {
+		op.err = msg.Error
+		return
+	}
+	if op.err = json.Unmarshal(msg.Result, &op.sub.subid); op.err == nil {
+		go op.sub.run()
+		h.clientSubs[op.sub.subid] = op.sub
+	}
 }
 
 func (h *handler) handleCallMsg(ctx *callProc, msg *jsonrpcMessage) *jsonrpcMessage {
@@ -234,7 +258,12 @@ func (h *handler) handleCallMsg(ctx *callProc, msg *jsonrpcMessage) *jsonrpcMess
 	//case msg.isNotification():
 	case msg.isCall():
 		//log.Trace("begin "+msg.Method, "p", string(msg.Params))
+		parent := ctx.ctx
+		var reads *ethdb.ReadStats
+		ctx.ctx, reads = ethdb.WithReadStats(parent)
 		resp := h.handleCall(ctx, msg)
+		ctx.ctx = parent
+		h.observeCall(msg, resp, time.Since(start), reads)
 		var ctx []interface{}
 		ctx = append(ctx, "reqid", idForLog{msg.ID}, "t", time.Since(start), "p", string(msg.Params), "r", string(resp.Result))
 		if resp.Error != nil {
@@ -254,6 +283,21 @@ func (h *handler) handleCallMsg(ctx *callProc, msg *jsonrpcMessage) *jsonrpcMess
 	}
 }
 
+// observeCall updates the metrics of a served call and offers it to the slow query log
+func (h *handler) observeCall(msg, resp *jsonrpcMessage, elapsed time.Duration, reads *ethdb.ReadStats) {
+	known := msg.isSubscribe() || msg.isUnsubscribe() || h.reg.callback(msg.Method) != nil
+	updateCallMetrics(callMetricsName(msg.Method, known), msg, resp, elapsed)
+	q := SlowQuery{Method: msg.Method, Duration: elapsed, Time: time.Now()}
+	if !known {
+		q.Method = "unknown"
+	}
+	if resp.Error != nil {
+		q.Error = resp.Error.Code
+	}
+	q.KVReads, q.KVBytes = reads.Total()
+	defaultSlowQueries.observe(q, msg.Params)
+}
+
 func (h *handler) handleCall(cp *callProc, msg *jsonrpcMessage) *jsonrpcMessage {
 	if msg.isSubscribe() {
 		return h.handleSubscribe(cp, msg)
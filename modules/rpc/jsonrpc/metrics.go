@@ -0,0 +1,204 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package jsonrpc
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// DefaultSlowQueries is the number of slowest calls kept by the default slow query log.
+	DefaultSlowQueries = 32
+	// maxParamString is the longest string parameter kept whole in a slow query, longer ones are shortened.
+	maxParamString = 66
+	// maxParams is the size of captured parameters, beyond it they are dropped.
+	maxParams = 2048
+)
+
+var defaultSlowQueries = NewSlowQueryLog(DefaultSlowQueries, false)
+
+// DefaultSlowQueryLog returns the slow query log all servers record to.
+func DefaultSlowQueryLog() *SlowQueryLog { return defaultSlowQueries }
+
+// callMetricsName returns the metrics prefix of a method: rpc/<namespace>/<method>. Calls of methods
+// which are not registered share rpc/unknown, so clients can't grow the registry.
+func callMetricsName(method string, known bool) string {
+	if !known {
+		return "rpc/unknown"
+	}
+	return "rpc/" + strings.Replace(method, serviceMethodSeparator, "/", 1)
+}
+
+// updateCallMetrics records a served call: its latency, the sizes of its params and result, and the error
+// code of a failed one.
+func updateCallMetrics(name string, msg, resp *jsonrpcMessage, elapsed time.Duration) {
+	metrics.GetOrRegisterTimer(name+"/duration", nil).Update(elapsed)
+	sizeHistogram(name + "/request/size").Update(int64(len(msg.Params)))
+	sizeHistogram(name + "/response/size").Update(int64(len(resp.Result)))
+	if resp.Error != nil {
+		metrics.GetOrRegisterCounter(name+"/errors/"+strconv.Itoa(resp.Error.Code), nil).Inc(1)
+	}
+}
+
+func sizeHistogram(name string) metrics.Histogram {
+	return metrics.GetOrRegisterHistogram(name, nil, metrics.NewExpDecaySample(1028, 0.015))
+}
+
+// SlowQuery is a call kept by a SlowQueryLog.
+type SlowQuery struct {
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`   // sanitized, only with parameter capture
+	BlockTag string          `json:"blockTag,omitempty"` // block the call was made at, if any
+	Duration time.Duration   `json:"duration"`
+	Time     time.Time       `json:"time"`
+	Error    int             `json:"error,omitempty"` // JSON-RPC error code of a failed call
+	KVReads  uint64          `json:"kvReads"`
+	KVBytes  uint64          `json:"kvBytes"`
+}
+
+// SlowQueryLog keeps the slowest calls served, up to a fixed number. The parameters of calls are
+// only kept if capture is enabled, as they may identify users.
+type SlowQueryLog struct {
+	mu            sync.Mutex
+	size          int
+	captureParams bool
+	queries       slowQueryHeap
+}
+
+// NewSlowQueryLog returns a log of the size slowest calls.
+func NewSlowQueryLog(size int, captureParams bool) *SlowQueryLog {
+	return &SlowQueryLog{size: size, captureParams: captureParams}
+}
+
+// Configure resizes the log and switches parameter capture, clearing the kept calls.
+func (l *SlowQueryLog) Configure(size int, captureParams bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size, l.captureParams, l.queries = size, captureParams, nil
+}
+
+// Queries returns the kept calls, slowest first.
+func (l *SlowQueryLog) Queries() []SlowQuery {
+	l.mu.Lock()
+	res := append([]SlowQuery(nil), l.queries...)
+	l.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Duration > res[j].Duration })
+	return res
+}
+
+// observe keeps q if it is among the slowest calls, params are sanitized only then.
+func (l *SlowQueryLog) observe(q SlowQuery, params json.RawMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size <= 0 {
+		return
+	}
+	if len(l.queries) >= l.size {
+		if l.queries[0].Duration >= q.Duration {
+			return
+		}
+		heap.Pop(&l.queries)
+	}
+	q.BlockTag = blockTag(params)
+	if l.captureParams {
+		q.Params = sanitizeParams(params)
+	}
+	heap.Push(&l.queries, q)
+}
+
+// slowQueryHeap is a min-heap by duration, the fastest kept call is evicted first
+type slowQueryHeap []SlowQuery
+
+func (h slowQueryHeap) Len() int            { return len(h) }
+func (h slowQueryHeap) Less(i, j int) bool  { return h[i].Duration < h[j].Duration }
+func (h slowQueryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowQueryHeap) Push(x interface{}) { *h = append(*h, x.(SlowQuery)) }
+
+func (h *slowQueryHeap) Pop() interface{} {
+	old := *h
+	q := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return q
+}
+
+// blockTag returns the block parameter of a call: the last positional string which is a block tag or
+// number, or the block hash or number of a trailing object
+func blockTag(params json.RawMessage) string {
+	var args []interface{}
+	if json.Unmarshal(params, &args) != nil {
+		return ""
+	}
+	for i := len(args) - 1; i >= 0; i-- {
+		switch arg := args[i].(type) {
+		case string:
+			switch {
+			case arg == "latest" || arg == "pending" || arg == "earliest" || arg == "safe" || arg == "finalized":
+				return arg
+			case strings.HasPrefix(arg, "0x") && len(arg) <= 18:
+				return arg
+			}
+		case map[string]interface{}:
+			for _, key := range []string{"blockHash", "blockNumber"} {
+				if s, ok := arg[key].(string); ok {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sanitizeParams shortens long strings, e.g. call data and raw transactions, and drops params which
+// remain too large
+func sanitizeParams(params json.RawMessage) json.RawMessage {
+	var args interface{}
+	if json.Unmarshal(params, &args) != nil {
+		return nil
+	}
+	res, err := json.Marshal(shortenStrings(args))
+	if err != nil || len(res) > maxParams {
+		return nil
+	}
+	return res
+}
+
+func shortenStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if len(v) > maxParamString {
+			return fmt.Sprintf("%s...(%d chars)", v[:maxParamString], len(v))
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = shortenStrings(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = shortenStrings(v[k])
+		}
+	}
+	return v
+}
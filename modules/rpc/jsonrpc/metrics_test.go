@@ -0,0 +1,117 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/modules/ethdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+const slowCallDelay = 50 * time.Millisecond
+
+type slowService struct {
+	db kv.RwDB
+}
+
+func (s *slowService) Fast() int { return 0 }
+
+// Scan reads all canonical hashes, slowly
+func (s *slowService) Scan(ctx context.Context, block string) (int, error) {
+	time.Sleep(slowCallDelay)
+	var n int
+	err := s.db.View(ctx, func(tx kv.Tx) error {
+		return tx.ForEach(kv.HeaderCanonical, nil, func(k, v []byte) error {
+			n++
+			return nil
+		})
+	})
+	return n, err
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i := uint64(0); i < 3; i++ {
+			k := make([]byte, 8)
+			binary.BigEndian.PutUint64(k, i)
+			if err := tx.Put(kv.HeaderCanonical, k, make([]byte, 32)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultSlowQueries.Configure(1, true)
+	defer defaultSlowQueries.Configure(DefaultSlowQueries, false)
+
+	server := NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("test", &slowService{db: ethdb.WithReadAccounting(kv.ChainDB, db)}); err != nil {
+		t.Fatal(err)
+	}
+	client := DialInProc(server)
+	defer client.Close()
+
+	var n int
+	if err := client.Call(&n, "test_scan", "latest"); err != nil || n != 3 {
+		t.Fatalf("scan: %d, %v", n, err)
+	}
+	// faster calls don't evict the slowest one
+	for i := 0; i < 5; i++ {
+		if err := client.Call(&n, "test_fast"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queries := DefaultSlowQueryLog().Queries()
+	if len(queries) != 1 {
+		t.Fatalf("%d queries kept, want 1", len(queries))
+	}
+	q := queries[0]
+	if q.Method != "test_scan" || q.Duration < slowCallDelay || q.BlockTag != "latest" {
+		t.Fatalf("kept %s at %q in %v", q.Method, q.BlockTag, q.Duration)
+	}
+	if q.KVReads != 3 || q.KVBytes != 3*(8+32) {
+		t.Fatalf("kv reads %d, bytes %d", q.KVReads, q.KVBytes)
+	}
+	if string(q.Params) != `["latest"]` {
+		t.Fatalf("params %s", q.Params)
+	}
+}
+
+func TestSanitizeParams(t *testing.T) {
+	long := "0x" + strings.Repeat("ab", maxParamString)
+	res := sanitizeParams([]byte(`[{"data":"` + long + `"},"latest"]`))
+	if want := `[{"data":"` + long[:maxParamString] + `...(134 chars)"},"latest"]`; string(res) != want {
+		t.Fatalf("sanitized %s, want %s", res, want)
+	}
+	if res := sanitizeParams([]byte(`[` + strings.Repeat(`1,`, maxParams) + `1]`)); res != nil {
+		t.Fatalf("oversized params kept: %d bytes", len(res))
+	}
+	if tag := blockTag([]byte(`[{"to":"0x01"},"0x10"]`)); tag != "0x10" {
+		t.Fatalf("block tag %q", tag)
+	}
+}
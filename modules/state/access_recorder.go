@@ -0,0 +1,96 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+)
+
+var _ StateReader = (*AccessRecorder)(nil)
+
+// AccessRecorder is a StateReader recording the accounts and storage slots
+// read through it. Reads served from the caches of an IntraBlockState never
+// reach it, so wrapped around the reader of a block it records the first
+// access of every account and slot the block touched, read-only ones included.
+type AccessRecorder struct {
+	StateReader
+	accounts map[types.Address]map[types.Hash]struct{}
+}
+
+func NewAccessRecorder(r StateReader) *AccessRecorder {
+	return &AccessRecorder{
+		StateReader: r,
+		accounts:    make(map[types.Address]map[types.Hash]struct{}),
+	}
+}
+
+func (r *AccessRecorder) touch(address types.Address) map[types.Hash]struct{} {
+	slots, ok := r.accounts[address]
+	if !ok {
+		slots = make(map[types.Hash]struct{})
+		r.accounts[address] = slots
+	}
+	return slots
+}
+
+func (r *AccessRecorder) ReadAccountData(address types.Address) (*account.StateAccount, error) {
+	r.touch(address)
+	return r.StateReader.ReadAccountData(address)
+}
+
+func (r *AccessRecorder) ReadAccountStorage(address types.Address, incarnation uint16, key *types.Hash) ([]byte, error) {
+	r.touch(address)[*key] = struct{}{}
+	return r.StateReader.ReadAccountStorage(address, incarnation, key)
+}
+
+func (r *AccessRecorder) ReadAccountCode(address types.Address, incarnation uint16, codeHash types.Hash) ([]byte, error) {
+	r.touch(address)
+	return r.StateReader.ReadAccountCode(address, incarnation, codeHash)
+}
+
+func (r *AccessRecorder) ReadAccountCodeSize(address types.Address, incarnation uint16, codeHash types.Hash) (int, error) {
+	r.touch(address)
+	return r.StateReader.ReadAccountCodeSize(address, incarnation, codeHash)
+}
+
+func (r *AccessRecorder) ReadAccountIncarnation(address types.Address) (uint16, error) {
+	r.touch(address)
+	return r.StateReader.ReadAccountIncarnation(address)
+}
+
+// AccessList returns the recorded accesses, addresses and their storage keys
+// in ascending order.
+func (r *AccessRecorder) AccessList() transaction.AccessList {
+	list := make(transaction.AccessList, 0, len(r.accounts))
+	for address, slots := range r.accounts {
+		tuple := transaction.AccessTuple{Address: address, StorageKeys: make([]types.Hash, 0, len(slots))}
+		for slot := range slots {
+			tuple.StorageKeys = append(tuple.StorageKeys, slot)
+		}
+		sort.Slice(tuple.StorageKeys, func(i, j int) bool {
+			return bytes.Compare(tuple.StorageKeys[i][:], tuple.StorageKeys[j][:]) < 0
+		})
+		list = append(list, tuple)
+	}
+	sort.Slice(list, func(i, j int) bool { return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0 })
+	return list
+}
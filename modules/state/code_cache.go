@@ -0,0 +1,286 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/amazechain/amc/common/types"
+	"github.com/rcrowley/go-metrics"
+)
+
+const defaultCodeCacheSize = 64 << 20
+
+// DefaultCodeCache is shared by block execution and the RPC state readers.
+var DefaultCodeCache = NewCodeCache(defaultCodeCacheSize)
+
+// CodeCache caches contract code by code hash within a byte budget. Code of
+// a hash never changes, so entries don't go stale: Remove is only needed
+// when code records are deleted.
+//
+// The cache is a W-TinyLFU: new code enters a small LRU window, and code
+// falling out of the window moves to the main LRU only if it was requested
+// more often than each entry it would evict there, as counted by a decaying
+// frequency sketch. Codes requested twice in a row are served by the window,
+// while floods of one-off lookups can't push big popular contracts out of
+// the main LRU.
+type CodeCache struct {
+	mu        sync.Mutex
+	window    *sizedLRU
+	main      *sizedLRU
+	sketch    *frequencySketch
+	consumers map[string]*CodeCacheConsumer
+}
+
+// NewCodeCache creates a cache holding up to size bytes of code, keys
+// included. One percent of it is the admission window.
+func NewCodeCache(size int) *CodeCache {
+	window := size / 100
+	return &CodeCache{
+		window:    newSizedLRU(window),
+		main:      newSizedLRU(size - window),
+		sketch:    newFrequencySketch(size / 1024),
+		consumers: make(map[string]*CodeCacheConsumer),
+	}
+}
+
+// Consumer returns the view of the cache counting hits, misses and bytes
+// under label, e.g. "execution" or "rpc". Consumers of DefaultCodeCache
+// register their counters as state/code/<label>/{hit,miss,hitbytes,missbytes}.
+func (c *CodeCache) Consumer(label string) *CodeCacheConsumer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if consumer, ok := c.consumers[label]; ok {
+		return consumer
+	}
+	consumer := &CodeCacheConsumer{
+		cache:     c,
+		hits:      metrics.NewCounter(),
+		misses:    metrics.NewCounter(),
+		hitBytes:  metrics.NewCounter(),
+		missBytes: metrics.NewCounter(),
+	}
+	if c == DefaultCodeCache {
+		prefix := "state/code/" + label + "/"
+		metrics.Register(prefix+"hit", consumer.hits)
+		metrics.Register(prefix+"miss", consumer.misses)
+		metrics.Register(prefix+"hitbytes", consumer.hitBytes)
+		metrics.Register(prefix+"missbytes", consumer.missBytes)
+	}
+	c.consumers[label] = consumer
+	return consumer
+}
+
+func (c *CodeCache) get(codeHash types.Hash) ([]byte, bool) {
+	key := string(codeHash[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sketch.increment(codeHash)
+	if v, ok := c.window.get(key); ok {
+		return v.([]byte), true
+	}
+	if v, ok := c.main.get(key); ok {
+		return v.([]byte), true
+	}
+	return nil, false
+}
+
+func (c *CodeCache) add(codeHash types.Hash, code []byte) {
+	key := string(codeHash[:])
+	size := len(key) + len(code)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.window.items[key]; ok {
+		return
+	}
+	if _, ok := c.main.items[key]; ok {
+		return
+	}
+	if size > c.window.limit {
+		c.admit(&sizedEntry{key: key, value: code, size: size})
+		return
+	}
+	for c.window.size+size > c.window.limit {
+		e := c.window.ll.Back()
+		c.window.remove(e)
+		c.admit(e.Value.(*sizedEntry))
+	}
+	c.window.add(key, code, size)
+}
+
+// admit moves a candidate into the main LRU if it fits without evicting
+// entries requested at least as often.
+func (c *CodeCache) admit(candidate *sizedEntry) {
+	if candidate.size > c.main.limit {
+		return
+	}
+	freq := c.sketch.estimate(types.BytesToHash([]byte(candidate.key)))
+	need := c.main.size + candidate.size - c.main.limit
+	for e := c.main.ll.Back(); need > 0 && e != nil; e = e.Prev() {
+		victim := e.Value.(*sizedEntry)
+		if c.sketch.estimate(types.BytesToHash([]byte(victim.key))) >= freq {
+			return
+		}
+		need -= victim.size
+	}
+	c.main.add(candidate.key, candidate.value, candidate.size)
+}
+
+// Remove drops the code of codeHash, to be called when code records are deleted.
+func (c *CodeCache) Remove(codeHash types.Hash) {
+	key := string(codeHash[:])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.window.items[key]; ok {
+		c.window.remove(e)
+	}
+	if e, ok := c.main.items[key]; ok {
+		c.main.remove(e)
+	}
+}
+
+// Size returns the number of cached codes and their total size in bytes, keys included.
+func (c *CodeCache) Size() (entries, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.window.len() + c.main.len(), c.window.size + c.main.size
+}
+
+// CodeCacheConsumer reads code through a CodeCache, counting under its label.
+type CodeCacheConsumer struct {
+	cache               *CodeCache
+	hits, misses        metrics.Counter
+	hitBytes, missBytes metrics.Counter
+}
+
+// Get returns the code of codeHash from the cache, or from read on a miss.
+// The returned code is shared and must not be modified.
+func (c *CodeCacheConsumer) Get(codeHash types.Hash, read func() ([]byte, error)) ([]byte, error) {
+	if code, ok := c.cache.get(codeHash); ok {
+		c.hits.Inc(1)
+		c.hitBytes.Inc(int64(len(code)))
+		return code, nil
+	}
+	code, err := read()
+	if err != nil || len(code) == 0 {
+		return code, err
+	}
+	c.misses.Inc(1)
+	c.missBytes.Inc(int64(len(code)))
+	code = types.CopyBytes(code)
+	c.cache.add(codeHash, code)
+	return code, nil
+}
+
+// CodeCacheStats are the counters of a CodeCacheConsumer.
+type CodeCacheStats struct {
+	Hits, Misses        int64
+	HitBytes, MissBytes int64
+}
+
+// Stats returns the counters of the consumer.
+func (c *CodeCacheConsumer) Stats() CodeCacheStats {
+	return CodeCacheStats{
+		Hits:      c.hits.Count(),
+		Misses:    c.misses.Count(),
+		HitBytes:  c.hitBytes.Count(),
+		MissBytes: c.missBytes.Count(),
+	}
+}
+
+var _ StateReader = (*CodeCachingReader)(nil)
+
+// CodeCachingReader is a StateReader serving contract code through a CodeCache.
+type CodeCachingReader struct {
+	StateReader
+	codes *CodeCacheConsumer
+}
+
+func NewCodeCachingReader(r StateReader, codes *CodeCacheConsumer) *CodeCachingReader {
+	return &CodeCachingReader{StateReader: r, codes: codes}
+}
+
+func (r *CodeCachingReader) ReadAccountCode(address types.Address, incarnation uint16, codeHash types.Hash) ([]byte, error) {
+	if bytes.Equal(codeHash[:], emptyCodeHash) {
+		return nil, nil
+	}
+	return r.codes.Get(codeHash, func() ([]byte, error) {
+		return r.StateReader.ReadAccountCode(address, incarnation, codeHash)
+	})
+}
+
+func (r *CodeCachingReader) ReadAccountCodeSize(address types.Address, incarnation uint16, codeHash types.Hash) (int, error) {
+	code, err := r.ReadAccountCode(address, incarnation, codeHash)
+	return len(code), err
+}
+
+// frequencySketch is a count-min sketch of 4-bit counters estimating how
+// often a code hash was requested. Counters are halved every 10 * width
+// increments, so the estimates follow changes of popularity.
+type frequencySketch struct {
+	rows             [4][]uint8
+	mask             uint64
+	additions, reset int
+}
+
+func newFrequencySketch(width int) *frequencySketch {
+	w := 1024
+	for w < width {
+		w <<= 1
+	}
+	s := &frequencySketch{mask: uint64(w - 1), reset: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// code hashes are uniformly distributed, each row is indexed by one of their words
+func (s *frequencySketch) index(h types.Hash, row int) uint64 {
+	return binary.LittleEndian.Uint64(h[row*8:]) & s.mask
+}
+
+func (s *frequencySketch) increment(h types.Hash) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+	if s.additions++; s.additions >= s.reset {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *frequencySketch) estimate(h types.Hash) uint8 {
+	min := uint8(15)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/amazechain/amc/common/types"
+)
+
+type codeLookup struct {
+	hash types.Hash
+	size int
+}
+
+// loadCodeTrace reads testdata/code_trace.txt.gz, a synthetic trace of code
+// lookups shaped like mainnet execution: a few big, heavily used contracts
+// (routers, tokens), a long tail of medium sized ones and a steady stream of
+// one-off small codes (minimal proxies, fresh deployments). Each line holds a
+// contract id and its code size.
+func loadCodeTrace(tb testing.TB) []codeLookup {
+	f, err := os.Open("testdata/code_trace.txt.gz")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var trace []codeLookup
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var id uint64
+		var size int
+		if _, err := fmt.Sscan(scanner.Text(), &id, &size); err != nil {
+			tb.Fatalf("bad trace line %q: %v", scanner.Text(), err)
+		}
+		trace = append(trace, codeLookup{hash: sha256.Sum256([]byte(fmt.Sprint(id))), size: size})
+	}
+	if err := scanner.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return trace
+}
+
+// replayCodeTrace returns the number of Code table reads of the trace.
+func replayCodeTrace(trace []codeLookup, get func(types.Hash, func() ([]byte, error)) ([]byte, error)) (reads int) {
+	for _, l := range trace {
+		size := l.size
+		get(l.hash, func() ([]byte, error) {
+			reads++
+			return make([]byte, size), nil
+		})
+	}
+	return reads
+}
+
+// entryCountCache is an LRU bounded by the number of entries, like the code
+// cache of kvcache.
+func entryCountCache(entries int) func(types.Hash, func() ([]byte, error)) ([]byte, error) {
+	lru := newSizedLRU(entries)
+	return func(h types.Hash, read func() ([]byte, error)) ([]byte, error) {
+		if v, ok := lru.get(string(h[:])); ok {
+			return v.([]byte), nil
+		}
+		code, err := read()
+		if err == nil {
+			lru.add(string(h[:]), code, 1)
+		}
+		return code, err
+	}
+}
+
+func TestCodeCache(t *testing.T) {
+	cache := NewCodeCache(1 << 20)
+	codes := cache.Consumer("test")
+	h := types.BytesToHash([]byte{1})
+
+	reads := 0
+	read := func() ([]byte, error) {
+		reads++
+		return []byte{0x60, 0x00}, nil
+	}
+	for i := 0; i < 3; i++ {
+		code, err := codes.Get(h, read)
+		if err != nil || len(code) != 2 {
+			t.Fatalf("get %d: code %x, err %v", i, code, err)
+		}
+	}
+	if reads != 1 {
+		t.Fatalf("code read %d times, want 1", reads)
+	}
+	if stats := codes.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.HitBytes != 4 || stats.MissBytes != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	cache.Remove(h)
+	if _, err := codes.Get(h, read); err != nil || reads != 2 {
+		t.Fatalf("removed code not read again, reads %d, err %v", reads, err)
+	}
+	if cache.Consumer("test") != codes {
+		t.Fatal("consumer of the same label not reused")
+	}
+
+	// missing code is not cached
+	if _, err := codes.Get(types.BytesToHash([]byte{2}), func() ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := cache.Size(); entries != 1 {
+		t.Fatalf("%d entries cached, want 1", entries)
+	}
+}
+
+func TestCodeCacheAdmission(t *testing.T) {
+	cache := NewCodeCache(64 << 10)
+	codes := cache.Consumer("test")
+	code := func(size int) func() ([]byte, error) {
+		return func() ([]byte, error) { return make([]byte, size), nil }
+	}
+
+	popular := types.Hash(sha256.Sum256([]byte("popular")))
+	for i := 0; i < 10; i++ {
+		codes.Get(popular, code(24<<10))
+	}
+	// a flood of one-off codes must not evict the popular one
+	for i := 0; i < 10_000; i++ {
+		codes.Get(sha256.Sum256([]byte(fmt.Sprint(i))), code(500))
+	}
+	misses := codes.Stats().Misses
+	codes.Get(popular, code(24<<10))
+	if codes.Stats().Misses != misses {
+		t.Fatal("popular code evicted by one-off lookups")
+	}
+	if _, size := cache.Size(); size > 64<<10 {
+		t.Fatalf("cache holds %d bytes, over its budget", size)
+	}
+}
+
+func TestCodeCacheTrace(t *testing.T) {
+	const budget = 4 << 20
+	trace := loadCodeTrace(t)
+
+	var total int
+	for _, l := range trace {
+		total += l.size
+	}
+	// the entry count cache gets the same memory for the average code size
+	baseline := replayCodeTrace(trace, entryCountCache(budget/(total/len(trace))))
+	reads := replayCodeTrace(trace, NewCodeCache(budget).Consumer("trace").Get)
+
+	t.Logf("lookups %d, code reads: entry count LRU %d, code cache %d", len(trace), baseline, reads)
+	if reads >= baseline {
+		t.Fatalf("code cache reads %d codes, entry count LRU %d", reads, baseline)
+	}
+}
+
+func BenchmarkCodeCacheTrace(b *testing.B) {
+	const budget = 4 << 20
+	trace := loadCodeTrace(b)
+	var total int
+	for _, l := range trace {
+		total += l.size
+	}
+
+	b.Run("entrycount", func(b *testing.B) {
+		var reads int
+		for i := 0; i < b.N; i++ {
+			reads = replayCodeTrace(trace, entryCountCache(budget/(total/len(trace))))
+		}
+		b.ReportMetric(100*float64(len(trace)-reads)/float64(len(trace)), "hit%")
+		b.ReportMetric(float64(reads), "reads")
+	})
+	b.Run("codecache", func(b *testing.B) {
+		var reads int
+		for i := 0; i < b.N; i++ {
+			reads = replayCodeTrace(trace, NewCodeCache(budget).Consumer("trace").Get)
+		}
+		b.ReportMetric(100*float64(len(trace)-reads)/float64(len(trace)), "hit%")
+		b.ReportMetric(float64(reads), "reads")
+	})
+}
@@ -1,11 +1,9 @@
 package state
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/amazechain/amc/common/account"
 	"github.com/amazechain/amc/common/crypto"
-	"github.com/amazechain/amc/common/types"
 	"github.com/amazechain/amc/modules"
 	"math"
 
@@ -37,7 +35,6 @@ func originalAccountData(original *account.StateAccount, omitHashes bool) []byte
 }
 
 func writeIndex(blocknum uint64, changes *changeset.ChangeSet, bucket string, changeDb kv.RwTx) error {
-	buf := bytes.NewBuffer(nil)
 	for _, change := range changes.Changes {
 		k := modules.CompositeKeyWithoutIncarnation(change.Key)
 
@@ -47,11 +44,11 @@ func writeIndex(blocknum uint64, changes *changeset.ChangeSet, bucket string, ch
 		}
 		index.Add(blocknum)
 		if err = bitmapdb.WalkChunkWithKeys64(k, index, bitmapdb.ChunkLimit, func(chunkKey []byte, chunk *roaring64.Bitmap) error {
-			buf.Reset()
-			if _, err = chunk.WriteTo(buf); err != nil {
+			v, err := bitmapdb.EncodeShard64(chunk)
+			if err != nil {
 				return err
 			}
-			return changeDb.Put(bucket, chunkKey, types.CopyBytes(buf.Bytes()))
+			return changeDb.Put(bucket, chunkKey, v)
 		}); err != nil {
 			return err
 		}
@@ -30,14 +30,27 @@ func GetAsOf(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, storage bool
 }
 
 func FindByHistory(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, storage bool, key []byte, timestamp uint64) ([]byte, error) {
-	var csBucket string
+	index, err := historyShard(indexC, storage, key, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	changeSetBlock, ok := bitmapdb.SeekInBitmap64(index, timestamp)
+	if !ok {
+		return nil, ethdb.ErrKeyNotFound
+	}
+	return historyChange(tx, changesC, storage, key, changeSetBlock)
+}
+
+func historyBucket(storage bool) string {
 	if storage {
-		csBucket = modules.StorageChangeSet
-	} else {
-		csBucket = modules.AccountChangeSet
+		return modules.StorageChangeSet
 	}
+	return modules.AccountChangeSet
+}
 
-	k, v, seekErr := indexC.Seek(changeset.Mapper[csBucket].IndexChunkKey(key, timestamp))
+// historyShard returns the decoded history index shard of key covering timestamp.
+func historyShard(indexC kv.Cursor, storage bool, key []byte, timestamp uint64) (*roaring64.Bitmap, error) {
+	k, v, seekErr := indexC.Seek(changeset.Mapper[historyBucket(storage)].IndexChunkKey(key, timestamp))
 	if seekErr != nil {
 		return nil, seekErr
 	}
@@ -56,23 +69,20 @@ func FindByHistory(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, storag
 		}
 	}
 	index := roaring64.New()
-	if _, err := index.ReadFrom(bytes.NewReader(v)); err != nil {
+	if err := bitmapdb.DecodeShard64(index, v); err != nil {
 		return nil, err
 	}
-	found, ok := bitmapdb.SeekInBitmap64(index, timestamp)
-	changeSetBlock := found
+	return index, nil
+}
 
-	var data []byte
-	var err error
-	if ok {
-		data, err = changeset.Mapper[csBucket].Find(changesC, changeSetBlock, key)
-		if err != nil {
-			if !errors.Is(err, changeset.ErrNotFound) {
-				return nil, fmt.Errorf("finding %x in the changeset %d: %w", key, changeSetBlock, err)
-			}
-			return nil, ethdb.ErrKeyNotFound
+// historyChange returns the value of key before changeSetBlock.
+func historyChange(tx kv.Tx, changesC kv.CursorDupSort, storage bool, key []byte, changeSetBlock uint64) ([]byte, error) {
+	csBucket := historyBucket(storage)
+	data, err := changeset.Mapper[csBucket].Find(changesC, changeSetBlock, key)
+	if err != nil {
+		if !errors.Is(err, changeset.ErrNotFound) {
+			return nil, fmt.Errorf("finding %x in the changeset %d: %w", key, changeSetBlock, err)
 		}
-	} else {
 		return nil, ethdb.ErrKeyNotFound
 	}
 
@@ -179,7 +189,7 @@ func WalkAsOfStorage(tx kv.Tx, address types.Address, incarnation uint16, startL
 			goOn, err = walker(addr, loc, v)
 		} else {
 			index := roaring64.New()
-			if _, err = index.ReadFrom(bytes.NewReader(hV)); err != nil {
+			if err = bitmapdb.DecodeShard64(index, hV); err != nil {
 				return err
 			}
 			found, ok := bitmapdb.SeekInBitmap64(index, timestamp)
@@ -286,8 +296,7 @@ func WalkAsOfAccounts(tx kv.Tx, startAddress types.Address, timestamp uint64, wa
 			goOn, err = walker(k, v)
 		} else {
 			index := roaring64.New()
-			_, err = index.ReadFrom(bytes.NewReader(hV))
-			if err != nil {
+			if err = bitmapdb.DecodeShard64(index, hV); err != nil {
 				return err
 			}
 			found, ok := bitmapdb.SeekInBitmap64(index, timestamp)
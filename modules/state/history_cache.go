@@ -0,0 +1,308 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	defaultHistoryShardCacheSize = 64 << 20
+	defaultHistoryValueCacheSize = 64 << 20
+)
+
+// DefaultHistoryCache is shared by the historical state readers of the node.
+var DefaultHistoryCache = NewHistoryCache(defaultHistoryShardCacheSize, defaultHistoryValueCacheSize)
+
+func init() {
+	metrics.Register("state/history/shard/hit", DefaultHistoryCache.shardHits)
+	metrics.Register("state/history/shard/miss", DefaultHistoryCache.shardMisses)
+	metrics.Register("state/history/value/hit", DefaultHistoryCache.valueHits)
+	metrics.Register("state/history/value/miss", DefaultHistoryCache.valueMisses)
+}
+
+// HistoryCache caches historical state lookups. It keeps two levels, both
+// bounded by bytes with LRU eviction:
+//
+//   - decoded history index shards per key, so that lookups of the same key
+//     at nearby blocks skip the index seek and the bitmap decoding;
+//   - resolved (block, key) values, accounts also decoded once read through
+//     AccountAsOf.
+//
+// Only lookups answered from history are cached: blocks below the head are
+// immutable, so entries stay valid until the blocks they depend on are
+// unwound, see Unwind. Lookups falling through to the current state are not
+// cached.
+type HistoryCache struct {
+	mu     sync.Mutex
+	shards *sizedLRU
+	values *sizedLRU
+
+	shardHits, shardMisses metrics.Counter
+	valueHits, valueMisses metrics.Counter
+}
+
+type cachedShard struct {
+	index    *roaring64.Bitmap
+	min, max uint64 // a shard answers every timestamp in [min, max]
+}
+
+type cachedValue struct {
+	data           []byte
+	account        *account.StateAccount // data decoded, set by AccountAsOf
+	changeSetBlock uint64
+}
+
+// cachedAccountSize is the size a decoded account adds to its value entry.
+const cachedAccountSize = int(unsafe.Sizeof(account.StateAccount{}))
+
+// HistoryCacheStats are the hit counters of a HistoryCache.
+type HistoryCacheStats struct {
+	ShardHits, ShardMisses int64
+	ValueHits, ValueMisses int64
+}
+
+// NewHistoryCache creates a cache holding up to shardBytes of decoded
+// shards and valueBytes of resolved values.
+func NewHistoryCache(shardBytes, valueBytes int) *HistoryCache {
+	return &HistoryCache{
+		shards:      newSizedLRU(shardBytes),
+		values:      newSizedLRU(valueBytes),
+		shardHits:   metrics.NewCounter(),
+		shardMisses: metrics.NewCounter(),
+		valueHits:   metrics.NewCounter(),
+		valueMisses: metrics.NewCounter(),
+	}
+}
+
+func historyCacheKey(storage bool, key []byte, timestamp *uint64) string {
+	k := make([]byte, 1, 1+len(key)+8)
+	if storage {
+		k[0] = 1
+	}
+	k = append(k, key...)
+	if timestamp != nil {
+		k = append(k, modules.EncodeBlockNumber(*timestamp)...)
+	}
+	return string(k)
+}
+
+// GetAsOf is GetAsOf served from the cache where possible.
+func (c *HistoryCache) GetAsOf(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, storage bool, key []byte, timestamp uint64) ([]byte, error) {
+	v, err := c.findByHistory(tx, indexC, changesC, storage, key, timestamp, false)
+	if err == nil {
+		return types.CopyBytes(v.data), nil
+	}
+	if !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	if storage {
+		return tx.GetOne(modules.Storage, key)
+	}
+	return tx.GetOne(modules.Account, key)
+}
+
+// AccountAsOf is GetAsOf of an account, decoded. Accounts answered from
+// history are decoded once and kept with their value, most of the cost of
+// a cached read otherwise. It returns nil if the account did not exist.
+func (c *HistoryCache) AccountAsOf(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, address []byte, timestamp uint64) (*account.StateAccount, error) {
+	v, err := c.findByHistory(tx, indexC, changesC, false, address, timestamp, true)
+	if err == nil {
+		if v.account == nil {
+			return nil, nil
+		}
+		a := *v.account
+		return &a, nil
+	}
+	if !errors.Is(err, ethdb.ErrKeyNotFound) {
+		return nil, err
+	}
+	enc, err := tx.GetOne(modules.Account, address)
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	var a account.StateAccount
+	if err := a.DecodeForStorage(enc); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// findByHistory returns the cached value of key at timestamp, resolving it
+// from history on a miss. With decode, the value is returned with its
+// account decoded. Cached values are shared, callers must not modify them.
+func (c *HistoryCache) findByHistory(tx kv.Tx, indexC kv.Cursor, changesC kv.CursorDupSort, storage bool, key []byte, timestamp uint64, decode bool) (*cachedValue, error) {
+	valueKey := historyCacheKey(storage, key, &timestamp)
+	c.mu.Lock()
+	e, ok := c.values.get(valueKey)
+	c.mu.Unlock()
+
+	var v *cachedValue
+	if ok {
+		c.valueHits.Inc(1)
+		v = e.(*cachedValue)
+		if !decode || v.account != nil || len(v.data) == 0 {
+			return v, nil
+		}
+	} else {
+		c.valueMisses.Inc(1)
+		changeSetBlock, err := c.seek(indexC, storage, key, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		data, err := historyChange(tx, changesC, storage, key, changeSetBlock)
+		if err != nil {
+			return nil, err
+		}
+		v = &cachedValue{data: types.CopyBytes(data), changeSetBlock: changeSetBlock}
+	}
+
+	size := len(valueKey) + len(v.data) + 8
+	if decode && len(v.data) > 0 {
+		a := new(account.StateAccount)
+		if err := a.DecodeForStorage(v.data); err != nil {
+			return nil, err
+		}
+		v = &cachedValue{data: v.data, account: a, changeSetBlock: v.changeSetBlock}
+		size += cachedAccountSize
+	}
+	c.mu.Lock()
+	c.values.add(valueKey, v, size)
+	c.mu.Unlock()
+	return v, nil
+}
+
+// seek returns the first block at or after timestamp changing key.
+func (c *HistoryCache) seek(indexC kv.Cursor, storage bool, key []byte, timestamp uint64) (uint64, error) {
+	shardKey := historyCacheKey(storage, key, nil)
+	c.mu.Lock()
+	if e, ok := c.shards.get(shardKey); ok {
+		// shards hold disjoint ranges, so the first change at or after any
+		// timestamp within the values of a shard is in that shard
+		if s := e.(*cachedShard); s.min <= timestamp && timestamp <= s.max {
+			found, _ := bitmapdb.SeekInBitmap64(s.index, timestamp)
+			c.mu.Unlock()
+			c.shardHits.Inc(1)
+			return found, nil
+		}
+	}
+	c.mu.Unlock()
+	c.shardMisses.Inc(1)
+
+	index, err := historyShard(indexC, storage, key, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	found, ok := bitmapdb.SeekInBitmap64(index, timestamp)
+	if !ok {
+		return 0, ethdb.ErrKeyNotFound
+	}
+	c.mu.Lock()
+	c.shards.add(shardKey, &cachedShard{index: index, min: index.Minimum(), max: index.Maximum()}, len(shardKey)+int(index.GetSizeInBytes())+16)
+	c.mu.Unlock()
+	return found, nil
+}
+
+// Unwind drops every entry depending on blocks above to. It must be called
+// when canonical blocks are unwound.
+func (c *HistoryCache) Unwind(to uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shards.removeIf(func(v interface{}) bool { return v.(*cachedShard).max > to })
+	c.values.removeIf(func(v interface{}) bool { return v.(*cachedValue).changeSetBlock > to })
+}
+
+// Stats returns the hit counters of the cache.
+func (c *HistoryCache) Stats() HistoryCacheStats {
+	return HistoryCacheStats{
+		ShardHits:   c.shardHits.Count(),
+		ShardMisses: c.shardMisses.Count(),
+		ValueHits:   c.valueHits.Count(),
+		ValueMisses: c.valueMisses.Count(),
+	}
+}
+
+// sizedLRU is a LRU bounded by the total size of its entries. Not safe for
+// concurrent use.
+type sizedLRU struct {
+	limit, size int
+	ll          *list.List
+	items       map[string]*list.Element
+}
+
+type sizedEntry struct {
+	key   string
+	value interface{}
+	size  int
+}
+
+func newSizedLRU(limit int) *sizedLRU {
+	return &sizedLRU{limit: limit, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *sizedLRU) get(key string) (interface{}, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return e.Value.(*sizedEntry).value, true
+}
+
+func (l *sizedLRU) add(key string, value interface{}, size int) {
+	if size > l.limit {
+		return
+	}
+	if e, ok := l.items[key]; ok {
+		l.remove(e)
+	}
+	l.items[key] = l.ll.PushFront(&sizedEntry{key: key, value: value, size: size})
+	l.size += size
+	for l.size > l.limit {
+		l.remove(l.ll.Back())
+	}
+}
+
+func (l *sizedLRU) remove(e *list.Element) {
+	entry := l.ll.Remove(e).(*sizedEntry)
+	delete(l.items, entry.key)
+	l.size -= entry.size
+}
+
+func (l *sizedLRU) removeIf(f func(value interface{}) bool) {
+	for e := l.ll.Front(); e != nil; {
+		next := e.Next()
+		if f(e.Value.(*sizedEntry).value) {
+			l.remove(e)
+		}
+		e = next
+	}
+}
+
+func (l *sizedLRU) len() int { return l.ll.Len() }
@@ -0,0 +1,166 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+func encodeTestAccount(balance uint64) []byte {
+	acc := account.StateAccount{Initialised: true, Balance: *uint256.NewInt(balance)}
+	data := make([]byte, acc.EncodingLengthForStorage())
+	acc.EncodeForStorage(data)
+	return data
+}
+
+// openHistoryDB creates accounts changed at blocks 10, 20, ..., 100. The value
+// before block b has balance b, the current balance is 1000.
+func openHistoryDB(tb testing.TB, accounts int) (kv.RwDB, []types.Address) {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).Path(tb.TempDir()).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	addrs := make([]types.Address, accounts)
+	if err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		for i := range addrs {
+			addrs[i] = types.Address{byte(i >> 8), byte(i), 1}
+			index := roaring64.New()
+			for b := uint64(10); b <= 100; b += 10 {
+				index.Add(b)
+				if err := tx.Put(modules.AccountChangeSet, modules.EncodeBlockNumber(b), append(addrs[i].Bytes(), encodeTestAccount(b)...)); err != nil {
+					return err
+				}
+			}
+			var buf bytes.Buffer
+			if _, err := index.WriteTo(&buf); err != nil {
+				return err
+			}
+			if err := tx.Put(modules.AccountsHistory, modules.AccountIndexChunkKey(addrs[i][:], math.MaxUint64), buf.Bytes()); err != nil {
+				return err
+			}
+			if err := tx.Put(modules.Account, addrs[i][:], encodeTestAccount(1000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		tb.Fatal(err)
+	}
+	return db, addrs
+}
+
+func TestHistoryCache(t *testing.T) {
+	db, addrs := openHistoryDB(t, 4)
+	defer db.Close()
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	cache := NewHistoryCache(1<<20, 1<<20)
+	uncached, cached := NewPlainState(tx, 0), NewPlainState(tx, 0)
+	cached.SetHistoryCache(cache)
+	for round := 0; round < 2; round++ {
+		for _, addr := range addrs {
+			for ts := uint64(1); ts <= 110; ts++ {
+				uncached.SetBlockNr(ts)
+				cached.SetBlockNr(ts)
+				want, err := uncached.ReadAccountData(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := cached.ReadAccountData(addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want.Balance.Cmp(&have.Balance) != 0 {
+					t.Fatalf("round %d %x at %d: have %d, want %d", round, addr, ts, &have.Balance, &want.Balance)
+				}
+			}
+		}
+	}
+	stats := cache.Stats()
+	if stats.ValueHits == 0 || stats.ShardHits == 0 {
+		t.Fatalf("cache not used: %+v", stats)
+	}
+	// one shard per account, one value per (account, block) answered from history
+	if cache.shards.len() != len(addrs) || cache.values.len() != len(addrs)*100 {
+		t.Fatalf("have %d shards and %d values", cache.shards.len(), cache.values.len())
+	}
+
+	cache.Unwind(50)
+	if cache.shards.len() != 0 {
+		t.Fatalf("shards covering unwound blocks kept: %d", cache.shards.len())
+	}
+	if cache.values.len() != len(addrs)*50 {
+		t.Fatalf("have %d values after unwind, want %d", cache.values.len(), len(addrs)*50)
+	}
+
+	small := NewHistoryCache(1<<20, 10*(1+types.AddressLength+8+len(encodeTestAccount(10))+8+cachedAccountSize))
+	cached.SetHistoryCache(small)
+	for ts := uint64(1); ts <= 100; ts++ {
+		cached.SetBlockNr(ts)
+		if _, err := cached.ReadAccountData(addrs[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if small.values.len() != 10 || small.values.size > small.values.limit {
+		t.Fatalf("size bound not kept: %d values of %d bytes", small.values.len(), small.values.size)
+	}
+}
+
+// BenchmarkHistoricalBalances issues 10k balance reads at one historical block.
+func BenchmarkHistoricalBalances(b *testing.B) {
+	db, addrs := openHistoryDB(b, 1000)
+	defer db.Close()
+	tx, err := db.BeginRo(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	run := func(b *testing.B, cache *HistoryCache) {
+		for i := 0; i < b.N; i++ {
+			s := NewPlainState(tx, 55)
+			s.SetHistoryCache(cache)
+			for round := 0; round < 10; round++ {
+				for _, addr := range addrs {
+					if _, err := s.ReadAccountData(addr); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		}
+	}
+	b.Run("uncached", func(b *testing.B) { run(b, nil) })
+	b.Run("cached", func(b *testing.B) { run(b, NewHistoryCache(64<<20, 64<<20)) })
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/log/v3"
+	"sort"
 )
 
 type storageItem struct {
@@ -47,6 +48,7 @@ type PlainState struct {
 	blockNr                      uint64
 	storage                      map[types.Address]*btree.BTree
 	trace                        bool
+	cache                        *HistoryCache
 }
 
 func NewPlainState(tx kv.Tx, blockNr uint64) *PlainState {
@@ -63,6 +65,18 @@ func NewPlainState(tx kv.Tx, blockNr uint64) *PlainState {
 	}
 }
 
+// SetHistoryCache makes historical lookups go through cache.
+func (s *PlainState) SetHistoryCache(cache *HistoryCache) {
+	s.cache = cache
+}
+
+func (s *PlainState) getAsOf(indexC kv.Cursor, changesC kv.CursorDupSort, storage bool, key []byte, timestamp uint64) ([]byte, error) {
+	if s.cache != nil {
+		return s.cache.GetAsOf(s.tx, indexC, changesC, storage, key, timestamp)
+	}
+	return GetAsOf(s.tx, indexC, changesC, storage, key, timestamp)
+}
+
 func (s *PlainState) SetTrace(trace bool) {
 	s.trace = trace
 }
@@ -79,7 +93,7 @@ func (s *PlainState) ForEachStorage(addr types.Address, startLocation types.Hash
 	st := btree.New(16)
 	var k [types.AddressLength + types.IncarnationLength + types.HashLength]byte
 	copy(k[:], addr[:])
-	accData, err := GetAsOf(s.tx, s.accHistoryC, s.accChangesC, false /* storage */, addr[:], s.blockNr)
+	accData, err := s.getAsOf(s.accHistoryC, s.accChangesC, false /* storage */, addr[:], s.blockNr)
 	if err != nil {
 		return err
 	}
@@ -150,21 +164,33 @@ func (s *PlainState) ForEachStorage(addr types.Address, startLocation types.Hash
 	return innerErr
 }
 
-func (s *PlainState) ReadAccountData(address types.Address) (*account.StateAccount, error) {
+// accountAsOf - account at the beginning of blockNr, nil if it didn't exist
+func (s *PlainState) accountAsOf(address types.Address) (*account.StateAccount, error) {
+	if s.cache != nil {
+		return s.cache.AccountAsOf(s.tx, s.accHistoryC, s.accChangesC, address[:], s.blockNr)
+	}
 	enc, err := GetAsOf(s.tx, s.accHistoryC, s.accChangesC, false /* storage */, address[:], s.blockNr)
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	var a account.StateAccount
+	if err = a.DecodeForStorage(enc); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *PlainState) ReadAccountData(address types.Address) (*account.StateAccount, error) {
+	a, err := s.accountAsOf(address)
 	if err != nil {
 		return nil, err
 	}
-	if len(enc) == 0 {
+	if a == nil {
 		if s.trace {
 			fmt.Printf("ReadAccountData [%x] => []\n", address)
 		}
 		return nil, nil
 	}
-	var a account.StateAccount
-	if err = a.DecodeForStorage(enc); err != nil {
-		return nil, err
-	}
 	//restore codehash
 	if a.Incarnation > 0 && a.IsEmptyCodeHash() {
 		if codeHash, err1 := s.tx.GetOne(modules.PlainContractCode, modules.PlainGenerateStoragePrefix(address[:], a.Incarnation)); err1 == nil {
@@ -178,12 +204,35 @@ func (s *PlainState) ReadAccountData(address types.Address) (*account.StateAccou
 	if s.trace {
 		fmt.Printf("ReadAccountData [%x] => [nonce: %d, balance: %d, codeHash: %x]\n", address, a.Nonce, &a.Balance, a.CodeHash)
 	}
-	return &a, nil
+	return a, nil
+}
+
+// ReadBalances - balances of addrs as of the block of the reader, in the order of addrs, zero for
+// missing accounts. Accounts are looked up in address order, so the cursors only move forward.
+func (s *PlainState) ReadBalances(addrs []types.Address) ([]*uint256.Int, error) {
+	order := make([]int, len(addrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return bytes.Compare(addrs[order[i]][:], addrs[order[j]][:]) < 0 })
+
+	balances := make([]*uint256.Int, len(addrs))
+	for _, i := range order {
+		a, err := s.ReadAccountData(addrs[i])
+		if err != nil {
+			return nil, fmt.Errorf("read account %x: %w", addrs[i], err)
+		}
+		balances[i] = new(uint256.Int)
+		if a != nil {
+			balances[i].Set(&a.Balance)
+		}
+	}
+	return balances, nil
 }
 
 func (s *PlainState) ReadAccountStorage(address types.Address, incarnation uint16, key *types.Hash) ([]byte, error) {
 	compositeKey := modules.PlainGenerateCompositeStorageKey(address.Bytes(), incarnation, key.Bytes())
-	enc, err := GetAsOf(s.tx, s.storageHistoryC, s.storageChangesC, true /* storage */, compositeKey, s.blockNr)
+	enc, err := s.getAsOf(s.storageHistoryC, s.storageChangesC, true /* storage */, compositeKey, s.blockNr)
 	if err != nil {
 		return nil, err
 	}
@@ -216,7 +265,7 @@ func (s *PlainState) ReadAccountCodeSize(address types.Address, incarnation uint
 }
 
 func (s *PlainState) ReadAccountIncarnation(address types.Address) (uint16, error) {
-	enc, err := GetAsOf(s.tx, s.accHistoryC, s.accChangesC, false /* storage */, address[:], s.blockNr+1)
+	enc, err := s.getAsOf(s.accHistoryC, s.accChangesC, false /* storage */, address[:], s.blockNr+1)
 	if err != nil {
 		return 0, err
 	}
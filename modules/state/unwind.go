@@ -0,0 +1,101 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/changeset"
+	"github.com/amazechain/amc/modules/ethdb/bitmapdb"
+	"github.com/ledgerwatch/erigon-lib/kv"
+)
+
+// UnwindPlainState rolls the plain state written by PlainStateWriter back from block
+// from to block to. Every account and storage slot changed in the blocks above to gets
+// the value it had before the first of these changes, then the changesets of the blocks
+// and their AccountsHistory and StorageHistory entries are removed.
+func UnwindPlainState(tx kv.RwTx, from, to uint64) error {
+	if to >= from {
+		return nil
+	}
+	var (
+		before = make(map[string][]byte)
+		keys   [][]byte
+	)
+	for _, table := range []string{modules.AccountChangeSet, modules.StorageChangeSet} {
+		if err := changeset.ForRange(tx, table, to+1, from+1, func(_ uint64, k, v []byte) error {
+			if _, ok := before[string(k)]; !ok {
+				before[string(k)] = types.CopyBytes(v)
+				keys = append(keys, types.CopyBytes(k))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		restore, history := unwindAccount, modules.AccountsHistory
+		if len(k) != types.AddressLength {
+			restore, history = unwindStorage, modules.StorageHistory
+		}
+		if err := restore(tx, k, before[string(k)]); err != nil {
+			return err
+		}
+		if err := bitmapdb.TruncateRange64(tx, history, modules.CompositeKeyWithoutIncarnation(k), to+1); err != nil {
+			return err
+		}
+	}
+	return changeset.Truncate(tx, to+1)
+}
+
+// unwindAccount restores an account from its changeset value. Updated accounts are
+// stored there without code hash and storage root, which do not change within an
+// incarnation, so they are taken from the account as it is now.
+func unwindAccount(tx kv.RwTx, address, v []byte) error {
+	if len(v) == 0 {
+		return tx.Delete(modules.Account, address)
+	}
+	var acc account.StateAccount
+	if err := acc.DecodeForStorage(v); err != nil {
+		return err
+	}
+	enc, err := tx.GetOne(modules.Account, address)
+	if err != nil {
+		return err
+	}
+	if len(enc) > 0 {
+		var current account.StateAccount
+		if err := current.DecodeForStorage(enc); err != nil {
+			return err
+		}
+		if current.Incarnation == acc.Incarnation {
+			acc.Root, acc.CodeHash = current.Root, current.CodeHash
+			v = make([]byte, acc.EncodingLengthForStorage())
+			acc.EncodeForStorage(v)
+		}
+	}
+	return tx.Put(modules.Account, address, v)
+}
+
+func unwindStorage(tx kv.RwTx, key, v []byte) error {
+	if len(v) == 0 {
+		return tx.Delete(modules.Storage, key)
+	}
+	return tx.Put(modules.Storage, key, v)
+}
@@ -67,7 +67,7 @@ const (
 	BlockRewards = "BlockRewards"
 
 	// Transaction senders - stored separately from the block bodies
-	Senders = "TxSender" // block_num_u64 + blockHash -> sendersList (no serialization format, every 20 bytes is new sender)
+	Senders = "TxSender" // block_num_u64 + blockHash -> sendersList (20 bytes per sender, or deduplicated - see rawdb.EncodeSenders)
 
 	Receipts = "Receipt"        // block_num_u64 -> canonical block receipts (non-canonical are not stored)
 	Log      = "TransactionLog" // block_num_u64 + txId -> logs of transaction
@@ -79,13 +79,15 @@ const (
 	//
 	// if last existing shard size merge it with delta
 	// if serialized size of delta > ShardLimit - break down to multiple shards
-	// shard number - it's biggest value in bitmap
+	// shard number - counts up from 0, the newest shard has the biggest one and sorts first
+	// see bitmapdb.UpsertShardedIndex and bitmapdb.SeekInIndex
 	LogTopicIndex   = "LogTopicIndex"
 	LogAddressIndex = "LogAddressIndex"
 
 	// CallTraceSet is the name of the table that contain the mapping of block number to the set (sorted) of all accounts
 	// touched by call traces. It is DupSort-ed table
 	// 8-byte BE block number -> account address -> two bits (one for "from", another for "to")
+	// see rawdb.RecordCallTraces and rawdb.FlushCallTraceIndexes
 	CallTraceSet = "CallTraceSet"
 	// Indices for call traces - have the same format as LogTopicIndex and LogAddressIndex
 	// Store bitmap indices - in which block number we saw calls from (CallFromIndex) or to (CallToIndex) some addresses
@@ -96,6 +98,24 @@ const (
 
 	Stake = "Stake" // stakes   amc_stake -> bytes
 
+	EventJournal = "EventJournal" // seq_u64 -> rlp(journal event), see rawdb.AppendJournalEvent
+
+	StorageWatch     = "StorageWatch"     // address + slot_hash -> empty, watched storage slots
+	StorageWatchHits = "StorageWatchHits" // seq_u64 -> rlp(watch hit), see rawdb.AppendStorageWatchHits
+
+	FeeAccounting        = "FeeAccounting"        // address + shard_u64 -> rlp(fee account), totals of FeeAccountingShard blocks
+	FeeAccountingChanges = "FeeAccountingChanges" // block_num_u64 + hash -> rlp(fee changes of the block), to unwind FeeAccounting
+
+	BlockAccessList = "BlockAccessList" // block_num_u64 + hash -> rlp(access list), accounts and slots the block touched
+
+	CumulativeGasIndex         = "CumulativeGasIndex"         // block_num_u64 -> gas used by the canonical chain up to the block, u64
+	CumulativeTransactionIndex = "CumulativeTransactionIndex" // block_num_u64 -> transactions of the canonical chain up to the block, u64
+
+	BlockSizes       = "BlockSizes"       // block_num_u64 -> stored header, body, txs, receipts bytes, u64 each [+ sampled compressed bytes, u64]
+	BlockSizeWindows = "BlockSizeWindows" // window_u64 -> totals of BlockSizes of 1k blocks, see rawdb.ScheduleRetirement
+
+	Migrations = "Migration" // migration name -> progress of the migration, see rawdb.ReceiptsMigrated
+
 )
 
 const (
@@ -128,11 +148,17 @@ var AmcTables = []string{
 
 	BlockBody,
 	BlockTx,
+	NonCanonicalTxs,
 
 	TxLookup,
 	Senders,
 	Receipts,
 	Log,
+	LogTopicIndex,
+	LogAddressIndex,
+	CallTraceSet,
+	CallFromIndex,
+	CallToIndex,
 
 	SignersDB,
 	PoaSnapshot,
@@ -142,11 +168,29 @@ var AmcTables = []string{
 	Deposit,
 	BlockVerify,
 	BlockRewards,
+	EventJournal,
+	StorageWatch,
+	StorageWatchHits,
+	FeeAccounting,
+	FeeAccountingChanges,
+	BlockAccessList,
+	CumulativeGasIndex,
+	CumulativeTransactionIndex,
+	BlockSizes,
+	BlockSizeWindows,
+	Migrations,
+}
+
+// AmcDeprecatedTables - tables of older layouts, opened only if they exist, `amc db drop-deprecated` drops them
+var AmcDeprecatedTables = []string{
+	"Clique",
+	"TransitionBlock",
 }
 
 var AmcTableCfg = kv.TableCfg{
 	AccountChangeSet: {Flags: kv.DupSort},
 	StorageChangeSet: {Flags: kv.DupSort},
+	CallTraceSet:     {Flags: kv.DupSort},
 	Storage: {
 		Flags:                     kv.DupSort,
 		AutoDupSortKeysConversion: true,
@@ -165,4 +209,9 @@ func AmcInit() {
 			AmcTableCfg[name] = kv.TableCfgItem{}
 		}
 	}
+	for _, name := range AmcDeprecatedTables {
+		cfg := AmcTableCfg[name]
+		cfg.IsDeprecated = true
+		AmcTableCfg[name] = cfg
+	}
 }
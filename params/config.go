@@ -308,6 +308,9 @@ type ChainConfig struct {
 	NanoBlock    *big.Int `json:"nanoBlock,omitempty" toml:",omitempty"`    // nanoBlock switch block (nil = no fork, 0 = already activated)
 	MoranBlock   *big.Int `json:"moranBlock,omitempty" toml:",omitempty"`   // moranBlock switch block (nil = no fork, 0 = already activated)
 	BeijingBlock *big.Int `json:"beijingBlock,omitempty" toml:",omitempty"` // beijingBlock switch block (nil = no fork, 0 = already activated)
+
+	// TxValidityBlock enables the transaction validity window extension, blocks must not include expired transactions
+	TxValidityBlock *big.Int `json:"txValidityBlock,omitempty" toml:",omitempty"` // (nil = no fork, 0 = already activated)
 	//Apos         *AposConfig `json:"apos,omitempty"`
 
 	// Gnosis Chain fork blocks
@@ -316,6 +319,11 @@ type ChainConfig struct {
 	Eip1559FeeCollector           *types.Address `json:"eip1559FeeCollector,omitempty"`           // (Optional) Address where burnt EIP-1559 fees go to
 	Eip1559FeeCollectorTransition *big.Int       `json:"eip1559FeeCollectorTransition,omitempty"` // (Optional) Block from which burnt EIP-1559 fees go to the Eip1559FeeCollector
 
+	// (Optional) EIP-1559 parameters scheduled by block number, e.g. {"0": 2, "1000000": 4}.
+	// Missing schedules use ElasticityMultiplier and BaseFeeChangeDenominator protocol constants.
+	ElasticityMultiplierSchedule     map[string]uint64 `json:"elasticityMultiplierSchedule,omitempty"`
+	BaseFeeChangeDenominatorSchedule map[string]uint64 `json:"baseFeeChangeDenominatorSchedule,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
@@ -434,6 +442,36 @@ func (c *BorConfig) calculateBorConfigHelper(field map[string]uint64, number uin
 	return field[keys[len(keys)-1]]
 }
 
+// GetElasticityMultiplier returns the EIP-1559 elasticity multiplier in effect at block number.
+func (c *ChainConfig) GetElasticityMultiplier(number uint64) uint64 {
+	return scheduledValue(c.ElasticityMultiplierSchedule, number, ElasticityMultiplier)
+}
+
+// GetBaseFeeChangeDenominator returns the EIP-1559 baseFee change denominator in effect at block number.
+func (c *ChainConfig) GetBaseFeeChangeDenominator(number uint64) uint64 {
+	return scheduledValue(c.BaseFeeChangeDenominatorSchedule, number, BaseFeeChangeDenominator)
+}
+
+// scheduledValue returns the value of the latest schedule entry activated at or before number.
+// Entries with malformed or zero values are ignored.
+func scheduledValue(schedule map[string]uint64, number uint64, def uint64) uint64 {
+	var (
+		value = def
+		from  uint64
+		found bool
+	)
+	for k, v := range schedule {
+		block, err := strconv.ParseUint(k, 10, 64)
+		if err != nil || v == 0 || block > number {
+			continue
+		}
+		if !found || block >= from {
+			value, from, found = v, block, true
+		}
+	}
+	return value
+}
+
 // String implements the fmt.Stringer interface.
 func (c *ChainConfig) String() string {
 
@@ -739,6 +777,11 @@ func (c *ChainConfig) IsBeijing(num uint64) bool {
 	return isForked(c.BeijingBlock, num)
 }
 
+// IsTxValidity returns whether num is either equal to the tx validity window fork block or greater.
+func (c *ChainConfig) IsTxValidity(num uint64) bool {
+	return isForked(c.TxValidityBlock, num)
+}
+
 func (c *ChainConfig) IsEip1559FeeCollector(num uint64) bool {
 	return c.Eip1559FeeCollector != nil && isForked(c.Eip1559FeeCollectorTransition, num)
 }
@@ -887,6 +930,9 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head uint64) *ConfigC
 	if isForkIncompatible(c.CancunBlock, newcfg.CancunBlock, head) {
 		return newCompatError("Cancun fork block", c.CancunBlock, newcfg.CancunBlock)
 	}
+	if isForkIncompatible(c.TxValidityBlock, newcfg.TxValidityBlock, head) {
+		return newCompatError("Tx validity fork block", c.TxValidityBlock, newcfg.TxValidityBlock)
+	}
 
 	// Parlia forks
 	//if isForkIncompatible(c.RamanujanBlock, newcfg.RamanujanBlock, head) {
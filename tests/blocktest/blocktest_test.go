@@ -0,0 +1,239 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package blocktest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/holiman/uint256"
+)
+
+var (
+	alice    = types.HexToAddress("0x00000000000000000000000000000000000a11ce")
+	bob      = types.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	coinbase = types.HexToAddress("0x000000000000000000000000000000000000c0de")
+
+	// initCode stores 0x2a in slot 0 and emits an empty LOG0.
+	initCode = []byte{0x60, 0x2a, 0x60, 0x00, 0x55, 0x60, 0x00, 0x60, 0x00, 0xa0, 0x00}
+)
+
+func testSpec() *Spec {
+	ether := new(uint256.Int).Exp(uint256.NewInt(10), uint256.NewInt(18))
+	return &Spec{
+		Network: "Merge",
+		Pre: Alloc{
+			alice: {Balance: *NewU256(ether)},
+			bob:   {Balance: *NewU256(uint256.NewInt(1)), Storage: map[Word]Word{{31: 1}: {31: 7}}, Code: []byte{0x00}},
+		},
+		GasLimit: 10_000_000,
+		BaseFee:  uint256.NewInt(10),
+		Blocks: []BlockSpec{
+			{
+				Coinbase: coinbase,
+				Transactions: []*transaction.Transaction{
+					transaction.NewTx(&transaction.LegacyTx{
+						Nonce: 0, GasPrice: uint256.NewInt(100), Gas: 21000, To: &bob, From: &alice, Value: uint256.NewInt(1000),
+					}),
+					transaction.NewTx(&transaction.DynamicFeeTx{
+						ChainID: uint256.NewInt(1), Nonce: 1, GasTipCap: uint256.NewInt(2), GasFeeCap: uint256.NewInt(100),
+						Gas: 21000, To: &bob, From: &alice, Value: uint256.NewInt(5),
+					}),
+				},
+			},
+			{
+				Coinbase: coinbase,
+				Transactions: []*transaction.Transaction{
+					transaction.NewTx(&transaction.LegacyTx{
+						Nonce: 2, GasPrice: uint256.NewInt(100), Gas: 100000, From: &alice, Value: uint256.NewInt(0), Data: initCode,
+					}),
+				},
+			},
+		},
+	}
+}
+
+func encodeTest(t *testing.T, bt *BlockTest) []byte {
+	var buf bytes.Buffer
+	if err := Encode(&buf, map[string]*BlockTest{"vector": bt}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGenerateRoundTrip(t *testing.T) {
+	bt, err := Generate(testSpec())
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	enc := encodeTest(t, bt)
+
+	again, err := Generate(testSpec())
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !bytes.Equal(enc, encodeTest(t, again)) {
+		t.Fatalf("generator output is not deterministic")
+	}
+
+	tests, err := Decode(bytes.NewReader(enc))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	decoded := tests["vector"]
+	if err := Run(decoded); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(decoded.Blocks) != 2 || decoded.Blocks[1].Header.GasUsed <= 21000 {
+		t.Fatalf("unexpected blocks %+v", decoded.Blocks)
+	}
+	if _, ok := decoded.PostState[coinbase]; !ok {
+		t.Fatalf("coinbase missing from post-state")
+	}
+	balance := decoded.PostState[bob].Balance
+	if got := balance.Int(); !got.Eq(uint256.NewInt(1006)) {
+		t.Fatalf("bob balance %s, want 1006", got)
+	}
+	var created bool
+	for _, a := range decoded.PostState {
+		if a.Storage[Word{}] == (Word{31: 0x2a}) {
+			created = true
+		}
+	}
+	if !created {
+		t.Fatalf("created contract storage missing from post-state")
+	}
+}
+
+func TestRunDetectsMismatch(t *testing.T) {
+	fresh := func() *BlockTest {
+		bt, err := Generate(testSpec())
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		return bt
+	}
+
+	bt := fresh()
+	acc := bt.PostState[bob]
+	acc.Balance = *NewU256(uint256.NewInt(1))
+	bt.PostState[bob] = acc
+	if err := Run(bt); err == nil {
+		t.Fatalf("balance mismatch not detected")
+	}
+
+	bt = fresh()
+	bt.Blocks[1].Header.Bloom[0] ^= 0xff
+	if err := Run(bt); err == nil {
+		t.Fatalf("bloom mismatch not detected")
+	}
+
+	bt = fresh()
+	bt.Blocks[0].Header.StateRoot = types.Hash{1}
+	if err := Run(bt); err == nil {
+		t.Fatalf("state root mismatch not detected")
+	}
+
+	// Fixtures filled by other clients carry Ethereum commitments, which
+	// are not comparable and must not be checked.
+	bt.Info = nil
+	if err := Run(bt); err != nil {
+		t.Fatalf("foreign fixture: %v", err)
+	}
+}
+
+func TestRunUnsupported(t *testing.T) {
+	bt, err := Generate(testSpec())
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	bt.Network = "London"
+	if err := Run(bt); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("have %v, want ErrUnsupported", err)
+	}
+}
+
+func TestDecodePaddedQuantities(t *testing.T) {
+	var u Uint64
+	if err := u.UnmarshalText([]byte("0x01")); err != nil || u != 1 {
+		t.Fatalf("have %d (%v), want 1", u, err)
+	}
+	var w Word
+	if err := w.UnmarshalText([]byte("0x00")); err != nil || w != (Word{}) {
+		t.Fatalf("have %x (%v), want zero", w, err)
+	}
+	if text, _ := (Word{31: 0x2a}).MarshalText(); string(text) != "0x2a" {
+		t.Fatalf("have %s, want 0x2a", text)
+	}
+}
+
+// skippedFixtures lists the cases under testdata that Run cannot replay,
+// with the reason.
+var skippedFixtures = map[string]string{
+	"invalidGasUsed": "blocks expected to be rejected are not replayed",
+}
+
+// TestFixtures replays the pinned BlockchainTest cases under testdata. They
+// are in the ethereum/tests format and were filled with go-ethereum
+// v1.11.2, so only gas used, blooms and the post-state are compared.
+func TestFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures under testdata")
+	}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests, err := Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		names := make([]string, 0, len(tests))
+		for name := range tests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			bt := tests[name]
+			t.Run(name, func(t *testing.T) {
+				err := Run(bt)
+				if reason, ok := skippedFixtures[name]; ok {
+					if !errors.Is(err, ErrUnsupported) {
+						t.Fatalf("skipped case no longer unsupported (%v), drop it from skippedFixtures", err)
+					}
+					t.Skip(reason)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
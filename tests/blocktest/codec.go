@@ -0,0 +1,396 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package blocktest reads, writes, generates and runs BlockchainTest
+// fixtures in the ethereum/tests JSON format, so that the same block
+// vectors can be replayed by amc and by other execution clients.
+package blocktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+// FillingTool is recorded in the _info section of every fixture produced
+// by Generate. Commitments (state and receipt roots) are only comparable
+// between fixtures carrying this marker, see Run.
+const FillingTool = "amc blocktest"
+
+// BlockTest is a single BlockchainTest case.
+type BlockTest struct {
+	Info          *Info               `json:"_info,omitempty"`
+	Network       string              `json:"network"`
+	SealEngine    string              `json:"sealEngine,omitempty"`
+	Config        *params.ChainConfig `json:"config,omitempty"` // amc extension, takes precedence over Network
+	Genesis       Header              `json:"genesisBlockHeader"`
+	Pre           Alloc               `json:"pre"`
+	Blocks        []Block             `json:"blocks"`
+	PostState     Alloc               `json:"postState"`
+	LastBlockHash types.Hash          `json:"lastblockhash"`
+}
+
+// Info is the free-form _info section of a fixture.
+type Info struct {
+	FillingTool string `json:"filling-tool,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// Alloc is a world state keyed by account address.
+type Alloc map[types.Address]Account
+
+// Account is the state of a single account.
+type Account struct {
+	Balance U256          `json:"balance"`
+	Nonce   Uint64        `json:"nonce"`
+	Code    hexutil.Bytes `json:"code"`
+	Storage map[Word]Word `json:"storage"`
+}
+
+// Header is a block header as it appears in a fixture.
+type Header struct {
+	ParentHash       types.Hash       `json:"parentHash"`
+	Coinbase         types.Address    `json:"coinbase"`
+	StateRoot        types.Hash       `json:"stateRoot"`
+	TransactionsTrie types.Hash       `json:"transactionsTrie"`
+	ReceiptTrie      types.Hash       `json:"receiptTrie"`
+	Bloom            block.Bloom      `json:"bloom"`
+	Difficulty       U256             `json:"difficulty"`
+	Number           Uint64           `json:"number"`
+	GasLimit         Uint64           `json:"gasLimit"`
+	GasUsed          Uint64           `json:"gasUsed"`
+	Timestamp        Uint64           `json:"timestamp"`
+	ExtraData        hexutil.Bytes    `json:"extraData"`
+	MixHash          types.Hash       `json:"mixHash"`
+	Nonce            block.BlockNonce `json:"nonce"`
+	BaseFee          *U256            `json:"baseFeePerGas,omitempty"`
+	Hash             types.Hash       `json:"hash"`
+}
+
+// Block is a block of a fixture. Only the JSON form of the transactions
+// is consumed, the rlp field is kept for round-tripping.
+type Block struct {
+	Header          *Header           `json:"blockHeader,omitempty"`
+	Transactions    []Transaction     `json:"transactions"`
+	UncleHeaders    []json.RawMessage `json:"uncleHeaders"`
+	Withdrawals     []json.RawMessage `json:"withdrawals,omitempty"`
+	ExpectException string            `json:"expectException,omitempty"`
+	RLP             string            `json:"rlp,omitempty"`
+}
+
+// Transaction is a transaction as it appears in a fixture.
+type Transaction struct {
+	Type                 *Uint64                `json:"type,omitempty"`
+	ChainID              *U256                  `json:"chainId,omitempty"`
+	Nonce                Uint64                 `json:"nonce"`
+	GasPrice             *U256                  `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *U256                  `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *U256                  `json:"maxPriorityFeePerGas,omitempty"`
+	GasLimit             Uint64                 `json:"gasLimit"`
+	To                   string                 `json:"to"`
+	Value                U256                   `json:"value"`
+	Data                 hexutil.Bytes          `json:"data"`
+	AccessList           transaction.AccessList `json:"accessList,omitempty"`
+	V                    *U256                  `json:"v,omitempty"`
+	R                    *U256                  `json:"r,omitempty"`
+	S                    *U256                  `json:"s,omitempty"`
+	Sender               *types.Address         `json:"sender,omitempty"`
+}
+
+// Decode reads a fixture file, a JSON object of named test cases.
+func Decode(r io.Reader) (map[string]*BlockTest, error) {
+	tests := make(map[string]*BlockTest)
+	if err := json.NewDecoder(r).Decode(&tests); err != nil {
+		return nil, err
+	}
+	return tests, nil
+}
+
+// Encode writes named test cases as an indented fixture file.
+func Encode(w io.Writer, tests map[string]*BlockTest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(tests)
+}
+
+// Uint64 is a hex quantity. Unlike hexutil.Uint64 it accepts the zero
+// padded form ("0x01") used throughout ethereum/tests.
+type Uint64 uint64
+
+func (n Uint64) MarshalText() ([]byte, error) {
+	return []byte(hexutil.EncodeUint64(uint64(n))), nil
+}
+
+func (n *Uint64) UnmarshalText(input []byte) error {
+	s := string(input)
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return fmt.Errorf("hex quantity %q without 0x prefix", s)
+	}
+	if s = s[2:]; s == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid hex quantity %q: %w", input, err)
+	}
+	*n = Uint64(v)
+	return nil
+}
+
+// U256 is a 256-bit hex quantity accepting zero padding.
+type U256 uint256.Int
+
+// NewU256 converts v to a U256.
+func NewU256(v *uint256.Int) *U256 {
+	if v == nil {
+		return nil
+	}
+	u := U256(*v)
+	return &u
+}
+
+// Int returns the value as a uint256.Int.
+func (u *U256) Int() *uint256.Int {
+	if u == nil {
+		return nil
+	}
+	v := uint256.Int(*u)
+	return &v
+}
+
+func (u U256) MarshalText() ([]byte, error) {
+	v := uint256.Int(u)
+	return []byte(v.Hex()), nil
+}
+
+func (u *U256) UnmarshalText(input []byte) error {
+	s := string(input)
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return fmt.Errorf("hex quantity %q without 0x prefix", s)
+	}
+	b, ok := new(big.Int).SetString("0"+s[2:], 16)
+	if !ok {
+		return fmt.Errorf("invalid hex quantity %q", s)
+	}
+	v, overflow := uint256.FromBig(b)
+	if overflow {
+		return fmt.Errorf("hex quantity %q overflows 256 bits", s)
+	}
+	*u = U256(*v)
+	return nil
+}
+
+// Word is a storage slot key or value. It is encoded as a minimal hex
+// quantity, as in ethereum/tests.
+type Word types.Hash
+
+func (w Word) MarshalText() ([]byte, error) {
+	return []byte(new(uint256.Int).SetBytes(w[:]).Hex()), nil
+}
+
+func (w *Word) UnmarshalText(input []byte) error {
+	var u U256
+	if err := u.UnmarshalText(input); err != nil {
+		return err
+	}
+	*w = Word(u.Int().Bytes32())
+	return nil
+}
+
+// toAddress parses the "to" field, an empty string denotes contract creation.
+func toAddress(s string) (*types.Address, error) {
+	if s == "" || s == "0x" {
+		return nil, nil
+	}
+	var addr types.Address
+	if err := addr.UnmarshalText([]byte(s)); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+	}
+	return &addr, nil
+}
+
+// ToHeader converts a fixture header to a block header.
+func (h *Header) ToHeader() *block.Header {
+	header := &block.Header{
+		ParentHash:  h.ParentHash,
+		Coinbase:    h.Coinbase,
+		Root:        h.StateRoot,
+		TxHash:      h.TransactionsTrie,
+		ReceiptHash: h.ReceiptTrie,
+		Bloom:       h.Bloom,
+		Difficulty:  h.Difficulty.Int(),
+		Number:      uint256.NewInt(uint64(h.Number)),
+		GasLimit:    uint64(h.GasLimit),
+		GasUsed:     uint64(h.GasUsed),
+		Time:        uint64(h.Timestamp),
+		MixDigest:   h.MixHash,
+		Nonce:       h.Nonce,
+		Extra:       append([]byte{}, h.ExtraData...), // decoded "0x" is empty, not nil
+		BaseFee:     h.BaseFee.Int(),
+	}
+	return header
+}
+
+// NewHeader converts a block header to its fixture form. The hash is
+// taken over the fixture form, which is what Run hashes after decoding.
+func NewHeader(header *block.Header) *Header {
+	h := &Header{
+		ParentHash:       header.ParentHash,
+		Coinbase:         header.Coinbase,
+		StateRoot:        header.Root,
+		TransactionsTrie: header.TxHash,
+		ReceiptTrie:      header.ReceiptHash,
+		Bloom:            header.Bloom,
+		Difficulty:       *NewU256(header.Difficulty),
+		Number:           Uint64(header.Number.Uint64()),
+		GasLimit:         Uint64(header.GasLimit),
+		GasUsed:          Uint64(header.GasUsed),
+		Timestamp:        Uint64(header.Time),
+		ExtraData:        header.Extra,
+		MixHash:          header.MixDigest,
+		Nonce:            header.Nonce,
+		BaseFee:          NewU256(header.BaseFee),
+	}
+	h.Hash = h.ToHeader().Hash()
+	return h
+}
+
+// ToTransaction converts a fixture transaction. The sender is taken from
+// the sender field when present, otherwise it is recovered from the
+// signature with the signer of the given chain configuration.
+func (t *Transaction) ToTransaction(config *params.ChainConfig, number uint64) (*transaction.Transaction, error) {
+	to, err := toAddress(t.To)
+	if err != nil {
+		return nil, err
+	}
+	var chainID *uint256.Int
+	if t.ChainID != nil {
+		chainID = t.ChainID.Int()
+	} else {
+		chainID, _ = uint256.FromBig(config.ChainID)
+	}
+	var txType uint64
+	if t.Type != nil {
+		txType = uint64(*t.Type)
+	}
+
+	var inner transaction.TxData
+	switch txType {
+	case transaction.LegacyTxType:
+		inner = &transaction.LegacyTx{
+			Nonce:    uint64(t.Nonce),
+			GasPrice: t.GasPrice.Int(),
+			Gas:      uint64(t.GasLimit),
+			To:       to,
+			Value:    t.Value.Int(),
+			Data:     t.Data,
+			V:        t.V.Int(),
+			R:        t.R.Int(),
+			S:        t.S.Int(),
+		}
+	case transaction.AccessListTxType:
+		inner = &transaction.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      uint64(t.Nonce),
+			GasPrice:   t.GasPrice.Int(),
+			Gas:        uint64(t.GasLimit),
+			To:         to,
+			Value:      t.Value.Int(),
+			Data:       t.Data,
+			AccessList: t.AccessList,
+			V:          t.V.Int(),
+			R:          t.R.Int(),
+			S:          t.S.Int(),
+		}
+	case transaction.DynamicFeeTxType:
+		inner = &transaction.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      uint64(t.Nonce),
+			GasTipCap:  t.MaxPriorityFeePerGas.Int(),
+			GasFeeCap:  t.MaxFeePerGas.Int(),
+			Gas:        uint64(t.GasLimit),
+			To:         to,
+			Value:      t.Value.Int(),
+			Data:       t.Data,
+			AccessList: t.AccessList,
+			V:          t.V.Int(),
+			R:          t.R.Int(),
+			S:          t.S.Int(),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", txType)
+	}
+	tx := transaction.NewTx(inner)
+
+	if t.Sender != nil {
+		tx.SetFrom(*t.Sender)
+		return tx, nil
+	}
+	if t.V == nil || t.R == nil || t.S == nil {
+		return nil, fmt.Errorf("transaction has neither sender nor signature")
+	}
+	from, err := transaction.Sender(transaction.MakeSigner(config, new(big.Int).SetUint64(number)), tx)
+	if err != nil {
+		return nil, fmt.Errorf("recover sender: %w", err)
+	}
+	tx.SetFrom(from)
+	return tx, nil
+}
+
+// NewTransaction converts a transaction to its fixture form.
+func NewTransaction(tx *transaction.Transaction) Transaction {
+	t := Transaction{
+		Nonce:    Uint64(tx.Nonce()),
+		GasLimit: Uint64(tx.Gas()),
+		Value:    *NewU256(tx.Value()),
+		Data:     tx.Data(),
+		Sender:   tx.From(),
+	}
+	if to := tx.To(); to != nil {
+		t.To = to.Hex()
+	}
+	switch tx.Type() {
+	case transaction.LegacyTxType:
+		t.GasPrice = NewU256(tx.GasPrice())
+	case transaction.AccessListTxType:
+		t.GasPrice = NewU256(tx.GasPrice())
+		t.AccessList = tx.AccessList()
+	case transaction.DynamicFeeTxType:
+		t.MaxFeePerGas = NewU256(tx.GasFeeCap())
+		t.MaxPriorityFeePerGas = NewU256(tx.GasTipCap())
+		t.AccessList = tx.AccessList()
+	}
+	if tx.Type() != transaction.LegacyTxType {
+		txType := Uint64(tx.Type())
+		t.Type = &txType
+		t.ChainID = NewU256(tx.ChainId())
+	}
+	if v, r, s := tx.RawSignatureValues(); v != nil && r != nil && s != nil {
+		t.V, t.R, t.S = NewU256(v), NewU256(r), NewU256(s)
+	}
+	return t
+}
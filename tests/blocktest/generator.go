@@ -0,0 +1,150 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package blocktest
+
+import (
+	"encoding/json"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal/consensus/misc"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+)
+
+// defaultBlockTime is the timestamp step used when a BlockSpec leaves
+// Timestamp unset.
+const defaultBlockTime = 12
+
+// Spec describes the chain a test vector is generated from.
+type Spec struct {
+	Network string
+	Config  *params.ChainConfig // optional, takes precedence over Network
+	Comment string
+
+	Pre       Alloc
+	GasLimit  uint64
+	BaseFee   *uint256.Int
+	Timestamp uint64
+
+	Blocks []BlockSpec
+}
+
+// BlockSpec describes a block on top of the previous one. Zero Timestamp
+// and GasLimit are inherited from the parent, the base fee is derived.
+// Transactions must carry their sender, they are not re-signed.
+type BlockSpec struct {
+	Coinbase     types.Address
+	Timestamp    uint64
+	GasLimit     uint64
+	MixHash      types.Hash
+	Extra        []byte
+	Transactions []*transaction.Transaction
+}
+
+// Generate executes spec on an empty in-memory database and returns the
+// filled test: state and receipt commitments, logs bloom and gas used of
+// every block, and the full post-state. Identical specs produce
+// byte-identical fixtures.
+func Generate(spec *Spec) (*BlockTest, error) {
+	t := &BlockTest{
+		Info:    &Info{FillingTool: FillingTool, Comment: spec.Comment},
+		Network: spec.Network,
+		Config:  spec.Config,
+		Pre:     spec.Pre,
+		Blocks:  make([]Block, 0, len(spec.Blocks)),
+	}
+	config, err := t.chainConfig()
+	if err != nil {
+		return nil, err
+	}
+	e, err := newExecutor(config)
+	if err != nil {
+		return nil, err
+	}
+	defer e.close()
+
+	root, err := e.applyAlloc(spec.Pre)
+	if err != nil {
+		return nil, err
+	}
+	parent := &block.Header{
+		Root:       root,
+		Difficulty: uint256.NewInt(0),
+		Number:     uint256.NewInt(0),
+		GasLimit:   spec.GasLimit,
+		Time:       spec.Timestamp,
+		BaseFee:    spec.BaseFee,
+	}
+	t.Genesis = *NewHeader(parent)
+	e.hashes[0] = t.Genesis.Hash
+	parentHash := t.Genesis.Hash
+
+	for _, b := range spec.Blocks {
+		number := parent.Number.Uint64() + 1
+		header := &block.Header{
+			ParentHash: parentHash,
+			Coinbase:   b.Coinbase,
+			Difficulty: uint256.NewInt(0),
+			Number:     uint256.NewInt(number),
+			GasLimit:   b.GasLimit,
+			Time:       b.Timestamp,
+			MixDigest:  b.MixHash,
+			Extra:      b.Extra,
+		}
+		if header.GasLimit == 0 {
+			header.GasLimit = parent.GasLimit
+		}
+		if header.Time == 0 {
+			header.Time = parent.Time + defaultBlockTime
+		}
+		if config.IsLondon(number) {
+			header.BaseFee, _ = uint256.FromBig(misc.CalcBaseFee(config, parent))
+		}
+
+		res, err := e.execute(header, b.Transactions)
+		if err != nil {
+			return nil, err
+		}
+		header.Root = res.root
+		header.ReceiptHash = res.receiptHash
+		header.TxHash = res.txHash
+		header.Bloom = res.bloom
+		header.GasUsed = res.gasUsed
+		// all fields are final, hash the header
+		fixture := NewHeader(header)
+		e.hashes[number] = fixture.Hash
+
+		txs := make([]Transaction, len(b.Transactions))
+		for i, tx := range b.Transactions {
+			txs[i] = NewTransaction(tx)
+		}
+		t.Blocks = append(t.Blocks, Block{
+			Header:       fixture,
+			Transactions: txs,
+			UncleHeaders: []json.RawMessage{},
+		})
+		parent, parentHash = header, fixture.Hash
+	}
+
+	if t.PostState, err = e.dump(); err != nil {
+		return nil, err
+	}
+	t.LastBlockHash = parentHash
+	return t, nil
+}
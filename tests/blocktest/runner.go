@@ -0,0 +1,167 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package blocktest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+)
+
+// ErrUnsupported is returned for fixtures using features the runner
+// cannot replay: block rewards (pre-merge networks), uncles,
+// withdrawals and blocks expected to be rejected.
+var ErrUnsupported = errors.New("unsupported blockchain test")
+
+// Run replays t and checks the gas used and logs bloom of every block and
+// the resulting post-state.
+//
+// amc commits to state and receipts differently from Ethereum, so state
+// roots, receipt roots, transaction roots and block hashes are only
+// checked for fixtures filled by this package (see FillingTool). For
+// other fixtures the fixture's block hashes are served to BLOCKHASH.
+func Run(t *BlockTest) error {
+	for i, b := range t.Blocks {
+		switch {
+		case b.Header == nil || b.ExpectException != "":
+			return fmt.Errorf("%w: block %d is expected to be invalid", ErrUnsupported, i)
+		case len(b.UncleHeaders) > 0:
+			return fmt.Errorf("%w: block %d has uncles", ErrUnsupported, i)
+		case len(b.Withdrawals) > 0:
+			return fmt.Errorf("%w: block %d has withdrawals", ErrUnsupported, i)
+		}
+	}
+	config, err := t.chainConfig()
+	if err != nil {
+		return err
+	}
+	commitments := t.Info != nil && t.Info.FillingTool == FillingTool
+
+	e, err := newExecutor(config)
+	if err != nil {
+		return err
+	}
+	defer e.close()
+
+	root, err := e.applyAlloc(t.Pre)
+	if err != nil {
+		return err
+	}
+	if commitments && root != t.Genesis.StateRoot {
+		return fmt.Errorf("genesis state root mismatch: have %x, want %x", root, t.Genesis.StateRoot)
+	}
+	e.hashes[0] = t.Genesis.Hash
+
+	for _, b := range t.Blocks {
+		header := b.Header.ToHeader()
+		number := header.Number.Uint64()
+		txs := make([]*transaction.Transaction, len(b.Transactions))
+		for i := range b.Transactions {
+			if txs[i], err = b.Transactions[i].ToTransaction(config, number); err != nil {
+				return fmt.Errorf("block %d tx %d: %w", number, i, err)
+			}
+		}
+
+		res, err := e.execute(header, txs)
+		if err != nil {
+			return err
+		}
+		if res.gasUsed != header.GasUsed {
+			return fmt.Errorf("block %d gas used mismatch: have %d, want %d", number, res.gasUsed, header.GasUsed)
+		}
+		if res.bloom != header.Bloom {
+			return fmt.Errorf("block %d logs bloom mismatch: have %x, want %x", number, res.bloom, header.Bloom)
+		}
+		if commitments {
+			if res.root != header.Root {
+				return fmt.Errorf("block %d state root mismatch: have %x, want %x", number, res.root, header.Root)
+			}
+			if res.receiptHash != header.ReceiptHash {
+				return fmt.Errorf("block %d receipt root mismatch: have %x, want %x", number, res.receiptHash, header.ReceiptHash)
+			}
+			if res.txHash != header.TxHash {
+				return fmt.Errorf("block %d transaction root mismatch: have %x, want %x", number, res.txHash, header.TxHash)
+			}
+			if hash := header.Hash(); hash != b.Header.Hash {
+				return fmt.Errorf("block %d hash mismatch: have %x, want %x", number, hash, b.Header.Hash)
+			}
+		}
+		e.hashes[number] = b.Header.Hash
+	}
+
+	if t.PostState == nil {
+		return nil
+	}
+	post, err := e.dump()
+	if err != nil {
+		return err
+	}
+	return diffAlloc(post, t.PostState)
+}
+
+// diffAlloc reports the first difference between two states, visiting
+// addresses in order so that the report is stable.
+func diffAlloc(have, want Alloc) error {
+	addrs := make(types.Addresses, 0, len(have)+len(want))
+	for addr := range have {
+		addrs = append(addrs, addr)
+	}
+	for addr := range want {
+		if _, ok := have[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Sort(addrs)
+
+	for _, addr := range addrs {
+		h, hok := have[addr]
+		w, wok := want[addr]
+		switch {
+		case !hok:
+			return fmt.Errorf("account %x missing from post-state", addr)
+		case !wok:
+			return fmt.Errorf("unexpected account %x in post-state", addr)
+		}
+		if hb, wb := h.Balance.Int(), w.Balance.Int(); !hb.Eq(wb) {
+			return fmt.Errorf("account %x balance mismatch: have %s, want %s", addr, hb, wb)
+		}
+		if h.Nonce != w.Nonce {
+			return fmt.Errorf("account %x nonce mismatch: have %d, want %d", addr, h.Nonce, w.Nonce)
+		}
+		if !bytes.Equal(h.Code, w.Code) {
+			return fmt.Errorf("account %x code mismatch: have %x, want %x", addr, []byte(h.Code), []byte(w.Code))
+		}
+		for k, v := range w.Storage {
+			if v == (Word{}) {
+				continue
+			}
+			if h.Storage[k] != v {
+				return fmt.Errorf("account %x slot %x mismatch: have %x, want %x", addr, k, h.Storage[k], v)
+			}
+		}
+		for k, v := range h.Storage {
+			if _, ok := w.Storage[k]; !ok {
+				return fmt.Errorf("account %x unexpected slot %x = %x", addr, k, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package blocktest
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/common/account"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/internal"
+	"github.com/amazechain/amc/internal/vm"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/state"
+	"github.com/amazechain/amc/params"
+	"github.com/c2h5oh/datasize"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/mdbx"
+)
+
+// postMerge is the rule set shared by every supported network. Block
+// rewards are not applied by the executor, so only networks without
+// them can be replayed.
+func postMerge() *params.ChainConfig {
+	config := *params.AllEthashProtocolChanges
+	config.ChainID = big.NewInt(1)
+	config.GrayGlacierBlock = big.NewInt(0)
+	config.TerminalTotalDifficulty = big.NewInt(0)
+	config.Ethash = nil
+	return &config
+}
+
+// Networks maps ethereum/tests network names to chain configurations.
+var Networks = map[string]func() *params.ChainConfig{
+	"Merge": postMerge,
+	"Paris": postMerge,
+	"Shanghai": func() *params.ChainConfig {
+		config := postMerge()
+		config.ShanghaiBlock = big.NewInt(0)
+		return config
+	},
+}
+
+// chainConfig resolves the rules a test runs under.
+func (t *BlockTest) chainConfig() (*params.ChainConfig, error) {
+	if t.Config != nil {
+		return t.Config, nil
+	}
+	network, ok := Networks[t.Network]
+	if !ok {
+		return nil, fmt.Errorf("%w: network %q", ErrUnsupported, t.Network)
+	}
+	return network(), nil
+}
+
+// executor runs blocks on top of a throwaway in-memory database.
+type executor struct {
+	config *params.ChainConfig
+	db     kv.RwDB
+	tx     kv.RwTx
+	hashes map[uint64]types.Hash
+}
+
+// blockResult holds the commitments produced by executing a block.
+type blockResult struct {
+	root        types.Hash
+	receiptHash types.Hash
+	txHash      types.Hash
+	bloom       block.Bloom
+	gasUsed     uint64
+	receipts    block.Receipts
+}
+
+func newExecutor(config *params.ChainConfig) (*executor, error) {
+	modules.AmcInit()
+	db, err := mdbx.NewMDBX(nil).InMem("").MapSize(2 * datasize.GB).
+		WithTableCfg(func(kv.TableCfg) kv.TableCfg { return modules.AmcTableCfg }).
+		Open()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginRw(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &executor{
+		config: config,
+		db:     db,
+		tx:     tx,
+		hashes: make(map[uint64]types.Hash),
+	}, nil
+}
+
+func (e *executor) close() {
+	e.tx.Rollback()
+	e.db.Close()
+}
+
+func (e *executor) getHash(n uint64) types.Hash {
+	return e.hashes[n]
+}
+
+// applyAlloc writes the pre-state and returns its state root.
+func (e *executor) applyAlloc(alloc Alloc) (types.Hash, error) {
+	ibs := state.New(state.NewPlainStateReader(e.tx))
+	for addr, a := range alloc {
+		ibs.AddBalance(addr, a.Balance.Int())
+		ibs.SetCode(addr, a.Code)
+		ibs.SetNonce(addr, uint64(a.Nonce))
+		for k, v := range a.Storage {
+			key := types.Hash(k)
+			ibs.SetState(addr, &key, *new(uint256.Int).SetBytes(v[:]))
+		}
+		if len(a.Code) > 0 || len(a.Storage) > 0 {
+			ibs.SetIncarnation(addr, state.FirstContractIncarnation)
+		}
+	}
+	root := ibs.IntermediateRoot()
+	if err := ibs.CommitBlock(e.config.Rules(0), state.NewPlainStateWriterNoHistory(e.tx)); err != nil {
+		return types.Hash{}, err
+	}
+	return root, nil
+}
+
+// execute applies txs on top of the current state under header and
+// commits the result. The commitments are computed the same way the
+// block validator computes them.
+func (e *executor) execute(header *block.Header, txs []*transaction.Transaction) (*blockResult, error) {
+	number := header.Number.Uint64()
+	ibs := state.New(state.NewPlainStateReader(e.tx))
+	gp := new(common.GasPool).AddGas(header.GasLimit)
+	coinbase := header.Coinbase
+
+	var (
+		usedGas  uint64
+		receipts block.Receipts
+	)
+	for i, tx := range txs {
+		ibs.Prepare(tx.Hash(), types.Hash{}, i)
+		receipt, _, err := internal.ApplyTransaction(e.config, e.getHash, nil, &coinbase, gp, ibs, state.NewNoopWriter(), header, tx, &usedGas, vm.Config{})
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d from block %d: %w", i, number, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	res := &blockResult{
+		root:        ibs.IntermediateRoot(),
+		receiptHash: internal.DeriveSha(receipts),
+		txHash:      internal.DeriveSha(transaction.Transactions(txs)),
+		bloom:       block.CreateBloom(receipts),
+		gasUsed:     usedGas,
+		receipts:    receipts,
+	}
+	if err := ibs.CommitBlock(e.config.Rules(number), state.NewPlainStateWriterNoHistory(e.tx)); err != nil {
+		return nil, fmt.Errorf("committing block %d failed: %w", number, err)
+	}
+	return res, nil
+}
+
+// dump reads back the complete world state.
+func (e *executor) dump() (Alloc, error) {
+	alloc := make(Alloc)
+	reader := state.NewPlainStateReader(e.tx)
+	err := e.tx.ForEach(modules.Account, nil, func(k, v []byte) error {
+		var acc account.StateAccount
+		if err := acc.DecodeForStorage(v); err != nil {
+			return fmt.Errorf("decode account %x: %w", k, err)
+		}
+		addr := types.BytesToAddress(k)
+		code, err := reader.ReadAccountCode(addr, acc.Incarnation, acc.CodeHash)
+		if err != nil {
+			return err
+		}
+		a := Account{
+			Balance: U256(acc.Balance),
+			Nonce:   Uint64(acc.Nonce),
+			Code:    code,
+			Storage: make(map[Word]Word),
+		}
+		prefix := modules.PlainGenerateStoragePrefix(addr[:], acc.Incarnation)
+		if err := e.tx.ForPrefix(modules.Storage, prefix, func(k, v []byte) error {
+			_, _, key := modules.PlainParseCompositeStorageKey(k)
+			if len(v) > 0 {
+				a.Storage[Word(key)] = Word(new(uint256.Int).SetBytes(v).Bytes32())
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		alloc[addr] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return alloc, nil
+}
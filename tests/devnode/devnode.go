@@ -0,0 +1,254 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package devnode runs a single signer APoa chain in process on an in-memory
+// database, with the node's blockchain, transaction pool and RPC services, for
+// tests that need a node with a few real blocks behind it.
+package devnode
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/amazechain/amc/accounts"
+	"github.com/amazechain/amc/common"
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/conf"
+	"github.com/amazechain/amc/internal"
+	"github.com/amazechain/amc/internal/api"
+	"github.com/amazechain/amc/internal/consensus"
+	"github.com/amazechain/amc/internal/consensus/apoa"
+	"github.com/amazechain/amc/internal/consensus/misc"
+	"github.com/amazechain/amc/internal/txspool"
+	"github.com/amazechain/amc/internal/vm"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/modules/rpc/jsonrpc"
+	"github.com/amazechain/amc/modules/state"
+	"github.com/amazechain/amc/params"
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+)
+
+// Key signs the blocks of every dev node and owns its funded account.
+var (
+	Key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	Address = crypto.PubkeyToAddress(Key.PublicKey)
+)
+
+// Config is the chain configuration of a dev node, every fork up to London is
+// active from genesis.
+var Config = &params.ChainConfig{
+	ChainID:               big.NewInt(1337),
+	Consensus:             params.CliqueConsensus,
+	HomesteadBlock:        big.NewInt(0),
+	TangerineWhistleBlock: big.NewInt(0),
+	SpuriousDragonBlock:   big.NewInt(0),
+	ByzantiumBlock:        big.NewInt(0),
+	ConstantinopleBlock:   big.NewInt(0),
+	PetersburgBlock:       big.NewInt(0),
+	IstanbulBlock:         big.NewInt(0),
+	MuirGlacierBlock:      big.NewInt(0),
+	BerlinBlock:           big.NewInt(0),
+	LondonBlock:           big.NewInt(0),
+}
+
+// Node is an in-process node. Blocks are only produced by Mine, there is no
+// miner, p2p service or downloader.
+type Node struct {
+	DB    kv.RwDB
+	Chain *internal.BlockChain
+	API   *api.API
+
+	engine consensus.Engine
+	pool   *txspool.TxsPool
+	server *jsonrpc.Server
+	cancel context.CancelFunc
+}
+
+// New boots a node with Address funded in its genesis block. The node is
+// stopped when tb finishes.
+func New(tb testing.TB) *Node {
+	modules.AmcInit()
+	kv.ChaindataTablesCfg = modules.AmcTableCfg
+	db := memdb.NewTestDB(tb)
+
+	genesisCfg := &conf.GenesisBlockConfig{
+		Config:   Config,
+		GasLimit: 30_000_000,
+		Engine: &conf.ConsensusConfig{
+			EngineName: "APoaEngine",
+			Period:     0,
+			GasCeil:    30_000_000,
+			APoa:       &conf.APoaConfig{Epoch: 30000, CheckpointInterval: 1024, InmemorySnapshots: 128, InmemorySignatures: 4096, InMemory: true},
+		},
+		Miners: []string{"AMC" + fmt.Sprintf("%x", Address[:])},
+		Alloc:  []conf.Allocate{{Address: "AMC" + fmt.Sprintf("%x", Address[:]), Balance: "1000000000000000000000000"}},
+	}
+	var genesis *block.Block
+	err := db.Update(context.Background(), func(tx kv.RwTx) error {
+		var err error
+		genesis, _, err = (&internal.GenesisBlock{GenesisBlockConfig: genesisCfg}).Write(tx)
+		return err
+	})
+	if err != nil {
+		tb.Fatalf("write genesis: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	engine := apoa.New(genesisCfg.Engine, db)
+	engine.(*apoa.Apoa).Authorize(Address, func(_ accounts.Account, _ string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), Key)
+	})
+	bc, err := internal.NewBlockChain(ctx, genesis, engine, nil, db, nil, Config)
+	if err != nil {
+		cancel()
+		tb.Fatalf("new blockchain: %v", err)
+	}
+	pool, err := txspool.NewTxsPool(ctx, bc)
+	if err != nil {
+		cancel()
+		tb.Fatalf("new txs pool: %v", err)
+	}
+
+	n := &Node{
+		DB:     db,
+		Chain:  bc.(*internal.BlockChain),
+		engine: engine,
+		pool:   pool.(*txspool.TxsPool),
+		server: jsonrpc.NewServer(),
+		cancel: cancel,
+	}
+	n.API = api.NewAPI(nil, nil, nil, bc, db, engine, pool, nil, accounts.NewManager(&accounts.Config{}), Config)
+	n.API.SetGpo(api.NewOracle(bc, nil, Config, conf.FullNodeGPO))
+	for _, service := range n.API.Apis() {
+		if err := n.server.RegisterName(service.Namespace, service.Service); err != nil {
+			cancel()
+			tb.Fatalf("register %s: %v", service.Namespace, err)
+		}
+	}
+	tb.Cleanup(n.close)
+	return n
+}
+
+func (n *Node) close() {
+	n.server.Stop()
+	n.pool.Stop()
+	n.cancel()
+}
+
+// Client returns an in-process RPC client of the node.
+func (n *Node) Client() *jsonrpc.Client {
+	return jsonrpc.DialInProc(n.server)
+}
+
+// Sign signs inner with Key for the dev chain.
+func Sign(inner transaction.TxData) (*transaction.Transaction, error) {
+	tx, err := transaction.SignNewTx(Key, transaction.LatestSignerForChainID(Config.ChainID), inner)
+	if err != nil {
+		return nil, err
+	}
+	tx.SetFrom(Address)
+	return tx, nil
+}
+
+// Nonce returns the nonce of addr at the head of the chain.
+func (n *Node) Nonce(addr types.Address) (uint64, error) {
+	tx, err := n.DB.BeginRo(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	return state.New(state.NewPlainStateReader(tx)).GetNonce(addr), nil
+}
+
+// Mine seals a block with txs on top of the current head and imports it the
+// way a block from the network is imported. Each transaction must succeed,
+// and APoa does not seal empty blocks on a dev chain.
+func (n *Node) Mine(txs ...*transaction.Transaction) (block.IBlock, error) {
+	parent := n.Chain.CurrentBlock().Header().(*block.Header)
+	header := &block.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(uint256.Int).AddUint64(parent.Number, 1),
+		GasLimit:   parent.GasLimit,
+		Difficulty: uint256.NewInt(0),
+		BaseFee:    uint256.NewInt(0),
+	}
+	header.BaseFee, _ = uint256.FromBig(misc.CalcBaseFee(Config, parent))
+	if err := n.engine.Prepare(n.Chain, header); err != nil {
+		return nil, err
+	}
+
+	sealing, err := n.assemble(header, txs)
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan block.IBlock, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := n.engine.Seal(n.Chain, sealing, results, stop); err != nil {
+		return nil, err
+	}
+	var sealed block.IBlock
+	select {
+	case sealed = <-results:
+	case <-time.After(5 * time.Second):
+		return nil, fmt.Errorf("block %d was not sealed", header.Number.Uint64())
+	}
+	if _, err := n.Chain.InsertChain([]block.IBlock{sealed}); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// assemble executes txs on the head state under header, see the miner's worker.
+func (n *Node) assemble(header *block.Header, txs []*transaction.Transaction) (block.IBlock, error) {
+	tx, err := n.DB.BeginRo(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	getHeader := func(hash types.Hash, number uint64) *block.Header {
+		return rawdb.ReadHeader(tx, hash, number)
+	}
+	var (
+		ibs      = state.New(state.NewPlainStateReader(tx))
+		gp       = new(common.GasPool).AddGas(header.GasLimit)
+		coinbase = Address // the fee recipient is the signer, as for the miner's etherbase
+		noop     = state.NewNoopWriter()
+		receipts []*block.Receipt
+	)
+	for i, txn := range txs {
+		ibs.Prepare(txn.Hash(), types.Hash{}, i)
+		receipt, _, err := internal.ApplyTransaction(Config, internal.GetHashFn(header, getHeader), n.engine, &coinbase, gp, ibs, noop, header, txn, &header.GasUsed, vm.Config{})
+		if err != nil {
+			return nil, fmt.Errorf("block %d tx %d: %w", header.Number.Uint64(), i, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	if err := ibs.CommitBlock(Config.Rules(header.Number.Uint64()), noop); err != nil {
+		return nil, err
+	}
+	return n.engine.FinalizeAndAssemble(n.Chain, header, ibs, txs, nil, receipts, nil)
+}
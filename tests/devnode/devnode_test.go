@@ -0,0 +1,57 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package devnode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/common/hexutil"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/holiman/uint256"
+)
+
+func TestMine(t *testing.T) {
+	n := New(t)
+	to := types.Address{0xbb}
+	for i := uint64(0); i < 2; i++ {
+		tx, err := Sign(&transaction.DynamicFeeTx{
+			ChainID: uint256.NewInt(Config.ChainID.Uint64()), Nonce: i, GasTipCap: uint256.NewInt(1), GasFeeCap: uint256.NewInt(1e10),
+			Gas: 21000, To: &to, Value: uint256.NewInt(7),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n.Mine(tx); err != nil {
+			t.Fatalf("mine %d: %v", i+1, err)
+		}
+	}
+	if head := n.Chain.CurrentBlock().Number64().Uint64(); head != 2 {
+		t.Fatalf("head = %d, want 2", head)
+	}
+
+	client := n.Client()
+	defer client.Close()
+	var balance hexutil.Big
+	if err := client.CallContext(context.Background(), &balance, "eth_getBalance", to, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if balance.ToInt().Uint64() != 14 {
+		t.Fatalf("balance = %s, want 14", balance.ToInt())
+	}
+}
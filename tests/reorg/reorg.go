@@ -0,0 +1,103 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package reorg simulates a network of two in-process dev nodes which can be
+// partitioned and healed, so that each side builds its own branch and one of
+// them reorganises onto the other's when they meet again.
+package reorg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/modules/ethdb/dbdiff"
+	"github.com/amazechain/amc/tests/devnode"
+)
+
+// Network connects two dev nodes. While it is connected a block mined on one
+// node is imported by the other right away, while it is partitioned the blocks
+// stay on their side until Heal.
+type Network struct {
+	Nodes [2]*devnode.Node
+
+	partitioned bool
+	pending     [2][]block.IBlock // blocks mined on each side since Partition
+}
+
+// New starts two nodes on the same genesis block.
+func New(tb testing.TB) *Network {
+	return &Network{Nodes: [2]*devnode.Node{devnode.New(tb), devnode.New(tb)}}
+}
+
+// Mine seals a block with txs on node i and delivers it to the other node,
+// unless the network is partitioned.
+func (n *Network) Mine(i int, txs ...*transaction.Transaction) (block.IBlock, error) {
+	b, err := n.Nodes[i].Mine(txs...)
+	if err != nil {
+		return nil, fmt.Errorf("node %d: %w", i, err)
+	}
+	if n.partitioned {
+		n.pending[i] = append(n.pending[i], b)
+		return b, nil
+	}
+	if _, err := n.Nodes[1-i].Chain.InsertChain([]block.IBlock{b}); err != nil {
+		return nil, fmt.Errorf("node %d: import block %d: %w", 1-i, b.Number64().Uint64(), err)
+	}
+	return b, nil
+}
+
+// Partition cuts the nodes off from each other.
+func (n *Network) Partition() {
+	n.partitioned = true
+}
+
+// Heal reconnects the nodes, each of them imports the branch the other built
+// while they were partitioned.
+func (n *Network) Heal() error {
+	n.partitioned = false
+	for i, blocks := range n.pending {
+		if len(blocks) == 0 {
+			continue
+		}
+		if _, err := n.Nodes[1-i].Chain.InsertChain(blocks); err != nil {
+			return fmt.Errorf("node %d: import the branch of node %d: %w", 1-i, i, err)
+		}
+	}
+	n.pending = [2][]block.IBlock{}
+	return nil
+}
+
+// Converged checks that both nodes have the same head and hold the same records
+// in tables, see dbdiff.CanonicalTables. It returns the first divergence.
+func (n *Network) Converged(ctx context.Context, tables []string) (*dbdiff.Divergence, error) {
+	if a, b := n.Nodes[0].Chain.CurrentBlock(), n.Nodes[1].Chain.CurrentBlock(); a.Hash() != b.Hash() {
+		return nil, fmt.Errorf("heads differ: node 0 at %d %x, node 1 at %d %x", a.Number64().Uint64(), a.Hash(), b.Number64().Uint64(), b.Hash())
+	}
+	a, err := n.Nodes[0].DB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Rollback()
+	b, err := n.Nodes[1].DB.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Rollback()
+	return dbdiff.Tables(ctx, a, b, tables)
+}
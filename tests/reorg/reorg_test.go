@@ -0,0 +1,193 @@
+// Copyright 2022 The AmazeChain Authors
+// This file is part of the AmazeChain library.
+//
+// The AmazeChain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The AmazeChain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the AmazeChain library. If not, see <http://www.gnu.org/licenses/>.
+
+package reorg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amazechain/amc/common/block"
+	"github.com/amazechain/amc/common/crypto"
+	"github.com/amazechain/amc/common/transaction"
+	"github.com/amazechain/amc/common/types"
+	"github.com/amazechain/amc/modules"
+	"github.com/amazechain/amc/modules/ethdb/dbdiff"
+	"github.com/amazechain/amc/modules/rawdb"
+	"github.com/amazechain/amc/params"
+	"github.com/amazechain/amc/tests/devnode"
+	"github.com/holiman/uint256"
+)
+
+// storeCode is the runtime code of the contract the tests deploy, it stores the second
+// calldata word under the first one and emits LOG1 with the first word as topic and the
+// second one as data.
+var storeCode = []byte{
+	0x60, 0x20, 0x35, 0x60, 0x00, 0x35, 0x55, // SSTORE(CALLDATALOAD(0), CALLDATALOAD(32))
+	0x60, 0x20, 0x35, 0x60, 0x00, 0x52, // MSTORE(0, CALLDATALOAD(32))
+	0x60, 0x00, 0x35, 0x60, 0x20, 0x60, 0x00, 0xa1, // LOG1(0, 32, CALLDATALOAD(0))
+	0x00,
+}
+
+// sender signs the transactions of one side of the network, nonces follow its node's head.
+type sender struct {
+	t     *testing.T
+	nonce uint64
+}
+
+func newSender(t *testing.T, n *devnode.Node) *sender {
+	nonce, err := n.Nonce(devnode.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sender{t: t, nonce: nonce}
+}
+
+func (s *sender) sign(to *types.Address, value uint64, gas uint64, data []byte) *transaction.Transaction {
+	s.t.Helper()
+	tx, err := devnode.Sign(&transaction.LegacyTx{Nonce: s.nonce, GasPrice: uint256.NewInt(100 * params.GWei), Gas: gas, To: to, Value: uint256.NewInt(value), Data: data})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.nonce++
+	return tx
+}
+
+func (s *sender) transfer(to byte) *transaction.Transaction {
+	return s.sign(&types.Address{to}, 1, 21_000, nil)
+}
+
+func (s *sender) deploy() *transaction.Transaction {
+	// CODECOPY(0, 12, len) RETURN(0, len), followed by the runtime code
+	initCode := append([]byte{0x60, byte(len(storeCode)), 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, byte(len(storeCode)), 0x60, 0x00, 0xf3}, storeCode...)
+	return s.sign(nil, 0, 200_000, initCode)
+}
+
+func (s *sender) store(contract types.Address, key, value byte) *transaction.Transaction {
+	data := make([]byte, 64)
+	data[31], data[63] = key, value
+	return s.sign(&contract, 0, 100_000, data)
+}
+
+// TestPartitionHeal splits the network after a common prefix, lets node 0 build a
+// shorter branch than node 1 and heals it. Node 0 reorganises onto the branch of
+// node 1: both nodes must hold the same canonical tables, and the transactions of
+// the blocks node 0 unwound must be in its NonCanonicalTxs.
+func TestPartitionHeal(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// blocks of each side while partitioned, node 1 builds the longer branch
+		branches func(t *testing.T, n *Network, contract types.Address) [2][][]*transaction.Transaction
+	}{
+		{"one block against two", func(t *testing.T, n *Network, contract types.Address) [2][][]*transaction.Transaction {
+			s0, s1 := newSender(t, n.Nodes[0]), newSender(t, n.Nodes[1])
+			return [2][][]*transaction.Transaction{
+				{{s0.transfer(0xa1), s0.store(contract, 1, 0xa1)}},
+				{{s1.store(contract, 1, 0xb1)}, {s1.transfer(0xb2), s1.store(contract, 2, 0xb2)}},
+			}
+		}},
+		{"contract created on the losing branch", func(t *testing.T, n *Network, contract types.Address) [2][][]*transaction.Transaction {
+			s0, s1 := newSender(t, n.Nodes[0]), newSender(t, n.Nodes[1])
+			created := crypto.CreateAddress(devnode.Address, s0.nonce)
+			return [2][][]*transaction.Transaction{
+				{{s0.deploy(), s0.transfer(0xa1)}, {s0.store(created, 1, 0xa2), s0.store(contract, 1, 0xa2)}},
+				{{s1.transfer(0xb1)}, {s1.store(contract, 1, 0xb2)}, {s1.transfer(0xa1), s1.store(contract, 3, 0xb3)}},
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := New(t)
+			s := newSender(t, n.Nodes[0])
+			contract := crypto.CreateAddress(devnode.Address, s.nonce)
+			if _, err := n.Mine(0, s.deploy(), s.transfer(0xc1)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := n.Mine(1, newSender(t, n.Nodes[1]).store(contract, 1, 0xc2)); err != nil {
+				t.Fatal(err)
+			}
+
+			n.Partition()
+			var lost []block.IBlock
+			for i, branch := range tc.branches(t, n, contract) {
+				for _, txs := range branch {
+					b, err := n.Mine(i, txs...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if i == 0 {
+						lost = append(lost, b)
+					}
+				}
+			}
+			if err := n.Heal(); err != nil {
+				t.Fatal(err)
+			}
+
+			d, err := n.Converged(context.Background(), dbdiff.CanonicalTables)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != nil {
+				t.Fatalf("nodes diverged at %s", d)
+			}
+			checkNonCanonicalTxs(t, n.Nodes[0], lost)
+			checkNonCanonicalTxs(t, n.Nodes[1], nil)
+		})
+	}
+}
+
+// checkNonCanonicalTxs checks that NonCanonicalTxs of node holds the transactions of
+// the unwound blocks and nothing else.
+func checkNonCanonicalTxs(t *testing.T, node *devnode.Node, unwound []block.IBlock) {
+	t.Helper()
+	tx, err := node.DB.BeginRo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	want := 0
+	for _, b := range unwound {
+		body, err := rawdb.ReadBodyForStorageByKey(tx, modules.BlockBodyKey(b.Number64().Uint64(), b.Hash()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first and the last id of the range are reserved for system transactions
+		txs, err := rawdb.NonCanonicalTransactions(tx, body.BaseTxId+1, body.TxAmount-2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) != len(b.Transactions()) {
+			t.Fatalf("block %d: %d transactions in NonCanonicalTxs, want %d", b.Number64().Uint64(), len(txs), len(b.Transactions()))
+		}
+		for i, txn := range b.Transactions() {
+			if txs[i].Hash() != txn.Hash() {
+				t.Fatalf("block %d tx %d: %x in NonCanonicalTxs, want %x", b.Number64().Uint64(), i, txs[i].Hash(), txn.Hash())
+			}
+		}
+		want += len(txs)
+	}
+	have := 0
+	if err := tx.ForEach(modules.NonCanonicalTxs, nil, func(_, _ []byte) error {
+		have++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if have != want {
+		t.Fatalf("%d records in NonCanonicalTxs, want %d", have, want)
+	}
+}